@@ -15,22 +15,37 @@
 package main
 
 import (
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/containernetworking/cni/libcni"
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/cni/pkg/version"
 )
 
 const (
-	EnvCNIPath = "CNI_PATH"
-	EnvNetDir  = "NETCONFPATH"
+	EnvCNIPath        = "CNI_PATH"
+	EnvNetDir         = "NETCONFPATH"
+	EnvIfName         = "CNI_IFNAME"
+	EnvCNIArgs        = "CNI_ARGS"
+	EnvCapabilityArgs = "CAP_ARGS"
+	EnvOutput         = "CNITOOL_OUTPUT"
+	EnvCacheDir       = "CNI_CACHE_DIR"
 
 	DefaultNetDir = "/etc/cni/net.d"
+	DefaultIfName = "eth0"
 
-	CmdAdd = "add"
-	CmdDel = "del"
+	CmdAdd    = "add"
+	CmdCheck  = "check"
+	CmdDel    = "del"
+	CmdStatus = "status"
 )
 
 func main() {
@@ -43,44 +58,160 @@ func main() {
 	if netdir == "" {
 		netdir = DefaultNetDir
 	}
-	netconf, err := libcni.LoadConf(netdir, os.Args[2])
+	netconf, err := libcni.LoadConfList(netdir, os.Args[2])
 	if err != nil {
 		exit(err)
 	}
 
-	netns := os.Args[3]
+	cacheDir := os.Getenv(EnvCacheDir)
+	if cacheDir == "" {
+		cacheDir = libcni.DefaultCacheDir
+	}
 
 	cninet := &libcni.CNIConfig{
-		Path: strings.Split(os.Getenv(EnvCNIPath), ":"),
+		Path: filepath.SplitList(os.Getenv(EnvCNIPath)),
+		// the plugins run on behalf of the user of cnitool, who may
+		// need them to find tools in PATH
+		InheritEnv: true,
+		// so that check and del get the result of add
+		CacheDir: cacheDir,
 	}
 
-	rt := &libcni.RuntimeConf{
-		ContainerID: "cni",
-		NetNS:       netns,
-		IfName:      "eth0",
+	if os.Args[1] == CmdStatus {
+		if err := cninet.StatusNetworkList(netconf); err != nil {
+			exit(err)
+		}
+		exit(printJSON(status(cninet, netconf)))
+	}
+
+	if len(os.Args) < 4 {
+		usage()
+		return
+	}
+	netns := os.Args[3]
+
+	rt, err := runtimeConf(netns)
+	if err != nil {
+		exit(err)
 	}
 
 	switch os.Args[1] {
 	case CmdAdd:
-		_, err := cninet.AddNetwork(netconf, rt)
-		exit(err)
+		result, err := cninet.AddNetworkList(netconf, rt)
+		if err != nil {
+			exit(err)
+		}
+		if os.Getenv(EnvOutput) == "text" {
+			_, err = fmt.Fprint(os.Stdout, current.NewResultFromLegacy(result).Pretty())
+			exit(err)
+		}
+		exit(printJSON(result))
+	case CmdCheck:
+		// the result of add comes from the cache
+		exit(cninet.CheckNetworkList(netconf, nil, rt))
 	case CmdDel:
-		exit(cninet.DelNetwork(netconf, rt))
+		exit(cninet.DelNetworkList(netconf, rt))
+	default:
+		usage()
 	}
 }
 
+// runtimeConf builds the runtime configuration for netns. The container
+// ID is derived from the netns path, so that DEL and CHECK find what ADD
+// set up.
+func runtimeConf(netns string) (*libcni.RuntimeConf, error) {
+	ifName := os.Getenv(EnvIfName)
+	if ifName == "" {
+		ifName = DefaultIfName
+	}
+
+	rt := &libcni.RuntimeConf{
+		ContainerID: fmt.Sprintf("cnitool-%x", sha512.Sum512([]byte(netns)))[:25],
+		NetNS:       netns,
+		IfName:      ifName,
+	}
+
+	if args := os.Getenv(EnvCNIArgs); args != "" {
+		for _, pair := range strings.Split(args, ";") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid %s pair %q", EnvCNIArgs, pair)
+			}
+			rt.Args = append(rt.Args, [2]string{kv[0], kv[1]})
+		}
+	}
+
+	if capArgs := os.Getenv(EnvCapabilityArgs); capArgs != "" {
+		if err := json.Unmarshal([]byte(capArgs), &rt.CapabilityArgs); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", EnvCapabilityArgs, err)
+		}
+	}
+
+	return rt, nil
+}
+
+// pluginStatus reports whether a plugin of the list is installed and
+// which versions of the spec it supports
+type pluginStatus struct {
+	Type              string   `json:"type"`
+	Path              string   `json:"path,omitempty"`
+	SupportedVersions []string `json:"supportedVersions,omitempty"`
+	Error             string   `json:"error,omitempty"`
+}
+
+func status(cninet *libcni.CNIConfig, netconf *libcni.NetworkConfigList) []pluginStatus {
+	var statuses []pluginStatus
+	for _, net := range netconf.Plugins {
+		s := pluginStatus{Type: net.Network.Type}
+		path, err := invoke.FindInPath(net.Network.Type, cninet.Path)
+		if err == nil {
+			s.Path = path
+			var info version.PluginInfo
+			info, err = invoke.GetVersionInfo(path, nil)
+			if err == nil {
+				s.SupportedVersions = info.SupportedVersions()
+			}
+		}
+		if err != nil {
+			s.Error = err.Error()
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(os.Stdout, "%s\n", data)
+	return err
+}
+
 func usage() {
 	exe := filepath.Base(os.Args[0])
 
-	fmt.Fprintf(os.Stderr, "%s: Add or remove network interfaces from a network namespace\n", exe)
+	fmt.Fprintf(os.Stderr, "%s: Add, check or remove network interfaces from a network namespace\n", exe)
 	fmt.Fprintf(os.Stderr, "  %s %s <net> <netns>\n", exe, CmdAdd)
+	fmt.Fprintf(os.Stderr, "  %s %s <net> <netns>\n", exe, CmdCheck)
 	fmt.Fprintf(os.Stderr, "  %s %s <net> <netns>\n", exe, CmdDel)
+	fmt.Fprintf(os.Stderr, "  %s %s <net>\n", exe, CmdStatus)
 	os.Exit(1)
 }
 
+// exit prints err to stderr, and as a CNI error to stdout: the error
+// reported by the failed plugin, or an internal error if no plugin
+// reported one.
 func exit(err error) {
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
+
+		cniErr := &types.Error{}
+		if !errors.As(err, &cniErr) {
+			cniErr = types.NewError(types.ErrInternal, err.Error(), "")
+		}
+		cniErr.Print()
 		os.Exit(1)
 	}
 	os.Exit(0)
@@ -0,0 +1,52 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration_test
+
+import (
+	"path/filepath"
+
+	"github.com/onsi/gomega/gexec"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+var pluginDirs []string
+
+func TestIntegration(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Integration Suite")
+}
+
+var _ = BeforeSuite(func() {
+	for _, plugin := range []string{
+		"plugins/ipam/host-local",
+		"plugins/main/bridge",
+		"plugins/main/macvlan",
+		"plugins/main/ptp",
+		"plugins/meta/tuning",
+		"plugins/test/noop",
+	} {
+		path, err := gexec.Build("github.com/containernetworking/cni/" + plugin)
+		Expect(err).NotTo(HaveOccurred())
+		pluginDirs = append(pluginDirs, filepath.Dir(path))
+	}
+})
+
+var _ = AfterSuite(func() {
+	gexec.CleanupBuildArtifacts()
+})
@@ -0,0 +1,488 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/libcni"
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/testutils"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/cni/pkg/utils/sysctl"
+
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const containerID = "integration-test"
+
+// skipUnlessSupported skips the test if the kernel cannot create links
+// like link, e.g. because the bridge module is missing
+func skipUnlessSupported(env *testutils.Env, link netlink.Link) {
+	err := env.HostNS.Do(func(ns.NetNS) error {
+		if err := netlink.LinkAdd(link); err != nil {
+			return err
+		}
+		return netlink.LinkDel(link)
+	})
+	if err != nil {
+		Skip(fmt.Sprintf("cannot create %s links: %v", link.Type(), err))
+	}
+}
+
+func addrStrings(addrs []netlink.Addr) []string {
+	strs := []string{}
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			strs = append(strs, addr.IPNet.String())
+		}
+	}
+	return strs
+}
+
+// addr6Strings returns the global IPv6 addresses in addrs
+func addr6Strings(addrs []netlink.Addr) []string {
+	strs := []string{}
+	for _, addr := range addrs {
+		if addr.IP.To4() == nil && !addr.IP.IsLinkLocalUnicast() {
+			strs = append(strs, addr.IPNet.String())
+		}
+	}
+	return strs
+}
+
+// hostVeth returns the only veth in the host namespace
+func hostVeth(env *testutils.Env) netlink.Link {
+	var veth netlink.Link
+	err := env.HostNS.Do(func(ns.NetNS) error {
+		links, err := netlink.LinkList()
+		if err != nil {
+			return err
+		}
+		for _, l := range links {
+			if _, ok := l.(*netlink.Veth); ok {
+				veth = l
+			}
+		}
+		return nil
+	})
+	Expect(err).NotTo(HaveOccurred())
+	Expect(veth).NotTo(BeNil())
+	return veth
+}
+
+// routeDsts returns the destination of each route of a result
+func routeDsts(routes []types.Route) []string {
+	dsts := []string{}
+	for _, r := range routes {
+		dsts = append(dsts, r.Dst.String())
+	}
+	return dsts
+}
+
+func hasRoute(routes []netlink.Route, dst string, gw net.IP) bool {
+	for _, r := range routes {
+		rdst := ""
+		switch {
+		case r.Dst != nil:
+			rdst = r.Dst.String()
+		// netlink reports default routes without a destination
+		case r.Gw.To4() != nil:
+			rdst = "0.0.0.0/0"
+		case r.Gw != nil:
+			rdst = "::/0"
+		}
+		if rdst == dst && r.Gw.Equal(gw) {
+			return true
+		}
+	}
+	return false
+}
+
+var _ = Describe("Running plugins end-to-end", func() {
+	var (
+		env     *testutils.Env
+		netName string
+		// dataDir holds the reservations of host-local, which would
+		// otherwise be kept on the real host
+		dataDir string
+	)
+
+	BeforeEach(func() {
+		var err error
+		env, err = testutils.NewEnv(pluginDirs...)
+		Expect(err).NotTo(HaveOccurred())
+		netName = fmt.Sprintf("cni-integration-%d", GinkgoParallelNode())
+		dataDir, err = ioutil.TempDir("", "cni-integration")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(env.Close()).To(Succeed())
+		Expect(os.RemoveAll(dataDir)).To(Succeed())
+	})
+
+	conf := func(plugin string) []byte {
+		return []byte(fmt.Sprintf(`{
+			"cniVersion": "0.2.0",
+			"name": %q,
+			%s,
+			"ipam": {
+				"type": "host-local",
+				"dataDir": %q,
+				"subnet": "10.1.2.0/24",
+				"routes": [{"dst": "10.9.0.0/16"}]
+			}
+		}`, netName, plugin, dataDir))
+	}
+
+	It("configures and deconfigures a ptp veth pair", func() {
+		ptpConf := conf(`"type": "ptp"`)
+
+		result, err := env.Add(containerID, "eth0", ptpConf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IP4.IP.String()).To(Equal("10.1.2.2/24"))
+		Expect(result.IP4.Gateway.String()).To(Equal("10.1.2.1"))
+
+		cont, err := testutils.Link(env.ContainerNS, "eth0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cont).NotTo(BeNil())
+		Expect(addrStrings(cont.Addrs)).To(ConsistOf("10.1.2.2/24"))
+		Expect(hasRoute(cont.Routes, "10.1.2.1/32", nil)).To(BeTrue())
+		Expect(hasRoute(cont.Routes, "10.1.2.0/24", result.IP4.Gateway)).To(BeTrue())
+		Expect(hasRoute(cont.Routes, "10.9.0.0/16", result.IP4.Gateway)).To(BeTrue())
+
+		veth := hostVeth(env)
+		host, err := testutils.Link(env.HostNS, veth.Attrs().Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(addrStrings(host.Addrs)).To(ConsistOf("10.1.2.1/32"))
+		Expect(hasRoute(host.Routes, "10.1.2.2/32", nil)).To(BeTrue())
+
+		Expect(env.Check(containerID, "eth0", ptpConf, result)).To(Succeed())
+		Expect(env.Del(containerID, "eth0", ptpConf)).To(Succeed())
+
+		cont, err = testutils.Link(env.ContainerNS, "eth0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cont).To(BeNil())
+		host, err = testutils.Link(env.HostNS, veth.Attrs().Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(host).To(BeNil())
+	})
+
+	It("tears down a ptp veth pair whose configuration is gone", func() {
+		result, err := env.Add(containerID, "eth0", conf(`"type": "ptp"`))
+		Expect(err).NotTo(HaveOccurred())
+		lease := filepath.Join(dataDir, netName, result.IP4.IP.IP.String())
+		Expect(lease).To(BeAnExistingFile())
+
+		// all the runtime still knows is which plugin to call
+		Expect(env.Del(containerID, "eth0", []byte(`{"cniVersion": "0.2.0", "type": "ptp"}`))).To(Succeed())
+
+		cont, err := testutils.Link(env.ContainerNS, "eth0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cont).To(BeNil())
+		Expect(lease).NotTo(BeAnExistingFile())
+	})
+
+	It("checks a list of plugins that do not implement CHECK", func() {
+		list, err := libcni.ConfListFromBytes([]byte(fmt.Sprintf(`{
+			"cniVersion": "0.2.0",
+			"name": %q,
+			"plugins": [
+				{
+					"type": "ptp",
+					"ipam": {"type": "noop"},
+					"result": {"ip4": {"ip": "10.1.2.2/24", "gateway": "10.1.2.1"}}
+				},
+				{"type": "tuning", "sysctl": {"net.ipv4.conf.eth0.accept_redirects": "0"}}
+			]
+		}`, netName)))
+		Expect(err).NotTo(HaveOccurred())
+		cniConfig := &libcni.CNIConfig{Path: pluginDirs}
+		rt := &libcni.RuntimeConf{ContainerID: containerID, NetNS: env.ContainerNS.Path(), IfName: "eth0"}
+
+		// the plugin processes start in the namespace of the thread
+		// forking them
+		err = env.HostNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			result, err := cniConfig.AddNetworkList(list, rt)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cniConfig.CheckNetworkList(list, result, rt)).To(Succeed())
+			Expect(cniConfig.DelNetworkList(list, rt)).To(Succeed())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context("with addresses of both families", func() {
+		// the noop plugin stands in for an IPAM plugin returning both
+		// families; it reads its result from the top level of the config
+		dualStackConf := func(plugin string) []byte {
+			return []byte(fmt.Sprintf(`{
+				"cniVersion": "0.2.0",
+				"name": %q,
+				%s,
+				"ipam": {"type": "noop"},
+				"result": {
+					"ip4": {"ip": "10.1.2.2/24", "gateway": "10.1.2.1"},
+					"ip6": {"ip": "fd00:1::2/64", "gateway": "fd00:1::1", "routes": [{"dst": "fd00:9::/48"}]}
+				}
+			}`, netName, plugin))
+		}
+
+		It("configures both families on a ptp veth pair", func() {
+			ptpConf := dualStackConf(`"type": "ptp"`)
+
+			result, err := env.Add(containerID, "eth0", ptpConf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.IP4.IP.String()).To(Equal("10.1.2.2/24"))
+			Expect(result.IP6.IP.String()).To(Equal("fd00:1::2/64"))
+
+			cont, err := testutils.Link(env.ContainerNS, "eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(addrStrings(cont.Addrs)).To(ConsistOf("10.1.2.2/24"))
+			Expect(addr6Strings(cont.Addrs)).To(ConsistOf("fd00:1::2/64"))
+			Expect(hasRoute(cont.Routes, "10.1.2.1/32", nil)).To(BeTrue())
+			Expect(hasRoute(cont.Routes, "10.1.2.0/24", net.ParseIP("10.1.2.1"))).To(BeTrue())
+			Expect(hasRoute(cont.Routes, "fd00:1::1/128", nil)).To(BeTrue())
+			Expect(hasRoute(cont.Routes, "fd00:1::/64", net.ParseIP("fd00:1::1"))).To(BeTrue())
+			Expect(hasRoute(cont.Routes, "fd00:9::/48", net.ParseIP("fd00:1::1"))).To(BeTrue())
+
+			host, err := testutils.Link(env.HostNS, hostVeth(env).Attrs().Name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(addrStrings(host.Addrs)).To(ConsistOf("10.1.2.1/32"))
+			Expect(addr6Strings(host.Addrs)).To(ConsistOf("fd00:1::1/128"))
+			Expect(hasRoute(host.Routes, "10.1.2.2/32", nil)).To(BeTrue())
+			Expect(hasRoute(host.Routes, "fd00:1::2/128", nil)).To(BeTrue())
+
+			Expect(env.Check(containerID, "eth0", ptpConf, result)).To(Succeed())
+			Expect(env.Del(containerID, "eth0", ptpConf)).To(Succeed())
+
+			cont, err = testutils.Link(env.ContainerNS, "eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cont).To(BeNil())
+		})
+
+		It("publishes the ptp addresses on the proxyArpInterface", func() {
+			uplink := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "uplink0"}, PeerName: "uplink1"}
+			Expect(env.HostNS.Do(func(ns.NetNS) error {
+				return netlink.LinkAdd(uplink)
+			})).To(Succeed())
+			ptpConf := dualStackConf(`"type": "ptp", "proxyArp": true, "proxyArpInterface": "uplink0"`)
+
+			_, err := env.Add(containerID, "eth0", ptpConf)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = env.HostNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				Expect(sysctl.Sysctl("net/ipv4/conf/uplink0/proxy_arp")).To(Equal("1"))
+				Expect(sysctl.Sysctl("net/ipv6/conf/uplink0/proxy_ndp")).To(Equal("1"))
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(env.Del(containerID, "eth0", ptpConf)).To(Succeed())
+		})
+
+		It("gives the bridge a gateway address of each family", func() {
+			skipUnlessSupported(env, &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "probe0"}})
+			bridgeConf := dualStackConf(`"type": "bridge", "bridge": "cni-test0", "isDefaultGateway": true`)
+
+			result, err := env.Add(containerID, "eth0", bridgeConf)
+			Expect(err).NotTo(HaveOccurred())
+
+			cont, err := testutils.Link(env.ContainerNS, "eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(addrStrings(cont.Addrs)).To(ConsistOf("10.1.2.2/24"))
+			Expect(addr6Strings(cont.Addrs)).To(ConsistOf("fd00:1::2/64"))
+			Expect(hasRoute(cont.Routes, "0.0.0.0/0", net.ParseIP("10.1.2.1"))).To(BeTrue())
+			Expect(hasRoute(cont.Routes, "::/0", net.ParseIP("fd00:1::1"))).To(BeTrue())
+
+			br, err := testutils.Link(env.HostNS, "cni-test0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(addrStrings(br.Addrs)).To(ConsistOf("10.1.2.1/24"))
+			Expect(addr6Strings(br.Addrs)).To(ConsistOf("fd00:1::1/64"))
+
+			Expect(env.Check(containerID, "eth0", bridgeConf, result)).To(Succeed())
+			Expect(env.Del(containerID, "eth0", bridgeConf)).To(Succeed())
+		})
+
+		It("applies isDefaultGateway to the families it names", func() {
+			skipUnlessSupported(env, &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "probe0"}})
+			bridgeConf := dualStackConf(`"type": "bridge", "bridge": "cni-test0", "isDefaultGateway": {"ipv4": true}`)
+
+			result, err := env.Add(containerID, "eth0", bridgeConf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(routeDsts(result.IP4.Routes)).To(ContainElement("0.0.0.0/0"))
+			Expect(routeDsts(result.IP6.Routes)).NotTo(ContainElement("::/0"))
+
+			cont, err := testutils.Link(env.ContainerNS, "eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hasRoute(cont.Routes, "0.0.0.0/0", net.ParseIP("10.1.2.1"))).To(BeTrue())
+			Expect(hasRoute(cont.Routes, "::/0", net.ParseIP("fd00:1::1"))).To(BeFalse())
+
+			br, err := testutils.Link(env.HostNS, "cni-test0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(addrStrings(br.Addrs)).To(ConsistOf("10.1.2.1/24"))
+			Expect(addr6Strings(br.Addrs)).To(BeEmpty())
+
+			Expect(env.Check(containerID, "eth0", bridgeConf, result)).To(Succeed())
+			Expect(env.Del(containerID, "eth0", bridgeConf)).To(Succeed())
+		})
+	})
+
+	It("allocates IPv6 addresses with host-local", func() {
+		ptpConf := []byte(fmt.Sprintf(`{
+			"cniVersion": "0.2.0",
+			"name": %q,
+			"type": "ptp",
+			"ipam": {"type": "host-local", "dataDir": %q, "subnet": "fd00:2::/64"}
+		}`, netName, dataDir))
+
+		result, err := env.Add(containerID, "eth0", ptpConf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IP4).To(BeNil())
+		Expect(result.IP6.IP.String()).To(Equal("fd00:2::2/64"))
+		Expect(result.IP6.Gateway.String()).To(Equal("fd00:2::1"))
+
+		cont, err := testutils.Link(env.ContainerNS, "eth0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(addr6Strings(cont.Addrs)).To(ConsistOf("fd00:2::2/64"))
+
+		Expect(env.Check(containerID, "eth0", ptpConf, result)).To(Succeed())
+		Expect(env.Del(containerID, "eth0", ptpConf)).To(Succeed())
+	})
+
+	Context("when the interface name is taken", func() {
+		BeforeEach(func() {
+			// the interface of another network, or of the runtime itself
+			Expect(env.ContainerNS.Do(func(ns.NetNS) error {
+				return netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}, PeerName: "peer0"})
+			})).To(Succeed())
+		})
+
+		It("fails with an interface-exists error by default", func() {
+			_, err := env.Add(containerID, "eth0", conf(`"type": "ptp"`))
+			code, ok := types.ErrorCode(err)
+			Expect(ok).To(BeTrue())
+			Expect(code).To(Equal(types.ErrInterfaceExists))
+		})
+
+		generateConf := func(plugin string) []byte {
+			return []byte(fmt.Sprintf(`{
+				"cniVersion": "0.3.0",
+				"name": %q,
+				%s,
+				"ifNameConflict": "generate",
+				"ipam": {"type": "noop"},
+				"result": {"ip4": {"ip": "10.1.3.2/24", "gateway": "10.1.3.1"}}
+			}`, netName, plugin))
+		}
+
+		// addAndDelete adds an attachment asking for eth0 with conf, and
+		// deletes it again with the name the runtime asked for
+		addAndDelete := func(conf []byte) {
+			out, err := env.Run("ADD", containerID, "eth0", conf)
+			Expect(err).NotTo(HaveOccurred())
+			result, err := current.NewResult(out)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.SandboxInterface()).To(Equal("eth1"))
+
+			cont, err := testutils.Link(env.ContainerNS, "eth1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cont).NotTo(BeNil())
+			Expect(addrStrings(cont.Addrs)).To(ConsistOf("10.1.3.2/24"))
+
+			Expect(env.Del(containerID, "eth0", conf)).To(Succeed())
+
+			cont, err = testutils.Link(env.ContainerNS, "eth1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cont).To(BeNil())
+			cont, err = testutils.Link(env.ContainerNS, "eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cont).NotTo(BeNil())
+		}
+
+		It("picks the next free name for ptp, and deletes that one", func() {
+			addAndDelete(generateConf(`"type": "ptp"`))
+		})
+
+		It("picks the next free name for bridge, and deletes that one", func() {
+			skipUnlessSupported(env, &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "probe0"}})
+			addAndDelete(generateConf(`"type": "bridge", "bridge": "cni-test0"`))
+		})
+	})
+
+	It("configures and deconfigures a bridge attachment", func() {
+		skipUnlessSupported(env, &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "probe0"}})
+		bridgeConf := conf(`"type": "bridge", "bridge": "cni-test0", "isGateway": true`)
+
+		result, err := env.Add(containerID, "eth0", bridgeConf)
+		Expect(err).NotTo(HaveOccurred())
+
+		cont, err := testutils.Link(env.ContainerNS, "eth0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cont).NotTo(BeNil())
+		Expect(addrStrings(cont.Addrs)).To(ConsistOf(result.IP4.IP.String()))
+		Expect(hasRoute(cont.Routes, "10.9.0.0/16", result.IP4.Gateway)).To(BeTrue())
+
+		br, err := testutils.Link(env.HostNS, "cni-test0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(br).NotTo(BeNil())
+		Expect(addrStrings(br.Addrs)).To(ConsistOf("10.1.2.1/24"))
+
+		Expect(env.Check(containerID, "eth0", bridgeConf, result)).To(Succeed())
+		Expect(env.Del(containerID, "eth0", bridgeConf)).To(Succeed())
+
+		cont, err = testutils.Link(env.ContainerNS, "eth0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cont).To(BeNil())
+	})
+
+	It("configures and deconfigures a macvlan interface", func() {
+		skipUnlessSupported(env, &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "probe0"}})
+		err := env.HostNS.Do(func(ns.NetNS) error {
+			return netlink.LinkAdd(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "parent0"}})
+		})
+		Expect(err).NotTo(HaveOccurred())
+		macvlanConf := conf(`"type": "macvlan", "master": "parent0"`)
+
+		result, err := env.Add(containerID, "eth0", macvlanConf)
+		Expect(err).NotTo(HaveOccurred())
+
+		cont, err := testutils.Link(env.ContainerNS, "eth0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cont).NotTo(BeNil())
+		Expect(cont.Link.Type()).To(Equal("macvlan"))
+		Expect(addrStrings(cont.Addrs)).To(ConsistOf(result.IP4.IP.String()))
+
+		Expect(env.Check(containerID, "eth0", macvlanConf, result)).To(Succeed())
+		Expect(env.Del(containerID, "eth0", macvlanConf)).To(Succeed())
+
+		cont, err = testutils.Link(env.ContainerNS, "eth0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cont).To(BeNil())
+	})
+})
@@ -15,10 +15,15 @@
 package libcni
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
 	"strings"
 
 	"github.com/containernetworking/cni/pkg/invoke"
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/version"
 )
 
 type RuntimeConf struct {
@@ -26,6 +31,20 @@ type RuntimeConf struct {
 	NetNS       string
 	IfName      string
 	Args        [][2]string
+	// A dictionary of capability-specific data passed by the runtime
+	// to plugins as top-level keys in the 'runtimeConfig' dictionary
+	// of the plugin's stdin data.  libcni will ensure that only keys
+	// in this map which match the capabilities of the plugin are passed
+	// to the plugin
+	CapabilityArgs map[string]interface{}
+	// Context, if set, bounds how long plugin executions wait for the
+	// CNIConfig.Limiter; it does not interrupt plugins once they run
+	Context context.Context
+	// PrevResult is the result of the ADD of the attachment as the
+	// runtime recorded it, e.g. in a checkpoint, in any result version.
+	// DEL and CHECK fall back to it when CNIConfig.CacheDir has no
+	// record of the attachment, e.g. after the cache was lost.
+	PrevResult []byte
 }
 
 type NetworkConfig struct {
@@ -33,35 +52,674 @@ type NetworkConfig struct {
 	Bytes   []byte
 }
 
+type NetworkConfigList struct {
+	Name         string
+	CNIVersion   string
+	DisableCheck bool
+	DisableGC    bool
+	Plugins      []*NetworkConfig
+	Bytes        []byte
+}
+
 type CNI interface {
 	AddNetwork(net *NetworkConfig, rt *RuntimeConf) (*types.Result, error)
 	DelNetwork(net *NetworkConfig, rt *RuntimeConf) error
+
+	CheckNetworkList(net *NetworkConfigList, result *types.Result, rt *RuntimeConf) error
+	GCNetworkList(net *NetworkConfigList, valid []GCAttachment) error
+	StatusNetworkList(net *NetworkConfigList) error
+
+	ValidateNetworkList(net *NetworkConfigList, rt *RuntimeConf) error
+	ValidateNetwork(net *NetworkConfig, rt *RuntimeConf) error
 }
 
 type CNIConfig struct {
 	Path []string
+
+	// DisableRollback leaves the plugins of a list that succeeded in place
+	// when a later plugin fails ADD, instead of running DEL on them.
+	// This is only meant for debugging failed chains.
+	DisableRollback bool
+
+	// Hooks are called around every plugin execution
+	Hooks []Hook
+
+	// Resolver, if set, finds the plugin binaries instead of the Exec,
+	// e.g. to cache lookups or to pin plugins to specific binaries
+	Resolver *invoke.Resolver
+
+	// CacheDir, if set, is where every attachment that was added and not
+	// yet deleted is recorded, see ListAttachments
+	CacheDir string
+
+	// DryRun, if set, makes c resolve the plugins and build and validate
+	// their configuration as usual, but report every execution to DryRun
+	// instead of running it. Hooks are not called and CacheDir is left
+	// alone. Plugins are not asked for the versions they support, and
+	// every ADD pretends to return an empty result.
+	DryRun func(inv *PlannedInvocation)
+
+	// EnvAllowlist names the variables of the environment of this
+	// process that plugins are given besides PATH, which they need to
+	// find tools like iptables, and the CNI_* ones; a name
+	// ending in "*", such as "LC_*", stands for every variable with that
+	// prefix. Nothing else is passed on, so that credentials in the
+	// environment of the runtime do not leak into plugins.
+	EnvAllowlist []string
+
+	// PluginEnvAllowlist adds to EnvAllowlist for the plugins of the
+	// given types, e.g. the proxy variables for a plugin that downloads
+	PluginEnvAllowlist map[string][]string
+
+	// InheritEnv passes the whole environment of this process to every
+	// plugin, ignoring the allowlists
+	InheritEnv bool
+
+	// MaxPluginOutput caps how many bytes plugins run by the default
+	// Exec may write to stdout and to stderr, see invoke.RawExec;
+	// zero selects invoke.DefaultMaxOutput
+	MaxPluginOutput int
+
+	// Limiter, if set, bounds how many plugins run at once; the
+	// VERSION queries validating a configuration are not limited
+	Limiter *Limiter
+
+	// Events, if set, is called with every Event of the attachments c
+	// adds and deletes, and of the plugins it runs. It is called
+	// synchronously, from every goroutine using c, so it must be safe
+	// for concurrent use and should hand events off rather than block.
+	// It is not called by dry runs.
+	Events func(e *Event)
+
+	exec invoke.Exec
+}
+
+// NewCNIConfig returns a CNIConfig that searches path for plugins and runs
+// them through exec. A nil exec selects the default os/exec based
+// implementation.
+func NewCNIConfig(path []string, exec invoke.Exec) *CNIConfig {
+	return &CNIConfig{
+		Path: path,
+		exec: exec,
+	}
+}
+
+// NewCNIConfigWithSockets returns a CNIConfig that sends invocations to
+// plugins running as daemons on a unix socket in socketDir (see
+// skel.PluginServe), and executes the binary of plugins that are not.
+// An empty socketDir selects invoke.DefaultSocketDir.
+func NewCNIConfigWithSockets(path []string, socketDir string) *CNIConfig {
+	return NewCNIConfig(path, &invoke.SocketExec{SocketDir: socketDir})
+}
+
+// AddNetworkList runs ADD for each plugin of the list in order, passing
+// every plugin the result of the previous one as prevResult, and returns the
+// result of the last plugin. If a plugin fails, or ADD fails after some
+// plugins succeeded, DEL is run on the plugins that already succeeded, in
+// reverse order, before the error is returned (unless c.DisableRollback
+// is set). A plugin printing a result that is
+// not well-formed fails with a *PluginError wrapping a
+// *version.InvalidResultError; it is rolled back as well, like a plugin
+// that failed with ErrInterrupted.
+func (c *CNIConfig) AddNetworkList(list *NetworkConfigList, rt *RuntimeConf) (*types.Result, error) {
+	finish := c.track(EventAttachStarted, EventAttachCompleted, list.Name, rt)
+	result, err := c.addNetworkList(list, rt)
+	finish(result, err)
+	return result, err
+}
+
+func (c *CNIConfig) addNetworkList(list *NetworkConfigList, rt *RuntimeConf) (*types.Result, error) {
+	if err := validateRuntimeConf(rt); err != nil {
+		return nil, err
+	}
+	if err := c.validateDryRun(list, rt); err != nil {
+		return nil, err
+	}
+
+	var (
+		prevResult *types.Result
+		resultDNS  []types.DNS
+	)
+	for i, net := range list.Plugins {
+		newConf, err := buildOneConfig(list, net, prevResult, rt)
+		if err != nil {
+			return nil, c.rollbackAfter(err, list, list.Plugins[:i], prevResult, rt)
+		}
+
+		result, err := c.addOne(list.Name, newConf, rt)
+		if err != nil {
+			if pluginErr, ok := err.(*PluginError); ok && !c.DisableRollback {
+				// a plugin printing an invalid result, or interrupted
+				// half way, may still have set things up
+				added := list.Plugins[:i]
+				var invalid *version.InvalidResultError
+				if code, _ := types.ErrorCode(pluginErr.Err); code == types.ErrInterrupted || errors.As(pluginErr.Err, &invalid) {
+					added = list.Plugins[:i+1]
+				}
+				pluginErr.RollbackErr = c.rollback(list, added, prevResult, rt)
+			}
+			return nil, err
+		}
+		prevResult = result
+		resultDNS = append([]types.DNS{result.DNS}, resultDNS...)
+	}
+
+	if prevResult != nil {
+		dns, err := mergeDNS(list.Plugins, resultDNS, rt)
+		if err != nil {
+			return nil, c.rollbackAfter(err, list, list.Plugins, prevResult, rt)
+		}
+		prevResult.DNS = dns
+	}
+
+	if err := c.cacheAdd(list.Name, list.Bytes, prevResult, rt); err != nil {
+		return nil, c.rollbackAfter(err, list, list.Plugins, prevResult, rt)
+	}
+	return prevResult, nil
+}
+
+// rollbackAfter rolls back the given plugins, which succeeded, when ADD
+// failed with err outside of any plugin, and returns err along with the
+// error of the rollback, if any
+func (c *CNIConfig) rollbackAfter(err error, list *NetworkConfigList, plugins []*NetworkConfig, prevResult *types.Result, rt *RuntimeConf) error {
+	if c.DisableRollback || len(plugins) == 0 {
+		return err
+	}
+	if rollbackErr := c.rollback(list, plugins, prevResult, rt); rollbackErr != nil {
+		return fmt.Errorf("%v (rollback failed: %v)", err, rollbackErr)
+	}
+	return err
+}
+
+// rollback runs DEL on the given plugins in reverse order, passing each
+// the last successful result. It tries every plugin and returns the
+// first error encountered. The DELs wait for the Limiter regardless of
+// the context of rt, which may be what failed the ADD.
+func (c *CNIConfig) rollback(list *NetworkConfigList, plugins []*NetworkConfig, prevResult *types.Result, rt *RuntimeConf) error {
+	uncancelled := *rt
+	uncancelled.Context = nil
+	rt = &uncancelled
+
+	var firstErr error
+	for i := len(plugins) - 1; i >= 0; i-- {
+		newConf, err := buildOneConfig(list, plugins[i], prevResult, rt)
+		if err == nil {
+			err = c.delOne(list.Name, newConf, rt)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// DelNetworkList runs DEL for each plugin of the list in reverse order,
+// passing the result of the ADD, if known, as prevResult; see PrevResult.
+// With a CacheDir, the plugins get the CNI_ARGS and capability arguments
+// of the ADD, in place of those of rt.
+func (c *CNIConfig) DelNetworkList(list *NetworkConfigList, rt *RuntimeConf) error {
+	finish := c.track(EventTeardownStarted, EventTeardownCompleted, list.Name, rt)
+	err := c.delNetworkList(list, rt)
+	finish(nil, err)
+	return err
+}
+
+func (c *CNIConfig) delNetworkList(list *NetworkConfigList, rt *RuntimeConf) error {
+	if err := validateRuntimeConf(rt); err != nil {
+		return err
+	}
+	rt, err := c.replayArgs(list.Name, rt)
+	if err != nil {
+		return err
+	}
+	if err := c.validateDryRun(list, rt); err != nil {
+		return err
+	}
+	prevResult, err := c.PrevResult(list.Name, rt)
+	if err != nil {
+		return err
+	}
+
+	for i := len(list.Plugins) - 1; i >= 0; i-- {
+		newConf, err := buildOneConfig(list, list.Plugins[i], prevResult, rt)
+		if err != nil {
+			return err
+		}
+
+		if err := c.delOne(list.Name, newConf, rt); err != nil {
+			return err
+		}
+	}
+
+	return c.cacheDel(list.Name, rt)
+}
+
+// CheckNetworkList runs CHECK for each plugin of the list in order,
+// passing every plugin result, the result of the ADD being checked, as
+// prevResult. A nil result selects the one of PrevResult. Like DEL, it
+// replays the CNI_ARGS and capability arguments of the ADD. It succeeds
+// without running any plugin if the list sets disableCheck.
+func (c *CNIConfig) CheckNetworkList(list *NetworkConfigList, result *types.Result, rt *RuntimeConf) error {
+	if list.DisableCheck {
+		return nil
+	}
+	if err := validateRuntimeConf(rt); err != nil {
+		return err
+	}
+	rt, err := c.replayArgs(list.Name, rt)
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		if result, err = c.PrevResult(list.Name, rt); err != nil {
+			return err
+		}
+	}
+	if result == nil {
+		return fmt.Errorf("network %q: CHECK requires the result of ADD", list.Name)
+	}
+	if err := c.validateDryRun(list, rt); err != nil {
+		return err
+	}
+
+	for _, net := range list.Plugins {
+		newConf, err := buildOneConfig(list, net, result, rt)
+		if err != nil {
+			return err
+		}
+
+		if err := c.checkOne(list.Name, newConf, rt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GCAttachment identifies an attachment of a network that is still in
+// use, whose resources GC must leave alone
+type GCAttachment struct {
+	ContainerID string `json:"containerID"`
+	IfName      string `json:"ifname"`
+}
+
+// GCNetworkList runs GC for each plugin of the list, passing the
+// attachments that are still valid as "cni.dev/valid-attachments", so
+// that plugins can release whatever belongs to any other attachment.
+// Every plugin is run even if one fails, and the first error is
+// returned. Once all of them succeed, the cached attachments of the
+// network that are not valid are removed. It succeeds without running
+// any plugin if the list sets disableGC.
+func (c *CNIConfig) GCNetworkList(list *NetworkConfigList, valid []GCAttachment) error {
+	if list.DisableGC {
+		return nil
+	}
+	if valid == nil {
+		valid = []GCAttachment{}
+	}
+	if err := c.validateDryRun(list, nil); err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, net := range list.Plugins {
+		newConf, err := buildOneConfig(list, net, nil, nil)
+		if err == nil {
+			newConf, err = InjectConf(newConf, map[string]interface{}{"cni.dev/valid-attachments": valid})
+		}
+		if err == nil {
+			err = c.gcOne(list.Name, newConf)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	return c.cacheGC(list.Name, valid)
+}
+
+// StatusNetworkList runs STATUS for each plugin of the list in order, and
+// returns the error of the first plugin that is not ready, whose code is
+// types.ErrNotReady unless it failed otherwise. Runtimes should not add
+// containers to the network until it succeeds.
+func (c *CNIConfig) StatusNetworkList(list *NetworkConfigList) error {
+	if err := c.validateDryRun(list, nil); err != nil {
+		return err
+	}
+
+	for _, net := range list.Plugins {
+		newConf, err := buildOneConfig(list, net, nil, nil)
+		if err != nil {
+			return err
+		}
+		if err := c.statusOne(list.Name, newConf); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (c *CNIConfig) AddNetwork(net *NetworkConfig, rt *RuntimeConf) (*types.Result, error) {
-	pluginPath, err := invoke.FindInPath(net.Network.Type, c.Path)
+	finish := c.track(EventAttachStarted, EventAttachCompleted, net.Network.Name, rt)
+	result, err := c.addNetwork(net, rt)
+	finish(result, err)
+	return result, err
+}
+
+func (c *CNIConfig) addNetwork(net *NetworkConfig, rt *RuntimeConf) (*types.Result, error) {
+	if err := validateRuntimeConf(rt); err != nil {
+		return nil, err
+	}
+
+	if c.DryRun != nil {
+		if err := c.ValidateNetwork(net, rt); err != nil {
+			return nil, err
+		}
+	}
+
+	net, err := injectRuntimeConfig(net, rt)
 	if err != nil {
 		return nil, err
 	}
 
-	return invoke.ExecPluginWithResult(pluginPath, net.Bytes, c.args("ADD", rt))
+	result, err := c.addOne(net.Network.Name, net, rt)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.DNS, err = mergeDNS([]*NetworkConfig{net}, []types.DNS{result.DNS}, rt); err != nil {
+		return nil, err
+	}
+
+	if err := c.cacheAdd(net.Network.Name, net.Bytes, result, rt); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
 func (c *CNIConfig) DelNetwork(net *NetworkConfig, rt *RuntimeConf) error {
-	pluginPath, err := invoke.FindInPath(net.Network.Type, c.Path)
+	finish := c.track(EventTeardownStarted, EventTeardownCompleted, net.Network.Name, rt)
+	err := c.delNetwork(net, rt)
+	finish(nil, err)
+	return err
+}
+
+func (c *CNIConfig) delNetwork(net *NetworkConfig, rt *RuntimeConf) error {
+	if err := validateRuntimeConf(rt); err != nil {
+		return err
+	}
+	rt, err := c.replayArgs(net.Network.Name, rt)
 	if err != nil {
 		return err
 	}
 
-	return invoke.ExecPluginWithoutResult(pluginPath, net.Bytes, c.args("DEL", rt))
+	if c.DryRun != nil {
+		if err := c.ValidateNetwork(net, rt); err != nil {
+			return err
+		}
+	}
+
+	net, err = injectRuntimeConfig(net, rt)
+	if err != nil {
+		return err
+	}
+
+	if err := c.delOne(net.Network.Name, net, rt); err != nil {
+		return err
+	}
+
+	return c.cacheDel(net.Network.Name, rt)
+}
+
+// ValidateNetworkList checks that a network configuration list is well
+// formed and can be run by this CNIConfig, without invoking any plugin for
+// ADD or DEL: the name and cniVersion must be valid, every plugin must be
+// found in c.Path and support the list's cniVersion, and every capability
+// in rt.CapabilityArgs must be declared by at least one plugin. rt may
+// be nil if the runtime does not pass capability arguments.
+func (c *CNIConfig) ValidateNetworkList(list *NetworkConfigList, rt *RuntimeConf) error {
+	if err := types.ValidateNetworkName(list.Name); err != nil {
+		return err
+	}
+	if err := validateVersion(list.CNIVersion); err != nil {
+		return err
+	}
+	if len(list.Plugins) == 0 {
+		return fmt.Errorf("network %q has no plugins", list.Name)
+	}
+
+	for _, net := range list.Plugins {
+		if err := c.validatePlugin(net.Network.Type, list.CNIVersion); err != nil {
+			return err
+		}
+	}
+
+	return validateCapabilities(list.Name, list.Plugins, rt)
+}
+
+// ValidateNetwork checks a single network configuration the same way
+// ValidateNetworkList checks a list.
+func (c *CNIConfig) ValidateNetwork(net *NetworkConfig, rt *RuntimeConf) error {
+	if err := types.ValidateNetworkName(net.Network.Name); err != nil {
+		return err
+	}
+	if err := validateVersion(net.Network.CNIVersion); err != nil {
+		return err
+	}
+	if err := c.validatePlugin(net.Network.Type, net.Network.CNIVersion); err != nil {
+		return err
+	}
+
+	return validateCapabilities(net.Network.Name, []*NetworkConfig{net}, rt)
+}
+
+// AboutPlugin asks the plugin of the given type to describe itself, e.g.
+// for a configuration editor to offer the fields it reads. Like the
+// VERSION queries of validation, it is not limited by c.Limiter, and it
+// runs in dry runs too, as it changes nothing.
+func (c *CNIConfig) AboutPlugin(pluginType string) (*types.About, error) {
+	if pluginType == "" {
+		return nil, fmt.Errorf("plugin type missing")
+	}
+
+	pluginPath, err := c.findPlugin(pluginType)
+	if err != nil {
+		return nil, err
+	}
+
+	var about *types.About
+	inv := newInvocation("ABOUT", "", pluginType, pluginPath, nil)
+	err = c.runHooked(inv, func() error {
+		var err error
+		about, err = invoke.GetAboutInheriting(pluginPath, c.inherit(pluginType), c.ensureExec())
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the description of plugin %q: %v", pluginType, err)
+	}
+	return about, nil
 }
 
 // =====
-func (c *CNIConfig) args(action string, rt *RuntimeConf) *invoke.Args {
+func (c *CNIConfig) addOne(network string, net *NetworkConfig, rt *RuntimeConf) (*types.Result, error) {
+	pluginPath, err := c.findPlugin(net.Network.Type)
+	if err != nil {
+		return nil, newPluginError(network, net, "", "ADD", err)
+	}
+
+	var result *types.Result
+	inv := newInvocation("ADD", network, net.Network.Type, pluginPath, rt)
+	if c.dryRun(inv, net.Bytes, c.args("ADD", net.Network.Type, rt)) {
+		return &types.Result{}, nil
+	}
+	err = c.runLimited(inv, rt, func() error {
+		var err error
+		result, err = invoke.ExecPluginWithResult(pluginPath, net.Bytes, c.args("ADD", net.Network.Type, rt), c.ensureExec())
+		return err
+	})
+	if err != nil {
+		return nil, newPluginError(network, net, pluginPath, "ADD", err)
+	}
+
+	return result, nil
+}
+
+func (c *CNIConfig) delOne(network string, net *NetworkConfig, rt *RuntimeConf) error {
+	pluginPath, err := c.findPlugin(net.Network.Type)
+	if err != nil {
+		return newPluginError(network, net, "", "DEL", err)
+	}
+
+	inv := newInvocation("DEL", network, net.Network.Type, pluginPath, rt)
+	if c.dryRun(inv, net.Bytes, c.args("DEL", net.Network.Type, rt)) {
+		return nil
+	}
+	err = c.runLimited(inv, rt, func() error {
+		return invoke.ExecPluginWithoutResult(pluginPath, net.Bytes, c.args("DEL", net.Network.Type, rt), c.ensureExec())
+	})
+	if err != nil {
+		return newPluginError(network, net, pluginPath, "DEL", err)
+	}
+
+	return nil
+}
+
+func (c *CNIConfig) checkOne(network string, net *NetworkConfig, rt *RuntimeConf) error {
+	pluginPath, err := c.findPlugin(net.Network.Type)
+	if err != nil {
+		return newPluginError(network, net, "", "CHECK", err)
+	}
+
+	inv := newInvocation("CHECK", network, net.Network.Type, pluginPath, rt)
+	if c.dryRun(inv, net.Bytes, c.args("CHECK", net.Network.Type, rt)) {
+		return nil
+	}
+	err = c.runLimited(inv, rt, func() error {
+		return invoke.ExecPluginWithoutResult(pluginPath, net.Bytes, c.args("CHECK", net.Network.Type, rt), c.ensureExec())
+	})
+	if err != nil {
+		return newPluginError(network, net, pluginPath, "CHECK", err)
+	}
+
+	return nil
+}
+
+func (c *CNIConfig) gcOne(network string, net *NetworkConfig) error {
+	pluginPath, err := c.findPlugin(net.Network.Type)
+	if err != nil {
+		return newPluginError(network, net, "", "GC", err)
+	}
+
+	inv := newInvocation("GC", network, net.Network.Type, pluginPath, nil)
+	if c.dryRun(inv, net.Bytes, c.args("GC", net.Network.Type, &RuntimeConf{})) {
+		return nil
+	}
+	err = c.runLimited(inv, nil, func() error {
+		return invoke.ExecPluginWithoutResult(pluginPath, net.Bytes, c.args("GC", net.Network.Type, &RuntimeConf{}), c.ensureExec())
+	})
+	if err != nil {
+		return newPluginError(network, net, pluginPath, "GC", err)
+	}
+
+	return nil
+}
+
+func (c *CNIConfig) statusOne(network string, net *NetworkConfig) error {
+	pluginPath, err := c.findPlugin(net.Network.Type)
+	if err != nil {
+		return newPluginError(network, net, "", "STATUS", err)
+	}
+
+	inv := newInvocation("STATUS", network, net.Network.Type, pluginPath, nil)
+	if c.dryRun(inv, net.Bytes, c.args("STATUS", net.Network.Type, &RuntimeConf{})) {
+		return nil
+	}
+	err = c.runLimited(inv, nil, func() error {
+		return invoke.ExecPluginWithoutResult(pluginPath, net.Bytes, c.args("STATUS", net.Network.Type, &RuntimeConf{}), c.ensureExec())
+	})
+	if err != nil {
+		return newPluginError(network, net, pluginPath, "STATUS", err)
+	}
+
+	return nil
+}
+
+func (c *CNIConfig) validatePlugin(pluginType, cniVersion string) error {
+	if pluginType == "" {
+		return fmt.Errorf("plugin type missing")
+	}
+
+	pluginPath, err := c.findPlugin(pluginType)
+	if err != nil || c.DryRun != nil {
+		return err
+	}
+
+	var vi version.PluginInfo
+	inv := newInvocation("VERSION", "", pluginType, pluginPath, nil)
+	err = c.runHooked(inv, func() error {
+		var err error
+		vi, err = invoke.GetVersionInfoInheriting(pluginPath, c.inherit(pluginType), c.ensureExec())
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get version info of plugin %q: %v", pluginType, err)
+	}
+
+	if cniVersion == "" {
+		cniVersion = defaultCNIVersion
+	}
+	if !version.Supports(vi, cniVersion) {
+		return fmt.Errorf("plugin %q does not support config version %q (supports %v)", pluginType, cniVersion, vi.SupportedVersions())
+	}
+
+	return nil
+}
+
+func validateCapabilities(name string, plugins []*NetworkConfig, rt *RuntimeConf) error {
+	if rt == nil {
+		return nil
+	}
+
+	for capability := range rt.CapabilityArgs {
+		if !declaresCapability(plugins, capability) {
+			return fmt.Errorf("capability %q is not declared by any plugin in network %q", capability, name)
+		}
+	}
+
+	return nil
+}
+
+// validateRuntimeConf rejects the container ID and interface name that
+// skel would reject, before any plugin runs
+func validateRuntimeConf(rt *RuntimeConf) error {
+	if rt.ContainerID != "" {
+		if err := types.ValidateContainerID(rt.ContainerID); err != nil {
+			return err
+		}
+	}
+	if err := types.ValidateInterfaceName(rt.IfName); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *CNIConfig) findPlugin(plugin string) (string, error) {
+	if c.Resolver != nil {
+		return c.Resolver.FindInPath(plugin, c.Path)
+	}
+	return c.ensureExec().FindInPath(plugin, c.Path)
+}
+
+func (c *CNIConfig) ensureExec() invoke.Exec {
+	if c.exec == nil {
+		return &invoke.RawExec{Stderr: os.Stderr, MaxOutput: c.MaxPluginOutput}
+	}
+	return c.exec
+}
+
+func (c *CNIConfig) args(action, pluginType string, rt *RuntimeConf) *invoke.Args {
 	return &invoke.Args{
 		Command:     action,
 		ContainerID: rt.ContainerID,
@@ -69,5 +727,24 @@ func (c *CNIConfig) args(action string, rt *RuntimeConf) *invoke.Args {
 		PluginArgs:  rt.Args,
 		IfName:      rt.IfName,
 		Path:        strings.Join(c.Path, ":"),
+		Inherit:     c.inherit(pluginType),
+	}
+}
+
+// inherit selects the variables of the environment of this process that
+// plugins of pluginType are given, nil standing for all of them
+func (c *CNIConfig) inherit(pluginType string) func(key string) bool {
+	if c.InheritEnv {
+		return nil
+	}
+
+	allowlist := append(append([]string{"PATH"}, c.EnvAllowlist...), c.PluginEnvAllowlist[pluginType]...)
+	return func(key string) bool {
+		for _, allowed := range allowlist {
+			if key == allowed || strings.HasSuffix(allowed, "*") && strings.HasPrefix(key, strings.TrimSuffix(allowed, "*")) {
+				return true
+			}
+		}
+		return false
 	}
 }
@@ -0,0 +1,684 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containernetworking/cni/libcni"
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/version"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type invocation struct {
+	plugin  string
+	command string
+	stdin   []byte
+	env     []string
+}
+
+// fakeExec pretends that the plugins named in versions exist in any path,
+// answers VERSION with the given supported versions, and records every
+// other invocation. Plugins listed in failures, either by name or by
+// name and command, exit with that error.
+type fakeExec struct {
+	versions map[string][]string
+	failures map[string]error
+	results  map[string]string
+
+	// delay makes every ADD and DEL take that long
+	delay time.Duration
+	// reconcile decodes results as RawExec does, instead of as legacy
+	// results
+	reconcile bool
+
+	mu          sync.Mutex
+	invocations []invocation
+	inFlight    int
+	maxInFlight int
+}
+
+func (e *fakeExec) ExecPlugin(pluginPath string, stdinData []byte, environ []string) ([]byte, error) {
+	command := ""
+	for _, env := range environ {
+		if strings.HasPrefix(env, "CNI_COMMAND=") {
+			command = strings.TrimPrefix(env, "CNI_COMMAND=")
+		}
+	}
+
+	if command == "VERSION" {
+		return json.Marshal(map[string]interface{}{
+			"cniVersion":        "0.2.0",
+			"supportedVersions": e.versions[pluginPath],
+		})
+	}
+
+	e.mu.Lock()
+	e.invocations = append(e.invocations, invocation{pluginPath, command, stdinData, environ})
+	e.inFlight++
+	if e.inFlight > e.maxInFlight {
+		e.maxInFlight = e.inFlight
+	}
+	e.mu.Unlock()
+
+	time.Sleep(e.delay)
+
+	e.mu.Lock()
+	e.inFlight--
+	e.mu.Unlock()
+
+	if err := e.failures[pluginPath+" "+command]; err != nil {
+		return nil, err
+	}
+	if err := e.failures[pluginPath]; err != nil {
+		return nil, err
+	}
+	if result, ok := e.results[pluginPath]; ok {
+		return []byte(result), nil
+	}
+	return []byte(`{}`), nil
+}
+
+func (e *fakeExec) FindInPath(plugin string, paths []string) (string, error) {
+	if _, ok := e.versions[plugin]; !ok {
+		return "", fmt.Errorf("failed to find plugin %q in path %s", plugin, paths)
+	}
+	return plugin, nil
+}
+
+func (e *fakeExec) Decode(jsonBytes []byte) (*types.Result, error) {
+	if e.reconcile {
+		return (&invoke.RawExec{}).Decode(jsonBytes)
+	}
+	res := &types.Result{}
+	err := json.Unmarshal(jsonBytes, res)
+	return res, err
+}
+
+var _ = Describe("Validating configuration", func() {
+	var (
+		cniConfig *libcni.CNIConfig
+		list      *libcni.NetworkConfigList
+	)
+
+	BeforeEach(func() {
+		cniConfig = libcni.NewCNIConfig([]string{"/some/path"}, &fakeExec{
+			versions: map[string][]string{
+				"bridge":  {"0.1.0", "0.2.0"},
+				"portmap": {"0.2.0"},
+				"old":     {"0.1.0"},
+			},
+		})
+
+		var err error
+		list, err = libcni.ConfListFromBytes([]byte(`{
+			"name": "mynet",
+			"cniVersion": "0.2.0",
+			"plugins": [
+				{ "type": "bridge" },
+				{ "type": "portmap", "capabilities": { "portMappings": true } }
+			]
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("accepts a valid list", func() {
+		rt := &libcni.RuntimeConf{
+			CapabilityArgs: map[string]interface{}{"portMappings": []string{}},
+		}
+		Expect(cniConfig.ValidateNetworkList(list, rt)).To(Succeed())
+	})
+
+	It("rejects an invalid network name", func() {
+		list.Name = "my net"
+		err := cniConfig.ValidateNetworkList(list, nil)
+		Expect(err).To(MatchError(ContainSubstring(`invalid network name "my net"`)))
+	})
+
+	It("rejects an invalid cniVersion", func() {
+		list.CNIVersion = "zero"
+		err := cniConfig.ValidateNetworkList(list, nil)
+		Expect(err).To(MatchError(`invalid cniVersion "zero"`))
+	})
+
+	It("rejects plugins that cannot be found", func() {
+		list.Plugins[1].Network.Type = "missing"
+		err := cniConfig.ValidateNetworkList(list, nil)
+		Expect(err).To(MatchError(ContainSubstring(`failed to find plugin "missing"`)))
+	})
+
+	It("rejects plugins that do not support the cniVersion", func() {
+		list.Plugins[1].Network.Type = "old"
+		err := cniConfig.ValidateNetworkList(list, nil)
+		Expect(err).To(HaveOccurred())
+		Expect(strings.HasPrefix(err.Error(), `plugin "old" does not support config version "0.2.0"`)).To(BeTrue())
+	})
+
+	It("describes plugins predating ABOUT by their VERSION", func() {
+		exec := &fakeExec{
+			versions: map[string][]string{"old": {"0.1.0"}},
+			failures: map[string]error{
+				"old ABOUT": types.NewError(types.ErrInvalidEnvironmentVariables, "unknown CNI_COMMAND: ABOUT", ""),
+			},
+		}
+
+		about, err := libcni.NewCNIConfig([]string{"/some/path"}, exec).AboutPlugin("old")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(about).To(Equal(&types.About{CNIVersion: "0.3.0", Name: "old", SupportedVersions: []string{"0.1.0"}}))
+	})
+
+	It("rejects capabilities that no plugin declares", func() {
+		rt := &libcni.RuntimeConf{
+			CapabilityArgs: map[string]interface{}{"bandwidth": nil},
+		}
+		err := cniConfig.ValidateNetworkList(list, rt)
+		Expect(err).To(MatchError(`capability "bandwidth" is not declared by any plugin in network "mynet"`))
+	})
+
+	It("validates a single network configuration", func() {
+		net, err := libcni.ConfFromBytes([]byte(`{ "name": "mynet", "type": "old" }`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cniConfig.ValidateNetwork(net, nil)).To(Succeed())
+
+		net.Network.CNIVersion = "0.2.0"
+		Expect(cniConfig.ValidateNetwork(net, nil)).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("Invoking a network configuration list", func() {
+	var (
+		exec      *fakeExec
+		cniConfig *libcni.CNIConfig
+		list      *libcni.NetworkConfigList
+		rt        *libcni.RuntimeConf
+	)
+
+	BeforeEach(func() {
+		exec = &fakeExec{
+			versions: map[string][]string{"bridge": {"0.2.0"}, "portmap": {"0.2.0"}},
+			failures: map[string]error{},
+			results: map[string]string{
+				"bridge": `{ "ip4": { "ip": "10.1.2.3/24" } }`,
+			},
+		}
+		cniConfig = libcni.NewCNIConfig([]string{"/some/path"}, exec)
+		rt = &libcni.RuntimeConf{
+			ContainerID:    "some-container",
+			NetNS:          "/some/netns",
+			IfName:         "eth0",
+			CapabilityArgs: map[string]interface{}{"portMappings": "some-mappings"},
+		}
+
+		var err error
+		list, err = libcni.ConfListFromBytes([]byte(`{
+			"name": "mynet",
+			"cniVersion": "0.2.0",
+			"plugins": [
+				{ "type": "bridge", "bridge": "br0" },
+				{ "type": "portmap", "capabilities": { "portMappings": true } }
+			]
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("AddNetworkList", func() {
+		It("rejects an invalid interface name before running any plugin", func() {
+			rt.IfName = "some very long name"
+			_, err := cniConfig.AddNetworkList(list, rt)
+			Expect(err).To(MatchError(`invalid interface name "some very long name": longer than 15 characters`))
+
+			code, _ := types.ErrorCode(err)
+			Expect(code).To(Equal(types.ErrInvalidEnvironmentVariables))
+			Expect(exec.invocations).To(BeEmpty())
+		})
+
+		It("runs the plugins in order, passing the previous result", func() {
+			result, err := cniConfig.AddNetworkList(list, rt)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(&types.Result{}))
+
+			Expect(exec.invocations).To(HaveLen(2))
+			Expect(exec.invocations[0].plugin).To(Equal("bridge"))
+			Expect(exec.invocations[0].stdin).To(MatchJSON(`{
+				"name": "mynet", "cniVersion": "0.2.0", "type": "bridge", "bridge": "br0"
+			}`))
+			Expect(exec.invocations[1].plugin).To(Equal("portmap"))
+			Expect(exec.invocations[1].stdin).To(MatchJSON(`{
+				"name": "mynet", "cniVersion": "0.2.0", "type": "portmap",
+				"capabilities": { "portMappings": true },
+				"runtimeConfig": { "portMappings": "some-mappings" },
+				"prevResult": { "ip4": { "ip": "10.1.2.3/24" }, "dns": {} }
+			}`))
+		})
+
+		It("merges the DNS of the plugin results and the runtime", func() {
+			exec.results["bridge"] = `{ "ip4": { "ip": "10.1.2.3/24" }, "dns": { "domain": "bridge.local", "nameservers": ["10.0.0.1"] } }`
+			exec.results["portmap"] = `{ "ip4": { "ip": "10.1.2.3/24" }, "dns": { "nameservers": ["10.0.0.2"] } }`
+			list.Plugins[1].Network.Capabilities["dns"] = true
+			rt.CapabilityArgs["dns"] = map[string]interface{}{"nameservers": []string{"10.0.0.3"}, "search": []string{"rt.local"}}
+
+			result, err := cniConfig.AddNetworkList(list, rt)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.DNS).To(Equal(types.DNS{
+				Domain:      "bridge.local",
+				Nameservers: []string{"10.0.0.3", "10.0.0.2", "10.0.0.1"},
+				Search:      []string{"rt.local"},
+			}))
+		})
+
+		It("ignores the runtime DNS unless a plugin declares the capability", func() {
+			exec.results["portmap"] = `{ "dns": { "nameservers": ["10.0.0.2"] } }`
+			rt.CapabilityArgs["dns"] = map[string]interface{}{"nameservers": []string{"10.0.0.3"}}
+
+			result, err := cniConfig.AddNetworkList(list, rt)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.DNS).To(Equal(types.DNS{Nameservers: []string{"10.0.0.2"}}))
+		})
+
+		It("identifies the plugin that failed", func() {
+			exec.failures["portmap"] = &types.Error{Code: 7, Msg: "no iptables"}
+
+			_, err := cniConfig.AddNetworkList(list, rt)
+			Expect(err).To(MatchError(`network "mynet": plugin "portmap" failed on ADD: no iptables`))
+
+			var pluginErr *libcni.PluginError
+			Expect(errors.As(err, &pluginErr)).To(BeTrue())
+			Expect(pluginErr.Plugin).To(Equal("portmap"))
+			Expect(pluginErr.Path).To(Equal("portmap"))
+			Expect(pluginErr.Command).To(Equal("ADD"))
+
+			var cniErr *types.Error
+			Expect(errors.As(err, &cniErr)).To(BeTrue())
+			Expect(cniErr.Code).To(Equal(uint(7)))
+		})
+
+		It("rolls back the plugins that succeeded before the failure", func() {
+			exec.failures["portmap"] = errors.New("boom")
+
+			_, err := cniConfig.AddNetworkList(list, rt)
+			Expect(err).To(MatchError(`network "mynet": plugin "portmap" failed on ADD: boom`))
+
+			Expect(exec.invocations).To(HaveLen(3))
+			Expect(exec.invocations[2].plugin).To(Equal("bridge"))
+			Expect(exec.invocations[2].command).To(Equal("DEL"))
+			Expect(exec.invocations[2].stdin).To(MatchJSON(`{
+				"name": "mynet", "cniVersion": "0.2.0", "type": "bridge", "bridge": "br0",
+				"prevResult": { "ip4": { "ip": "10.1.2.3/24" }, "dns": {} }
+			}`))
+		})
+
+		It("rejects an invalid result, rolling back the plugin that printed it", func() {
+			exec.reconcile = true
+			exec.results["portmap"] = `{
+				"cniVersion": "0.3.0",
+				"ips": [{ "version": "4", "interface": 2, "address": "10.1.2.3/24" }]
+			}`
+
+			_, err := cniConfig.AddNetworkList(list, rt)
+			Expect(err).To(MatchError(`network "mynet": plugin "portmap" failed on ADD: ` +
+				`invalid plugin result: ips[0]: interface index 2 out of range (0 interfaces)`))
+
+			var invalid *version.InvalidResultError
+			Expect(errors.As(err, &invalid)).To(BeTrue())
+
+			Expect(exec.invocations).To(HaveLen(4))
+			Expect(exec.invocations[2].plugin).To(Equal("portmap"))
+			Expect(exec.invocations[2].command).To(Equal("DEL"))
+			Expect(exec.invocations[3].plugin).To(Equal("bridge"))
+			Expect(exec.invocations[3].command).To(Equal("DEL"))
+		})
+
+		It("rolls back an interrupted plugin along with the others", func() {
+			exec.failures["portmap ADD"] = &types.Error{Code: types.ErrInterrupted, Msg: "interrupted by terminated"}
+
+			_, err := cniConfig.AddNetworkList(list, rt)
+			Expect(err).To(MatchError(`network "mynet": plugin "portmap" failed on ADD: interrupted by terminated`))
+
+			Expect(exec.invocations).To(HaveLen(4))
+			Expect(exec.invocations[2].plugin).To(Equal("portmap"))
+			Expect(exec.invocations[2].command).To(Equal("DEL"))
+			Expect(exec.invocations[3].plugin).To(Equal("bridge"))
+			Expect(exec.invocations[3].command).To(Equal("DEL"))
+		})
+
+		It("reports a failed rollback", func() {
+			exec.failures["portmap"] = errors.New("boom")
+			exec.failures["bridge DEL"] = errors.New("stuck")
+
+			_, err := cniConfig.AddNetworkList(list, rt)
+			Expect(err).To(MatchError(`network "mynet": plugin "portmap" failed on ADD: boom ` +
+				`(rollback failed: network "mynet": plugin "bridge" failed on DEL: stuck)`))
+		})
+
+		It("rolls back the plugins that succeeded when the configuration of a later one cannot be built", func() {
+			list.Plugins[1].Bytes = []byte(`not json`)
+
+			_, err := cniConfig.AddNetworkList(list, rt)
+			Expect(err).To(MatchError(HavePrefix("unmarshal existing network bytes: ")))
+
+			Expect(exec.invocations).To(HaveLen(2))
+			Expect(exec.invocations[1].plugin).To(Equal("bridge"))
+			Expect(exec.invocations[1].command).To(Equal("DEL"))
+		})
+
+		It("rolls back every plugin when the DNS of the runtime is invalid", func() {
+			list.Plugins[1].Network.Capabilities["dns"] = true
+			rt.CapabilityArgs["dns"] = map[string]interface{}{"nameservers": "10.0.0.3"}
+
+			_, err := cniConfig.AddNetworkList(list, rt)
+			Expect(err).To(MatchError(HavePrefix("invalid dns capability argument: ")))
+
+			Expect(exec.invocations).To(HaveLen(4))
+			Expect(exec.invocations[2].plugin).To(Equal("portmap"))
+			Expect(exec.invocations[2].command).To(Equal("DEL"))
+			Expect(exec.invocations[3].plugin).To(Equal("bridge"))
+			Expect(exec.invocations[3].command).To(Equal("DEL"))
+		})
+
+		It("rolls back every plugin when the attachment cannot be cached", func() {
+			cacheFile, err := ioutil.TempFile("", "cni-cache")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cacheFile.Close()).To(Succeed())
+			defer os.Remove(cacheFile.Name())
+			cniConfig.CacheDir = cacheFile.Name()
+			exec.failures["bridge DEL"] = errors.New("stuck")
+
+			_, err = cniConfig.AddNetworkList(list, rt)
+			Expect(err).To(MatchError(And(
+				HavePrefix("failed to create cache directory: "),
+				HaveSuffix(`(rollback failed: network "mynet": plugin "bridge" failed on DEL: stuck)`))))
+
+			Expect(exec.invocations).To(HaveLen(4))
+			Expect(exec.invocations[2].plugin).To(Equal("portmap"))
+			Expect(exec.invocations[2].command).To(Equal("DEL"))
+			Expect(exec.invocations[3].plugin).To(Equal("bridge"))
+			Expect(exec.invocations[3].command).To(Equal("DEL"))
+		})
+
+		It("does not roll back when disabled", func() {
+			cniConfig.DisableRollback = true
+			exec.failures["portmap"] = errors.New("boom")
+
+			_, err := cniConfig.AddNetworkList(list, rt)
+			Expect(err).To(HaveOccurred())
+			Expect(exec.invocations).To(HaveLen(2))
+		})
+
+		It("finds the plugins with the Resolver when set", func() {
+			pinned, err := ioutil.TempFile("", "pinned-bridge")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pinned.Close()).To(Succeed())
+			defer os.Remove(pinned.Name())
+
+			cniConfig.Resolver = invoke.NewResolver(map[string]string{"bridge": pinned.Name()})
+			delete(exec.versions, "portmap")
+
+			_, err = cniConfig.AddNetworkList(list, rt)
+			Expect(err).To(MatchError(ContainSubstring(`plugin "portmap" failed on ADD: failed to find plugin "portmap" in path [/some/path]`)))
+			Expect(exec.invocations).To(HaveLen(2))
+			Expect(exec.invocations[0].plugin).To(Equal(pinned.Name()))
+		})
+
+		It("reports plugins that cannot be found", func() {
+			delete(exec.versions, "bridge")
+
+			_, err := cniConfig.AddNetworkList(list, rt)
+			var pluginErr *libcni.PluginError
+			Expect(errors.As(err, &pluginErr)).To(BeTrue())
+			Expect(pluginErr.Plugin).To(Equal("bridge"))
+			Expect(pluginErr.Path).To(BeEmpty())
+		})
+	})
+
+	Describe("DelNetworkList", func() {
+		It("runs the plugins in reverse order", func() {
+			Expect(cniConfig.DelNetworkList(list, rt)).To(Succeed())
+
+			Expect(exec.invocations).To(HaveLen(2))
+			Expect(exec.invocations[0].plugin).To(Equal("portmap"))
+			Expect(exec.invocations[0].command).To(Equal("DEL"))
+			Expect(exec.invocations[1].plugin).To(Equal("bridge"))
+		})
+
+		It("identifies the plugin that failed", func() {
+			exec.failures["bridge"] = errors.New("boom")
+
+			err := cniConfig.DelNetworkList(list, rt)
+			Expect(err).To(MatchError(`network "mynet": plugin "bridge" failed on DEL: boom`))
+		})
+	})
+	Describe("CheckNetworkList", func() {
+		var result *types.Result
+
+		BeforeEach(func() {
+			var err error
+			result, err = exec.Decode([]byte(`{ "ip4": { "ip": "10.1.2.3/24" } }`))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("runs the plugins in order, passing the result being checked", func() {
+			Expect(cniConfig.CheckNetworkList(list, result, rt)).To(Succeed())
+
+			Expect(exec.invocations).To(HaveLen(2))
+			for i, plugin := range []string{"bridge", "portmap"} {
+				Expect(exec.invocations[i].plugin).To(Equal(plugin))
+				Expect(exec.invocations[i].command).To(Equal("CHECK"))
+				conf := map[string]interface{}{}
+				Expect(json.Unmarshal(exec.invocations[i].stdin, &conf)).To(Succeed())
+				Expect(conf).To(HaveKeyWithValue("prevResult", map[string]interface{}{
+					"ip4": map[string]interface{}{"ip": "10.1.2.3/24"},
+					"dns": map[string]interface{}{},
+				}))
+			}
+		})
+
+		It("identifies the plugin that failed", func() {
+			exec.failures["portmap CHECK"] = errors.New("rules gone")
+
+			err := cniConfig.CheckNetworkList(list, result, rt)
+			Expect(err).To(MatchError(`network "mynet": plugin "portmap" failed on CHECK: rules gone`))
+		})
+
+		It("succeeds without running any plugin when disabled", func() {
+			list.DisableCheck = true
+			exec.failures["bridge"] = errors.New("boom")
+
+			Expect(cniConfig.CheckNetworkList(list, result, rt)).To(Succeed())
+			Expect(exec.invocations).To(BeEmpty())
+		})
+	})
+
+	Describe("with DryRun", func() {
+		var planned []*libcni.PlannedInvocation
+
+		BeforeEach(func() {
+			planned = nil
+			cniConfig.DryRun = func(inv *libcni.PlannedInvocation) {
+				planned = append(planned, inv)
+			}
+			cniConfig.Hooks = []libcni.Hook{libcni.HookFuncs{
+				Before: func(inv *libcni.Invocation) { Fail("hook called in a dry run") },
+			}}
+		})
+
+		It("reports what ADD would run without running anything", func() {
+			cacheDir, err := ioutil.TempDir("", "libcni-dry-run")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(cacheDir)
+			cniConfig.CacheDir = cacheDir
+			// not asked for in a dry run
+			exec.versions["bridge"] = []string{"0.1.0"}
+
+			result, err := cniConfig.AddNetworkList(list, rt)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(&types.Result{}))
+			Expect(exec.invocations).To(BeEmpty())
+			Expect(cniConfig.ListAttachments()).To(BeEmpty())
+
+			Expect(planned).To(HaveLen(2))
+			Expect(planned[0].Invocation).To(Equal(libcni.Invocation{
+				Command: "ADD", Network: "mynet", Plugin: "bridge", PluginPath: "bridge",
+				ContainerID: "some-container", IfName: "eth0",
+			}))
+			Expect(planned[0].Env).To(ContainElement("CNI_COMMAND=ADD"))
+			Expect(planned[0].Env).To(ContainElement("CNI_NETNS=/some/netns"))
+			Expect(planned[0].Env).To(ContainElement("CNI_PATH=/some/path"))
+			Expect(planned[1].Plugin).To(Equal("portmap"))
+			Expect(planned[1].Stdin).To(MatchJSON(`{
+				"name": "mynet", "cniVersion": "0.2.0", "type": "portmap",
+				"capabilities": { "portMappings": true },
+				"runtimeConfig": { "portMappings": "some-mappings" },
+				"prevResult": { "dns": {} }
+			}`))
+		})
+
+		It("reports DEL in reverse order", func() {
+			Expect(cniConfig.DelNetworkList(list, rt)).To(Succeed())
+			Expect(exec.invocations).To(BeEmpty())
+
+			Expect(planned).To(HaveLen(2))
+			Expect(planned[0].Plugin).To(Equal("portmap"))
+			Expect(planned[0].Command).To(Equal("DEL"))
+			Expect(planned[1].Plugin).To(Equal("bridge"))
+		})
+
+		It("validates the list", func() {
+			rt.CapabilityArgs["bandwidth"] = "some-limits"
+			_, err := cniConfig.AddNetworkList(list, rt)
+			Expect(err).To(MatchError(`capability "bandwidth" is not declared by any plugin in network "mynet"`))
+
+			delete(rt.CapabilityArgs, "bandwidth")
+			delete(exec.versions, "portmap")
+			err = cniConfig.DelNetworkList(list, rt)
+			Expect(err).To(MatchError(`failed to find plugin "portmap" in path [/some/path]`))
+			Expect(planned).To(BeEmpty())
+		})
+	})
+
+	Describe("environment of the plugins", func() {
+		var planned []*libcni.PlannedInvocation
+
+		BeforeEach(func() {
+			planned = nil
+			cniConfig.DryRun = func(inv *libcni.PlannedInvocation) {
+				planned = append(planned, inv)
+			}
+			os.Setenv("LIBCNI_TEST_SECRET", "hunter2")
+			os.Setenv("LIBCNI_TEST_PROXY", "http://proxy:3128")
+			os.Setenv("CNI_TEST_EXTRA", "extra")
+		})
+
+		AfterEach(func() {
+			os.Unsetenv("LIBCNI_TEST_SECRET")
+			os.Unsetenv("LIBCNI_TEST_PROXY")
+			os.Unsetenv("CNI_TEST_EXTRA")
+		})
+
+		add := func() {
+			_, err := cniConfig.AddNetworkList(list, rt)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(planned).To(HaveLen(2))
+		}
+
+		It("passes only PATH and the CNI_* variables by default", func() {
+			add()
+			Expect(planned[0].Env).To(ContainElement("CNI_COMMAND=ADD"))
+			Expect(planned[0].Env).To(ContainElement("CNI_TEST_EXTRA=extra"))
+			Expect(planned[0].Env).To(ContainElement("PATH=" + os.Getenv("PATH")))
+			Expect(planned[0].Env).NotTo(ContainElement(HavePrefix("LIBCNI_TEST_")))
+		})
+
+		It("adds the variables of the allowlists", func() {
+			cniConfig.EnvAllowlist = []string{"LIBCNI_TEST_P*"}
+			cniConfig.PluginEnvAllowlist = map[string][]string{"portmap": {"LIBCNI_TEST_SECRET"}}
+
+			add()
+			Expect(planned[0].Env).To(ContainElement("LIBCNI_TEST_PROXY=http://proxy:3128"))
+			Expect(planned[0].Env).NotTo(ContainElement("LIBCNI_TEST_SECRET=hunter2"))
+			Expect(planned[1].Env).To(ContainElement("LIBCNI_TEST_PROXY=http://proxy:3128"))
+			Expect(planned[1].Env).To(ContainElement("LIBCNI_TEST_SECRET=hunter2"))
+		})
+
+		It("passes the whole environment with InheritEnv", func() {
+			cniConfig.InheritEnv = true
+
+			add()
+			Expect(planned[0].Env).To(ContainElement("LIBCNI_TEST_SECRET=hunter2"))
+			Expect(planned[0].Env).To(ContainElement("CNI_COMMAND=ADD"))
+		})
+	})
+
+	Describe("GCNetworkList", func() {
+		valid := []libcni.GCAttachment{{ContainerID: "some-container", IfName: "eth0"}}
+
+		It("runs every plugin with the valid attachments", func() {
+			exec.failures["bridge GC"] = errors.New("boom")
+
+			err := cniConfig.GCNetworkList(list, valid)
+			Expect(err).To(MatchError(`network "mynet": plugin "bridge" failed on GC: boom`))
+
+			Expect(exec.invocations).To(HaveLen(2))
+			Expect(exec.invocations[1].plugin).To(Equal("portmap"))
+			Expect(exec.invocations[1].command).To(Equal("GC"))
+			Expect(exec.invocations[1].stdin).To(MatchJSON(`{
+				"name": "mynet", "cniVersion": "0.2.0", "type": "portmap",
+				"capabilities": { "portMappings": true },
+				"cni.dev/valid-attachments": [{ "containerID": "some-container", "ifname": "eth0" }]
+			}`))
+		})
+
+		It("succeeds without running any plugin when disabled", func() {
+			list.DisableGC = true
+
+			Expect(cniConfig.GCNetworkList(list, valid)).To(Succeed())
+			Expect(exec.invocations).To(BeEmpty())
+		})
+	})
+
+	Describe("StatusNetworkList", func() {
+		It("runs the plugins in order", func() {
+			Expect(cniConfig.StatusNetworkList(list)).To(Succeed())
+
+			Expect(exec.invocations).To(HaveLen(2))
+			Expect(exec.invocations[0].plugin).To(Equal("bridge"))
+			Expect(exec.invocations[0].command).To(Equal("STATUS"))
+			Expect(exec.invocations[1].plugin).To(Equal("portmap"))
+			Expect(exec.invocations[1].stdin).To(MatchJSON(`{
+				"name": "mynet", "cniVersion": "0.2.0", "type": "portmap",
+				"capabilities": { "portMappings": true }
+			}`))
+		})
+
+		It("stops at the first plugin that is not ready", func() {
+			exec.failures["bridge STATUS"] = types.NewError(types.ErrNotReady, "no uplink", "")
+
+			err := cniConfig.StatusNetworkList(list)
+			Expect(err).To(MatchError(`network "mynet": plugin "bridge" failed on STATUS: no uplink`))
+			code, _ := types.ErrorCode(err)
+			Expect(code).To(Equal(types.ErrNotReady))
+			Expect(exec.invocations).To(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,115 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// Attachment is one network a container should be attached to, along
+// with the runtime configuration (usually a distinct IfName) to use for it
+type Attachment struct {
+	Network     *NetworkConfigList
+	RuntimeConf *RuntimeConf
+}
+
+// AttachmentErrors is returned by AddNetworkLists and DelNetworkLists when
+// one or more attachments failed. Errors is indexed like the attachments
+// passed in and is nil for those that succeeded.
+type AttachmentErrors struct {
+	Errors []error
+}
+
+func (e *AttachmentErrors) Error() string {
+	msgs := []string{}
+	for _, err := range e.Errors {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	return fmt.Sprintf("%d of %d attachments failed: %s", len(msgs), len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Unwrap lets errors.As find the *PluginError of any failed attachment
+func (e *AttachmentErrors) Unwrap() []error {
+	errs := []error{}
+	for _, err := range e.Errors {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// AddNetworkLists runs AddNetworkList for every attachment, with at most
+// maxParallel of them in flight at once (no limit if maxParallel < 1). The
+// returned results are indexed like attachments. Attachments that succeeded
+// are left in place when others fail; if any failed the error is an
+// *AttachmentErrors.
+func (c *CNIConfig) AddNetworkLists(attachments []*Attachment, maxParallel int) ([]*types.Result, error) {
+	results := make([]*types.Result, len(attachments))
+	err := forEachAttachment(attachments, maxParallel, func(i int, a *Attachment) error {
+		result, err := c.AddNetworkList(a.Network, a.RuntimeConf)
+		results[i] = result
+		return err
+	})
+	return results, err
+}
+
+// DelNetworkLists runs DelNetworkList for every attachment like
+// AddNetworkLists does for ADD.
+func (c *CNIConfig) DelNetworkLists(attachments []*Attachment, maxParallel int) error {
+	return forEachAttachment(attachments, maxParallel, func(_ int, a *Attachment) error {
+		return c.DelNetworkList(a.Network, a.RuntimeConf)
+	})
+}
+
+func forEachAttachment(attachments []*Attachment, maxParallel int, f func(int, *Attachment) error) error {
+	if maxParallel < 1 || maxParallel > len(attachments) {
+		maxParallel = len(attachments)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		tokens = make(chan struct{}, maxParallel)
+		errs   = make([]error, len(attachments))
+		failed = false
+	)
+
+	for i, a := range attachments {
+		wg.Add(1)
+		tokens <- struct{}{}
+		go func(i int, a *Attachment) {
+			defer wg.Done()
+			defer func() { <-tokens }()
+			errs[i] = f(i, a)
+		}(i, a)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			failed = true
+		}
+	}
+	if failed {
+		return &AttachmentErrors{Errors: errs}
+	}
+	return nil
+}
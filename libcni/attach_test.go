@@ -0,0 +1,101 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni_test
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/containernetworking/cni/libcni"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Attaching a container to several networks", func() {
+	var (
+		exec        *fakeExec
+		cniConfig   *libcni.CNIConfig
+		attachments []*libcni.Attachment
+	)
+
+	BeforeEach(func() {
+		exec = &fakeExec{
+			versions: map[string][]string{"bridge": {"0.2.0"}, "macvlan": {"0.2.0"}, "ptp": {"0.2.0"}},
+			failures: map[string]error{},
+			results: map[string]string{
+				"bridge":  `{ "ip4": { "ip": "10.1.0.2/24" } }`,
+				"macvlan": `{ "ip4": { "ip": "10.2.0.2/24" } }`,
+				"ptp":     `{ "ip4": { "ip": "10.3.0.2/24" } }`,
+			},
+			delay: 20 * time.Millisecond,
+		}
+		cniConfig = libcni.NewCNIConfig([]string{"/some/path"}, exec)
+
+		attachments = nil
+		for i, plugin := range []string{"bridge", "macvlan", "ptp"} {
+			list, err := libcni.ConfListFromBytes([]byte(fmt.Sprintf(
+				`{ "name": "net%d", "plugins": [ { "type": %q } ] }`, i, plugin)))
+			Expect(err).NotTo(HaveOccurred())
+			attachments = append(attachments, &libcni.Attachment{
+				Network: list,
+				RuntimeConf: &libcni.RuntimeConf{
+					ContainerID: "some-container",
+					NetNS:       "/some/netns",
+					IfName:      fmt.Sprintf("eth%d", i),
+				},
+			})
+		}
+	})
+
+	It("returns the results in the order of the attachments", func() {
+		results, err := cniConfig.AddNetworkLists(attachments, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results).To(HaveLen(3))
+		Expect(results[0].IP4.IP.String()).To(Equal("10.1.0.2/24"))
+		Expect(results[1].IP4.IP.String()).To(Equal("10.2.0.2/24"))
+		Expect(results[2].IP4.IP.String()).To(Equal("10.3.0.2/24"))
+		Expect(exec.maxInFlight).To(Equal(3))
+	})
+
+	It("bounds the number of attachments in flight", func() {
+		_, err := cniConfig.AddNetworkLists(attachments, 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(exec.maxInFlight).To(Equal(2))
+
+		Expect(cniConfig.DelNetworkLists(attachments, 1)).To(Succeed())
+		Expect(exec.invocations).To(HaveLen(6))
+		Expect(exec.maxInFlight).To(Equal(2))
+	})
+
+	It("aggregates the errors of the failed attachments", func() {
+		exec.failures["macvlan"] = errors.New("no master")
+
+		results, err := cniConfig.AddNetworkLists(attachments, 0)
+		Expect(err).To(MatchError(`1 of 3 attachments failed: network "net1": plugin "macvlan" failed on ADD: no master`))
+		Expect(results[0]).NotTo(BeNil())
+		Expect(results[1]).To(BeNil())
+		Expect(results[2]).NotTo(BeNil())
+
+		attachErr, ok := err.(*libcni.AttachmentErrors)
+		Expect(ok).To(BeTrue())
+		Expect(attachErr.Errors[0]).To(BeNil())
+		Expect(attachErr.Errors[2]).To(BeNil())
+
+		var pluginErr *libcni.PluginError
+		Expect(errors.As(err, &pluginErr)).To(BeTrue())
+		Expect(pluginErr.Network).To(Equal("net1"))
+	})
+})
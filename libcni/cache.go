@@ -0,0 +1,241 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// DefaultCacheDir is the conventional CNIConfig.CacheDir of a node
+const DefaultCacheDir = "/var/lib/cni/cache"
+
+// CachedAttachment is what CNIConfig.CacheDir records about an
+// attachment that was added and not yet deleted
+type CachedAttachment struct {
+	Network     string          `json:"network"`
+	ContainerID string          `json:"containerID"`
+	IfName      string          `json:"ifName"`
+	NetNS       string          `json:"netns,omitempty"`
+	Config      json.RawMessage `json:"config"`
+	Result      *types.Result   `json:"result,omitempty"`
+	// Args and CapabilityArgs are those of the RuntimeConf of the ADD,
+	// which DEL and CHECK are given again
+	Args           [][2]string            `json:"args,omitempty"`
+	CapabilityArgs map[string]interface{} `json:"capabilityArgs,omitempty"`
+
+	// Created is when the attachment was first added and Updated when
+	// it was last added, which differ if the runtime repeated the ADD
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// ListAttachments returns every attachment recorded in c.CacheDir,
+// ordered by network, container ID and interface name. It returns
+// nothing if the cache is disabled.
+func (c *CNIConfig) ListAttachments() ([]*CachedAttachment, error) {
+	if c.CacheDir == "" {
+		return nil, nil
+	}
+
+	files, err := ioutil.ReadDir(c.CacheDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var attachments []*CachedAttachment
+	for _, f := range files {
+		// skip directories and files still being written
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		a, err := readCachedAttachment(filepath.Join(c.CacheDir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if a != nil {
+			attachments = append(attachments, a)
+		}
+	}
+
+	sort.Slice(attachments, func(i, j int) bool {
+		a, b := attachments[i], attachments[j]
+		if a.Network != b.Network {
+			return a.Network < b.Network
+		}
+		if a.ContainerID != b.ContainerID {
+			return a.ContainerID < b.ContainerID
+		}
+		return a.IfName < b.IfName
+	})
+	return attachments, nil
+}
+
+// GetAttachment returns the attachment of the container to network with
+// ifName recorded in c.CacheDir, or nil if there is none
+func (c *CNIConfig) GetAttachment(network, containerID, ifName string) (*CachedAttachment, error) {
+	if c.CacheDir == "" {
+		return nil, nil
+	}
+	return readCachedAttachment(c.cachePath(network, containerID, ifName))
+}
+
+func (c *CNIConfig) cachePath(network, containerID, ifName string) string {
+	return filepath.Join(c.CacheDir, network+"-"+containerID+"-"+ifName)
+}
+
+// cacheAdd records the attachment added with config and result, keeping
+// the creation time of an earlier record
+func (c *CNIConfig) cacheAdd(network string, config []byte, result *types.Result, rt *RuntimeConf) error {
+	if c.CacheDir == "" || c.DryRun != nil {
+		return nil
+	}
+
+	now := time.Now()
+	a := &CachedAttachment{
+		Network:     network,
+		ContainerID: rt.ContainerID,
+		IfName:      rt.IfName,
+		NetNS:       rt.NetNS,
+		Config:      config,
+		Result:      result,
+		Created:     now,
+		Updated:     now,
+
+		Args:           rt.Args,
+		CapabilityArgs: rt.CapabilityArgs,
+	}
+
+	path := c.cachePath(network, rt.ContainerID, rt.IfName)
+	if old, err := readCachedAttachment(path); err == nil && old != nil {
+		a.Created = old.Created
+	}
+
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.CacheDir, 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+
+	// write and rename, so that ListAttachments never sees half a record
+	f, err := ioutil.TempFile(c.CacheDir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to cache attachment: %v", err)
+	}
+	return nil
+}
+
+// replayArgs returns rt with the CNI_ARGS and capability arguments the
+// attachment was added with in place of its own, so that DEL and CHECK
+// see what ADD worked with, e.g. the port mappings to remove, even if
+// the runtime does not pass them again. Whatever the cache has no record
+// of is left as the runtime passed it.
+func (c *CNIConfig) replayArgs(network string, rt *RuntimeConf) (*RuntimeConf, error) {
+	a, err := c.GetAttachment(network, rt.ContainerID, rt.IfName)
+	if err != nil || a == nil {
+		return rt, err
+	}
+
+	replayed := *rt
+	if len(a.Args) > 0 {
+		replayed.Args = a.Args
+	}
+	if len(a.CapabilityArgs) > 0 {
+		replayed.CapabilityArgs = a.CapabilityArgs
+	}
+	return &replayed, nil
+}
+
+// cacheDel forgets the attachment, if it was recorded
+func (c *CNIConfig) cacheDel(network string, rt *RuntimeConf) error {
+	if c.CacheDir == "" || c.DryRun != nil {
+		return nil
+	}
+
+	err := os.Remove(c.cachePath(network, rt.ContainerID, rt.IfName))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove cached attachment: %v", err)
+	}
+	return nil
+}
+
+// cacheGC forgets the attachments of network other than the valid ones,
+// emitting an EventGCRemoved for each
+func (c *CNIConfig) cacheGC(network string, valid []GCAttachment) error {
+	if c.DryRun != nil {
+		return nil
+	}
+	attachments, err := c.ListAttachments()
+	if err != nil {
+		return fmt.Errorf("failed to list cached attachments: %v", err)
+	}
+
+	keep := map[GCAttachment]bool{}
+	for _, v := range valid {
+		keep[v] = true
+	}
+	for _, a := range attachments {
+		if a.Network != network || keep[GCAttachment{ContainerID: a.ContainerID, IfName: a.IfName}] {
+			continue
+		}
+		err := os.Remove(c.cachePath(network, a.ContainerID, a.IfName))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove cached attachment: %v", err)
+		}
+		c.emit(&Event{Type: EventGCRemoved, Network: network, ContainerID: a.ContainerID, IfName: a.IfName})
+	}
+	return nil
+}
+
+// readCachedAttachment returns nil if there is no record at path
+func readCachedAttachment(path string) (*CachedAttachment, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a := &CachedAttachment{}
+	if err := json.Unmarshal(data, a); err != nil {
+		return nil, fmt.Errorf("failed to parse cached attachment %s: %v", path, err)
+	}
+	return a, nil
+}
@@ -0,0 +1,175 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/libcni"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Listing cached attachments", func() {
+	var (
+		exec      *fakeExec
+		cniConfig *libcni.CNIConfig
+		list      *libcni.NetworkConfigList
+		rt        *libcni.RuntimeConf
+		cacheDir  string
+	)
+
+	BeforeEach(func() {
+		exec = &fakeExec{
+			versions: map[string][]string{"bridge": {"0.2.0"}},
+			failures: map[string]error{},
+			results:  map[string]string{"bridge": `{ "ip4": { "ip": "10.1.2.3/24" } }`},
+		}
+		cniConfig = libcni.NewCNIConfig([]string{"/some/path"}, exec)
+
+		var err error
+		cacheDir, err = ioutil.TempDir("", "cni-cache")
+		Expect(err).NotTo(HaveOccurred())
+		cniConfig.CacheDir = cacheDir
+
+		rt = &libcni.RuntimeConf{ContainerID: "some-container", NetNS: "/some/netns", IfName: "eth0"}
+		list, err = libcni.ConfListFromBytes([]byte(`{
+			"name": "mynet",
+			"cniVersion": "0.2.0",
+			"plugins": [{ "type": "bridge", "bridge": "br0" }]
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+	})
+
+	It("records an attachment on ADD and forgets it on DEL", func() {
+		result, err := cniConfig.AddNetworkList(list, rt)
+		Expect(err).NotTo(HaveOccurred())
+
+		attachments, err := cniConfig.ListAttachments()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(attachments).To(HaveLen(1))
+		a := attachments[0]
+		Expect(a.Network).To(Equal("mynet"))
+		Expect(a.ContainerID).To(Equal("some-container"))
+		Expect(a.IfName).To(Equal("eth0"))
+		Expect(a.NetNS).To(Equal("/some/netns"))
+		Expect([]byte(a.Config)).To(MatchJSON(list.Bytes))
+		Expect(a.Result).To(Equal(result))
+		Expect(a.Created).NotTo(BeZero())
+		Expect(a.Updated).To(Equal(a.Created))
+
+		Expect(cniConfig.DelNetworkList(list, rt)).To(Succeed())
+		Expect(cniConfig.ListAttachments()).To(BeEmpty())
+	})
+
+	It("keeps the creation time when the ADD is repeated", func() {
+		_, err := cniConfig.AddNetworkList(list, rt)
+		Expect(err).NotTo(HaveOccurred())
+		first, err := cniConfig.GetAttachment("mynet", "some-container", "eth0")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = cniConfig.AddNetworkList(list, rt)
+		Expect(err).NotTo(HaveOccurred())
+		second, err := cniConfig.GetAttachment("mynet", "some-container", "eth0")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(second.Created.Equal(first.Created)).To(BeTrue())
+		Expect(second.Updated.Before(first.Updated)).To(BeFalse())
+	})
+
+	It("replays the arguments of the ADD on DEL and CHECK", func() {
+		list, err := libcni.ConfListFromBytes([]byte(`{
+			"name": "mynet",
+			"cniVersion": "0.2.0",
+			"plugins": [{ "type": "bridge", "capabilities": { "portMappings": true } }]
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+		rt.Args = [][2]string{{"K8S_POD_NAME", "web"}}
+		rt.CapabilityArgs = map[string]interface{}{
+			"portMappings": []map[string]interface{}{{"hostPort": 8080, "containerPort": 80}},
+		}
+		_, err = cniConfig.AddNetworkList(list, rt)
+		Expect(err).NotTo(HaveOccurred())
+
+		// the runtime passes none of them again
+		bare := &libcni.RuntimeConf{ContainerID: "some-container", NetNS: "/some/netns", IfName: "eth0"}
+		Expect(cniConfig.CheckNetworkList(list, nil, bare)).To(Succeed())
+		Expect(cniConfig.DelNetworkList(list, bare)).To(Succeed())
+
+		Expect(exec.invocations).To(HaveLen(3))
+		for _, inv := range exec.invocations {
+			Expect(inv.env).To(ContainElement("CNI_ARGS=K8S_POD_NAME=web"))
+			var conf map[string]interface{}
+			Expect(json.Unmarshal(inv.stdin, &conf)).To(Succeed())
+			Expect(conf["runtimeConfig"]).To(Equal(map[string]interface{}{
+				"portMappings": []interface{}{map[string]interface{}{"hostPort": 8080.0, "containerPort": 80.0}},
+			}))
+		}
+	})
+
+	It("passes the arguments of the runtime for attachments it has no record of", func() {
+		rt.Args = [][2]string{{"K8S_POD_NAME", "web"}}
+		Expect(cniConfig.DelNetworkList(list, rt)).To(Succeed())
+		Expect(exec.invocations[0].env).To(ContainElement("CNI_ARGS=K8S_POD_NAME=web"))
+	})
+
+	It("does not record an attachment whose ADD failed", func() {
+		exec.failures["bridge"] = os.ErrPermission
+
+		_, err := cniConfig.AddNetworkList(list, rt)
+		Expect(err).To(HaveOccurred())
+		Expect(cniConfig.ListAttachments()).To(BeEmpty())
+	})
+
+	It("orders attachments by network, container and interface", func() {
+		for _, id := range []string{"b", "a"} {
+			for _, ifName := range []string{"eth1", "eth0"} {
+				_, err := cniConfig.AddNetworkList(list, &libcni.RuntimeConf{ContainerID: id, IfName: ifName})
+				Expect(err).NotTo(HaveOccurred())
+			}
+		}
+
+		attachments, err := cniConfig.ListAttachments()
+		Expect(err).NotTo(HaveOccurred())
+		var keys []string
+		for _, a := range attachments {
+			keys = append(keys, a.ContainerID+"/"+a.IfName)
+		}
+		Expect(keys).To(Equal([]string{"a/eth0", "a/eth1", "b/eth0", "b/eth1"}))
+	})
+
+	It("reports a corrupt record", func() {
+		path := filepath.Join(cacheDir, "mynet-some-container-eth0")
+		Expect(ioutil.WriteFile(path, []byte("{"), 0600)).To(Succeed())
+
+		_, err := cniConfig.ListAttachments()
+		Expect(err).To(MatchError(ContainSubstring("failed to parse cached attachment " + path)))
+	})
+
+	It("lists nothing when the cache is disabled", func() {
+		cniConfig.CacheDir = ""
+		_, err := cniConfig.AddNetworkList(list, rt)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(cniConfig.ListAttachments()).To(BeEmpty())
+	})
+})
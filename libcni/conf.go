@@ -20,7 +20,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
+
+	"github.com/containernetworking/cni/pkg/types"
 )
 
 func ConfFromBytes(bytes []byte) (*NetworkConfig, error) {
@@ -31,6 +34,20 @@ func ConfFromBytes(bytes []byte) (*NetworkConfig, error) {
 	return conf, nil
 }
 
+// defaultCNIVersion is assumed for configurations that do not set cniVersion
+const defaultCNIVersion = "0.1.0"
+
+var validVersion = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+$`)
+
+// validateVersion accepts an empty version, which legacy configurations
+// use to mean defaultCNIVersion
+func validateVersion(cniVersion string) error {
+	if cniVersion != "" && !validVersion.MatchString(cniVersion) {
+		return fmt.Errorf("invalid cniVersion %q", cniVersion)
+	}
+	return nil
+}
+
 func ConfFromFile(filename string) (*NetworkConfig, error) {
 	bytes, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -39,7 +56,43 @@ func ConfFromFile(filename string) (*NetworkConfig, error) {
 	return ConfFromBytes(bytes)
 }
 
-func ConfFiles(dir string) ([]string, error) {
+func ConfListFromBytes(bytes []byte) (*NetworkConfigList, error) {
+	rawList, err := types.ParseNetConfList(bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing configuration list: %s", err)
+	}
+
+	list := &NetworkConfigList{
+		Name:         rawList.Name,
+		CNIVersion:   rawList.CNIVersion,
+		DisableCheck: rawList.DisableCheck,
+		DisableGC:    rawList.DisableGC,
+		Bytes:        bytes,
+	}
+
+	if rawList.RawPlugins == nil {
+		return nil, fmt.Errorf("error parsing configuration list: no 'plugins' key")
+	}
+	for i, plugin := range rawList.RawPlugins {
+		netConf, err := ConfFromBytes(plugin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse plugin config %d: %v", i, err)
+		}
+		list.Plugins = append(list.Plugins, netConf)
+	}
+
+	return list, nil
+}
+
+func ConfListFromFile(filename string) (*NetworkConfigList, error) {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", filename, err)
+	}
+	return ConfListFromBytes(bytes)
+}
+
+func ConfFiles(dir string, extensions []string) ([]string, error) {
 	// In part, adapted from rkt/networking/podenv.go#listFiles
 	files, err := ioutil.ReadDir(dir)
 	switch {
@@ -55,15 +108,18 @@ func ConfFiles(dir string) ([]string, error) {
 		if f.IsDir() {
 			continue
 		}
-		if filepath.Ext(f.Name()) == ".conf" {
-			confFiles = append(confFiles, filepath.Join(dir, f.Name()))
+		fileExt := filepath.Ext(f.Name())
+		for _, ext := range extensions {
+			if fileExt == ext {
+				confFiles = append(confFiles, filepath.Join(dir, f.Name()))
+			}
 		}
 	}
 	return confFiles, nil
 }
 
 func LoadConf(dir, name string) (*NetworkConfig, error) {
-	files, err := ConfFiles(dir)
+	files, err := ConfFiles(dir, []string{".conf", ".json"})
 	switch {
 	case err != nil:
 		return nil, err
@@ -83,3 +139,141 @@ func LoadConf(dir, name string) (*NetworkConfig, error) {
 	}
 	return nil, fmt.Errorf(`no net configuration with name "%s" in %s`, name, dir)
 }
+
+// LoadConfList returns the network configuration list called name in dir.
+// If no .conflist file defines it, a matching .conf or .json file is
+// converted to a single-plugin list.
+func LoadConfList(dir, name string) (*NetworkConfigList, error) {
+	files, err := ConfFiles(dir, []string{".conflist"})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	for _, confFile := range files {
+		conf, err := ConfListFromFile(confFile)
+		if err != nil {
+			return nil, err
+		}
+		if conf.Name == name {
+			return conf, nil
+		}
+	}
+
+	// Try and load a network configuration file (instead of list)
+	// from the same name, then upconvert.
+	singleConf, err := LoadConf(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	return ConfListFromConf(singleConf)
+}
+
+// ConfListFromConf wraps a single network configuration in a list holding
+// just that plugin.
+func ConfListFromConf(original *NetworkConfig) (*NetworkConfigList, error) {
+	// Re-deserialize the config's json, then make a raw map configlist.
+	// This may seem a bit strange, but it's to make the Bytes fields
+	// actually make sense. Otherwise, the generated json is littered with
+	// golang default values.
+
+	rawConfig := make(map[string]interface{})
+	if err := json.Unmarshal(original.Bytes, &rawConfig); err != nil {
+		return nil, err
+	}
+
+	rawConfigList := map[string]interface{}{
+		"name":       original.Network.Name,
+		"cniVersion": original.Network.CNIVersion,
+		"plugins":    []interface{}{rawConfig},
+	}
+
+	b, err := json.Marshal(rawConfigList)
+	if err != nil {
+		return nil, err
+	}
+	return ConfListFromBytes(b)
+}
+
+// injectRuntimeConfig passes the entries of rt.CapabilityArgs that the
+// plugin declares as capabilities in its 'runtimeConfig' dictionary
+func injectRuntimeConfig(orig *NetworkConfig, rt *RuntimeConf) (*NetworkConfig, error) {
+	if rt == nil {
+		return orig, nil
+	}
+
+	rc := make(map[string]interface{})
+	for capability, supported := range orig.Network.Capabilities {
+		if !supported {
+			continue
+		}
+		if data, ok := rt.CapabilityArgs[capability]; ok {
+			rc[capability] = data
+		}
+	}
+
+	if len(rc) == 0 {
+		return orig, nil
+	}
+	return InjectConf(orig, map[string]interface{}{"runtimeConfig": rc})
+}
+
+// buildOneConfig renders the configuration passed to one plugin of a list:
+// the list's name and cniVersion, the previous plugin's result and the
+// plugin's runtimeConfig are added to the plugin's own configuration
+func buildOneConfig(list *NetworkConfigList, orig *NetworkConfig, prevResult *types.Result, rt *RuntimeConf) (*NetworkConfig, error) {
+	inject := map[string]interface{}{
+		"name": list.Name,
+	}
+	if list.CNIVersion != "" {
+		inject["cniVersion"] = list.CNIVersion
+	}
+	// Add previous plugin result
+	if prevResult != nil {
+		inject["prevResult"] = prevResult
+	}
+
+	orig, err := InjectConf(orig, inject)
+	if err != nil {
+		return nil, err
+	}
+
+	return injectRuntimeConfig(orig, rt)
+}
+
+// mergeDNS returns the DNS settings of the result of plugins, given the
+// DNS of the result of each plugin, the last plugin first: the runtime's
+// dns capability, if a plugin declares it, takes precedence over the
+// plugins, and later plugins over earlier ones. See types.MergeDNS.
+func mergeDNS(plugins []*NetworkConfig, resultDNS []types.DNS, rt *RuntimeConf) (types.DNS, error) {
+	var sources []types.DNS
+	if data, ok := capabilityArg(rt, "dns"); ok && declaresCapability(plugins, "dns") {
+		bytes, err := json.Marshal(data)
+		if err != nil {
+			return types.DNS{}, err
+		}
+		var dns types.DNS
+		if err := json.Unmarshal(bytes, &dns); err != nil {
+			return types.DNS{}, fmt.Errorf("invalid dns capability argument: %v", err)
+		}
+		sources = append(sources, dns)
+	}
+	return types.MergeDNS(append(sources, resultDNS...)...), nil
+}
+
+func capabilityArg(rt *RuntimeConf, capability string) (interface{}, bool) {
+	if rt == nil {
+		return nil, false
+	}
+	data, ok := rt.CapabilityArgs[capability]
+	return data, ok
+}
+
+func declaresCapability(plugins []*NetworkConfig, capability string) bool {
+	for _, net := range plugins {
+		if net.Network.Capabilities[capability] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni
+
+import (
+	"github.com/containernetworking/cni/pkg/invoke"
+)
+
+// PlannedInvocation is a plugin execution skipped by a dry run, with the
+// exact configuration and environment the plugin would have received
+type PlannedInvocation struct {
+	Invocation
+	Stdin []byte
+	Env   []string
+}
+
+// dryRun reports inv to c.DryRun instead of executing it, and returns
+// whether it did
+func (c *CNIConfig) dryRun(inv *Invocation, stdin []byte, args *invoke.Args) bool {
+	if c.DryRun == nil {
+		return false
+	}
+	c.DryRun(&PlannedInvocation{Invocation: *inv, Stdin: stdin, Env: args.AsEnv()})
+	return true
+}
+
+// validateDryRun validates list in a dry run, where the plugins are not
+// run to find out whether the list can work at all
+func (c *CNIConfig) validateDryRun(list *NetworkConfigList, rt *RuntimeConf) error {
+	if c.DryRun == nil {
+		return nil
+	}
+	return c.ValidateNetworkList(list, rt)
+}
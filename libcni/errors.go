@@ -0,0 +1,60 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni
+
+import (
+	"fmt"
+)
+
+// PluginError is returned by CNIConfig when a plugin of a network could
+// not be found or failed to run. When the plugin itself reported a CNI
+// error, Err is the *types.Error it printed, so callers can branch on its
+// code with errors.As.
+type PluginError struct {
+	// Network is the name of the network being operated on
+	Network string
+	// Plugin is the type of the failed plugin
+	Plugin string
+	// Path is the plugin binary; it is empty if the plugin was not found
+	Path string
+	// Command is the CNI command that failed, e.g. "ADD"
+	Command string
+	Err     error
+	// RollbackErr is set when ADD failed and cleaning up the plugins
+	// that had already succeeded failed as well
+	RollbackErr error
+}
+
+func newPluginError(network string, net *NetworkConfig, path, command string, err error) *PluginError {
+	return &PluginError{
+		Network: network,
+		Plugin:  net.Network.Type,
+		Path:    path,
+		Command: command,
+		Err:     err,
+	}
+}
+
+func (e *PluginError) Error() string {
+	msg := fmt.Sprintf("network %q: plugin %q failed on %s: %v", e.Network, e.Plugin, e.Command, e.Err)
+	if e.RollbackErr != nil {
+		msg = fmt.Sprintf("%s (rollback failed: %v)", msg, e.RollbackErr)
+	}
+	return msg
+}
+
+func (e *PluginError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,99 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni
+
+import (
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// EventType is the kind of an Event
+type EventType string
+
+const (
+	// EventAttachStarted and EventAttachCompleted bracket AddNetworkList
+	// and AddNetwork
+	EventAttachStarted   EventType = "attach-started"
+	EventAttachCompleted EventType = "attach-completed"
+	// EventTeardownStarted and EventTeardownCompleted bracket
+	// DelNetworkList and DelNetwork
+	EventTeardownStarted   EventType = "teardown-started"
+	EventTeardownCompleted EventType = "teardown-completed"
+	// EventPluginFinished follows every plugin execution
+	EventPluginFinished EventType = "plugin-finished"
+	// EventGCRemoved is emitted for every cached attachment GCNetworkList
+	// removes
+	EventGCRemoved EventType = "gc-removed"
+)
+
+// Event is a step in the lifecycle of an attachment, e.g. for a node
+// agent to build a timeline of the network operations of a container
+type Event struct {
+	Type EventType
+	// Time is when the event happened
+	Time time.Time
+	// Network, ContainerID and IfName identify the attachment; the
+	// latter two are empty for plugin executions outside of one, such
+	// as VERSION and GC
+	Network     string
+	ContainerID string
+	IfName      string
+	// Invocation is the plugin execution that finished, for
+	// EventPluginFinished
+	Invocation *Invocation
+	// Duration is how long the plugin execution, attachment or teardown
+	// took, for the finished and completed events
+	Duration time.Duration
+	// Err is the error of the plugin execution, attachment or teardown,
+	// nil if it succeeded
+	Err error
+	// Result is the result of a successful attachment
+	Result *types.Result
+}
+
+// emit hands e to c.Events, unless there is none or c is dry running
+func (c *CNIConfig) emit(e *Event) {
+	if c.Events == nil || c.DryRun != nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	c.Events(e)
+}
+
+// track emits the started event of an operation on the attachment of rt
+// to network, and returns the function emitting its completed event
+func (c *CNIConfig) track(started, completed EventType, network string, rt *RuntimeConf) func(*types.Result, error) {
+	if c.Events == nil {
+		return func(*types.Result, error) {}
+	}
+
+	attachment := Event{Network: network}
+	if rt != nil {
+		attachment.ContainerID, attachment.IfName = rt.ContainerID, rt.IfName
+	}
+	start := time.Now()
+	e := attachment
+	e.Type, e.Time = started, start
+	c.emit(&e)
+
+	return func(result *types.Result, err error) {
+		e := attachment
+		e.Type, e.Duration, e.Result, e.Err = completed, time.Since(start), result, err
+		c.emit(&e)
+	}
+}
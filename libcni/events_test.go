@@ -0,0 +1,173 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/containernetworking/cni/libcni"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Events", func() {
+	var (
+		exec      *fakeExec
+		cniConfig *libcni.CNIConfig
+		list      *libcni.NetworkConfigList
+		rt        *libcni.RuntimeConf
+		cacheDir  string
+
+		mu     sync.Mutex
+		events []libcni.Event
+	)
+
+	eventTypes := func() []libcni.EventType {
+		mu.Lock()
+		defer mu.Unlock()
+		var t []libcni.EventType
+		for _, e := range events {
+			t = append(t, e.Type)
+		}
+		return t
+	}
+
+	BeforeEach(func() {
+		exec = &fakeExec{
+			versions: map[string][]string{"bridge": {"0.2.0"}, "portmap": {"0.2.0"}},
+			failures: map[string]error{},
+			results:  map[string]string{"bridge": `{ "ip4": { "ip": "10.1.2.3/24" } }`},
+		}
+		cniConfig = libcni.NewCNIConfig([]string{"/some/path"}, exec)
+		events = nil
+		cniConfig.Events = func(e *libcni.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, *e)
+		}
+
+		var err error
+		cacheDir, err = ioutil.TempDir("", "cni-cache")
+		Expect(err).NotTo(HaveOccurred())
+		cniConfig.CacheDir = cacheDir
+
+		rt = &libcni.RuntimeConf{ContainerID: "some-container", NetNS: "/some/netns", IfName: "eth0"}
+		list, err = libcni.ConfListFromBytes([]byte(`{
+			"name": "mynet",
+			"cniVersion": "0.2.0",
+			"plugins": [
+				{ "type": "bridge", "bridge": "br0" },
+				{ "type": "portmap" }
+			]
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+	})
+
+	It("brackets the plugins of an attachment and its teardown", func() {
+		result, err := cniConfig.AddNetworkList(list, rt)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cniConfig.DelNetworkList(list, rt)).To(Succeed())
+
+		Expect(eventTypes()).To(Equal([]libcni.EventType{
+			libcni.EventAttachStarted,
+			libcni.EventPluginFinished, libcni.EventPluginFinished,
+			libcni.EventAttachCompleted,
+			libcni.EventTeardownStarted,
+			libcni.EventPluginFinished, libcni.EventPluginFinished,
+			libcni.EventTeardownCompleted,
+		}))
+		for _, e := range events {
+			Expect(e.Network).To(Equal("mynet"))
+			Expect(e.ContainerID).To(Equal("some-container"))
+			Expect(e.IfName).To(Equal("eth0"))
+			Expect(e.Time).NotTo(BeZero())
+			Expect(e.Err).NotTo(HaveOccurred())
+		}
+		Expect(events[1].Invocation.Plugin).To(Equal("bridge"))
+		Expect(events[1].Invocation.Command).To(Equal("ADD"))
+		Expect(events[3].Result).To(Equal(result))
+		Expect(events[3].Duration).To(BeNumerically(">=", events[1].Duration))
+	})
+
+	It("reports the error of a failed attachment", func() {
+		exec.failures["portmap ADD"] = errors.New("boom")
+
+		_, err := cniConfig.AddNetworkList(list, rt)
+		Expect(err).To(HaveOccurred())
+
+		// the bridge is rolled back within the attachment
+		Expect(eventTypes()).To(Equal([]libcni.EventType{
+			libcni.EventAttachStarted,
+			libcni.EventPluginFinished, libcni.EventPluginFinished, libcni.EventPluginFinished,
+			libcni.EventAttachCompleted,
+		}))
+		Expect(events[2].Err).To(MatchError("boom"))
+		Expect(events[3].Invocation.Plugin).To(Equal("bridge"))
+		Expect(events[3].Invocation.Command).To(Equal("DEL"))
+		Expect(events[4].Err).To(Equal(err))
+		Expect(events[4].Result).To(BeNil())
+	})
+
+	It("reports the cached attachments that GC removes", func() {
+		_, err := cniConfig.AddNetworkList(list, rt)
+		Expect(err).NotTo(HaveOccurred())
+		other := &libcni.RuntimeConf{ContainerID: "other-container", NetNS: "/some/netns", IfName: "eth0"}
+		_, err = cniConfig.AddNetworkList(list, other)
+		Expect(err).NotTo(HaveOccurred())
+		events = nil
+
+		Expect(cniConfig.GCNetworkList(list, []libcni.GCAttachment{{ContainerID: "some-container", IfName: "eth0"}})).To(Succeed())
+
+		Expect(eventTypes()).To(Equal([]libcni.EventType{
+			libcni.EventPluginFinished, libcni.EventPluginFinished,
+			libcni.EventGCRemoved,
+		}))
+		Expect(events[2].ContainerID).To(Equal("other-container"))
+		Expect(events[2].IfName).To(Equal("eth0"))
+
+		attachments, err := cniConfig.ListAttachments()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(attachments).To(HaveLen(1))
+		Expect(attachments[0].ContainerID).To(Equal("some-container"))
+	})
+
+	It("keeps the cache when a plugin fails GC", func() {
+		_, err := cniConfig.AddNetworkList(list, rt)
+		Expect(err).NotTo(HaveOccurred())
+		exec.failures["bridge GC"] = errors.New("boom")
+
+		Expect(cniConfig.GCNetworkList(list, nil)).NotTo(Succeed())
+
+		Expect(eventTypes()).NotTo(ContainElement(libcni.EventGCRemoved))
+		attachments, err := cniConfig.ListAttachments()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(attachments).To(HaveLen(1))
+	})
+
+	It("is not called by dry runs", func() {
+		cniConfig.DryRun = func(*libcni.PlannedInvocation) {}
+
+		_, err := cniConfig.AddNetworkList(list, rt)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(events).To(BeEmpty())
+	})
+})
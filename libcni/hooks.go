@@ -0,0 +1,105 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni
+
+import (
+	"time"
+)
+
+// Invocation describes one execution of a plugin by a CNIConfig
+type Invocation struct {
+	// Command is the CNI_COMMAND, e.g. "ADD", "DEL", "CHECK" or "VERSION"
+	Command string
+	// Network is the name of the network or list being run, empty for VERSION
+	Network string
+	// Plugin is the plugin type and PluginPath the binary that runs
+	Plugin     string
+	PluginPath string
+	// ContainerID and IfName are empty for VERSION and GC
+	ContainerID string
+	IfName      string
+}
+
+// Hook observes the plugin executions of a CNIConfig, e.g. to export
+// latency metrics or traces of network setup. Hooks must be safe for
+// concurrent use when the CNIConfig is used from several goroutines, as
+// AddNetworkLists does.
+type Hook interface {
+	// BeforeInvoke is called just before the plugin is executed
+	BeforeInvoke(inv *Invocation)
+	// AfterInvoke is called once the plugin has exited, with the time
+	// the execution took and its error, nil on success
+	AfterInvoke(inv *Invocation, duration time.Duration, err error)
+}
+
+// HookFuncs adapts a pair of functions to Hook; either may be nil
+type HookFuncs struct {
+	Before func(inv *Invocation)
+	After  func(inv *Invocation, duration time.Duration, err error)
+}
+
+func (h HookFuncs) BeforeInvoke(inv *Invocation) {
+	if h.Before != nil {
+		h.Before(inv)
+	}
+}
+
+func (h HookFuncs) AfterInvoke(inv *Invocation, duration time.Duration, err error) {
+	if h.After != nil {
+		h.After(inv, duration, err)
+	}
+}
+
+// runHooked executes f between the BeforeInvoke and AfterInvoke calls of
+// every hook of c, and emits its EventPluginFinished
+func (c *CNIConfig) runHooked(inv *Invocation, f func() error) error {
+	if len(c.Hooks) == 0 && c.Events == nil {
+		return f()
+	}
+
+	for _, h := range c.Hooks {
+		h.BeforeInvoke(inv)
+	}
+	start := time.Now()
+	err := f()
+	duration := time.Since(start)
+	for _, h := range c.Hooks {
+		h.AfterInvoke(inv, duration, err)
+	}
+	c.emit(&Event{
+		Type:        EventPluginFinished,
+		Network:     inv.Network,
+		ContainerID: inv.ContainerID,
+		IfName:      inv.IfName,
+		Invocation:  inv,
+		Duration:    duration,
+		Err:         err,
+	})
+	return err
+}
+
+func newInvocation(command, network, plugin, pluginPath string, rt *RuntimeConf) *Invocation {
+	inv := &Invocation{
+		Command:    command,
+		Network:    network,
+		Plugin:     plugin,
+		PluginPath: pluginPath,
+	}
+	if rt != nil {
+		inv.ContainerID = rt.ContainerID
+		inv.IfName = rt.IfName
+	}
+	return inv
+}
@@ -0,0 +1,138 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/containernetworking/cni/libcni"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type recordedInvocation struct {
+	before bool
+	inv    libcni.Invocation
+	err    error
+}
+
+type recordingHook struct {
+	mu    sync.Mutex
+	calls []recordedInvocation
+}
+
+func (h *recordingHook) BeforeInvoke(inv *libcni.Invocation) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls = append(h.calls, recordedInvocation{before: true, inv: *inv})
+}
+
+func (h *recordingHook) AfterInvoke(inv *libcni.Invocation, duration time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	Expect(duration).To(BeNumerically(">", 0))
+	h.calls = append(h.calls, recordedInvocation{inv: *inv, err: err})
+}
+
+var _ = Describe("Invocation hooks", func() {
+	var (
+		hook      *recordingHook
+		cniConfig *libcni.CNIConfig
+		rt        *libcni.RuntimeConf
+		tmpDir    string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "libcni-hooks")
+		Expect(err).NotTo(HaveOccurred())
+
+		hook = &recordingHook{}
+		cniConfig = libcni.NewCNIConfig([]string{noopPluginDir}, nil)
+		cniConfig.Hooks = []libcni.Hook{hook}
+		rt = &libcni.RuntimeConf{
+			ContainerID: "some-container",
+			NetNS:       "/proc/self/ns/net",
+			IfName:      "eth0",
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("reports each plugin execution of a list, including the rollback", func() {
+		list, err := libcni.ConfListFromBytes([]byte(fmt.Sprintf(`{
+			"cniVersion": "0.2.0",
+			"name": "test",
+			"plugins": [
+				{"type": "noop", "debugFile": %q},
+				{"type": "noop", "error": {"code": 11, "msg": "busy"}, "failOn": ["ADD"]}
+			]
+		}`, filepath.Join(tmpDir, "debug"))))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = cniConfig.AddNetworkList(list, rt)
+		Expect(err).To(HaveOccurred())
+
+		Expect(hook.calls).To(HaveLen(6))
+		commands := []string{}
+		for i, c := range hook.calls {
+			Expect(c.before).To(Equal(i%2 == 0))
+			Expect(c.inv.Network).To(Equal("test"))
+			Expect(c.inv.Plugin).To(Equal("noop"))
+			Expect(c.inv.PluginPath).To(Equal(filepath.Join(noopPluginDir, "noop")))
+			Expect(c.inv.ContainerID).To(Equal("some-container"))
+			Expect(c.inv.IfName).To(Equal("eth0"))
+			commands = append(commands, c.inv.Command)
+		}
+		Expect(commands).To(Equal([]string{"ADD", "ADD", "ADD", "ADD", "DEL", "DEL"}))
+		Expect(hook.calls[1].err).NotTo(HaveOccurred())
+		Expect(hook.calls[3].err).To(MatchError(ContainSubstring("busy")))
+		Expect(hook.calls[5].err).NotTo(HaveOccurred())
+	})
+
+	It("reports the VERSION executions of validation", func() {
+		conf, err := libcni.ConfFromBytes([]byte(`{"cniVersion": "0.2.0", "name": "test", "type": "noop"}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(cniConfig.ValidateNetwork(conf, nil)).To(Succeed())
+		Expect(hook.calls).To(HaveLen(2))
+		Expect(hook.calls[1].inv).To(Equal(libcni.Invocation{
+			Command:    "VERSION",
+			Plugin:     "noop",
+			PluginPath: filepath.Join(noopPluginDir, "noop"),
+		}))
+	})
+
+	It("accepts plain functions", func() {
+		var after []string
+		cniConfig.Hooks = []libcni.Hook{libcni.HookFuncs{
+			After: func(inv *libcni.Invocation, _ time.Duration, err error) {
+				after = append(after, inv.Command)
+			},
+		}}
+
+		conf, err := libcni.ConfFromBytes([]byte(`{"cniVersion": "0.2.0", "name": "test", "type": "noop"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cniConfig.DelNetwork(conf, rt)).To(Succeed())
+		Expect(after).To(Equal([]string{"DEL"}))
+	})
+})
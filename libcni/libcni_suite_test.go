@@ -0,0 +1,43 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni_test
+
+import (
+	"path/filepath"
+
+	"github.com/onsi/gomega/gexec"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+var noopPluginDir string
+
+func TestLibcni(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Libcni Suite")
+}
+
+var _ = BeforeSuite(func() {
+	pathToNoopPlugin, err := gexec.Build("github.com/containernetworking/cni/plugins/test/noop")
+	Expect(err).NotTo(HaveOccurred())
+	noopPluginDir = filepath.Dir(pathToNoopPlugin)
+})
+
+var _ = AfterSuite(func() {
+	gexec.CleanupBuildArtifacts()
+})
@@ -0,0 +1,146 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Limiter bounds how many plugins a CNIConfig executes at once, so that a
+// burst of containers does not fork hundreds of plugins contending for
+// the same locks, e.g. that of iptables. Executions over a limit wait
+// their turn in the order they arrived; one that only waits for its own
+// network does not hold up those of other networks. A Limiter may be
+// shared by several CNIConfigs, to apply its limits across them.
+type Limiter struct {
+	// PerNetwork is the most plugins executed at once for one network
+	// or list, and Global the most across all of them; zero means no
+	// limit
+	PerNetwork int
+	Global     int
+
+	mu        sync.Mutex
+	running   int
+	byNetwork map[string]int
+	queue     []*limiterWaiter
+}
+
+type limiterWaiter struct {
+	network string
+	ready   chan struct{}
+}
+
+// acquire waits until a plugin of network may run, or until ctx is done.
+// Every successful acquire must be followed by a release.
+func (l *Limiter) acquire(ctx context.Context, network string) error {
+	l.mu.Lock()
+	// waiters that fit were let through when the last slot was freed,
+	// so running right away never overtakes one
+	if l.fits(network) {
+		l.take(network)
+		l.mu.Unlock()
+		return nil
+	}
+	w := &limiterWaiter{network: network, ready: make(chan struct{})}
+	l.queue = append(l.queue, w)
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-w.ready:
+		// the slot was granted as ctx was done; hand it on
+		l.free(network)
+	default:
+		for i, q := range l.queue {
+			if q == w {
+				l.queue = append(l.queue[:i], l.queue[i+1:]...)
+				break
+			}
+		}
+	}
+	return fmt.Errorf("gave up waiting to run a plugin of network %q: %w", network, ctx.Err())
+}
+
+// release frees the slot taken by acquire for network
+func (l *Limiter) release(network string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.free(network)
+}
+
+func (l *Limiter) fits(network string) bool {
+	if l.Global > 0 && l.running >= l.Global {
+		return false
+	}
+	return l.PerNetwork <= 0 || l.byNetwork[network] < l.PerNetwork
+}
+
+func (l *Limiter) take(network string) {
+	if l.byNetwork == nil {
+		l.byNetwork = map[string]int{}
+	}
+	l.running++
+	l.byNetwork[network]++
+}
+
+// free gives back a slot of network and lets through the waiters that
+// now fit, in order. l.mu must be held.
+func (l *Limiter) free(network string) {
+	l.running--
+	if l.byNetwork[network]--; l.byNetwork[network] == 0 {
+		delete(l.byNetwork, network)
+	}
+
+	waiting := l.queue[:0]
+	for _, w := range l.queue {
+		if l.fits(w.network) {
+			l.take(w.network)
+			close(w.ready)
+			continue
+		}
+		waiting = append(waiting, w)
+	}
+	for i := len(waiting); i < len(l.queue); i++ {
+		l.queue[i] = nil
+	}
+	l.queue = waiting
+}
+
+// runLimited runs f, the execution inv describes, once c.Limiter lets
+// it, waiting at most until the context of rt is done
+func (c *CNIConfig) runLimited(inv *Invocation, rt *RuntimeConf, f func() error) error {
+	if c.Limiter == nil {
+		return c.runHooked(inv, f)
+	}
+
+	ctx := context.Background()
+	if rt != nil && rt.Context != nil {
+		ctx = rt.Context
+	}
+	if err := c.Limiter.acquire(ctx, inv.Network); err != nil {
+		return err
+	}
+	defer c.Limiter.release(inv.Network)
+	return c.runHooked(inv, f)
+}
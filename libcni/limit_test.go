@@ -0,0 +1,107 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/containernetworking/cni/libcni"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Limiting concurrent plugin executions", func() {
+	var (
+		exec      *fakeExec
+		cniConfig *libcni.CNIConfig
+	)
+
+	BeforeEach(func() {
+		exec = &fakeExec{
+			versions: map[string][]string{"bridge": {"0.2.0"}},
+			failures: map[string]error{},
+			results:  map[string]string{},
+			delay:    20 * time.Millisecond,
+		}
+		cniConfig = libcni.NewCNIConfig([]string{"/some/path"}, exec)
+	})
+
+	// attachments returns an attachment per network name given
+	attachments := func(networks ...string) []*libcni.Attachment {
+		var atts []*libcni.Attachment
+		for i, name := range networks {
+			list, err := libcni.ConfListFromBytes([]byte(fmt.Sprintf(
+				`{ "name": %q, "plugins": [ { "type": "bridge" } ] }`, name)))
+			Expect(err).NotTo(HaveOccurred())
+			atts = append(atts, &libcni.Attachment{
+				Network: list,
+				RuntimeConf: &libcni.RuntimeConf{
+					ContainerID: "some-container",
+					NetNS:       "/some/netns",
+					IfName:      fmt.Sprintf("eth%d", i),
+				},
+			})
+		}
+		return atts
+	}
+
+	It("bounds the executions across networks", func() {
+		cniConfig.Limiter = &libcni.Limiter{Global: 2}
+		_, err := cniConfig.AddNetworkLists(attachments("net0", "net1", "net2", "net3"), 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(exec.invocations).To(HaveLen(4))
+		Expect(exec.maxInFlight).To(Equal(2))
+	})
+
+	It("bounds the executions of each network without holding up the others", func() {
+		cniConfig.Limiter = &libcni.Limiter{PerNetwork: 1}
+		_, err := cniConfig.AddNetworkLists(attachments("net0", "net0", "net0", "net1"), 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(exec.invocations).To(HaveLen(4))
+		Expect(exec.maxInFlight).To(Equal(2))
+	})
+
+	It("stops waiting once the context of the runtime is done", func() {
+		cniConfig.Limiter = &libcni.Limiter{Global: 1}
+		exec.delay = 200 * time.Millisecond
+		atts := attachments("net0", "net1")
+
+		done := make(chan error)
+		go func() {
+			_, err := cniConfig.AddNetworkList(atts[0].Network, atts[0].RuntimeConf)
+			done <- err
+		}()
+		Eventually(func() int {
+			exec.mu.Lock()
+			defer exec.mu.Unlock()
+			return exec.inFlight
+		}).Should(Equal(1))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		atts[1].RuntimeConf.Context = ctx
+		_, err := cniConfig.AddNetworkList(atts[1].Network, atts[1].RuntimeConf)
+		Expect(err).To(MatchError(ContainSubstring(`gave up waiting to run a plugin of network "net1"`)))
+		Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+
+		Expect(<-done).To(Succeed())
+		// the slot of the first ADD is free again
+		_, err = cniConfig.AddNetworkList(atts[0].Network, atts[0].RuntimeConf)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
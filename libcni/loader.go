@@ -0,0 +1,137 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// ConfLoader loads every network configuration found in a directory.
+// Files ending in .conflist are parsed as lists; .conf and .json files are
+// converted to single-plugin lists.
+//
+// A configuration without a name is named after its file, without the
+// extension, and one without a cniVersion gets DefaultCNIVersion if that
+// is set, so that a directory mixing old and new files keeps working
+// while it is being upgraded. Strict rejects such configurations instead.
+type ConfLoader struct {
+	Dir               string
+	DefaultCNIVersion string
+	Strict            bool
+}
+
+func NewConfLoader(dir string) *ConfLoader {
+	return &ConfLoader{Dir: dir}
+}
+
+// Load returns the configuration lists in l.Dir ordered by file name. If
+// several files define the same network name, the first one in that order
+// wins and the others are ignored.
+func (l *ConfLoader) Load() ([]*NetworkConfigList, error) {
+	files, err := ConfFiles(l.Dir, []string{".conf", ".conflist", ".json"})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	lists := []*NetworkConfigList{}
+	seen := map[string]bool{}
+	for _, confFile := range files {
+		list, err := loadConfListFile(confFile)
+		if err != nil {
+			return nil, err
+		}
+		if list, err = l.withDefaults(confFile, list); err != nil {
+			return nil, err
+		}
+		if seen[list.Name] {
+			continue
+		}
+		seen[list.Name] = true
+		lists = append(lists, list)
+	}
+	return lists, nil
+}
+
+// Watch calls onChange with the result of Load every time a configuration
+// file in l.Dir is created, written, renamed or removed, until stop is
+// closed. onChange is called once with the initial contents before Watch
+// starts waiting for changes.
+func (l *ConfLoader) Watch(stop <-chan struct{}, onChange func([]*NetworkConfigList, error)) error {
+	return l.watch(stop, func() {
+		onChange(l.Load())
+	})
+}
+
+func loadConfListFile(filename string) (*NetworkConfigList, error) {
+	if filepath.Ext(filename) == ".conflist" {
+		return ConfListFromFile(filename)
+	}
+
+	conf, err := ConfFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ConfListFromConf(conf)
+}
+
+// withDefaults fills in the name and cniVersion that list, loaded from
+// filename, lacks, or fails in strict mode
+func (l *ConfLoader) withDefaults(filename string, list *NetworkConfigList) (*NetworkConfigList, error) {
+	inject := map[string]interface{}{}
+	if list.Name == "" {
+		if l.Strict {
+			return nil, fmt.Errorf("%s: missing network name", filename)
+		}
+		name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+		if err := types.ValidateNetworkName(name); err != nil {
+			return nil, fmt.Errorf("%s: cannot name the network after the file: %v", filename, err)
+		}
+		inject["name"] = name
+	}
+	if list.CNIVersion == "" {
+		switch {
+		case l.Strict:
+			return nil, fmt.Errorf("%s: missing cniVersion", filename)
+		case l.DefaultCNIVersion != "":
+			if err := validateVersion(l.DefaultCNIVersion); err != nil {
+				return nil, err
+			}
+			inject["cniVersion"] = l.DefaultCNIVersion
+		}
+	}
+	if len(inject) == 0 {
+		return list, nil
+	}
+
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(list.Bytes, &raw); err != nil {
+		return nil, err
+	}
+	for k, v := range inject {
+		raw[k] = v
+	}
+	bytes, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	return ConfListFromBytes(bytes)
+}
@@ -0,0 +1,64 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// IN_CREATE is deliberately not watched: it fires before the new file has
+// any contents, and IN_CLOSE_WRITE follows once it has been written
+const watchMask = unix.IN_CLOSE_WRITE | unix.IN_DELETE | unix.IN_MOVED_FROM |
+	unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+func (l *ConfLoader) watch(stop <-chan struct{}, reload func()) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("failed to initialize inotify: %v", err)
+	}
+	// a non-blocking fd is registered with the runtime poller, so
+	// closing the file below unblocks a pending Read
+	f := os.NewFile(uintptr(fd), "inotify")
+	defer f.Close()
+
+	if _, err = unix.InotifyAddWatch(fd, l.Dir, watchMask); err != nil {
+		return fmt.Errorf("failed to watch %q: %v", l.Dir, err)
+	}
+
+	go func() {
+		<-stop
+		f.Close()
+	}()
+
+	reload()
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		// a single read drains all pending events, so a burst of
+		// changes results in a single reload
+		if _, err := f.Read(buf); err != nil {
+			select {
+			case <-stop:
+				return nil
+			default:
+				return fmt.Errorf("failed to read inotify events for %q: %v", l.Dir, err)
+			}
+		}
+		reload()
+	}
+}
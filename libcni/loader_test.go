@@ -0,0 +1,153 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/libcni"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Loading a configuration directory", func() {
+	var (
+		configDir string
+		loader    *libcni.ConfLoader
+	)
+
+	writeConf := func(name, contents string) {
+		err := ioutil.WriteFile(filepath.Join(configDir, name), []byte(contents), 0600)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	names := func(lists []*libcni.NetworkConfigList) []string {
+		result := []string{}
+		for _, l := range lists {
+			result = append(result, l.Name)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		var err error
+		configDir, err = ioutil.TempDir("", "plugin-conf")
+		Expect(err).NotTo(HaveOccurred())
+		loader = libcni.NewConfLoader(configDir)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(configDir)).To(Succeed())
+	})
+
+	It("loads lists and single configurations in file name order", func() {
+		writeConf("20-second.conflist", `{ "name": "second", "plugins": [ { "type": "bridge" }, { "type": "tuning" } ] }`)
+		writeConf("10-first.conf", `{ "name": "first", "cniVersion": "0.2.0", "type": "ptp" }`)
+		writeConf("30-third.json", `{ "name": "third", "type": "macvlan" }`)
+		writeConf("40-ignored.txt", `{ "name": "ignored", "type": "macvlan" }`)
+
+		lists, err := loader.Load()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names(lists)).To(Equal([]string{"first", "second", "third"}))
+
+		Expect(lists[0].CNIVersion).To(Equal("0.2.0"))
+		Expect(lists[0].Plugins).To(HaveLen(1))
+		Expect(lists[0].Plugins[0].Network.Type).To(Equal("ptp"))
+		Expect(lists[1].Plugins).To(HaveLen(2))
+	})
+
+	It("ignores later files that reuse a network name", func() {
+		writeConf("10-a.conf", `{ "name": "net", "type": "ptp" }`)
+		writeConf("20-b.conf", `{ "name": "net", "type": "bridge" }`)
+
+		lists, err := loader.Load()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lists).To(HaveLen(1))
+		Expect(lists[0].Plugins[0].Network.Type).To(Equal("ptp"))
+	})
+
+	It("returns an error for invalid files", func() {
+		writeConf("10-a.conf", `{{{`)
+
+		_, err := loader.Load()
+		Expect(err).To(MatchError(ContainSubstring("error parsing configuration")))
+	})
+
+	It("names a network without a name after its file", func() {
+		writeConf("10-legacy.conf", `{ "type": "ptp" }`)
+		writeConf("20-list.conflist", `{ "plugins": [ { "type": "bridge" } ] }`)
+
+		lists, err := loader.Load()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names(lists)).To(Equal([]string{"10-legacy", "20-list"}))
+		Expect(string(lists[1].Bytes)).To(ContainSubstring(`"name":"20-list"`))
+		Expect(lists[0].CNIVersion).To(BeEmpty())
+	})
+
+	It("gives configurations without a cniVersion the default version", func() {
+		loader.DefaultCNIVersion = "0.3.0"
+		writeConf("10-old.conf", `{ "name": "old", "type": "ptp" }`)
+		writeConf("20-new.conf", `{ "name": "new", "cniVersion": "0.2.0", "type": "ptp" }`)
+
+		lists, err := loader.Load()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lists[0].CNIVersion).To(Equal("0.3.0"))
+		Expect(string(lists[0].Bytes)).To(ContainSubstring(`"cniVersion":"0.3.0"`))
+		Expect(lists[1].CNIVersion).To(Equal("0.2.0"))
+	})
+
+	It("rejects configurations without a name or cniVersion in strict mode", func() {
+		loader.Strict = true
+		writeConf("10-a.conf", `{ "cniVersion": "0.3.0", "type": "ptp" }`)
+
+		_, err := loader.Load()
+		Expect(err).To(MatchError(filepath.Join(configDir, "10-a.conf") + ": missing network name"))
+
+		writeConf("10-a.conf", `{ "name": "a", "type": "ptp" }`)
+		_, err = loader.Load()
+		Expect(err).To(MatchError(filepath.Join(configDir, "10-a.conf") + ": missing cniVersion"))
+	})
+
+	It("returns no lists for a missing directory", func() {
+		lists, err := libcni.NewConfLoader(filepath.Join(configDir, "missing")).Load()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lists).To(BeEmpty())
+	})
+
+	It("reloads when the directory changes", func() {
+		changes := make(chan []string, 10)
+		stop := make(chan struct{})
+		done := make(chan error)
+		go func() {
+			done <- loader.Watch(stop, func(lists []*libcni.NetworkConfigList, err error) {
+				if err != nil {
+					changes <- []string{err.Error()}
+					return
+				}
+				changes <- names(lists)
+			})
+		}()
+
+		Eventually(changes).Should(Receive(BeEmpty()))
+
+		writeConf("10-a.conf", `{ "name": "net", "type": "ptp" }`)
+		Eventually(changes).Should(Receive(Equal([]string{"net"})))
+
+		close(stop)
+		Eventually(done).Should(Receive(BeNil()))
+	})
+})
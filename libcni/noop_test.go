@@ -0,0 +1,121 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/libcni"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/plugins/test/noop/debug"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Running the noop plugin", func() {
+	var (
+		cniConfig *libcni.CNIConfig
+		rt        *libcni.RuntimeConf
+		tmpDir    string
+		debugFile string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "libcni-noop")
+		Expect(err).NotTo(HaveOccurred())
+		debugFile = filepath.Join(tmpDir, "debug")
+
+		cniConfig = libcni.NewCNIConfig([]string{noopPluginDir}, nil)
+		rt = &libcni.RuntimeConf{
+			ContainerID: "some-container",
+			NetNS:       "/proc/self/ns/net",
+			IfName:      "eth0",
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	list := func(second string) *libcni.NetworkConfigList {
+		list, err := libcni.ConfListFromBytes([]byte(fmt.Sprintf(`{
+			"cniVersion": "0.2.0",
+			"name": "test",
+			"plugins": [
+				{"type": "noop", "debugFile": %q, "result": {"ip4": {"ip": "10.1.2.3/24"}}},
+				{"type": "noop", "debugFile": %q %s}
+			]
+		}`, debugFile, debugFile, second)))
+		Expect(err).NotTo(HaveOccurred())
+		return list
+	}
+
+	It("passes the result of each plugin to the next one", func() {
+		result, err := cniConfig.AddNetworkList(list(""), rt)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IP4.IP.String()).To(Equal("10.1.2.3/24"))
+
+		records, err := debug.ReadRecords(debugFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(2))
+		Expect(records[0].Command).To(Equal("ADD"))
+		Expect(records[0].ContainerID).To(Equal("some-container"))
+		Expect(records[0].IfName).To(Equal("eth0"))
+		Expect(records[1].Command).To(Equal("ADD"))
+		Expect(records[1].Stdin).To(ContainSubstring(`"prevResult":{"ip4":{"ip":"10.1.2.3/24"}`))
+	})
+
+	It("rolls back the plugins that succeeded when a later one fails", func() {
+		_, err := cniConfig.AddNetworkList(list(`, "error": {"code": 11, "msg": "busy"}, "failOn": ["ADD"]`), rt)
+		Expect(err).To(HaveOccurred())
+
+		cniErr := &types.Error{}
+		Expect(errors.As(err, &cniErr)).To(BeTrue())
+		Expect(cniErr.Code).To(Equal(types.ErrTryAgainLater))
+		Expect(cniErr.Msg).To(Equal("busy"))
+
+		records, err := debug.ReadRecords(debugFile)
+		Expect(err).NotTo(HaveOccurred())
+		commands := []string{}
+		for _, r := range records {
+			commands = append(commands, r.Command)
+		}
+		Expect(commands).To(Equal([]string{"ADD", "ADD", "DEL"}))
+	})
+
+	It("describes the plugin on ABOUT", func() {
+		about, err := cniConfig.AboutPlugin("noop")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(about.Name).To(Equal("noop"))
+		Expect(about.SupportedVersions).To(Equal([]string{"0.1.0", "0.2.0", "0.3.0"}))
+		Expect(about.Commands).To(Equal([]string{"ADD", "DEL", "VERSION", "ABOUT"}))
+		Expect(about.Config["debugFile"].Type).To(Equal("string"))
+	})
+
+	It("runs DEL on the plugins in reverse order", func() {
+		Expect(cniConfig.DelNetworkList(list(`, "result": {"ip4": {"ip": "10.9.9.9/24"}}`), rt)).To(Succeed())
+
+		records, err := debug.ReadRecords(debugFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(2))
+		Expect(records[0].Stdin).To(ContainSubstring("10.9.9.9/24"))
+		Expect(records[1].Stdin).To(ContainSubstring("10.1.2.3/24"))
+	})
+})
@@ -0,0 +1,129 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// InjectConf returns a copy of original with the given top-level keys set
+// to the given values. Every other key of the original JSON, including
+// ones libcni does not know about, is preserved.
+func InjectConf(original *NetworkConfig, newValues map[string]interface{}) (*NetworkConfig, error) {
+	config := make(map[string]interface{})
+	if err := json.Unmarshal(original.Bytes, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal existing network bytes: %s", err)
+	}
+
+	for key, value := range newValues {
+		if key == "" {
+			return nil, fmt.Errorf("keys cannot be empty")
+		}
+		if value == nil {
+			return nil, fmt.Errorf("key '%s' value must not be nil", key)
+		}
+		config[key] = value
+	}
+
+	newBytes, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return ConfFromBytes(newBytes)
+}
+
+// ApplyOverrides returns a copy of original with overrides merged into it.
+// Unlike InjectConf, dictionaries are merged recursively, so for example
+//
+//	{"ipam": {"subnet": "10.1.2.0/24"}}
+//
+// replaces only the IPAM subnet. A nil value removes the key. Keys that
+// are not overridden are preserved as they are.
+func ApplyOverrides(original *NetworkConfig, overrides map[string]interface{}) (*NetworkConfig, error) {
+	config := make(map[string]interface{})
+	if err := json.Unmarshal(original.Bytes, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal existing network bytes: %s", err)
+	}
+
+	// round-trip the overrides through JSON so that structs and typed
+	// maps merge the same way as plain dictionaries
+	overrideBytes, err := json.Marshal(overrides)
+	if err != nil {
+		return nil, fmt.Errorf("marshal overrides: %s", err)
+	}
+	var rawOverrides map[string]interface{}
+	if err := json.Unmarshal(overrideBytes, &rawOverrides); err != nil {
+		return nil, fmt.Errorf("unmarshal overrides: %s", err)
+	}
+
+	mergeMaps(config, rawOverrides)
+
+	newBytes, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return ConfFromBytes(newBytes)
+}
+
+// ApplyListOverrides applies overrides with ApplyOverrides to every plugin
+// of the list whose type is pluginType, or to every plugin if pluginType
+// is empty, and returns the resulting list.
+func ApplyListOverrides(list *NetworkConfigList, pluginType string, overrides map[string]interface{}) (*NetworkConfigList, error) {
+	rawList := make(map[string]interface{})
+	if err := json.Unmarshal(list.Bytes, &rawList); err != nil {
+		return nil, fmt.Errorf("unmarshal existing network list bytes: %s", err)
+	}
+
+	plugins := []json.RawMessage{}
+	for _, plugin := range list.Plugins {
+		if pluginType == "" || plugin.Network.Type == pluginType {
+			var err error
+			plugin, err = ApplyOverrides(plugin, overrides)
+			if err != nil {
+				return nil, err
+			}
+		}
+		plugins = append(plugins, plugin.Bytes)
+	}
+	rawList["plugins"] = plugins
+
+	newBytes, err := json.Marshal(rawList)
+	if err != nil {
+		return nil, err
+	}
+
+	return ConfListFromBytes(newBytes)
+}
+
+func mergeMaps(dst, src map[string]interface{}) {
+	for key, value := range src {
+		if value == nil {
+			delete(dst, key)
+			continue
+		}
+
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeMaps(dstMap, srcMap)
+			continue
+		}
+
+		dst[key] = value
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni_test
+
+import (
+	"github.com/containernetworking/cni/libcni"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Overriding configuration", func() {
+	var net *libcni.NetworkConfig
+
+	BeforeEach(func() {
+		var err error
+		net, err = libcni.ConfFromBytes([]byte(`{
+			"name": "mynet",
+			"type": "bridge",
+			"someUnknownField": [1, 2, 3],
+			"ipam": { "type": "host-local", "subnet": "10.1.0.0/16", "dataDir": "/var/lib/cni" }
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("InjectConf", func() {
+		It("replaces top-level keys and preserves the others", func() {
+			newNet, err := libcni.InjectConf(net, map[string]interface{}{
+				"ipam": map[string]string{"type": "dhcp"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newNet.Network.IPAM.Type).To(Equal("dhcp"))
+			Expect(newNet.Bytes).To(MatchJSON(`{
+				"name": "mynet",
+				"type": "bridge",
+				"someUnknownField": [1, 2, 3],
+				"ipam": { "type": "dhcp" }
+			}`))
+		})
+
+		It("rejects nil values", func() {
+			_, err := libcni.InjectConf(net, map[string]interface{}{"ipam": nil})
+			Expect(err).To(MatchError("key 'ipam' value must not be nil"))
+		})
+	})
+
+	Describe("ApplyOverrides", func() {
+		It("merges dictionaries recursively and removes nil keys", func() {
+			newNet, err := libcni.ApplyOverrides(net, map[string]interface{}{
+				"ipam": map[string]interface{}{
+					"subnet":  "10.1.2.0/24",
+					"dataDir": nil,
+				},
+				"mtu": 1400,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newNet.Bytes).To(MatchJSON(`{
+				"name": "mynet",
+				"type": "bridge",
+				"mtu": 1400,
+				"someUnknownField": [1, 2, 3],
+				"ipam": { "type": "host-local", "subnet": "10.1.2.0/24" }
+			}`))
+		})
+	})
+
+	Describe("ApplyListOverrides", func() {
+		It("only overrides plugins of the given type", func() {
+			list, err := libcni.ConfListFromBytes([]byte(`{
+				"name": "mynet",
+				"cniVersion": "0.2.0",
+				"plugins": [
+					{ "type": "bridge", "ipam": { "type": "host-local", "subnet": "10.1.0.0/16" } },
+					{ "type": "tuning", "sysctl": { "a": "b" } }
+				]
+			}`))
+			Expect(err).NotTo(HaveOccurred())
+
+			newList, err := libcni.ApplyListOverrides(list, "bridge", map[string]interface{}{
+				"ipam": map[string]interface{}{"subnet": "10.1.2.0/24"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newList.Bytes).To(MatchJSON(`{
+				"name": "mynet",
+				"cniVersion": "0.2.0",
+				"plugins": [
+					{ "type": "bridge", "ipam": { "type": "host-local", "subnet": "10.1.2.0/24" } },
+					{ "type": "tuning", "sysctl": { "a": "b" } }
+				]
+			}`))
+			Expect(newList.Plugins[0].Network.IPAM.Type).To(Equal("host-local"))
+		})
+	})
+})
@@ -0,0 +1,46 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni
+
+import (
+	"fmt"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/version"
+)
+
+// PrevResult returns the result of the ADD of the attachment of rt to
+// the network, which DEL and CHECK pass to the plugins as prevResult:
+// the one recorded in c.CacheDir or, if there is none, rt.PrevResult,
+// converted from any result version the library understands. It returns
+// nil if neither has one.
+func (c *CNIConfig) PrevResult(network string, rt *RuntimeConf) (*types.Result, error) {
+	a, err := c.GetAttachment(network, rt.ContainerID, rt.IfName)
+	if err != nil {
+		return nil, err
+	}
+	if a != nil && a.Result != nil {
+		return a.Result, nil
+	}
+
+	if len(rt.PrevResult) == 0 {
+		return nil, nil
+	}
+	res, _, err := version.ReconcileResult("0.2.0", rt.PrevResult)
+	if err != nil {
+		return nil, fmt.Errorf("network %q: invalid prevResult of the runtime: %v", network, err)
+	}
+	return res.(*types.Result), nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/containernetworking/cni/libcni"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Previous results", func() {
+	var (
+		exec      *fakeExec
+		cniConfig *libcni.CNIConfig
+		list      *libcni.NetworkConfigList
+		rt        *libcni.RuntimeConf
+		cacheDir  string
+	)
+
+	// stdinPrevResult returns the prevResult the last invocation was given
+	stdinPrevResult := func() interface{} {
+		Expect(exec.invocations).NotTo(BeEmpty())
+		var conf map[string]interface{}
+		Expect(json.Unmarshal(exec.invocations[len(exec.invocations)-1].stdin, &conf)).To(Succeed())
+		return conf["prevResult"]
+	}
+
+	BeforeEach(func() {
+		exec = &fakeExec{
+			versions: map[string][]string{"bridge": {"0.2.0"}},
+			failures: map[string]error{},
+			results:  map[string]string{"bridge": `{ "ip4": { "ip": "10.1.2.3/24" } }`},
+		}
+		cniConfig = libcni.NewCNIConfig([]string{"/some/path"}, exec)
+
+		var err error
+		cacheDir, err = ioutil.TempDir("", "cni-cache")
+		Expect(err).NotTo(HaveOccurred())
+		cniConfig.CacheDir = cacheDir
+
+		rt = &libcni.RuntimeConf{ContainerID: "some-container", NetNS: "/some/netns", IfName: "eth0"}
+		list, err = libcni.ConfListFromBytes([]byte(`{
+			"name": "mynet",
+			"cniVersion": "0.2.0",
+			"plugins": [{ "type": "bridge", "bridge": "br0" }]
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+	})
+
+	It("passes the cached result to DEL and CHECK", func() {
+		_, err := cniConfig.AddNetworkList(list, rt)
+		Expect(err).NotTo(HaveOccurred())
+		// the cache wins over the runtime
+		rt.PrevResult = []byte(`{"ip4": {"ip": "10.9.9.9/24"}}`)
+
+		Expect(cniConfig.CheckNetworkList(list, nil, rt)).To(Succeed())
+		Expect(stdinPrevResult()).To(HaveKeyWithValue("ip4", HaveKeyWithValue("ip", "10.1.2.3/24")))
+
+		Expect(cniConfig.DelNetworkList(list, rt)).To(Succeed())
+		Expect(stdinPrevResult()).To(HaveKeyWithValue("ip4", HaveKeyWithValue("ip", "10.1.2.3/24")))
+	})
+
+	It("falls back to the result of the runtime, in any version", func() {
+		rt.PrevResult = []byte(`{
+			"cniVersion": "0.3.0",
+			"interfaces": [{"name": "eth0"}],
+			"ips": [{"version": "4", "interface": 0, "address": "10.1.2.4/24", "gateway": "10.1.2.1"}]
+		}`)
+
+		result, err := cniConfig.PrevResult("mynet", rt)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IP4.IP.String()).To(Equal("10.1.2.4/24"))
+		Expect(result.IP4.Gateway.String()).To(Equal("10.1.2.1"))
+
+		Expect(cniConfig.CheckNetworkList(list, nil, rt)).To(Succeed())
+		Expect(stdinPrevResult()).To(HaveKeyWithValue("ip4", HaveKeyWithValue("ip", "10.1.2.4/24")))
+
+		Expect(cniConfig.DelNetworkList(list, rt)).To(Succeed())
+		Expect(stdinPrevResult()).To(HaveKeyWithValue("ip4", HaveKeyWithValue("ip", "10.1.2.4/24")))
+	})
+
+	It("rejects an invalid result of the runtime", func() {
+		rt.PrevResult = []byte(`{
+			"cniVersion": "0.3.0",
+			"ips": [{"version": "4", "interface": 2, "address": "10.1.2.4/24"}]
+		}`)
+
+		err := cniConfig.DelNetworkList(list, rt)
+		Expect(err).To(MatchError(ContainSubstring(`network "mynet": invalid prevResult of the runtime: invalid plugin result: ips[0]: interface index 2 out of range`)))
+		Expect(exec.invocations).To(BeEmpty())
+	})
+
+	It("deletes without a prevResult when none is known", func() {
+		Expect(cniConfig.DelNetworkList(list, rt)).To(Succeed())
+		Expect(stdinPrevResult()).To(BeNil())
+
+		Expect(cniConfig.CheckNetworkList(list, nil, rt)).To(MatchError(`network "mynet": CHECK requires the result of ADD`))
+	})
+})
@@ -0,0 +1,143 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libcni_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/libcni"
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/plugins/test/noop/debug"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+)
+
+type countingExec struct {
+	invoke.RawExec
+	calls int
+}
+
+func (e *countingExec) ExecPlugin(pluginPath string, stdinData []byte, environ []string) ([]byte, error) {
+	e.calls++
+	return e.RawExec.ExecPlugin(pluginPath, stdinData, environ)
+}
+
+var _ = Describe("Invoking plugins over a unix socket", func() {
+	var (
+		tmpDir    string
+		debugFile string
+		fallback  *countingExec
+		cniConfig *libcni.CNIConfig
+		rt        *libcni.RuntimeConf
+		conf      *libcni.NetworkConfig
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "libcni-socket")
+		Expect(err).NotTo(HaveOccurred())
+		debugFile = filepath.Join(tmpDir, "debug")
+
+		fallback = &countingExec{RawExec: invoke.RawExec{Stderr: GinkgoWriter}}
+		cniConfig = libcni.NewCNIConfig([]string{noopPluginDir}, &invoke.SocketExec{
+			SocketDir: tmpDir,
+			Fallback:  fallback,
+		})
+		rt = &libcni.RuntimeConf{
+			ContainerID: "some-container",
+			NetNS:       "/proc/self/ns/net",
+			IfName:      "eth0",
+		}
+		conf, err = libcni.ConfFromBytes([]byte(fmt.Sprintf(`{
+			"cniVersion": "0.2.0",
+			"name": "test",
+			"type": "noop",
+			"debugFile": %q,
+			"result": {"ip4": {"ip": "10.1.2.3/24"}}
+		}`, debugFile)))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("executes the plugin binary when no daemon is serving", func() {
+		result, err := cniConfig.AddNetwork(conf, rt)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IP4.IP.String()).To(Equal("10.1.2.3/24"))
+		Expect(fallback.calls).To(Equal(1))
+	})
+
+	Context("when the plugin runs as a daemon", func() {
+		var session *gexec.Session
+
+		BeforeEach(func() {
+			socketPath := filepath.Join(tmpDir, "noop.sock")
+			cmd := exec.Command(filepath.Join(noopPluginDir, "noop"), "serve", socketPath)
+			var err error
+			session, err = gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func() error {
+				_, err := os.Stat(socketPath)
+				return err
+			}).Should(Succeed())
+		})
+
+		AfterEach(func() {
+			session.Terminate().Wait()
+		})
+
+		It("sends ADD and DEL to the daemon", func() {
+			result, err := cniConfig.AddNetwork(conf, rt)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.IP4.IP.String()).To(Equal("10.1.2.3/24"))
+			Expect(cniConfig.DelNetwork(conf, rt)).To(Succeed())
+			Expect(fallback.calls).To(Equal(0))
+
+			records, err := debug.ReadRecords(debugFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(records).To(HaveLen(2))
+			Expect(records[0].Command).To(Equal("ADD"))
+			Expect(records[0].ContainerID).To(Equal("some-container"))
+			Expect(records[0].IfName).To(Equal("eth0"))
+			Expect(records[1].Command).To(Equal("DEL"))
+		})
+
+		It("returns the errors of the daemon", func() {
+			conf, err := libcni.ConfFromBytes([]byte(`{
+				"cniVersion": "0.2.0",
+				"name": "test",
+				"type": "noop",
+				"error": {"code": 11, "msg": "busy"}
+			}`))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = cniConfig.AddNetwork(conf, rt)
+			Expect(err).To(MatchError(ContainSubstring("busy")))
+			Expect(fallback.calls).To(Equal(0))
+		})
+
+		It("removes the socket when it stops", func() {
+			session.Terminate().Wait()
+			Expect(filepath.Join(tmpDir, "noop.sock")).NotTo(BeAnExistingFile())
+		})
+	})
+})
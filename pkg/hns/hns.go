@@ -0,0 +1,166 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hns is a client of the Host Networking Service, through which
+// containers are attached to networks on Windows. Like pkg/ns elsewhere,
+// it builds on every platform, but fails with ErrNotImplemented outside
+// Windows.
+package hns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrNotImplemented is returned by the functions of this package on
+// platforms without HNS
+var ErrNotImplemented = errors.New("HNS is not implemented on this platform")
+
+// Network is an HNS network, which the administrator of the host sets
+// up before containers are attached to it
+type Network struct {
+	ID                 string   `json:"ID,omitempty"`
+	Name               string   `json:",omitempty"`
+	Type               string   `json:",omitempty"`
+	NetworkAdapterName string   `json:",omitempty"`
+	Subnets            []Subnet `json:",omitempty"`
+}
+
+// Subnet is an address range of a network
+type Subnet struct {
+	AddressPrefix  string `json:",omitempty"`
+	GatewayAddress string `json:",omitempty"`
+}
+
+// Endpoint is the attachment of a container to a network
+type Endpoint struct {
+	ID             string `json:"ID,omitempty"`
+	Name           string `json:",omitempty"`
+	VirtualNetwork string `json:",omitempty"`
+	// Policies are passed to HNS as they are, e.g. to NAT the outbound
+	// traffic of the container
+	Policies       []json.RawMessage `json:",omitempty"`
+	MacAddress     string            `json:",omitempty"`
+	IPAddress      net.IP            `json:",omitempty"`
+	PrefixLength   uint8             `json:",omitempty"`
+	GatewayAddress string            `json:",omitempty"`
+	DNSSuffix      string            `json:",omitempty"`
+	DNSServerList  string            `json:",omitempty"`
+}
+
+// attachRequest attaches an endpoint to a container, or detaches it
+type attachRequest struct {
+	ContainerID string `json:"ContainerId"`
+	SystemType  string `json:"SystemType"`
+}
+
+// response is what HNS answers to every call
+type response struct {
+	Success bool
+	Error   string
+	Output  json.RawMessage
+}
+
+// call is hnsCall, replaced by tests
+var call = hnsCall
+
+// request runs method on the HNS object at path with in as its body, if
+// given, and decodes the output of HNS into out, if given
+func request(method, path string, in, out interface{}) error {
+	body := ""
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = string(b)
+	}
+
+	raw, err := call(method, path, body)
+	if err != nil {
+		return err
+	}
+	resp := response{}
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return fmt.Errorf("failed to parse HNS response %q: %v", raw, err)
+	}
+	if !resp.Success {
+		return fmt.Errorf("HNS %s %s failed: %s", method, path, resp.Error)
+	}
+	if out == nil || len(resp.Output) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Output, out); err != nil {
+		return fmt.Errorf("failed to parse HNS output %q: %v", resp.Output, err)
+	}
+	return nil
+}
+
+// NetworkByName returns the network called name
+func NetworkByName(name string) (*Network, error) {
+	var networks []Network
+	if err := request("GET", "/networks/", nil, &networks); err != nil {
+		return nil, err
+	}
+	for i := range networks {
+		if networks[i].Name == name {
+			return &networks[i], nil
+		}
+	}
+	return nil, fmt.Errorf("HNS network %q not found", name)
+}
+
+// EndpointByName returns the endpoint called name, or nil if there is
+// no such endpoint
+func EndpointByName(name string) (*Endpoint, error) {
+	var endpoints []Endpoint
+	if err := request("GET", "/endpoints/", nil, &endpoints); err != nil {
+		return nil, err
+	}
+	for i := range endpoints {
+		if endpoints[i].Name == name {
+			return &endpoints[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// CreateEndpoint creates ep, and returns it as HNS filled it in, with
+// its ID and MAC address
+func CreateEndpoint(ep *Endpoint) (*Endpoint, error) {
+	created := &Endpoint{}
+	if err := request("POST", "/endpoints/", ep, created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// DeleteEndpoint deletes the endpoint with the ID id
+func DeleteEndpoint(id string) error {
+	return request("DELETE", "/endpoints/"+id, nil, nil)
+}
+
+// AttachEndpoint attaches the endpoint with the ID id to the container
+// containerID
+func AttachEndpoint(id, containerID string) error {
+	return request("POST", "/endpoints/"+id+"/attach", &attachRequest{ContainerID: containerID, SystemType: "Container"}, nil)
+}
+
+// DetachEndpoint detaches the endpoint with the ID id from the container
+// containerID
+func DetachEndpoint(id, containerID string) error {
+	return request("POST", "/endpoints/"+id+"/detach", &attachRequest{ContainerID: containerID, SystemType: "Container"}, nil)
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hns
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HNS client", func() {
+	type hnsRequest struct {
+		method, path, body string
+	}
+	var requests []hnsRequest
+	var responses map[string]string
+
+	BeforeEach(func() {
+		requests = nil
+		responses = map[string]string{}
+		call = func(method, path, body string) ([]byte, error) {
+			requests = append(requests, hnsRequest{method, path, body})
+			return []byte(responses[method+" "+path]), nil
+		}
+	})
+
+	AfterEach(func() {
+		call = hnsCall
+	})
+
+	It("finds a network by name", func() {
+		responses["GET /networks/"] = `{"Success": true, "Output": [
+			{"ID": "1", "Name": "nat", "Type": "NAT"},
+			{"ID": "2", "Name": "cbr0", "Type": "L2Bridge", "Subnets": [{"AddressPrefix": "10.1.2.0/24", "GatewayAddress": "10.1.2.1"}]}
+		]}`
+
+		n, err := NetworkByName("cbr0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(&Network{ID: "2", Name: "cbr0", Type: "L2Bridge", Subnets: []Subnet{{AddressPrefix: "10.1.2.0/24", GatewayAddress: "10.1.2.1"}}}))
+
+		_, err = NetworkByName("other")
+		Expect(err).To(MatchError(`HNS network "other" not found`))
+	})
+
+	It("returns nil for an endpoint that does not exist", func() {
+		responses["GET /endpoints/"] = `{"Success": true, "Output": [{"ID": "1", "Name": "other_cbr0"}]}`
+
+		ep, err := EndpointByName("dummy_cbr0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ep).To(BeNil())
+	})
+
+	It("creates, attaches and deletes an endpoint", func() {
+		responses["POST /endpoints/"] = `{"Success": true, "Output": {"ID": "ep1", "Name": "dummy_cbr0", "MacAddress": "00-15-5D-01-02-03"}}`
+		responses["POST /endpoints/ep1/attach"] = `{"Success": true}`
+		responses["DELETE /endpoints/ep1"] = `{"Success": true}`
+
+		ep, err := CreateEndpoint(&Endpoint{Name: "dummy_cbr0", VirtualNetwork: "2", IPAddress: net.ParseIP("10.1.2.3"), PrefixLength: 24})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ep.ID).To(Equal("ep1"))
+		Expect(ep.MacAddress).To(Equal("00-15-5D-01-02-03"))
+		Expect(AttachEndpoint(ep.ID, "dummy")).To(Succeed())
+		Expect(DeleteEndpoint(ep.ID)).To(Succeed())
+
+		Expect(requests).To(Equal([]hnsRequest{
+			{"POST", "/endpoints/", `{"Name":"dummy_cbr0","VirtualNetwork":"2","IPAddress":"10.1.2.3","PrefixLength":24}`},
+			{"POST", "/endpoints/ep1/attach", `{"ContainerId":"dummy","SystemType":"Container"}`},
+			{"DELETE", "/endpoints/ep1", ""},
+		}))
+	})
+
+	It("reports the errors of HNS", func() {
+		responses["DELETE /endpoints/ep1"] = `{"Success": false, "Error": "Element not found."}`
+
+		Expect(DeleteEndpoint("ep1")).To(MatchError("HNS DELETE /endpoints/ep1 failed: Element not found."))
+	})
+})
@@ -0,0 +1,76 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hns
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modvmcompute = syscall.NewLazyDLL("vmcompute.dll")
+	procHNSCall  = modvmcompute.NewProc("HNSCall")
+
+	modole32          = syscall.NewLazyDLL("ole32.dll")
+	procCoTaskMemFree = modole32.NewProc("CoTaskMemFree")
+)
+
+// hnsCall calls HNSCall of vmcompute.dll, which takes and returns JSON
+func hnsCall(method, path, request string) ([]byte, error) {
+	if err := procHNSCall.Find(); err != nil {
+		return nil, fmt.Errorf("HNS is not available: %v", err)
+	}
+	m, err := syscall.UTF16PtrFromString(method)
+	if err != nil {
+		return nil, err
+	}
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := syscall.UTF16PtrFromString(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var out *uint16
+	hr, _, _ := procHNSCall.Call(
+		uintptr(unsafe.Pointer(m)),
+		uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(r)),
+		uintptr(unsafe.Pointer(&out)),
+	)
+	if out != nil {
+		// HNS allocates the response with the COM allocator
+		defer procCoTaskMemFree.Call(uintptr(unsafe.Pointer(out)))
+	}
+	if int32(hr) < 0 {
+		return nil, fmt.Errorf("HNS %s %s failed: HRESULT 0x%08x", method, path, uint32(hr))
+	}
+	return []byte(utf16PtrToString(out)), nil
+}
+
+// utf16PtrToString converts the NUL-terminated string at p
+func utf16PtrToString(p *uint16) string {
+	if p == nil {
+		return ""
+	}
+	n := 0
+	for *(*uint16)(unsafe.Add(unsafe.Pointer(p), 2*n)) != 0 {
+		n++
+	}
+	return syscall.UTF16ToString(unsafe.Slice(p, n))
+}
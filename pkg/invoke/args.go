@@ -45,10 +45,14 @@ type Args struct {
 	PluginArgsStr string
 	IfName        string
 	Path          string
+
+	// Inherit selects the variables of the environment of this process
+	// passed on to the plugin besides the CNI_* ones; nil passes them all
+	Inherit func(key string) bool
 }
 
 func (args *Args) AsEnv() []string {
-	env := os.Environ()
+	env := inheritEnv(args.Inherit)
 	pluginArgsStr := args.PluginArgsStr
 	if pluginArgsStr == "" {
 		pluginArgsStr = stringify(args.PluginArgs)
@@ -64,6 +68,23 @@ func (args *Args) AsEnv() []string {
 	return env
 }
 
+// inheritEnv returns the CNI_* variables of the environment of this
+// process and the ones inherit selects, or all of them if inherit is nil
+func inheritEnv(inherit func(key string) bool) []string {
+	if inherit == nil {
+		return os.Environ()
+	}
+
+	env := []string{}
+	for _, kv := range os.Environ() {
+		key := strings.SplitN(kv, "=", 2)[0]
+		if strings.HasPrefix(key, "CNI_") || inherit(key) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
 // taken from rkt/networking/net_plugin.go
 func stringify(pluginArgs [][2]string) string {
 	entries := make([]string, len(pluginArgs))
@@ -17,37 +17,140 @@ package invoke
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/containernetworking/cni/pkg/types"
 )
 
-func DelegateAdd(delegatePlugin string, netconf []byte) (*types.Result, error) {
-	if os.Getenv("CNI_COMMAND") != "ADD" {
-		return nil, fmt.Errorf("CNI_COMMAND is not ADD")
+// DelegateOptions controls how a meta-plugin runs its delegate.
+// The zero value behaves like the plain DelegateAdd/DelegateDel helpers.
+type DelegateOptions struct {
+	// Path lists the directories searched for the delegate plugin and is
+	// passed on to it as CNI_PATH. If empty, CNI_PATH of this process is used.
+	Path []string
+
+	// Env sets or overrides environment variables of the delegate, e.g.
+	// {"CNI_IFNAME": "eth1"}. Everything else is inherited from this process.
+	Env map[string]string
+
+	// Exec runs the delegate; nil selects the default RawExec.
+	Exec Exec
+}
+
+// getenv returns the value of key as the delegate will see it
+func (o *DelegateOptions) getenv(key string) string {
+	if v, ok := o.Env[key]; ok {
+		return v
 	}
+	return os.Getenv(key)
+}
 
-	paths := strings.Split(os.Getenv("CNI_PATH"), ":")
+func (o *DelegateOptions) paths() []string {
+	if len(o.Path) > 0 {
+		return o.Path
+	}
+	return filepath.SplitList(o.getenv("CNI_PATH"))
+}
 
-	pluginPath, err := FindInPath(delegatePlugin, paths)
-	if err != nil {
-		return nil, err
+func (o *DelegateOptions) exec() Exec {
+	if o.Exec == nil {
+		return defaultExec
 	}
+	return o.Exec
+}
 
-	return ExecPluginWithResult(pluginPath, netconf, ArgsFromEnv())
+// AsEnv returns the environment of this process with the overrides
+// applied, or nil to simply inherit it when there are none.
+func (o *DelegateOptions) AsEnv() []string {
+	overrides := map[string]string{}
+	for k, v := range o.Env {
+		overrides[k] = v
+	}
+	if len(o.Path) > 0 {
+		if _, ok := overrides["CNI_PATH"]; !ok {
+			overrides["CNI_PATH"] = strings.Join(o.Path, string(os.PathListSeparator))
+		}
+	}
+	if len(overrides) == 0 {
+		return nil
+	}
+
+	env := []string{}
+	for _, kv := range os.Environ() {
+		if _, ok := overrides[strings.SplitN(kv, "=", 2)[0]]; !ok {
+			env = append(env, kv)
+		}
+	}
+
+	keys := make([]string, 0, len(overrides))
+	for k := range overrides {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, k+"="+overrides[k])
+	}
+	return env
+}
+
+func (o *DelegateOptions) find(command, delegatePlugin string) (string, error) {
+	if c := o.getenv("CNI_COMMAND"); c != command {
+		return "", fmt.Errorf("CNI_COMMAND is not %s", command)
+	}
+
+	return o.exec().FindInPath(delegatePlugin, o.paths())
+}
+
+func DelegateAdd(delegatePlugin string, netconf []byte) (*types.Result, error) {
+	return DelegateAddWithOptions(delegatePlugin, netconf, nil)
 }
 
 func DelegateDel(delegatePlugin string, netconf []byte) error {
-	if os.Getenv("CNI_COMMAND") != "DEL" {
-		return fmt.Errorf("CNI_COMMAND is not DEL")
+	return DelegateDelWithOptions(delegatePlugin, netconf, nil)
+}
+
+func DelegateCheck(delegatePlugin string, netconf []byte) error {
+	return DelegateCheckWithOptions(delegatePlugin, netconf, nil)
+}
+
+// DelegateAddWithOptions runs the ADD command of delegatePlugin and returns
+// its decoded result. A nil opts is the same as the zero DelegateOptions.
+func DelegateAddWithOptions(delegatePlugin string, netconf []byte, opts *DelegateOptions) (*types.Result, error) {
+	if opts == nil {
+		opts = &DelegateOptions{}
+	}
+
+	pluginPath, err := opts.find("ADD", delegatePlugin)
+	if err != nil {
+		return nil, err
 	}
 
-	paths := strings.Split(os.Getenv("CNI_PATH"), ":")
+	return ExecPluginWithResult(pluginPath, netconf, opts, opts.exec())
+}
+
+// DelegateDelWithOptions runs the DEL command of delegatePlugin.
+// A nil opts is the same as the zero DelegateOptions.
+func DelegateDelWithOptions(delegatePlugin string, netconf []byte, opts *DelegateOptions) error {
+	return delegateWithoutResult("DEL", delegatePlugin, netconf, opts)
+}
+
+// DelegateCheckWithOptions runs the CHECK command of delegatePlugin.
+// A nil opts is the same as the zero DelegateOptions.
+func DelegateCheckWithOptions(delegatePlugin string, netconf []byte, opts *DelegateOptions) error {
+	return delegateWithoutResult("CHECK", delegatePlugin, netconf, opts)
+}
+
+func delegateWithoutResult(command, delegatePlugin string, netconf []byte, opts *DelegateOptions) error {
+	if opts == nil {
+		opts = &DelegateOptions{}
+	}
 
-	pluginPath, err := FindInPath(delegatePlugin, paths)
+	pluginPath, err := opts.find(command, delegatePlugin)
 	if err != nil {
 		return err
 	}
 
-	return ExecPluginWithoutResult(pluginPath, netconf, ArgsFromEnv())
+	return ExecPluginWithoutResult(pluginPath, netconf, opts, opts.exec())
 }
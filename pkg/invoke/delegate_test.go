@@ -0,0 +1,82 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package invoke_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Delegating to another plugin", func() {
+	var (
+		exec      *fakeExec
+		pluginDir string
+		opts      *invoke.DelegateOptions
+	)
+
+	BeforeEach(func() {
+		var err error
+		pluginDir, err = ioutil.TempDir("", "delegate")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(filepath.Join(pluginDir, "delegate"), nil, 0700)).To(Succeed())
+
+		exec = &fakeExec{stdout: []byte(`{ "ip4": { "ip": "1.2.3.4/24" } }`)}
+		opts = &invoke.DelegateOptions{
+			Path: []string{pluginDir},
+			Env:  map[string]string{"CNI_COMMAND": "ADD", "CNI_IFNAME": "eth1"},
+			Exec: exec,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(pluginDir)).To(Succeed())
+	})
+
+	It("finds the delegate in the given path and decodes its result", func() {
+		result, err := invoke.DelegateAddWithOptions("delegate", []byte(`{"some":"config"}`), opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IP4.IP.String()).To(Equal("1.2.3.4/24"))
+
+		Expect(exec.pluginPath).To(Equal(filepath.Join(pluginDir, "delegate")))
+		Expect(exec.stdinData).To(MatchJSON(`{"some":"config"}`))
+	})
+
+	It("applies the environment overrides and passes the path as CNI_PATH", func() {
+		_, err := invoke.DelegateAddWithOptions("delegate", nil, opts)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(exec.environ).To(ContainElement("CNI_COMMAND=ADD"))
+		Expect(exec.environ).To(ContainElement("CNI_IFNAME=eth1"))
+		Expect(exec.environ).To(ContainElement("CNI_PATH=" + pluginDir))
+	})
+
+	It("refuses to delegate a command other than the current one", func() {
+		err := invoke.DelegateDelWithOptions("delegate", nil, opts)
+		Expect(err).To(MatchError("CNI_COMMAND is not DEL"))
+		Expect(exec.pluginPath).To(BeEmpty())
+	})
+
+	It("delegates CHECK", func() {
+		opts.Env["CNI_COMMAND"] = "CHECK"
+		err := invoke.DelegateCheckWithOptions("delegate", nil, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(exec.environ).To(ContainElement("CNI_COMMAND=CHECK"))
+	})
+})
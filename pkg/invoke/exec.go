@@ -18,58 +18,237 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/version"
 )
 
+// Exec abstracts the execution of plugin binaries. CNIConfig and the
+// delegation helpers use it instead of calling os/exec directly, so that
+// runtimes and tests can run plugins in-process, record invocations or
+// apply their own sandboxing around the child process.
+type Exec interface {
+	ExecPlugin(pluginPath string, stdinData []byte, environ []string) ([]byte, error)
+	FindInPath(plugin string, paths []string) (string, error)
+	Decode(jsonBytes []byte) (*types.Result, error)
+}
+
+// DefaultMaxOutput is how much a RawExec keeps of the stdout and of the
+// stderr of a plugin unless told otherwise
+const DefaultMaxOutput = 1 << 20
+
+// stderrTailSize is how much of the end of the stderr of a failed plugin
+// is added to its error
+const stderrTailSize = 4096
+
+// RawExec is the default Exec implementation; it runs the plugin binary
+// as a child process.
+type RawExec struct {
+	// Stderr, if set, is given what plugins write to their stderr
+	Stderr io.Writer
+
+	// MaxOutput caps how many bytes of its stdout and of its stderr a
+	// plugin may write; zero selects DefaultMaxOutput. A plugin going
+	// over it on stdout fails, the rest of its stderr is dropped.
+	MaxOutput int
+}
+
+var defaultExec = &RawExec{Stderr: os.Stderr}
+
+func (e *RawExec) ExecPlugin(pluginPath string, stdinData []byte, environ []string) ([]byte, error) {
+	max := e.MaxOutput
+	if max <= 0 {
+		max = DefaultMaxOutput
+	}
+	stdout := &bytes.Buffer{}
+	capped := &cappedWriter{w: stdout, left: max}
+	tail := &tailBuffer{max: stderrTailSize}
+	var stderr io.Writer = tail
+	if e.Stderr != nil {
+		stderr = io.MultiWriter(&cappedWriter{w: e.Stderr, left: max}, tail)
+	}
+
+	c := exec.Cmd{
+		Env:    environ,
+		Path:   pluginPath,
+		Args:   []string{pluginPath},
+		Stdin:  bytes.NewBuffer(stdinData),
+		Stdout: capped,
+		Stderr: stderr,
+	}
+	err := c.Run()
+	if capped.dropped > 0 {
+		err = fmt.Errorf("plugin wrote more than %d bytes to stdout", max)
+	}
+	if err != nil {
+		return nil, withStderr(pluginErr(err, stdout.Bytes()), tail.String())
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func (e *RawExec) FindInPath(plugin string, paths []string) (string, error) {
+	return FindInPath(plugin, paths)
+}
+
+// Decode accepts a result in any version the library understands and
+// converts it to the legacy format of 0.2.0, reporting on Stderr whatever
+// that cannot express.
+func (e *RawExec) Decode(jsonBytes []byte) (*types.Result, error) {
+	res, warnings, err := version.ReconcileResult("0.2.0", jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+	if e.Stderr != nil {
+		for _, w := range warnings {
+			fmt.Fprintf(e.Stderr, "plugin result: %s\n", w)
+		}
+	}
+	return res.(*types.Result), nil
+}
+
+// pluginErr returns the *types.Error printed by a plugin that exited with a
+// failure, so that callers can inspect its code
 func pluginErr(err error, output []byte) error {
 	if _, ok := err.(*exec.ExitError); ok {
 		emsg := types.Error{}
 		if perr := json.Unmarshal(output, &emsg); perr != nil {
 			return fmt.Errorf("netplugin failed but error parsing its diagnostic message %q: %v", string(output), perr)
 		}
-		details := ""
-		if emsg.Details != "" {
-			details = fmt.Sprintf("; %v", emsg.Details)
-		}
-		return fmt.Errorf("%v%v", emsg.Msg, details)
+		return &emsg
 	}
 
 	return err
 }
 
-func ExecPluginWithResult(pluginPath string, netconf []byte, args CNIArgs) (*types.Result, error) {
-	stdoutBytes, err := execPlugin(pluginPath, netconf, args)
+// withStderr adds the end of the stderr of a failed plugin to its
+// error; it goes to the details of a *types.Error
+func withStderr(err error, stderr string) error {
+	if stderr == "" {
+		return err
+	}
+	if e, ok := err.(*types.Error); ok {
+		if e.Details != "" {
+			e.Details += "; "
+		}
+		e.Details += "stderr: " + stderr
+		return e
+	}
+	return fmt.Errorf("%w; stderr: %s", err, stderr)
+}
+
+// ExecPluginWithResult runs the plugin through exec and decodes its result.
+// A nil exec selects the default RawExec implementation.
+func ExecPluginWithResult(pluginPath string, netconf []byte, args CNIArgs, exec Exec) (*types.Result, error) {
+	if exec == nil {
+		exec = defaultExec
+	}
+
+	stdoutBytes, err := exec.ExecPlugin(pluginPath, netconf, args.AsEnv())
 	if err != nil {
 		return nil, err
 	}
 
-	res := &types.Result{}
-	err = json.Unmarshal(stdoutBytes, res)
-	return res, err
+	return exec.Decode(stdoutBytes)
 }
 
-func ExecPluginWithoutResult(pluginPath string, netconf []byte, args CNIArgs) error {
-	_, err := execPlugin(pluginPath, netconf, args)
+// ExecPluginWithoutResult runs the plugin through exec, discarding its output.
+// A nil exec selects the default RawExec implementation.
+func ExecPluginWithoutResult(pluginPath string, netconf []byte, args CNIArgs, exec Exec) error {
+	if exec == nil {
+		exec = defaultExec
+	}
+
+	_, err := exec.ExecPlugin(pluginPath, netconf, args.AsEnv())
 	return err
 }
 
-func execPlugin(pluginPath string, netconf []byte, args CNIArgs) ([]byte, error) {
-	stdout := &bytes.Buffer{}
+// GetVersionInfo asks the plugin which versions of the CNI spec it
+// supports. Plugins that predate the VERSION command are reported as
+// supporting the legacy versions only.
+func GetVersionInfo(pluginPath string, exec Exec) (version.PluginInfo, error) {
+	return GetVersionInfoInheriting(pluginPath, nil, exec)
+}
 
-	c := exec.Cmd{
-		Env:    args.AsEnv(),
-		Path:   pluginPath,
-		Args:   []string{pluginPath},
-		Stdin:  bytes.NewBuffer(netconf),
-		Stdout: stdout,
-		Stderr: os.Stderr,
+// GetVersionInfoInheriting is GetVersionInfo passing the plugin only the
+// variables of the environment of this process that inherit selects, as
+// Args.Inherit does.
+func GetVersionInfoInheriting(pluginPath string, inherit func(key string) bool, exec Exec) (version.PluginInfo, error) {
+	if exec == nil {
+		exec = defaultExec
 	}
-	if err := c.Run(); err != nil {
-		return nil, pluginErr(err, stdout.Bytes())
+
+	args := &Args{
+		Command: "VERSION",
+
+		// set fake values required by plugins built against an older version of skel
+		NetNS:  "dummy",
+		IfName: "dummy",
+		Path:   "dummy",
+
+		Inherit: inherit,
+	}
+	stdin := []byte(fmt.Sprintf(`{"cniVersion":%q}`, version.Current()))
+	stdoutBytes, err := exec.ExecPlugin(pluginPath, stdin, args.AsEnv())
+	if err != nil {
+		if e, ok := err.(*types.Error); ok && e.Msg == "unknown CNI_COMMAND: VERSION" {
+			return version.Legacy, nil
+		}
+		return nil, err
 	}
 
-	return stdout.Bytes(), nil
+	return (&version.PluginDecoder{}).Decode(stdoutBytes)
+}
+
+// GetAbout asks the plugin to describe itself. Plugins that predate the
+// ABOUT command are described by their binary name and the versions they
+// report on VERSION.
+func GetAbout(pluginPath string, exec Exec) (*types.About, error) {
+	return GetAboutInheriting(pluginPath, nil, exec)
+}
+
+// GetAboutInheriting is GetAbout passing the plugin only the variables of
+// the environment of this process that inherit selects, as Args.Inherit
+// does.
+func GetAboutInheriting(pluginPath string, inherit func(key string) bool, exec Exec) (*types.About, error) {
+	if exec == nil {
+		exec = defaultExec
+	}
+
+	args := &Args{
+		Command: "ABOUT",
+
+		// set fake values required by plugins built against an older version of skel
+		NetNS:  "dummy",
+		IfName: "dummy",
+		Path:   "dummy",
+
+		Inherit: inherit,
+	}
+	stdin := []byte(fmt.Sprintf(`{"cniVersion":%q}`, version.Current()))
+	stdoutBytes, err := exec.ExecPlugin(pluginPath, stdin, args.AsEnv())
+	if err != nil {
+		if e, ok := err.(*types.Error); !ok || e.Msg != "unknown CNI_COMMAND: ABOUT" {
+			return nil, err
+		}
+		vi, err := GetVersionInfoInheriting(pluginPath, inherit, exec)
+		if err != nil {
+			return nil, err
+		}
+		return &types.About{
+			CNIVersion:        version.Current(),
+			Name:              filepath.Base(pluginPath),
+			SupportedVersions: vi.SupportedVersions(),
+		}, nil
+	}
+
+	about := &types.About{}
+	if err := json.Unmarshal(stdoutBytes, about); err != nil {
+		return nil, fmt.Errorf("failed to decode the ABOUT output: %v", err)
+	}
+	return about, nil
 }
@@ -0,0 +1,165 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package invoke_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeExec struct {
+	stdout []byte
+	err    error
+
+	pluginPath string
+	stdinData  []byte
+	environ    []string
+}
+
+func (e *fakeExec) ExecPlugin(pluginPath string, stdinData []byte, environ []string) ([]byte, error) {
+	e.pluginPath = pluginPath
+	e.stdinData = stdinData
+	e.environ = environ
+	return e.stdout, e.err
+}
+
+func (e *fakeExec) FindInPath(plugin string, paths []string) (string, error) {
+	return invoke.FindInPath(plugin, paths)
+}
+
+func (e *fakeExec) Decode(jsonBytes []byte) (*types.Result, error) {
+	return (&invoke.RawExec{}).Decode(jsonBytes)
+}
+
+var _ = Describe("Executing a plugin through an Exec", func() {
+	var (
+		exec *fakeExec
+		args *invoke.Args
+	)
+
+	BeforeEach(func() {
+		exec = &fakeExec{stdout: []byte(`{ "ip4": { "ip": "1.2.3.4/24" } }`)}
+		args = &invoke.Args{Command: "ADD", IfName: "eth0"}
+	})
+
+	It("passes the plugin path, stdin and environment to the Exec", func() {
+		_, err := invoke.ExecPluginWithResult("/some/plugin", []byte(`{"some":"config"}`), args, exec)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(exec.pluginPath).To(Equal("/some/plugin"))
+		Expect(exec.stdinData).To(MatchJSON(`{"some":"config"}`))
+		Expect(exec.environ).To(ContainElement("CNI_COMMAND=ADD"))
+		Expect(exec.environ).To(ContainElement("CNI_IFNAME=eth0"))
+	})
+
+	It("decodes the result printed by the plugin", func() {
+		result, err := invoke.ExecPluginWithResult("/some/plugin", nil, args, exec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IP4.IP.String()).To(Equal("1.2.3.4/24"))
+	})
+
+	It("converts a newer result to the legacy format, warning about what is dropped", func() {
+		stderr := &bytes.Buffer{}
+		result, err := (&invoke.RawExec{Stderr: stderr}).Decode([]byte(`{
+			"cniVersion": "0.3.0",
+			"ips": [
+				{"version": "4", "address": "1.2.3.4/24"},
+				{"version": "4", "address": "1.2.3.5/24"}
+			]
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IP4.IP.String()).To(Equal("1.2.3.4/24"))
+		Expect(stderr.String()).To(Equal("plugin result: dropped IP address 1.2.3.5/24: 0.2.0 results hold one address per family\n"))
+	})
+
+	It("decodes the description printed on ABOUT", func() {
+		exec.stdout = []byte(`{"cniVersion": "0.2.0", "name": "fake", "supportedVersions": ["0.2.0"], "commands": ["ADD", "DEL"]}`)
+		about, err := invoke.GetAbout("/some/plugin", exec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(exec.environ).To(ContainElement("CNI_COMMAND=ABOUT"))
+		Expect(about).To(Equal(&types.About{
+			CNIVersion:        "0.2.0",
+			Name:              "fake",
+			SupportedVersions: []string{"0.2.0"},
+			Commands:          []string{"ADD", "DEL"},
+		}))
+	})
+
+	It("returns the error from the Exec", func() {
+		exec.err = errors.New("banana")
+		err := invoke.ExecPluginWithoutResult("/some/plugin", nil, args, exec)
+		Expect(err).To(MatchError("banana"))
+	})
+})
+
+var _ = Describe("Running a plugin with RawExec", func() {
+	var (
+		dir    string
+		stderr *bytes.Buffer
+		raw    *invoke.RawExec
+	)
+
+	writePlugin := func(script string) string {
+		path := filepath.Join(dir, "plugin")
+		Expect(ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755)).To(Succeed())
+		return path
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "cni-exec")
+		Expect(err).NotTo(HaveOccurred())
+		stderr = &bytes.Buffer{}
+		raw = &invoke.RawExec{Stderr: stderr, MaxOutput: 1024}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("returns stdout and passes on stderr", func() {
+		out, err := raw.ExecPlugin(writePlugin("echo '{}'; echo working >&2"), nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(out)).To(Equal("{}\n"))
+		Expect(stderr.String()).To(Equal("working\n"))
+	})
+
+	It("adds the end of stderr to the error of a failed plugin", func() {
+		_, err := raw.ExecPlugin(writePlugin(`echo '{"code":7,"msg":"bad config"}'; echo 'no bridge' >&2; exit 1`), nil, nil)
+		Expect(err).To(Equal(&types.Error{Code: 7, Msg: "bad config", Details: "stderr: no bridge"}))
+	})
+
+	It("fails a plugin writing too much to stdout", func() {
+		_, err := raw.ExecPlugin(writePlugin("head -c 2000 /dev/zero"), nil, nil)
+		Expect(err).To(MatchError("plugin wrote more than 1024 bytes to stdout"))
+	})
+
+	It("drops stderr beyond the limit, keeping its end for the error", func() {
+		_, err := raw.ExecPlugin(writePlugin(`yes x | head -c 10000 | tr -d '\n' >&2; echo ' the end' >&2; exit 1`), nil, nil)
+		Expect(stderr.Len()).To(Equal(1024))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HaveSuffix("xxx the end"))
+		Expect(strings.Count(err.Error(), "x")).To(BeNumerically("<", 5000))
+	})
+})
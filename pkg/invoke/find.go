@@ -16,10 +16,20 @@ package invoke
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
 )
 
+// PluginNotFoundError is returned when a plugin is in none of the
+// directories searched for it
+type PluginNotFoundError struct {
+	Plugin string
+	Paths  []string
+}
+
+func (e *PluginNotFoundError) Error() string {
+	return fmt.Sprintf("failed to find plugin %q in path %s", e.Plugin, e.Paths)
+}
+
 // FindInPath returns the full path of the plugin by searching in the provided path
 func FindInPath(plugin string, paths []string) (string, error) {
 	if plugin == "" {
@@ -30,18 +40,12 @@ func FindInPath(plugin string, paths []string) (string, error) {
 		return "", fmt.Errorf("no paths provided")
 	}
 
-	var fullpath string
 	for _, path := range paths {
 		full := filepath.Join(path, plugin)
-		if fi, err := os.Stat(full); err == nil && fi.Mode().IsRegular() {
-			fullpath = full
-			break
+		if isRegularFile(full) {
+			return full, nil
 		}
 	}
 
-	if fullpath == "" {
-		return "", fmt.Errorf("failed to find plugin %q in path %s", plugin, paths)
-	}
-
-	return fullpath, nil
+	return "", &PluginNotFoundError{Plugin: plugin, Paths: paths}
 }
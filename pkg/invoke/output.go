@@ -0,0 +1,75 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package invoke
+
+import (
+	"io"
+	"strings"
+)
+
+// cappedWriter passes on the first left bytes written to it to w, and
+// counts the rest
+type cappedWriter struct {
+	w       io.Writer
+	left    int
+	dropped int
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if n > c.left {
+		c.dropped += n - c.left
+		p = p[:c.left]
+	}
+	c.left -= len(p)
+	if len(p) > 0 {
+		if _, err := c.w.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// tailBuffer keeps the last max bytes written to it
+type tailBuffer struct {
+	buf       []byte
+	max       int
+	truncated bool
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if n >= t.max {
+		t.truncated = t.truncated || n > t.max || len(t.buf) > 0
+		t.buf = append(t.buf[:0], p[n-t.max:]...)
+		return n, nil
+	}
+	if over := len(t.buf) + n - t.max; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+		t.truncated = true
+	}
+	t.buf = append(t.buf, p...)
+	return n, nil
+}
+
+// String returns what was kept without surrounding whitespace, starting
+// with "..." if earlier output was dropped
+func (t *tailBuffer) String() string {
+	s := strings.TrimSpace(string(t.buf))
+	if t.truncated && s != "" {
+		s = "..." + s
+	}
+	return s
+}
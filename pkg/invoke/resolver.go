@@ -0,0 +1,86 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package invoke
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Resolver finds plugin binaries like FindInPath, but remembers where
+// each plugin was found so that later lookups only check that the binary
+// is still there instead of searching every directory again. A plugin
+// installed into an earlier directory of the path after it was found is
+// only picked up once Invalidate is called.
+type Resolver struct {
+	overrides map[string]string
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+// NewResolver returns a Resolver that uses the binary overrides[plugin]
+// for the plugins it names, without searching any path.
+func NewResolver(overrides map[string]string) *Resolver {
+	return &Resolver{
+		overrides: overrides,
+		cache:     map[string]string{},
+	}
+}
+
+// FindInPath returns the full path of the plugin, from its override or by
+// searching paths in order
+func (r *Resolver) FindInPath(plugin string, paths []string) (string, error) {
+	if override, ok := r.overrides[plugin]; ok {
+		if !isRegularFile(override) {
+			return "", fmt.Errorf("plugin %q is pinned to %q, which is not a regular file", plugin, override)
+		}
+		return override, nil
+	}
+
+	key := plugin + "\x00" + strings.Join(paths, ":")
+
+	r.mu.Lock()
+	cached, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && isRegularFile(cached) {
+		return cached, nil
+	}
+
+	fullpath, err := FindInPath(plugin, paths)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		delete(r.cache, key)
+		return "", err
+	}
+	r.cache[key] = fullpath
+	return fullpath, nil
+}
+
+// Invalidate forgets every plugin found so far
+func (r *Resolver) Invalidate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cache = map[string]string{}
+}
+
+func isRegularFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
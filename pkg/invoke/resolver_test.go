@@ -0,0 +1,104 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package invoke_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Resolver", func() {
+	var (
+		firstDir, secondDir string
+		paths               []string
+		resolver            *invoke.Resolver
+	)
+
+	writePlugin := func(dir string) string {
+		path := filepath.Join(dir, "some-plugin")
+		Expect(ioutil.WriteFile(path, []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+		return path
+	}
+
+	BeforeEach(func() {
+		var err error
+		firstDir, err = ioutil.TempDir("", "cni-resolver")
+		Expect(err).NotTo(HaveOccurred())
+		secondDir, err = ioutil.TempDir("", "cni-resolver")
+		Expect(err).NotTo(HaveOccurred())
+
+		paths = []string{firstDir, secondDir}
+		resolver = invoke.NewResolver(nil)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(firstDir)).To(Succeed())
+		Expect(os.RemoveAll(secondDir)).To(Succeed())
+	})
+
+	It("keeps returning the binary it found until Invalidate", func() {
+		second := writePlugin(secondDir)
+		Expect(resolver.FindInPath("some-plugin", paths)).To(Equal(second))
+
+		first := writePlugin(firstDir)
+		Expect(resolver.FindInPath("some-plugin", paths)).To(Equal(second))
+
+		resolver.Invalidate()
+		Expect(resolver.FindInPath("some-plugin", paths)).To(Equal(first))
+	})
+
+	It("searches again once the binary it found is gone", func() {
+		first := writePlugin(firstDir)
+		second := writePlugin(secondDir)
+		Expect(resolver.FindInPath("some-plugin", paths)).To(Equal(first))
+
+		Expect(os.Remove(first)).To(Succeed())
+		Expect(resolver.FindInPath("some-plugin", paths)).To(Equal(second))
+	})
+
+	It("reports the directories searched for a missing plugin", func() {
+		_, err := resolver.FindInPath("some-plugin", paths)
+		Expect(err).To(Equal(&invoke.PluginNotFoundError{Plugin: "some-plugin", Paths: paths}))
+		Expect(err).To(MatchError(`failed to find plugin "some-plugin" in path [` + firstDir + " " + secondDir + "]"))
+	})
+
+	Context("when a plugin is pinned to a binary", func() {
+		var pinned string
+
+		BeforeEach(func() {
+			pinned = filepath.Join(secondDir, "pinned-plugin")
+			resolver = invoke.NewResolver(map[string]string{"some-plugin": pinned})
+		})
+
+		It("returns the binary regardless of the path", func() {
+			writePlugin(firstDir)
+			Expect(ioutil.WriteFile(pinned, []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+
+			Expect(resolver.FindInPath("some-plugin", paths)).To(Equal(pinned))
+		})
+
+		It("fails if the binary is missing", func() {
+			writePlugin(firstDir)
+
+			_, err := resolver.FindInPath("some-plugin", paths)
+			Expect(err).To(MatchError(`plugin "some-plugin" is pinned to "` + pinned + `", which is not a regular file`))
+		})
+	})
+})
@@ -0,0 +1,137 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package invoke
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// DefaultSocketDir is where plugins running as daemons listen, one socket
+// per plugin named after the plugin binary with a ".sock" suffix
+const DefaultSocketDir = "/run/cni/plugins"
+
+// SocketRequest is one plugin invocation sent to a plugin serving on a
+// unix socket: the CNI_* environment it would have been executed with
+// and its stdin.
+type SocketRequest struct {
+	Env   map[string]string `json:"env"`
+	Stdin []byte            `json:"stdin"`
+}
+
+// SocketResponse carries what the plugin would have printed on stdout,
+// or the error it would have exited with.
+type SocketResponse struct {
+	Stdout []byte       `json:"stdout,omitempty"`
+	Error  *types.Error `json:"error,omitempty"`
+}
+
+// ErrNotServing is returned by ExecSocket when nothing accepts connections
+// on the socket, so the caller can execute the plugin binary instead
+var ErrNotServing = errors.New("no plugin is serving on the socket")
+
+// SocketPath returns the socket a plugin binary serves on in socketDir
+func SocketPath(socketDir, pluginPath string) string {
+	return filepath.Join(socketDir, filepath.Base(pluginPath)+".sock")
+}
+
+// SocketEnv returns the CNI_* variables of environ, keeping the last
+// value of variables that are set more than once, as exec does
+func SocketEnv(environ []string) map[string]string {
+	env := map[string]string{}
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, "CNI_") {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+	return env
+}
+
+// ExecSocket sends an invocation to the plugin serving on socketPath and
+// returns its stdout. A nil environ sends the environment of this process.
+// Errors reported by the plugin are returned as *types.Error.
+func ExecSocket(socketPath string, stdinData []byte, environ []string) ([]byte, error) {
+	if environ == nil {
+		environ = os.Environ()
+	}
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, ErrNotServing
+	}
+	defer conn.Close()
+
+	req := &SocketRequest{Env: SocketEnv(environ), Stdin: stdinData}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("failed to send request to %q: %v", socketPath, err)
+	}
+
+	resp := &SocketResponse{}
+	if err := json.NewDecoder(conn).Decode(resp); err != nil {
+		return nil, fmt.Errorf("failed to read response from %q: %v", socketPath, err)
+	}
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	return resp.Stdout, nil
+}
+
+// SocketExec sends invocations to plugins serving on a unix socket in
+// SocketDir, and runs plugins without a listening socket through Fallback.
+type SocketExec struct {
+	// SocketDir defaults to DefaultSocketDir
+	SocketDir string
+	// Fallback defaults to RawExec
+	Fallback Exec
+}
+
+func (e *SocketExec) fallback() Exec {
+	if e.Fallback == nil {
+		return defaultExec
+	}
+	return e.Fallback
+}
+
+func (e *SocketExec) ExecPlugin(pluginPath string, stdinData []byte, environ []string) ([]byte, error) {
+	dir := e.SocketDir
+	if dir == "" {
+		dir = DefaultSocketDir
+	}
+
+	out, err := ExecSocket(SocketPath(dir, pluginPath), stdinData, environ)
+	if err == ErrNotServing {
+		return e.fallback().ExecPlugin(pluginPath, stdinData, environ)
+	}
+	return out, err
+}
+
+func (e *SocketExec) FindInPath(plugin string, paths []string) (string, error) {
+	return e.fallback().FindInPath(plugin, paths)
+}
+
+func (e *SocketExec) Decode(jsonBytes []byte) (*types.Result, error) {
+	return e.fallback().Decode(jsonBytes)
+}
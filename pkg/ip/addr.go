@@ -0,0 +1,95 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+const (
+	ifaFlagDADFailed = 0x08
+	ifaFlagTentative = 0x40
+)
+
+// SettleAddresses waits up to timeout for the IPv6 addresses of ifName to
+// leave the tentative state. The kernel keeps addresses tentative until
+// the link has carrier, even with duplicate address detection disabled,
+// and refuses to use them as a route source until then.
+func SettleAddresses(ifName string, timeout time.Duration) error {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		tentative, err := tentativeAddrs(link.Attrs().Index)
+		if err != nil {
+			return err
+		}
+		if len(tentative) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("addresses %v of %q are still tentative after %v", tentative, ifName, timeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// tentativeAddrs returns the IPv6 addresses of the link that are still
+// tentative, failing if duplicate address detection failed for one
+func tentativeAddrs(index int) ([]net.IP, error) {
+	req := nl.NewNetlinkRequest(syscall.RTM_GETADDR, syscall.NLM_F_DUMP)
+	req.AddData(nl.NewIfInfomsg(syscall.AF_INET6))
+
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWADDR)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses: %v", err)
+	}
+
+	var tentative []net.IP
+	for _, m := range msgs {
+		msg := nl.DeserializeIfAddrmsg(m)
+		if int(msg.Index) != index || msg.Family != syscall.AF_INET6 {
+			continue
+		}
+
+		attrs, err := nl.ParseRouteAttr(m[msg.Len():])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse address: %v", err)
+		}
+		var addr net.IP
+		for _, attr := range attrs {
+			if attr.Attr.Type == syscall.IFA_ADDRESS {
+				addr = net.IP(attr.Value)
+			}
+		}
+
+		if msg.Flags&ifaFlagDADFailed != 0 {
+			return nil, fmt.Errorf("duplicate address detection failed for %v", addr)
+		}
+		if msg.Flags&ifaFlagTentative != 0 {
+			tentative = append(tentative, addr)
+		}
+	}
+	return tentative, nil
+}
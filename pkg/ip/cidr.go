@@ -15,31 +15,21 @@
 package ip
 
 import (
-	"math/big"
 	"net"
+
+	"github.com/containernetworking/cni/pkg/ip/cidr"
 )
 
-// NextIP returns IP incremented by 1
+// NextIP returns IP incremented by 1, or nil if it is the last address
+// of its family; see the cidr package for more arithmetic on addresses
 func NextIP(ip net.IP) net.IP {
-	i := ipToInt(ip)
-	return intToIP(i.Add(i, big.NewInt(1)))
+	return cidr.Next(ip)
 }
 
-// PrevIP returns IP decremented by 1
+// PrevIP returns IP decremented by 1, or nil if it is the first address
+// of its family
 func PrevIP(ip net.IP) net.IP {
-	i := ipToInt(ip)
-	return intToIP(i.Sub(i, big.NewInt(1)))
-}
-
-func ipToInt(ip net.IP) *big.Int {
-	if v := ip.To4(); v != nil {
-		return big.NewInt(0).SetBytes(v)
-	}
-	return big.NewInt(0).SetBytes(ip.To16())
-}
-
-func intToIP(i *big.Int) net.IP {
-	return net.IP(i.Bytes())
+	return cidr.Prev(ip)
 }
 
 // Network masks off the host portion of the IP
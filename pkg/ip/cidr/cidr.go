@@ -0,0 +1,209 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cidr does arithmetic on IPv4 and IPv6 addresses and ranges of
+// them. Addresses are treated as integers of the width of their family,
+// so that the operations work the same on both and report when they
+// would leave the family instead of wrapping around.
+package cidr
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"net"
+)
+
+var one = big.NewInt(1)
+
+// Bits returns the width of the family of ip: 32 for IPv4 addresses,
+// including IPv4-mapped IPv6 ones, 128 for IPv6 addresses, and 0 for
+// anything else.
+func Bits(ip net.IP) int {
+	if ip.To4() != nil {
+		return 32
+	}
+	if ip.To16() != nil {
+		return 128
+	}
+	return 0
+}
+
+// ToInt returns ip as an integer of the width of its family, or nil if
+// ip is not an address
+func ToInt(ip net.IP) *big.Int {
+	if v := ip.To4(); v != nil {
+		return new(big.Int).SetBytes(v)
+	}
+	if v := ip.To16(); v != nil {
+		return new(big.Int).SetBytes(v)
+	}
+	return nil
+}
+
+// FromInt returns the address of the family of the given width, 32 or
+// 128, that is i, or nil if i does not fit in it. IPv4 addresses are
+// returned in their 4-byte form.
+func FromInt(i *big.Int, bits int) net.IP {
+	if i.Sign() < 0 || i.BitLen() > bits || (bits != 32 && bits != 128) {
+		return nil
+	}
+	b := i.Bytes()
+	ip := make(net.IP, bits/8)
+	copy(ip[len(ip)-len(b):], b)
+	return ip
+}
+
+// Add returns ip offset by n, which may be negative, or nil if the result
+// is outside the family of ip
+func Add(ip net.IP, n *big.Int) net.IP {
+	i := ToInt(ip)
+	if i == nil {
+		return nil
+	}
+	return FromInt(i.Add(i, n), Bits(ip))
+}
+
+// Next returns the address after ip, or nil if ip is the last one of its
+// family
+func Next(ip net.IP) net.IP {
+	return Add(ip, one)
+}
+
+// Prev returns the address before ip, or nil if ip is the first one of
+// its family
+func Prev(ip net.IP) net.IP {
+	return Add(ip, new(big.Int).Neg(one))
+}
+
+// Compare returns -1, 0 or 1 as a is lower than, equal to or higher than
+// b. IPv4 addresses compare as their IPv4-mapped IPv6 form.
+func Compare(a, b net.IP) int {
+	return bytes.Compare(a.To16(), b.To16())
+}
+
+// Distance returns b minus a, which is negative if b is lower than a, or
+// nil if they are not of the same family
+func Distance(a, b net.IP) *big.Int {
+	if Bits(a) == 0 || Bits(a) != Bits(b) {
+		return nil
+	}
+	return new(big.Int).Sub(ToInt(b), ToInt(a))
+}
+
+// Bounds returns the first and the last address of the subnet
+func Bounds(subnet *net.IPNet) (net.IP, net.IP) {
+	first := subnet.IP.Mask(subnet.Mask)
+	last := make(net.IP, len(first))
+	for i := range first {
+		last[i] = first[i] | ^subnet.Mask[i]
+	}
+	return first, last
+}
+
+// Range is the addresses from Start to End, both included
+type Range struct {
+	Start net.IP
+	End   net.IP
+}
+
+// NewRange returns the range from start to end, which must be of the
+// same family and in order
+func NewRange(start, end net.IP) (Range, error) {
+	d := Distance(start, end)
+	if d == nil {
+		return Range{}, fmt.Errorf("%s and %s are not of the same IP family", start, end)
+	}
+	if d.Sign() < 0 {
+		return Range{}, fmt.Errorf("%s is after %s", start, end)
+	}
+	return Range{Start: start, End: end}, nil
+}
+
+// SubnetRange returns the range of all the addresses of the subnet
+func SubnetRange(subnet *net.IPNet) Range {
+	first, last := Bounds(subnet)
+	return Range{Start: first, End: last}
+}
+
+// Size returns the number of addresses in the range
+func (r Range) Size() *big.Int {
+	d := Distance(r.Start, r.End)
+	if d == nil || d.Sign() < 0 {
+		return new(big.Int)
+	}
+	return d.Add(d, one)
+}
+
+// Contains reports whether ip is in the range
+func (r Range) Contains(ip net.IP) bool {
+	return Bits(ip) != 0 && Bits(ip) == Bits(r.Start) &&
+		Compare(ip, r.Start) >= 0 && Compare(ip, r.End) <= 0
+}
+
+// ContainsRange reports whether every address of other is in the range
+func (r Range) ContainsRange(other Range) bool {
+	return r.Contains(other.Start) && r.Contains(other.End)
+}
+
+// Overlaps reports whether an address is in both ranges
+func (r Range) Overlaps(other Range) bool {
+	return Bits(r.Start) == Bits(other.Start) &&
+		Compare(r.Start, other.End) <= 0 && Compare(other.Start, r.End) <= 0
+}
+
+func (r Range) String() string {
+	return fmt.Sprintf("%s-%s", r.Start, r.End)
+}
+
+// Iter returns an iterator over the addresses of the range, in order
+func (r Range) Iter() *Iterator {
+	return &Iterator{r: r}
+}
+
+// Iterator walks through the addresses of a range:
+//
+//	for it := r.Iter(); it.Next(); {
+//		fmt.Println(it.IP())
+//	}
+type Iterator struct {
+	r    Range
+	cur  net.IP
+	done bool
+}
+
+// Next advances to the next address, and returns false once there is
+// none left
+func (it *Iterator) Next() bool {
+	if it.done {
+		return false
+	}
+	if it.cur == nil {
+		it.cur = it.r.Start
+	} else if Compare(it.cur, it.r.End) < 0 {
+		it.cur = Next(it.cur)
+	} else {
+		it.cur = nil
+	}
+	if it.cur == nil || !it.r.Contains(it.cur) {
+		it.cur, it.done = nil, true
+		return false
+	}
+	return true
+}
+
+// IP returns the current address
+func (it *Iterator) IP() net.IP {
+	return it.cur
+}
@@ -0,0 +1,135 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cidr_test
+
+import (
+	"math/big"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/ip/cidr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	Expect(err).NotTo(HaveOccurred())
+	return n
+}
+
+var _ = Describe("CIDR math", func() {
+	It("steps through addresses of both families", func() {
+		Expect(cidr.Next(net.ParseIP("10.0.0.255"))).To(Equal(net.IP{10, 0, 1, 0}))
+		Expect(cidr.Prev(net.ParseIP("10.0.1.0"))).To(Equal(net.IP{10, 0, 0, 255}))
+		Expect(cidr.Next(net.ParseIP("2001:db8::ffff:ffff"))).To(Equal(net.ParseIP("2001:db8::1:0:0")))
+		Expect(cidr.Prev(net.ParseIP("2001:db8::1:0:0"))).To(Equal(net.ParseIP("2001:db8::ffff:ffff")))
+	})
+
+	It("keeps the leading zero bytes of low addresses", func() {
+		Expect(cidr.Next(net.ParseIP("0.0.0.0"))).To(Equal(net.IP{0, 0, 0, 1}))
+		Expect(cidr.Next(net.ParseIP("::"))).To(Equal(net.ParseIP("::1")))
+	})
+
+	It("does not wrap around the ends of a family", func() {
+		Expect(cidr.Next(net.ParseIP("255.255.255.255"))).To(BeNil())
+		Expect(cidr.Prev(net.ParseIP("0.0.0.0"))).To(BeNil())
+		Expect(cidr.Next(net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"))).To(BeNil())
+		Expect(cidr.Prev(net.ParseIP("::"))).To(BeNil())
+		Expect(cidr.Add(net.ParseIP("255.255.255.0"), big.NewInt(256))).To(BeNil())
+	})
+
+	It("adds large offsets to IPv6 addresses", func() {
+		offset := new(big.Int).Lsh(big.NewInt(1), 64)
+		Expect(cidr.Add(net.ParseIP("2001:db8::1"), offset)).To(Equal(net.ParseIP("2001:db8:0:1::1")))
+		Expect(cidr.Distance(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8:0:1::1"))).To(Equal(offset))
+	})
+
+	It("only measures the distance within a family", func() {
+		Expect(cidr.Distance(net.ParseIP("10.0.0.10"), net.ParseIP("10.0.0.1")).Int64()).To(Equal(int64(-9)))
+		Expect(cidr.Distance(net.ParseIP("10.0.0.1"), net.ParseIP("2001:db8::1"))).To(BeNil())
+	})
+
+	It("compares IPv4 addresses in either form", func() {
+		Expect(cidr.Compare(net.IP{10, 0, 0, 1}, net.ParseIP("10.0.0.1"))).To(Equal(0))
+		Expect(cidr.Compare(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"))).To(Equal(-1))
+		Expect(cidr.Bits(net.ParseIP("10.0.0.1"))).To(Equal(32))
+		Expect(cidr.Bits(net.ParseIP("2001:db8::1"))).To(Equal(128))
+		Expect(cidr.Bits(nil)).To(Equal(0))
+	})
+
+	It("finds the bounds of a subnet", func() {
+		first, last := cidr.Bounds(mustParseCIDR("10.1.2.0/23"))
+		Expect(first.String()).To(Equal("10.1.2.0"))
+		Expect(last.String()).To(Equal("10.1.3.255"))
+
+		first, last = cidr.Bounds(mustParseCIDR("2001:db8::/64"))
+		Expect(first.String()).To(Equal("2001:db8::"))
+		Expect(last.String()).To(Equal("2001:db8::ffff:ffff:ffff:ffff"))
+	})
+
+	Describe("ranges", func() {
+		It("rejects ranges out of order or across families", func() {
+			_, err := cidr.NewRange(net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1"))
+			Expect(err).To(MatchError("10.0.0.2 is after 10.0.0.1"))
+			_, err = cidr.NewRange(net.ParseIP("10.0.0.1"), net.ParseIP("2001:db8::1"))
+			Expect(err).To(MatchError("10.0.0.1 and 2001:db8::1 are not of the same IP family"))
+		})
+
+		It("counts their addresses", func() {
+			Expect(cidr.SubnetRange(mustParseCIDR("10.0.0.0/24")).Size().Int64()).To(Equal(int64(256)))
+			Expect(cidr.SubnetRange(mustParseCIDR("::/0")).Size()).To(Equal(new(big.Int).Lsh(big.NewInt(1), 128)))
+		})
+
+		It("checks containment and overlap", func() {
+			r, err := cidr.NewRange(net.ParseIP("10.0.0.10"), net.ParseIP("10.0.0.20"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(r.Contains(net.ParseIP("10.0.0.10"))).To(BeTrue())
+			Expect(r.Contains(net.ParseIP("10.0.0.20"))).To(BeTrue())
+			Expect(r.Contains(net.ParseIP("10.0.0.21"))).To(BeFalse())
+			Expect(r.Contains(net.ParseIP("::ffff:a00:f"))).To(BeTrue())
+			Expect(r.Contains(net.ParseIP("2001:db8::a00:f"))).To(BeFalse())
+
+			subnet := cidr.SubnetRange(mustParseCIDR("10.0.0.0/24"))
+			Expect(subnet.ContainsRange(r)).To(BeTrue())
+			Expect(r.ContainsRange(subnet)).To(BeFalse())
+
+			Expect(r.Overlaps(cidr.Range{Start: net.ParseIP("10.0.0.20"), End: net.ParseIP("10.0.0.30")})).To(BeTrue())
+			Expect(r.Overlaps(cidr.Range{Start: net.ParseIP("10.0.0.21"), End: net.ParseIP("10.0.0.30")})).To(BeFalse())
+		})
+
+		It("iterates over their addresses", func() {
+			r, err := cidr.NewRange(net.ParseIP("10.0.0.254"), net.ParseIP("10.0.1.1"))
+			Expect(err).NotTo(HaveOccurred())
+
+			var ips []string
+			for it := r.Iter(); it.Next(); {
+				ips = append(ips, it.IP().String())
+			}
+			Expect(ips).To(Equal([]string{"10.0.0.254", "10.0.0.255", "10.0.1.0", "10.0.1.1"}))
+		})
+
+		It("stops iterating at the end of the family", func() {
+			r := cidr.Range{Start: net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe"), End: net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff")}
+
+			n := 0
+			for it := r.Iter(); it.Next(); {
+				n++
+			}
+			Expect(n).To(Equal(2))
+		})
+	})
+})
@@ -0,0 +1,83 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/vishvananda/netlink"
+)
+
+// handleClsact is the parent of a clsact qdisc, which shares its value
+// with the ingress qdisc
+const handleClsact = netlink.HANDLE_INGRESS
+
+// EnsureClsact adds a clsact qdisc to the link named ifName unless it
+// already has one, giving it ingress and egress hooks for tc programs
+func EnsureClsact(ifName string) error {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return fmt.Errorf("failed to list qdiscs of %q: %v", ifName, err)
+	}
+	for _, q := range qdiscs {
+		if q.Attrs().Parent == handleClsact && q.Type() == "clsact" {
+			return nil
+		}
+	}
+
+	qdisc := &netlink.GenericQdisc{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    handleClsact,
+		},
+		QdiscType: "clsact",
+	}
+	if err := netlink.QdiscAdd(qdisc); err != nil {
+		return fmt.Errorf("failed to add clsact qdisc to %q: %v", ifName, err)
+	}
+	return nil
+}
+
+// AttachPinnedBPF attaches the BPF program pinned at path to the ingress
+// or egress hook of the clsact qdisc of ifName, in direct-action mode.
+// The qdisc is added if the link does not have one yet. The filter has a
+// fixed preference and handle, so attaching again replaces the program
+// rather than stacking a second copy.
+func AttachPinnedBPF(ifName, hook, path string) error {
+	if hook != "ingress" && hook != "egress" {
+		return fmt.Errorf("invalid clsact hook %q, must be ingress or egress", hook)
+	}
+	if err := EnsureClsact(ifName); err != nil {
+		return err
+	}
+
+	tc, err := exec.LookPath("tc")
+	if err != nil {
+		return fmt.Errorf("failed to locate tc: %v", err)
+	}
+	cmd := exec.Command(tc, "filter", "replace", "dev", ifName, hook,
+		"pref", "1", "handle", "1", "bpf", "direct-action", "object-pinned", path)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to attach %q to %s of %q: %v: %s", path, hook, ifName, err, out)
+	}
+	return nil
+}
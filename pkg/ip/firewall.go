@@ -0,0 +1,120 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+)
+
+// FirewallBackend selects the tool used to install packet filtering
+// and NAT rules on the host
+type FirewallBackend string
+
+const (
+	// FirewallBackendAuto uses iptables when it is installed and falls
+	// back to nftables otherwise
+	FirewallBackendAuto     FirewallBackend = ""
+	FirewallBackendIPTables FirewallBackend = "iptables"
+	FirewallBackendNFTables FirewallBackend = "nftables"
+)
+
+// DetectFirewallBackend resolves FirewallBackendAuto to the backend
+// available on this host for the address family of ipn. Any other
+// backend is returned unchanged once it has been validated.
+func DetectFirewallBackend(backend FirewallBackend, ipn *net.IPNet) (FirewallBackend, error) {
+	switch backend {
+	case FirewallBackendIPTables, FirewallBackendNFTables:
+		return backend, nil
+	case FirewallBackendAuto:
+	default:
+		return "", fmt.Errorf("unknown firewall backend %q", backend)
+	}
+
+	iptablesCmd := "iptables"
+	if ipn.IP.To4() == nil {
+		iptablesCmd = "ip6tables"
+	}
+	if _, err := exec.LookPath(iptablesCmd); err == nil {
+		return FirewallBackendIPTables, nil
+	}
+	if _, err := exec.LookPath("nft"); err == nil {
+		return FirewallBackendNFTables, nil
+	}
+	return "", fmt.Errorf("failed to locate %s or nft", iptablesCmd)
+}
+
+// SetupIPMasqWithBackend is like SetupIPMasq but installs the rules
+// with the given firewall backend
+func SetupIPMasqWithBackend(backend FirewallBackend, ipn *net.IPNet, chain string, comment string, exclude ...*net.IPNet) error {
+	backend, err := DetectFirewallBackend(backend, ipn)
+	if err != nil {
+		return err
+	}
+	if backend == FirewallBackendNFTables {
+		return setupIPMasqNFT(ipn, chain, comment, exclude...)
+	}
+	return SetupIPMasq(ipn, chain, comment, exclude...)
+}
+
+// TeardownIPMasqWithBackend undoes the effects of SetupIPMasqWithBackend
+func TeardownIPMasqWithBackend(backend FirewallBackend, ipn *net.IPNet, chain string, comment string) error {
+	backend, err := DetectFirewallBackend(backend, ipn)
+	if err != nil {
+		return err
+	}
+	if backend == FirewallBackendNFTables {
+		return teardownIPMasqNFT(ipn, chain)
+	}
+	return TeardownIPMasq(ipn, chain, comment)
+}
+
+// masqChainPrefix starts the name of every chain SweepIPMasq may remove,
+// as given to SetupIPMasq by the plugins (see utils.FormatChainNameWithPrefix)
+const masqChainPrefix = "CNI-"
+
+// SweepIPMasq removes the masquerade rules installed by
+// SetupIPMasqWithBackend whose owner is gone, e.g. on GC, in both
+// address families. orphaned is asked about every chain whose name
+// starts with "CNI-", given the comment its rules were installed with,
+// and the chains it returns true for are torn down. With the iptables
+// backend, chains are only found through the POSTROUTING rules jumping
+// to them. With FirewallBackendAuto, a family without iptables or nft
+// has nothing to sweep.
+func SweepIPMasq(backend FirewallBackend, orphaned func(chain, comment string) bool) error {
+	for _, ipn := range []*net.IPNet{
+		{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+		{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+	} {
+		familyBackend, err := DetectFirewallBackend(backend, ipn)
+		if err != nil {
+			if backend == FirewallBackendAuto {
+				continue
+			}
+			return err
+		}
+
+		if familyBackend == FirewallBackendNFTables {
+			err = sweepIPMasqNFT(ipn, orphaned)
+		} else {
+			err = sweepIPMasq(ipn, orphaned)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
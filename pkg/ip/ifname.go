@@ -0,0 +1,78 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/vishvananda/netlink"
+)
+
+// IfNamePolicy selects what happens when the interface name requested for
+// a container is already in use in its network namespace
+type IfNamePolicy string
+
+const (
+	// IfNameFail fails with a types.ErrInterfaceExists error
+	IfNameFail IfNamePolicy = "fail"
+	// IfNameGenerate picks the first free name with the same prefix as
+	// the requested one, e.g. eth1 when eth0 is taken
+	IfNameGenerate IfNamePolicy = "generate"
+)
+
+// maxGeneratedIfNames bounds the search for a free interface name
+const maxGeneratedIfNames = 1024
+
+// ResolveIfName returns the name to give the container interface that was
+// requested as ifName, following policy; an empty policy is IfNameFail.
+// It must be called in the container's network namespace.
+func ResolveIfName(ifName string, policy IfNamePolicy) (string, error) {
+	switch policy {
+	case "", IfNameFail, IfNameGenerate:
+	default:
+		return "", fmt.Errorf("unknown interface name policy %q", policy)
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return "", fmt.Errorf("failed to list links: %v", err)
+	}
+	taken := map[string]bool{}
+	for _, l := range links {
+		taken[l.Attrs().Name] = true
+	}
+
+	if !taken[ifName] {
+		return ifName, nil
+	}
+	if policy != IfNameGenerate {
+		return "", types.NewInterfaceExistsError(ifName)
+	}
+
+	prefix := strings.TrimRight(ifName, "0123456789")
+	for i := 0; i < maxGeneratedIfNames; i++ {
+		name := prefix + strconv.Itoa(i)
+		if len(name) > types.MaxInterfaceNameLen {
+			break
+		}
+		if !taken[name] {
+			return name, nil
+		}
+	}
+	return "", types.NewInterfaceExistsError(ifName).WithField("prefix", prefix)
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ipTables is what the masquerading rules need of iptables.IPTables, which
+// only runs iptables, and of ip6tables for IPv6 networks
+type ipTables interface {
+	Exists(table, chain string, rulespec ...string) (bool, error)
+	AppendUnique(table, chain string, rulespec ...string) error
+	Delete(table, chain string, rulespec ...string) error
+	List(table, chain string) ([]string, error)
+	ClearChain(table, chain string) error
+	DeleteChain(table, chain string) error
+}
+
+// ip6tablesError is ip6tables exiting with a failure
+type ip6tablesError struct {
+	status int
+	msg    string
+}
+
+func (e *ip6tablesError) Error() string {
+	return fmt.Sprintf("exit status %v: %v", e.status, e.msg)
+}
+
+// ip6tables runs the ip6tables command
+type ip6tables struct {
+	path string
+}
+
+// newIP6Tables locates ip6tables. It must support --wait, so that the
+// rules of concurrent plugins are not lost, which every ip6tables able to
+// masquerade, 1.4.17 or later, but the first few does.
+func newIP6Tables() (*ip6tables, error) {
+	path, err := exec.LookPath("ip6tables")
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := exec.Command(path, "--version").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error checking ip6tables version: %v", err)
+	}
+	v := regexp.MustCompile(`v([0-9]+)\.([0-9]+)\.([0-9]+)`).FindStringSubmatch(string(out))
+	if v == nil {
+		return nil, fmt.Errorf("no ip6tables version found in %q", out)
+	}
+	var version [3]int
+	for i := range version {
+		version[i], _ = strconv.Atoi(v[i+1])
+	}
+	if version[0] == 1 && (version[1] < 4 || version[1] == 4 && version[2] < 20) {
+		return nil, fmt.Errorf("ip6tables %s does not support --wait, 1.4.20 or later is required", v[0])
+	}
+
+	return &ip6tables{path: path}, nil
+}
+
+// Exists checks if rulespec is in chain
+func (ipt *ip6tables) Exists(table, chain string, rulespec ...string) (bool, error) {
+	err := ipt.run(nil, append([]string{"-t", table, "-C", chain}, rulespec...)...)
+	if e, ok := err.(*ip6tablesError); ok && e.status == 1 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// AppendUnique appends rulespec to chain, unless it is already there
+func (ipt *ip6tables) AppendUnique(table, chain string, rulespec ...string) error {
+	exists, err := ipt.Exists(table, chain, rulespec...)
+	if err != nil || exists {
+		return err
+	}
+	return ipt.run(nil, append([]string{"-t", table, "-A", chain}, rulespec...)...)
+}
+
+// Delete removes rulespec from chain
+func (ipt *ip6tables) Delete(table, chain string, rulespec ...string) error {
+	return ipt.run(nil, append([]string{"-t", table, "-D", chain}, rulespec...)...)
+}
+
+// List returns the rules of chain, as printed by ip6tables -S
+func (ipt *ip6tables) List(table, chain string) ([]string, error) {
+	var stdout bytes.Buffer
+	if err := ipt.run(&stdout, "-t", table, "-S", chain); err != nil {
+		return nil, err
+	}
+	rules := strings.Split(stdout.String(), "\n")
+	if len(rules) > 0 && rules[len(rules)-1] == "" {
+		rules = rules[:len(rules)-1]
+	}
+	return rules, nil
+}
+
+// ClearChain flushes chain, creating it if it does not exist
+func (ipt *ip6tables) ClearChain(table, chain string) error {
+	err := ipt.run(nil, "-t", table, "-N", chain)
+	if e, ok := err.(*ip6tablesError); ok && e.status == 1 {
+		// the chain already exists
+		return ipt.run(nil, "-t", table, "-F", chain)
+	}
+	return err
+}
+
+// DeleteChain deletes chain, which must be empty
+func (ipt *ip6tables) DeleteChain(table, chain string) error {
+	return ipt.run(nil, "-t", table, "-X", chain)
+}
+
+func (ipt *ip6tables) run(stdout *bytes.Buffer, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(ipt.path, append(args, "--wait")...)
+	if stdout != nil {
+		cmd.Stdout = stdout
+	}
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok {
+		return &ip6tablesError{status: e.Sys().(syscall.WaitStatus).ExitStatus(), msg: stderr.String()}
+	}
+	return err
+}
@@ -15,17 +15,42 @@
 package ip
 
 import (
-	"io/ioutil"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/cni/pkg/utils/sysctl"
 )
 
 func EnableIP4Forward() error {
-	return echo1("/proc/sys/net/ipv4/ip_forward")
+	_, err := sysctl.Sysctl("net.ipv4.ip_forward", "1")
+	return err
 }
 
 func EnableIP6Forward() error {
-	return echo1("/proc/sys/net/ipv6/conf/all/forwarding")
+	_, err := sysctl.Sysctl("net.ipv6.conf.all.forwarding", "1")
+	return err
 }
 
-func echo1(f string) error {
-	return ioutil.WriteFile(f, []byte("1"), 0644)
+// EnableForward enables forwarding for each IP family that has an address
+// in ips
+func EnableForward(ips []*current.IPConfig) error {
+	v4, v6 := false, false
+	for _, ip := range ips {
+		switch ip.Version {
+		case "4":
+			v4 = true
+		case "6":
+			v6 = true
+		}
+	}
+
+	if v4 {
+		if err := EnableIP4Forward(); err != nil {
+			return err
+		}
+	}
+	if v6 {
+		if err := EnableIP6Forward(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
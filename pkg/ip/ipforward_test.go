@@ -0,0 +1,88 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/types/current"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IP forwarding", func() {
+	const (
+		ip4Forward = "/proc/sys/net/ipv4/ip_forward"
+		ip6Forward = "/proc/sys/net/ipv6/conf/all/forwarding"
+	)
+
+	var netns ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+		netns, err = ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(netns.Close()).To(Succeed())
+	})
+
+	// enableForward runs EnableForward for ips in netns, with forwarding
+	// disabled beforehand, and returns the forwarding sysctls afterwards
+	enableForward := func(ips ...*current.IPConfig) (ip4, ip6 string) {
+		err := netns.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			for _, f := range []string{ip4Forward, ip6Forward} {
+				Expect(ioutil.WriteFile(f, []byte("0"), 0644)).To(Succeed())
+			}
+			if err := EnableForward(ips); err != nil {
+				return err
+			}
+
+			read := func(f string) string {
+				content, err := ioutil.ReadFile(f)
+				Expect(err).NotTo(HaveOccurred())
+				return strings.TrimSpace(string(content))
+			}
+			ip4, ip6 = read(ip4Forward), read(ip6Forward)
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return
+	}
+
+	It("enables IPv4 forwarding only for IPv4 addresses", func() {
+		ip4, ip6 := enableForward(&current.IPConfig{Version: "4"})
+		Expect(ip4).To(Equal("1"))
+		Expect(ip6).To(Equal("0"))
+	})
+
+	It("enables IPv6 forwarding only for IPv6 addresses", func() {
+		ip4, ip6 := enableForward(&current.IPConfig{Version: "6"})
+		Expect(ip4).To(Equal("0"))
+		Expect(ip6).To(Equal("1"))
+	})
+
+	It("enables forwarding of both families for dual-stack addresses", func() {
+		ip4, ip6 := enableForward(&current.IPConfig{Version: "4"}, &current.IPConfig{Version: "6"})
+		Expect(ip4).To(Equal("1"))
+		Expect(ip6).To(Equal("1"))
+	})
+})
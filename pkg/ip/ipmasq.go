@@ -17,50 +17,203 @@ package ip
 import (
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/coreos/go-iptables/iptables"
 )
 
+// newIPTables returns the iptables or ip6tables command matching the
+// family of ipn, with the multicast range that must not be masqueraded
+func newIPTables(ipn *net.IPNet) (ipTables, string, error) {
+	if ipn.IP.To4() != nil {
+		ipt, err := iptables.New()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to locate iptables: %v", err)
+		}
+		return ipt, "224.0.0.0/4", nil
+	}
+
+	ipt, err := newIP6Tables()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to locate ip6tables: %v", err)
+	}
+	return ipt, "ff00::/8", nil
+}
+
 // SetupIPMasq installs iptables rules to masquerade traffic
-// coming from ipn and going outside of it
-func SetupIPMasq(ipn *net.IPNet, chain string, comment string) error {
-	ipt, err := iptables.New()
+// coming from ipn and going outside of it, except to the networks of
+// exclude. IPv6 networks are handled with ip6tables, and the networks of
+// exclude of the other family are skipped.
+func SetupIPMasq(ipn *net.IPNet, chain string, comment string, exclude ...*net.IPNet) error {
+	ipt, multicastNet, err := newIPTables(ipn)
 	if err != nil {
-		return fmt.Errorf("failed to locate iptables: %v", err)
+		return err
 	}
 
-	if err = ipt.NewChain("nat", chain); err != nil {
-		if err.(*iptables.Error).ExitStatus() != 1 {
-			// TODO(eyakubovich): assumes exit status 1 implies chain exists
-			return err
-		}
+	// start over, so that the exclusions come before the MASQUERADE rule
+	// however the chain was set up before
+	if err = ipt.ClearChain("nat", chain); err != nil {
+		return err
 	}
 
 	if err = ipt.AppendUnique("nat", chain, "-d", ipn.String(), "-j", "ACCEPT", "-m", "comment", "--comment", comment); err != nil {
 		return err
 	}
 
-	if err = ipt.AppendUnique("nat", chain, "!", "-d", "224.0.0.0/4", "-j", "MASQUERADE", "-m", "comment", "--comment", comment); err != nil {
+	for _, dst := range sameFamily(ipn, exclude) {
+		if err = ipt.AppendUnique("nat", chain, "-d", dst.String(), "-j", "RETURN", "-m", "comment", "--comment", comment); err != nil {
+			return err
+		}
+	}
+
+	if err = ipt.AppendUnique("nat", chain, "!", "-d", multicastNet, "-j", "MASQUERADE", "-m", "comment", "--comment", comment); err != nil {
 		return err
 	}
 
 	return ipt.AppendUnique("nat", "POSTROUTING", "-s", ipn.String(), "-j", chain, "-m", "comment", "--comment", comment)
 }
 
-// TeardownIPMasq undoes the effects of SetupIPMasq
+// sameFamily returns the networks of nets of the address family of ipn
+func sameFamily(ipn *net.IPNet, nets []*net.IPNet) []*net.IPNet {
+	var same []*net.IPNet
+	for _, n := range nets {
+		if (n.IP.To4() == nil) == (ipn.IP.To4() == nil) {
+			same = append(same, n)
+		}
+	}
+	return same
+}
+
+// TeardownIPMasq undoes the effects of SetupIPMasq. Rules and chains
+// that are already gone are skipped, so that it can be retried.
 func TeardownIPMasq(ipn *net.IPNet, chain string, comment string) error {
-	ipt, err := iptables.New()
+	ipt, _, err := newIPTables(ipn)
 	if err != nil {
-		return fmt.Errorf("failed to locate iptables: %v", err)
+		return err
 	}
+	return teardownIPMasqChain(ipt, ipn.String(), chain, comment)
+}
 
-	if err = ipt.Delete("nat", "POSTROUTING", "-s", ipn.String(), "-j", chain, "-m", "comment", "--comment", comment); err != nil {
+// teardownIPMasqChain removes chain and the POSTROUTING rule jumping to it
+// for traffic from source
+func teardownIPMasqChain(ipt ipTables, source, chain, comment string) error {
+	// iptables does not delete chains that are jumped to, so there are no
+	// rules left to delete if the chain is gone
+	if _, err := ipt.List("nat", chain); err != nil {
+		if isNotExist(err) {
+			return nil
+		}
 		return err
 	}
 
-	if err = ipt.ClearChain("nat", chain); err != nil {
+	jump := []string{"-s", source, "-j", chain, "-m", "comment", "--comment", comment}
+	exists, err := ipt.Exists("nat", "POSTROUTING", jump...)
+	if err != nil {
 		return err
 	}
+	if exists {
+		if err = ipt.Delete("nat", "POSTROUTING", jump...); err != nil {
+			return err
+		}
+	}
 
+	if err = ipt.ClearChain("nat", chain); err != nil {
+		return err
+	}
 	return ipt.DeleteChain("nat", chain)
 }
+
+// isNotExist reports whether err is iptables or ip6tables failing on a
+// missing chain
+func isNotExist(err error) bool {
+	switch e := err.(type) {
+	case *iptables.Error:
+		return e.ExitStatus() == 1
+	case *ip6tablesError:
+		return e.status == 1
+	}
+	return false
+}
+
+// sweepIPMasq removes the chains SetupIPMasq installed for the address
+// family of ipn, found through the POSTROUTING rules jumping to them, for
+// which orphaned returns true
+func sweepIPMasq(ipn *net.IPNet, orphaned func(chain, comment string) bool) error {
+	ipt, _, err := newIPTables(ipn)
+	if err != nil {
+		return err
+	}
+
+	rules, err := ipt.List("nat", "POSTROUTING")
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		source, chain, comment := parseMasqJump(rule)
+		if chain == "" || !strings.HasPrefix(chain, masqChainPrefix) || !orphaned(chain, comment) {
+			continue
+		}
+		if err := teardownIPMasqChain(ipt, source, chain, comment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseMasqJump returns the source, target and comment of a rule in the
+// form printed by iptables -S, e.g.
+// -A POSTROUTING -s 10.0.0.2/32 -m comment --comment "name: \"net\"" -j CNI-1234
+func parseMasqJump(rule string) (source, chain, comment string) {
+	args := splitRule(rule)
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "-s":
+			source = args[i+1]
+		case "-j":
+			chain = args[i+1]
+		case "--comment":
+			comment = args[i+1]
+		}
+	}
+	if source == "" {
+		chain = ""
+	}
+	return
+}
+
+// splitRule splits a rule printed by iptables -S into its arguments,
+// removing the double quotes and backslash escapes around comments
+func splitRule(rule string) []string {
+	var (
+		args    []string
+		arg     []byte
+		inArg   bool
+		quoted  bool
+		escaped bool
+	)
+	for i := 0; i < len(rule); i++ {
+		c := rule[i]
+		switch {
+		case escaped:
+			arg = append(arg, c)
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case c == ' ' && !quoted:
+			if inArg {
+				args = append(args, string(arg))
+				arg, inArg = nil, false
+			}
+		default:
+			arg = append(arg, c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, string(arg))
+	}
+	return args
+}
@@ -0,0 +1,326 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// nftTable is the table, one per address family, holding all CNI rules
+const nftTable = "cni"
+
+// nftCmd is a single command of the nft JSON API, e.g. {"add": {...}}
+type nftCmd map[string]interface{}
+
+type nftables struct {
+	path string
+}
+
+func newNFTables() (*nftables, error) {
+	path, err := exec.LookPath("nft")
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate nft: %v", err)
+	}
+	return &nftables{path: path}, nil
+}
+
+// apply runs cmds as a single atomic nft transaction
+func (nft *nftables) apply(cmds ...nftCmd) error {
+	data, err := json.Marshal(map[string]interface{}{"nftables": cmds})
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(nft.path, "-j", "-f", "-")
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("nft failed: %v: %s", err, out)
+	}
+	return nil
+}
+
+// nftListedRule is a rule of a listed table, with the target of its jump
+// if it has one
+type nftListedRule struct {
+	Chain   string
+	Handle  int
+	Comment string
+	Jump    string
+}
+
+// nftListedTable is what listTable returns of the "cni" table
+type nftListedTable struct {
+	Chains map[string]bool
+	Rules  []nftListedRule
+}
+
+// listTable returns the chains and rules of the "cni" table of family,
+// which is empty if the table does not exist
+func (nft *nftables) listTable(family string) (*nftListedTable, error) {
+	table := &nftListedTable{Chains: map[string]bool{}}
+
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command(nft.path, "-j", "list", "table", family, nftTable)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(stderr.String(), "No such file or directory") {
+			return table, nil
+		}
+		return nil, fmt.Errorf("failed to list nft table %s %s: %v: %s", family, nftTable, err, stderr)
+	}
+
+	var ruleset struct {
+		Nftables []struct {
+			Chain *struct {
+				Name string `json:"name"`
+			} `json:"chain"`
+			Rule *struct {
+				Chain   string                       `json:"chain"`
+				Handle  int                          `json:"handle"`
+				Comment string                       `json:"comment"`
+				Expr    []map[string]json.RawMessage `json:"expr"`
+			} `json:"rule"`
+		} `json:"nftables"`
+	}
+	if err := json.Unmarshal(out, &ruleset); err != nil {
+		return nil, fmt.Errorf("failed to parse nft output: %v", err)
+	}
+
+	for _, obj := range ruleset.Nftables {
+		if obj.Chain != nil {
+			table.Chains[obj.Chain.Name] = true
+		}
+		if obj.Rule == nil {
+			continue
+		}
+		rule := nftListedRule{Chain: obj.Rule.Chain, Handle: obj.Rule.Handle, Comment: obj.Rule.Comment}
+		for _, expr := range obj.Rule.Expr {
+			var jump struct {
+				Target string `json:"target"`
+			}
+			if raw, ok := expr["jump"]; ok && json.Unmarshal(raw, &jump) == nil {
+				rule.Jump = jump.Target
+			}
+		}
+		table.Rules = append(table.Rules, rule)
+	}
+	return table, nil
+}
+
+// jumpHandles returns the handles of the rules in chain that jump to target
+func (nft *nftables) jumpHandles(family, chain, target string) ([]int, error) {
+	table, err := nft.listTable(family)
+	if err != nil {
+		return nil, err
+	}
+	return table.jumpHandles(chain, target), nil
+}
+
+func (t *nftListedTable) jumpHandles(chain, target string) []int {
+	var handles []int
+	for _, rule := range t.Rules {
+		if rule.Chain == chain && rule.Jump == target {
+			handles = append(handles, rule.Handle)
+		}
+	}
+	return handles
+}
+
+// teardownChain removes chain of the "cni" table of family together with
+// the rules of hook jumping to it. A chain that is already gone is
+// skipped: nft does not delete chains that are jumped to, so no rules
+// can be left either.
+func (nft *nftables) teardownChain(family, hook, chain string) error {
+	table, err := nft.listTable(family)
+	if err != nil {
+		return err
+	}
+	return nft.teardownListedChain(table, family, hook, chain)
+}
+
+func (nft *nftables) teardownListedChain(table *nftListedTable, family, hook, chain string) error {
+	if !table.Chains[chain] {
+		return nil
+	}
+
+	var cmds []nftCmd
+	for _, handle := range table.jumpHandles(hook, chain) {
+		cmds = append(cmds, nftCmd{"delete": nftCmd{"rule": map[string]interface{}{
+			"family": family,
+			"table":  nftTable,
+			"chain":  hook,
+			"handle": handle,
+		}}})
+	}
+	cmds = append(cmds,
+		nftCmd{"flush": nftChain(family, chain)},
+		nftCmd{"delete": nftChain(family, chain)},
+	)
+	return nft.apply(cmds...)
+}
+
+// nftFamily returns the nft table family and payload protocol for ipn,
+// together with the multicast range that must not be masqueraded
+func nftFamily(ipn *net.IPNet) (string, *net.IPNet) {
+	if ipn.IP.To4() == nil {
+		return "ip6", &net.IPNet{IP: net.ParseIP("ff00::"), Mask: net.CIDRMask(8, 128)}
+	}
+	return "ip", &net.IPNet{IP: net.IPv4(224, 0, 0, 0).To4(), Mask: net.CIDRMask(4, 32)}
+}
+
+func nftChain(family, name string) nftCmd {
+	return nftCmd{"chain": map[string]interface{}{
+		"family": family,
+		"table":  nftTable,
+		"name":   name,
+	}}
+}
+
+func nftRule(family, chain, comment string, expr ...interface{}) nftCmd {
+	return nftCmd{"rule": map[string]interface{}{
+		"family":  family,
+		"table":   nftTable,
+		"chain":   chain,
+		"comment": comment,
+		"expr":    expr,
+	}}
+}
+
+// nftMatchAddr matches the field ("saddr" or "daddr") of the packet
+// against the network ipn with op ("==" or "!=")
+func nftMatchAddr(family, field, op string, ipn *net.IPNet) interface{} {
+	ones, _ := ipn.Mask.Size()
+	return map[string]interface{}{"match": map[string]interface{}{
+		"op": op,
+		"left": map[string]interface{}{"payload": map[string]interface{}{
+			"protocol": family,
+			"field":    field,
+		}},
+		"right": map[string]interface{}{"prefix": map[string]interface{}{
+			"addr": ipn.IP.Mask(ipn.Mask).String(),
+			"len":  ones,
+		}},
+	}}
+}
+
+// setupIPMasqNFT is the nftables equivalent of SetupIPMasq. The rules
+// live in a per-network chain of the "cni" table which the table's
+// POSTROUTING chain jumps to.
+func setupIPMasqNFT(ipn *net.IPNet, chain string, comment string, exclude ...*net.IPNet) error {
+	nft, err := newNFTables()
+	if err != nil {
+		return err
+	}
+
+	family, multicastNet := nftFamily(ipn)
+	postrouting := nftCmd{"chain": map[string]interface{}{
+		"family": family,
+		"table":  nftTable,
+		"name":   "POSTROUTING",
+		"type":   "nat",
+		"hook":   "postrouting",
+		"prio":   100,
+		"policy": "accept",
+	}}
+
+	cmds := []nftCmd{
+		{"add": nftCmd{"table": map[string]interface{}{"family": family, "name": nftTable}}},
+		{"add": postrouting},
+		{"add": nftChain(family, chain)},
+		{"flush": nftChain(family, chain)},
+		{"add": nftRule(family, chain, comment,
+			nftMatchAddr(family, "daddr", "==", ipn),
+			map[string]interface{}{"accept": nil})},
+	}
+	for _, dst := range sameFamily(ipn, exclude) {
+		cmds = append(cmds, nftCmd{"add": nftRule(family, chain, comment,
+			nftMatchAddr(family, "daddr", "==", dst),
+			map[string]interface{}{"return": nil})})
+	}
+	cmds = append(cmds, nftCmd{"add": nftRule(family, chain, comment,
+		nftMatchAddr(family, "daddr", "!=", multicastNet),
+		map[string]interface{}{"masquerade": nil})})
+	if err = nft.apply(cmds...); err != nil {
+		return err
+	}
+
+	handles, err := nft.jumpHandles(family, "POSTROUTING", chain)
+	if err != nil {
+		return err
+	}
+	if len(handles) > 0 {
+		return nil
+	}
+
+	return nft.apply(nftCmd{"add": nftRule(family, "POSTROUTING", comment,
+		nftMatchAddr(family, "saddr", "==", ipn),
+		map[string]interface{}{"jump": map[string]interface{}{"target": chain}})})
+}
+
+// teardownIPMasqNFT undoes the effects of setupIPMasqNFT
+func teardownIPMasqNFT(ipn *net.IPNet, chain string) error {
+	nft, err := newNFTables()
+	if err != nil {
+		return err
+	}
+
+	family, _ := nftFamily(ipn)
+	return nft.teardownChain(family, "POSTROUTING", chain)
+}
+
+// sweepIPMasqNFT is the nftables equivalent of sweepIPMasq; every chain
+// of the table is considered, jumped to or not
+func sweepIPMasqNFT(ipn *net.IPNet, orphaned func(chain, comment string) bool) error {
+	nft, err := newNFTables()
+	if err != nil {
+		return err
+	}
+
+	family, _ := nftFamily(ipn)
+	table, err := nft.listTable(family)
+	if err != nil {
+		return err
+	}
+
+	// the comment of a chain is that of its rules
+	comments := map[string]string{}
+	for _, rule := range table.Rules {
+		if rule.Comment != "" {
+			comments[rule.Chain] = rule.Comment
+		}
+	}
+
+	var chains []string
+	for chain := range table.Chains {
+		if strings.HasPrefix(chain, masqChainPrefix) && orphaned(chain, comments[chain]) {
+			chains = append(chains, chain)
+		}
+	}
+	sort.Strings(chains)
+
+	for _, chain := range chains {
+		if err := nft.teardownListedChain(table, family, "POSTROUTING", chain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeNFT is an nft on PATH that records the transactions it is given and
+// lists the table in its table.json, or no table without one
+const fakeNFT = `#!/bin/sh
+if [ "$2" = "list" ]; then
+	if [ -f "%[1]s/table.json" ]; then
+		cat "%[1]s/table.json"
+		exit 0
+	fi
+	echo "Error: No such file or directory" >&2
+	exit 1
+fi
+cat >> "%[1]s/transactions"
+echo >> "%[1]s/transactions"
+`
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	Expect(err).NotTo(HaveOccurred())
+	return n
+}
+
+var _ = Describe("nftables masquerading", func() {
+	var dir, oldPath string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "nft")
+		Expect(err).NotTo(HaveOccurred())
+		err = ioutil.WriteFile(filepath.Join(dir, "nft"), []byte(fmt.Sprintf(fakeNFT, dir)), 0755)
+		Expect(err).NotTo(HaveOccurred())
+
+		oldPath = os.Getenv("PATH")
+		os.Setenv("PATH", dir+string(os.PathListSeparator)+oldPath)
+	})
+
+	AfterEach(func() {
+		os.Setenv("PATH", oldPath)
+		os.RemoveAll(dir)
+	})
+
+	transactions := func() []string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "transactions"))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+
+	listing := func(table string) {
+		err := ioutil.WriteFile(filepath.Join(dir, "table.json"), []byte(table), 0644)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	const table = `{"nftables": [
+		{"metainfo": {"json_schema_version": 1}},
+		{"table": {"family": "ip", "name": "cni", "handle": 1}},
+		{"chain": {"family": "ip", "table": "cni", "name": "POSTROUTING", "handle": 1, "type": "nat", "hook": "postrouting", "prio": 100, "policy": "accept"}},
+		{"chain": {"family": "ip", "table": "cni", "name": "CNI-abc", "handle": 2}},
+		{"chain": {"family": "ip", "table": "cni", "name": "CNI-def", "handle": 3}},
+		{"rule": {"family": "ip", "table": "cni", "chain": "POSTROUTING", "handle": 4, "comment": "name: \"a\"", "expr": [
+			{"match": {"op": "==", "left": {"payload": {"protocol": "ip", "field": "saddr"}}, "right": {"prefix": {"addr": "10.0.0.0", "len": 24}}}},
+			{"jump": {"target": "CNI-abc"}}
+		]}},
+		{"rule": {"family": "ip", "table": "cni", "chain": "CNI-abc", "handle": 5, "comment": "name: \"a\"", "expr": [
+			{"match": {"op": "!=", "left": {"payload": {"protocol": "ip", "field": "daddr"}}, "right": {"prefix": {"addr": "224.0.0.0", "len": 4}}}},
+			{"masquerade": null}
+		]}},
+		{"rule": {"family": "ip", "table": "cni", "chain": "POSTROUTING", "handle": 6, "comment": "name: \"a\"", "expr": [
+			{"match": {"op": "==", "left": {"payload": {"protocol": "ip", "field": "saddr"}}, "right": {"prefix": {"addr": "10.0.0.0", "len": 24}}}},
+			{"jump": {"target": "CNI-abc"}}
+		]}},
+		{"rule": {"family": "ip", "table": "cni", "chain": "CNI-def", "handle": 7, "comment": "name: \"d\"", "expr": [
+			{"masquerade": null}
+		]}}
+	]}`
+
+	It("masquerades a network in a chain of its own, jumped to from POSTROUTING", func() {
+		err := setupIPMasqNFT(mustParseCIDR("10.0.0.0/24"), "CNI-abc", "comment",
+			mustParseCIDR("10.1.0.0/16"), mustParseCIDR("fd00::/8"))
+		Expect(err).NotTo(HaveOccurred())
+
+		txs := transactions()
+		Expect(txs).To(HaveLen(2))
+		Expect(txs[0]).To(MatchJSON(`{"nftables": [
+			{"add": {"table": {"family": "ip", "name": "cni"}}},
+			{"add": {"chain": {"family": "ip", "table": "cni", "name": "POSTROUTING", "type": "nat", "hook": "postrouting", "prio": 100, "policy": "accept"}}},
+			{"add": {"chain": {"family": "ip", "table": "cni", "name": "CNI-abc"}}},
+			{"flush": {"chain": {"family": "ip", "table": "cni", "name": "CNI-abc"}}},
+			{"add": {"rule": {"family": "ip", "table": "cni", "chain": "CNI-abc", "comment": "comment", "expr": [
+				{"match": {"op": "==", "left": {"payload": {"protocol": "ip", "field": "daddr"}}, "right": {"prefix": {"addr": "10.0.0.0", "len": 24}}}},
+				{"accept": null}
+			]}}},
+			{"add": {"rule": {"family": "ip", "table": "cni", "chain": "CNI-abc", "comment": "comment", "expr": [
+				{"match": {"op": "==", "left": {"payload": {"protocol": "ip", "field": "daddr"}}, "right": {"prefix": {"addr": "10.1.0.0", "len": 16}}}},
+				{"return": null}
+			]}}},
+			{"add": {"rule": {"family": "ip", "table": "cni", "chain": "CNI-abc", "comment": "comment", "expr": [
+				{"match": {"op": "!=", "left": {"payload": {"protocol": "ip", "field": "daddr"}}, "right": {"prefix": {"addr": "224.0.0.0", "len": 4}}}},
+				{"masquerade": null}
+			]}}}
+		]}`))
+		Expect(txs[1]).To(MatchJSON(`{"nftables": [
+			{"add": {"rule": {"family": "ip", "table": "cni", "chain": "POSTROUTING", "comment": "comment", "expr": [
+				{"match": {"op": "==", "left": {"payload": {"protocol": "ip", "field": "saddr"}}, "right": {"prefix": {"addr": "10.0.0.0", "len": 24}}}},
+				{"jump": {"target": "CNI-abc"}}
+			]}}}
+		]}`))
+	})
+
+	It("uses the ip6 family and multicast range for IPv6 networks", func() {
+		err := setupIPMasqNFT(mustParseCIDR("fd00:1::/64"), "CNI-abc", "comment")
+		Expect(err).NotTo(HaveOccurred())
+
+		txs := transactions()
+		Expect(txs).To(HaveLen(2))
+		Expect(txs[0]).To(ContainSubstring(`{"add":{"table":{"family":"ip6","name":"cni"}}}`))
+		Expect(txs[0]).To(ContainSubstring(`"right":{"prefix":{"addr":"ff00::","len":8}}`))
+		Expect(txs[1]).To(ContainSubstring(`"payload":{"field":"saddr","protocol":"ip6"}`))
+	})
+
+	It("does not jump to a chain twice", func() {
+		listing(table)
+
+		err := setupIPMasqNFT(mustParseCIDR("10.0.0.0/24"), "CNI-abc", "comment")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(transactions()).To(HaveLen(1))
+	})
+
+	It("lists the chains of the table, and the rules with their jumps", func() {
+		listing(table)
+
+		nft, err := newNFTables()
+		Expect(err).NotTo(HaveOccurred())
+		listed, err := nft.listTable("ip")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(listed.Chains).To(Equal(map[string]bool{"POSTROUTING": true, "CNI-abc": true, "CNI-def": true}))
+		Expect(listed.Rules).To(Equal([]nftListedRule{
+			{Chain: "POSTROUTING", Handle: 4, Comment: `name: "a"`, Jump: "CNI-abc"},
+			{Chain: "CNI-abc", Handle: 5, Comment: `name: "a"`},
+			{Chain: "POSTROUTING", Handle: 6, Comment: `name: "a"`, Jump: "CNI-abc"},
+			{Chain: "CNI-def", Handle: 7, Comment: `name: "d"`},
+		}))
+		Expect(listed.jumpHandles("POSTROUTING", "CNI-abc")).To(Equal([]int{4, 6}))
+		Expect(listed.jumpHandles("POSTROUTING", "CNI-def")).To(BeEmpty())
+	})
+
+	It("lists a missing table as empty", func() {
+		nft, err := newNFTables()
+		Expect(err).NotTo(HaveOccurred())
+		listed, err := nft.listTable("ip")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(listed.Chains).To(BeEmpty())
+		Expect(listed.Rules).To(BeEmpty())
+	})
+
+	It("tears down a chain with every rule jumping to it", func() {
+		listing(table)
+
+		Expect(teardownIPMasqNFT(mustParseCIDR("10.0.0.0/24"), "CNI-abc")).To(Succeed())
+		Expect(transactions()).To(ConsistOf(MatchJSON(`{"nftables": [
+			{"delete": {"rule": {"family": "ip", "table": "cni", "chain": "POSTROUTING", "handle": 4}}},
+			{"delete": {"rule": {"family": "ip", "table": "cni", "chain": "POSTROUTING", "handle": 6}}},
+			{"flush": {"chain": {"family": "ip", "table": "cni", "name": "CNI-abc"}}},
+			{"delete": {"chain": {"family": "ip", "table": "cni", "name": "CNI-abc"}}}
+		]}`)))
+	})
+
+	It("skips tearing down a chain that is gone", func() {
+		Expect(teardownIPMasqNFT(mustParseCIDR("10.0.0.0/24"), "CNI-abc")).To(Succeed())
+		Expect(transactions()).To(BeEmpty())
+	})
+
+	It("sweeps the chains that are orphaned, by their comment", func() {
+		listing(table)
+
+		var asked []string
+		err := sweepIPMasqNFT(mustParseCIDR("10.0.0.0/24"), func(chain, comment string) bool {
+			asked = append(asked, chain+" "+comment)
+			return chain == "CNI-def"
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(asked).To(ConsistOf(`CNI-abc name: "a"`, `CNI-def name: "d"`))
+		Expect(transactions()).To(ConsistOf(MatchJSON(`{"nftables": [
+			{"flush": {"chain": {"family": "ip", "table": "cni", "name": "CNI-def"}}},
+			{"delete": {"chain": {"family": "ip", "table": "cni", "name": "CNI-def"}}}
+		]}`)))
+	})
+
+	It("reports what nft printed when it fails", func() {
+		err := ioutil.WriteFile(filepath.Join(dir, "nft"), []byte("#!/bin/sh\necho 'Error: syntax error' >&2\nexit 1\n"), 0755)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = setupIPMasqNFT(mustParseCIDR("10.0.0.0/24"), "CNI-abc", "comment")
+		Expect(err).To(MatchError(ContainSubstring("nft failed: exit status 1: Error: syntax error")))
+	})
+})
@@ -0,0 +1,55 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("iptables masquerading", func() {
+	It("splits the rules printed by iptables -S into their arguments", func() {
+		Expect(splitRule(`-A POSTROUTING -s 10.0.0.2/32 -j CNI-1234`)).To(Equal([]string{
+			"-A", "POSTROUTING", "-s", "10.0.0.2/32", "-j", "CNI-1234",
+		}))
+		Expect(splitRule(`-A CNI-1234 -m comment --comment "name: \"net\" id: \"abc\"" -j MASQUERADE`)).To(Equal([]string{
+			"-A", "CNI-1234", "-m", "comment", "--comment", `name: "net" id: "abc"`, "-j", "MASQUERADE",
+		}))
+		Expect(splitRule(`-A CNI-1234  --comment "a\\b"  -j ACCEPT`)).To(Equal([]string{
+			"-A", "CNI-1234", "--comment", `a\b`, "-j", "ACCEPT",
+		}))
+		Expect(splitRule(`-A CNI-1234 --comment ""`)).To(Equal([]string{"-A", "CNI-1234", "--comment", ""}))
+	})
+
+	It("parses the POSTROUTING rules jumping to masquerade chains", func() {
+		source, chain, comment := parseMasqJump(`-A POSTROUTING -s 10.0.0.2/32 -m comment --comment "name: \"net\"" -j CNI-1234`)
+		Expect(source).To(Equal("10.0.0.2/32"))
+		Expect(chain).To(Equal("CNI-1234"))
+		Expect(comment).To(Equal(`name: "net"`))
+
+		source, chain, comment = parseMasqJump(`-A POSTROUTING -s fd00::2/128 -j CNI-5678`)
+		Expect(source).To(Equal("fd00::2/128"))
+		Expect(chain).To(Equal("CNI-5678"))
+		Expect(comment).To(BeEmpty())
+	})
+
+	It("ignores the rules of POSTROUTING without a source", func() {
+		_, chain, _ := parseMasqJump(`-A POSTROUTING -o eth0 -j MASQUERADE`)
+		Expect(chain).To(BeEmpty())
+
+		_, chain, _ = parseMasqJump(`-P POSTROUTING ACCEPT`)
+		Expect(chain).To(BeEmpty())
+	})
+})
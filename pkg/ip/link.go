@@ -21,6 +21,7 @@ import (
 	"os"
 
 	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/utils/hwaddr"
 	"github.com/vishvananda/netlink"
 )
 
@@ -40,30 +41,20 @@ func makeVethPair(name, peer string, mtu int) (netlink.Link, error) {
 	return veth, nil
 }
 
-func makeVeth(name string, mtu int) (peerName string, veth netlink.Link, err error) {
-	for i := 0; i < 10; i++ {
-		peerName, err = RandomVethName()
-		if err != nil {
-			return
-		}
+// vethNameAttempts bounds the random host veth names SetupVeth tries
+const vethNameAttempts = 10
 
-		veth, err = makeVethPair(name, peerName, mtu)
-		switch {
-		case err == nil:
-			return
-
-		case os.IsExist(err):
-			continue
+// randomVethName picks the random host veth names, replaced in tests
+var randomVethName = RandomVethName
 
-		default:
-			err = fmt.Errorf("failed to make veth pair: %v", err)
-			return
-		}
-	}
+// vethNameTakenError is returned by setupVethPair if the name of the
+// host veth is already taken, in the container or the host namespace
+type vethNameTakenError struct {
+	msg string
+}
 
-	// should really never be hit
-	err = fmt.Errorf("failed to find a unique veth name")
-	return
+func (e *vethNameTakenError) Error() string {
+	return e.msg
 }
 
 // RandomVethName returns string "veth" with random prefix (hashed from entropy)
@@ -82,12 +73,62 @@ func RandomVethName() (string, error) {
 // Should be in container netns, and will switch back to hostNS to set the host
 // veth end up.
 func SetupVeth(contVethName string, mtu int, hostNS ns.NetNS) (hostVeth, contVeth netlink.Link, err error) {
-	var hostVethName string
-	hostVethName, contVeth, err = makeVeth(contVethName, mtu)
+	return SetupVethWithName(contVethName, "", mtu, hostNS)
+}
+
+// SetupVethWithName is SetupVeth with an explicit name for the host end of
+// the veth, so that it can be found by other tooling; an empty name picks
+// a random one, and another one if it turns out to be taken. It fails if
+// an explicit name is already taken in hostNS, and removes the veth again
+// if any step fails.
+func SetupVethWithName(contVethName, hostVethName string, mtu int, hostNS ns.NetNS) (hostVeth, contVeth netlink.Link, err error) {
+	if hostVethName != "" {
+		err = hostNS.Do(func(_ ns.NetNS) error {
+			if _, err := netlink.LinkByName(hostVethName); err == nil {
+				return fmt.Errorf("host veth name %q already exists in %q", hostVethName, hostNS.Path())
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+		return setupVethPair(contVethName, hostVethName, mtu, hostNS)
+	}
+
+	for i := 0; i < vethNameAttempts; i++ {
+		if hostVethName, err = randomVethName(); err != nil {
+			return
+		}
+		hostVeth, contVeth, err = setupVethPair(contVethName, hostVethName, mtu, hostNS)
+		if _, taken := err.(*vethNameTakenError); !taken {
+			return
+		}
+	}
+
+	err = fmt.Errorf("failed to find a unique veth name after %d attempts: %v", vethNameAttempts, err)
+	return
+}
+
+// setupVethPair creates the veth and moves its host end to hostNS,
+// removing both ends again if that fails
+func setupVethPair(contVethName, hostVethName string, mtu int, hostNS ns.NetNS) (hostVeth, contVeth netlink.Link, err error) {
+	contVeth, err = makeVethPair(contVethName, hostVethName, mtu)
+	if os.IsExist(err) {
+		err = &vethNameTakenError{fmt.Sprintf("failed to make veth pair: %v", err)}
+		return
+	}
 	if err != nil {
+		err = fmt.Errorf("failed to make veth pair: %v", err)
 		return
 	}
 
+	// deleting either end of the veth removes both
+	defer func() {
+		if err != nil {
+			netlink.LinkDel(contVeth)
+		}
+	}()
+
 	if err = netlink.LinkSetUp(contVeth); err != nil {
 		err = fmt.Errorf("failed to set %q up: %v", contVethName, err)
 		return
@@ -100,7 +141,12 @@ func SetupVeth(contVethName string, mtu int, hostNS ns.NetNS) (hostVeth, contVet
 	}
 
 	if err = netlink.LinkSetNsFd(hostVeth, int(hostNS.Fd())); err != nil {
-		err = fmt.Errorf("failed to move veth to host netns: %v", err)
+		// the name may have been taken in hostNS since it was picked
+		if os.IsExist(err) {
+			err = &vethNameTakenError{fmt.Sprintf("failed to move veth to host netns: %v", err)}
+		} else {
+			err = fmt.Errorf("failed to move veth to host netns: %v", err)
+		}
 		return
 	}
 
@@ -132,16 +178,17 @@ func DelLinkByName(ifName string) error {
 	return nil
 }
 
-// DelLinkByNameAddr remove an interface returns its IP address
-// of the specified family
-func DelLinkByNameAddr(ifName string, family int) (*net.IPNet, error) {
+// DelLinkByNameAddr removes an interface and returns its IPv4 and IPv6
+// addresses. IPv6 link-local addresses are assigned by the kernel rather
+// than by IPAM, so they are left out.
+func DelLinkByNameAddr(ifName string) ([]*net.IPNet, error) {
 	iface, err := netlink.LinkByName(ifName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to lookup %q: %v", ifName, err)
 	}
 
-	addrs, err := netlink.AddrList(iface, family)
-	if err != nil || len(addrs) == 0 {
+	addrs, err := netlink.AddrList(iface, netlink.FAMILY_ALL)
+	if err != nil {
 		return nil, fmt.Errorf("failed to get IP addresses for %q: %v", ifName, err)
 	}
 
@@ -149,5 +196,37 @@ func DelLinkByNameAddr(ifName string, family int) (*net.IPNet, error) {
 		return nil, fmt.Errorf("failed to delete %q: %v", ifName, err)
 	}
 
-	return addrs[0].IPNet, nil
+	var out []*net.IPNet
+	for _, addr := range addrs {
+		if addr.IP.To4() == nil && addr.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		out = append(out, addr.IPNet)
+	}
+	return out, nil
+}
+
+// VethPeerIndex returns the index of the peer of link, which must be a
+// veth: for the container end, that of the host end in the host namespace
+func VethPeerIndex(link netlink.Link) (int, error) {
+	if link.Type() != "veth" {
+		return 0, fmt.Errorf("%q is a %s link, not veth", link.Attrs().Name, link.Type())
+	}
+	return link.Attrs().ParentIndex, nil
+}
+
+// SetHWAddr sets the hardware address of the interface to hwAddr
+func SetHWAddr(ifName string, hwAddr net.HardwareAddr) error {
+	return SetLinkProperties(ifName, LinkProperties{HardwareAddr: hwAddr})
+}
+
+// SetHWAddrByIP sets the hardware address of the interface to one
+// derived from its IPv4 address, so that the interface keeps its MAC
+// across container restarts and neighbours' ARP caches stay valid
+func SetHWAddrByIP(ifName string, ip4 net.IP) error {
+	hwAddr, err := hwaddr.GenerateHardwareAddr4(ip4, hwaddr.PrivateMACPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to generate hardware addr: %v", err)
+	}
+	return SetHWAddr(ifName, hwAddr)
 }
@@ -0,0 +1,225 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"net"
+
+	"github.com/containernetworking/cni/pkg/ns"
+
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("veth setup", func() {
+	var hostNS, containerNS ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+		hostNS, err = ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		containerNS, err = ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(containerNS.Close()).To(Succeed())
+		Expect(hostNS.Close()).To(Succeed())
+	})
+
+	// linkNames returns the names of the links in netns other than lo
+	linkNames := func(netns ns.NetNS) []string {
+		var names []string
+		err := netns.Do(func(ns.NetNS) error {
+			links, err := netlink.LinkList()
+			if err != nil {
+				return err
+			}
+			for _, l := range links {
+				if l.Attrs().Name != "lo" {
+					names = append(names, l.Attrs().Name)
+				}
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return names
+	}
+
+	addVeth := func(netns ns.NetNS, name, peer string) {
+		err := netns.Do(func(ns.NetNS) error {
+			return netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: peer})
+		})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("moves the host end of a veth with a random name to the host namespace", func() {
+		var hostVeth netlink.Link
+		err := containerNS.Do(func(ns.NetNS) error {
+			var err error
+			hostVeth, _, err = SetupVeth("eth0", 1400, hostNS)
+			return err
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(hostVeth.Attrs().Name).To(HavePrefix("veth"))
+		Expect(linkNames(containerNS)).To(Equal([]string{"eth0"}))
+		Expect(linkNames(hostNS)).To(Equal([]string{hostVeth.Attrs().Name}))
+
+		err = hostNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			link, err := netlink.LinkByName(hostVeth.Attrs().Name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link.Attrs().Flags & net.FlagUp).To(Equal(net.FlagUp))
+			Expect(link.Attrs().MTU).To(Equal(1400))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("gives the host end of a veth the name it is asked for", func() {
+		err := containerNS.Do(func(ns.NetNS) error {
+			_, _, err := SetupVethWithName("eth0", "hostveth0", 1500, hostNS)
+			return err
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(linkNames(hostNS)).To(Equal([]string{"hostveth0"}))
+	})
+
+	It("finds the host end of a veth from its container end", func() {
+		var hostVeth netlink.Link
+		var peerIndex int
+		err := containerNS.Do(func(ns.NetNS) error {
+			var err error
+			hostVeth, _, err = SetupVeth("eth0", 1500, hostNS)
+			if err != nil {
+				return err
+			}
+			link, err := netlink.LinkByName("eth0")
+			if err != nil {
+				return err
+			}
+			peerIndex, err = VethPeerIndex(link)
+			return err
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(peerIndex).To(Equal(hostVeth.Attrs().Index))
+
+		err = containerNS.Do(func(ns.NetNS) error {
+			link, err := netlink.LinkByName("lo")
+			if err != nil {
+				return err
+			}
+			_, err = VethPeerIndex(link)
+			return err
+		})
+		Expect(err).To(MatchError(`"lo" is a device link, not veth`))
+	})
+
+	It("fails without a trace when the name asked for is taken on the host", func() {
+		addVeth(hostNS, "hostveth0", "hostveth1")
+
+		err := containerNS.Do(func(ns.NetNS) error {
+			_, _, err := SetupVethWithName("eth0", "hostveth0", 1500, hostNS)
+			return err
+		})
+		Expect(err).To(MatchError(`host veth name "hostveth0" already exists in "` + hostNS.Path() + `"`))
+		Expect(linkNames(containerNS)).To(BeEmpty())
+		Expect(linkNames(hostNS)).To(ConsistOf("hostveth0", "hostveth1"))
+	})
+
+	It("fails when the container interface name is taken", func() {
+		addVeth(containerNS, "eth0", "eth1")
+
+		err := containerNS.Do(func(ns.NetNS) error {
+			_, _, err := SetupVethWithName("eth0", "hostveth0", 1500, hostNS)
+			return err
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(linkNames(containerNS)).To(ConsistOf("eth0", "eth1"))
+		Expect(linkNames(hostNS)).To(BeEmpty())
+	})
+
+	It("removes the veth when its host end name is taken on the host by the time it is moved", func() {
+		addVeth(hostNS, "hostveth0", "hostveth1")
+
+		err := containerNS.Do(func(ns.NetNS) error {
+			_, _, err := setupVethPair("eth0", "hostveth0", 1500, hostNS)
+			return err
+		})
+		Expect(err).To(BeAssignableToTypeOf(&vethNameTakenError{}))
+		Expect(err.Error()).To(HavePrefix("failed to move veth to host netns: "))
+		Expect(linkNames(containerNS)).To(BeEmpty())
+		Expect(linkNames(hostNS)).To(ConsistOf("hostveth0", "hostveth1"))
+	})
+
+	Context("with random host veth names", func() {
+		var names []string
+
+		BeforeEach(func() {
+			names = nil
+		})
+
+		AfterEach(func() {
+			randomVethName = RandomVethName
+		})
+
+		// pick hands out candidates in turn, the last one for good
+		pick := func(candidates ...string) {
+			randomVethName = func() (string, error) {
+				name := candidates[0]
+				if len(candidates) > 1 {
+					candidates = candidates[1:]
+				}
+				names = append(names, name)
+				return name, nil
+			}
+		}
+
+		It("tries another name when the first one is taken on the host", func() {
+			addVeth(hostNS, "vethtaken0", "vethtaken1")
+			pick("vethtaken0", "vethfree0")
+
+			var hostVeth netlink.Link
+			err := containerNS.Do(func(ns.NetNS) error {
+				var err error
+				hostVeth, _, err = SetupVeth("eth0", 1500, hostNS)
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hostVeth.Attrs().Name).To(Equal("vethfree0"))
+			Expect(names).To(Equal([]string{"vethtaken0", "vethfree0"}))
+			Expect(linkNames(containerNS)).To(Equal([]string{"eth0"}))
+			Expect(linkNames(hostNS)).To(ConsistOf("vethtaken0", "vethtaken1", "vethfree0"))
+		})
+
+		It("gives up after vethNameAttempts names", func() {
+			addVeth(hostNS, "vethtaken0", "vethtaken1")
+			pick("vethtaken0")
+
+			err := containerNS.Do(func(ns.NetNS) error {
+				_, _, err := SetupVeth("eth0", 1500, hostNS)
+				return err
+			})
+			Expect(err).To(MatchError(HavePrefix("failed to find a unique veth name after 10 attempts: failed to move veth to host netns: ")))
+			Expect(names).To(HaveLen(vethNameAttempts))
+			Expect(linkNames(containerNS)).To(BeEmpty())
+			Expect(linkNames(hostNS)).To(ConsistOf("vethtaken0", "vethtaken1"))
+		})
+	})
+})
@@ -0,0 +1,165 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// LinkProperties are the attributes SetLinkProperties changes on a link.
+// Zero and nil fields leave the attribute as it is.
+type LinkProperties struct {
+	// Up brings the link up if true and down if false
+	Up           *bool
+	MTU          int
+	HardwareAddr net.HardwareAddr
+	Alias        *string
+	TxQLen       *int
+}
+
+// linkChange is a single attribute SetLinkProperties changes, along with
+// how to put it back the way it was
+type linkChange struct {
+	desc    string
+	apply   func() error
+	restore func() error
+}
+
+// SetLinkProperties applies props to the link named ifName. A link being
+// brought down goes down before, and one being brought up comes up after,
+// the other attributes are changed, since some drivers refuse a new MAC
+// on a running link. If an attribute cannot be changed, the ones changed
+// before it are restored, so the link is left as it was found.
+func SetLinkProperties(ifName string, props LinkProperties) error {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+	attrs := *link.Attrs()
+	wasUp := attrs.Flags&net.FlagUp != 0
+
+	setUp := func(up bool) func() error {
+		return func() error {
+			if up {
+				return netlink.LinkSetUp(link)
+			}
+			return netlink.LinkSetDown(link)
+		}
+	}
+
+	var changes []linkChange
+	if props.Up != nil && !*props.Up && wasUp {
+		changes = append(changes, linkChange{fmt.Sprintf("set %q down", ifName), setUp(false), setUp(true)})
+	}
+	if props.MTU != 0 && props.MTU != attrs.MTU {
+		changes = append(changes, linkChange{
+			fmt.Sprintf("set MTU %d on %q", props.MTU, ifName),
+			func() error { return netlink.LinkSetMTU(link, props.MTU) },
+			func() error { return netlink.LinkSetMTU(link, attrs.MTU) },
+		})
+	}
+	if props.HardwareAddr != nil && !bytes.Equal(props.HardwareAddr, attrs.HardwareAddr) {
+		changes = append(changes, linkChange{
+			fmt.Sprintf("set hardware addr %v on %q", props.HardwareAddr, ifName),
+			func() error { return netlink.LinkSetHardwareAddr(link, props.HardwareAddr) },
+			func() error { return netlink.LinkSetHardwareAddr(link, attrs.HardwareAddr) },
+		})
+	}
+	if props.Alias != nil {
+		alias, err := linkAlias(attrs.Index)
+		if err != nil {
+			return fmt.Errorf("failed to get alias of %q: %v", ifName, err)
+		}
+		if *props.Alias != alias {
+			changes = append(changes, linkChange{
+				fmt.Sprintf("set alias %q on %q", *props.Alias, ifName),
+				func() error { return setLinkAttr(attrs.Index, syscall.IFLA_IFALIAS, []byte(*props.Alias)) },
+				func() error { return setLinkAttr(attrs.Index, syscall.IFLA_IFALIAS, []byte(alias)) },
+			})
+		}
+	}
+	if props.TxQLen != nil && *props.TxQLen != attrs.TxQLen {
+		changes = append(changes, linkChange{
+			fmt.Sprintf("set txqueuelen %d on %q", *props.TxQLen, ifName),
+			func() error {
+				return setLinkAttr(attrs.Index, syscall.IFLA_TXQLEN, nl.Uint32Attr(uint32(*props.TxQLen)))
+			},
+			func() error {
+				return setLinkAttr(attrs.Index, syscall.IFLA_TXQLEN, nl.Uint32Attr(uint32(attrs.TxQLen)))
+			},
+		})
+	}
+	if props.Up != nil && *props.Up && !wasUp {
+		changes = append(changes, linkChange{fmt.Sprintf("set %q up", ifName), setUp(true), setUp(false)})
+	}
+
+	for i, c := range changes {
+		if err := c.apply(); err != nil {
+			err = fmt.Errorf("failed to %s: %v", c.desc, err)
+			for j := i - 1; j >= 0; j-- {
+				if rerr := changes[j].restore(); rerr != nil {
+					return fmt.Errorf("%v; failed to undo the earlier %s: %v", err, changes[j].desc, rerr)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// setLinkAttr sets a single attribute of the link with the given index,
+// for those the netlink package has no setter for
+func setLinkAttr(index, attrType int, value []byte) error {
+	req := nl.NewNetlinkRequest(syscall.RTM_SETLINK, syscall.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(index)
+	req.AddData(msg)
+	req.AddData(nl.NewRtAttr(attrType, value))
+
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+// linkAlias returns the alias of the link with the given index, which the
+// netlink package does not parse
+func linkAlias(index int) (string, error) {
+	req := nl.NewNetlinkRequest(syscall.RTM_GETLINK, syscall.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(index)
+	req.AddData(msg)
+
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWLINK)
+	if err != nil {
+		return "", err
+	}
+	for _, m := range msgs {
+		attrs, err := nl.ParseRouteAttr(m[msg.Len():])
+		if err != nil {
+			return "", err
+		}
+		for _, attr := range attrs {
+			if attr.Attr.Type == syscall.IFLA_IFALIAS {
+				return string(bytes.TrimRight(attr.Value, "\x00")), nil
+			}
+		}
+	}
+	return "", nil
+}
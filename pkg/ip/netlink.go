@@ -0,0 +1,208 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// macvlanModes are the kernel values of the modes of macvlan and macvtap
+// links
+var macvlanModes = map[netlink.MacvlanMode]uint32{
+	netlink.MACVLAN_MODE_PRIVATE:  1,
+	netlink.MACVLAN_MODE_VEPA:     2,
+	netlink.MACVLAN_MODE_BRIDGE:   4,
+	netlink.MACVLAN_MODE_PASSTHRU: 8,
+}
+
+// IPoIBMode is the mode of an IPoIB link
+type IPoIBMode uint16
+
+const (
+	IPoIBModeDatagram IPoIBMode = iota
+	IPoIBModeConnected
+)
+
+// the attributes of IPoIB links in IFLA_INFO_DATA
+const (
+	iflaIPoIBPkey = iota + 1
+	iflaIPoIBMode
+	iflaIPoIBUmcast
+)
+
+// IPoIB is a child interface of an InfiniBand device in the partition
+// Pkey, a kind of link the vendored netlink does not know
+type IPoIB struct {
+	netlink.LinkAttrs
+	Pkey   uint16
+	Mode   IPoIBMode
+	Umcast uint16
+}
+
+func (ipoib *IPoIB) Attrs() *netlink.LinkAttrs {
+	return &ipoib.LinkAttrs
+}
+
+func (ipoib *IPoIB) Type() string {
+	return "ipoib"
+}
+
+// LinkAdd adds link like netlink.LinkAdd, but with the attributes of the
+// kinds of links the vendored netlink only creates with their defaults:
+// the Mode of a macvtap link, and those of an *IPoIB.
+func LinkAdd(link netlink.Link) error {
+	switch link := link.(type) {
+	case *netlink.Macvtap:
+		return linkAddWithData(link, func(data *nl.RtAttr) {
+			if mode, ok := macvlanModes[link.Mode]; ok {
+				nl.NewRtAttrChild(data, nl.IFLA_MACVLAN_MODE, nl.Uint32Attr(mode))
+			}
+		})
+	case *IPoIB:
+		return linkAddWithData(link, func(data *nl.RtAttr) {
+			nl.NewRtAttrChild(data, iflaIPoIBPkey, nl.Uint16Attr(link.Pkey))
+			nl.NewRtAttrChild(data, iflaIPoIBMode, nl.Uint16Attr(uint16(link.Mode)))
+			nl.NewRtAttrChild(data, iflaIPoIBUmcast, nl.Uint16Attr(link.Umcast))
+		})
+	}
+	return netlink.LinkAdd(link)
+}
+
+// LinkByName finds the link called name like netlink.LinkByName, but
+// returns an *IPoIB for an IPoIB link
+func LinkByName(name string) (netlink.Link, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if l, ok := link.(*netlink.GenericLink); !ok || l.LinkType != "ipoib" {
+		return link, nil
+	}
+
+	data, err := linkInfoData(link.Attrs().Index)
+	if err != nil {
+		return nil, err
+	}
+	ipoib := &IPoIB{LinkAttrs: *link.Attrs()}
+	native := nl.NativeEndian()
+	for _, datum := range data {
+		switch datum.Attr.Type {
+		case iflaIPoIBPkey:
+			ipoib.Pkey = native.Uint16(datum.Value[0:2])
+		case iflaIPoIBMode:
+			ipoib.Mode = IPoIBMode(native.Uint16(datum.Value[0:2]))
+		case iflaIPoIBUmcast:
+			ipoib.Umcast = native.Uint16(datum.Value[0:2])
+		}
+	}
+	return ipoib, nil
+}
+
+// linkAddWithData adds link, its kind-specific attributes being added to
+// the IFLA_INFO_DATA attribute of the request by addData
+func linkAddWithData(link netlink.Link, addData func(data *nl.RtAttr)) error {
+	base := link.Attrs()
+	if base.Name == "" {
+		return fmt.Errorf("link name cannot be empty")
+	}
+
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+	req.AddData(nl.NewIfInfomsg(syscall.AF_UNSPEC))
+	if base.ParentIndex != 0 {
+		req.AddData(nl.NewRtAttr(syscall.IFLA_LINK, nl.Uint32Attr(uint32(base.ParentIndex))))
+	}
+	req.AddData(nl.NewRtAttr(syscall.IFLA_IFNAME, nl.ZeroTerminated(base.Name)))
+	if base.MTU > 0 {
+		req.AddData(nl.NewRtAttr(syscall.IFLA_MTU, nl.Uint32Attr(uint32(base.MTU))))
+	}
+	if base.TxQLen > 0 {
+		req.AddData(nl.NewRtAttr(syscall.IFLA_TXQLEN, nl.Uint32Attr(uint32(base.TxQLen))))
+	}
+	switch ns := base.Namespace.(type) {
+	case netlink.NsPid:
+		req.AddData(nl.NewRtAttr(syscall.IFLA_NET_NS_PID, nl.Uint32Attr(uint32(ns))))
+	case netlink.NsFd:
+		req.AddData(nl.NewRtAttr(nl.IFLA_NET_NS_FD, nl.Uint32Attr(uint32(ns))))
+	}
+
+	linkInfo := nl.NewRtAttr(syscall.IFLA_LINKINFO, nil)
+	nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_KIND, nl.NonZeroTerminated(link.Type()))
+	addData(nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_DATA, nil))
+	req.AddData(linkInfo)
+
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+// iflaBrVlanFiltering is the attribute of bridges in IFLA_INFO_DATA
+// telling whether they filter by VLAN
+const iflaBrVlanFiltering = 7
+
+// BridgeVlanFiltering reports whether the bridge br filters by VLAN,
+// which the vendored netlink does not read
+func BridgeVlanFiltering(br *netlink.Bridge) (bool, error) {
+	data, err := linkInfoData(br.Attrs().Index)
+	if err != nil {
+		return false, err
+	}
+	for _, datum := range data {
+		if datum.Attr.Type == iflaBrVlanFiltering {
+			return datum.Value[0] == 1, nil
+		}
+	}
+	return false, nil
+}
+
+// linkInfoData returns the kind-specific attributes of the link with the
+// given index, from the IFLA_INFO_DATA attribute the kernel reports
+func linkInfoData(index int) ([]syscall.NetlinkRouteAttr, error) {
+	req := nl.NewNetlinkRequest(syscall.RTM_GETLINK, syscall.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(index)
+	req.AddData(msg)
+
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWLINK)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) != 1 {
+		return nil, fmt.Errorf("expected one link of index %d, got %d", index, len(msgs))
+	}
+
+	m := msgs[0]
+	attrs, err := nl.ParseRouteAttr(m[nl.DeserializeIfInfomsg(m).Len():])
+	if err != nil {
+		return nil, err
+	}
+	for _, attr := range attrs {
+		if attr.Attr.Type != syscall.IFLA_LINKINFO {
+			continue
+		}
+		infos, err := nl.ParseRouteAttr(attr.Value)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if info.Attr.Type == nl.IFLA_INFO_DATA {
+				return nl.ParseRouteAttr(info.Value)
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	arpRequest = 1
+	arpReply   = 2
+
+	icmpv6NeighborSolicitation  = 135
+	icmpv6NeighborAdvertisement = 136
+)
+
+// AddrInUse reports whether another host on the link of ifName answers
+// for addr, waiting up to timeout: it sends an ARP probe (RFC 5227) for
+// an IPv4 address and a neighbor solicitation for an IPv6 one. It must
+// be called in the network namespace of ifName.
+func AddrInUse(ifName string, addr net.IP, timeout time.Duration) (bool, error) {
+	iface, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return false, fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+	if len(iface.HardwareAddr) != 6 {
+		return false, fmt.Errorf("%q has no Ethernet address to probe from", ifName)
+	}
+
+	if ip4 := addr.To4(); ip4 != nil {
+		return arpProbe(iface, ip4, timeout)
+	}
+	return neighborProbe(iface, addr.To16(), timeout)
+}
+
+func arpProbe(iface *net.Interface, addr net.IP, timeout time.Duration) (bool, error) {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, int(htons(syscall.ETH_P_ARP)))
+	if err != nil {
+		return false, fmt.Errorf("failed to open ARP socket: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	ll := &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ARP), Ifindex: iface.Index}
+	if err := syscall.Bind(fd, ll); err != nil {
+		return false, fmt.Errorf("failed to bind ARP socket to %q: %v", iface.Name, err)
+	}
+
+	// a probe asks for addr from the unspecified address, so that it
+	// does not update the ARP caches of the hosts that receive it
+	req := arpPacket(arpRequest, iface.HardwareAddr, net.IPv4zero.To4(), addr)
+	broadcast := &syscall.SockaddrLinklayer{
+		Protocol: htons(syscall.ETH_P_ARP),
+		Ifindex:  iface.Index,
+		Halen:    6,
+		Addr:     [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+	if err := syscall.Sendto(fd, req, 0, broadcast); err != nil {
+		return false, fmt.Errorf("failed to send ARP probe for %v on %q: %v", addr, iface.Name, err)
+	}
+
+	return awaitAnswer(fd, timeout, func(pkt []byte) bool {
+		if len(pkt) < 28 {
+			return false
+		}
+		op := binary.BigEndian.Uint16(pkt[6:8])
+		sender, senderIP := net.HardwareAddr(pkt[8:14]), net.IP(pkt[14:18])
+		return (op == arpReply || op == arpRequest) &&
+			senderIP.Equal(addr) && !bytes.Equal(sender, iface.HardwareAddr)
+	})
+}
+
+// arpPacket builds an Ethernet/IPv4 ARP packet without the link header
+func arpPacket(op uint16, sender net.HardwareAddr, senderIP, targetIP net.IP) []byte {
+	pkt := make([]byte, 28)
+	binary.BigEndian.PutUint16(pkt[0:2], 1) // Ethernet
+	binary.BigEndian.PutUint16(pkt[2:4], syscall.ETH_P_IP)
+	pkt[4] = 6
+	pkt[5] = 4
+	binary.BigEndian.PutUint16(pkt[6:8], op)
+	copy(pkt[8:14], sender)
+	copy(pkt[14:18], senderIP)
+	copy(pkt[24:28], targetIP)
+	return pkt
+}
+
+func neighborProbe(iface *net.Interface, addr net.IP, timeout time.Duration) (bool, error) {
+	fd, err := syscall.Socket(syscall.AF_INET6, syscall.SOCK_RAW, syscall.IPPROTO_ICMPV6)
+	if err != nil {
+		return false, fmt.Errorf("failed to open ICMPv6 socket: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	if err := syscall.BindToDevice(fd, iface.Name); err != nil {
+		return false, fmt.Errorf("failed to bind ICMPv6 socket to %q: %v", iface.Name, err)
+	}
+	// neighbor discovery messages must have a hop limit of 255
+	for _, opt := range []int{syscall.IPV6_MULTICAST_HOPS, syscall.IPV6_UNICAST_HOPS} {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, opt, 255); err != nil {
+			return false, fmt.Errorf("failed to set ICMPv6 hop limit: %v", err)
+		}
+	}
+
+	// the type, code, checksum (filled in by the kernel) and reserved
+	// fields, the target and a source link-layer address option
+	sol := make([]byte, 32)
+	sol[0] = icmpv6NeighborSolicitation
+	copy(sol[8:24], addr)
+	sol[24] = 1
+	sol[25] = 1
+	copy(sol[26:32], iface.HardwareAddr)
+
+	// the solicited-node multicast address of addr
+	dst := &syscall.SockaddrInet6{ZoneId: uint32(iface.Index)}
+	copy(dst.Addr[:], net.ParseIP("ff02::1:ff00:0"))
+	copy(dst.Addr[13:], addr[13:])
+	if err := syscall.Sendto(fd, sol, 0, dst); err != nil {
+		return false, fmt.Errorf("failed to send neighbor solicitation for %v on %q: %v", addr, iface.Name, err)
+	}
+
+	return awaitAnswer(fd, timeout, func(pkt []byte) bool {
+		return len(pkt) >= 24 && pkt[0] == icmpv6NeighborAdvertisement && net.IP(pkt[8:24]).Equal(addr)
+	})
+}
+
+// awaitAnswer reads packets from fd until isAnswer accepts one or the
+// timeout expires
+func awaitAnswer(fd int, timeout time.Duration, isAnswer func(pkt []byte) bool) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 1500)
+	for {
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return false, nil
+		}
+		tv := syscall.NsecToTimeval(remaining.Nanoseconds())
+		if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+			return false, err
+		}
+
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		switch err {
+		case nil:
+			if isAnswer(buf[:n]) {
+				return true, nil
+			}
+		case syscall.EAGAIN, syscall.EINTR:
+		default:
+			return false, err
+		}
+	}
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/utils/sysctl"
+)
+
+// SetupProxyNeigh makes the host answer ARP requests and IPv6 neighbor
+// solicitations received on ifName for ips, so that addresses routed to
+// containers are reachable from its link without routes on the other
+// hosts. An empty ifName selects the interface of the default route of
+// each address family. IPv4 relies on proxy ARP, which answers for every
+// address routed through another interface; IPv6 needs a proxy entry
+// per address.
+func SetupProxyNeigh(ifName string, ips []net.IP) error {
+	for _, ip := range ips {
+		link, err := proxyNeighLink(ifName, ip)
+		if err != nil {
+			return err
+		}
+		name := link.Attrs().Name
+
+		if ip.To4() != nil {
+			key := fmt.Sprintf("net/ipv4/conf/%s/proxy_arp", name)
+			if _, err := sysctl.Sysctl(key, "1"); err != nil {
+				return fmt.Errorf("failed to set %s: %v", key, err)
+			}
+			continue
+		}
+
+		key := fmt.Sprintf("net/ipv6/conf/%s/proxy_ndp", name)
+		if _, err := sysctl.Sysctl(key, "1"); err != nil {
+			return fmt.Errorf("failed to set %s: %v", key, err)
+		}
+		if err := netlink.NeighSet(proxyNeigh(link, ip)); err != nil {
+			return fmt.Errorf("failed to add proxy neighbor %v on %q: %v", ip, name, err)
+		}
+	}
+	return nil
+}
+
+// TeardownProxyNeigh removes the IPv6 proxy entries added by
+// SetupProxyNeigh. The sysctls are left alone as other attachments may
+// depend on them.
+func TeardownProxyNeigh(ifName string, ips []net.IP) error {
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			continue
+		}
+
+		link, err := proxyNeighLink(ifName, ip)
+		if err != nil {
+			return err
+		}
+		err = netlink.NeighDel(proxyNeigh(link, ip))
+		if err != nil && err != syscall.ENOENT {
+			return fmt.Errorf("failed to delete proxy neighbor %v on %q: %v", ip, link.Attrs().Name, err)
+		}
+	}
+	return nil
+}
+
+// proxyNeighLink returns the link named ifName or, if it is empty, the
+// link of the default route of ip's family
+func proxyNeighLink(ifName string, ip net.IP) (netlink.Link, error) {
+	if ifName != "" {
+		link, err := netlink.LinkByName(ifName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lookup %q: %v", ifName, err)
+		}
+		return link, nil
+	}
+
+	family := netlink.FAMILY_V6
+	if ip.To4() != nil {
+		family = netlink.FAMILY_V4
+	}
+	routes, err := netlink.RouteList(nil, family)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes: %v", err)
+	}
+	for _, route := range routes {
+		if route.Dst == nil && route.LinkIndex > 0 {
+			return netlink.LinkByIndex(route.LinkIndex)
+		}
+	}
+	return nil, fmt.Errorf("no default route to publish %v on", ip)
+}
+
+func proxyNeigh(link netlink.Link, ip net.IP) *netlink.Neigh {
+	return &netlink.Neigh{
+		LinkIndex: link.Attrs().Index,
+		Family:    netlink.FAMILY_V6,
+		Flags:     netlink.NTF_PROXY,
+		IP:        ip,
+		// the kernel rejects a link-layer address shorter than the
+		// link's, even though proxy entries do not use it
+		HardwareAddr: link.Attrs().HardwareAddr,
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// minRateLimitBurst is the smallest bucket, in bytes, of a rate
+	// limit. tbf drops packets larger than its bucket, and with GSO a
+	// veth hands it packets of up to 64KiB.
+	minRateLimitBurst = 64 * 1024
+	// rateLimitLatency is how long, in seconds, a packet may wait for
+	// tokens before it is dropped
+	rateLimitLatency = 0.025
+)
+
+// SetRateLimit limits the traffic sent by the link named ifName to rate
+// bits per second with a tbf root qdisc, replacing the one it has. burst
+// is the size of the bucket in bits, 0 for about 10ms of traffic at rate.
+func SetRateLimit(ifName string, rate, burst uint64) error {
+	if rate == 0 {
+		return fmt.Errorf("invalid rate limit 0 on %q", ifName)
+	}
+	// the kernel takes the rate as 32 bits of bytes per second
+	if rate/8 > math.MaxUint32 {
+		return fmt.Errorf("rate limit %d on %q exceeds %d bits per second", rate, ifName, uint64(math.MaxUint32)*8)
+	}
+
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+
+	rateBytes := rate / 8
+	burstBytes := burst / 8
+	if burst == 0 {
+		burstBytes = rateBytes / 100
+	}
+	if burstBytes < minRateLimitBurst {
+		burstBytes = minRateLimitBurst
+	}
+	limit := float64(rateBytes)*rateLimitLatency + float64(burstBytes)
+	if limit > math.MaxUint32 {
+		limit = math.MaxUint32
+	}
+
+	if err := ClearRateLimit(ifName); err != nil {
+		return err
+	}
+	qdisc := &netlink.Tbf{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(1, 0),
+			Parent:    netlink.HANDLE_ROOT,
+		},
+		Rate:   rateBytes,
+		Limit:  uint32(limit),
+		Buffer: uint32(netlink.Xmittime(rateBytes, uint32(burstBytes))),
+	}
+	if err := netlink.QdiscAdd(qdisc); err != nil {
+		return fmt.Errorf("failed to add tbf qdisc to %q: %v", ifName, err)
+	}
+	return nil
+}
+
+// RateLimit returns the rate in bits per second the link named ifName
+// is limited to by SetRateLimit, 0 if it is not
+func RateLimit(ifName string) (uint64, error) {
+	tbf, err := rootTbf(ifName)
+	if err != nil || tbf == nil {
+		return 0, err
+	}
+	return tbf.Rate * 8, nil
+}
+
+// ClearRateLimit removes the rate limit of the link named ifName, if it
+// has one
+func ClearRateLimit(ifName string) error {
+	tbf, err := rootTbf(ifName)
+	if err != nil || tbf == nil {
+		return err
+	}
+	if err := netlink.QdiscDel(tbf); err != nil {
+		return fmt.Errorf("failed to remove tbf qdisc of %q: %v", ifName, err)
+	}
+	return nil
+}
+
+// rootTbf returns the tbf root qdisc of the link named ifName, or nil
+func rootTbf(ifName string) (*netlink.Tbf, error) {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list qdiscs of %q: %v", ifName, err)
+	}
+	for _, q := range qdiscs {
+		if tbf, ok := q.(*netlink.Tbf); ok && q.Attrs().Parent == netlink.HANDLE_ROOT {
+			return tbf, nil
+		}
+	}
+	return nil, nil
+}
@@ -15,9 +15,12 @@
 package ip
 
 import (
+	"fmt"
 	"net"
+	"syscall"
 
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
 )
 
 // AddDefaultRoute sets the default route on the given gateway.
@@ -45,3 +48,152 @@ func AddHostRoute(ipn *net.IPNet, gw net.IP, dev netlink.Link) error {
 		Gw:        gw,
 	})
 }
+
+// RouteOptions holds the optional attributes of a route. Zero values
+// select the kernel defaults: universe scope, the main table, no
+// metric, MTU or advertised MSS and a source address picked by the
+// kernel.
+type RouteOptions struct {
+	Scope    netlink.Scope
+	Table    int
+	Priority int
+	MTU      int
+	AdvMSS   int
+	// LockMTU keeps path MTU discovery from lowering or raising MTU
+	LockMTU bool
+	// OnLink treats the gateway as directly reachable through the device
+	// even when it is not covered by any of the device's subnets
+	OnLink bool
+	// Src is the preferred source address for traffic using the route
+	Src net.IP
+}
+
+// RouteExistsError is returned by AddRouteWithOptions when a route to
+// the same destination is already present in the table
+type RouteExistsError struct {
+	Dst *net.IPNet
+}
+
+func (e *RouteExistsError) Error() string {
+	return fmt.Sprintf("route to %v already exists", e.Dst)
+}
+
+// IsRouteExists returns true if err reports that a route being added is
+// already present, either as a *RouteExistsError or as the raw EEXIST
+// returned by netlink.RouteAdd.
+func IsRouteExists(err error) bool {
+	switch err := err.(type) {
+	case *RouteExistsError:
+		return true
+	case syscall.Errno:
+		return err == syscall.EEXIST
+	}
+	return false
+}
+
+// AddRouteWithOptions adds a route to a device with the given attributes.
+// netlink.RouteAdd has no support for tables, metrics and the like, so the
+// request is built here. A *RouteExistsError is returned if a route to
+// the destination already exists.
+func AddRouteWithOptions(ipn *net.IPNet, gw net.IP, dev netlink.Link, opts RouteOptions) error {
+	err := routeRequest(syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, ipn, gw, dev, opts)
+	if err == syscall.EEXIST {
+		return &RouteExistsError{Dst: ipn}
+	}
+	return err
+}
+
+// ReplaceRoute adds a universally-scoped route to a device, replacing
+// a route to the same destination if one exists.
+func ReplaceRoute(ipn *net.IPNet, gw net.IP, dev netlink.Link) error {
+	return ReplaceRouteWithOptions(ipn, gw, dev, RouteOptions{})
+}
+
+// ReplaceRouteWithOptions is like AddRouteWithOptions but replaces a
+// route to the same destination if one exists, so that retrying a
+// partially completed setup succeeds.
+func ReplaceRouteWithOptions(ipn *net.IPNet, gw net.IP, dev netlink.Link, opts RouteOptions) error {
+	return routeRequest(syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE, ipn, gw, dev, opts)
+}
+
+func routeRequest(flags int, ipn *net.IPNet, gw net.IP, dev netlink.Link, opts RouteOptions) error {
+	if ipn == nil || ipn.IP == nil {
+		return fmt.Errorf("route destination must not be nil")
+	}
+
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWROUTE, flags|syscall.NLM_F_ACK)
+	msg := nl.NewRtMsg()
+	msg.Scope = uint8(opts.Scope)
+
+	family := nl.GetIPFamily(ipn.IP)
+	msg.Family = uint8(family)
+	dstLen, _ := ipn.Mask.Size()
+	msg.Dst_len = uint8(dstLen)
+
+	attrs := []*nl.RtAttr{nl.NewRtAttr(syscall.RTA_DST, ipBytes(ipn.IP, family))}
+
+	if gw != nil {
+		if nl.GetIPFamily(gw) != family {
+			return fmt.Errorf("gateway %v and destination %v are not the same IP family", gw, ipn)
+		}
+		attrs = append(attrs, nl.NewRtAttr(syscall.RTA_GATEWAY, ipBytes(gw, family)))
+	}
+
+	if opts.OnLink {
+		if gw == nil {
+			return fmt.Errorf("onlink route to %v requires a gateway", ipn)
+		}
+		msg.Flags |= syscall.RTNH_F_ONLINK
+	}
+
+	if opts.Src != nil {
+		if nl.GetIPFamily(opts.Src) != family {
+			return fmt.Errorf("source %v and destination %v are not the same IP family", opts.Src, ipn)
+		}
+		attrs = append(attrs, nl.NewRtAttr(syscall.RTA_PREFSRC, ipBytes(opts.Src, family)))
+	}
+
+	if opts.Table > 0 {
+		if opts.Table < 256 {
+			msg.Table = uint8(opts.Table)
+		} else {
+			msg.Table = syscall.RT_TABLE_UNSPEC
+		}
+		attrs = append(attrs, nl.NewRtAttr(syscall.RTA_TABLE, nl.Uint32Attr(uint32(opts.Table))))
+	}
+
+	if opts.Priority > 0 {
+		attrs = append(attrs, nl.NewRtAttr(syscall.RTA_PRIORITY, nl.Uint32Attr(uint32(opts.Priority))))
+	}
+
+	if opts.MTU > 0 || opts.AdvMSS > 0 {
+		metrics := nl.NewRtAttr(syscall.RTA_METRICS, nil)
+		if opts.MTU > 0 {
+			nl.NewRtAttrChild(metrics, syscall.RTAX_MTU, nl.Uint32Attr(uint32(opts.MTU)))
+			if opts.LockMTU {
+				nl.NewRtAttrChild(metrics, syscall.RTAX_LOCK, nl.Uint32Attr(1<<syscall.RTAX_MTU))
+			}
+		}
+		if opts.AdvMSS > 0 {
+			nl.NewRtAttrChild(metrics, syscall.RTAX_ADVMSS, nl.Uint32Attr(uint32(opts.AdvMSS)))
+		}
+		attrs = append(attrs, metrics)
+	}
+
+	attrs = append(attrs, nl.NewRtAttr(syscall.RTA_OIF, nl.Uint32Attr(uint32(dev.Attrs().Index))))
+
+	req.AddData(msg)
+	for _, attr := range attrs {
+		req.AddData(attr)
+	}
+
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+func ipBytes(ip net.IP, family int) []byte {
+	if family == netlink.FAMILY_V4 {
+		return ip.To4()
+	}
+	return ip.To16()
+}
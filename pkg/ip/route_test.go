@@ -0,0 +1,122 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"net"
+
+	"github.com/containernetworking/cni/pkg/ns"
+
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("routes", func() {
+	var (
+		netns ns.NetNS
+		link  netlink.Link
+		dst   *net.IPNet
+	)
+
+	BeforeEach(func() {
+		var err error
+		netns, err = ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		err = netns.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}, PeerName: "eth1"})
+			Expect(err).NotTo(HaveOccurred())
+			link, err = netlink.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetUp(link)).To(Succeed())
+			Expect(netlink.AddrAdd(link, &netlink.Addr{IPNet: mustParseCIDR("10.0.0.1/24")})).To(Succeed())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		dst = mustParseCIDR("192.168.0.0/24")
+	})
+
+	AfterEach(func() {
+		Expect(netns.Close()).To(Succeed())
+	})
+
+	// routesTo returns the gateways of the routes of link to dst
+	routesTo := func(dst *net.IPNet) []string {
+		routes, err := netlink.RouteList(link, netlink.FAMILY_V4)
+		Expect(err).NotTo(HaveOccurred())
+		var gws []string
+		for _, r := range routes {
+			if r.Dst != nil && r.Dst.String() == dst.String() {
+				gws = append(gws, r.Gw.String())
+			}
+		}
+		return gws
+	}
+
+	It("replaces the route to a destination", func() {
+		err := netns.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			Expect(AddRoute(dst, net.ParseIP("10.0.0.2"), link)).To(Succeed())
+			Expect(ReplaceRoute(dst, net.ParseIP("10.0.0.3"), link)).To(Succeed())
+			Expect(routesTo(dst)).To(Equal([]string{"10.0.0.3"}))
+
+			// and retrying changes nothing
+			Expect(ReplaceRoute(dst, net.ParseIP("10.0.0.3"), link)).To(Succeed())
+			Expect(routesTo(dst)).To(Equal([]string{"10.0.0.3"}))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("adds a route with ReplaceRoute if there is none", func() {
+		err := netns.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			Expect(ReplaceRouteWithOptions(dst, net.ParseIP("10.0.0.2"), link, RouteOptions{Priority: 10})).To(Succeed())
+			Expect(routesTo(dst)).To(Equal([]string{"10.0.0.2"}))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("reports a route that already exists", func() {
+		err := netns.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			Expect(AddRoute(dst, net.ParseIP("10.0.0.2"), link)).To(Succeed())
+
+			err := AddRouteWithOptions(dst, net.ParseIP("10.0.0.3"), link, RouteOptions{})
+			Expect(err).To(Equal(&RouteExistsError{Dst: dst}))
+			Expect(IsRouteExists(err)).To(BeTrue())
+			Expect(IsRouteExists(AddRoute(dst, net.ParseIP("10.0.0.3"), link))).To(BeTrue())
+			Expect(routesTo(dst)).To(Equal([]string{"10.0.0.2"}))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("rejects a gateway of the other family", func() {
+		err := netns.Do(func(ns.NetNS) error {
+			return ReplaceRoute(dst, net.ParseIP("fd00::1"), link)
+		})
+		Expect(err).To(MatchError("gateway fd00::1 and destination 192.168.0.0/24 are not the same IP family"))
+	})
+})
@@ -0,0 +1,127 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"net"
+)
+
+// spoofCheckHook is the base chain of the bridge family "cni" table that
+// jumps to the chain of each checked port
+const spoofCheckHook = "PREROUTING"
+
+// SetupSpoofCheck installs nftables rules, in chain of the bridge family
+// "cni" table, that drop frames entering a bridge from the port hostIface
+// unless they come from mac and, for ARP, IPv4 and IPv6, from one of ips.
+// IPv6 link-local and unspecified sources stay allowed for neighbor
+// discovery. Calling it again for the same chain replaces the rules.
+func SetupSpoofCheck(hostIface string, mac net.HardwareAddr, ips []net.IP, chain, comment string) error {
+	nft, err := newNFTables()
+	if err != nil {
+		return err
+	}
+
+	prerouting := nftCmd{"chain": map[string]interface{}{
+		"family": "bridge",
+		"table":  nftTable,
+		"name":   spoofCheckHook,
+		"type":   "filter",
+		"hook":   "prerouting",
+		"prio":   -300,
+		"policy": "accept",
+	}}
+
+	var ip4s, ip6s []interface{}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			ip4s = append(ip4s, ip.String())
+		} else {
+			ip6s = append(ip6s, ip.String())
+		}
+	}
+
+	drop := map[string]interface{}{"drop": nil}
+	accept := map[string]interface{}{"accept": nil}
+	rule := func(expr ...interface{}) nftCmd {
+		return nftCmd{"add": nftRule("bridge", chain, comment, expr...)}
+	}
+
+	cmds := []nftCmd{
+		{"add": nftCmd{"table": map[string]interface{}{"family": "bridge", "name": nftTable}}},
+		{"add": prerouting},
+		{"add": nftChain("bridge", chain)},
+		{"flush": nftChain("bridge", chain)},
+		rule(nftMatch("!=", nftPayload("ether", "saddr"), mac.String()), drop),
+		rule(append(nftMatchSources("arp", "arp", "saddr ip", ip4s), drop)...),
+		rule(append(nftMatchSources("ip", "ip", "saddr", ip4s), drop)...),
+		rule(nftMatchEtherType("ip6"), nftMatchAddr("ip6", "saddr", "==", &net.IPNet{IP: net.ParseIP("fe80::"), Mask: net.CIDRMask(10, 128)}), accept),
+		rule(nftMatchEtherType("ip6"), nftMatch("==", nftPayload("ip6", "saddr"), net.IPv6unspecified.String()), accept),
+		rule(append(nftMatchSources("ip6", "ip6", "saddr", ip6s), drop)...),
+	}
+	if err := nft.apply(cmds...); err != nil {
+		return err
+	}
+
+	handles, err := nft.jumpHandles("bridge", spoofCheckHook, chain)
+	if err != nil {
+		return err
+	}
+	if len(handles) > 0 {
+		return nil
+	}
+
+	return nft.apply(nftCmd{"add": nftRule("bridge", spoofCheckHook, comment,
+		nftMatch("==", map[string]interface{}{"meta": map[string]interface{}{"key": "iifname"}}, hostIface),
+		map[string]interface{}{"jump": map[string]interface{}{"target": chain}})})
+}
+
+// TeardownSpoofCheck removes the rules installed by SetupSpoofCheck. It
+// succeeds if they are already gone.
+func TeardownSpoofCheck(chain string) error {
+	nft, err := newNFTables()
+	if err != nil {
+		return err
+	}
+	return nft.teardownChain("bridge", spoofCheckHook, chain)
+}
+
+func nftPayload(protocol, field string) interface{} {
+	return map[string]interface{}{"payload": map[string]interface{}{
+		"protocol": protocol,
+		"field":    field,
+	}}
+}
+
+func nftMatch(op string, left, right interface{}) interface{} {
+	return map[string]interface{}{"match": map[string]interface{}{
+		"op":    op,
+		"left":  left,
+		"right": right,
+	}}
+}
+
+func nftMatchEtherType(etherType string) interface{} {
+	return nftMatch("==", nftPayload("ether", "type"), etherType)
+}
+
+// nftMatchSources matches packets of etherType whose source field is
+// not one of addrs, which is every such packet if addrs is empty
+func nftMatchSources(etherType, protocol, field string, addrs []interface{}) []interface{} {
+	expr := []interface{}{nftMatchEtherType(etherType)}
+	if len(addrs) > 0 {
+		expr = append(expr, nftMatch("!=", nftPayload(protocol, field), map[string]interface{}{"set": addrs}))
+	}
+	return expr
+}
@@ -0,0 +1,174 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipam
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+)
+
+const defaultConfCacheDir = "/var/lib/cni/ipam"
+
+// ConfCache keeps a copy of the IPAM plugin and network configuration
+// a container was added with, so that its addresses can be released
+// with the same configuration even after the network configuration on
+// disk has been changed or removed. Plugins may also keep the result of
+// the ADD, to tear down what it set up once the container is gone.
+// Entries are keyed by the interface name the runtime asked for; the
+// result records the name the interface was given if that was taken.
+type ConfCache struct {
+	dir string
+}
+
+type cachedConf struct {
+	Plugin  string          `json:"plugin"`
+	Netconf json.RawMessage `json:"netconf"`
+	Result  *current.Result `json:"result,omitempty"`
+}
+
+// NewConfCache returns a cache storing its entries in dir, or in
+// /var/lib/cni/ipam if dir is empty
+func NewConfCache(dir string) *ConfCache {
+	if dir == "" {
+		dir = defaultConfCacheDir
+	}
+	return &ConfCache{dir: dir}
+}
+
+func (c *ConfCache) path(containerID, ifName string) string {
+	return filepath.Join(c.dir, containerID+"-"+ifName)
+}
+
+// Save records the configuration used for the interface ifName of
+// the container
+func (c *ConfCache) Save(containerID, ifName, plugin string, netconf []byte) error {
+	return c.write(containerID, ifName, &cachedConf{Plugin: plugin, Netconf: netconf})
+}
+
+// SaveResult adds result to the configuration saved for the interface
+// ifName of the container
+func (c *ConfCache) SaveResult(containerID, ifName string, result *current.Result) error {
+	conf, err := c.read(containerID, ifName)
+	if err != nil {
+		return err
+	}
+	if conf == nil {
+		return fmt.Errorf("no cached IPAM config for %s/%s", containerID, ifName)
+	}
+	conf.Result = result
+	return c.write(containerID, ifName, conf)
+}
+
+// Load returns the configuration saved for the interface ifName of the
+// container. found is false if nothing was saved.
+func (c *ConfCache) Load(containerID, ifName string) (plugin string, netconf []byte, found bool, err error) {
+	conf, err := c.read(containerID, ifName)
+	if err != nil || conf == nil {
+		return "", nil, false, err
+	}
+	return conf.Plugin, conf.Netconf, true, nil
+}
+
+// LoadResult returns the result saved for the interface ifName of the
+// container, or nil if there is none
+func (c *ConfCache) LoadResult(containerID, ifName string) (*current.Result, error) {
+	conf, err := c.read(containerID, ifName)
+	if err != nil || conf == nil {
+		return nil, err
+	}
+	return conf.Result, nil
+}
+
+func (c *ConfCache) write(containerID, ifName string, conf *cachedConf) error {
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path(containerID, ifName), data, 0600)
+}
+
+// read returns nil if nothing was saved for the interface ifName of the
+// container
+func (c *ConfCache) read(containerID, ifName string) (*cachedConf, error) {
+	data, err := ioutil.ReadFile(c.path(containerID, ifName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &cachedConf{}
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("failed to parse cached IPAM config for %s/%s: %v", containerID, ifName, err)
+	}
+	return conf, nil
+}
+
+// Remove deletes the configuration saved for the interface ifName of
+// the container, if any
+func (c *ConfCache) Remove(containerID, ifName string) error {
+	err := os.Remove(c.path(containerID, ifName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ExecAddWithCache is like ExecAdd, and saves plugin and netconf to
+// cache once the addresses are allocated. If they cannot be saved the
+// addresses are released again.
+func ExecAddWithCache(cache *ConfCache, containerID, ifName, plugin string, netconf []byte) (*types.Result, error) {
+	result, err := ExecAdd(plugin, netconf)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cache.Save(containerID, ifName, plugin, netconf); err != nil {
+		invoke.DelegateDelWithOptions(plugin, netconf, &invoke.DelegateOptions{
+			Env: map[string]string{"CNI_COMMAND": "DEL"},
+		})
+		return nil, fmt.Errorf("failed to cache IPAM config: %v", err)
+	}
+	return result, nil
+}
+
+// ExecDelWithCache is like ExecDel, but prefers the plugin and netconf
+// saved by ExecAddWithCache over the ones given, and removes them from
+// cache once the addresses are released.
+func ExecDelWithCache(cache *ConfCache, containerID, ifName, plugin string, netconf []byte) error {
+	cachedPlugin, cachedNetconf, found, err := cache.Load(containerID, ifName)
+	if err != nil {
+		return err
+	}
+	if found {
+		plugin, netconf = cachedPlugin, cachedNetconf
+	}
+
+	if err := ExecDel(plugin, netconf); err != nil {
+		return err
+	}
+	return cache.Remove(containerID, ifName)
+}
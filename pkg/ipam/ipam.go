@@ -16,11 +16,13 @@ package ipam
 
 import (
 	"fmt"
-	"os"
+	"net"
 
 	"github.com/containernetworking/cni/pkg/invoke"
 	"github.com/containernetworking/cni/pkg/ip"
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/cni/pkg/utils/sysctl"
 
 	"github.com/vishvananda/netlink"
 )
@@ -29,36 +31,89 @@ func ExecAdd(plugin string, netconf []byte) (*types.Result, error) {
 	return invoke.DelegateAdd(plugin, netconf)
 }
 
+func ExecCheck(plugin string, netconf []byte) error {
+	return invoke.DelegateCheck(plugin, netconf)
+}
+
 func ExecDel(plugin string, netconf []byte) error {
 	return invoke.DelegateDel(plugin, netconf)
 }
 
-// ConfigureIface takes the result of IPAM plugin and
-// applies to the ifName interface
-func ConfigureIface(ifName string, res *types.Result) error {
+// ConfigureIface takes the result of IPAM plugin and applies it to the
+// ifName interface: every IP address of either family that belongs to
+// the interface, and every route of the result. Routes without a
+// gateway use the gateway of the first address of the same family that
+// has one. It must be called inside the container network namespace.
+func ConfigureIface(ifName string, res *current.Result) error {
 	link, err := netlink.LinkByName(ifName)
 	if err != nil {
 		return fmt.Errorf("failed to lookup %q: %v", ifName, err)
 	}
 
+	ips, err := ifaceIPs(ifName, res)
+	if err != nil {
+		return fmt.Errorf("failed to add IP addr to %q: %v", ifName, err)
+	}
+	hasIPv6 := false
+	for _, ipc := range ips {
+		if ipc.Address.IP.To4() == nil {
+			hasIPv6 = true
+		}
+	}
+
+	if hasIPv6 {
+		// IPAM hands out unique addresses, so duplicate address detection
+		// only delays the address becoming usable
+		for _, setting := range []string{"disable_ipv6", "accept_dad"} {
+			name := fmt.Sprintf("net/ipv6/conf/%s/%s", ifName, setting)
+			if _, err := sysctl.Sysctl(name, "0"); err != nil {
+				return fmt.Errorf("failed to set %s: %v", name, err)
+			}
+		}
+	}
+
 	if err := netlink.LinkSetUp(link); err != nil {
 		return fmt.Errorf("failed to set %q UP: %v", ifName, err)
 	}
 
-	// TODO(eyakubovich): IPv6
-	addr := &netlink.Addr{IPNet: &res.IP4.IP, Label: ""}
-	if err = netlink.AddrAdd(link, addr); err != nil {
-		return fmt.Errorf("failed to add IP addr to %q: %v", ifName, err)
+	var gw4, gw6 net.IP
+	for _, ipc := range ips {
+		addr := &netlink.Addr{IPNet: &ipc.Address, Label: ""}
+		if err = netlink.AddrAdd(link, addr); err != nil {
+			return fmt.Errorf("failed to add IP addr %v to %q: %v", ipc.Address, ifName, err)
+		}
+
+		if ipc.Gateway == nil {
+			continue
+		}
+		if ipc.Address.IP.To4() != nil {
+			if gw4 == nil {
+				gw4 = ipc.Gateway
+			}
+		} else if gw6 == nil {
+			gw6 = ipc.Gateway
+		}
 	}
 
-	for _, r := range res.IP4.Routes {
+	for _, r := range res.Routes {
 		gw := r.GW
 		if gw == nil {
-			gw = res.IP4.Gateway
+			gw = gw6
+			if r.Dst.IP.To4() != nil {
+				gw = gw4
+			}
+		}
+		opts := ip.RouteOptions{
+			Scope:    netlink.Scope(r.Scope),
+			Table:    r.Table,
+			Priority: r.Priority,
+			MTU:      r.MTU,
+			AdvMSS:   r.AdvMSS,
+			LockMTU:  r.LockMTU,
 		}
-		if err = ip.AddRoute(&r.Dst, gw, link); err != nil {
+		if err = ip.AddRouteWithOptions(&r.Dst, gw, link, opts); err != nil {
 			// we skip over duplicate routes as we assume the first one wins
-			if !os.IsExist(err) {
+			if !ip.IsRouteExists(err) {
 				return fmt.Errorf("failed to add route '%v via %v dev %v': %v", r.Dst, gw, ifName, err)
 			}
 		}
@@ -66,3 +121,56 @@ func ConfigureIface(ifName string, res *types.Result) error {
 
 	return nil
 }
+
+// CheckIface verifies that the ifName interface still carries every IP
+// address of the result that belongs to it. It must be called inside
+// the container network namespace.
+func CheckIface(ifName string, res *current.Result) error {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+
+	ips, err := ifaceIPs(ifName, res)
+	if err != nil {
+		return fmt.Errorf("failed to check IP addrs of %q: %v", ifName, err)
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("failed to list IP addrs of %q: %v", ifName, err)
+	}
+
+	for _, ipc := range ips {
+		found := false
+		for _, addr := range addrs {
+			if addr.IPNet.String() == ipc.Address.String() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%q is missing IP addr %v", ifName, ipc.Address.String())
+		}
+	}
+	return nil
+}
+
+// ifaceIPs returns the IP configs of res that belong to ifName: those
+// pointing at its entry in res.Interfaces, and those not pointing at any
+func ifaceIPs(ifName string, res *current.Result) ([]*current.IPConfig, error) {
+	var ips []*current.IPConfig
+	for _, ipc := range res.IPs {
+		if ipc.Interface != nil {
+			idx := *ipc.Interface
+			if idx < 0 || idx >= len(res.Interfaces) {
+				return nil, fmt.Errorf("invalid interface index %d for %v", idx, ipc.Address.String())
+			}
+			if res.Interfaces[idx].Name != ifName {
+				continue
+			}
+		}
+		ips = append(ips, ipc)
+	}
+	return ips, nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"os"
+	"sync"
+)
+
+var (
+	defaultMu     sync.RWMutex
+	defaultLogger = NewWithWriter(os.Stderr, DefaultLevel, FormatText)
+)
+
+// Default returns the logger used by the package level functions
+func Default() *Logger {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLogger
+}
+
+// SetDefault replaces the logger used by the package level functions
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLogger = l
+}
+
+// Setup creates a logger from conf with the given key/value fields and
+// makes it the default. Plugins typically call it once per invocation
+// and defer the returned close function:
+//
+//	closeLog, err := logging.Setup(n.Log, "plugin", "bridge", "containerID", args.ContainerID)
+//	if err != nil {
+//		return err
+//	}
+//	defer closeLog()
+func Setup(conf Config, kv ...interface{}) (func(), error) {
+	l, err := New(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	prev := Default()
+	SetDefault(l.With(kv...))
+	return func() {
+		SetDefault(prev)
+		l.Close()
+	}, nil
+}
+
+func Debugf(format string, args ...interface{}) { Default().Debugf(format, args...) }
+func Infof(format string, args ...interface{})  { Default().Infof(format, args...) }
+func Warnf(format string, args ...interface{})  { Default().Warnf(format, args...) }
+func Errorf(format string, args ...interface{}) { Default().Errorf(format, args...) }
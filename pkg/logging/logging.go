@@ -0,0 +1,273 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logging is a small leveled, structured logger for plugins.
+// Plugins must never write logs to stdout, which carries their result,
+// so output goes to stderr unless a log file is configured.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log message
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// DefaultLevel keeps plugins quiet unless something goes wrong
+const DefaultLevel = LevelWarn
+
+// ParseLevel parses a level name; the empty string selects DefaultLevel
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return DefaultLevel, nil
+	case "warning":
+		return LevelWarn, nil
+	}
+	for l, name := range levelNames {
+		if strings.ToLower(s) == name {
+			return l, nil
+		}
+	}
+	return DefaultLevel, fmt.Errorf("unknown log level %q", s)
+}
+
+// Format selects how each log line is encoded
+type Format string
+
+const (
+	// FormatText writes logfmt style key=value lines
+	FormatText Format = "text"
+	// FormatJSON writes one JSON object per line
+	FormatJSON Format = "json"
+)
+
+// Environment variables that override the corresponding Config fields
+const (
+	EnvLevel  = "CNI_LOG_LEVEL"
+	EnvFormat = "CNI_LOG_FORMAT"
+	EnvFile   = "CNI_LOG_FILE"
+)
+
+// Config is the "log" section of a network configuration. Every field is
+// optional and may be overridden by the CNI_LOG_* environment variables.
+type Config struct {
+	Level  string `json:"level,omitempty"`
+	Format Format `json:"format,omitempty"`
+	// File is appended to instead of writing to stderr
+	File string `json:"file,omitempty"`
+}
+
+// withEnv returns a copy of c with the environment overrides applied
+func (c Config) withEnv(getenv func(string) string) Config {
+	if v := getenv(EnvLevel); v != "" {
+		c.Level = v
+	}
+	if v := getenv(EnvFormat); v != "" {
+		c.Format = Format(v)
+	}
+	if v := getenv(EnvFile); v != "" {
+		c.File = v
+	}
+	return c
+}
+
+// Logger writes leveled messages annotated with key/value fields.
+// A Logger is safe for concurrent use.
+type Logger struct {
+	out    *output
+	level  Level
+	format Format
+	fields []interface{}
+}
+
+// output is shared between a Logger and those derived from it by With
+type output struct {
+	mu sync.Mutex
+	w  io.Writer
+	c  io.Closer
+}
+
+func (o *output) write(line []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// a failure to log must never fail the plugin
+	o.w.Write(line)
+}
+
+// New creates a Logger from conf after applying the CNI_LOG_* environment
+// overrides. The caller must Close the logger if it may have opened a file.
+func New(conf Config) (*Logger, error) {
+	conf = conf.withEnv(os.Getenv)
+
+	level, err := ParseLevel(conf.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	switch conf.Format {
+	case "":
+		conf.Format = FormatText
+	case FormatText, FormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown log format %q", conf.Format)
+	}
+
+	out := &output{w: os.Stderr}
+	if conf.File != "" {
+		f, err := os.OpenFile(conf.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %v", err)
+		}
+		out.w = f
+		out.c = f
+	}
+
+	return &Logger{out: out, level: level, format: conf.Format}, nil
+}
+
+// NewWithWriter creates a Logger writing to w, ignoring the environment
+func NewWithWriter(w io.Writer, level Level, format Format) *Logger {
+	return &Logger{out: &output{w: w}, level: level, format: format}
+}
+
+// With returns a Logger that adds the given key/value pairs to every
+// message. A trailing key without a value is logged with a nil value.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv)+1)
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	if len(kv)%2 != 0 {
+		fields = append(fields, nil)
+	}
+	return &Logger{out: l.out, level: l.level, format: l.format, fields: fields}
+}
+
+// Enabled reports whether messages at level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Close closes the log file, if any
+func (l *Logger) Close() error {
+	if l.out.c == nil {
+		return nil
+	}
+	return l.out.c.Close()
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) { l.logf(LevelDebug, format, args...) }
+func (l *Logger) Infof(format string, args ...interface{})  { l.logf(LevelInfo, format, args...) }
+func (l *Logger) Warnf(format string, args ...interface{})  { l.logf(LevelWarn, format, args...) }
+func (l *Logger) Errorf(format string, args ...interface{}) { l.logf(LevelError, format, args...) }
+
+func (l *Logger) logf(level Level, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	now := time.Now().UTC()
+
+	var line []byte
+	if l.format == FormatJSON {
+		line = l.encodeJSON(now, level, msg)
+	} else {
+		line = l.encodeText(now, level, msg)
+	}
+	l.out.write(line)
+}
+
+func (l *Logger) encodeJSON(now time.Time, level Level, msg string) []byte {
+	entry := map[string]interface{}{}
+	for i := 0; i+1 < len(l.fields); i += 2 {
+		entry[fmt.Sprint(l.fields[i])] = jsonValue(l.fields[i+1])
+	}
+	entry["time"] = now.Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{
+			"time":  now.Format(time.RFC3339Nano),
+			"level": level.String(),
+			"msg":   msg,
+			"error": fmt.Sprintf("failed to encode log fields: %v", err),
+		})
+	}
+	return append(data, '\n')
+}
+
+// jsonValue keeps values that marshal naturally and stringifies the rest,
+// such as errors, which would otherwise encode as {}
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, bool, string, int, int32, int64, uint, uint32, uint64, float32, float64, json.Marshaler:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return v
+	}
+}
+
+func (l *Logger) encodeText(now time.Time, level Level, msg string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "time=%s level=%s msg=%s", now.Format(time.RFC3339Nano), level, quote(msg))
+	for i := 0; i+1 < len(l.fields); i += 2 {
+		fmt.Fprintf(&buf, " %s=%s", quote(fmt.Sprint(l.fields[i])), quote(fmt.Sprint(l.fields[i+1])))
+	}
+
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// quote returns s unchanged unless it needs quoting to stay one token
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") || !strconv.CanBackquote(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
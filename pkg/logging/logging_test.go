@@ -0,0 +1,149 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/logging"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logger", func() {
+	var buf *bytes.Buffer
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+	})
+
+	It("drops messages below the configured level", func() {
+		l := logging.NewWithWriter(buf, logging.LevelWarn, logging.FormatText)
+		l.Debugf("debug")
+		l.Infof("info")
+		l.Warnf("warn")
+		l.Errorf("error")
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[0]).To(ContainSubstring("level=warn msg=warn"))
+		Expect(lines[1]).To(ContainSubstring("level=error msg=error"))
+	})
+
+	It("writes text lines with quoted fields", func() {
+		l := logging.NewWithWriter(buf, logging.LevelDebug, logging.FormatText)
+		l.With("containerID", "abc", "err", errors.New("no such device")).Infof("adding %s", "eth0")
+
+		Expect(buf.String()).To(HavePrefix("time="))
+		Expect(buf.String()).To(HaveSuffix(` level=info msg="adding eth0" containerID=abc err="no such device"` + "\n"))
+	})
+
+	It("writes JSON lines", func() {
+		l := logging.NewWithWriter(buf, logging.LevelDebug, logging.FormatJSON)
+		l.With("plugin", "bridge", "err", errors.New("boom"), "dangling").Debugf("hello")
+
+		entry := map[string]interface{}{}
+		Expect(json.Unmarshal(buf.Bytes(), &entry)).To(Succeed())
+		Expect(entry).To(HaveKeyWithValue("level", "debug"))
+		Expect(entry).To(HaveKeyWithValue("msg", "hello"))
+		Expect(entry).To(HaveKeyWithValue("plugin", "bridge"))
+		Expect(entry).To(HaveKeyWithValue("err", "boom"))
+		Expect(entry).To(HaveKeyWithValue("dangling", BeNil()))
+		Expect(entry).To(HaveKey("time"))
+	})
+
+	It("does not share fields between derived loggers", func() {
+		l := logging.NewWithWriter(buf, logging.LevelDebug, logging.FormatText)
+		a := l.With("a", 1)
+		a.With("b", 2)
+		a.Infof("x")
+		Expect(buf.String()).To(HaveSuffix(" a=1\n"))
+	})
+
+	Describe("New", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "logging")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.Unsetenv(logging.EnvLevel)
+			os.Unsetenv(logging.EnvFormat)
+			os.Unsetenv(logging.EnvFile)
+			os.RemoveAll(dir)
+		})
+
+		It("appends to the configured file", func() {
+			file := filepath.Join(dir, "cni.log")
+			for i := 0; i < 2; i++ {
+				l, err := logging.New(logging.Config{File: file, Format: logging.FormatJSON})
+				Expect(err).NotTo(HaveOccurred())
+				l.Warnf("line %d", i)
+				Expect(l.Close()).To(Succeed())
+			}
+
+			data, err := ioutil.ReadFile(file)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.Count(string(data), "\n")).To(Equal(2))
+		})
+
+		It("lets the environment override the config", func() {
+			file := filepath.Join(dir, "env.log")
+			os.Setenv(logging.EnvLevel, "debug")
+			os.Setenv(logging.EnvFormat, "json")
+			os.Setenv(logging.EnvFile, file)
+
+			l, err := logging.New(logging.Config{Level: "error", Format: logging.FormatText})
+			Expect(err).NotTo(HaveOccurred())
+			l.Debugf("from env")
+			Expect(l.Close()).To(Succeed())
+
+			data, err := ioutil.ReadFile(file)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(HavePrefix("{"))
+			Expect(string(data)).To(ContainSubstring(`"msg":"from env"`))
+		})
+
+		It("rejects unknown levels and formats", func() {
+			_, err := logging.New(logging.Config{Level: "loud"})
+			Expect(err).To(MatchError(`unknown log level "loud"`))
+
+			_, err = logging.New(logging.Config{Format: "xml"})
+			Expect(err).To(MatchError(`unknown log format "xml"`))
+		})
+	})
+
+	Describe("Setup", func() {
+		It("installs and restores the default logger", func() {
+			prev := logging.Default()
+			closeLog, err := logging.Setup(logging.Config{}, "plugin", "test")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(logging.Default() == prev).To(BeFalse())
+
+			closeLog()
+			Expect(logging.Default() == prev).To(BeTrue())
+		})
+	})
+})
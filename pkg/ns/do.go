@@ -0,0 +1,99 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ns
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+// PanicInfo describes a panic of a closure run in a network namespace.
+// Do re-raises the panics of its closure in the calling goroutine with a
+// *PanicInfo as the value, so that recover() there sees where the panic
+// happened; DoRecover returns it instead.
+type PanicInfo struct {
+	// Value is the value the closure panicked with
+	Value interface{}
+	// Stack is the stack of the goroutine that panicked, at the panic
+	Stack []byte
+}
+
+func newPanicInfo(value interface{}) *PanicInfo {
+	if p, ok := value.(*PanicInfo); ok {
+		return p
+	}
+	return &PanicInfo{Value: value, Stack: debug.Stack()}
+}
+
+func (p *PanicInfo) Error() string {
+	return fmt.Sprintf("panic in network namespace: %v", p.Value)
+}
+
+func (p *PanicInfo) String() string {
+	return fmt.Sprintf("%s\n\n%s", p.Error(), p.Stack)
+}
+
+// DoRecover is netns.Do, but returns a panic of toRun as a *PanicInfo
+// instead of re-raising it. The namespace of the thread that panicked is
+// never handed back to the scheduler, so the caller can carry on safely.
+func DoRecover(netns NetNS, toRun func(NetNS) error) (panicked *PanicInfo, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = newPanicInfo(r)
+		}
+	}()
+	return nil, netns.Do(toRun)
+}
+
+// MultiError is returned by DoAll when several of its functions fail
+type MultiError []error
+
+func (m MultiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap lets errors.Is and errors.As match any of the errors
+func (m MultiError) Unwrap() []error {
+	return m
+}
+
+// DoAll runs every function of toRun in order in one entry of netns,
+// saving the namespace switches of a Do for each. Every function runs
+// even if earlier ones fail; the error of the only one failing, or a
+// MultiError of all of them, is returned. A panic stops the remaining
+// functions and is re-raised as by Do.
+func DoAll(netns NetNS, toRun ...func(NetNS) error) error {
+	return netns.Do(func(hostNS NetNS) error {
+		var errs MultiError
+		for _, f := range toRun {
+			if err := f(hostNS); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		switch len(errs) {
+		case 0:
+			return nil
+		case 1:
+			return errs[0]
+		}
+		return errs
+	})
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ns_test
+
+import (
+	"errors"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Do variants", func() {
+	var (
+		targetNetNS   ns.NetNS
+		targetInode   uint64
+		originalInode uint64
+	)
+
+	BeforeEach(func() {
+		var err error
+		targetNetNS, err = ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		targetInode, err = getInodeNS(targetNetNS)
+		Expect(err).NotTo(HaveOccurred())
+		originalInode, err = getInodeCurNetNS()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(targetNetNS.Close()).To(Succeed())
+	})
+
+	It("re-raises panics in the caller", func() {
+		var recovered interface{}
+		func() {
+			defer func() { recovered = recover() }()
+			targetNetNS.Do(func(ns.NetNS) error {
+				panic("boom")
+			})
+		}()
+
+		info, ok := recovered.(*ns.PanicInfo)
+		Expect(ok).To(BeTrue())
+		Expect(info.Value).To(Equal("boom"))
+		Expect(string(info.Stack)).To(ContainSubstring("do_linux_test.go"))
+
+		inode, err := getInodeCurNetNS()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(inode).To(Equal(originalInode))
+	})
+
+	It("returns panics with DoRecover", func() {
+		panicked, err := ns.DoRecover(targetNetNS, func(ns.NetNS) error {
+			var m map[string]int
+			m["x"] = 1
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(panicked).NotTo(BeNil())
+		Expect(panicked.Error()).To(Equal("panic in network namespace: assignment to entry in nil map"))
+
+		panicked, err = ns.DoRecover(targetNetNS, func(ns.NetNS) error {
+			return errors.New("failed")
+		})
+		Expect(panicked).To(BeNil())
+		Expect(err).To(MatchError("failed"))
+	})
+
+	It("runs every function in one entry of the namespace", func() {
+		var inodes []uint64
+		record := func(ns.NetNS) error {
+			inode, err := getInodeCurNetNS()
+			inodes = append(inodes, inode)
+			return err
+		}
+
+		Expect(ns.DoAll(targetNetNS, record, record, record)).To(Succeed())
+		Expect(inodes).To(Equal([]uint64{targetInode, targetInode, targetInode}))
+	})
+
+	It("aggregates the errors of the functions", func() {
+		errA, errB := errors.New("a failed"), errors.New("b failed")
+		ran := 0
+		step := func(err error) func(ns.NetNS) error {
+			return func(ns.NetNS) error {
+				ran++
+				return err
+			}
+		}
+
+		err := ns.DoAll(targetNetNS, step(errA), step(nil), step(errB))
+		Expect(ran).To(Equal(3))
+		Expect(err).To(MatchError("a failed; b failed"))
+		Expect(errors.Is(err, errB)).To(BeTrue())
+
+		err = ns.DoAll(targetNetNS, step(nil), step(errA))
+		Expect(err).To(Equal(errA))
+	})
+})
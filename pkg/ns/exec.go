@@ -0,0 +1,87 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ns
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CommandError is returned by CommandOutput when the command cannot be
+// started or fails
+type CommandError struct {
+	// Args are the command and its arguments
+	Args []string
+	// Err is the error of starting or waiting for the command, an
+	// *exec.ExitError if it ran and failed
+	Err error
+	// Stderr is what the command wrote to stderr
+	Stderr []byte
+}
+
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("%s failed: %v", strings.Join(e.Args, " "), e.Err)
+	if stderr := strings.TrimSpace(string(e.Stderr)); stderr != "" {
+		msg += ": " + stderr
+	}
+	return msg
+}
+
+// Unwrap lets errors.As match the *exec.ExitError of a failed command
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// StartCommand starts cmd in netns, leaving the namespace of the caller
+// alone. The command inherits only its stdin, stdout and stderr and
+// cmd.ExtraFiles, even if the process has descriptors open without
+// close-on-exec, such as netlink sockets. Waiting for the command is up
+// to the caller.
+func StartCommand(netns NetNS, cmd *exec.Cmd) error {
+	return netns.Do(func(NetNS) error {
+		closeOnExec()
+		return cmd.Start()
+	})
+}
+
+// CommandOutput runs cmd in netns as StartCommand does, and returns what
+// it wrote to stdout. If cmd fails, the error is a *CommandError with
+// what it wrote to stderr, unless cmd.Stderr was set.
+func CommandOutput(netns NetNS, cmd *exec.Cmd) ([]byte, error) {
+	if cmd.Stdout != nil {
+		return nil, fmt.Errorf("%s: Stdout already set", cmd.Path)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	captured := cmd.Stderr == nil
+	if captured {
+		cmd.Stderr = &stderr
+	}
+
+	err := StartCommand(netns, cmd)
+	if err == nil {
+		err = cmd.Wait()
+	}
+	if err != nil {
+		cmdErr := &CommandError{Args: cmd.Args, Err: err}
+		if captured {
+			cmdErr.Stderr = stderr.Bytes()
+		}
+		return stdout.Bytes(), cmdErr
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ns_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Commands", func() {
+	var (
+		targetNetNS   ns.NetNS
+		targetInode   uint64
+		originalInode uint64
+	)
+
+	BeforeEach(func() {
+		var err error
+		targetNetNS, err = ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		targetInode, err = getInodeNS(targetNetNS)
+		Expect(err).NotTo(HaveOccurred())
+		originalInode, err = getInodeCurNetNS()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(targetNetNS.Close()).To(Succeed())
+	})
+
+	It("runs commands in the namespace, leaving the caller alone", func() {
+		out, err := ns.CommandOutput(targetNetNS, exec.Command("readlink", "/proc/self/ns/net"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(out)).To(Equal(fmt.Sprintf("net:[%d]\n", targetInode)))
+
+		inode, err := getInodeCurNetNS()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(inode).To(Equal(originalInode))
+	})
+
+	It("starts commands the caller waits for", func() {
+		cmd := exec.Command("sh", "-c", "exit 3")
+		Expect(ns.StartCommand(targetNetNS, cmd)).To(Succeed())
+		err := cmd.Wait()
+		Expect(err).To(BeAssignableToTypeOf(&exec.ExitError{}))
+	})
+
+	It("returns the stderr and exit status of failed commands", func() {
+		_, err := ns.CommandOutput(targetNetNS, exec.Command("sh", "-c", "echo oops >&2; exit 3"))
+		Expect(err).To(MatchError("sh -c echo oops >&2; exit 3 failed: exit status 3: oops"))
+
+		var exitErr *exec.ExitError
+		Expect(errors.As(err, &exitErr)).To(BeTrue())
+		Expect(exitErr.ExitCode()).To(Equal(3))
+
+		_, err = ns.CommandOutput(targetNetNS, exec.Command("/does/not/exist"))
+		Expect(err).To(BeAssignableToTypeOf(&ns.CommandError{}))
+	})
+
+	It("only passes on stdio and the extra files", func() {
+		// descriptors made by syscalls are not close-on-exec
+		fd, err := syscall.Dup(int(os.Stderr.Fd()))
+		Expect(err).NotTo(HaveOccurred())
+		defer syscall.Close(fd)
+
+		_, err = ns.CommandOutput(targetNetNS, exec.Command("test", "-e", fmt.Sprintf("/proc/self/fd/%d", fd)))
+		Expect(err).To(MatchError("test -e /proc/self/fd/" + fmt.Sprint(fd) + " failed: exit status 1"))
+
+		extra := os.NewFile(uintptr(fd), "extra")
+		cmd := exec.Command("test", "-e", "/proc/self/fd/3")
+		cmd.ExtraFiles = []*os.File{extra}
+		_, err = ns.CommandOutput(targetNetNS, cmd)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("refuses commands with their stdout set", func() {
+		cmd := exec.Command("true")
+		cmd.Stdout = os.Stdout
+		_, err := ns.CommandOutput(targetNetNS, cmd)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,97 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ns
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// Executor runs closures on a dedicated OS thread that stays in a network
+// namespace, so that callers doing many operations in the same namespace
+// do not have to switch namespaces for each of them. Closures run one at
+// a time, in the order Do is called.
+type Executor struct {
+	calls     chan func()
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewExecutor starts an Executor for netns. netns only needs to stay
+// open until NewExecutor returns.
+func NewExecutor(netns NetNS) (*Executor, error) {
+	e := &Executor{
+		calls: make(chan func()),
+		done:  make(chan struct{}),
+	}
+
+	started := make(chan error)
+	go e.loop(netns, started)
+	if err := <-started; err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *Executor) loop(netns NetNS, started chan<- error) {
+	// the thread is never unlocked: when the loop ends it exits with the
+	// goroutine instead of going back to the scheduler in netns
+	runtime.LockOSThread()
+
+	if err := netns.Set(); err != nil {
+		started <- err
+		return
+	}
+	started <- nil
+
+	for {
+		select {
+		case call := <-e.calls:
+			call()
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// Do runs toRun in the namespace of the Executor and returns its error.
+// A panic of toRun is returned as a *PanicInfo, and the Executor carries
+// on with the next closure.
+func (e *Executor) Do(toRun func() error) error {
+	result := make(chan error, 1)
+	call := func() {
+		defer func() {
+			if r := recover(); r != nil {
+				result <- newPanicInfo(r)
+			}
+		}()
+		result <- toRun()
+	}
+	select {
+	case e.calls <- call:
+		return <-result
+	case <-e.done:
+		return fmt.Errorf("executor has already been closed")
+	}
+}
+
+// Close stops the Executor and terminates its thread. Calls to Do
+// already in progress complete; later calls fail.
+func (e *Executor) Close() {
+	e.closeOnce.Do(func() {
+		close(e.done)
+	})
+}
@@ -0,0 +1,147 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ns_test
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Executor", func() {
+	var (
+		targetNetNS   ns.NetNS
+		targetInode   uint64
+		originalInode uint64
+		executor      *ns.Executor
+	)
+
+	BeforeEach(func() {
+		var err error
+		targetNetNS, err = ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		targetInode, err = getInodeNS(targetNetNS)
+		Expect(err).NotTo(HaveOccurred())
+		originalInode, err = getInodeCurNetNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		executor, err = ns.NewExecutor(targetNetNS)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		executor.Close()
+		Expect(targetNetNS.Close()).To(Succeed())
+	})
+
+	It("runs every call in the target namespace", func() {
+		for i := 0; i < 10; i++ {
+			var inode uint64
+			err := executor.Do(func() error {
+				var err error
+				inode, err = getInodeCurNetNS()
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(inode).To(Equal(targetInode))
+		}
+
+		inode, err := getInodeCurNetNS()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(inode).To(Equal(originalInode))
+	})
+
+	It("serializes concurrent calls", func() {
+		var wg sync.WaitGroup
+		running, maxRunning := 0, 0
+		var mu sync.Mutex
+
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(executor.Do(func() error {
+					mu.Lock()
+					running++
+					if running > maxRunning {
+						maxRunning = running
+					}
+					mu.Unlock()
+
+					runtime.Gosched()
+
+					mu.Lock()
+					running--
+					mu.Unlock()
+					return nil
+				})).To(Succeed())
+			}()
+		}
+		wg.Wait()
+		Expect(maxRunning).To(Equal(1))
+	})
+
+	It("returns the error of the call", func() {
+		err := executor.Do(func() error { return errors.New("potato") })
+		Expect(err).To(MatchError("potato"))
+	})
+
+	It("returns a panic of the call and keeps running", func() {
+		err := executor.Do(func() error { panic("oh no") })
+		Expect(err).To(MatchError("panic in network namespace: oh no"))
+		Expect(err).To(BeAssignableToTypeOf(&ns.PanicInfo{}))
+
+		var inode uint64
+		Expect(executor.Do(func() error {
+			var err error
+			inode, err = getInodeCurNetNS()
+			return err
+		})).To(Succeed())
+		Expect(inode).To(Equal(targetInode))
+	})
+
+	It("fails once it is closed", func() {
+		executor.Close()
+		err := executor.Do(func() error { return nil })
+		Expect(err).To(MatchError("executor has already been closed"))
+	})
+})
+
+var _ = Describe("GetCurrentNS", func() {
+	It("is safe to call from many goroutines", func() {
+		expected, err := getInodeCurNetNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				inode, err := getInodeCurNetNS()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(inode).To(Equal(expected))
+			}()
+		}
+		wg.Wait()
+	})
+})
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 
 	"github.com/containernetworking/cni/pkg/ns"
@@ -214,6 +215,76 @@ var _ = Describe("Linux namespace operations", func() {
 		})
 	})
 
+	Describe("creating and removing namespaces", func() {
+		var nsRunDir string
+
+		BeforeEach(func() {
+			var err error
+			nsRunDir, err = ioutil.TempDir("", "netns")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(nsRunDir)).To(Succeed())
+		})
+
+		It("mounts the namespace in the given directory", func() {
+			createdNetNS, err := ns.NewNSInDir(nsRunDir)
+			Expect(err).NotTo(HaveOccurred())
+			defer createdNetNS.Close()
+
+			Expect(filepath.Dir(createdNetNS.Path())).To(Equal(nsRunDir))
+			Expect(ns.IsNSorErr(createdNetNS.Path())).To(Succeed())
+		})
+
+		It("removes a namespace given its path", func() {
+			createdNetNS, err := ns.NewNSInDir(nsRunDir)
+			Expect(err).NotTo(HaveOccurred())
+			// the mount is gone after RemoveNS, so Close only closes the file
+			defer createdNetNS.Close()
+			nspath := createdNetNS.Path()
+
+			Expect(ns.RemoveNS(nspath)).To(Succeed())
+			_, err = os.Stat(nspath)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("does not fail to remove a namespace that is already gone", func() {
+			Expect(ns.RemoveNS(filepath.Join(nsRunDir, "does-not-exist"))).To(Succeed())
+		})
+	})
+
+	Describe("resolving the namespace of a process", func() {
+		It("returns the namespace of a running process", func() {
+			nspath, err := ns.NSPathFromPID(os.Getpid())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nspath).To(Equal(fmt.Sprintf("/proc/%d/ns/net", os.Getpid())))
+
+			pidNS, err := ns.GetNSFromPID(os.Getpid())
+			Expect(err).NotTo(HaveOccurred())
+			defer pidNS.Close()
+
+			expectedInode, err := getInode(nspath)
+			Expect(err).NotTo(HaveOccurred())
+			actualInode, err := getInodeNS(pidNS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(actualInode).To(Equal(expectedInode))
+		})
+
+		It("rejects invalid pids", func() {
+			_, err := ns.NSPathFromPID(0)
+			Expect(err).To(MatchError("invalid pid 0"))
+		})
+
+		It("reports processes that have exited", func() {
+			cmd := exec.Command("true")
+			Expect(cmd.Run()).To(Succeed())
+
+			_, err := ns.GetNSFromPID(cmd.Process.Pid)
+			Expect(err).To(BeAssignableToTypeOf(ns.NSPathDeadErr{}))
+		})
+	})
+
 	Describe("IsNSorErr", func() {
 		It("should detect a namespace", func() {
 			createdNetNS, err := ns.NewNS()
@@ -235,6 +306,28 @@ var _ = Describe("Linux namespace operations", func() {
 			Expect(err).NotTo(BeAssignableToTypeOf(ns.NSPathNotExistErr{}))
 		})
 
+		It("should detect a namespace whose mount is gone", func() {
+			createdNetNS, err := ns.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			nspath := createdNetNS.Path()
+			defer os.Remove(nspath)
+
+			Expect(unix.Unmount(nspath, unix.MNT_DETACH)).To(Succeed())
+			createdNetNS.Close()
+			Expect(ioutil.WriteFile(nspath, nil, 0644)).To(Succeed())
+
+			err = ns.IsNSorErr(nspath)
+			Expect(err).To(BeAssignableToTypeOf(ns.NSPathDeadErr{}))
+		})
+
+		It("should detect a namespace whose process has exited", func() {
+			cmd := exec.Command("true")
+			Expect(cmd.Run()).To(Succeed())
+
+			err := ns.IsNSorErr(fmt.Sprintf("/proc/%d/ns/net", cmd.Process.Pid))
+			Expect(err).To(BeAssignableToTypeOf(ns.NSPathDeadErr{}))
+		})
+
 		It("should error on non-existing paths", func() {
 			err := ns.IsNSorErr("/tmp/IDoNotExist")
 			Expect(err).To(HaveOccurred())
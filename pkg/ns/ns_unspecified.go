@@ -0,0 +1,60 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package ns
+
+// The functions below let code importing this package compile on other
+// platforms, e.g. Windows, where plugins attach containers through HNS
+// instead; all of them fail with ErrNotImplemented.
+
+func GetCurrentNS() (NetNS, error) {
+	return nil, ErrNotImplemented
+}
+
+func IsNSorErr(nspath string) error {
+	return ErrNotImplemented
+}
+
+func GetNS(nspath string) (NetNS, error) {
+	return nil, ErrNotImplemented
+}
+
+func NSPathFromPID(pid int) (string, error) {
+	return "", ErrNotImplemented
+}
+
+func GetNSFromPID(pid int) (NetNS, error) {
+	return nil, ErrNotImplemented
+}
+
+func NewNS() (NetNS, error) {
+	return nil, ErrNotImplemented
+}
+
+func NewNSInDir(nsRunDir string) (NetNS, error) {
+	return nil, ErrNotImplemented
+}
+
+func RemoveNS(nspath string) error {
+	return ErrNotImplemented
+}
+
+func WithNetNSPath(nspath string, toRun func(NetNS) error) error {
+	return ErrNotImplemented
+}
+
+func closeOnExec() {}
@@ -0,0 +1,56 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skel
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/version"
+)
+
+// about returns what the plugin reports on ABOUT: funcs.About, with the
+// versions of the spec it supports and the commands it implements
+func about(funcs PluginFuncs) *types.About {
+	a := types.About{}
+	if funcs.About != nil {
+		a = *funcs.About
+	}
+	if a.Name == "" {
+		a.Name = filepath.Base(os.Args[0])
+	}
+	a.CNIVersion = version.Current()
+	a.SupportedVersions = version.All.SupportedVersions()
+
+	a.Commands = nil
+	commands := []struct {
+		name string
+		f    func(_ *CmdArgs) error
+	}{
+		{"ADD", funcs.Add},
+		{"CHECK", funcs.Check},
+		{"DEL", funcs.Del},
+		{"GC", funcs.GC},
+		{"STATUS", funcs.Status},
+	}
+	for _, c := range commands {
+		if c.f != nil {
+			a.Commands = append(a.Commands, c.name)
+		}
+	}
+	a.Commands = append(a.Commands, "VERSION", "ABOUT")
+	return &a
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skel
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// cachedArgs is what ArgsCacheDir records of the ADD of an attachment
+type cachedArgs struct {
+	Args          string          `json:"args,omitempty"`
+	RuntimeConfig json.RawMessage `json:"runtimeConfig,omitempty"`
+}
+
+func argsCachePath(dir, containerID, ifName string) string {
+	// a colon cannot occur in an interface name
+	return filepath.Join(dir, containerID+":"+ifName+".args")
+}
+
+// recordArgs saves the CNI_ARGS and runtimeConfig of args, those of an
+// ADD, replacing the ones of an earlier ADD of the attachment
+func recordArgs(dir string, args *CmdArgs) error {
+	var conf struct {
+		RuntimeConfig json.RawMessage `json:"runtimeConfig"`
+	}
+	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
+		return fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+	data, err := json.Marshal(&cachedArgs{Args: args.Args, RuntimeConfig: conf.RuntimeConfig})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create args cache directory: %v", err)
+	}
+	// write and rename, so that a DEL never reads half a record
+	f, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), argsCachePath(dir, args.ContainerID, args.IfName))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to cache args: %v", err)
+	}
+	return nil
+}
+
+// replayArgs gives args, those of a DEL or CHECK, the CNI_ARGS and
+// runtimeConfig recorded for the ADD of the attachment in place of their
+// own. Whatever was not recorded is left as the runtime passed it.
+func replayArgs(dir string, args *CmdArgs) error {
+	data, err := ioutil.ReadFile(argsCachePath(dir, args.ContainerID, args.IfName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cached := &cachedArgs{}
+	if err := json.Unmarshal(data, cached); err != nil {
+		return fmt.Errorf("failed to parse cached args: %v", err)
+	}
+
+	if cached.Args != "" {
+		args.Args = cached.Args
+	}
+	if len(cached.RuntimeConfig) == 0 {
+		return nil
+	}
+	conf := map[string]json.RawMessage{}
+	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
+		return fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+	conf["runtimeConfig"] = cached.RuntimeConfig
+	args.StdinData, err = json.Marshal(conf)
+	return err
+}
+
+// forgetArgs removes the record of the attachment args was deleted from
+func forgetArgs(dir string, args *CmdArgs) error {
+	err := os.Remove(argsCachePath(dir, args.ContainerID, args.IfName))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove cached args: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skel
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// notifyInterrupts delivers the signals runtimes stop plugins with, e.g.
+// when an operation times out, until stop is called
+func notifyInterrupts() (sigs <-chan os.Signal, stop func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+	return c, func() { signal.Stop(c) }
+}
+
+// watchInterrupts handles the interruption of ADD until the returned
+// function is called: it runs cleanup, prints an ErrInterrupted error and
+// exits. Once the returned function has been called, ADD is done and an
+// interruption leaves it alone.
+func (t *dispatcher) watchInterrupts(cleanup func(_ *CmdArgs) error, args *CmdArgs) func() {
+	if t.interrupts == nil {
+		return func() {}
+	}
+	sigs, stopNotify := t.interrupts()
+
+	var mu sync.Mutex
+	finished := false
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigs:
+			// holding mu keeps ADD from finishing while cleaning up
+			mu.Lock()
+			defer mu.Unlock()
+			if !finished {
+				t.interrupted(sig, cleanup, args)
+			}
+		case <-done:
+		}
+	}()
+
+	return func() {
+		mu.Lock()
+		finished = true
+		mu.Unlock()
+		close(done)
+		stopNotify()
+	}
+}
+
+func (t *dispatcher) interrupted(sig os.Signal, cleanup func(_ *CmdArgs) error, args *CmdArgs) {
+	e := types.NewError(types.ErrInterrupted, fmt.Sprintf("interrupted by %v", sig), "")
+	if cleanup != nil {
+		if err := callSafely(cleanup, args); err != nil {
+			e.Details = fmt.Sprintf("cleanup failed: %v", err)
+		}
+	}
+
+	data, err := json.MarshalIndent(e, "", "    ")
+	if err == nil {
+		_, err = t.Stdout.Write(data)
+	}
+	if err != nil {
+		fmt.Fprintf(t.Stderr, "Error writing error JSON to stdout: %v\n", err)
+	}
+	t.exit(1)
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skel
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// SynthesizedContainerIDKey is the key skel adds to the result of ADD
+// when it made up the container ID, see PluginFuncs
+const SynthesizedContainerIDKey = "cni.dev/synthesized-container-id"
+
+// legacyIDPrefix starts every synthesized container ID
+const legacyIDPrefix = "legacy-"
+
+// synthesizeContainerID derives the container ID of a caller that did not
+// pass one from the network namespace and interface name, which stay the
+// same over the ADD, CHECK and DEL of an attachment
+func synthesizeContainerID(netns, ifName string) (string, *types.Error) {
+	if netns == "" {
+		return "", types.NewError(types.ErrInvalidEnvironmentVariables,
+			"CNI_CONTAINERID missing and cannot be derived without CNI_NETNS", "CNI_CONTAINERID")
+	}
+	sum := sha256.Sum256([]byte(filepath.Clean(netns) + "\x00" + ifName))
+	return legacyIDPrefix + hex.EncodeToString(sum[:16]), nil
+}
+
+// markSynthesized adds the synthesized container ID to the result of ADD
+// in out. Output that is not a JSON object is returned unchanged.
+func markSynthesized(out []byte, containerID string) []byte {
+	result := map[string]json.RawMessage{}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return out
+	}
+
+	result[SynthesizedContainerIDKey], _ = json.Marshal(containerID)
+	marked, err := json.MarshalIndent(result, "", "    ")
+	if err != nil {
+		return out
+	}
+	return marked
+}
+
+// callAddSynthesized runs f for ADD with a synthesized container ID,
+// capturing what it prints to mark the result before it is written to
+// t.Stdout
+func (t *dispatcher) callAddSynthesized(f func(_ *CmdArgs) error, args *CmdArgs) error {
+	out := &bytes.Buffer{}
+	restore, err := captureStdout(out)
+	if err != nil {
+		return fmt.Errorf("failed to capture the result: %v", err)
+	}
+	err = callSafely(f, args)
+	restore()
+	if err != nil {
+		return err
+	}
+
+	_, err = t.Stdout.Write(markSynthesized(out.Bytes(), args.ContainerID))
+	return err
+}
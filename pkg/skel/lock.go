@@ -0,0 +1,72 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skel
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// attachmentLock is an exclusive lock on the lock file of one
+// attachment. The lock file may be removed by a DEL while another
+// invocation waits for it, so a lock only counts once the file it was
+// taken on is still the one at the path.
+type attachmentLock struct {
+	path string
+	f    *os.File
+}
+
+func lockAttachment(dir, containerID, ifName string) (*attachmentLock, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %v", err)
+	}
+	// a colon cannot occur in an interface name
+	path := filepath.Join(dir, containerID+":"+ifName)
+
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open lock file: %v", err)
+		}
+		if err := lockFile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %q: %v", path, err)
+		}
+
+		held, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		current, err := os.Stat(path)
+		if err == nil && os.SameFile(held, current) {
+			return &attachmentLock{path: path, f: f}, nil
+		}
+		f.Close()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
+
+// release unlocks the attachment, first removing its lock file if the
+// attachment is gone
+func (l *attachmentLock) release(remove bool) {
+	if remove {
+		os.Remove(l.path)
+	}
+	l.f.Close()
+}
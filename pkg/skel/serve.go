@@ -0,0 +1,221 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// socketDirEnv overrides invoke.DefaultSocketDir for both the daemon and
+// the shim that forwards to it
+const socketDirEnv = "CNI_PLUGIN_SOCKET_DIR"
+
+func socketDir(getenv func(string) string) string {
+	if dir := getenv(socketDirEnv); dir != "" {
+		return dir
+	}
+	return invoke.DefaultSocketDir
+}
+
+// PluginServe runs the plugin as a daemon serving invocations sent with
+// invoke.ExecSocket on a unix socket at socketPath, until stop is closed.
+// Requests are handled one at a time: the callbacks see the CNI_*
+// environment of the request in the process environment, so that
+// delegation to IPAM plugins works unchanged, and anything they print
+// on os.Stdout is returned to the caller.
+func PluginServe(socketPath string, stop <-chan struct{}, cmdAdd, cmdDel func(_ *CmdArgs) error) error {
+	return PluginServeFuncs(socketPath, stop, PluginFuncs{Add: cmdAdd, Del: cmdDel})
+}
+
+// PluginServeFuncs is like PluginServe, for a plugin that also implements
+// CHECK.
+func PluginServeFuncs(socketPath string, stop <-chan struct{}, funcs PluginFuncs) error {
+	l, err := listenUnix(socketPath)
+	if err != nil {
+		return err
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+			close(stopped)
+			l.Close()
+		case <-stopped:
+		}
+	}()
+
+	s := &server{funcs: funcs, stderr: os.Stderr}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-stopped:
+				return nil
+			default:
+				close(stopped)
+				l.Close()
+				return err
+			}
+		}
+		go s.handle(conn)
+	}
+}
+
+// listenUnix creates the socket, replacing a stale one left behind by a
+// daemon that did not shut down cleanly
+func listenUnix(socketPath string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0700); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(socketPath); err == nil {
+		if conn, err := net.Dial("unix", socketPath); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another daemon is already serving on %q", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %q: %v", socketPath, err)
+		}
+	}
+
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+type server struct {
+	mu     sync.Mutex
+	funcs  PluginFuncs
+	stderr io.Writer
+}
+
+func (s *server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	req := &invoke.SocketRequest{}
+	if err := json.NewDecoder(conn).Decode(req); err != nil {
+		// a connection closed without a request is just a liveness probe
+		if err != io.EOF {
+			fmt.Fprintf(s.stderr, "failed to read request: %v\n", err)
+		}
+		return
+	}
+
+	if err := json.NewEncoder(conn).Encode(s.run(req)); err != nil {
+		fmt.Fprintf(s.stderr, "failed to write response: %v\n", err)
+	}
+}
+
+func (s *server) run(req *invoke.SocketRequest) *invoke.SocketResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	defer setCNIEnv(req.Env)()
+
+	stdout := &bytes.Buffer{}
+	restore, err := captureStdout(stdout)
+	if err != nil {
+		return &invoke.SocketResponse{
+			Error: types.NewError(types.ErrInternal, fmt.Sprintf("failed to capture stdout: %v", err), ""),
+		}
+	}
+
+	e := (&dispatcher{
+		Getenv: os.Getenv,
+		Stdin:  bytes.NewReader(req.Stdin),
+		Stdout: os.Stdout,
+		Stderr: s.stderr,
+	}).pluginMain(s.funcs)
+	restore()
+
+	if e != nil {
+		return &invoke.SocketResponse{Error: e}
+	}
+	return &invoke.SocketResponse{Stdout: stdout.Bytes()}
+}
+
+// setCNIEnv replaces all CNI_* variables of the process environment with
+// env and returns a function restoring the previous ones
+func setCNIEnv(env map[string]string) (restore func()) {
+	prev := invoke.SocketEnv(os.Environ())
+	for k := range prev {
+		os.Unsetenv(k)
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+
+	return func() {
+		for _, kv := range os.Environ() {
+			if strings.HasPrefix(kv, "CNI_") {
+				os.Unsetenv(strings.SplitN(kv, "=", 2)[0])
+			}
+		}
+		for k, v := range prev {
+			os.Setenv(k, v)
+		}
+	}
+}
+
+// forward sends the invocation to a daemon of this plugin serving on
+// socketPath, if there is one. It reports false when the plugin should
+// run in this process instead.
+func (t *dispatcher) forward(socketPath string, environ []string) (bool, *types.Error) {
+	if _, err := os.Stat(socketPath); err != nil {
+		return false, nil
+	}
+
+	stdinData, err := ioutil.ReadAll(t.Stdin)
+	if err != nil {
+		return true, types.NewError(errPluginFailed, fmt.Sprintf("error reading from stdin: %v", err), "")
+	}
+	// a stale socket leaves stdin to be read again by the plugin itself
+	t.Stdin = bytes.NewReader(stdinData)
+
+	out, err := invoke.ExecSocket(socketPath, stdinData, environ)
+	switch err := err.(type) {
+	case nil:
+		if _, err := t.Stdout.Write(out); err != nil {
+			return true, types.NewError(errPluginFailed, fmt.Sprintf("error writing result: %v", err), "")
+		}
+		return true, nil
+	case *types.Error:
+		return true, err
+	default:
+		if err == invoke.ErrNotServing {
+			return false, nil
+		}
+		return true, types.NewError(types.ErrInternal, err.Error(), "")
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/types"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("serving on a unix socket", func() {
+	var (
+		tmpDir     string
+		socketPath string
+		stop       chan struct{}
+		served     chan error
+		cmdAdd     func(*CmdArgs) error
+		cmdDel     func(*CmdArgs) error
+		environ    []string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "skel-serve")
+		Expect(err).NotTo(HaveOccurred())
+		socketPath = filepath.Join(tmpDir, "plugin.sock")
+
+		cmdAdd = func(args *CmdArgs) error {
+			fmt.Printf(`{"ifName":%q,"env":%q,"stdin":%q}`, args.IfName, os.Getenv("CNI_CONTAINERID"), args.StdinData)
+			return nil
+		}
+		cmdDel = func(args *CmdArgs) error {
+			return errors.New("del failed")
+		}
+		environ = []string{
+			"PATH=/usr/bin",
+			"CNI_COMMAND=ADD",
+			"CNI_CONTAINERID=first",
+			"CNI_CONTAINERID=some-container-id",
+			"CNI_NETNS=/proc/self/ns/net",
+			"CNI_IFNAME=eth0",
+			"CNI_PATH=/some/cni/path",
+		}
+
+		stop = make(chan struct{})
+		served = make(chan error, 1)
+		go func() {
+			served <- PluginServe(socketPath, stop, func(args *CmdArgs) error {
+				return cmdAdd(args)
+			}, func(args *CmdArgs) error {
+				return cmdDel(args)
+			})
+		}()
+		Eventually(func() error {
+			_, err := os.Stat(socketPath)
+			return err
+		}).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		close(stop)
+		Eventually(served).Should(Receive(BeNil()))
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("runs the callback with the request's environment and returns its stdout", func() {
+		out, err := invoke.ExecSocket(socketPath, []byte(`{"some":"config"}`), environ)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(out)).To(Equal(`{"ifName":"eth0","env":"some-container-id","stdin":"{\"some\":\"config\"}"}`))
+	})
+
+	It("restores the process environment after each request", func() {
+		os.Setenv("CNI_IFNAME", "before")
+		defer os.Unsetenv("CNI_IFNAME")
+
+		_, err := invoke.ExecSocket(socketPath, []byte(`{}`), environ)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Getenv("CNI_IFNAME")).To(Equal("before"))
+		Expect(os.Getenv("CNI_CONTAINERID")).To(BeEmpty())
+	})
+
+	It("returns callback errors as CNI errors", func() {
+		environ[1] = "CNI_COMMAND=DEL"
+		_, err := invoke.ExecSocket(socketPath, []byte(`{}`), environ)
+		Expect(err).To(Equal(&types.Error{Code: errPluginFailed, Msg: "del failed"}))
+	})
+
+	It("refuses to replace a socket another daemon is serving on", func() {
+		err := PluginServe(socketPath, make(chan struct{}), cmdAdd, cmdDel)
+		Expect(err).To(MatchError(ContainSubstring("another daemon is already serving")))
+	})
+
+	Describe("forwarding an invocation", func() {
+		var dispatch *dispatcher
+
+		BeforeEach(func() {
+			dispatch = &dispatcher{
+				Stdin:  strings.NewReader(`{"some":"config"}`),
+				Stdout: &bytes.Buffer{},
+				Stderr: &bytes.Buffer{},
+			}
+		})
+
+		It("writes the daemon's output", func() {
+			forwarded, e := dispatch.forward(socketPath, environ)
+			Expect(forwarded).To(BeTrue())
+			Expect(e).To(BeNil())
+			Expect(dispatch.Stdout.(*bytes.Buffer).String()).To(ContainSubstring(`"ifName":"eth0"`))
+		})
+
+		It("does not forward when there is no socket", func() {
+			forwarded, e := dispatch.forward(filepath.Join(tmpDir, "missing.sock"), environ)
+			Expect(forwarded).To(BeFalse())
+			Expect(e).To(BeNil())
+		})
+
+		It("leaves stdin readable when the socket is stale", func() {
+			stale := filepath.Join(tmpDir, "stale.sock")
+			l, err := net.Listen("unix", stale)
+			Expect(err).NotTo(HaveOccurred())
+			l.(*net.UnixListener).SetUnlinkOnClose(false)
+			Expect(l.Close()).To(Succeed())
+
+			forwarded, e := dispatch.forward(stale, environ)
+			Expect(forwarded).To(BeFalse())
+			Expect(e).To(BeNil())
+
+			data, err := ioutil.ReadAll(dispatch.Stdin)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(`{"some":"config"}`))
+		})
+	})
+})
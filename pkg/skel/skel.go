@@ -17,12 +17,20 @@
 package skel
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
+	"syscall"
 
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/ns"
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/version"
 )
 
 // CmdArgs captures all the arguments passed in to the plugin
@@ -36,84 +44,179 @@ type CmdArgs struct {
 	StdinData   []byte
 }
 
+// LoadArgs parses CNI_ARGS into the struct container points to; see
+// types.LoadArgsWithPolicy for the supported field types.
+func (args *CmdArgs) LoadArgs(container interface{}, policy types.UnknownArgsPolicy) error {
+	return types.LoadArgsWithPolicy(args.Args, container, policy)
+}
+
+// errPluginFailed is the code used for plain errors returned by a plugin
+const errPluginFailed uint = 100
+
+// PluginFuncs holds the callbacks of a plugin, one per command. A command
+// whose callback is nil is rejected as unsupported, except for CHECK, GC
+// and STATUS, which then succeed: a plugin without CHECK has nothing it
+// can verify, one without GC has nothing to collect, and one without
+// STATUS is always ready. Status should fail with an
+// ErrNotReady error while the plugin cannot add containers.
+//
+// If LockDir is set, ADD, CHECK and DEL of the same container ID and
+// interface name are serialized with a file lock in that directory, so
+// that a runtime retrying an operation cannot have it race the previous
+// attempt. The lock file is removed by a successful DEL.
+//
+// If ArgsCacheDir is set, the CNI_ARGS and runtimeConfig of every ADD are
+// recorded in that directory, and DEL and CHECK of the attachment get
+// them in place of their own, so that a plugin can undo what it did with
+// them, e.g. remove the port mappings it set up, even if the runtime does
+// not pass them again. The record is removed by a successful DEL. It may
+// be the same directory as LockDir.
+//
+// If SynthesizeContainerID is set, ADD, CHECK and DEL from legacy runtimes
+// that do not pass CNI_CONTAINERID get a container ID derived from
+// CNI_NETNS and CNI_IFNAME, which therefore must be the same for every
+// operation on the attachment. The result of ADD then carries that ID
+// under SynthesizedContainerIDKey. Without it, the callbacks are called
+// with an empty container ID.
+//
+// If ADD is interrupted by SIGINT or SIGTERM, e.g. by a runtime timing it
+// out, Cleanup is called with the arguments of the ADD, and the plugin
+// exits with an ErrInterrupted error, after which the runtime is expected
+// to run DEL. Cleanup runs on another goroutine while ADD is still in
+// progress, so it must not wait for ADD; it should remove whatever ADD may
+// have created so far. Without Cleanup, the plugin exits right away.
+//
+// On ABOUT, the plugin prints About as JSON, with the versions of the
+// spec it supports and the commands it implements filled in.
+type PluginFuncs struct {
+	Add     func(_ *CmdArgs) error
+	Check   func(_ *CmdArgs) error
+	Del     func(_ *CmdArgs) error
+	GC      func(_ *CmdArgs) error
+	Status  func(_ *CmdArgs) error
+	Cleanup func(_ *CmdArgs) error
+
+	LockDir               string
+	ArgsCacheDir          string
+	SynthesizeContainerID bool
+	About                 *types.About
+}
+
+type dispatcher struct {
+	Getenv func(string) string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// interrupts starts delivering the signals interrupting ADD; without
+	// it they are not handled. exit ends the interrupted process.
+	interrupts func() (<-chan os.Signal, func())
+	exit       func(int)
+}
+
 type reqForCmdEntry map[string]bool
 
-// PluginMain is the "main" for a plugin. It accepts
-// two callback functions for add and del commands.
-func PluginMain(cmdAdd, cmdDel func(_ *CmdArgs) error) {
+func (t *dispatcher) getCmdArgsFromEnv() (string, *CmdArgs, *types.Error) {
 	var cmd, contID, netns, ifName, args, path string
 
 	vars := []struct {
 		name      string
 		val       *string
 		reqForCmd reqForCmdEntry
+		validate  func(cmd, val string) *types.Error
 	}{
 		{
 			"CNI_COMMAND",
 			&cmd,
 			reqForCmdEntry{
-				"ADD": true,
-				"DEL": true,
+				"ADD":    true,
+				"DEL":    true,
+				"CHECK":  true,
+				"GC":     true,
+				"STATUS": true,
 			},
+			nil,
 		},
 		{
 			"CNI_CONTAINERID",
 			&contID,
 			reqForCmdEntry{
-				"ADD": false,
-				"DEL": false,
+				"ADD":   false,
+				"DEL":   false,
+				"CHECK": false,
 			},
+			validateContainerID,
 		},
 		{
 			"CNI_NETNS",
 			&netns,
 			reqForCmdEntry{
-				"ADD": true,
-				"DEL": false,
+				"ADD":   true,
+				"DEL":   false,
+				"CHECK": true,
 			},
+			validateNetns,
 		},
 		{
 			"CNI_IFNAME",
 			&ifName,
 			reqForCmdEntry{
-				"ADD": true,
-				"DEL": true,
+				"ADD":   true,
+				"DEL":   true,
+				"CHECK": true,
 			},
+			validateIfName,
 		},
 		{
 			"CNI_ARGS",
 			&args,
 			reqForCmdEntry{
-				"ADD": false,
-				"DEL": false,
+				"ADD":   false,
+				"DEL":   false,
+				"CHECK": false,
 			},
+			nil,
 		},
 		{
 			"CNI_PATH",
 			&path,
 			reqForCmdEntry{
-				"ADD": true,
-				"DEL": true,
+				"ADD":    true,
+				"DEL":    true,
+				"CHECK":  true,
+				"GC":     true,
+				"STATUS": true,
 			},
+			nil,
 		},
 	}
 
-	argsMissing := false
+	missing := []string{}
 	for _, v := range vars {
-		*v.val = os.Getenv(v.name)
+		*v.val = t.Getenv(v.name)
 		if v.reqForCmd[cmd] && *v.val == "" {
-			log.Printf("%v env variable missing", v.name)
-			argsMissing = true
+			fmt.Fprintf(t.Stderr, "%v env variable missing\n", v.name)
+			missing = append(missing, v.name)
 		}
 	}
 
-	if argsMissing {
-		dieMsg("required env variables missing")
+	if len(missing) > 0 {
+		return "", nil, types.NewError(types.ErrInvalidEnvironmentVariables, "required env variables missing", strings.Join(missing, ", "))
 	}
 
-	stdinData, err := ioutil.ReadAll(os.Stdin)
+	for _, v := range vars {
+		if v.validate == nil || *v.val == "" {
+			continue
+		}
+		if e := v.validate(cmd, *v.val); e != nil {
+			e.Details = v.name
+			return "", nil, e
+		}
+	}
+
+	stdinData, err := ioutil.ReadAll(t.Stdin)
 	if err != nil {
-		dieMsg("error reading from stdin: %v", err)
+		return "", nil, types.NewError(errPluginFailed, fmt.Sprintf("error reading from stdin: %v", err), "")
 	}
 
 	cmdArgs := &CmdArgs{
@@ -124,38 +227,212 @@ func PluginMain(cmdAdd, cmdDel func(_ *CmdArgs) error) {
 		Path:        path,
 		StdinData:   stdinData,
 	}
+	return cmd, cmdArgs, nil
+}
+
+func (t *dispatcher) pluginMain(funcs PluginFuncs) *types.Error {
+	if traceFile := t.Getenv("CNI_TRACE_FILE"); traceFile != "" {
+		return t.tracedDispatch(traceFile, funcs)
+	}
+	return t.dispatch(funcs)
+}
+
+func (t *dispatcher) dispatch(funcs PluginFuncs) (e *types.Error) {
+	cmd, cmdArgs, e := t.getCmdArgsFromEnv()
+	if e != nil {
+		return e
+	}
 
+	var f func(_ *CmdArgs) error
 	switch cmd {
 	case "ADD":
-		err = cmdAdd(cmdArgs)
+		f = funcs.Add
+
+	case "CHECK":
+		// libcni checks every plugin of a list
+		if funcs.Check == nil {
+			return nil
+		}
+		f = funcs.Check
 
 	case "DEL":
-		err = cmdDel(cmdArgs)
+		f = funcs.Del
+
+	case "GC":
+		if funcs.GC == nil {
+			return nil
+		}
+		f = funcs.GC
+
+	case "STATUS":
+		if funcs.Status == nil {
+			return nil
+		}
+		f = funcs.Status
+
+	case "VERSION":
+		if err := version.All.Encode(t.Stdout); err != nil {
+			return types.NewError(errPluginFailed, err.Error(), "")
+		}
+		return nil
+
+	case "ABOUT":
+		if err := json.NewEncoder(t.Stdout).Encode(about(funcs)); err != nil {
+			return types.NewError(errPluginFailed, err.Error(), "")
+		}
+		return nil
 
 	default:
-		dieMsg("unknown CNI_COMMAND: %v", cmd)
+		return types.NewError(types.ErrInvalidEnvironmentVariables, fmt.Sprintf("unknown CNI_COMMAND: %v", cmd), "")
 	}
 
-	if err != nil {
+	if f == nil {
+		return types.NewError(types.ErrInvalidEnvironmentVariables, fmt.Sprintf("unsupported CNI_COMMAND: %v", cmd), "")
+	}
+
+	// GC and STATUS are about the whole network, not one attachment
+	perAttachment := cmd != "GC" && cmd != "STATUS"
+
+	synthesized := false
+	if funcs.SynthesizeContainerID && perAttachment && cmdArgs.ContainerID == "" {
+		if cmdArgs.ContainerID, e = synthesizeContainerID(cmdArgs.Netns, cmdArgs.IfName); e != nil {
+			return e
+		}
+		fmt.Fprintf(t.Stderr, "CNI_CONTAINERID missing, using %s\n", cmdArgs.ContainerID)
+		synthesized = true
+	}
+
+	if funcs.LockDir != "" && perAttachment && cmdArgs.ContainerID != "" {
+		lock, err := lockAttachment(funcs.LockDir, cmdArgs.ContainerID, cmdArgs.IfName)
+		if err != nil {
+			return types.NewError(errPluginFailed, err.Error(), "")
+		}
+		defer func() { lock.release(cmd == "DEL" && e == nil) }()
+	}
+
+	cacheArgs := funcs.ArgsCacheDir != "" && perAttachment && cmdArgs.ContainerID != ""
+	if cacheArgs {
+		var err error
+		switch cmd {
+		case "ADD":
+			// before the ADD, so that the DEL after a failed one gets
+			// them as well
+			err = recordArgs(funcs.ArgsCacheDir, cmdArgs)
+		case "CHECK", "DEL":
+			err = replayArgs(funcs.ArgsCacheDir, cmdArgs)
+		}
+		if err != nil {
+			return types.NewError(errPluginFailed, err.Error(), "")
+		}
+	}
+
+	call := callSafely
+	if synthesized && cmd == "ADD" {
+		call = t.callAddSynthesized
+	}
+	if cmd == "ADD" {
+		defer t.watchInterrupts(funcs.Cleanup, cmdArgs)()
+	}
+	if err := call(f, cmdArgs); err != nil {
 		if e, ok := err.(*types.Error); ok {
 			// don't wrap Error in Error
-			dieErr(e)
+			return e
 		}
-		dieMsg(err.Error())
+		return types.NewError(errPluginFailed, err.Error(), "")
 	}
+	if cacheArgs && cmd == "DEL" {
+		if err := forgetArgs(funcs.ArgsCacheDir, cmdArgs); err != nil {
+			return types.NewError(errPluginFailed, err.Error(), "")
+		}
+	}
+	return nil
+}
+
+// callSafely runs the callback, turning a panic into an internal error
+// that carries the stack trace in its details, so that the runtime still
+// gets an error JSON it can parse. For a panic inside a network namespace
+// that is the stack of the goroutine that panicked there.
+func callSafely(f func(_ *CmdArgs) error, args *CmdArgs) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			if p, ok := r.(*ns.PanicInfo); ok {
+				stack = p.Stack
+			}
+			err = types.NewError(types.ErrInternal, fmt.Sprintf("plugin panicked: %v", r), string(stack))
+		}
+	}()
+
+	return f(args)
 }
 
-func dieMsg(f string, args ...interface{}) {
-	e := &types.Error{
-		Code: 100,
-		Msg:  fmt.Sprintf(f, args...),
+// PluginMainWithError is the core "main" for a plugin. It accepts
+// callback functions for add and del commands and returns the error
+// to report, if any, instead of exiting the process.
+// If a daemon of the plugin is serving on its socket (see PluginServe),
+// the invocation is forwarded to it rather than handled in this process.
+func PluginMainWithError(cmdAdd, cmdDel func(_ *CmdArgs) error) *types.Error {
+	return PluginMainFuncsWithError(PluginFuncs{Add: cmdAdd, Del: cmdDel})
+}
+
+// PluginMainFuncsWithError is like PluginMainWithError, for a plugin
+// that also implements CHECK.
+func PluginMainFuncsWithError(funcs PluginFuncs) *types.Error {
+	t := &dispatcher{
+		Getenv:     os.Getenv,
+		Stdin:      os.Stdin,
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+		interrupts: notifyInterrupts,
+		exit:       os.Exit,
+	}
+
+	socketPath := invoke.SocketPath(socketDir(os.Getenv), os.Args[0])
+	if forwarded, e := t.forward(socketPath, os.Environ()); forwarded {
+		return e
 	}
-	dieErr(e)
+	return t.pluginMain(funcs)
 }
 
-func dieErr(e *types.Error) {
-	if err := e.Print(); err != nil {
-		log.Print("Error writing error JSON to stdout: ", err)
+// PluginMain is the "main" for a plugin. It accepts
+// two callback functions for add and del commands.
+// On failure it prints the error JSON to stdout and exits with status 1.
+//
+// Run as "<plugin> serve [socket]", the plugin instead becomes a daemon
+// serving on socket, by default <plugin>.sock in /run/cni/plugins or
+// $CNI_PLUGIN_SOCKET_DIR, until it receives SIGINT or SIGTERM.
+func PluginMain(cmdAdd, cmdDel func(_ *CmdArgs) error) {
+	PluginMainFuncs(PluginFuncs{Add: cmdAdd, Del: cmdDel})
+}
+
+// PluginMainFuncs is like PluginMain, for a plugin that also implements
+// CHECK.
+func PluginMainFuncs(funcs PluginFuncs) {
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		socketPath := invoke.SocketPath(socketDir(os.Getenv), os.Args[0])
+		if len(os.Args) > 2 {
+			socketPath = os.Args[2]
+		}
+
+		stop := make(chan struct{})
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			<-sigs
+			close(stop)
+		}()
+
+		if err := PluginServeFuncs(socketPath, stop, funcs); err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving on %q: %v\n", socketPath, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if e := PluginMainFuncsWithError(funcs); e != nil {
+		if err := e.Print(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing error JSON to stdout: %v\n", err)
+		}
+		os.Exit(1)
 	}
-	os.Exit(1)
 }
@@ -15,70 +15,587 @@
 package skel
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/types"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
-var _ = Describe("Skel", func() {
+type fakeCmd struct {
+	args *CmdArgs
+	err  error
+}
+
+func (c *fakeCmd) Func(args *CmdArgs) error {
+	c.args = args
+	return c.err
+}
+
+var _ = Describe("dispatching to the correct callback", func() {
 	var (
-		fNoop = func(_ *CmdArgs) error { return nil }
-		// fErr    = func(_ *CmdArgs) error { return errors.New("dummy") }
-		envVars = []struct {
-			name string
-			val  string
-		}{
-			{"CNI_CONTAINERID", "dummy"},
-			{"CNI_NETNS", "dummy"},
-			{"CNI_IFNAME", "dummy"},
-			{"CNI_ARGS", "dummy"},
-			{"CNI_PATH", "dummy"},
-		}
+		environment    map[string]string
+		stdin          *strings.Reader
+		stdout, stderr *bytes.Buffer
+		cmdAdd, cmdDel *fakeCmd
+		cmdCheck       *fakeCmd
+		funcs          PluginFuncs
+		dispatch       *dispatcher
+		netnsPath      string
 	)
 
-	It("Must be possible to set the env vars", func() {
-		for _, v := range envVars {
-			err := os.Setenv(v.name, v.val)
-			Expect(err).NotTo(HaveOccurred())
+	BeforeEach(func() {
+		netnsFile, err := ioutil.TempFile("", "netns")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(netnsFile.Close()).To(Succeed())
+		netnsPath = netnsFile.Name()
+
+		environment = map[string]string{
+			"CNI_COMMAND":     "ADD",
+			"CNI_CONTAINERID": "some-container-id",
+			"CNI_NETNS":       netnsPath,
+			"CNI_IFNAME":      "eth0",
+			"CNI_ARGS":        "some;extra;args",
+			"CNI_PATH":        "/some/cni/path",
+		}
+		stdin = strings.NewReader(`{ "some": "config" }`)
+		stdout = &bytes.Buffer{}
+		stderr = &bytes.Buffer{}
+		dispatch = &dispatcher{
+			Getenv: func(key string) string { return environment[key] },
+			Stdin:  stdin,
+			Stdout: stdout,
+			Stderr: stderr,
 		}
+		cmdAdd = &fakeCmd{}
+		cmdCheck = &fakeCmd{}
+		cmdDel = &fakeCmd{}
+		funcs = PluginFuncs{Add: cmdAdd.Func, Check: cmdCheck.Func, Del: cmdDel.Func}
 	})
 
-	Context("When dummy environment variables are passed", func() {
+	AfterEach(func() {
+		Expect(os.Remove(netnsPath)).To(Succeed())
+	})
 
-		It("should not fail with ADD and noop callback", func() {
-			err := os.Setenv("CNI_COMMAND", "ADD")
-			Expect(err).NotTo(HaveOccurred())
-			PluginMain(fNoop, nil)
+	It("calls cmdAdd with the parsed arguments on ADD", func() {
+		Expect(dispatch.pluginMain(funcs)).To(BeNil())
+
+		Expect(cmdDel.args).To(BeNil())
+		Expect(cmdAdd.args).To(Equal(&CmdArgs{
+			ContainerID: "some-container-id",
+			Netns:       netnsPath,
+			IfName:      "eth0",
+			Args:        "some;extra;args",
+			Path:        "/some/cni/path",
+			StdinData:   []byte(`{ "some": "config" }`),
+		}))
+	})
+
+	It("calls cmdDel on DEL, without requiring CNI_NETNS", func() {
+		environment["CNI_COMMAND"] = "DEL"
+		delete(environment, "CNI_NETNS")
+
+		Expect(dispatch.pluginMain(funcs)).To(BeNil())
+		Expect(cmdAdd.args).To(BeNil())
+		Expect(cmdDel.args.Netns).To(BeEmpty())
+	})
+
+	It("calls cmdCheck on CHECK", func() {
+		environment["CNI_COMMAND"] = "CHECK"
+
+		Expect(dispatch.pluginMain(funcs)).To(BeNil())
+		Expect(cmdAdd.args).To(BeNil())
+		Expect(cmdDel.args).To(BeNil())
+		Expect(cmdCheck.args.Netns).To(Equal(netnsPath))
+	})
+
+	It("requires CNI_NETNS on CHECK", func() {
+		environment["CNI_COMMAND"] = "CHECK"
+		delete(environment, "CNI_NETNS")
+
+		err := dispatch.pluginMain(funcs)
+		Expect(err).To(Equal(&types.Error{
+			Code:    types.ErrInvalidEnvironmentVariables,
+			Msg:     "required env variables missing",
+			Details: "CNI_NETNS",
+		}))
+		Expect(cmdCheck.args).To(BeNil())
+	})
+
+	It("succeeds on CHECK when the plugin has nothing to check", func() {
+		environment["CNI_COMMAND"] = "CHECK"
+		funcs.Check = nil
+
+		Expect(dispatch.pluginMain(funcs)).To(BeNil())
+		Expect(cmdAdd.args).To(BeNil())
+		Expect(cmdDel.args).To(BeNil())
+	})
+
+	It("succeeds on GC when the plugin has nothing to collect", func() {
+		environment = map[string]string{"CNI_COMMAND": "GC", "CNI_PATH": "/some/cni/path"}
+
+		Expect(dispatch.pluginMain(funcs)).To(BeNil())
+		Expect(cmdAdd.args).To(BeNil())
+		Expect(cmdDel.args).To(BeNil())
+	})
+
+	It("calls Status on STATUS, with the network configuration alone", func() {
+		environment = map[string]string{"CNI_COMMAND": "STATUS", "CNI_PATH": "/some/cni/path"}
+		cmdStatus := &fakeCmd{}
+		funcs.Status = cmdStatus.Func
+
+		Expect(dispatch.pluginMain(funcs)).To(BeNil())
+		Expect(cmdStatus.args).To(Equal(&CmdArgs{
+			Path:      "/some/cni/path",
+			StdinData: []byte(`{ "some": "config" }`),
+		}))
+	})
+
+	It("reports a plugin without Status as ready", func() {
+		environment = map[string]string{"CNI_COMMAND": "STATUS", "CNI_PATH": "/some/cni/path"}
+
+		Expect(dispatch.pluginMain(funcs)).To(BeNil())
+		Expect(cmdAdd.args).To(BeNil())
+	})
+
+	It("reports missing required variables", func() {
+		delete(environment, "CNI_IFNAME")
+
+		err := dispatch.pluginMain(funcs)
+		Expect(err).To(Equal(&types.Error{
+			Code:    types.ErrInvalidEnvironmentVariables,
+			Msg:     "required env variables missing",
+			Details: "CNI_IFNAME",
+		}))
+		Expect(stderr.String()).To(ContainSubstring("CNI_IFNAME env variable missing"))
+		Expect(cmdAdd.args).To(BeNil())
+	})
+
+	It("reports an unknown command", func() {
+		environment["CNI_COMMAND"] = "NOPE"
+
+		err := dispatch.pluginMain(funcs)
+		Expect(err.Code).To(Equal(types.ErrInvalidEnvironmentVariables))
+		Expect(err.Msg).To(Equal("unknown CNI_COMMAND: NOPE"))
+	})
+
+	It("prints the supported versions on VERSION", func() {
+		environment = map[string]string{"CNI_COMMAND": "VERSION"}
+
+		Expect(dispatch.pluginMain(funcs)).To(BeNil())
+		Expect(stdout.Bytes()).To(MatchJSON(`{
+			"cniVersion": "0.3.0",
+			"supportedVersions": ["0.1.0", "0.2.0", "0.3.0"]
+		}`))
+	})
+
+	It("describes the plugin on ABOUT", func() {
+		environment = map[string]string{"CNI_COMMAND": "ABOUT"}
+		funcs.About = &types.About{
+			Name:         "fake",
+			Capabilities: []string{"portMappings"},
+			Config:       map[string]types.ConfigField{"bridge": {Type: "string", Required: true}},
+		}
+
+		Expect(dispatch.pluginMain(funcs)).To(BeNil())
+		Expect(stdout.Bytes()).To(MatchJSON(`{
+			"cniVersion": "0.3.0",
+			"name": "fake",
+			"supportedVersions": ["0.1.0", "0.2.0", "0.3.0"],
+			"commands": ["ADD", "CHECK", "DEL", "VERSION", "ABOUT"],
+			"capabilities": ["portMappings"],
+			"config": {"bridge": {"type": "string", "required": true}}
+		}`))
+	})
+
+	It("passes a *types.Error from the callback through unchanged", func() {
+		cmdAdd.err = types.NewTryAgainLaterError("busy")
+
+		err := dispatch.pluginMain(funcs)
+		Expect(err).To(Equal(&types.Error{Code: types.ErrTryAgainLater, Msg: "busy"}))
+	})
+
+	It("wraps other errors from the callback", func() {
+		cmdAdd.err = errors.New("banana")
+
+		err := dispatch.pluginMain(funcs)
+		Expect(err).To(Equal(&types.Error{Code: 100, Msg: "banana"}))
+	})
+
+	It("turns a panic in the callback into an internal error", func() {
+		environment["CNI_COMMAND"] = "DEL"
+		panicking := func(_ *CmdArgs) error { panic("oh no") }
+
+		funcs.Del = panicking
+
+		err := dispatch.pluginMain(funcs)
+		Expect(err.Code).To(Equal(types.ErrInternal))
+		Expect(err.Msg).To(Equal("plugin panicked: oh no"))
+		Expect(err.Details).To(ContainSubstring("skel.callSafely"))
+	})
+
+	Context("when ADD is interrupted", func() {
+		var (
+			sigs      chan os.Signal
+			exited    chan int
+			release   chan struct{}
+			stopped   bool
+			cleanedUp *CmdArgs
+		)
+
+		BeforeEach(func() {
+			sigs = make(chan os.Signal, 1)
+			exited = make(chan int, 1)
+			release = make(chan struct{})
+			stopped = false
+			cleanedUp = nil
+			dispatch.interrupts = func() (<-chan os.Signal, func()) {
+				return sigs, func() { stopped = true }
+			}
+			dispatch.exit = func(code int) { exited <- code }
+
+			// ADD blocks until the test releases it
+			funcs.Add = func(args *CmdArgs) error {
+				<-release
+				return nil
+			}
+			funcs.Cleanup = func(args *CmdArgs) error {
+				cleanedUp = args
+				return nil
+			}
 		})
 
-		// TODO: figure out howto mock printing and os.Exit()
-		// It("should fail with ADD and error callback", func() {
-		// 	err := os.Setenv("CNI_COMMAND", "ADD")
-		// 	Expect(err).NotTo(HaveOccurred())
-		// 	PluginMain(fErr, nil)
-		// })
+		It("cleans up and exits with an interrupted error", func() {
+			done := make(chan *types.Error)
+			go func() { done <- dispatch.pluginMain(funcs) }()
 
-		It("should not fail with DEL and noop callback", func() {
-			err := os.Setenv("CNI_COMMAND", "DEL")
-			Expect(err).NotTo(HaveOccurred())
-			PluginMain(nil, fNoop)
+			sigs <- syscall.SIGTERM
+			Eventually(exited).Should(Receive(Equal(1)))
+			Expect(cleanedUp.ContainerID).To(Equal("some-container-id"))
+
+			var e types.Error
+			Expect(json.Unmarshal(stdout.Bytes(), &e)).To(Succeed())
+			Expect(e.Code).To(Equal(types.ErrInterrupted))
+			Expect(e.Msg).To(Equal("interrupted by terminated"))
+
+			close(release)
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(stopped).To(BeTrue())
+		})
+
+		It("reports a failed cleanup", func() {
+			funcs.Cleanup = func(args *CmdArgs) error { return errors.New("veth is busy") }
+			done := make(chan *types.Error)
+			go func() { done <- dispatch.pluginMain(funcs) }()
+
+			sigs <- syscall.SIGINT
+			Eventually(exited).Should(Receive())
+
+			var e types.Error
+			Expect(json.Unmarshal(stdout.Bytes(), &e)).To(Succeed())
+			Expect(e.Code).To(Equal(types.ErrInterrupted))
+			Expect(e.Details).To(Equal("cleanup failed: veth is busy"))
+
+			close(release)
+			Eventually(done).Should(Receive())
 		})
 
-		// TODO: figure out howto mock printing and os.Exit()
-		// It("should fail with DEL and error callback", func() {
-		// 	err := os.Setenv("CNI_COMMAND", "DEL")
-		// 	Expect(err).NotTo(HaveOccurred())
-		// 	PluginMain(fErr, nil)
-		// })
+		It("stops watching once ADD is done", func() {
+			close(release)
+			Expect(dispatch.pluginMain(funcs)).To(BeNil())
+			Expect(stopped).To(BeTrue())
+
+			sigs <- syscall.SIGTERM
+			Consistently(exited).ShouldNot(Receive())
+			Expect(cleanedUp).To(BeNil())
+		})
+
+		It("does not watch other commands", func() {
+			environment["CNI_COMMAND"] = "DEL"
+			Expect(dispatch.pluginMain(funcs)).To(BeNil())
+			Expect(stopped).To(BeFalse())
+		})
+	})
+
+	It("reports the stack of a panic in a network namespace", func() {
+		environment["CNI_COMMAND"] = "DEL"
+		targetNS, err := ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		defer targetNS.Close()
 
-		It("should not fail with DEL and no NETNS and noop callback", func() {
-			err := os.Setenv("CNI_COMMAND", "DEL")
+		funcs.Del = func(_ *CmdArgs) error {
+			return targetNS.Do(func(ns.NetNS) error { panic("oh no") })
+		}
+
+		cniErr := dispatch.pluginMain(funcs)
+		Expect(cniErr.Code).To(Equal(types.ErrInternal))
+		Expect(cniErr.Msg).To(Equal("plugin panicked: panic in network namespace: oh no"))
+		Expect(cniErr.Details).To(ContainSubstring("ns.(*netNS).Do.func"))
+		Expect(cniErr.Details).NotTo(ContainSubstring("skel.callSafely"))
+	})
+
+	Context("with a LockDir", func() {
+		var lockDir string
+
+		BeforeEach(func() {
+			var err error
+			lockDir, err = ioutil.TempDir("", "skel-lock")
 			Expect(err).NotTo(HaveOccurred())
-			err = os.Unsetenv("CNI_NETNS")
+			funcs.LockDir = lockDir
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(lockDir)).To(Succeed())
+		})
+
+		// run dispatches cmd with its own stdin, as a separate
+		// invocation would
+		run := func(cmd string) <-chan *types.Error {
+			env := map[string]string{}
+			for k, v := range environment {
+				env[k] = v
+			}
+			env["CNI_COMMAND"] = cmd
+			d := &dispatcher{
+				Getenv: func(key string) string { return env[key] },
+				Stdin:  strings.NewReader(`{}`),
+				Stdout: &bytes.Buffer{},
+				Stderr: &bytes.Buffer{},
+			}
+
+			done := make(chan *types.Error, 1)
+			go func() { done <- d.pluginMain(funcs) }()
+			return done
+		}
+
+		It("serializes operations on the same attachment", func() {
+			entered := make(chan struct{})
+			proceed := make(chan struct{})
+			funcs.Add = func(*CmdArgs) error {
+				close(entered)
+				<-proceed
+				return nil
+			}
+			deleted := make(chan struct{})
+			funcs.Del = func(*CmdArgs) error {
+				close(deleted)
+				return nil
+			}
+
+			add := run("ADD")
+			Eventually(entered).Should(BeClosed())
+			del := run("DEL")
+			Consistently(deleted, "200ms").ShouldNot(BeClosed())
+
+			close(proceed)
+			Eventually(add).Should(Receive(BeNil()))
+			Eventually(del).Should(Receive(BeNil()))
+			Expect(deleted).To(BeClosed())
+		})
+
+		It("does not serialize operations on different attachments", func() {
+			proceed := make(chan struct{})
+			defer close(proceed)
+			funcs.Add = func(*CmdArgs) error {
+				<-proceed
+				return nil
+			}
+
+			run("ADD")
+			environment["CNI_IFNAME"] = "eth1"
+			Eventually(run("DEL")).Should(Receive(BeNil()))
+		})
+
+		It("removes the lock file after a successful DEL only", func() {
+			lockFile := filepath.Join(lockDir, "some-container-id:eth0")
+
+			Eventually(run("ADD")).Should(Receive(BeNil()))
+			Expect(lockFile).To(BeAnExistingFile())
+
+			cmdDel.err = errors.New("still attached")
+			Eventually(run("DEL")).Should(Receive(Not(BeNil())))
+			Expect(lockFile).To(BeAnExistingFile())
+
+			cmdDel.err = nil
+			Eventually(run("DEL")).Should(Receive(BeNil()))
+			Expect(lockFile).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("with an ArgsCacheDir", func() {
+		var cacheDir string
+
+		BeforeEach(func() {
+			var err error
+			cacheDir, err = ioutil.TempDir("", "skel-args")
 			Expect(err).NotTo(HaveOccurred())
-			PluginMain(nil, fNoop)
+			funcs.ArgsCacheDir = cacheDir
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(cacheDir)).To(Succeed())
+		})
+
+		// run dispatches cmd with the given CNI_ARGS and stdin, as a
+		// separate invocation would
+		run := func(cmd, cniArgs, conf string) *types.Error {
+			environment["CNI_COMMAND"] = cmd
+			environment["CNI_ARGS"] = cniArgs
+			d := &dispatcher{
+				Getenv: func(key string) string { return environment[key] },
+				Stdin:  strings.NewReader(conf),
+				Stdout: &bytes.Buffer{},
+				Stderr: &bytes.Buffer{},
+			}
+			return d.pluginMain(funcs)
+		}
+
+		It("replays the args of the ADD on CHECK and DEL", func() {
+			Expect(run("ADD", "K8S_POD_NAME=web", `{ "some": "config", "runtimeConfig": { "portMappings": [ { "hostPort": 8080 } ] } }`)).To(BeNil())
+
+			Expect(run("CHECK", "", `{ "some": "config" }`)).To(BeNil())
+			Expect(cmdCheck.args.Args).To(Equal("K8S_POD_NAME=web"))
+			Expect(cmdCheck.args.StdinData).To(MatchJSON(`{ "some": "config", "runtimeConfig": { "portMappings": [ { "hostPort": 8080 } ] } }`))
+
+			Expect(run("DEL", "IgnoreUnknown=1", `{ "some": "other-config", "runtimeConfig": {} }`)).To(BeNil())
+			Expect(cmdDel.args.Args).To(Equal("K8S_POD_NAME=web"))
+			Expect(cmdDel.args.StdinData).To(MatchJSON(`{ "some": "other-config", "runtimeConfig": { "portMappings": [ { "hostPort": 8080 } ] } }`))
+		})
+
+		It("keeps the args of the runtime for attachments it has no record of", func() {
+			Expect(run("DEL", "IgnoreUnknown=1", `{ "some": "config" }`)).To(BeNil())
+			Expect(cmdDel.args.Args).To(Equal("IgnoreUnknown=1"))
+			Expect(cmdDel.args.StdinData).To(MatchJSON(`{ "some": "config" }`))
 		})
 
+		It("keeps the record of a failed ADD until a DEL succeeds", func() {
+			record := filepath.Join(cacheDir, "some-container-id:eth0.args")
+
+			cmdAdd.err = errors.New("no addresses left")
+			Expect(run("ADD", "K8S_POD_NAME=web", `{}`)).NotTo(BeNil())
+			Expect(record).To(BeAnExistingFile())
+
+			cmdDel.err = errors.New("still attached")
+			Expect(run("DEL", "", `{}`)).NotTo(BeNil())
+			Expect(cmdDel.args.Args).To(Equal("K8S_POD_NAME=web"))
+			Expect(record).To(BeAnExistingFile())
+
+			cmdDel.err = nil
+			Expect(run("DEL", "", `{}`)).To(BeNil())
+			Expect(record).NotTo(BeAnExistingFile())
+
+			Expect(run("DEL", "", `{}`)).To(BeNil())
+			Expect(cmdDel.args.Args).To(Equal(""))
+		})
+	})
+
+	Context("with SynthesizeContainerID", func() {
+		BeforeEach(func() {
+			funcs.SynthesizeContainerID = true
+			delete(environment, "CNI_CONTAINERID")
+		})
+
+		It("derives the same container ID for every operation on the attachment", func() {
+			funcs.Add = func(args *CmdArgs) error {
+				cmdAdd.args = args
+				_, err := os.Stdout.WriteString(`{ "ip4": { "ip": "10.1.2.3/24" } }`)
+				return err
+			}
+			Expect(dispatch.pluginMain(funcs)).To(BeNil())
+			id := cmdAdd.args.ContainerID
+			Expect(id).To(HavePrefix("legacy-"))
+			Expect(types.ValidateContainerID(id)).To(BeNil())
+			Expect(stdout.String()).To(MatchJSON(`{
+				"ip4": { "ip": "10.1.2.3/24" },
+				"cni.dev/synthesized-container-id": "` + id + `"
+			}`))
+			Expect(stderr.String()).To(ContainSubstring(id))
+
+			environment["CNI_COMMAND"] = "DEL"
+			Expect(dispatch.pluginMain(funcs)).To(BeNil())
+			Expect(cmdDel.args.ContainerID).To(Equal(id))
+
+			environment["CNI_COMMAND"] = "ADD"
+			environment["CNI_IFNAME"] = "eth1"
+			Expect(dispatch.pluginMain(funcs)).To(BeNil())
+			Expect(cmdAdd.args.ContainerID).NotTo(Equal(id))
+		})
+
+		It("keeps a container ID passed by the runtime", func() {
+			environment["CNI_CONTAINERID"] = "some-container-id"
+
+			Expect(dispatch.pluginMain(funcs)).To(BeNil())
+			Expect(cmdAdd.args.ContainerID).To(Equal("some-container-id"))
+			Expect(stderr.String()).To(BeEmpty())
+		})
+
+		It("fails if there is no network namespace to derive it from", func() {
+			environment["CNI_COMMAND"] = "DEL"
+			delete(environment, "CNI_NETNS")
+
+			err := dispatch.pluginMain(funcs)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Code).To(Equal(types.ErrInvalidEnvironmentVariables))
+			Expect(err.Msg).To(Equal("CNI_CONTAINERID missing and cannot be derived without CNI_NETNS"))
+			Expect(cmdDel.args).To(BeNil())
+		})
+	})
+
+	Context("when a variable is malformed", func() {
+		expectInvalid := func(variable, msg string) {
+			err := dispatch.pluginMain(funcs)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Code).To(Equal(types.ErrInvalidEnvironmentVariables))
+			Expect(err.Msg).To(Equal(msg))
+			Expect(err.Details).To(Equal(variable))
+			Expect(cmdAdd.args).To(BeNil())
+		}
+
+		It("rejects a container ID with invalid characters", func() {
+			environment["CNI_CONTAINERID"] = "some/container"
+			expectInvalid("CNI_CONTAINERID", `invalid container ID "some/container": must start with an alphanumeric character and contain only alphanumerics, '_', '.' and '-'`)
+		})
+
+		It("rejects an interface name that is too long", func() {
+			environment["CNI_IFNAME"] = "averyverylongifname"
+			expectInvalid("CNI_IFNAME", `invalid interface name "averyverylongifname": longer than 15 characters`)
+		})
+
+		It("rejects an interface name with whitespace", func() {
+			environment["CNI_IFNAME"] = "eth 0"
+			expectInvalid("CNI_IFNAME", `invalid interface name "eth 0": cannot contain '/', ':' or whitespace`)
+		})
+
+		It("rejects a network namespace that does not exist on ADD", func() {
+			environment["CNI_NETNS"] = "/does/not/exist"
+			expectInvalid("CNI_NETNS", `invalid network namespace "/does/not/exist": stat /does/not/exist: no such file or directory`)
+		})
+
+		It("accepts a network namespace that is already gone on DEL", func() {
+			environment["CNI_COMMAND"] = "DEL"
+			environment["CNI_NETNS"] = "/does/not/exist"
+
+			Expect(dispatch.pluginMain(funcs)).To(BeNil())
+			Expect(cmdDel.args.Netns).To(Equal("/does/not/exist"))
+		})
+	})
+})
+
+var _ = Describe("CmdArgs.LoadArgs", func() {
+	It("parses CNI_ARGS into the given struct", func() {
+		cmdArgs := &CmdArgs{Args: "K8S_POD_NAME=web-0;Other=thing"}
+		k8sArgs := types.K8sArgs{}
+
+		Expect(cmdArgs.LoadArgs(&k8sArgs, types.IgnoreUnknownArgs)).To(Succeed())
+		Expect(string(k8sArgs.K8S_POD_NAME)).To(Equal("web-0"))
 	})
 })
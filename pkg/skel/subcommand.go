@@ -0,0 +1,129 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skel
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Subcommand is an auxiliary entrypoint of a plugin, e.g. a daemon it
+// relies on or a maintenance tool, run by an operator as
+// "<plugin> <name> [flags] [args]" rather than by a runtime.
+type Subcommand interface {
+	// Usage describes the subcommand in one line
+	Usage() string
+	// SetFlags defines the flags of the subcommand
+	SetFlags(flags *flag.FlagSet)
+	// Run runs the subcommand once its flags are parsed. An error is
+	// printed to stderr and makes the plugin exit with status 1.
+	Run(ctx *SubcommandContext) error
+}
+
+// SubcommandContext is what a Subcommand runs with
+type SubcommandContext struct {
+	// Args are the arguments left after the flags
+	Args   []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// PluginMainWithSubcommands is like PluginMainFuncs, for a plugin that
+// also ships subcommands. Run with the name of one of cmds as its first
+// argument, the plugin parses the flags that follow, runs the subcommand
+// and exits. "help" lists the subcommands. Without arguments, which is how
+// runtimes run plugins, the plugin handles the CNI command of its
+// environment as usual. The serve subcommand of PluginMain is reserved.
+func PluginMainWithSubcommands(funcs PluginFuncs, cmds map[string]Subcommand) {
+	if _, ok := cmds["serve"]; ok {
+		panic("programmer error: the serve subcommand is reserved")
+	}
+
+	t := &dispatcher{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+	if status, ok := t.runSubcommand(filepath.Base(os.Args[0]), os.Args[1:], cmds); ok {
+		os.Exit(status)
+	}
+	PluginMainFuncs(funcs)
+}
+
+// runSubcommand runs the subcommand args names, if any, and returns its
+// exit status. It returns false for the invocations handled as CNI
+// commands.
+func (t *dispatcher) runSubcommand(prog string, args []string, cmds map[string]Subcommand) (int, bool) {
+	if len(args) == 0 || args[0] == "serve" {
+		return 0, false
+	}
+
+	name := args[0]
+	cmd, ok := cmds[name]
+	if !ok {
+		if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+			printSubcommands(t.Stdout, prog, cmds)
+			return 0, true
+		}
+		fmt.Fprintf(t.Stderr, "%s: unknown subcommand %q\n", prog, name)
+		printSubcommands(t.Stderr, prog, cmds)
+		return 2, true
+	}
+
+	flags := flag.NewFlagSet(prog+" "+name, flag.ContinueOnError)
+	flags.SetOutput(t.Stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(t.Stderr, "Usage of %s %s: %s\n", prog, name, cmd.Usage())
+		flags.PrintDefaults()
+	}
+	cmd.SetFlags(flags)
+	if err := flags.Parse(args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return 0, true
+		}
+		return 2, true
+	}
+
+	ctx := &SubcommandContext{
+		Args:   flags.Args(),
+		Stdin:  t.Stdin,
+		Stdout: t.Stdout,
+		Stderr: t.Stderr,
+	}
+	if err := cmd.Run(ctx); err != nil {
+		fmt.Fprintf(t.Stderr, "%s %s: %v\n", prog, name, err)
+		return 1, true
+	}
+	return 0, true
+}
+
+func printSubcommands(w io.Writer, prog string, cmds map[string]Subcommand) {
+	var names []string
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "Usage: %s [subcommand [flags] [args]]\n\nRun without arguments, %s handles the CNI command of its environment.\n\nSubcommands:\n", prog, prog)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\t%s\n", name, cmds[name].Usage())
+	}
+	fmt.Fprintf(w, "  serve\tserve CNI commands on a socket\n")
+}
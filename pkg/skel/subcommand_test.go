@@ -0,0 +1,117 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skel
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// echoCmd prints its arguments and stdin, upper-cased with -upper
+type echoCmd struct {
+	upper bool
+	err   error
+}
+
+func (c *echoCmd) Usage() string { return "print the arguments and stdin" }
+
+func (c *echoCmd) SetFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&c.upper, "upper", false, "upper-case the output")
+}
+
+func (c *echoCmd) Run(ctx *SubcommandContext) error {
+	if c.err != nil {
+		return c.err
+	}
+	in, err := ioutil.ReadAll(ctx.Stdin)
+	if err != nil {
+		return err
+	}
+	out := strings.Join(ctx.Args, " ") + " " + string(in)
+	if c.upper {
+		out = strings.ToUpper(out)
+	}
+	_, err = ctx.Stdout.Write([]byte(out))
+	return err
+}
+
+var _ = Describe("Dispatching subcommands", func() {
+	var (
+		dispatch       *dispatcher
+		stdout, stderr *bytes.Buffer
+		cmd            *echoCmd
+		cmds           map[string]Subcommand
+	)
+
+	BeforeEach(func() {
+		stdout = &bytes.Buffer{}
+		stderr = &bytes.Buffer{}
+		dispatch = &dispatcher{
+			Stdin:  strings.NewReader("in"),
+			Stdout: stdout,
+			Stderr: stderr,
+		}
+		cmd = &echoCmd{}
+		cmds = map[string]Subcommand{"echo": cmd}
+	})
+
+	It("runs the named subcommand with its flags and arguments", func() {
+		status, ok := dispatch.runSubcommand("plugin", []string{"echo", "-upper", "a", "b"}, cmds)
+		Expect(ok).To(BeTrue())
+		Expect(status).To(Equal(0))
+		Expect(stdout.String()).To(Equal("A B IN"))
+	})
+
+	It("leaves invocations without arguments and serve to the CNI dispatch", func() {
+		_, ok := dispatch.runSubcommand("plugin", nil, cmds)
+		Expect(ok).To(BeFalse())
+		_, ok = dispatch.runSubcommand("plugin", []string{"serve", "/run/x.sock"}, cmds)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("exits with status 1 and prints the error of a failed subcommand", func() {
+		cmd.err = errors.New("boom")
+		status, ok := dispatch.runSubcommand("plugin", []string{"echo"}, cmds)
+		Expect(ok).To(BeTrue())
+		Expect(status).To(Equal(1))
+		Expect(stderr.String()).To(Equal("plugin echo: boom\n"))
+	})
+
+	It("exits with status 2 on unknown subcommands and flags", func() {
+		status, _ := dispatch.runSubcommand("plugin", []string{"nope"}, cmds)
+		Expect(status).To(Equal(2))
+		Expect(stderr.String()).To(HavePrefix(`plugin: unknown subcommand "nope"`))
+		Expect(stderr.String()).To(ContainSubstring("echo\tprint the arguments and stdin"))
+
+		stderr.Reset()
+		status, _ = dispatch.runSubcommand("plugin", []string{"echo", "-loud"}, cmds)
+		Expect(status).To(Equal(2))
+		Expect(stderr.String()).To(ContainSubstring("Usage of plugin echo: print the arguments and stdin"))
+	})
+
+	It("lists the subcommands with help", func() {
+		status, ok := dispatch.runSubcommand("plugin", []string{"help"}, cmds)
+		Expect(ok).To(BeTrue())
+		Expect(status).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("echo\tprint the arguments and stdin"))
+		Expect(stdout.String()).To(ContainSubstring("serve\t"))
+	})
+})
@@ -0,0 +1,136 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// traceVars are the environment variables recorded for each invocation
+var traceVars = []string{
+	"CNI_COMMAND",
+	"CNI_CONTAINERID",
+	"CNI_NETNS",
+	"CNI_IFNAME",
+	"CNI_ARGS",
+	"CNI_PATH",
+}
+
+// traceRecord is the line appended to CNI_TRACE_FILE for each invocation
+type traceRecord struct {
+	Time     time.Time         `json:"time"`
+	Command  string            `json:"command"`
+	Env      map[string]string `json:"env"`
+	Config   string            `json:"config,omitempty"`
+	Output   string            `json:"output,omitempty"`
+	Error    *types.Error      `json:"error,omitempty"`
+	Duration string            `json:"duration"`
+}
+
+// tracedDispatch runs dispatch while recording its input and output, then
+// appends the record as a JSON line to traceFile. Failing to write the
+// trace is reported on stderr but does not fail the plugin.
+func (t *dispatcher) tracedDispatch(traceFile string, funcs PluginFuncs) *types.Error {
+	record := &traceRecord{
+		Time:    time.Now(),
+		Command: t.Getenv("CNI_COMMAND"),
+		Env:     map[string]string{},
+	}
+	for _, name := range traceVars {
+		if v := t.Getenv(name); v != "" {
+			record.Env[name] = v
+		}
+	}
+
+	stdin := &bytes.Buffer{}
+	stdout := &bytes.Buffer{}
+	traced := *t
+	traced.Stdin = io.TeeReader(t.Stdin, stdin)
+	traced.Stdout = io.MultiWriter(t.Stdout, stdout)
+
+	// plugins print their result straight to os.Stdout
+	restore := func() {}
+	if t.Stdout == io.Writer(os.Stdout) {
+		var err error
+		if restore, err = captureStdout(io.MultiWriter(os.Stdout, stdout)); err != nil {
+			fmt.Fprintf(t.Stderr, "CNI_TRACE_FILE: failed to capture stdout: %v\n", err)
+			restore = func() {}
+		} else {
+			traced.Stdout = os.Stdout
+		}
+	}
+
+	e := traced.dispatch(funcs)
+	restore()
+
+	record.Duration = time.Since(record.Time).String()
+	record.Config = stdin.String()
+	record.Output = stdout.String()
+	record.Error = e
+	if err := appendTrace(traceFile, record); err != nil {
+		fmt.Fprintf(t.Stderr, "CNI_TRACE_FILE: %v\n", err)
+	}
+
+	return e
+}
+
+// captureStdout replaces os.Stdout with a pipe whose data is copied to out,
+// until restore is called.
+func captureStdout(out io.Writer) (restore func(), err error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	orig := os.Stdout
+	os.Stdout = w
+	done := make(chan struct{})
+	go func() {
+		io.Copy(out, r)
+		r.Close()
+		close(done)
+	}()
+
+	return func() {
+		os.Stdout = orig
+		w.Close()
+		<-done
+	}, nil
+}
+
+func appendTrace(traceFile string, record *traceRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode trace: %v", err)
+	}
+
+	f, err := os.OpenFile(traceFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open trace file: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write trace file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("tracing invocations", func() {
+	var (
+		traceDir    string
+		traceFile   string
+		environment map[string]string
+		stdout      *bytes.Buffer
+		dispatch    *dispatcher
+	)
+
+	BeforeEach(func() {
+		var err error
+		traceDir, err = ioutil.TempDir("", "skel-trace")
+		Expect(err).NotTo(HaveOccurred())
+		traceFile = filepath.Join(traceDir, "trace.log")
+
+		environment = map[string]string{
+			"CNI_COMMAND":     "ADD",
+			"CNI_CONTAINERID": "some-container-id",
+			"CNI_NETNS":       traceDir,
+			"CNI_IFNAME":      "eth0",
+			"CNI_PATH":        "/some/cni/path",
+			"CNI_TRACE_FILE":  traceFile,
+		}
+		stdout = &bytes.Buffer{}
+		dispatch = &dispatcher{
+			Getenv: func(key string) string { return environment[key] },
+			Stdin:  strings.NewReader(`{ "some": "config" }`),
+			Stdout: stdout,
+			Stderr: &bytes.Buffer{},
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(traceDir)).To(Succeed())
+	})
+
+	readTrace := func() []traceRecord {
+		data, err := ioutil.ReadFile(traceFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		records := []traceRecord{}
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var r traceRecord
+			Expect(json.Unmarshal([]byte(line), &r)).To(Succeed())
+			records = append(records, r)
+		}
+		return records
+	}
+
+	It("appends one record per invocation", func() {
+		failing := func(_ *CmdArgs) error { return errors.New("banana") }
+		Expect(dispatch.pluginMain(PluginFuncs{Add: failing})).NotTo(BeNil())
+
+		environment["CNI_COMMAND"] = "VERSION"
+		dispatch.Stdin = strings.NewReader("")
+		Expect(dispatch.pluginMain(PluginFuncs{})).To(BeNil())
+
+		records := readTrace()
+		Expect(records).To(HaveLen(2))
+
+		Expect(records[0].Command).To(Equal("ADD"))
+		Expect(records[0].Env).To(HaveKeyWithValue("CNI_IFNAME", "eth0"))
+		Expect(records[0].Env).NotTo(HaveKey("CNI_ARGS"))
+		Expect(records[0].Config).To(Equal(`{ "some": "config" }`))
+		Expect(records[0].Error).To(Equal(&types.Error{Code: 100, Msg: "banana"}))
+		Expect(records[0].Duration).NotTo(BeEmpty())
+
+		Expect(records[1].Command).To(Equal("VERSION"))
+		Expect(records[1].Error).To(BeNil())
+		Expect(records[1].Output).To(Equal(stdout.String()))
+		Expect(records[1].Output).To(ContainSubstring("supportedVersions"))
+	})
+
+	It("does not trace unless CNI_TRACE_FILE is set", func() {
+		delete(environment, "CNI_TRACE_FILE")
+		noop := func(_ *CmdArgs) error { return nil }
+		Expect(dispatch.pluginMain(PluginFuncs{Add: noop})).To(BeNil())
+
+		_, err := os.Stat(traceFile)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+})
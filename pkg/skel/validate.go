@@ -0,0 +1,42 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skel
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+func validateContainerID(_, id string) *types.Error {
+	return types.ValidateContainerID(id)
+}
+
+// validateNetns checks that the namespace exists when it is about to be
+// configured; on DEL it may legitimately be gone already.
+func validateNetns(cmd, netns string) *types.Error {
+	if cmd != "ADD" {
+		return nil
+	}
+	if _, err := os.Stat(netns); err != nil {
+		return types.NewError(types.ErrInvalidEnvironmentVariables, fmt.Sprintf("invalid network namespace %q: %v", netns, err), "")
+	}
+	return nil
+}
+
+func validateIfName(_, ifName string) *types.Error {
+	return types.ValidateInterfaceName(ifName)
+}
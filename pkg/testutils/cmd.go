@@ -20,6 +20,7 @@ import (
 	"os"
 
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
 )
 
 func envCleanup() {
@@ -30,6 +31,32 @@ func envCleanup() {
 }
 
 func CmdAddWithResult(cniNetns, cniIfname string, f func() error) (*types.Result, error) {
+	out, err := cmdAdd(cniNetns, cniIfname, f)
+	if err != nil {
+		return nil, err
+	}
+
+	result := types.Result{}
+	err = json.Unmarshal(out, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// CmdAddWithCurrentResult is CmdAddWithResult for plugins configured
+// with cniVersion 0.3.0; it decodes a result of any version.
+func CmdAddWithCurrentResult(cniNetns, cniIfname string, f func() error) (*current.Result, error) {
+	out, err := cmdAdd(cniNetns, cniIfname, f)
+	if err != nil {
+		return nil, err
+	}
+	return current.NewResult(out)
+}
+
+// cmdAdd runs f as ADD and returns what it printed
+func cmdAdd(cniNetns, cniIfname string, f func() error) ([]byte, error) {
 	os.Setenv("CNI_COMMAND", "ADD")
 	os.Setenv("CNI_PATH", os.Getenv("PATH"))
 	os.Setenv("CNI_NETNS", cniNetns)
@@ -44,26 +71,14 @@ func CmdAddWithResult(cniNetns, cniIfname string, f func() error) (*types.Result
 	}
 
 	os.Stdout = w
+	defer func() { os.Stdout = oldStdout }()
 	err = f()
 	w.Close()
 	if err != nil {
 		return nil, err
 	}
 
-	// parse the result
-	out, err := ioutil.ReadAll(r)
-	os.Stdout = oldStdout
-	if err != nil {
-		return nil, err
-	}
-
-	result := types.Result{}
-	err = json.Unmarshal(out, &result)
-	if err != nil {
-		return nil, err
-	}
-
-	return &result, nil
+	return ioutil.ReadAll(r)
 }
 
 func CmdDelWithResult(cniNetns, cniIfname string, f func() error) error {
@@ -75,3 +90,13 @@ func CmdDelWithResult(cniNetns, cniIfname string, f func() error) error {
 
 	return f()
 }
+
+func CmdCheckWithResult(cniNetns, cniIfname string, f func() error) error {
+	os.Setenv("CNI_COMMAND", "CHECK")
+	os.Setenv("CNI_PATH", os.Getenv("PATH"))
+	os.Setenv("CNI_NETNS", cniNetns)
+	os.Setenv("CNI_IFNAME", cniIfname)
+	defer envCleanup()
+
+	return f()
+}
@@ -0,0 +1,180 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Env is a pair of throwaway network namespaces, one standing in for the
+// host and one for the container, in which real plugin binaries are run
+// end-to-end, so that tests never touch the network of the machine
+// running them.
+type Env struct {
+	HostNS      ns.NetNS
+	ContainerNS ns.NetNS
+
+	// PluginDirs are searched for the plugin binaries, and passed to
+	// them as CNI_PATH so that they find their IPAM plugins
+	PluginDirs []string
+}
+
+// NewEnv creates the namespaces of a new Env
+func NewEnv(pluginDirs ...string) (*Env, error) {
+	hostNS, err := ns.NewNS()
+	if err != nil {
+		return nil, err
+	}
+
+	containerNS, err := ns.NewNS()
+	if err != nil {
+		hostNS.Close()
+		return nil, err
+	}
+
+	return &Env{
+		HostNS:      hostNS,
+		ContainerNS: containerNS,
+		PluginDirs:  pluginDirs,
+	}, nil
+}
+
+// Close removes the namespaces of the Env and everything in them
+func (e *Env) Close() error {
+	errHost := e.HostNS.Close()
+	errContainer := e.ContainerNS.Close()
+	if errHost != nil {
+		return errHost
+	}
+	return errContainer
+}
+
+// Run runs command of the plugin of conf from within the host
+// namespace, against the interface ifName of the container namespace,
+// and returns what the plugin printed
+func (e *Env) Run(command, containerID, ifName string, conf []byte) ([]byte, error) {
+	netconf := types.NetConf{}
+	if err := json.Unmarshal(conf, &netconf); err != nil {
+		return nil, fmt.Errorf("failed to parse network config: %v", err)
+	}
+
+	pluginPath, err := invoke.FindInPath(netconf.Type, e.PluginDirs)
+	if err != nil {
+		return nil, err
+	}
+
+	args := &invoke.Args{
+		Command:     command,
+		ContainerID: containerID,
+		NetNS:       e.ContainerNS.Path(),
+		IfName:      ifName,
+		Path:        strings.Join(e.PluginDirs, string(os.PathListSeparator)),
+	}
+
+	var out []byte
+	// the plugin process starts in the namespace of the thread forking it
+	err = e.HostNS.Do(func(ns.NetNS) error {
+		var err error
+		out, err = (&invoke.RawExec{Stderr: os.Stderr}).ExecPlugin(pluginPath, conf, args.AsEnv())
+		return err
+	})
+	return out, err
+}
+
+// Add runs ADD and decodes the result
+func (e *Env) Add(containerID, ifName string, conf []byte) (*types.Result, error) {
+	out, err := e.Run("ADD", containerID, ifName, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &types.Result{}
+	if err := json.Unmarshal(out, result); err != nil {
+		return nil, fmt.Errorf("failed to parse result %q: %v", out, err)
+	}
+	return result, nil
+}
+
+// Check runs CHECK, passing result, that of the ADD, as prevResult
+func (e *Env) Check(containerID, ifName string, conf []byte, result *types.Result) error {
+	netconf := map[string]interface{}{}
+	if err := json.Unmarshal(conf, &netconf); err != nil {
+		return fmt.Errorf("failed to parse network config: %v", err)
+	}
+	netconf["prevResult"] = result
+	conf, err := json.Marshal(netconf)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.Run("CHECK", containerID, ifName, conf)
+	return err
+}
+
+// Del runs DEL
+func (e *Env) Del(containerID, ifName string, conf []byte) error {
+	_, err := e.Run("DEL", containerID, ifName, conf)
+	return err
+}
+
+// LinkState is what a test usually asserts about an interface
+type LinkState struct {
+	Link   netlink.Link
+	Addrs  []netlink.Addr
+	Routes []netlink.Route
+}
+
+// Link returns the link called name in netns with its addresses and
+// routes, or nil if there is no such link
+func Link(netns ns.NetNS, name string) (*LinkState, error) {
+	var state *LinkState
+	err := netns.Do(func(ns.NetNS) error {
+		links, err := netlink.LinkList()
+		if err != nil {
+			return err
+		}
+		var link netlink.Link
+		for _, l := range links {
+			if l.Attrs().Name == name {
+				link = l
+			}
+		}
+		if link == nil {
+			return nil
+		}
+
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			return err
+		}
+		routes, err := netlink.RouteList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			return err
+		}
+
+		state = &LinkState{Link: link, Addrs: addrs, Routes: routes}
+		return nil
+	})
+	return state, err
+}
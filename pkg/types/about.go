@@ -0,0 +1,47 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+// About is what a plugin reports of itself on ABOUT, so that tools such
+// as configuration editors and validators can introspect the plugins
+// installed on a host
+type About struct {
+	CNIVersion string `json:"cniVersion"`
+	// Name is the name of the plugin, the name of its binary if it
+	// does not report one
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// SupportedVersions are the versions of the spec the plugin
+	// supports, as on VERSION
+	SupportedVersions []string `json:"supportedVersions"`
+	// Commands are the CNI commands the plugin implements
+	Commands []string `json:"commands,omitempty"`
+	// Capabilities are the capabilities the plugin can be given as
+	// runtimeConfig
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Config describes the fields of the network configuration the
+	// plugin reads, by name
+	Config map[string]ConfigField `json:"config,omitempty"`
+}
+
+// ConfigField hints at what a field of the network configuration holds
+type ConfigField struct {
+	// Type is the JSON type of the field: "string", "number",
+	// "boolean", "object" or "array"
+	Type        string      `json:"type"`
+	Required    bool        `json:"required,omitempty"`
+	Default     interface{} `json:"default,omitempty"`
+	Description string      `json:"description,omitempty"`
+}
@@ -18,6 +18,7 @@ import (
 	"encoding"
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 )
 
@@ -63,8 +64,37 @@ func GetKeyField(keyString string, v reflect.Value) reflect.Value {
 	return v.Elem().FieldByName(keyString)
 }
 
+// K8sArgs holds the CNI_ARGS passed by Kubernetes. Plugins can embed it
+// in their own args struct.
+type K8sArgs struct {
+	CommonArgs
+	K8S_POD_NAME               UnmarshallableString
+	K8S_POD_NAMESPACE          UnmarshallableString
+	K8S_POD_INFRA_CONTAINER_ID UnmarshallableString
+}
+
+// UnknownArgsPolicy selects what LoadArgsWithPolicy does with keys that
+// have no matching field
+type UnknownArgsPolicy int
+
+const (
+	// RejectUnknownArgs fails on unknown keys, unless the runtime passed
+	// IgnoreUnknown=1 and the container embeds CommonArgs
+	RejectUnknownArgs UnknownArgsPolicy = iota
+	// IgnoreUnknownArgs always skips unknown keys
+	IgnoreUnknownArgs
+)
+
 // LoadArgs parses args from a string in the form "K=V;K2=V2;..."
 func LoadArgs(args string, container interface{}) error {
+	return LoadArgsWithPolicy(args, container, RejectUnknownArgs)
+}
+
+// LoadArgsWithPolicy parses args from a string in the form "K=V;K2=V2;..."
+// into the fields of the same name of the struct container points to.
+// Fields must implement encoding.TextUnmarshaler or be of a string, bool,
+// integer or unsigned integer kind.
+func LoadArgsWithPolicy(args string, container interface{}, policy UnknownArgsPolicy) error {
 	if args == "" {
 		return nil
 	}
@@ -74,7 +104,7 @@ func LoadArgs(args string, container interface{}) error {
 	pairs := strings.Split(args, ";")
 	unknownArgs := []string{}
 	for _, pair := range pairs {
-		kv := strings.Split(pair, "=")
+		kv := strings.SplitN(pair, "=", 2)
 		if len(kv) != 2 {
 			return fmt.Errorf("ARGS: invalid pair %q", pair)
 		}
@@ -86,16 +116,50 @@ func LoadArgs(args string, container interface{}) error {
 			continue
 		}
 
-		u := keyField.Addr().Interface().(encoding.TextUnmarshaler)
-		err := u.UnmarshalText([]byte(valueString))
-		if err != nil {
+		if err := setArgField(keyField, valueString); err != nil {
 			return fmt.Errorf("ARGS: error parsing value of pair %q: %v)", pair, err)
 		}
 	}
 
-	isIgnoreUnknown := GetKeyField("IgnoreUnknown", containerValue).Bool()
-	if len(unknownArgs) > 0 && !isIgnoreUnknown {
+	if len(unknownArgs) > 0 && policy != IgnoreUnknownArgs && !isIgnoreUnknown(containerValue) {
 		return fmt.Errorf("ARGS: unknown args %q", unknownArgs)
 	}
 	return nil
 }
+
+func isIgnoreUnknown(containerValue reflect.Value) bool {
+	field := GetKeyField("IgnoreUnknown", containerValue)
+	return field.IsValid() && field.Bool()
+}
+
+func setArgField(field reflect.Value, value string) error {
+	if u, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		var b UnmarshallableBool
+		if err := b.UnmarshalText([]byte(value)); err != nil {
+			return err
+		}
+		field.SetBool(bool(b))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(u)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
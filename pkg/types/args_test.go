@@ -15,6 +15,7 @@
 package types_test
 
 import (
+	"net"
 	"reflect"
 
 	. "github.com/containernetworking/cni/pkg/types"
@@ -119,3 +120,43 @@ var _ = Describe("LoadArgs", func() {
 		})
 	})
 })
+
+var _ = Describe("LoadArgsWithPolicy", func() {
+	type podArgs struct {
+		K8sArgs
+		IP    net.IP
+		MTU   int
+		Debug bool
+	}
+
+	It("converts values to the type of each field", func() {
+		args := podArgs{}
+		err := LoadArgs("K8S_POD_NAME=web-0;K8S_POD_NAMESPACE=prod;IP=10.1.2.3;MTU=1400;Debug=true", &args)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(string(args.K8S_POD_NAME)).To(Equal("web-0"))
+		Expect(string(args.K8S_POD_NAMESPACE)).To(Equal("prod"))
+		Expect(args.IP.String()).To(Equal("10.1.2.3"))
+		Expect(args.MTU).To(Equal(1400))
+		Expect(args.Debug).To(BeTrue())
+	})
+
+	It("reports values that do not convert", func() {
+		args := podArgs{}
+		err := LoadArgs("MTU=big", &args)
+		Expect(err).To(MatchError(ContainSubstring(`error parsing value of pair "MTU=big"`)))
+	})
+
+	It("ignores unknown keys when asked to", func() {
+		args := podArgs{}
+		err := LoadArgsWithPolicy("K8S_POD_NAME=web-0;Unk=nown", &args, IgnoreUnknownArgs)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(args.K8S_POD_NAME)).To(Equal("web-0"))
+	})
+
+	It("rejects unknown keys in containers without CommonArgs", func() {
+		args := struct{ MTU int }{}
+		err := LoadArgsWithPolicy("Unk=nown", &args, RejectUnknownArgs)
+		Expect(err).To(MatchError(`ARGS: unknown args ["Unk=nown"]`))
+	})
+})
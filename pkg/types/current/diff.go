@@ -0,0 +1,163 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package current
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Mismatch is a value that differs between an expected and an actual
+// result. Expected or Actual is empty when the value is only in the
+// other result.
+type Mismatch struct {
+	// Field is where the value is, e.g. "ips[1].gateway" or "routes"
+	Field    string
+	Expected string
+	Actual   string
+}
+
+func (m Mismatch) String() string {
+	switch {
+	case m.Actual == "":
+		return fmt.Sprintf("%s: missing %s", m.Field, m.Expected)
+	case m.Expected == "":
+		return fmt.Sprintf("%s: unexpected %s", m.Field, m.Actual)
+	}
+	return fmt.Sprintf("%s: expected %s, got %s", m.Field, m.Expected, m.Actual)
+}
+
+// Mismatches is the difference between two results, as found by Diff. It
+// is an error so that CHECK can return it as it is.
+type Mismatches []Mismatch
+
+func (m Mismatches) Error() string {
+	msgs := make([]string, len(m))
+	for i, mismatch := range m {
+		msgs[i] = mismatch.String()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Diff compares the result a plugin expects, typically its prevResult
+// in CHECK, with the actual one, and returns every difference, or nil if
+// there is none. Interfaces and IP configurations are compared by their
+// index, since IP configurations refer to interfaces by it, while routes
+// and DNS settings are compared regardless of their order.
+func Diff(expected, actual *Result) Mismatches {
+	var diff Mismatches
+	mismatch := func(field, exp, act string) {
+		if exp != act {
+			diff = append(diff, Mismatch{Field: field, Expected: exp, Actual: act})
+		}
+	}
+
+	mismatch("cniVersion", expected.CNIVersion, actual.CNIVersion)
+
+	for i := 0; i < len(expected.Interfaces) || i < len(actual.Interfaces); i++ {
+		field := fmt.Sprintf("interfaces[%d]", i)
+		switch {
+		case i >= len(actual.Interfaces):
+			mismatch(field, formatInterface(expected.Interfaces[i]), "")
+		case i >= len(expected.Interfaces):
+			mismatch(field, "", formatInterface(actual.Interfaces[i]))
+		case expected.Interfaces[i] == nil || actual.Interfaces[i] == nil:
+			mismatch(field, formatInterface(expected.Interfaces[i]), formatInterface(actual.Interfaces[i]))
+		default:
+			exp, act := expected.Interfaces[i], actual.Interfaces[i]
+			mismatch(field+".name", exp.Name, act.Name)
+			mismatch(field+".mac", strings.ToLower(exp.Mac), strings.ToLower(act.Mac))
+			mismatch(field+".sandbox", exp.Sandbox, act.Sandbox)
+			mismatch(field+".mtu", formatInt(exp.Mtu), formatInt(act.Mtu))
+			mismatch(field+".pciID", exp.PciID, act.PciID)
+			mismatch(field+".socketPath", exp.SocketPath, act.SocketPath)
+			mismatch(field+".deviceInfo", formatDeviceInfo(exp.DeviceInfo), formatDeviceInfo(act.DeviceInfo))
+		}
+	}
+
+	for i := 0; i < len(expected.IPs) || i < len(actual.IPs); i++ {
+		field := fmt.Sprintf("ips[%d]", i)
+		switch {
+		case i >= len(actual.IPs):
+			mismatch(field, expected.formatIPConfig(expected.IPs[i]), "")
+		case i >= len(expected.IPs):
+			mismatch(field, "", actual.formatIPConfig(actual.IPs[i]))
+		case expected.IPs[i] == nil || actual.IPs[i] == nil:
+			mismatch(field, expected.formatIPConfig(expected.IPs[i]), actual.formatIPConfig(actual.IPs[i]))
+		default:
+			exp, act := expected.IPs[i], actual.IPs[i]
+			mismatch(field+".version", exp.Version, act.Version)
+			mismatch(field+".interface", formatIndex(exp.Interface), formatIndex(act.Interface))
+			mismatch(field+".address", exp.Address.String(), act.Address.String())
+			mismatch(field+".gateway", formatIP(exp.Gateway), formatIP(act.Gateway))
+		}
+	}
+
+	var expRoutes, actRoutes []string
+	for _, route := range expected.Routes {
+		expRoutes = append(expRoutes, formatRoute(route))
+	}
+	for _, route := range actual.Routes {
+		actRoutes = append(actRoutes, formatRoute(route))
+	}
+	diff = append(diff, diffSets("routes", expRoutes, actRoutes)...)
+
+	mismatch("dns.domain", expected.DNS.Domain, actual.DNS.Domain)
+	diff = append(diff, diffSets("dns.nameservers", expected.DNS.Nameservers, actual.DNS.Nameservers)...)
+	diff = append(diff, diffSets("dns.search", expected.DNS.Search, actual.DNS.Search)...)
+	diff = append(diff, diffSets("dns.options", expected.DNS.Options, actual.DNS.Options)...)
+
+	return diff
+}
+
+// diffSets reports the values of expected missing from actual, then
+// those of actual not in expected, counting duplicates
+func diffSets(field string, expected, actual []string) Mismatches {
+	unmatched := make(map[string]int)
+	for _, v := range actual {
+		unmatched[v]++
+	}
+
+	var diff Mismatches
+	for _, v := range expected {
+		if unmatched[v] > 0 {
+			unmatched[v]--
+			continue
+		}
+		diff = append(diff, Mismatch{Field: field, Expected: v})
+	}
+	for _, v := range actual {
+		if unmatched[v] > 0 {
+			unmatched[v]--
+			diff = append(diff, Mismatch{Field: field, Actual: v})
+		}
+	}
+	return diff
+}
+
+func formatIP(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func formatIndex(idx *int) string {
+	if idx == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d", *idx)
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package current_test
+
+import (
+	"net"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Result diffs", func() {
+	var expected, actual *current.Result
+
+	newResult := func() *current.Result {
+		zero := 0
+		return &current.Result{
+			CNIVersion: "0.3.0",
+			Interfaces: []*current.Interface{
+				{Name: "eth0", Mac: "00:11:22:33:44:55", Sandbox: "/proc/3553/ns/net"},
+			},
+			IPs: []*current.IPConfig{
+				{
+					Version:   "4",
+					Interface: &zero,
+					Address:   mustParseCIDR("10.1.2.3/24"),
+					Gateway:   net.ParseIP("10.1.2.1"),
+				},
+			},
+			Routes: []*types.Route{
+				{Dst: mustParseCIDR("0.0.0.0/0"), GW: net.ParseIP("10.1.2.1")},
+				{Dst: mustParseCIDR("192.168.0.0/16"), GW: net.ParseIP("10.1.2.254"), Priority: 100},
+			},
+			DNS: types.DNS{Nameservers: []string{"10.1.2.1", "10.1.2.2"}},
+		}
+	}
+
+	BeforeEach(func() {
+		expected = newResult()
+		actual = newResult()
+	})
+
+	It("renders a result one entry per line", func() {
+		Expect(expected.Pretty()).To(Equal(`cniVersion: 0.3.0
+interfaces:
+  [0] eth0 mac 00:11:22:33:44:55 sandbox /proc/3553/ns/net
+ips:
+  [0] 10.1.2.3/24 gateway 10.1.2.1 on eth0
+routes:
+  0.0.0.0/0 via 10.1.2.1
+  192.168.0.0/16 via 10.1.2.254 metric 100
+dns:
+  nameservers 10.1.2.1, 10.1.2.2
+`))
+	})
+
+	It("finds no difference between equal results", func() {
+		Expect(current.Diff(expected, actual)).To(BeEmpty())
+	})
+
+	It("ignores the order of routes and DNS settings and the case of MACs", func() {
+		actual.Routes[0], actual.Routes[1] = actual.Routes[1], actual.Routes[0]
+		actual.DNS.Nameservers = []string{"10.1.2.2", "10.1.2.1"}
+		actual.Interfaces[0].Mac = "00:11:22:AA:BB:CC"
+		expected.Interfaces[0].Mac = "00:11:22:aa:bb:cc"
+		Expect(current.Diff(expected, actual)).To(BeEmpty())
+	})
+
+	It("reports each differing field", func() {
+		actual.Interfaces[0].Mtu = 9000
+		actual.IPs[0].Address = mustParseCIDR("10.1.2.4/24")
+		actual.IPs[0].Gateway = nil
+		actual.Routes = actual.Routes[:1]
+		actual.DNS.Domain = "example.com"
+
+		diff := current.Diff(expected, actual)
+		Expect(diff).To(Equal(current.Mismatches{
+			{Field: "interfaces[0].mtu", Actual: "9000"},
+			{Field: "ips[0].address", Expected: "10.1.2.3/24", Actual: "10.1.2.4/24"},
+			{Field: "ips[0].gateway", Expected: "10.1.2.1"},
+			{Field: "routes", Expected: "192.168.0.0/16 via 10.1.2.254 metric 100"},
+			{Field: "dns.domain", Actual: "example.com"},
+		}))
+		Expect(diff.Error()).To(Equal("interfaces[0].mtu: unexpected 9000; " +
+			"ips[0].address: expected 10.1.2.3/24, got 10.1.2.4/24; " +
+			"ips[0].gateway: missing 10.1.2.1; " +
+			"routes: missing 192.168.0.0/16 via 10.1.2.254 metric 100; " +
+			"dns.domain: unexpected example.com"))
+	})
+
+	It("reports whole interfaces and IP configurations only in one result", func() {
+		actual.Interfaces = append(actual.Interfaces, &current.Interface{Name: "eth1"})
+		expected.IPs = append(expected.IPs, &current.IPConfig{
+			Version: "6",
+			Address: mustParseCIDR("2001:db8::3/64"),
+		})
+
+		Expect(current.Diff(expected, actual)).To(Equal(current.Mismatches{
+			{Field: "interfaces[1]", Actual: "eth1"},
+			{Field: "ips[1]", Expected: "2001:db8::3/64"},
+		}))
+	})
+
+	It("counts duplicate routes", func() {
+		actual.Routes = append(actual.Routes, actual.Routes[0])
+
+		Expect(current.Diff(expected, actual)).To(Equal(current.Mismatches{
+			{Field: "routes", Actual: "0.0.0.0/0 via 10.1.2.1"},
+		}))
+	})
+})
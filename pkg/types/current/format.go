@@ -0,0 +1,174 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package current
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// Pretty renders the result for people rather than programs, one
+// interface, IP configuration or route per line:
+//
+//	cniVersion: 0.3.0
+//	interfaces:
+//	  [0] eth0 mac 00:11:22:33:44:55 sandbox /proc/3553/ns/net
+//	ips:
+//	  [0] 10.1.2.3/24 gateway 10.1.2.1 on eth0
+//	routes:
+//	  0.0.0.0/0 via 10.1.2.1
+//	dns:
+//	  nameservers 10.1.2.1
+//
+// Sections with nothing in them are left out.
+func (r *Result) Pretty() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "cniVersion: %s\n", r.CNIVersion)
+
+	if len(r.Interfaces) > 0 {
+		b.WriteString("interfaces:\n")
+		for i, iface := range r.Interfaces {
+			fmt.Fprintf(&b, "  [%d] %s\n", i, formatInterface(iface))
+		}
+	}
+	if len(r.IPs) > 0 {
+		b.WriteString("ips:\n")
+		for i, ipc := range r.IPs {
+			fmt.Fprintf(&b, "  [%d] %s\n", i, r.formatIPConfig(ipc))
+		}
+	}
+	if len(r.Routes) > 0 {
+		b.WriteString("routes:\n")
+		for _, route := range r.Routes {
+			fmt.Fprintf(&b, "  %s\n", formatRoute(route))
+		}
+	}
+	if dns := formatDNS(r.DNS); dns != "" {
+		fmt.Fprintf(&b, "dns:\n  %s\n", dns)
+	}
+
+	return b.String()
+}
+
+func formatInterface(iface *Interface) string {
+	if iface == nil {
+		return "<nil>"
+	}
+	s := iface.Name
+	for _, attr := range []struct {
+		name, value string
+	}{
+		{"mac", iface.Mac},
+		{"sandbox", iface.Sandbox},
+		{"mtu", formatInt(iface.Mtu)},
+		{"pci", iface.PciID},
+		{"socket", iface.SocketPath},
+		{"device", formatDeviceInfo(iface.DeviceInfo)},
+	} {
+		if attr.value != "" {
+			s += " " + attr.name + " " + attr.value
+		}
+	}
+	return s
+}
+
+func formatDeviceInfo(info *DeviceInfo) string {
+	if info == nil {
+		return ""
+	}
+	s := info.Type
+	if info.Driver != "" {
+		s += "/" + info.Driver
+	}
+	if info.NUMANode != nil {
+		s += fmt.Sprintf(" numa %d", *info.NUMANode)
+	}
+	return s
+}
+
+// formatIPConfig names the interface of ipc when it is one of those of r
+func (r *Result) formatIPConfig(ipc *IPConfig) string {
+	if ipc == nil {
+		return "<nil>"
+	}
+	s := ipc.Address.String()
+	if ipc.Gateway != nil {
+		s += " gateway " + ipc.Gateway.String()
+	}
+	if ipc.Interface != nil {
+		idx := *ipc.Interface
+		if idx >= 0 && idx < len(r.Interfaces) && r.Interfaces[idx] != nil {
+			s += " on " + r.Interfaces[idx].Name
+		} else {
+			s += fmt.Sprintf(" on interface %d", idx)
+		}
+	}
+	return s
+}
+
+func formatRoute(route *types.Route) string {
+	if route == nil {
+		return "<nil>"
+	}
+	s := route.Dst.String()
+	if route.GW != nil {
+		s += " via " + route.GW.String()
+	}
+	mtu := "mtu"
+	if route.LockMTU {
+		mtu = "mtu lock"
+	}
+	for _, attr := range []struct {
+		name  string
+		value int
+	}{
+		{"scope", route.Scope},
+		{"table", route.Table},
+		{"metric", route.Priority},
+		{mtu, route.MTU},
+		{"advmss", route.AdvMSS},
+	} {
+		if attr.value != 0 {
+			s += fmt.Sprintf(" %s %d", attr.name, attr.value)
+		}
+	}
+	return s
+}
+
+func formatDNS(dns types.DNS) string {
+	var parts []string
+	if len(dns.Nameservers) > 0 {
+		parts = append(parts, "nameservers "+strings.Join(dns.Nameservers, ", "))
+	}
+	if dns.Domain != "" {
+		parts = append(parts, "domain "+dns.Domain)
+	}
+	if len(dns.Search) > 0 {
+		parts = append(parts, "search "+strings.Join(dns.Search, ", "))
+	}
+	if len(dns.Options) > 0 {
+		parts = append(parts, "options "+strings.Join(dns.Options, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func formatInt(i int) string {
+	if i == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d", i)
+}
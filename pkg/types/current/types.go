@@ -0,0 +1,320 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package current implements the result format of the 0.3.0 spec, which
+// describes the interfaces a plugin created and any number of IP addresses
+// on them, and converts it from and to the legacy IP4/IP6 result.
+package current
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// ImplementedSpecVersion is the version of the result format of this package
+const ImplementedSpecVersion string = "0.3.0"
+
+// SupportedVersions lists the versions a Result can be printed as
+var SupportedVersions = []string{"0.1.0", "0.2.0", ImplementedSpecVersion}
+
+// Result is what gets returned from the plugin (via stdout) to the caller
+type Result struct {
+	CNIVersion string         `json:"cniVersion,omitempty"`
+	Interfaces []*Interface   `json:"interfaces,omitempty"`
+	IPs        []*IPConfig    `json:"ips,omitempty"`
+	Routes     []*types.Route `json:"routes,omitempty"`
+	DNS        types.DNS      `json:"dns,omitempty"`
+}
+
+// Interface contains values about the created interfaces
+type Interface struct {
+	Name    string `json:"name"`
+	Mac     string `json:"mac,omitempty"`
+	Sandbox string `json:"sandbox,omitempty"`
+	Mtu     int    `json:"mtu,omitempty"`
+	// PciID is the PCI address of the device backing the interface, e.g.
+	// "0000:03:00.1" for an SR-IOV virtual function
+	PciID string `json:"pciID,omitempty"`
+	// SocketPath is the socket of a userspace device backing the
+	// interface, e.g. that of a vhost-user port
+	SocketPath string      `json:"socketPath,omitempty"`
+	DeviceInfo *DeviceInfo `json:"deviceInfo,omitempty"`
+}
+
+// DeviceInfo describes the device backing an interface, for runtimes
+// that pass it through to a VM or place workloads near it
+type DeviceInfo struct {
+	// Type is the kind of device, e.g. "pci", "vdpa" or "vhost-user"
+	Type string `json:"type"`
+	// Driver is the kernel driver the device is bound to, e.g. "vfio-pci"
+	Driver string `json:"driver,omitempty"`
+	// NUMANode is the NUMA node the device is attached to, if known
+	NUMANode *int `json:"numaNode,omitempty"`
+}
+
+func (i *Interface) String() string {
+	return fmt.Sprintf("%+v", *i)
+}
+
+// IPConfig contains values necessary to configure an IP address on an
+// interface
+type IPConfig struct {
+	// IP version, either "4" or "6"
+	Version string
+	// Index into Result.Interfaces of the interface the address is on,
+	// or nil if it is not tied to any of them
+	Interface *int
+	Address   net.IPNet
+	Gateway   net.IP
+}
+
+func (i *IPConfig) String() string {
+	return fmt.Sprintf("%+v", *i)
+}
+
+// JSON (un)marshallable types
+type ipConfig struct {
+	Version   string      `json:"version"`
+	Interface *int        `json:"interface,omitempty"`
+	Address   types.IPNet `json:"address"`
+	Gateway   net.IP      `json:"gateway,omitempty"`
+}
+
+func (c *IPConfig) MarshalJSON() ([]byte, error) {
+	ipc := ipConfig{
+		Version:   c.Version,
+		Interface: c.Interface,
+		Address:   types.IPNet(c.Address),
+		Gateway:   c.Gateway,
+	}
+
+	return json.Marshal(ipc)
+}
+
+func (c *IPConfig) UnmarshalJSON(data []byte) error {
+	ipc := ipConfig{}
+	if err := json.Unmarshal(data, &ipc); err != nil {
+		return err
+	}
+
+	c.Version = ipc.Version
+	c.Interface = ipc.Interface
+	c.Address = net.IPNet(ipc.Address)
+	c.Gateway = ipc.Gateway
+	return nil
+}
+
+// NewResult decodes a result printed in any of the SupportedVersions.
+// Results without a cniVersion are taken to be legacy ones.
+func NewResult(data []byte) (*Result, error) {
+	var v struct {
+		CNIVersion string `json:"cniVersion"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	switch v.CNIVersion {
+	case "", "0.1.0", "0.2.0":
+		legacy := &types.Result{}
+		if err := json.Unmarshal(data, legacy); err != nil {
+			return nil, err
+		}
+		return NewResultFromLegacy(legacy), nil
+
+	case ImplementedSpecVersion:
+		result := &Result{}
+		if err := json.Unmarshal(data, result); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("unsupported CNI result version %q", v.CNIVersion)
+}
+
+// ParsePrevResult decodes the prevResult of the network configuration
+// stdin, which the runtime may pass in any of the SupportedVersions,
+// whatever the cniVersion of the configuration. It returns nil if the
+// configuration has none.
+func ParsePrevResult(stdin []byte) (*Result, error) {
+	var conf struct {
+		PrevResult json.RawMessage `json:"prevResult"`
+	}
+	if err := json.Unmarshal(stdin, &conf); err != nil {
+		return nil, err
+	}
+	if len(conf.PrevResult) == 0 || string(conf.PrevResult) == "null" {
+		return nil, nil
+	}
+
+	result, err := NewResult(conf.PrevResult)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prevResult: %v", err)
+	}
+	return result, nil
+}
+
+// AddSandboxInterface adds the container interface name, with the
+// hardware address mac, in the network namespace netns, to the interfaces
+// of the result, and ties to it the IP addresses the result ties to no
+// other interface, as results converted from the legacy format never do.
+func (r *Result) AddSandboxInterface(name, mac, netns string) {
+	idx := len(r.Interfaces)
+	r.Interfaces = append(r.Interfaces, &Interface{Name: name, Mac: mac, Sandbox: netns})
+	for _, ipc := range r.IPs {
+		if ipc.Interface == nil {
+			ipc.Interface = &idx
+		}
+	}
+}
+
+// SandboxInterface returns the name of the first interface of the result
+// that is in a container, or "" if the result names none, as results
+// converted from the legacy format never do.
+func (r *Result) SandboxInterface() string {
+	for _, iface := range r.Interfaces {
+		if iface != nil && iface.Sandbox != "" {
+			return iface.Name
+		}
+	}
+	return ""
+}
+
+// NewResultFromLegacy converts a legacy result. Its routes, which are
+// nested in the IP configurations, become routes of the whole result.
+func NewResultFromLegacy(old *types.Result) *Result {
+	result := &Result{
+		CNIVersion: ImplementedSpecVersion,
+		DNS:        old.DNS,
+	}
+
+	for _, ip := range []struct {
+		version string
+		config  *types.IPConfig
+	}{
+		{"4", old.IP4},
+		{"6", old.IP6},
+	} {
+		if ip.config == nil {
+			continue
+		}
+
+		result.IPs = append(result.IPs, &IPConfig{
+			Version: ip.version,
+			Address: ip.config.IP,
+			Gateway: ip.config.Gateway,
+		})
+		for i := range ip.config.Routes {
+			route := ip.config.Routes[i]
+			result.Routes = append(result.Routes, &route)
+		}
+	}
+
+	return result
+}
+
+// Legacy converts the result to the legacy format. Only the first IP
+// address of each family is kept, and each route is attached to the IP
+// configuration of its family; interfaces cannot be expressed at all.
+func (r *Result) Legacy() *types.Result {
+	old := &types.Result{DNS: r.DNS}
+
+	for _, ip := range r.IPs {
+		config := &types.IPConfig{
+			IP:      ip.Address,
+			Gateway: ip.Gateway,
+		}
+		switch {
+		case ip.Version == "4" && old.IP4 == nil:
+			old.IP4 = config
+		case ip.Version == "6" && old.IP6 == nil:
+			old.IP6 = config
+		}
+	}
+
+	for _, route := range r.Routes {
+		config := old.IP6
+		if route.Dst.IP.To4() != nil {
+			config = old.IP4
+		}
+		if config != nil {
+			config.Routes = append(config.Routes, *route)
+		}
+	}
+
+	return old
+}
+
+// GetAsVersion returns the result in the format of the given version of
+// the spec: a *types.Result for the legacy versions, and for an empty
+// version, which configurations predating cniVersion mean as 0.1.0, a
+// *Result otherwise.
+func (r *Result) GetAsVersion(version string) (interface{}, error) {
+	switch version {
+	case "", "0.1.0", "0.2.0":
+		return r.Legacy(), nil
+
+	case ImplementedSpecVersion:
+		result := *r
+		result.CNIVersion = ImplementedSpecVersion
+		return &result, nil
+	}
+
+	return nil, types.NewIncompatibleCNIVersionError(version, SupportedVersions)
+}
+
+// PrintResult writes the result to stdout in the format of the given
+// version of the spec, which is normally the cniVersion of the network
+// configuration the plugin was called with.
+func PrintResult(result *Result, version string) error {
+	versioned, err := result.GetAsVersion(version)
+	if err != nil {
+		return err
+	}
+	return prettyPrint(versioned)
+}
+
+func (r *Result) Print() error {
+	return prettyPrint(r)
+}
+
+func prettyPrint(obj interface{}) error {
+	data, err := json.MarshalIndent(obj, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// String returns a formatted string in the form of
+// "Interfaces: $1, IP: $2, Routes: $3, DNS: $4"
+func (r *Result) String() string {
+	var str string
+	if len(r.Interfaces) > 0 {
+		str += fmt.Sprintf("Interfaces:%+v, ", r.Interfaces)
+	}
+	if len(r.IPs) > 0 {
+		str += fmt.Sprintf("IP:%+v, ", r.IPs)
+	}
+	if len(r.Routes) > 0 {
+		str += fmt.Sprintf("Routes:%+v, ", r.Routes)
+	}
+	return fmt.Sprintf("%sDNS:%+v", str, r.DNS)
+}
@@ -0,0 +1,260 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package current_test
+
+import (
+	"encoding/json"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func mustParseCIDR(s string) net.IPNet {
+	n, err := types.ParseCIDR(s)
+	Expect(err).NotTo(HaveOccurred())
+	return *n
+}
+
+var _ = Describe("Current types", func() {
+	var result *current.Result
+
+	BeforeEach(func() {
+		zero := 0
+		result = &current.Result{
+			CNIVersion: "0.3.0",
+			Interfaces: []*current.Interface{
+				{Name: "eth0", Mac: "00:11:22:33:44:55", Sandbox: "/proc/3553/ns/net"},
+			},
+			IPs: []*current.IPConfig{
+				{
+					Version:   "4",
+					Interface: &zero,
+					Address:   mustParseCIDR("1.2.3.30/24"),
+					Gateway:   net.ParseIP("1.2.3.1"),
+				},
+				{
+					Version:   "6",
+					Interface: &zero,
+					Address:   mustParseCIDR("abcd:1234:ffff::cdde/64"),
+					Gateway:   net.ParseIP("abcd:1234:ffff::1"),
+				},
+				{
+					Version: "4",
+					Address: mustParseCIDR("1.2.3.31/24"),
+				},
+			},
+			Routes: []*types.Route{
+				{Dst: mustParseCIDR("15.5.6.0/24"), GW: net.ParseIP("15.5.6.8")},
+				{Dst: mustParseCIDR("1111:dddd::/80"), GW: net.ParseIP("1111:dddd::aaaa")},
+			},
+			DNS: types.DNS{Nameservers: []string{"1.2.3.4"}},
+		}
+	})
+
+	It("marshals the 0.3.0 format", func() {
+		data, err := json.Marshal(result)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(MatchJSON(`{
+			"cniVersion": "0.3.0",
+			"interfaces": [
+				{"name": "eth0", "mac": "00:11:22:33:44:55", "sandbox": "/proc/3553/ns/net"}
+			],
+			"ips": [
+				{"version": "4", "interface": 0, "address": "1.2.3.30/24", "gateway": "1.2.3.1"},
+				{"version": "6", "interface": 0, "address": "abcd:1234:ffff::cdde/64", "gateway": "abcd:1234:ffff::1"},
+				{"version": "4", "address": "1.2.3.31/24"}
+			],
+			"routes": [
+				{"dst": "15.5.6.0/24", "gw": "15.5.6.8"},
+				{"dst": "1111:dddd::/80", "gw": "1111:dddd::aaaa"}
+			],
+			"dns": {"nameservers": ["1.2.3.4"]}
+		}`))
+
+		decoded, err := current.NewResult(data)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decoded).To(Equal(result))
+	})
+
+	It("marshals the device of an interface", func() {
+		node := 1
+		result.Interfaces = append(result.Interfaces, &current.Interface{
+			Name:       "net1",
+			Mtu:        9000,
+			PciID:      "0000:03:00.1",
+			DeviceInfo: &current.DeviceInfo{Type: "pci", Driver: "vfio-pci", NUMANode: &node},
+		}, &current.Interface{
+			Name:       "vhost0",
+			SocketPath: "/var/run/vhost/vhost0.sock",
+			DeviceInfo: &current.DeviceInfo{Type: "vhost-user"},
+		})
+
+		data, err := json.Marshal(result.Interfaces[1:])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(MatchJSON(`[
+			{
+				"name": "net1",
+				"mtu": 9000,
+				"pciID": "0000:03:00.1",
+				"deviceInfo": {"type": "pci", "driver": "vfio-pci", "numaNode": 1}
+			},
+			{
+				"name": "vhost0",
+				"socketPath": "/var/run/vhost/vhost0.sock",
+				"deviceInfo": {"type": "vhost-user"}
+			}
+		]`))
+
+		data, err = json.Marshal(result)
+		Expect(err).NotTo(HaveOccurred())
+		decoded, err := current.NewResult(data)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decoded).To(Equal(result))
+	})
+
+	It("converts to the legacy format, keeping the first address of each family", func() {
+		legacy, err := result.GetAsVersion("0.2.0")
+		Expect(err).NotTo(HaveOccurred())
+
+		data, err := json.Marshal(legacy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(MatchJSON(`{
+			"ip4": {
+				"ip": "1.2.3.30/24",
+				"gateway": "1.2.3.1",
+				"routes": [{"dst": "15.5.6.0/24", "gw": "15.5.6.8"}]
+			},
+			"ip6": {
+				"ip": "abcd:1234:ffff::cdde/64",
+				"gateway": "abcd:1234:ffff::1",
+				"routes": [{"dst": "1111:dddd::/80", "gw": "1111:dddd::aaaa"}]
+			},
+			"dns": {"nameservers": ["1.2.3.4"]}
+		}`))
+	})
+
+	It("takes an empty version to be the legacy one", func() {
+		legacy, err := result.GetAsVersion("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(legacy).To(BeAssignableToTypeOf(&types.Result{}))
+	})
+
+	It("converts a legacy result", func() {
+		converted, err := current.NewResult([]byte(`{
+			"ip4": {
+				"ip": "1.2.3.30/24",
+				"gateway": "1.2.3.1",
+				"routes": [{"dst": "15.5.6.0/24", "gw": "15.5.6.8"}]
+			},
+			"dns": {"nameservers": ["1.2.3.4"]}
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(converted).To(Equal(&current.Result{
+			CNIVersion: "0.3.0",
+			IPs: []*current.IPConfig{
+				{
+					Version: "4",
+					Address: mustParseCIDR("1.2.3.30/24"),
+					Gateway: net.ParseIP("1.2.3.1"),
+				},
+			},
+			Routes: []*types.Route{
+				{Dst: mustParseCIDR("15.5.6.0/24"), GW: net.ParseIP("15.5.6.8")},
+			},
+			DNS: types.DNS{Nameservers: []string{"1.2.3.4"}},
+		}))
+	})
+
+	It("decodes a prevResult of any version", func() {
+		prev, err := current.ParsePrevResult([]byte(`{"prevResult": {"ip4": {"ip": "1.2.3.30/24"}}}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(prev.IPs).To(Equal([]*current.IPConfig{{Version: "4", Address: mustParseCIDR("1.2.3.30/24")}}))
+
+		data, err := json.Marshal(map[string]interface{}{"cniVersion": "0.2.0", "prevResult": result})
+		Expect(err).NotTo(HaveOccurred())
+		prev, err = current.ParsePrevResult(data)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(prev).To(Equal(result))
+
+		prev, err = current.ParsePrevResult([]byte(`{"name": "mynet"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(prev).To(BeNil())
+
+		_, err = current.ParsePrevResult([]byte(`{"prevResult": {"cniVersion": "9.9.9"}}`))
+		Expect(err).To(MatchError(`invalid prevResult: unsupported CNI result version "9.9.9"`))
+	})
+
+	It("ties the addresses of a converted result to its container interface", func() {
+		converted := current.NewResultFromLegacy(&types.Result{IP4: &types.IPConfig{IP: mustParseCIDR("1.2.3.30/24")}})
+		converted.AddSandboxInterface("eth1", "0a:58:01:02:03:1e", "/proc/3553/ns/net")
+
+		zero := 0
+		Expect(converted.Interfaces).To(Equal([]*current.Interface{{Name: "eth1", Mac: "0a:58:01:02:03:1e", Sandbox: "/proc/3553/ns/net"}}))
+		Expect(converted.IPs).To(Equal([]*current.IPConfig{{Version: "4", Interface: &zero, Address: mustParseCIDR("1.2.3.30/24")}}))
+		Expect(converted.SandboxInterface()).To(Equal("eth1"))
+	})
+
+	It("names the interface of the result that is in a container", func() {
+		Expect(result.SandboxInterface()).To(Equal("eth0"))
+
+		result.Interfaces = []*current.Interface{{Name: "cni0"}}
+		Expect(result.SandboxInterface()).To(Equal(""))
+	})
+
+	It("refuses to convert to an unknown version", func() {
+		_, err := result.GetAsVersion("9.9.9")
+		Expect(err).To(HaveOccurred())
+		code, _ := types.ErrorCode(err)
+		Expect(code).To(Equal(types.ErrIncompatibleCNIVersion))
+	})
+
+	Context("validating", func() {
+		It("accepts a well-formed result", func() {
+			Expect(result.Validate()).To(Succeed())
+		})
+
+		It("reports every problem of a result", func() {
+			two := 2
+			result.Interfaces = append(result.Interfaces, &current.Interface{Mac: "not-a-mac"})
+			result.IPs[0].Interface = &two
+			result.IPs[1].Gateway = net.ParseIP("1.2.3.1")
+			result.IPs[2].Version = "5"
+			result.Routes = append(result.Routes, &types.Route{})
+
+			Expect(result.Validate()).To(MatchError(`interfaces[1]: missing name; ` +
+				`interfaces[1]: invalid mac "not-a-mac"; ` +
+				`ips[0]: interface index 2 out of range (2 interfaces); ` +
+				`ips[1]: gateway 1.2.3.1 is not IPv6; ` +
+				`ips[2]: invalid version "5"; ` +
+				`routes[2]: missing dst`))
+		})
+
+		It("rejects addresses of the wrong family", func() {
+			result.IPs[0].Address = mustParseCIDR("abcd::1/64")
+			result.IPs[0].Gateway = nil
+			Expect(result.Validate()).To(MatchError("ips[0]: address abcd::1 is not IPv4"))
+		})
+
+		It("rejects addresses without a prefix length", func() {
+			result.IPs[0].Address = net.IPNet{IP: net.ParseIP("1.2.3.30")}
+			Expect(result.Validate()).To(MatchError("ips[0]: address 1.2.3.30 has an invalid prefix length"))
+		})
+	})
+})
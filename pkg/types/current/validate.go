@@ -0,0 +1,110 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package current
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Validate checks that the result is well-formed: interfaces are named,
+// IP configurations reference existing interfaces and carry addresses
+// and gateways of their version, and routes have a destination. It
+// reports every problem found in one error.
+func (r *Result) Validate() error {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	for i, iface := range r.Interfaces {
+		if iface == nil {
+			problem("interfaces[%d]: null interface", i)
+			continue
+		}
+		if iface.Name == "" {
+			problem("interfaces[%d]: missing name", i)
+		}
+		if iface.Mac != "" {
+			if _, err := net.ParseMAC(iface.Mac); err != nil {
+				problem("interfaces[%d]: invalid mac %q", i, iface.Mac)
+			}
+		}
+	}
+
+	for i, ipc := range r.IPs {
+		if ipc == nil {
+			problem("ips[%d]: null IP configuration", i)
+			continue
+		}
+		if ipc.Interface != nil && (*ipc.Interface < 0 || *ipc.Interface >= len(r.Interfaces)) {
+			problem("ips[%d]: interface index %d out of range (%d interfaces)", i, *ipc.Interface, len(r.Interfaces))
+		}
+
+		var bits int
+		switch ipc.Version {
+		case "4":
+			bits = 32
+		case "6":
+			bits = 128
+		default:
+			problem("ips[%d]: invalid version %q", i, ipc.Version)
+			continue
+		}
+		if ipc.Address.IP == nil {
+			problem("ips[%d]: missing address", i)
+			continue
+		}
+		if family(ipc.Address.IP) != bits {
+			problem("ips[%d]: address %v is not IPv%s", i, ipc.Address.IP, ipc.Version)
+		} else if _, maskBits := ipc.Address.Mask.Size(); maskBits != bits {
+			problem("ips[%d]: address %v has an invalid prefix length", i, ipc.Address.IP)
+		}
+		if ipc.Gateway != nil && family(ipc.Gateway) != bits {
+			problem("ips[%d]: gateway %v is not IPv%s", i, ipc.Gateway, ipc.Version)
+		}
+	}
+
+	for i, route := range r.Routes {
+		if route == nil {
+			problem("routes[%d]: null route", i)
+			continue
+		}
+		if route.Dst.IP == nil {
+			problem("routes[%d]: missing dst", i)
+			continue
+		}
+		if _, maskBits := route.Dst.Mask.Size(); maskBits != family(route.Dst.IP) {
+			problem("routes[%d]: dst %v has an invalid prefix length", i, route.Dst.IP)
+		}
+		if route.GW != nil && family(route.GW) != family(route.Dst.IP) {
+			problem("routes[%d]: gateway %v is not of the family of dst %v", i, route.GW, route.Dst.IP)
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// family returns the length in bits of the addresses of the family of ip
+func family(ip net.IP) int {
+	if ip.To4() != nil {
+		return 32
+	}
+	return 128
+}
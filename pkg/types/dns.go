@@ -0,0 +1,52 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+// MergeDNS combines DNS settings given in decreasing order of precedence:
+// the domain is the first one set, and nameservers, search domains and
+// options are those of every source in that order, with duplicates
+// dropped. Sources with nothing set do not affect the result.
+//
+// Plugins merge the dns of their configuration over the one of the IPAM
+// result, and libcni merges the runtime's dns capability over the
+// results of the plugins of a chain, the last plugin first.
+func MergeDNS(sources ...DNS) DNS {
+	merged := DNS{}
+	for _, dns := range sources {
+		if merged.Domain == "" {
+			merged.Domain = dns.Domain
+		}
+		merged.Nameservers = appendUnique(merged.Nameservers, dns.Nameservers)
+		merged.Search = appendUnique(merged.Search, dns.Search)
+		merged.Options = appendUnique(merged.Options, dns.Options)
+	}
+	return merged
+}
+
+func appendUnique(list, values []string) []string {
+	for _, v := range values {
+		found := false
+		for _, existing := range list {
+			if existing == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+	return list
+}
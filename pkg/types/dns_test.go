@@ -0,0 +1,43 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types_test
+
+import (
+	"github.com/containernetworking/cni/pkg/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MergeDNS", func() {
+	It("takes the first domain and every list entry in order of precedence", func() {
+		merged := types.MergeDNS(
+			types.DNS{Nameservers: []string{"10.0.0.10"}, Search: []string{"svc.local"}},
+			types.DNS{Domain: "plugin.local", Nameservers: []string{"8.8.8.8", "10.0.0.10"}, Options: []string{"ndots:5"}},
+			types.DNS{Domain: "ipam.local", Nameservers: []string{"1.1.1.1"}, Search: []string{"ipam.local", "svc.local"}},
+		)
+
+		Expect(merged).To(Equal(types.DNS{
+			Domain:      "plugin.local",
+			Nameservers: []string{"10.0.0.10", "8.8.8.8", "1.1.1.1"},
+			Search:      []string{"svc.local", "ipam.local"},
+			Options:     []string{"ndots:5"},
+		}))
+	})
+
+	It("is empty without any settings", func() {
+		Expect(types.MergeDNS()).To(Equal(types.DNS{}))
+		Expect(types.MergeDNS(types.DNS{}, types.DNS{})).To(Equal(types.DNS{}))
+	})
+})
@@ -0,0 +1,130 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// NetConfList describes an ordered list of networks, the contents of a
+// .conflist file.
+type NetConfList struct {
+	CNIVersion string `json:"cniVersion,omitempty"`
+
+	Name         string     `json:"name,omitempty"`
+	DisableCheck bool       `json:"disableCheck,omitempty"`
+	DisableGC    bool       `json:"disableGC,omitempty"`
+	Plugins      []*NetConf `json:"plugins,omitempty"`
+
+	// RawPlugins holds the JSON of each plugin as it was decoded, with the
+	// fields NetConf does not know about. MarshalJSON writes it out unchanged,
+	// so use SetPlugin rather than modifying Plugins directly.
+	RawPlugins []json.RawMessage `json:"-"`
+}
+
+type netConfList struct {
+	CNIVersion   string            `json:"cniVersion,omitempty"`
+	Name         string            `json:"name,omitempty"`
+	DisableCheck bool              `json:"disableCheck,omitempty"`
+	DisableGC    bool              `json:"disableGC,omitempty"`
+	Plugins      []json.RawMessage `json:"plugins"`
+}
+
+// ParseNetConfList decodes a network configuration list
+func ParseNetConfList(data []byte) (*NetConfList, error) {
+	list := &NetConfList{}
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (l *NetConfList) UnmarshalJSON(data []byte) error {
+	raw := netConfList{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	plugins := make([]*NetConf, len(raw.Plugins))
+	for i, rawPlugin := range raw.Plugins {
+		plugins[i] = &NetConf{}
+		if err := json.Unmarshal(rawPlugin, plugins[i]); err != nil {
+			return fmt.Errorf("failed to parse plugin config %d: %v", i, err)
+		}
+	}
+
+	l.CNIVersion = raw.CNIVersion
+	l.Name = raw.Name
+	l.DisableCheck = raw.DisableCheck
+	l.DisableGC = raw.DisableGC
+	l.RawPlugins = raw.Plugins
+	if raw.Plugins != nil {
+		l.Plugins = plugins
+	} else {
+		l.Plugins = nil
+	}
+	return nil
+}
+
+// MarshalJSON writes each plugin from RawPlugins when it is there, and
+// from Plugins otherwise.
+func (l *NetConfList) MarshalJSON() ([]byte, error) {
+	raw := netConfList{
+		CNIVersion:   l.CNIVersion,
+		Name:         l.Name,
+		DisableCheck: l.DisableCheck,
+		DisableGC:    l.DisableGC,
+		Plugins:      []json.RawMessage{},
+	}
+
+	for i, plugin := range l.Plugins {
+		if i < len(l.RawPlugins) && l.RawPlugins[i] != nil {
+			raw.Plugins = append(raw.Plugins, l.RawPlugins[i])
+			continue
+		}
+		data, err := json.Marshal(plugin)
+		if err != nil {
+			return nil, err
+		}
+		raw.Plugins = append(raw.Plugins, data)
+	}
+
+	return json.Marshal(raw)
+}
+
+// SetPlugin replaces the configuration of plugin i, or appends it if i is
+// the number of plugins in the list.
+func (l *NetConfList) SetPlugin(i int, data []byte) error {
+	if i < 0 || i > len(l.Plugins) {
+		return fmt.Errorf("plugin index %d out of range", i)
+	}
+
+	plugin := &NetConf{}
+	if err := json.Unmarshal(data, plugin); err != nil {
+		return fmt.Errorf("failed to parse plugin config %d: %v", i, err)
+	}
+
+	for len(l.RawPlugins) < len(l.Plugins) {
+		l.RawPlugins = append(l.RawPlugins, nil)
+	}
+	if i == len(l.Plugins) {
+		l.Plugins = append(l.Plugins, plugin)
+		l.RawPlugins = append(l.RawPlugins, nil)
+	}
+	l.Plugins[i] = plugin
+	l.RawPlugins[i] = append(json.RawMessage(nil), data...)
+	return nil
+}
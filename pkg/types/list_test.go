@@ -0,0 +1,98 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types_test
+
+import (
+	"encoding/json"
+
+	"github.com/containernetworking/cni/pkg/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NetConfList", func() {
+	const listJSON = `{
+		"cniVersion": "0.3.0",
+		"name": "mynet",
+		"disableCheck": true,
+		"disableGC": true,
+		"plugins": [
+			{"type": "bridge", "bridge": "cni0", "ipam": {"type": "host-local", "subnet": "10.1.0.0/16"}},
+			{"type": "tuning", "sysctl": {"net.core.somaxconn": "500"}}
+		]
+	}`
+
+	It("decodes the list and each plugin", func() {
+		list, err := types.ParseNetConfList([]byte(listJSON))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(list.CNIVersion).To(Equal("0.3.0"))
+		Expect(list.Name).To(Equal("mynet"))
+		Expect(list.DisableCheck).To(BeTrue())
+		Expect(list.DisableGC).To(BeTrue())
+		Expect(list.Plugins).To(HaveLen(2))
+		Expect(list.Plugins[0].Type).To(Equal("bridge"))
+		Expect(list.Plugins[0].IPAM.Type).To(Equal("host-local"))
+		Expect(string(list.RawPlugins[1])).To(Equal(`{"type": "tuning", "sysctl": {"net.core.somaxconn": "500"}}`))
+	})
+
+	It("preserves unknown plugin fields when marshalled", func() {
+		list, err := types.ParseNetConfList([]byte(listJSON))
+		Expect(err).NotTo(HaveOccurred())
+
+		data, err := json.Marshal(list)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(MatchJSON(listJSON))
+	})
+
+	It("replaces and appends plugins", func() {
+		list, err := types.ParseNetConfList([]byte(listJSON))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(list.SetPlugin(1, []byte(`{"type": "portmap", "snat": true}`))).To(Succeed())
+		Expect(list.SetPlugin(2, []byte(`{"type": "bandwidth"}`))).To(Succeed())
+		Expect(list.SetPlugin(4, []byte(`{"type": "nope"}`))).To(MatchError("plugin index 4 out of range"))
+
+		Expect(list.Plugins[1].Type).To(Equal("portmap"))
+		data, err := json.Marshal(list)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(MatchJSON(`{
+			"cniVersion": "0.3.0",
+			"name": "mynet",
+			"disableCheck": true,
+			"disableGC": true,
+			"plugins": [
+				{"type": "bridge", "bridge": "cni0", "ipam": {"type": "host-local", "subnet": "10.1.0.0/16"}},
+				{"type": "portmap", "snat": true},
+				{"type": "bandwidth"}
+			]
+		}`))
+	})
+
+	It("marshals plugins built in code", func() {
+		list := &types.NetConfList{
+			Name:    "mynet",
+			Plugins: []*types.NetConf{{Type: "loopback"}},
+		}
+		data, err := json.Marshal(list)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(MatchJSON(`{"name": "mynet", "plugins": [{"type": "loopback", "ipam": {}, "dns": {}}]}`))
+	})
+
+	It("reports invalid plugins", func() {
+		_, err := types.ParseNetConfList([]byte(`{"name": "mynet", "plugins": [{"type": 1}]}`))
+		Expect(err).To(MatchError(ContainSubstring("failed to parse plugin config 0")))
+	})
+})
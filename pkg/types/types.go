@@ -16,6 +16,7 @@ package types
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -57,12 +58,19 @@ func (n *IPNet) UnmarshalJSON(data []byte) error {
 
 // NetConf describes a network.
 type NetConf struct {
-	Name string `json:"name,omitempty"`
-	Type string `json:"type,omitempty"`
-	IPAM struct {
+	CNIVersion string `json:"cniVersion,omitempty"`
+
+	Name         string          `json:"name,omitempty"`
+	Type         string          `json:"type,omitempty"`
+	Capabilities map[string]bool `json:"capabilities,omitempty"`
+	IPAM         struct {
 		Type string `json:"type,omitempty"`
 	} `json:"ipam,omitempty"`
 	DNS DNS `json:"dns"`
+
+	// PrevResult is the result of the previous plugin in a chain, or
+	// of the ADD being checked on CHECK
+	PrevResult *Result `json:"prevResult,omitempty"`
 }
 
 // Result is what gets returned from the plugin (via stdout) to the caller
@@ -105,25 +113,149 @@ type DNS struct {
 	Options     []string `json:"options,omitempty"`
 }
 
+// Route describes a route to configure. All fields but Dst are optional;
+// zero values select the kernel defaults.
 type Route struct {
 	Dst net.IPNet
 	GW  net.IP
+	// Scope of the route, as in rtnetlink (e.g. 253 for link scope)
+	Scope int
+	// Table is the routing table to add the route to, instead of main
+	Table int
+	// Priority is the metric of the route
+	Priority int
+	// MTU and AdvMSS set the path MTU and advertised MSS for the route
+	MTU    int
+	AdvMSS int
+	// LockMTU keeps path MTU discovery from changing MTU
+	LockMTU bool
 }
 
+// Well-known error codes; see the SPEC for their meaning. Codes 0-99 are
+// reserved for these, plugins are free to use higher ones.
+const (
+	ErrIncompatibleCNIVersion      uint = 1
+	ErrUnsupportedField            uint = 2
+	ErrUnknownContainer            uint = 3
+	ErrInvalidEnvironmentVariables uint = 4
+	ErrInvalidNetworkConfig        uint = 7
+	ErrTryAgainLater               uint = 11
+	ErrInterfaceExists             uint = 12
+	ErrInterrupted                 uint = 13
+	ErrNotReady                    uint = 14
+	ErrInternal                    uint = 99
+)
+
 type Error struct {
 	Code    uint   `json:"code"`
 	Msg     string `json:"msg"`
 	Details string `json:"details,omitempty"`
+	// Fields carries machine-readable details, e.g. {"pool": "10.1.0.0/16"}
+	Fields map[string]string `json:"fields,omitempty"`
+
+	// err is the wrapped error; it does not survive JSON encoding, but its
+	// message is kept in Details
+	err error
 }
 
 func (e *Error) Error() string {
-	return e.Msg
+	details := ""
+	if e.Details != "" {
+		details = fmt.Sprintf("; %v", e.Details)
+	}
+	return fmt.Sprintf("%v%v", e.Msg, details)
 }
 
 func (e *Error) Print() error {
 	return prettyPrint(e)
 }
 
+// NewError returns an Error with the given code, message and details
+func NewError(code uint, msg, details string) *Error {
+	return &Error{
+		Code:    code,
+		Msg:     msg,
+		Details: details,
+	}
+}
+
+// WrapError returns an Error with the given code and message that wraps
+// err, so that errors.Is and errors.As see through it. The message of err
+// becomes the details.
+func WrapError(code uint, msg string, err error) *Error {
+	e := NewError(code, msg, "")
+	if err != nil {
+		e.Details = err.Error()
+		e.err = err
+	}
+	return e
+}
+
+// Unwrap returns the error passed to WrapError, if any
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether target is an *Error with the same code, so that
+// errors.Is(err, &Error{Code: ErrTryAgainLater}) matches any such error.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// WithField sets a field of e and returns e
+func (e *Error) WithField(key, value string) *Error {
+	if e.Fields == nil {
+		e.Fields = map[string]string{}
+	}
+	e.Fields[key] = value
+	return e
+}
+
+// NewIncompatibleCNIVersionError reports that the plugin does not support
+// the requested version of the spec.
+func NewIncompatibleCNIVersionError(version string, supported []string) *Error {
+	return NewError(ErrIncompatibleCNIVersion,
+		fmt.Sprintf("incompatible CNI versions; config is %q, plugin supports %q", version, supported), "")
+}
+
+// NewUnsupportedFieldError reports a network configuration field, with the
+// given value, that the plugin does not support.
+func NewUnsupportedFieldError(field string, value interface{}) *Error {
+	return NewError(ErrUnsupportedField,
+		fmt.Sprintf("unsupported field %q: %v", field, value), "")
+}
+
+// NewUnknownContainerError reports that the container does not exist or
+// is not known to the plugin.
+func NewUnknownContainerError(containerID string) *Error {
+	return NewError(ErrUnknownContainer,
+		fmt.Sprintf("unknown container %q", containerID), "")
+}
+
+// NewTryAgainLaterError reports a transient failure; the runtime should
+// retry the operation later.
+func NewTryAgainLaterError(msg string) *Error {
+	return NewError(ErrTryAgainLater, msg, "")
+}
+
+// NewInterfaceExistsError reports that the interface name requested for
+// the container is already in use there.
+func NewInterfaceExistsError(ifName string) *Error {
+	return NewError(ErrInterfaceExists,
+		fmt.Sprintf("interface %q already exists in the container", ifName), "").
+		WithField("ifName", ifName)
+}
+
+// ErrorCode returns the code of the first *Error in err's chain, if any
+func ErrorCode(err error) (uint, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code, true
+	}
+	return 0, false
+}
+
 // net.IPNet is not JSON (un)marshallable so this duality is needed
 // for our custom IPNet type
 
@@ -135,8 +267,14 @@ type ipConfig struct {
 }
 
 type route struct {
-	Dst IPNet  `json:"dst"`
-	GW  net.IP `json:"gw,omitempty"`
+	Dst      IPNet  `json:"dst"`
+	GW       net.IP `json:"gw,omitempty"`
+	Scope    int    `json:"scope,omitempty"`
+	Table    int    `json:"table,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	MTU      int    `json:"mtu,omitempty"`
+	AdvMSS   int    `json:"advmss,omitempty"`
+	LockMTU  bool   `json:"mtuLock,omitempty"`
 }
 
 func (c *IPConfig) MarshalJSON() ([]byte, error) {
@@ -169,13 +307,25 @@ func (r *Route) UnmarshalJSON(data []byte) error {
 
 	r.Dst = net.IPNet(rt.Dst)
 	r.GW = rt.GW
+	r.Scope = rt.Scope
+	r.Table = rt.Table
+	r.Priority = rt.Priority
+	r.MTU = rt.MTU
+	r.AdvMSS = rt.AdvMSS
+	r.LockMTU = rt.LockMTU
 	return nil
 }
 
 func (r *Route) MarshalJSON() ([]byte, error) {
 	rt := route{
-		Dst: IPNet(r.Dst),
-		GW:  r.GW,
+		Dst:      IPNet(r.Dst),
+		GW:       r.GW,
+		Scope:    r.Scope,
+		Table:    r.Table,
+		Priority: r.Priority,
+		MTU:      r.MTU,
+		AdvMSS:   r.AdvMSS,
+		LockMTU:  r.LockMTU,
 	}
 
 	return json.Marshal(rt)
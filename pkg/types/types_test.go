@@ -0,0 +1,129 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Error", func() {
+	It("includes the details in the message", func() {
+		err := types.NewError(types.ErrUnsupportedField, "unsupported field", "mtu")
+		Expect(err).To(MatchError("unsupported field; mtu"))
+	})
+
+	It("wraps an underlying error", func() {
+		cause := errors.New("no such file or directory")
+		err := types.WrapError(types.ErrTryAgainLater, "netns is gone", cause)
+
+		Expect(err).To(MatchError("netns is gone; no such file or directory"))
+		Expect(errors.Is(err, cause)).To(BeTrue())
+		Expect(errors.Unwrap(err)).To(Equal(cause))
+	})
+
+	It("matches other errors with the same code", func() {
+		err := fmt.Errorf("add failed: %w", types.NewTryAgainLaterError("busy"))
+		Expect(errors.Is(err, &types.Error{Code: types.ErrTryAgainLater})).To(BeTrue())
+		Expect(errors.Is(err, &types.Error{Code: types.ErrUnknownContainer})).To(BeFalse())
+	})
+
+	It("round-trips through JSON with its fields", func() {
+		err := types.WrapError(110, "IP pool exhausted", errors.New("no addresses left in range")).
+			WithField("pool", "10.1.0.0/16").
+			WithField("network", "mynet")
+
+		data, jsonErr := json.Marshal(err)
+		Expect(jsonErr).NotTo(HaveOccurred())
+		Expect(data).To(MatchJSON(`{
+			"code": 110,
+			"msg": "IP pool exhausted",
+			"details": "no addresses left in range",
+			"fields": {"pool": "10.1.0.0/16", "network": "mynet"}
+		}`))
+
+		decoded := &types.Error{}
+		Expect(json.Unmarshal(data, decoded)).To(Succeed())
+		Expect(decoded.Code).To(Equal(uint(110)))
+		Expect(decoded.Details).To(Equal("no addresses left in range"))
+		Expect(decoded.Fields).To(Equal(map[string]string{"pool": "10.1.0.0/16", "network": "mynet"}))
+	})
+
+	It("names the taken interface in an interface-exists error", func() {
+		err := types.NewInterfaceExistsError("eth0")
+		Expect(err.Code).To(Equal(types.ErrInterfaceExists))
+		Expect(err.Fields).To(Equal(map[string]string{"ifName": "eth0"}))
+	})
+
+	It("finds the code of a wrapped error", func() {
+		err := fmt.Errorf("plugin failed: %w", types.NewTryAgainLaterError("busy"))
+
+		code, ok := types.ErrorCode(err)
+		Expect(ok).To(BeTrue())
+		Expect(code).To(Equal(types.ErrTryAgainLater))
+	})
+
+	It("reports no code for other errors", func() {
+		_, ok := types.ErrorCode(errors.New("banana"))
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("Route", func() {
+	It("round-trips the optional attributes through JSON", func() {
+		route := types.Route{}
+		Expect(json.Unmarshal([]byte(`{
+			"dst": "10.0.0.0/8",
+			"gw": "10.1.1.1",
+			"scope": 253,
+			"table": 100,
+			"priority": 20,
+			"mtu": 1400,
+			"advmss": 1360
+		}`), &route)).To(Succeed())
+
+		Expect(route.Dst.String()).To(Equal("10.0.0.0/8"))
+		Expect(route.Scope).To(Equal(253))
+		Expect(route.Table).To(Equal(100))
+		Expect(route.Priority).To(Equal(20))
+		Expect(route.MTU).To(Equal(1400))
+		Expect(route.AdvMSS).To(Equal(1360))
+
+		data, err := json.Marshal(&route)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(MatchJSON(`{
+			"dst": "10.0.0.0/8",
+			"gw": "10.1.1.1",
+			"scope": 253,
+			"table": 100,
+			"priority": 20,
+			"mtu": 1400,
+			"advmss": 1360
+		}`))
+	})
+
+	It("omits the attributes that are not set", func() {
+		_, dst, _ := net.ParseCIDR("10.0.0.0/8")
+		data, err := json.Marshal(&types.Route{Dst: *dst})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(MatchJSON(`{"dst": "10.0.0.0/8"}`))
+	})
+})
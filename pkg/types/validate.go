@@ -0,0 +1,72 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MaxInterfaceNameLen is the kernel's IFNAMSIZ without the terminating NUL
+const MaxInterfaceNameLen = 15
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.\-]*$`)
+
+const validNameRule = "must start with an alphanumeric character and contain only alphanumerics, '_', '.' and '-'"
+
+// ValidateNetworkName checks that name can be used as a network name,
+// which also ends up in file paths of IPAM plugins.
+func ValidateNetworkName(name string) *Error {
+	if name == "" {
+		return NewError(ErrInvalidNetworkConfig, "missing network name", "")
+	}
+	if !validName.MatchString(name) {
+		return NewError(ErrInvalidNetworkConfig, fmt.Sprintf("invalid network name %q: %s", name, validNameRule), "")
+	}
+	return nil
+}
+
+// ValidateContainerID checks the format of a container ID
+func ValidateContainerID(containerID string) *Error {
+	if containerID == "" {
+		return NewError(ErrInvalidEnvironmentVariables, "missing container ID", "")
+	}
+	if !validName.MatchString(containerID) {
+		return NewError(ErrInvalidEnvironmentVariables, fmt.Sprintf("invalid container ID %q: %s", containerID, validNameRule), "")
+	}
+	return nil
+}
+
+// ValidateInterfaceName checks that the kernel will accept ifName as the
+// name of a network interface. "lo" is allowed, as runtimes pass it to
+// the loopback plugin.
+func ValidateInterfaceName(ifName string) *Error {
+	invalid := func(reason string) *Error {
+		return NewError(ErrInvalidEnvironmentVariables, fmt.Sprintf("invalid interface name %q: %s", ifName, reason), "")
+	}
+
+	switch {
+	case ifName == "":
+		return NewError(ErrInvalidEnvironmentVariables, "missing interface name", "")
+	case len(ifName) > MaxInterfaceNameLen:
+		return invalid(fmt.Sprintf("longer than %d characters", MaxInterfaceNameLen))
+	case ifName == "." || ifName == "..":
+		return invalid("cannot be '.' or '..'")
+	case strings.ContainsAny(ifName, "/: \t\n"):
+		return invalid("cannot contain '/', ':' or whitespace")
+	}
+	return nil
+}
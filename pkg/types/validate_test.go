@@ -0,0 +1,78 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types_test
+
+import (
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validating names", func() {
+	DescribeTable("network names",
+		func(name, msg string) {
+			err := types.ValidateNetworkName(name)
+			if msg == "" {
+				Expect(err).To(BeNil())
+				return
+			}
+			Expect(err).NotTo(BeNil())
+			Expect(err.Code).To(Equal(types.ErrInvalidNetworkConfig))
+			Expect(err.Msg).To(Equal(msg))
+		},
+		Entry("valid", "my-net_1.0", ""),
+		Entry("empty", "", "missing network name"),
+		Entry("with a slash", "my/net", `invalid network name "my/net": must start with an alphanumeric character and contain only alphanumerics, '_', '.' and '-'`),
+		Entry("starting with a dot", ".net", `invalid network name ".net": must start with an alphanumeric character and contain only alphanumerics, '_', '.' and '-'`),
+	)
+
+	DescribeTable("container IDs",
+		func(id string, valid bool) {
+			err := types.ValidateContainerID(id)
+			if valid {
+				Expect(err).To(BeNil())
+				return
+			}
+			Expect(err).NotTo(BeNil())
+			Expect(err.Code).To(Equal(types.ErrInvalidEnvironmentVariables))
+		},
+		Entry("valid", "0123456789abcdef", true),
+		Entry("empty", "", false),
+		Entry("with a space", "some container", false),
+	)
+
+	DescribeTable("interface names",
+		func(ifName, msg string) {
+			err := types.ValidateInterfaceName(ifName)
+			if msg == "" {
+				Expect(err).To(BeNil())
+				return
+			}
+			Expect(err).NotTo(BeNil())
+			Expect(err.Code).To(Equal(types.ErrInvalidEnvironmentVariables))
+			Expect(err.Msg).To(Equal(msg))
+		},
+		Entry("valid", "eth0", ""),
+		Entry("loopback", "lo", ""),
+		Entry("at the length limit", strings.Repeat("a", 15), ""),
+		Entry("empty", "", "missing interface name"),
+		Entry("too long", strings.Repeat("a", 16), `invalid interface name "aaaaaaaaaaaaaaaa": longer than 15 characters`),
+		Entry("dot dot", "..", `invalid interface name "..": cannot be '.' or '..'`),
+		Entry("with a colon", "eth0:1", `invalid interface name "eth0:1": cannot contain '/', ':' or whitespace`),
+	)
+})
@@ -0,0 +1,79 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hwaddr
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net"
+)
+
+const (
+	hwAddrLen         = 6
+	ipRelevantByteLen = 4
+)
+
+var (
+	// PrivateMACPrefix is a locally administered, unicast prefix for
+	// generated hardware addresses
+	PrivateMACPrefix = []byte{0x0a, 0x58}
+)
+
+type SupportIp4OnlyErr struct{ msg string }
+
+func (e SupportIp4OnlyErr) Error() string { return e.msg }
+
+type InvalidPrefixLengthErr struct{ msg string }
+
+func (e InvalidPrefixLengthErr) Error() string { return e.msg }
+
+// GenerateHardwareAddr4 generates a hardware address made of prefix
+// followed by the four bytes of the IPv4 address ip. prefix must be two
+// bytes long, e.g. PrivateMACPrefix.
+func GenerateHardwareAddr4(ip net.IP, prefix []byte) (net.HardwareAddr, error) {
+	switch {
+	case ip.To4() == nil:
+		return nil, SupportIp4OnlyErr{msg: "GenerateHardwareAddr4 only supports valid IPv4 address as input"}
+
+	case len(prefix) != hwAddrLen-ipRelevantByteLen:
+		return nil, InvalidPrefixLengthErr{msg: fmt.Sprintf(
+			"Prefix has length %d instead of %d", len(prefix), hwAddrLen-ipRelevantByteLen)}
+	}
+
+	return buildHardwareAddr(prefix, ip.To4()), nil
+}
+
+// GenerateHardwareAddrFromID generates a hardware address made of prefix
+// followed by a hash of id, typically a container ID. prefix may be up
+// to five bytes long.
+func GenerateHardwareAddrFromID(id string, prefix []byte) (net.HardwareAddr, error) {
+	if len(prefix) == 0 || len(prefix) >= hwAddrLen {
+		return nil, InvalidPrefixLengthErr{msg: fmt.Sprintf(
+			"Prefix has length %d, must be between 1 and %d", len(prefix), hwAddrLen-1)}
+	}
+
+	sum := sha256.Sum256([]byte(id))
+	return buildHardwareAddr(prefix, sum[:hwAddrLen-len(prefix)]), nil
+}
+
+// buildHardwareAddr joins prefix and suffix, forcing the address to be
+// locally administered and unicast whatever prefix is given
+func buildHardwareAddr(prefix, suffix []byte) net.HardwareAddr {
+	addr := make(net.HardwareAddr, 0, hwAddrLen)
+	addr = append(addr, prefix...)
+	addr = append(addr, suffix...)
+	addr[0] = (addr[0] | 0x02) &^ 0x01
+	return addr
+}
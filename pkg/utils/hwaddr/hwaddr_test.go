@@ -0,0 +1,92 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hwaddr_test
+
+import (
+	"net"
+
+	"github.com/containernetworking/cni/pkg/utils/hwaddr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Hwaddr", func() {
+	Context("Generate Hardware Address from IPv4", func() {
+		It("generates hardware addresses from the prefix and the address", func() {
+			testCases := []struct {
+				ip          net.IP
+				expectedMAC net.HardwareAddr
+			}{
+				{
+					ip:          net.ParseIP("10.0.0.2"),
+					expectedMAC: (net.HardwareAddr)(append(hwaddr.PrivateMACPrefix, 0x0a, 0x00, 0x00, 0x02)),
+				},
+				{
+					ip:          net.ParseIP("172.17.0.2"),
+					expectedMAC: (net.HardwareAddr)(append(hwaddr.PrivateMACPrefix, 0xac, 0x11, 0x00, 0x02)),
+				},
+				{
+					ip:          net.IPv4(byte(192), byte(168), byte(1), byte(5)),
+					expectedMAC: (net.HardwareAddr)(append(hwaddr.PrivateMACPrefix, 0xc0, 0xa8, 0x01, 0x05)),
+				},
+			}
+
+			for _, tc := range testCases {
+				mac, err := hwaddr.GenerateHardwareAddr4(tc.ip, hwaddr.PrivateMACPrefix)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mac).To(Equal(tc.expectedMAC))
+			}
+		})
+
+		It("returns an error for IPv6 addresses", func() {
+			_, err := hwaddr.GenerateHardwareAddr4(net.ParseIP("2001:db8::1"), hwaddr.PrivateMACPrefix)
+			Expect(err).To(BeAssignableToTypeOf(hwaddr.SupportIp4OnlyErr{}))
+		})
+
+		It("returns an error for a prefix of the wrong length", func() {
+			_, err := hwaddr.GenerateHardwareAddr4(net.ParseIP("10.0.0.2"), []byte{0x0a})
+			Expect(err).To(BeAssignableToTypeOf(hwaddr.InvalidPrefixLengthErr{}))
+		})
+
+		It("makes the address locally administered and unicast", func() {
+			mac, err := hwaddr.GenerateHardwareAddr4(net.ParseIP("10.0.0.2"), []byte{0x01, 0x00})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mac).To(Equal(net.HardwareAddr{0x02, 0x00, 0x0a, 0x00, 0x00, 0x02}))
+		})
+	})
+
+	Context("Generate Hardware Address from an ID", func() {
+		It("is stable for the same ID", func() {
+			mac1, err := hwaddr.GenerateHardwareAddrFromID("some-container", hwaddr.PrivateMACPrefix)
+			Expect(err).NotTo(HaveOccurred())
+			mac2, err := hwaddr.GenerateHardwareAddrFromID("some-container", hwaddr.PrivateMACPrefix)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mac1).To(Equal(mac2))
+			Expect(mac1[:2]).To(Equal(net.HardwareAddr(hwaddr.PrivateMACPrefix)))
+		})
+
+		It("differs between IDs", func() {
+			mac1, _ := hwaddr.GenerateHardwareAddrFromID("container-a", hwaddr.PrivateMACPrefix)
+			mac2, _ := hwaddr.GenerateHardwareAddrFromID("container-b", hwaddr.PrivateMACPrefix)
+			Expect(mac1).NotTo(Equal(mac2))
+		})
+
+		It("returns an error for a prefix of the wrong length", func() {
+			_, err := hwaddr.GenerateHardwareAddrFromID("id", []byte{1, 2, 3, 4, 5, 6})
+			Expect(err).To(BeAssignableToTypeOf(hwaddr.InvalidPrefixLengthErr{}))
+		})
+	})
+})
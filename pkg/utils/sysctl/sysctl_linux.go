@@ -21,12 +21,23 @@ import (
 	"io/ioutil"
 	"path/filepath"
 	"strings"
+
+	"golang.org/x/sys/unix"
 )
 
+const procSys = "/proc/sys"
+
 // Sysctl provides a method to set/get values from /proc/sys - in linux systems
 // new interface to set/get values of variables formerly handled by sysctl syscall
 // If optional `params` have only one string value - this function will
 // set this value into coresponding sysctl variable
+//
+// Names are either dotted, like "net.ipv4.ip_forward", or slashed, like
+// "net/ipv4/conf/eth0.100/forwarding"; only the slashed form can refer
+// to interfaces with a dot in their name.
+//
+// The /proc/sys/net keys belong to the network namespace of the calling
+// thread, so within ns.Do they are those of the namespace entered.
 func Sysctl(name string, params ...string) (string, error) {
 	if len(params) > 1 {
 		return "", fmt.Errorf("unexcepted additional parameters")
@@ -36,23 +47,74 @@ func Sysctl(name string, params ...string) (string, error) {
 	return getSysctl(name)
 }
 
+// Path returns the file below /proc/sys holding the sysctl name
+func Path(name string) (string, error) {
+	if !strings.Contains(name, "/") {
+		name = strings.Replace(name, ".", "/", -1)
+	}
+	fullName := filepath.Join(procSys, name)
+	if !strings.HasPrefix(fullName, procSys+"/") {
+		return "", fmt.Errorf("invalid sysctl name %q", name)
+	}
+	return fullName, nil
+}
+
 func getSysctl(name string) (string, error) {
-	fullName := filepath.Join("/proc/sys", strings.Replace(name, ".", "/", -1))
-	fullName = filepath.Clean(fullName)
+	fullName, err := Path(name)
+	if err != nil {
+		return "", err
+	}
 	data, err := ioutil.ReadFile(fullName)
 	if err != nil {
 		return "", err
 	}
 
-	return string(data[:len(data)-1]), nil
+	return strings.TrimSuffix(string(data), "\n"), nil
 }
 
+// setSysctl writes value, unless the sysctl already has it; /proc/sys may
+// be read-only in containers where the value was set beforehand.
 func setSysctl(name, value string) (string, error) {
-	fullName := filepath.Join("/proc/sys", strings.Replace(name, ".", "/", -1))
-	fullName = filepath.Clean(fullName)
+	if value == "" || strings.ContainsAny(value, "\n\x00") {
+		return "", fmt.Errorf("invalid value %q for sysctl %q", value, name)
+	}
+	fullName, err := Path(name)
+	if err != nil {
+		return "", err
+	}
+
+	if current, err := getSysctl(name); err == nil && sameValue(current, value) {
+		return current, nil
+	}
 	if err := ioutil.WriteFile(fullName, []byte(value), 0644); err != nil {
 		return "", err
 	}
 
 	return getSysctl(name)
 }
+
+// Settable fails unless setting the sysctl name to value would succeed,
+// i.e. unless it exists and either has value already or can be written
+func Settable(name, value string) error {
+	fullName, err := Path(name)
+	if err != nil {
+		return err
+	}
+	current, err := getSysctl(name)
+	if err != nil {
+		return err
+	}
+	if sameValue(current, value) {
+		return nil
+	}
+	if err := unix.Access(fullName, unix.W_OK); err != nil {
+		return fmt.Errorf("%s is %s and cannot be written: %v", fullName, current, err)
+	}
+	return nil
+}
+
+// sameValue compares sysctl values, some of which like tcp_rmem are
+// printed with tabs but may be written with spaces
+func sameValue(a, b string) bool {
+	return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sysctl_test
+
+import (
+	"github.com/containernetworking/cni/pkg/utils/sysctl"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sysctl", func() {
+	Describe("Path", func() {
+		It("converts dotted names", func() {
+			path, err := sysctl.Path("net.ipv4.ip_forward")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal("/proc/sys/net/ipv4/ip_forward"))
+		})
+
+		It("keeps dots in slashed names", func() {
+			path, err := sysctl.Path("net/ipv4/conf/eth0.100/forwarding")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal("/proc/sys/net/ipv4/conf/eth0.100/forwarding"))
+		})
+
+		It("rejects names outside of /proc/sys", func() {
+			_, err := sysctl.Path("net/../../../etc/passwd")
+			Expect(err).To(MatchError(`invalid sysctl name "net/../../../etc/passwd"`))
+		})
+	})
+
+	It("reads values without the trailing newline", func() {
+		value, err := sysctl.Sysctl("kernel.ostype")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value).To(Equal("Linux"))
+	})
+
+	It("does not write a value the sysctl already has", func() {
+		value, err := sysctl.Sysctl("kernel.ostype", "Linux")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value).To(Equal("Linux"))
+	})
+
+	It("rejects invalid values", func() {
+		_, err := sysctl.Sysctl("net.ipv4.ip_forward", "1\n0")
+		Expect(err).To(MatchError(`invalid value "1\n0" for sysctl "net.ipv4.ip_forward"`))
+
+		_, err = sysctl.Sysctl("net.ipv4.ip_forward", "")
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("Settable", func() {
+		It("accepts values the sysctl has or can be written", func() {
+			Expect(sysctl.Settable("kernel.ostype", "Linux")).To(Succeed())
+			Expect(sysctl.Settable("net.ipv4.ip_forward", "1")).To(Succeed())
+		})
+
+		It("rejects other values of read-only sysctls", func() {
+			Expect(sysctl.Settable("kernel.ostype", "BSD")).To(MatchError("/proc/sys/kernel/ostype is Linux and cannot be written: permission denied"))
+		})
+
+		It("rejects sysctls that do not exist", func() {
+			Expect(sysctl.Settable("net.ipv4.conf.nonexistent0.proxy_arp", "1")).NotTo(Succeed())
+		})
+	})
+})
@@ -17,12 +17,20 @@ package utils
 import (
 	"crypto/sha512"
 	"fmt"
+	"strings"
 )
 
 const (
 	maxChainLength = 28
 	chainPrefix    = "CNI-"
 	prefixLength   = len(chainPrefix)
+
+	// minHashLength hex characters (64 bits) of hash are kept in every
+	// chain name to make collisions unlikely
+	minHashLength = 16
+
+	// maxCommentLength is the longest comment iptables accepts
+	maxCommentLength = 256
 )
 
 // Generates a chain name to be used with iptables.
@@ -39,3 +47,60 @@ func FormatChainName(name string, id string) string {
 func FormatComment(name string, id string) string {
 	return fmt.Sprintf("name: %q id: %q", name, id)
 }
+
+// FormatChainNameWithPrefix generates a chain name for the attachment of
+// a container to a network through ifName, so that each attachment gets
+// its own chain. The chain name starts with prefix and is exactly
+// maxChainLength chars in length. The prefix must leave room for at
+// least minHashLength chars of hash.
+func FormatChainNameWithPrefix(prefix, name, id, ifName string) (string, error) {
+	if len(prefix) > maxChainLength-minHashLength {
+		return "", fmt.Errorf("chain prefix %q is longer than %d characters", prefix, maxChainLength-minHashLength)
+	}
+
+	// separate the fields so that ("ab", "c") and ("a", "bc") differ
+	chainBytes := sha512.Sum512([]byte(name + "\x00" + id + "\x00" + ifName))
+	chain := fmt.Sprintf("%s%x", prefix, chainBytes)
+	return chain[:maxChainLength], nil
+}
+
+// FormatCommentWithIfName returns a comment identifying the rules of
+// the attachment of a container to a network through ifName. It is
+// truncated to the maximum length of an iptables comment.
+func FormatCommentWithIfName(name, id, ifName string) string {
+	comment := fmt.Sprintf("name: %q id: %q ifname: %q", name, id, ifName)
+	if len(comment) > maxCommentLength {
+		comment = comment[:maxCommentLength]
+	}
+	return comment
+}
+
+// Attachment identifies the attachment of a container to a network
+// through an interface, as listed in "cni.dev/valid-attachments" on GC
+type Attachment struct {
+	ContainerID string `json:"containerID"`
+	IfName      string `json:"ifname"`
+}
+
+// OrphanedChains returns a func, for ip.SweepIPMasq, reporting whether
+// a chain belongs to the network name, by the comment it was installed
+// with, but to none of the valid attachments. Both the chains named by
+// FormatChainNameWithPrefix with prefix and those named by
+// FormatChainName for a whole container are recognized.
+func OrphanedChains(prefix, name string, valid []Attachment) (func(chain, comment string) bool, error) {
+	keep := map[string]bool{}
+	for _, a := range valid {
+		chain, err := FormatChainNameWithPrefix(prefix, name, a.ContainerID, a.IfName)
+		if err != nil {
+			return nil, err
+		}
+		keep[chain] = true
+		keep[FormatChainName(name, a.ContainerID)] = true
+	}
+
+	// the comments of FormatComment and FormatCommentWithIfName alike
+	owned := fmt.Sprintf("name: %q id: ", name)
+	return func(chain, comment string) bool {
+		return strings.HasPrefix(comment, owned) && !keep[chain]
+	}, nil
+}
@@ -48,4 +48,78 @@ var _ = Describe("Utils", func() {
 		Expect(chain1).To(Equal("CNI-374f33fe84ab0ed84dcdebe3"))
 		Expect(chain1).NotTo(Equal(chain2))
 	})
+
+	Describe("FormatChainNameWithPrefix", func() {
+		It("must format a name of the maximum length with the prefix", func() {
+			chain, err := FormatChainNameWithPrefix("CNI-DN-", "test", "1234", "eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(chain)).To(Equal(maxChainLength))
+			Expect(chain).To(HavePrefix("CNI-DN-"))
+		})
+
+		It("must be predictable", func() {
+			chain1, err := FormatChainNameWithPrefix("CNI-", "test", "1234", "eth0")
+			Expect(err).NotTo(HaveOccurred())
+			chain2, err := FormatChainNameWithPrefix("CNI-", "test", "1234", "eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(chain1).To(Equal(chain2))
+		})
+
+		It("must differ between interfaces of a container", func() {
+			chain1, _ := FormatChainNameWithPrefix("CNI-", "test", "1234", "eth0")
+			chain2, _ := FormatChainNameWithPrefix("CNI-", "test", "1234", "eth1")
+			Expect(chain1).NotTo(Equal(chain2))
+		})
+
+		It("must not collide when characters move between fields", func() {
+			chain1, _ := FormatChainNameWithPrefix("CNI-", "test1", "234", "eth0")
+			chain2, _ := FormatChainNameWithPrefix("CNI-", "test", "1234", "eth0")
+			Expect(chain1).NotTo(Equal(chain2))
+		})
+
+		It("must reject a prefix leaving too little room for the hash", func() {
+			_, err := FormatChainNameWithPrefix("CNI-A-VERY-LONG-", "test", "1234", "eth0")
+			Expect(err).To(MatchError(`chain prefix "CNI-A-VERY-LONG-" is longer than 12 characters`))
+		})
+	})
+
+	Describe("FormatCommentWithIfName", func() {
+		It("must include the network, container and interface", func() {
+			Expect(FormatCommentWithIfName("test", "1234", "eth0")).To(Equal(`name: "test" id: "1234" ifname: "eth0"`))
+		})
+
+		It("must truncate long comments", func() {
+			id := string(make([]byte, 300))
+			Expect(len(FormatCommentWithIfName("test", id, "eth0"))).To(Equal(maxCommentLength))
+		})
+	})
+
+	Describe("OrphanedChains", func() {
+		It("must report the chains of the network that no valid attachment has", func() {
+			orphaned, err := OrphanedChains("CNI-", "test", []Attachment{{ContainerID: "1234", IfName: "eth0"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			valid, _ := FormatChainNameWithPrefix("CNI-", "test", "1234", "eth0")
+			Expect(orphaned(valid, FormatCommentWithIfName("test", "1234", "eth0"))).To(BeFalse())
+			Expect(orphaned(FormatChainName("test", "1234"), FormatComment("test", "1234"))).To(BeFalse())
+
+			stale, _ := FormatChainNameWithPrefix("CNI-", "test", "1234", "eth1")
+			Expect(orphaned(stale, FormatCommentWithIfName("test", "1234", "eth1"))).To(BeTrue())
+			Expect(orphaned(FormatChainName("test", "5678"), FormatComment("test", "5678"))).To(BeTrue())
+		})
+
+		It("must leave the chains of other networks alone", func() {
+			orphaned, err := OrphanedChains("CNI-", "test", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			chain, _ := FormatChainNameWithPrefix("CNI-", "test2", "1234", "eth0")
+			Expect(orphaned(chain, FormatCommentWithIfName("test2", "1234", "eth0"))).To(BeFalse())
+			Expect(orphaned("CNI-DN-1234", "")).To(BeFalse())
+		})
+
+		It("must reject a prefix leaving too little room for the hash", func() {
+			_, err := OrphanedChains("CNI-TOO-LONG-PREFIX-", "test", []Attachment{{ContainerID: "1234", IfName: "eth0"}})
+			Expect(err).To(HaveOccurred())
+		})
+	})
 })
@@ -0,0 +1,88 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package version
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/containernetworking/cni/pkg/types/current"
+)
+
+// ResultDecoder converts a result printed in the version it is registered
+// for to a *current.Result
+type ResultDecoder func(jsonBytes []byte) (*current.Result, error)
+
+var resultDecoders = struct {
+	sync.RWMutex
+	byVersion map[string]ResultDecoder
+}{byVersion: map[string]ResultDecoder{}}
+
+// RegisterResultDecoder makes ReconcileResult, and thus every runtime
+// decoding plugin output through this library, accept results whose
+// cniVersion is version by converting them with decoder. It lets projects
+// whose plugins print an extended result schema use the library without
+// patching it. The versions the library implements itself cannot be
+// replaced, and a version can only be registered once.
+func RegisterResultDecoder(version string, decoder ResultDecoder) error {
+	if decoder == nil {
+		return fmt.Errorf("no decoder given for result version %q", version)
+	}
+	if version == "" {
+		return fmt.Errorf("cannot register a decoder for legacy results without a cniVersion")
+	}
+	for _, v := range current.SupportedVersions {
+		if v == version {
+			return fmt.Errorf("result version %q is implemented by the library", version)
+		}
+	}
+
+	resultDecoders.Lock()
+	defer resultDecoders.Unlock()
+	if _, ok := resultDecoders.byVersion[version]; ok {
+		return fmt.Errorf("a decoder for result version %q is already registered", version)
+	}
+	resultDecoders.byVersion[version] = decoder
+	return nil
+}
+
+// decodeResult decodes a result with the decoder registered for its
+// cniVersion, if there is one, and as one of the versions the library
+// implements otherwise
+func decodeResult(jsonBytes []byte) (*current.Result, error) {
+	var v struct {
+		CNIVersion string `json:"cniVersion"`
+	}
+	if err := json.Unmarshal(jsonBytes, &v); err != nil {
+		return nil, err
+	}
+
+	resultDecoders.RLock()
+	decoder := resultDecoders.byVersion[v.CNIVersion]
+	resultDecoders.RUnlock()
+	if decoder == nil {
+		return current.NewResult(jsonBytes)
+	}
+
+	res, err := decoder(jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil {
+		return nil, fmt.Errorf("decoder of result version %q returned no result", v.CNIVersion)
+	}
+	return res, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package version_test
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/cni/pkg/version"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registering result decoders", func() {
+	// decodeAcme decodes a result extending 0.3.0 with a field of its own
+	decodeAcme := func(jsonBytes []byte) (*current.Result, error) {
+		var r struct {
+			current.Result
+			Acme struct {
+				Zone string `json:"zone"`
+			} `json:"acme"`
+		}
+		if err := json.Unmarshal(jsonBytes, &r); err != nil {
+			return nil, err
+		}
+		if r.Acme.Zone == "" {
+			return nil, errors.New("missing acme zone")
+		}
+		r.Result.CNIVersion = current.ImplementedSpecVersion
+		return &r.Result, nil
+	}
+
+	It("decodes results of the registered version with their decoder", func() {
+		Expect(version.RegisterResultDecoder("0.3.0-acme", decodeAcme)).To(Succeed())
+
+		result := []byte(`{
+			"cniVersion": "0.3.0-acme",
+			"ips": [{"version": "4", "address": "10.1.2.3/24"}],
+			"acme": {"zone": "a"}
+		}`)
+		res, warnings, err := version.ReconcileResult("0.3.0", result)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warnings).To(BeEmpty())
+		Expect(res.(*current.Result).IPs).To(HaveLen(1))
+
+		res, _, err = version.ReconcileResult("0.2.0", result)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.(*types.Result).IP4.IP.String()).To(Equal("10.1.2.3/24"))
+
+		_, _, err = version.ReconcileResult("0.3.0", []byte(`{"cniVersion": "0.3.0-acme"}`))
+		Expect(err).To(MatchError("failed to decode plugin result: missing acme zone"))
+	})
+
+	It("validates what the decoder returns", func() {
+		Expect(version.RegisterResultDecoder("0.3.0-invalid", func([]byte) (*current.Result, error) {
+			return &current.Result{IPs: []*current.IPConfig{{Version: "7"}}}, nil
+		})).To(Succeed())
+
+		_, _, err := version.ReconcileResult("0.3.0", []byte(`{"cniVersion": "0.3.0-invalid"}`))
+		_, ok := err.(*version.InvalidResultError)
+		Expect(ok).To(BeTrue())
+	})
+
+	It("refuses to replace a decoder", func() {
+		Expect(version.RegisterResultDecoder("0.3.0-twice", decodeAcme)).To(Succeed())
+		Expect(version.RegisterResultDecoder("0.3.0-twice", decodeAcme)).To(MatchError(`a decoder for result version "0.3.0-twice" is already registered`))
+		Expect(version.RegisterResultDecoder("0.3.0", decodeAcme)).To(MatchError(`result version "0.3.0" is implemented by the library`))
+		Expect(version.RegisterResultDecoder("", decodeAcme)).To(HaveOccurred())
+		Expect(version.RegisterResultDecoder("0.3.0-nil", nil)).To(MatchError(`no decoder given for result version "0.3.0-nil"`))
+	})
+})
@@ -0,0 +1,78 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package version_test
+
+import (
+	"bytes"
+
+	"github.com/containernetworking/cni/pkg/version"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Decoding versions reported by a plugin", func() {
+	var decoder *version.PluginDecoder
+
+	BeforeEach(func() {
+		decoder = &version.PluginDecoder{}
+	})
+
+	It("returns a PluginInfo that represents the given json bytes", func() {
+		pluginInfo, err := decoder.Decode([]byte(`{
+			"cniVersion": "some-library-version",
+			"supportedVersions": [ "some-version", "some-other-version" ]
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pluginInfo.SupportedVersions()).To(ConsistOf("some-version", "some-other-version"))
+		Expect(version.Supports(pluginInfo, "some-version")).To(BeTrue())
+		Expect(version.Supports(pluginInfo, "unknown")).To(BeFalse())
+	})
+
+	It("round-trips the output of Encode", func() {
+		var buf bytes.Buffer
+		Expect(version.All.Encode(&buf)).To(Succeed())
+
+		pluginInfo, err := decoder.Decode(buf.Bytes())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pluginInfo.SupportedVersions()).To(Equal(version.All.SupportedVersions()))
+	})
+
+	Context("when the bytes cannot be decoded as json", func() {
+		It("returns a meaningful error", func() {
+			_, err := decoder.Decode([]byte(`{{{`))
+			Expect(err).To(MatchError(ContainSubstring("decoding version info: ")))
+		})
+	})
+
+	Context("when the json bytes are missing the required CNIVersion field", func() {
+		It("returns a meaningful error", func() {
+			_, err := decoder.Decode([]byte(`{ "supportedVersions": [ "foo" ] }`))
+			Expect(err).To(MatchError("decoding version info: missing field cniVersion"))
+		})
+	})
+
+	Context("when there are no supported versions", func() {
+		It("assumes 0.2.0 plugins support 0.1.0 and 0.2.0", func() {
+			pluginInfo, err := decoder.Decode([]byte(`{ "cniVersion": "0.2.0" }`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pluginInfo.SupportedVersions()).To(ConsistOf("0.1.0", "0.2.0"))
+		})
+
+		It("returns a meaningful error otherwise", func() {
+			_, err := decoder.Decode([]byte(`{ "cniVersion": "0.3.0" }`))
+			Expect(err).To(MatchError("decoding version info: missing field supportedVersions"))
+		})
+	})
+})
@@ -0,0 +1,97 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package version
+
+import (
+	"fmt"
+
+	"github.com/containernetworking/cni/pkg/types/current"
+)
+
+// InvalidResultError is returned by ReconcileResult for a result that
+// decodes but is not well-formed, e.g. one whose IP configurations
+// reference interfaces it does not have
+type InvalidResultError struct {
+	Err error
+}
+
+func (e *InvalidResultError) Error() string {
+	return fmt.Sprintf("invalid plugin result: %v", e.Err)
+}
+
+func (e *InvalidResultError) Unwrap() error {
+	return e.Err
+}
+
+// ReconcileResult converts the result printed by a plugin, in any result
+// version the library understands, to the version the runtime asked for:
+// a *types.Result for the legacy versions (and for an empty version, which
+// legacy configurations use), a *current.Result otherwise. Results of a
+// version registered with RegisterResultDecoder go through their decoder
+// first. Whatever the requested version cannot express is dropped, and
+// described by one warning each, rather than failing the whole operation.
+// A result that is not well-formed fails with an *InvalidResultError.
+func ReconcileResult(requested string, result []byte) (interface{}, []string, error) {
+	res, err := decodeResult(result)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode plugin result: %v", err)
+	}
+	if err := res.Validate(); err != nil {
+		return nil, nil, &InvalidResultError{Err: err}
+	}
+
+	switch requested {
+	case "", "0.1.0", "0.2.0":
+		return res.Legacy(), legacyLosses(res, requested), nil
+	}
+
+	converted, err := res.GetAsVersion(requested)
+	if err != nil {
+		return nil, nil, err
+	}
+	return converted, nil, nil
+}
+
+// legacyLosses describes what Legacy drops from res
+func legacyLosses(res *current.Result, requested string) []string {
+	if requested == "" {
+		requested = "legacy"
+	}
+
+	var warnings []string
+	for _, iface := range res.Interfaces {
+		warnings = append(warnings, fmt.Sprintf("dropped interface %q: %s results cannot describe interfaces", iface.Name, requested))
+	}
+
+	seen := map[string]bool{}
+	for _, ip := range res.IPs {
+		if seen[ip.Version] {
+			warnings = append(warnings, fmt.Sprintf("dropped IP address %s: %s results hold one address per family", ip.Address.String(), requested))
+		}
+		seen[ip.Version] = true
+	}
+
+	for _, route := range res.Routes {
+		family := "6"
+		if route.Dst.IP.To4() != nil {
+			family = "4"
+		}
+		if !seen[family] {
+			warnings = append(warnings, fmt.Sprintf("dropped route to %s: %s results only hold routes of an address family with an address", route.Dst.String(), requested))
+		}
+	}
+
+	return warnings
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package version_test
+
+import (
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/cni/pkg/version"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reconciling plugin results", func() {
+	const currentResult = `{
+		"cniVersion": "0.3.0",
+		"interfaces": [{"name": "eth0", "mac": "00:11:22:33:44:55"}],
+		"ips": [
+			{"version": "4", "interface": 0, "address": "10.1.2.3/24", "gateway": "10.1.2.1"},
+			{"version": "4", "interface": 0, "address": "10.1.3.3/24"},
+			{"version": "6", "interface": 0, "address": "fd00::3/64"}
+		],
+		"routes": [{"dst": "0.0.0.0/0"}, {"dst": "::/0"}]
+	}`
+
+	It("converts a newer result down to a legacy one, describing what was dropped", func() {
+		res, warnings, err := version.ReconcileResult("0.2.0", []byte(currentResult))
+		Expect(err).NotTo(HaveOccurred())
+
+		legacy, ok := res.(*types.Result)
+		Expect(ok).To(BeTrue())
+		Expect(legacy.IP4.IP.String()).To(Equal("10.1.2.3/24"))
+		Expect(legacy.IP4.Gateway.String()).To(Equal("10.1.2.1"))
+		Expect(legacy.IP4.Routes).To(HaveLen(1))
+		Expect(legacy.IP6.IP.String()).To(Equal("fd00::3/64"))
+
+		Expect(warnings).To(ConsistOf(
+			`dropped interface "eth0": 0.2.0 results cannot describe interfaces`,
+			`dropped IP address 10.1.3.3/24: 0.2.0 results hold one address per family`,
+		))
+	})
+
+	It("reports routes of a family without an address", func() {
+		_, warnings, err := version.ReconcileResult("", []byte(`{
+			"cniVersion": "0.3.0",
+			"ips": [{"version": "4", "address": "10.1.2.3/24"}],
+			"routes": [{"dst": "fd00:9::/48"}]
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warnings).To(ConsistOf(
+			`dropped route to fd00:9::/48: legacy results only hold routes of an address family with an address`,
+		))
+	})
+
+	It("converts a legacy result up without loss", func() {
+		res, warnings, err := version.ReconcileResult("0.3.0", []byte(`{
+			"ip4": {"ip": "10.1.2.3/24", "routes": [{"dst": "0.0.0.0/0"}]}
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warnings).To(BeEmpty())
+
+		result, ok := res.(*current.Result)
+		Expect(ok).To(BeTrue())
+		Expect(result.CNIVersion).To(Equal("0.3.0"))
+		Expect(result.IPs).To(HaveLen(1))
+		Expect(result.Routes).To(HaveLen(1))
+	})
+
+	It("rejects a requested version it does not know", func() {
+		_, _, err := version.ReconcileResult("9.9.9", []byte(currentResult))
+		code, ok := types.ErrorCode(err)
+		Expect(ok).To(BeTrue())
+		Expect(code).To(Equal(types.ErrIncompatibleCNIVersion))
+	})
+
+	It("rejects a result it cannot decode", func() {
+		_, _, err := version.ReconcileResult("0.2.0", []byte(`{"cniVersion": "9.9.9"}`))
+		Expect(err).To(MatchError(`failed to decode plugin result: unsupported CNI result version "9.9.9"`))
+	})
+
+	It("rejects a result that is not well-formed", func() {
+		_, _, err := version.ReconcileResult("0.3.0", []byte(`{
+			"cniVersion": "0.3.0",
+			"ips": [{"version": "6", "address": "10.1.2.3/24"}]
+		}`))
+		Expect(err).To(MatchError("invalid plugin result: ips[0]: address 10.1.2.3 is not IPv6"))
+		_, ok := err.(*version.InvalidResultError)
+		Expect(ok).To(BeTrue())
+	})
+})
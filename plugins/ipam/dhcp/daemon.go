@@ -17,47 +17,98 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
 	"net/rpc"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/containernetworking/cni/pkg/logging"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
 	"github.com/coreos/go-systemd/activation"
 )
 
 const listenFdsStart = 3
 const resendCount = 3
 
+// maxVLAN is the highest valid 802.1Q VLAN ID
+const maxVLAN = 4094
+
 var errNoMoreTries = errors.New("no more tries")
 
 type DHCP struct {
 	mux    sync.Mutex
-	leases map[string]*DHCPLease
+	leases map[leaseKey]*DHCPLease
+	// hooks, if set, are notified of the events of the leases
+	hooks *leaseHooks
+}
+
+// leaseKey identifies the attachment a lease is maintained for; a
+// container may have leases on several networks and interfaces
+type leaseKey struct {
+	Network     string
+	ContainerID string
+	IfName      string
+}
+
+func (k leaseKey) String() string {
+	return k.ContainerID + "/" + k.Network + "/" + k.IfName
+}
+
+// LeaseListArgs selects the leases returned by List
+type LeaseListArgs struct {
+	// Network, if set, restricts the list to the leases of that network
+	Network string
+}
+
+// LeaseInfo describes a lease maintained by the daemon
+type LeaseInfo struct {
+	Network     string    `json:"network"`
+	ContainerID string    `json:"containerID"`
+	IfName      string    `json:"ifName"`
+	IP          string    `json:"ip,omitempty"`
+	Expires     time.Time `json:"expires"`
 }
 
 func newDHCP() *DHCP {
 	return &DHCP{
-		leases: make(map[string]*DHCPLease),
+		leases: make(map[leaseKey]*DHCPLease),
 	}
 }
 
 // Allocate acquires an IP from a DHCP server for a specified container.
 // The acquired lease will be maintained until Release() is called.
-func (d *DHCP) Allocate(args *skel.CmdArgs, result *types.Result) error {
-	conf := types.NetConf{}
+func (d *DHCP) Allocate(args *skel.CmdArgs, result *current.Result) error {
+	conf := netConf{}
 	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
 		return fmt.Errorf("error parsing netconf: %v", err)
 	}
+	if conf.IPAM.VLAN < 0 || conf.IPAM.VLAN > maxVLAN {
+		return fmt.Errorf("invalid VLAN ID %d", conf.IPAM.VLAN)
+	}
+	ifName := conf.IPAM.Interface
+	if ifName == "" {
+		ifName = args.IfName
+	}
+
+	// a repeated ADD starts over; the old lease has the same client ID,
+	// so it must be released before the server is asked again
+	key := leaseKey{Network: conf.Name, ContainerID: args.ContainerID, IfName: args.IfName}
+	if old := d.takeLease(key); old != nil {
+		old.Stop()
+	}
 
-	clientID := args.ContainerID + "/" + conf.Name
-	l, err := AcquireLease(clientID, args.Netns, args.IfName)
+	l, err := AcquireLease(key.String(), args.Netns, ifName, conf.IPAM.VLAN, d.leaseEvents(key))
 	if err != nil {
 		return err
 	}
@@ -68,12 +119,20 @@ func (d *DHCP) Allocate(args *skel.CmdArgs, result *types.Result) error {
 		return err
 	}
 
-	d.setLease(args.ContainerID, conf.Name, l)
+	d.setLease(key, l)
 
-	result.IP4 = &types.IPConfig{
-		IP:      *ipn,
+	result.CNIVersion = current.ImplementedSpecVersion
+	result.IPs = []*current.IPConfig{{
+		Version: "4",
+		Address: *ipn,
 		Gateway: l.Gateway(),
-		Routes:  l.Routes(),
+	}}
+	for _, route := range l.Routes() {
+		route := route
+		result.Routes = append(result.Routes, &route)
+	}
+	if name := l.link.Attrs().Name; name != args.IfName {
+		result.AddSandboxInterface(name, l.link.Attrs().HardwareAddr.String(), args.Netns)
 	}
 
 	return nil
@@ -87,32 +146,125 @@ func (d *DHCP) Release(args *skel.CmdArgs, reply *struct{}) error {
 		return fmt.Errorf("error parsing netconf: %v", err)
 	}
 
-	if l := d.getLease(args.ContainerID, conf.Name); l != nil {
+	key := leaseKey{Network: conf.Name, ContainerID: args.ContainerID, IfName: args.IfName}
+	if l := d.takeLease(key); l != nil {
 		l.Stop()
 		return nil
 	}
 
-	return fmt.Errorf("lease not found: %v/%v", args.ContainerID, conf.Name)
+	logging.Warnf("release of unknown lease %v", key)
+	return fmt.Errorf("lease not found: %v", key)
 }
 
-func (d *DHCP) getLease(contID, netName string) *DHCPLease {
+// List returns the leases the daemon maintains, ordered by network,
+// container ID and interface name
+func (d *DHCP) List(args *LeaseListArgs, reply *[]LeaseInfo) error {
 	d.mux.Lock()
 	defer d.mux.Unlock()
 
-	// TODO(eyakubovich): hash it to avoid collisions
-	l, ok := d.leases[contID+netName]
-	if !ok {
+	leases := []LeaseInfo{}
+	for key, l := range d.leases {
+		if args.Network != "" && key.Network != args.Network {
+			continue
+		}
+		leases = append(leases, leaseInfo(key, l))
+	}
+
+	sort.Slice(leases, func(i, j int) bool {
+		a, b := leases[i], leases[j]
+		if a.Network != b.Network {
+			return a.Network < b.Network
+		}
+		if a.ContainerID != b.ContainerID {
+			return a.ContainerID < b.ContainerID
+		}
+		return a.IfName < b.IfName
+	})
+	*reply = leases
+	return nil
+}
+
+// Lookup returns the lease maintained for the attachment of args, or a
+// zero LeaseInfo if there is none
+func (d *DHCP) Lookup(args *skel.CmdArgs, reply *LeaseInfo) error {
+	conf := types.NetConf{}
+	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
+		return fmt.Errorf("error parsing netconf: %v", err)
+	}
+
+	key := leaseKey{Network: conf.Name, ContainerID: args.ContainerID, IfName: args.IfName}
+	d.mux.Lock()
+	l := d.leases[key]
+	d.mux.Unlock()
+
+	*reply = LeaseInfo{}
+	if l != nil {
+		*reply = leaseInfo(key, l)
+	}
+	return nil
+}
+
+func leaseInfo(key leaseKey, l *DHCPLease) LeaseInfo {
+	info := LeaseInfo{Network: key.Network, ContainerID: key.ContainerID, IfName: key.IfName}
+	if ip, expires := l.status(); ip != nil {
+		info.IP, info.Expires = ip.String(), expires
+	}
+	return info
+}
+
+// leaseEvents returns the function the lease of key reports its events
+// to the hooks with, or nil if there are none
+func (d *DHCP) leaseEvents(key leaseKey) func(string, *DHCPLease) {
+	if d.hooks == nil {
 		return nil
 	}
+	return func(event string, l *DHCPLease) {
+		info := leaseInfo(key, l)
+		d.hooks.notify(LeaseEvent{
+			Event:       event,
+			Network:     info.Network,
+			ContainerID: info.ContainerID,
+			IfName:      info.IfName,
+			IP:          info.IP,
+			Expires:     info.Expires,
+		})
+	}
+}
+
+// takeLease returns the lease of key, if any, and forgets it
+func (d *DHCP) takeLease(key leaseKey) *DHCPLease {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	l := d.leases[key]
+	delete(d.leases, key)
 	return l
 }
 
-func (d *DHCP) setLease(contID, netName string, l *DHCPLease) {
+func (d *DHCP) setLease(key leaseKey, l *DHCPLease) {
 	d.mux.Lock()
 	defer d.mux.Unlock()
 
-	// TODO(eyakubovich): hash it to avoid collisions
-	d.leases[contID+netName] = l
+	d.leases[key] = l
+}
+
+// releaseAll stops maintaining every lease and releases them
+func (d *DHCP) releaseAll() {
+	d.mux.Lock()
+	leases := d.leases
+	d.leases = make(map[leaseKey]*DHCPLease)
+	d.mux.Unlock()
+
+	var wg sync.WaitGroup
+	for _, l := range leases {
+		wg.Add(1)
+		go func(l *DHCPLease) {
+			defer wg.Done()
+			l.Stop()
+		}(l)
+	}
+	wg.Wait()
+	logging.Infof("released %d leases", len(leases))
 }
 
 func getListener() (net.Listener, error) {
@@ -139,19 +291,74 @@ func getListener() (net.Listener, error) {
 	}
 }
 
-func runDaemon() {
+// daemonCmd implements "dhcp daemon": it serves the RPC interface until
+// it is sent SIGINT or SIGTERM
+type daemonCmd struct {
+	releaseOnShutdown bool
+	hook              string
+	hookSocket        string
+	hookTimeout       time.Duration
+}
+
+func (*daemonCmd) Usage() string {
+	return "serve the leases of the plugin invocations"
+}
+
+func (c *daemonCmd) SetFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&c.releaseOnShutdown, "release-on-shutdown", false, "release every lease when shutting down")
+	flags.StringVar(&c.hook, "hook", "", "command to run on every lease event")
+	flags.StringVar(&c.hookSocket, "hook-socket", "", "unix socket to send every lease event to")
+	flags.DurationVar(&c.hookTimeout, "hook-timeout", 5*time.Second, "how long a hook may take")
+}
+
+func (c *daemonCmd) Run(_ *skel.SubcommandContext) error {
 	// since other goroutines (on separate threads) will change namespaces,
 	// ensure the RPC server does not get scheduled onto those
 	runtime.LockOSThread()
 
+	// unlike a plugin invocation, the daemon reports lease activity by
+	// default; CNI_LOG_* still override this
+	closeLog, err := logging.Setup(logging.Config{Level: "info"}, "plugin", "dhcp")
+	if err != nil {
+		return fmt.Errorf("error setting up logging: %v", err)
+	}
+	defer closeLog()
+
 	l, err := getListener()
 	if err != nil {
-		log.Printf("Error getting listener: %v", err)
-		return
+		return fmt.Errorf("error getting listener: %v", err)
 	}
 
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
 	dhcp := newDHCP()
+	if c.hook != "" || c.hookSocket != "" {
+		if c.hookTimeout <= 0 {
+			return fmt.Errorf("invalid hook timeout %v", c.hookTimeout)
+		}
+		dhcp.hooks = newLeaseHooks(c.hook, c.hookSocket, c.hookTimeout)
+	}
 	rpc.Register(dhcp)
 	rpc.HandleHTTP()
-	http.Serve(l, nil)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- http.Serve(l, nil)
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("error serving: %v", err)
+	case sig := <-sigs:
+		logging.Infof("received %v, shutting down", sig)
+	}
+
+	l.Close()
+	if c.releaseOnShutdown {
+		dhcp.releaseAll()
+	}
+	if dhcp.hooks != nil {
+		dhcp.hooks.close()
+	}
+	return nil
 }
@@ -0,0 +1,132 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+func TestLeasesKeyedByAttachment(t *testing.T) {
+	d := newDHCP()
+	// these used to share a key
+	keys := []leaseKey{
+		{Network: "c", ContainerID: "ab", IfName: "eth0"},
+		{Network: "bc", ContainerID: "a", IfName: "eth0"},
+		{Network: "bc", ContainerID: "a", IfName: "eth1"},
+	}
+	for _, key := range keys {
+		d.setLease(key, &DHCPLease{clientID: key.String()})
+	}
+
+	for _, key := range keys {
+		l := d.takeLease(key)
+		if l == nil || l.clientID != key.String() {
+			t.Fatalf("expected the lease of %v, got %v", key, l)
+		}
+		if d.takeLease(key) != nil {
+			t.Fatalf("lease of %v still recorded after taking it", key)
+		}
+	}
+}
+
+func TestListLeases(t *testing.T) {
+	d := newDHCP()
+	for _, key := range []leaseKey{
+		{Network: "net2", ContainerID: "b", IfName: "eth0"},
+		{Network: "net1", ContainerID: "b", IfName: "eth0"},
+		{Network: "net1", ContainerID: "a", IfName: "eth1"},
+		{Network: "net1", ContainerID: "a", IfName: "eth0"},
+	} {
+		d.setLease(key, &DHCPLease{clientID: key.String()})
+	}
+
+	var all []LeaseInfo
+	if err := d.List(&LeaseListArgs{}, &all); err != nil {
+		t.Fatal(err)
+	}
+	expected := []LeaseInfo{
+		{Network: "net1", ContainerID: "a", IfName: "eth0"},
+		{Network: "net1", ContainerID: "a", IfName: "eth1"},
+		{Network: "net1", ContainerID: "b", IfName: "eth0"},
+		{Network: "net2", ContainerID: "b", IfName: "eth0"},
+	}
+	if !reflect.DeepEqual(all, expected) {
+		t.Errorf("expected %v, got %v", expected, all)
+	}
+
+	var net2 []LeaseInfo
+	if err := d.List(&LeaseListArgs{Network: "net2"}, &net2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(net2, expected[3:]) {
+		t.Errorf("expected %v, got %v", expected[3:], net2)
+	}
+}
+
+func TestReleaseAll(t *testing.T) {
+	d := newDHCP()
+	var leases []*DHCPLease
+	for _, key := range []leaseKey{
+		{Network: "net1", ContainerID: "a", IfName: "eth0"},
+		{Network: "net2", ContainerID: "b", IfName: "eth0"},
+	} {
+		l := &DHCPLease{clientID: key.String(), stop: make(chan struct{})}
+		d.setLease(key, l)
+		leases = append(leases, l)
+	}
+
+	d.releaseAll()
+
+	for _, l := range leases {
+		select {
+		case <-l.stop:
+		default:
+			t.Errorf("lease %v was not stopped", l.clientID)
+		}
+	}
+	var all []LeaseInfo
+	if err := d.List(&LeaseListArgs{}, &all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 0 {
+		t.Errorf("expected no leases after releasing them, got %v", all)
+	}
+}
+
+func TestLookupLease(t *testing.T) {
+	d := newDHCP()
+	key := leaseKey{Network: "net1", ContainerID: "a", IfName: "eth0"}
+	d.setLease(key, &DHCPLease{clientID: key.String()})
+
+	var info LeaseInfo
+	args := &skel.CmdArgs{ContainerID: "a", IfName: "eth0", StdinData: []byte(`{"name":"net1"}`)}
+	if err := d.Lookup(args, &info); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (LeaseInfo{Network: "net1", ContainerID: "a", IfName: "eth0"}); info != expected {
+		t.Errorf("expected %v, got %v", expected, info)
+	}
+
+	args.IfName = "eth1"
+	if err := d.Lookup(args, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info != (LeaseInfo{}) {
+		t.Errorf("expected no lease for eth1, got %v", info)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/logging"
+)
+
+// The events of a lease reported to the hooks
+const (
+	leaseEventAcquire = "acquire"
+	leaseEventRenew   = "renew"
+	leaseEventRebind  = "rebind"
+	leaseEventExpire  = "expire"
+	leaseEventRelease = "release"
+)
+
+// hookQueueLen is how many events may wait for the hooks before new ones
+// are dropped
+const hookQueueLen = 256
+
+// LeaseEvent describes what happened to a lease, as passed to the hooks
+type LeaseEvent struct {
+	Event       string    `json:"event"`
+	Network     string    `json:"network"`
+	ContainerID string    `json:"containerID"`
+	IfName      string    `json:"ifName"`
+	IP          string    `json:"ip,omitempty"`
+	Expires     time.Time `json:"expires"`
+}
+
+// leaseHooks runs a command and notifies a unix socket of the events of
+// the leases, in the order they happen. The hooks run on a goroutine of
+// their own, outside the network namespaces the leases are maintained
+// in, and a slow hook delays the events after it but not the leases.
+type leaseHooks struct {
+	// command is run with the event in DHCP_LEASE_* variables and as
+	// JSON on stdin
+	command string
+	// socket is a unix stream socket sent each event as a line of JSON
+	socket  string
+	timeout time.Duration
+
+	// mu guards events against notify after close
+	mu     sync.Mutex
+	closed bool
+	events chan LeaseEvent
+	done   chan struct{}
+}
+
+func newLeaseHooks(command, socket string, timeout time.Duration) *leaseHooks {
+	h := &leaseHooks{
+		command: command,
+		socket:  socket,
+		timeout: timeout,
+		events:  make(chan LeaseEvent, hookQueueLen),
+		done:    make(chan struct{}),
+	}
+	go h.run()
+	return h
+}
+
+// notify queues ev for the hooks, dropping it if too many are waiting
+func (h *leaseHooks) notify(ev LeaseEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+	select {
+	case h.events <- ev:
+	default:
+		logging.Warnf("dropped %s event of lease %s/%s/%s: too many events waiting for the hooks", ev.Event, ev.ContainerID, ev.Network, ev.IfName)
+	}
+}
+
+// close waits for the hooks of the queued events to be run, and drops
+// the events after
+func (h *leaseHooks) close() {
+	h.mu.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.events)
+	}
+	h.mu.Unlock()
+	<-h.done
+}
+
+func (h *leaseHooks) run() {
+	defer close(h.done)
+	for ev := range h.events {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			logging.Errorf("failed to encode lease event: %v", err)
+			continue
+		}
+		if h.command != "" {
+			if err := h.exec(ev, data); err != nil {
+				logging.Warnf("%v", err)
+			}
+		}
+		if h.socket != "" {
+			if err := h.send(data); err != nil {
+				logging.Warnf("failed to notify %s of %s event: %v", h.socket, ev.Event, err)
+			}
+		}
+	}
+}
+
+// exec runs the command for ev, killing it and any process it started if
+// it runs past the timeout
+func (h *leaseHooks) exec(ev LeaseEvent, data []byte) error {
+	cmd := exec.Command(h.command)
+	cmd.Env = append(os.Environ(),
+		"DHCP_LEASE_EVENT="+ev.Event,
+		"DHCP_LEASE_NETWORK="+ev.Network,
+		"DHCP_LEASE_CONTAINER_ID="+ev.ContainerID,
+		"DHCP_LEASE_IFNAME="+ev.IfName,
+		"DHCP_LEASE_IP="+ev.IP,
+		"DHCP_LEASE_EXPIRES="+ev.Expires.Format(time.RFC3339),
+	)
+	cmd.Stdin = bytes.NewReader(data)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%s hook failed: %v", ev.Event, err)
+	}
+	killed := make(chan struct{})
+	timer := time.AfterFunc(h.timeout, func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		close(killed)
+	})
+	err := cmd.Wait()
+	timer.Stop()
+
+	select {
+	case <-killed:
+		return fmt.Errorf("%s hook timed out after %v", ev.Event, h.timeout)
+	default:
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %v: %s", ev.Event, err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// send writes data as a line to a new connection to the socket
+func (h *leaseHooks) send(data []byte) error {
+	conn, err := net.DialTimeout("unix", h.socket, h.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(h.timeout))
+	_, err = conn.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLeaseHookCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhcp-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "events")
+	hook := filepath.Join(dir, "hook")
+	script := "#!/bin/sh\necho \"$DHCP_LEASE_EVENT $DHCP_LEASE_CONTAINER_ID $DHCP_LEASE_IP $(cat)\" >> " + out + "\n"
+	if err := ioutil.WriteFile(hook, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	h := newLeaseHooks(hook, "", 5*time.Second)
+	h.notify(LeaseEvent{Event: leaseEventAcquire, Network: "net1", ContainerID: "a", IfName: "eth0", IP: "10.0.0.2"})
+	h.notify(LeaseEvent{Event: leaseEventRelease, Network: "net1", ContainerID: "a", IfName: "eth0", IP: "10.0.0.2"})
+	h.close()
+	// events after close are dropped
+	h.notify(LeaseEvent{Event: leaseEventAcquire, Network: "net1", ContainerID: "b", IfName: "eth0"})
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 hook runs, got %q", lines)
+	}
+	for i, event := range []string{"acquire", "release"} {
+		fields := strings.SplitN(lines[i], " ", 4)
+		if fields[0] != event || fields[1] != "a" || fields[2] != "10.0.0.2" {
+			t.Errorf("expected the %s of 10.0.0.2 by a, got %q", event, lines[i])
+		}
+		var ev LeaseEvent
+		if err := json.Unmarshal([]byte(fields[3]), &ev); err != nil || ev.Event != event {
+			t.Errorf("expected the %s event as JSON on stdin, got %q: %v", event, fields[3], err)
+		}
+	}
+}
+
+func TestLeaseHookTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhcp-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hook := filepath.Join(dir, "hook")
+	if err := ioutil.WriteFile(hook, []byte("#!/bin/sh\nsleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &leaseHooks{command: hook, timeout: 50 * time.Millisecond}
+	start := time.Now()
+	err = h.exec(LeaseEvent{Event: leaseEventRenew}, nil)
+	if err == nil || err.Error() != "renew hook timed out after 50ms" {
+		t.Errorf("expected the hook to time out, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("the hook was not killed")
+	}
+}
+
+func TestLeaseHookSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhcp-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "hook.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			conn.Close()
+			received <- line
+		}
+	}()
+
+	d := newDHCP()
+	d.hooks = newLeaseHooks("", socket, 5*time.Second)
+	key := leaseKey{Network: "net1", ContainerID: "a", IfName: "eth0"}
+	events := d.leaseEvents(key)
+	events(leaseEventRenew, &DHCPLease{clientID: key.String()})
+	events(leaseEventExpire, &DHCPLease{clientID: key.String()})
+	d.hooks.close()
+
+	for _, event := range []string{"renew", "expire"} {
+		select {
+		case line := <-received:
+			var ev LeaseEvent
+			if err := json.Unmarshal([]byte(line), &ev); err != nil {
+				t.Fatalf("expected a line of JSON, got %q: %v", line, err)
+			}
+			expected := LeaseEvent{Event: event, Network: "net1", ContainerID: "a", IfName: "eth0"}
+			if ev != expected {
+				t.Errorf("expected %v, got %v", expected, ev)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event received", event)
+		}
+	}
+}
+
+func TestNoLeaseEventsWithoutHooks(t *testing.T) {
+	if newDHCP().leaseEvents(leaseKey{Network: "net1"}) != nil {
+		t.Error("expected no event function without hooks")
+	}
+}
@@ -16,7 +16,6 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"math/rand"
 	"net"
 	"sync"
@@ -26,6 +25,7 @@ import (
 	"github.com/d2g/dhcp4client"
 	"github.com/vishvananda/netlink"
 
+	"github.com/containernetworking/cni/pkg/logging"
 	"github.com/containernetworking/cni/pkg/ns"
 	"github.com/containernetworking/cni/pkg/types"
 )
@@ -48,7 +48,10 @@ const (
 // needs to be done carefully as dhcp4client ops are blocking.
 
 type DHCPLease struct {
-	clientID      string
+	clientID string
+	// mu guards the fields set by commit against readers outside the
+	// goroutine maintaining the lease
+	mu            sync.Mutex
 	ack           *dhcp4.Packet
 	opts          dhcp4.Options
 	link          netlink.Link
@@ -57,28 +60,38 @@ type DHCPLease struct {
 	expireTime    time.Time
 	stop          chan struct{}
 	wg            sync.WaitGroup
+	log           *logging.Logger
+	// events, if set, is called with each event of the lease
+	events func(event string, l *DHCPLease)
 }
 
 // AcquireLease gets an DHCP lease and then maintains it in the background
 // by periodically renewing it. The acquired lease can be released by
-// calling DHCPLease.Stop()
-func AcquireLease(clientID, netns, ifName string) (*DHCPLease, error) {
+// calling DHCPLease.Stop(). A non-zero vlan runs DHCP over the VLAN
+// subinterface of ifName with that ID. events, if not nil, is called
+// as the lease is acquired, renewed, rebound, expires or is released.
+func AcquireLease(clientID, netns, ifName string, vlan int, events func(string, *DHCPLease)) (*DHCPLease, error) {
 	errCh := make(chan error, 1)
 	l := &DHCPLease{
 		clientID: clientID,
+		events:   events,
 		stop:     make(chan struct{}),
+		log:      logging.Default().With("clientID", clientID, "netns", netns, "ifName", ifName),
+	}
+	if vlan != 0 {
+		l.log = l.log.With("vlan", vlan)
 	}
 
-	log.Printf("%v: acquiring lease", clientID)
+	l.log.Infof("acquiring lease")
 
 	l.wg.Add(1)
 	go func() {
 		errCh <- ns.WithNetNSPath(netns, func(_ ns.NetNS) error {
 			defer l.wg.Done()
 
-			link, err := netlink.LinkByName(ifName)
+			link, err := dhcpLink(ifName, vlan)
 			if err != nil {
-				return fmt.Errorf("error looking up %q: %v", ifName, err)
+				return err
 			}
 
 			l.link = link
@@ -87,7 +100,8 @@ func AcquireLease(clientID, netns, ifName string) (*DHCPLease, error) {
 				return err
 			}
 
-			log.Printf("%v: lease acquired, expiration is %v", l.clientID, l.expireTime)
+			l.log.Infof("lease acquired, expiration is %v", l.expireTime)
+			l.event(leaseEventAcquire)
 
 			errCh <- nil
 
@@ -103,6 +117,30 @@ func AcquireLease(clientID, netns, ifName string) (*DHCPLease, error) {
 	return l, nil
 }
 
+// dhcpLink returns the link to run DHCP over: ifName itself, or its VLAN
+// subinterface with ID vlan if that is not zero
+func dhcpLink(ifName string, vlan int) (netlink.Link, error) {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up %q: %v", ifName, err)
+	}
+	if vlan == 0 {
+		return link, nil
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("error listing links: %v", err)
+	}
+	for _, l := range links {
+		v, ok := l.(*netlink.Vlan)
+		if ok && v.VlanId == vlan && v.Attrs().ParentIndex == link.Attrs().Index {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("no VLAN %d subinterface of %q", vlan, ifName)
+}
+
 // Stop terminates the background task that maintains the lease
 // and issues a DHCP Release
 func (l *DHCPLease) Stop() {
@@ -118,7 +156,7 @@ func (l *DHCPLease) acquire() error {
 	defer c.Close()
 
 	if (l.link.Attrs().Flags & net.FlagUp) != net.FlagUp {
-		log.Printf("Link %q down. Attempting to set up", l.link.Attrs().Name)
+		l.log.Infof("link %q down, attempting to set up", l.link.Attrs().Name)
 		if err = netlink.LinkSetUp(l.link); err != nil {
 			return err
 		}
@@ -162,6 +200,9 @@ func (l *DHCPLease) commit(ack *dhcp4.Packet) error {
 		renewalTime = leaseTime / 2
 	}
 
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
 	now := time.Now()
 	l.expireTime = now.Add(leaseTime)
 	l.renewalTime = now.Add(renewalTime)
@@ -182,35 +223,38 @@ func (l *DHCPLease) maintain() {
 		case leaseStateBound:
 			sleepDur = l.renewalTime.Sub(time.Now())
 			if sleepDur <= 0 {
-				log.Printf("%v: renewing lease", l.clientID)
+				l.log.Infof("renewing lease")
 				state = leaseStateRenewing
 				continue
 			}
 
 		case leaseStateRenewing:
 			if err := l.renew(); err != nil {
-				log.Printf("%v: %v", l.clientID, err)
+				l.log.Warnf("%v", err)
 
 				if time.Now().After(l.rebindingTime) {
-					log.Printf("%v: renawal time expired, rebinding", l.clientID)
+					l.log.Warnf("renewal time expired, rebinding")
 					state = leaseStateRebinding
 				}
 			} else {
-				log.Printf("%v: lease renewed, expiration is %v", l.clientID, l.expireTime)
+				l.log.Infof("lease renewed, expiration is %v", l.expireTime)
+				l.event(leaseEventRenew)
 				state = leaseStateBound
 			}
 
 		case leaseStateRebinding:
 			if err := l.acquire(); err != nil {
-				log.Printf("%v: %v", l.clientID, err)
+				l.log.Warnf("%v", err)
 
 				if time.Now().After(l.expireTime) {
-					log.Printf("%v: lease expired, bringing interface DOWN", l.clientID)
+					l.log.Errorf("lease expired, bringing interface DOWN")
 					l.downIface()
+					l.event(leaseEventExpire)
 					return
 				}
 			} else {
-				log.Printf("%v: lease rebound, expiration is %v", l.clientID, l.expireTime)
+				l.log.Infof("lease rebound, expiration is %v", l.expireTime)
+				l.event(leaseEventRebind)
 				state = leaseStateBound
 			}
 		}
@@ -220,16 +264,24 @@ func (l *DHCPLease) maintain() {
 
 		case <-l.stop:
 			if err := l.release(); err != nil {
-				log.Printf("%v: failed to release DHCP lease: %v", l.clientID, err)
+				l.log.Warnf("failed to release DHCP lease: %v", err)
 			}
+			l.event(leaseEventRelease)
 			return
 		}
 	}
 }
 
+// event reports an event of the lease, if anything listens for them
+func (l *DHCPLease) event(name string) {
+	if l.events != nil {
+		l.events(name, l)
+	}
+}
+
 func (l *DHCPLease) downIface() {
 	if err := netlink.LinkSetDown(l.link); err != nil {
-		log.Printf("%v: failed to bring %v interface DOWN: %v", l.clientID, l.link.Attrs().Name, err)
+		l.log.Errorf("failed to bring %v interface DOWN: %v", l.link.Attrs().Name, err)
 	}
 }
 
@@ -259,22 +311,52 @@ func (l *DHCPLease) renew() error {
 	return nil
 }
 
+// release sends a DHCPRELEASE for the lease. As RFC 2131 requires, it is
+// unicast from the leased address to the server that granted the lease,
+// since many servers ignore broadcast releases. It is broadcast only if
+// the leased address cannot be sent from, e.g. because it was already
+// removed from the interface.
 func (l *DHCPLease) release() error {
-	log.Printf("%v: releasing lease", l.clientID)
+	l.log.Infof("releasing lease")
 
-	c, err := newDHCPClient(l.link)
+	c, err := l.newReleaseClient()
 	if err != nil {
 		return err
 	}
 	defer c.Close()
 
 	if err = c.Release(*l.ack); err != nil {
-		return fmt.Errorf("failed to send DHCPRELEASE")
+		return fmt.Errorf("failed to send DHCPRELEASE: %v", err)
 	}
 
 	return nil
 }
 
+// newReleaseClient returns a client unicasting to the server of the
+// lease, or broadcasting if that is not possible
+func (l *DHCPLease) newReleaseClient() (*dhcp4client.Client, error) {
+	server := parseServerIdentifier(l.opts)
+	if server == nil {
+		l.log.Warnf("lease has no server identifier, broadcasting DHCPRELEASE")
+		return newDHCPClient(l.link)
+	}
+
+	sock, err := dhcp4client.NewInetSock(
+		dhcp4client.SetLocalAddr(net.UDPAddr{IP: l.ack.YIAddr(), Port: 68}),
+		dhcp4client.SetRemoteAddr(net.UDPAddr{IP: server, Port: 67}),
+	)
+	if err != nil {
+		l.log.Warnf("cannot send from %v, broadcasting DHCPRELEASE: %v", l.ack.YIAddr(), err)
+		return newDHCPClient(l.link)
+	}
+
+	return dhcp4client.New(
+		dhcp4client.HardwareAddr(l.link.Attrs().HardwareAddr),
+		dhcp4client.Timeout(5*time.Second),
+		dhcp4client.Connection(sock),
+	)
+}
+
 func (l *DHCPLease) IPNet() (*net.IPNet, error) {
 	mask := parseSubnetMask(l.opts)
 	if mask == nil {
@@ -287,6 +369,18 @@ func (l *DHCPLease) IPNet() (*net.IPNet, error) {
 	}, nil
 }
 
+// status returns the leased IP, if the lease was acquired, and when the
+// lease expires unless it is renewed
+func (l *DHCPLease) status() (net.IP, time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.ack == nil {
+		return nil, time.Time{}
+	}
+	return l.ack.YIAddr(), l.expireTime
+}
+
 func (l *DHCPLease) Gateway() net.IP {
 	return parseRouter(l.opts)
 }
@@ -310,7 +404,7 @@ func backoffRetry(f func() (*dhcp4.Packet, error)) (*dhcp4.Packet, error) {
 			return pkt, nil
 		}
 
-		log.Print(err)
+		logging.Warnf("DHCP request failed, retrying: %v", err)
 
 		time.Sleep(baseDelay + jitter(time.Second))
 
@@ -0,0 +1,135 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/logging"
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/d2g/dhcp4"
+	"github.com/vishvananda/netlink"
+)
+
+func TestDHCPLink(t *testing.T) {
+	netns, err := ns.NewNS()
+	if err != nil {
+		t.Skipf("cannot create a network namespace: %v", err)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(ns.NetNS) error {
+		veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}, PeerName: "peer0"}
+		if err := netlink.LinkAdd(veth); err != nil {
+			return err
+		}
+		parent, err := netlink.LinkByName("eth0")
+		if err != nil {
+			return err
+		}
+		return netlink.LinkAdd(&netlink.Vlan{
+			LinkAttrs: netlink.LinkAttrs{Name: "eth0.100", ParentIndex: parent.Attrs().Index},
+			VlanId:    100,
+		})
+	})
+	if err != nil {
+		t.Skipf("cannot create VLAN links: %v", err)
+	}
+
+	err = netns.Do(func(ns.NetNS) error {
+		link, err := dhcpLink("eth0", 0)
+		if err != nil {
+			return err
+		}
+		if link.Attrs().Name != "eth0" {
+			t.Errorf("expected eth0 itself, got %q", link.Attrs().Name)
+		}
+
+		link, err = dhcpLink("eth0", 100)
+		if err != nil {
+			return err
+		}
+		if link.Attrs().Name != "eth0.100" {
+			t.Errorf("expected eth0.100, got %q", link.Attrs().Name)
+		}
+
+		if _, err := dhcpLink("eth0", 200); err == nil {
+			t.Errorf("expected no VLAN 200 of eth0")
+		}
+		if _, err := dhcpLink("peer0", 100); err == nil {
+			t.Errorf("expected no VLAN 100 of peer0")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReleaseIsUnicast(t *testing.T) {
+	netns, err := ns.NewNS()
+	if err != nil {
+		t.Skipf("cannot create a network namespace: %v", err)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(ns.NetNS) error {
+		lo, err := netlink.LinkByName("lo")
+		if err != nil {
+			return err
+		}
+		if err := netlink.LinkSetUp(lo); err != nil {
+			return err
+		}
+
+		// the server and the leased address are both on lo
+		server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 67})
+		if err != nil {
+			return err
+		}
+		defer server.Close()
+
+		ack := dhcp4.NewPacket(dhcp4.BootReply)
+		ack.SetYIAddr(net.IPv4(127, 0, 0, 1))
+		ack.AddOption(dhcp4.OptionServerIdentifier, []byte{127, 0, 0, 1})
+		l := &DHCPLease{ack: &ack, opts: ack.ParseOptions(), link: lo, log: logging.Default()}
+		if err := l.release(); err != nil {
+			return err
+		}
+
+		buf := make([]byte, 1500)
+		server.SetReadDeadline(time.Now().Add(time.Second))
+		n, from, err := server.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		pkt := dhcp4.Packet(buf[:n])
+		if !from.IP.Equal(net.IPv4(127, 0, 0, 1)) || from.Port != 68 {
+			t.Errorf("expected the release from 127.0.0.1:68, got %v", from)
+		}
+		if !pkt.CIAddr().Equal(net.IPv4(127, 0, 0, 1)) {
+			t.Errorf("expected ciaddr 127.0.0.1, got %v", pkt.CIAddr())
+		}
+		if mt := pkt.ParseOptions()[dhcp4.OptionDHCPMessageType]; len(mt) != 1 || dhcp4.MessageType(mt[0]) != dhcp4.Release {
+			t.Errorf("expected a DHCPRELEASE, got message type %v", mt)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/rpc"
+
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+// leasesCmd implements "dhcp leases": it prints the leases the daemon
+// maintains as JSON
+type leasesCmd struct {
+	network string
+}
+
+func (*leasesCmd) Usage() string {
+	return "print the leases the daemon maintains"
+}
+
+func (c *leasesCmd) SetFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.network, "network", "", "only list the leases of this network")
+}
+
+func (c *leasesCmd) Run(ctx *skel.SubcommandContext) error {
+	return runLeasesErr(ctx.Stdout, c.network)
+}
+
+func runLeasesErr(stdout io.Writer, network string) error {
+	client, err := rpc.DialHTTP("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("error dialing DHCP daemon: %v", err)
+	}
+	defer client.Close()
+
+	var leases []LeaseInfo
+	if err := client.Call("DHCP.List", &LeaseListArgs{Network: network}, &leases); err != nil {
+		return fmt.Errorf("error calling DHCP.List: %v", err)
+	}
+
+	out, err := json.MarshalIndent(leases, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(stdout, "%s\n", out)
+	return err
+}
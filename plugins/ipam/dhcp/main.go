@@ -15,41 +15,149 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/rpc"
-	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/containernetworking/cni/pkg/logging"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
 )
 
 const socketPath = "/run/cni/dhcp.sock"
 
+// errLeaseLost is the code of the error CHECK returns when the daemon no
+// longer holds the lease of the attachment, e.g. because it expired or
+// the daemon restarted; the attachment has to be set up again
+const errLeaseLost uint = 110
+
 func main() {
-	if len(os.Args) > 1 && os.Args[1] == "daemon" {
-		runDaemon()
-	} else {
-		skel.PluginMain(cmdAdd, cmdDel)
+	skel.PluginMainWithSubcommands(skel.PluginFuncs{Add: cmdAdd, Check: cmdCheck, Del: cmdDel}, map[string]skel.Subcommand{
+		"daemon": &daemonCmd{},
+		"leases": &leasesCmd{},
+	})
+}
+
+// netConf holds the parts of the network configuration the client and
+// the daemon use
+type netConf struct {
+	types.NetConf
+	IPAM ipamConf       `json:"ipam"`
+	Log  logging.Config `json:"log,omitempty"`
+}
+
+type ipamConf struct {
+	Type string `json:"type"`
+	// Interface is the container interface to run DHCP over; it
+	// defaults to CNI_IFNAME
+	Interface string `json:"interface,omitempty"`
+	// VLAN, if set, runs DHCP over the VLAN subinterface of Interface
+	// with this ID instead
+	VLAN int `json:"vlan,omitempty"`
+}
+
+func setupLogging(command string, args *skel.CmdArgs) (func(), error) {
+	conf := netConf{}
+	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
+		return nil, fmt.Errorf("error parsing netconf: %v", err)
 	}
+	return logging.Setup(conf.Log, "plugin", "dhcp", "command", command,
+		"containerID", args.ContainerID, "network", conf.Name)
 }
 
 func cmdAdd(args *skel.CmdArgs) error {
-	result := types.Result{}
+	closeLog, err := setupLogging("ADD", args)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	conf := netConf{}
+	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
+		return fmt.Errorf("error parsing netconf: %v", err)
+	}
+
+	result := current.Result{}
 	if err := rpcCall("DHCP.Allocate", args, &result); err != nil {
+		logging.Errorf("%v", err)
 		return err
 	}
-	return result.Print()
+	logging.Debugf("daemon returned %v", &result)
+	return current.PrintResult(&result, conf.CNIVersion)
 }
 
 func cmdDel(args *skel.CmdArgs) error {
+	closeLog, err := setupLogging("DEL", args)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
 	result := struct{}{}
 	if err := rpcCall("DHCP.Release", args, &result); err != nil {
+		logging.Errorf("%v", err)
 		return fmt.Errorf("error dialing DHCP daemon: %v", err)
 	}
 	return nil
 }
 
+func cmdCheck(args *skel.CmdArgs) error {
+	closeLog, err := setupLogging("CHECK", args)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	prevResult, err := current.ParsePrevResult(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if prevResult == nil {
+		return errors.New("required prevResult missing")
+	}
+
+	info := LeaseInfo{}
+	if err := rpcCall("DHCP.Lookup", args, &info); err != nil {
+		logging.Errorf("%v", err)
+		return err
+	}
+	if err := checkLease(&info, prevResult, time.Now()); err != nil {
+		logging.Warnf("%v", err)
+		return err
+	}
+	return nil
+}
+
+// checkLease fails with errLeaseLost unless info is a lease that has not
+// expired by now, of the IPv4 address in prevResult
+func checkLease(info *LeaseInfo, prevResult *current.Result, now time.Time) error {
+	var addr net.IP
+	for _, ip := range prevResult.IPs {
+		if ip.Version == "4" {
+			addr = ip.Address.IP
+			break
+		}
+	}
+	if addr == nil {
+		return errors.New("prevResult has no IPv4 address")
+	}
+
+	switch {
+	case info.ContainerID == "" || info.IP == "":
+		return types.NewError(errLeaseLost, fmt.Sprintf("the DHCP daemon holds no lease for %v", addr), "")
+	case !now.Before(info.Expires):
+		return types.NewError(errLeaseLost, fmt.Sprintf("the lease of %v expired at %v", addr, info.Expires), "")
+	case info.IP != addr.String():
+		return types.NewError(errLeaseLost, fmt.Sprintf("the lease is for %s, not %v", info.IP, addr), "")
+	}
+	return nil
+}
+
 func rpcCall(method string, args *skel.CmdArgs, result interface{}) error {
 	client, err := rpc.DialHTTP("unix", socketPath)
 	if err != nil {
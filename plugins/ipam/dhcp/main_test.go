@@ -0,0 +1,56 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+)
+
+func TestCheckLease(t *testing.T) {
+	now := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	prevResult := &current.Result{IPs: []*current.IPConfig{{
+		Version: "4",
+		Address: net.IPNet{IP: net.ParseIP("192.0.2.10").To4(), Mask: net.CIDRMask(24, 32)},
+	}}}
+	held := LeaseInfo{Network: "net1", ContainerID: "a", IfName: "eth0", IP: "192.0.2.10", Expires: now.Add(time.Hour)}
+
+	if err := checkLease(&held, prevResult, now); err != nil {
+		t.Errorf("expected the lease to be valid, got %v", err)
+	}
+
+	expired := held
+	expired.Expires = now.Add(-time.Second)
+	moved := held
+	moved.IP = "192.0.2.11"
+	for name, info := range map[string]LeaseInfo{
+		"no lease": {},
+		"expired":  expired,
+		"moved":    moved,
+	} {
+		err := checkLease(&info, prevResult, now)
+		if e, ok := err.(*types.Error); !ok || e.Code != errLeaseLost {
+			t.Errorf("%s: expected a lost lease error, got %v", name, err)
+		}
+	}
+
+	if err := checkLease(&held, &current.Result{}, now); err == nil {
+		t.Error("expected a prevResult without IPv4 address to fail")
+	}
+}
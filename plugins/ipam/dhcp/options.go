@@ -33,6 +33,17 @@ func parseRouter(opts dhcp4.Options) net.IP {
 	return nil
 }
 
+// parseServerIdentifier returns the address of the server that granted
+// the lease, which releases are sent to
+func parseServerIdentifier(opts dhcp4.Options) net.IP {
+	if opts, ok := opts[dhcp4.OptionServerIdentifier]; ok {
+		if len(opts) == 4 {
+			return net.IP(opts)
+		}
+	}
+	return nil
+}
+
 func classfulSubnet(sn net.IP) net.IPNet {
 	return net.IPNet{
 		IP:   sn,
@@ -16,49 +16,74 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"net"
+	"strconv"
+	"time"
 
 	"github.com/containernetworking/cni/pkg/ip"
+	"github.com/containernetworking/cni/pkg/logging"
 	"github.com/containernetworking/cni/pkg/types"
 	"github.com/containernetworking/cni/plugins/ipam/host-local/backend"
 )
 
+// errQuotaExceeded is the code of the error returned when an allocation
+// would go over one of the maxAllocations limits
+const errQuotaExceeded uint = 110
+
+// conflictTimeout is how long an address is probed for with checkConflict
+const conflictTimeout = 500 * time.Millisecond
+
 type IPAllocator struct {
-	start net.IP
-	end   net.IP
-	conf  *IPAMConfig
-	store backend.Store
+	ranges []*ipRange
+	conf   *IPAMConfig
+	store  backend.Store
+
+	// inUse, if set, reports whether another host answers for an address
+	inUse func(net.IP) (bool, error)
 }
 
 func NewIPAllocator(conf *IPAMConfig, store backend.Store) (*IPAllocator, error) {
-	var (
-		start net.IP
-		end   net.IP
-		err   error
-	)
-	start, end, err = networkRange((*net.IPNet)(&conf.Subnet))
+	ranges, err := newIPRanges(conf)
 	if err != nil {
 		return nil, err
 	}
-
-	// skip the .0 address
-	start = ip.NextIP(start)
-
-	if conf.RangeStart != nil {
-		if err := validateRangeIP(conf.RangeStart, (*net.IPNet)(&conf.Subnet)); err != nil {
+	if conf.Shared != nil {
+		if ranges, err = conf.Shared.clip(ranges); err != nil {
 			return nil, err
 		}
-		start = conf.RangeStart
 	}
-	if conf.RangeEnd != nil {
-		if err := validateRangeIP(conf.RangeEnd, (*net.IPNet)(&conf.Subnet)); err != nil {
-			return nil, err
+	if conf.MaxAllocations < 0 || conf.MaxAllocationsPerPrefix < 0 || conf.IDPrefixLength < 0 {
+		return nil, fmt.Errorf("allocation limits must not be negative")
+	}
+	if conf.MaxAllocationsPerPrefix > 0 && conf.IDPrefixLength == 0 {
+		return nil, fmt.Errorf("%q requires %q", "maxAllocationsPerPrefix", "idPrefixLength")
+	}
+
+	a := &IPAllocator{ranges: ranges, conf: conf, store: store}
+	switch conf.CheckConflict {
+	case "":
+	case "arping":
+		if conf.CheckConflictInterface == "" {
+			return nil, fmt.Errorf("%q requires %q", "checkConflict", "checkConflictInterface")
 		}
-		// RangeEnd is inclusive
-		end = ip.NextIP(conf.RangeEnd)
+		a.inUse = func(addr net.IP) (bool, error) {
+			return ip.AddrInUse(conf.CheckConflictInterface, addr, conflictTimeout)
+		}
+	default:
+		return nil, fmt.Errorf("unknown checkConflict mode %q", conf.CheckConflict)
+	}
+
+	switch conf.Allocation {
+	case "", allocationSequential:
+	case allocationStableHash:
+		// IPv4 ranges are too small for hashing to spread allocations
+		for _, r := range ranges {
+			r.hashed = r.start.To4() == nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown allocation mode %q", conf.Allocation)
 	}
-	return &IPAllocator{start, end, conf, store}, nil
+	return a, nil
 }
 
 func validateRangeIP(ip net.IP, ipnet *net.IPNet) error {
@@ -68,14 +93,21 @@ func validateRangeIP(ip net.IP, ipnet *net.IPNet) error {
 	return nil
 }
 
-// Returns newly allocated IP along with its config
-func (a *IPAllocator) Get(id string) (*types.IPConfig, error) {
+// Returns newly allocated IP along with its config, for the interface
+// ifName of the container with the given ID
+func (a *IPAllocator) Get(id, ifName string) (*types.IPConfig, error) {
 	a.store.Lock()
 	defer a.store.Unlock()
 
-	gw := a.conf.Gateway
-	if gw == nil {
-		gw = ip.NextIP(a.conf.Subnet.IP)
+	res := backend.Reservation{
+		ID:         id,
+		IfName:     ifName,
+		Allocated:  time.Now().UTC(),
+		ConfigHash: a.conf.configHash,
+	}
+
+	if err := a.checkQuota(id); err != nil {
+		return nil, err
 	}
 
 	var requestedIP net.IP
@@ -84,119 +116,157 @@ func (a *IPAllocator) Get(id string) (*types.IPConfig, error) {
 	}
 
 	if requestedIP != nil {
-		if gw != nil && gw.Equal(a.conf.Args.IP) {
-			return nil, fmt.Errorf("requested IP must differ gateway IP")
-		}
-
-		subnet := net.IPNet{
-			IP:   a.conf.Subnet.IP,
-			Mask: a.conf.Subnet.Mask,
-		}
-		err := validateRangeIP(requestedIP, &subnet)
+		r, err := a.rangeFor(requestedIP)
 		if err != nil {
 			return nil, err
 		}
+		if r.gateway.Equal(requestedIP) {
+			return nil, fmt.Errorf("requested IP must differ gateway IP")
+		}
+		if s := a.conf.Shared; s != nil && !s.contains(requestedIP) {
+			return nil, fmt.Errorf("requested IP %s is outside the range %s-%s of this node", requestedIP, s.RangeStart, s.RangeEnd)
+		}
 
-		reserved, err := a.store.Reserve(id, requestedIP)
+		reserved, err := a.reserve(res, requestedIP)
 		if err != nil {
 			return nil, err
 		}
 
 		if reserved {
-			return &types.IPConfig{
-				IP:      net.IPNet{IP: requestedIP, Mask: a.conf.Subnet.Mask},
-				Gateway: gw,
-				Routes:  a.conf.Routes,
-			}, nil
+			logging.Debugf("reserved requested IP %v for %q", requestedIP, id)
+			return a.ipConfig(r, requestedIP), nil
 		}
 		return nil, fmt.Errorf("requested IP address %q is not available in network: %s", requestedIP, a.conf.Name)
 	}
 
-	startIP, endIP := a.getSearchRange()
-	for cur := startIP; !cur.Equal(endIP); cur = a.nextIP(cur) {
-		// don't allocate gateway IP
-		if gw != nil && cur.Equal(gw) {
-			continue
+	lastReservedIP, err := a.store.LastReservedIP()
+	if err != nil {
+		logging.Debugf("starting search from the range start: %v", err)
+		lastReservedIP = nil
+	}
+	ranges, err := a.orderRanges(lastReservedIP)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range ranges {
+		startIP, endIP := r.searchRange(lastReservedIP)
+		if r.hashed {
+			// try the derived address, then the ones after it
+			hashedIP := r.hashedIP(a.conf.Name, id)
+			if ipConf, err := a.reserveIn(r, res, hashedIP); ipConf != nil || err != nil {
+				return ipConf, err
+			}
+			startIP, endIP = r.nextIP(hashedIP), hashedIP
 		}
-
-		reserved, err := a.store.Reserve(id, cur)
-		if err != nil {
-			return nil, err
-		}
-		if reserved {
-			return &types.IPConfig{
-				IP:      net.IPNet{IP: cur, Mask: a.conf.Subnet.Mask},
-				Gateway: gw,
-				Routes:  a.conf.Routes,
-			}, nil
+		logging.Debugf("searching for a free IP from %v to %v", startIP, endIP)
+		for cur := startIP; !cur.Equal(endIP); cur = r.nextIP(cur) {
+			if ipConf, err := a.reserveIn(r, res, cur); ipConf != nil || err != nil {
+				return ipConf, err
+			}
 		}
+		logging.Warnf("range %v-%v of network %q is exhausted", r.start, r.end, a.conf.Name)
 	}
 	return nil, fmt.Errorf("no IP addresses available in network: %s", a.conf.Name)
 }
 
-// Releases all IPs allocated for the container with given ID
-func (a *IPAllocator) Release(id string) error {
-	a.store.Lock()
-	defer a.store.Unlock()
+// reserveIn reserves cur of r as res, returning nil if it cannot be
+// allocated or is not free. It must be called with the store locked.
+func (a *IPAllocator) reserveIn(r *ipRange, res backend.Reservation, cur net.IP) (*types.IPConfig, error) {
+	// don't allocate gateway IP, nor the end hashed searches wrap at or
+	// the one past the range of the node
+	if cur.Equal(r.gateway) || (r.hashed || r.clipped) && cur.Equal(r.end) {
+		return nil, nil
+	}
 
-	return a.store.ReleaseByID(id)
+	reserved, err := a.reserve(res, cur)
+	if err != nil || !reserved {
+		return nil, err
+	}
+	logging.Debugf("reserved IP %v for %q", cur, res.ID)
+	return a.ipConfig(r, cur), nil
 }
 
-func networkRange(ipnet *net.IPNet) (net.IP, net.IP, error) {
-	if ipnet.IP == nil {
-		return nil, nil, fmt.Errorf("missing field %q in IPAM configuration", "subnet")
-	}
-	ip := ipnet.IP.To4()
-	if ip == nil {
-		ip = ipnet.IP.To16()
-		if ip == nil {
-			return nil, nil, fmt.Errorf("IP not v4 nor v6")
-		}
+// ipConfig returns the configuration of addr, allocated from r
+func (a *IPAllocator) ipConfig(r *ipRange, addr net.IP) *types.IPConfig {
+	return &types.IPConfig{
+		IP:      net.IPNet{IP: addr, Mask: r.subnet.Mask},
+		Gateway: r.gateway,
+		Routes:  a.conf.Routes,
 	}
+}
 
-	if len(ip) != len(ipnet.Mask) {
-		return nil, nil, fmt.Errorf("IPNet IP and Mask version mismatch")
+// reserve reserves candidate as res, unless conflict checking is enabled
+// and another host answers for it. It must be called with the store locked.
+func (a *IPAllocator) reserve(res backend.Reservation, candidate net.IP) (bool, error) {
+	reserved, err := a.store.Reserve(res, candidate)
+	if err != nil || !reserved || a.inUse == nil {
+		return reserved, err
 	}
 
-	var end net.IP
-	for i := 0; i < len(ip); i++ {
-		end = append(end, ip[i]|^ipnet.Mask[i])
+	inUse, err := a.inUse(candidate)
+	if err == nil && !inUse {
+		return true, nil
 	}
-	return ipnet.IP, end, nil
+	if releaseErr := a.store.Release(candidate); releaseErr != nil {
+		return false, releaseErr
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check %v for conflicts on %q: %v", candidate, a.conf.CheckConflictInterface, err)
+	}
+	logging.Warnf("skipping %v: another host on %q answers for it", candidate, a.conf.CheckConflictInterface)
+	return false, nil
 }
 
-// nextIP returns the next ip of curIP within ipallocator's subnet
-func (a *IPAllocator) nextIP(curIP net.IP) net.IP {
-	if curIP.Equal(a.end) {
-		return a.start
+// checkQuota fails if reserving another IP for id would go over the
+// limits of the network. It must be called with the store locked.
+func (a *IPAllocator) checkQuota(id string) error {
+	if err := a.checkLimit(id, "", a.conf.MaxAllocations); err != nil {
+		return err
+	}
+	if a.conf.MaxAllocationsPerPrefix == 0 {
+		return nil
+	}
+
+	prefix := id
+	if len(prefix) > a.conf.IDPrefixLength {
+		prefix = prefix[:a.conf.IDPrefixLength]
 	}
-	return ip.NextIP(curIP)
+	return a.checkLimit(id, prefix, a.conf.MaxAllocationsPerPrefix)
 }
 
-// getSearchRange returns the start and end ip based on the last reserved ip
-func (a *IPAllocator) getSearchRange() (net.IP, net.IP) {
-	var startIP net.IP
-	var endIP net.IP
-	startFromLastReservedIP := false
-	lastReservedIP, err := a.store.LastReservedIP()
+// checkLimit fails if max IPs are already reserved for IDs starting with
+// prefix; a zero max is no limit
+func (a *IPAllocator) checkLimit(id, prefix string, max int) error {
+	if max == 0 {
+		return nil
+	}
+	n, err := a.store.CountByIDPrefix(prefix)
 	if err != nil {
-		log.Printf("Error retriving last reserved ip: %v", err)
-	} else if lastReservedIP != nil {
-		subnet := net.IPNet{
-			IP:   a.conf.Subnet.IP,
-			Mask: a.conf.Subnet.Mask,
-		}
-		err := validateRangeIP(lastReservedIP, &subnet)
-		if err == nil {
-			startFromLastReservedIP = true
-		}
+		return err
 	}
-	if startFromLastReservedIP {
-		startIP = a.nextIP(lastReservedIP)
-		endIP = lastReservedIP
-	} else {
-		startIP = a.start
-		endIP = a.end
+	if n < max {
+		return nil
+	}
+
+	logging.Warnf("refusing to allocate for %q: %d of %d IPs in use", id, n, max)
+	if prefix == "" {
+		return types.NewError(errQuotaExceeded,
+			fmt.Sprintf("network %s has reached its limit of %d allocations", a.conf.Name, max), "").
+			WithField("network", a.conf.Name).
+			WithField("limit", strconv.Itoa(max))
 	}
-	return startIP, endIP
+	return types.NewError(errQuotaExceeded,
+		fmt.Sprintf("container IDs starting with %q have reached their limit of %d allocations in network %s", prefix, max, a.conf.Name), "").
+		WithField("network", a.conf.Name).
+		WithField("limit", strconv.Itoa(max)).
+		WithField("idPrefix", prefix)
+}
+
+// Releases all IPs allocated for the container with given ID
+func (a *IPAllocator) Release(id string) error {
+	a.store.Lock()
+	defer a.store.Unlock()
+
+	logging.Debugf("releasing IPs held by %q", id)
+	return a.store.ReleaseByID(id)
 }
@@ -0,0 +1,141 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/ip"
+	"github.com/containernetworking/cni/pkg/types"
+	fakestore "github.com/containernetworking/cni/plugins/ipam/host-local/backend/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// newBenchAllocator returns an allocator for subnet whose store already
+// has the given fraction of the pool reserved, at random but
+// reproducible addresses
+func newBenchAllocator(subnet string, utilization float64) (*IPAllocator, *fakestore.FakeStore, error) {
+	ipn, err := types.ParseCIDR(subnet)
+	if err != nil {
+		return nil, nil, err
+	}
+	conf := IPAMConfig{
+		Name:   "bench",
+		Type:   "host-local",
+		Subnet: types.IPNet{IP: ipn.IP, Mask: ipn.Mask},
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	ipmap := map[string]string{}
+	var last net.IP
+	ones, bits := ipn.Mask.Size()
+	size := 1 << uint(bits-ones)
+	// skip the network, gateway and broadcast addresses
+	cur := ip.NextIP(ip.NextIP(ipn.IP))
+	for i := 2; i < size-1; i++ {
+		if rnd.Float64() < utilization {
+			ipmap[cur.String()] = "existing"
+			last = cur
+		}
+		cur = ip.NextIP(cur)
+	}
+
+	store := fakestore.NewFakeStore(ipmap, last)
+	alloc, err := NewIPAllocator(&conf, store)
+	if err != nil {
+		return nil, nil, err
+	}
+	return alloc, store, nil
+}
+
+// benchmarkGet measures allocating an IP while keeping the utilization
+// of the pool constant, by releasing each IP again
+func benchmarkGet(b *testing.B, subnet string, utilization float64) {
+	alloc, store, err := newBenchAllocator(subnet, utilization)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		res, err := alloc.Get(fmt.Sprintf("container-%d", i), "eth0")
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		store.Release(res.IP.IP)
+		b.StartTimer()
+	}
+}
+
+func BenchmarkGet24Empty(b *testing.B)      { benchmarkGet(b, "10.0.0.0/24", 0) }
+func BenchmarkGet24Half(b *testing.B)       { benchmarkGet(b, "10.0.0.0/24", 0.5) }
+func BenchmarkGet24NearlyFull(b *testing.B) { benchmarkGet(b, "10.0.0.0/24", 0.9) }
+func BenchmarkGet16Empty(b *testing.B)      { benchmarkGet(b, "10.0.0.0/16", 0) }
+func BenchmarkGet16Half(b *testing.B)       { benchmarkGet(b, "10.0.0.0/16", 0.5) }
+func BenchmarkGet16NearlyFull(b *testing.B) { benchmarkGet(b, "10.0.0.0/16", 0.9) }
+func BenchmarkGet16Exhausted(b *testing.B)  { benchmarkGet(b, "10.0.0.0/16", 0.99) }
+
+// benchmarkRelease measures releasing the IP of one container from a
+// pool with the given utilization
+func benchmarkRelease(b *testing.B, subnet string, utilization float64) {
+	alloc, _, err := newBenchAllocator(subnet, utilization)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		id := fmt.Sprintf("container-%d", i)
+		if _, err := alloc.Get(id, "eth0"); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		if err := alloc.Release(id); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRelease24NearlyFull(b *testing.B) { benchmarkRelease(b, "10.0.0.0/24", 0.9) }
+func BenchmarkRelease16NearlyFull(b *testing.B) { benchmarkRelease(b, "10.0.0.0/16", 0.9) }
+
+// allocationBudget is the longest an allocation may take on average
+// with 90% of a /16 reserved
+const allocationBudget = time.Millisecond
+
+var _ = Describe("host-local ip allocator performance", func() {
+	It("allocates within budget from a nearly full /16", func() {
+		alloc, store, err := newBenchAllocator("10.0.0.0/16", 0.9)
+		Expect(err).NotTo(HaveOccurred())
+
+		const n = 200
+		var elapsed time.Duration
+		for i := 0; i < n; i++ {
+			start := time.Now()
+			res, err := alloc.Get(fmt.Sprintf("container-%d", i), "eth0")
+			elapsed += time.Since(start)
+			Expect(err).NotTo(HaveOccurred())
+			store.Release(res.IP.IP)
+		}
+		Expect(elapsed / n).To(BeNumerically("<", allocationBudget))
+	})
+})
@@ -15,6 +15,10 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/containernetworking/cni/pkg/types"
 	fakestore "github.com/containernetworking/cni/plugins/ipam/host-local/backend/testing"
 	. "github.com/onsi/ginkgo"
@@ -38,7 +42,7 @@ func (t AllocatorTestCase) run() (*types.IPConfig, error) {
 	}
 	store := fakestore.NewFakeStore(t.ipmap, net.ParseIP(t.lastIP))
 	alloc, _ := NewIPAllocator(&conf, store)
-	res, err := alloc.Get("ID")
+	res, err := alloc.Get("ID", "eth0")
 	return res, err
 }
 
@@ -133,4 +137,412 @@ var _ = Describe("host-local ip allocator", func() {
 			}
 		})
 	})
+	Context("when the store is not reliable", func() {
+		var (
+			store *fakestore.FakeStore
+			alloc *IPAllocator
+		)
+
+		BeforeEach(func() {
+			subnet, err := types.ParseCIDR("10.0.0.0/29")
+			Expect(err).NotTo(HaveOccurred())
+			conf := IPAMConfig{
+				Name:   "test",
+				Type:   "host-local",
+				Subnet: types.IPNet{IP: subnet.IP, Mask: subnet.Mask},
+			}
+			store = fakestore.NewFakeStore(map[string]string{}, nil)
+			alloc, err = NewIPAllocator(&conf, store)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns the error of a failed reservation", func() {
+			store.InjectError("Reserve", errors.New("disk full"), 1)
+
+			_, err := alloc.Get("ID", "eth0")
+			Expect(err).To(MatchError("disk full"))
+			Expect(store.IPMap()).To(BeEmpty())
+
+			res, err := alloc.Get("ID", "eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.IP.IP.String()).To(Equal("10.0.0.2"))
+		})
+
+		It("skips an IP reserved concurrently by another container", func() {
+			store.ReserveConcurrently(net.ParseIP("10.0.0.2"), "other")
+
+			res, err := alloc.Get("ID", "eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.IP.IP.String()).To(Equal("10.0.0.3"))
+			Expect(store.IPMap()).To(Equal(map[string]string{
+				"10.0.0.2": "other",
+				"10.0.0.3": "ID",
+			}))
+		})
+
+		It("records the interface, time and configuration of a reservation", func() {
+			alloc.conf.configHash = "sha256:abc"
+			before := time.Now()
+			res, err := alloc.Get("ID", "eth1")
+			Expect(err).NotTo(HaveOccurred())
+
+			r, err := store.Reservation(res.IP.IP)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.ID).To(Equal("ID"))
+			Expect(r.IfName).To(Equal("eth1"))
+			Expect(r.ConfigHash).To(Equal("sha256:abc"))
+			Expect(r.Allocated).To(BeTemporally(">=", before.Truncate(time.Second)))
+		})
+
+		It("holds the lock around every store access", func() {
+			_, err := alloc.Get("ID", "eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(alloc.Release("ID")).To(Succeed())
+
+			methods := []string{}
+			for _, call := range store.Calls() {
+				methods = append(methods, call.Method)
+			}
+			Expect(methods).To(Equal([]string{
+				"Lock", "LastReservedIP", "Reserve", "Unlock",
+				"Lock", "ReleaseByID", "Unlock",
+			}))
+			Expect(store.IPMap()).To(BeEmpty())
+		})
+
+		It("returns the error of a failed release", func() {
+			_, err := alloc.Get("ID", "eth0")
+			Expect(err).NotTo(HaveOccurred())
+			store.InjectError("ReleaseByID", errors.New("permission denied"), -1)
+
+			Expect(alloc.Release("ID")).To(MatchError("permission denied"))
+			Expect(alloc.Release("ID")).To(MatchError("permission denied"))
+			Expect(store.IPMap()).To(HaveLen(1))
+		})
+	})
+
+	Context("with allocation limits", func() {
+		var (
+			conf  IPAMConfig
+			store *fakestore.FakeStore
+		)
+
+		BeforeEach(func() {
+			subnet, err := types.ParseCIDR("10.0.0.0/24")
+			Expect(err).NotTo(HaveOccurred())
+			conf = IPAMConfig{
+				Name:   "test",
+				Type:   "host-local",
+				Subnet: types.IPNet{IP: subnet.IP, Mask: subnet.Mask},
+			}
+			store = fakestore.NewFakeStore(map[string]string{
+				"10.0.0.2": "tenant1-a",
+				"10.0.0.3": "tenant1-b",
+				"10.0.0.4": "tenant2-a",
+			}, nil)
+		})
+
+		get := func(id string) (*types.IPConfig, error) {
+			alloc, err := NewIPAllocator(&conf, store)
+			Expect(err).NotTo(HaveOccurred())
+			return alloc.Get(id, "eth0")
+		}
+
+		It("refuses to allocate beyond maxAllocations", func() {
+			conf.MaxAllocations = 3
+
+			_, err := get("tenant3-a")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*types.Error).Code).To(Equal(errQuotaExceeded))
+			Expect(err.(*types.Error).Fields).To(Equal(map[string]string{"network": "test", "limit": "3"}))
+			Expect(store.IPMap()).To(HaveLen(3))
+
+			Expect(store.ReleaseByID("tenant2-a")).To(Succeed())
+			_, err = get("tenant3-a")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("limits each container ID prefix on its own", func() {
+			conf.MaxAllocationsPerPrefix = 2
+			conf.IDPrefixLength = len("tenant1")
+
+			_, err := get("tenant1-c")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*types.Error).Code).To(Equal(errQuotaExceeded))
+			Expect(err.(*types.Error).Fields).To(HaveKeyWithValue("idPrefix", "tenant1"))
+
+			res, err := get("tenant2-b")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.IP.IP.String()).To(Equal("10.0.0.5"))
+		})
+
+		It("also applies to requested IPs", func() {
+			conf.MaxAllocations = 3
+			conf.Args = &IPAMArgs{IP: net.ParseIP("10.0.0.10")}
+
+			_, err := get("tenant3-a")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*types.Error).Code).To(Equal(errQuotaExceeded))
+		})
+
+		It("requires idPrefixLength with maxAllocationsPerPrefix", func() {
+			conf.MaxAllocationsPerPrefix = 2
+
+			_, err := NewIPAllocator(&conf, store)
+			Expect(err).To(MatchError(`"maxAllocationsPerPrefix" requires "idPrefixLength"`))
+		})
+	})
+
+	Context("with several ranges", func() {
+		var (
+			conf  IPAMConfig
+			store *fakestore.FakeStore
+		)
+
+		newRange := func(cidr string, weight int) Range {
+			subnet, err := types.ParseCIDR(cidr)
+			Expect(err).NotTo(HaveOccurred())
+			return Range{Subnet: types.IPNet{IP: subnet.IP, Mask: subnet.Mask}, Weight: weight}
+		}
+
+		BeforeEach(func() {
+			conf = IPAMConfig{
+				Name: "test",
+				Type: "host-local",
+				Ranges: []Range{
+					newRange("10.0.0.0/30", 3),
+					newRange("10.0.1.0/29", 1),
+				},
+			}
+			store = fakestore.NewFakeStore(map[string]string{}, nil)
+		})
+
+		allocate := func(n int) []string {
+			var ips []string
+			for i := 0; i < n; i++ {
+				alloc, err := NewIPAllocator(&conf, store)
+				Expect(err).NotTo(HaveOccurred())
+				res, err := alloc.Get(fmt.Sprintf("ID%d", i), "eth0")
+				Expect(err).NotTo(HaveOccurred())
+				ips = append(ips, res.IP.String())
+			}
+			return ips
+		}
+
+		It("fills the ranges in order by default", func() {
+			// leave a single free address in the first range
+			store = fakestore.NewFakeStore(map[string]string{"10.0.0.3": "other"}, nil)
+
+			Expect(allocate(3)).To(Equal([]string{"10.0.0.2/30", "10.0.1.2/29", "10.0.1.3/29"}))
+		})
+
+		It("alternates between the ranges with round-robin", func() {
+			conf.RangePolicy = "round-robin"
+			conf.Ranges = append(conf.Ranges, newRange("10.0.2.0/29", 1))
+
+			Expect(allocate(4)).To(Equal([]string{"10.0.0.2/30", "10.0.1.2/29", "10.0.2.2/29", "10.0.1.3/29"}))
+		})
+
+		It("allocates in proportion to the weights with weighted", func() {
+			conf.RangePolicy = "weighted"
+			conf.Ranges = []Range{newRange("10.0.0.0/24", 3), newRange("10.0.1.0/24", 1)}
+
+			Expect(allocate(5)).To(Equal([]string{"10.0.0.2/24", "10.0.1.2/24", "10.0.0.3/24", "10.0.0.4/24", "10.0.0.5/24"}))
+		})
+
+		It("drains ranges of weight 0 with weighted", func() {
+			conf.RangePolicy = "weighted"
+			conf.Ranges[0].Weight = 0
+
+			Expect(allocate(2)).To(Equal([]string{"10.0.1.2/29", "10.0.1.3/29"}))
+		})
+
+		It("uses the gateway of the range of a requested IP", func() {
+			conf.Ranges[1].Gateway = net.ParseIP("10.0.1.6")
+			conf.Args = &IPAMArgs{IP: net.ParseIP("10.0.1.5")}
+
+			alloc, err := NewIPAllocator(&conf, store)
+			Expect(err).NotTo(HaveOccurred())
+			res, err := alloc.Get("ID", "eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.IP.String()).To(Equal("10.0.1.5/29"))
+			Expect(res.Gateway.String()).To(Equal("10.0.1.6"))
+
+			conf.Args.IP = net.ParseIP("10.0.2.5")
+			_, err = alloc.Get("ID2", "eth0")
+			Expect(err).To(MatchError("10.0.2.5 not in any range of network: test"))
+		})
+
+		It("rejects invalid configurations", func() {
+			conf.RangePolicy = "random"
+			_, err := NewIPAllocator(&conf, store)
+			Expect(err).To(MatchError(`unknown rangePolicy "random"`))
+
+			conf.RangePolicy = "weighted"
+			conf.Ranges[0].Weight, conf.Ranges[1].Weight = 0, 0
+			_, err = NewIPAllocator(&conf, store)
+			Expect(err).To(MatchError(`rangePolicy "weighted" requires a range with a positive weight`))
+
+			conf.RangePolicy = ""
+			conf.Subnet = conf.Ranges[0].Subnet
+			_, err = NewIPAllocator(&conf, store)
+			Expect(err).To(MatchError(`"ranges" cannot be combined with "subnet", "rangeStart", "rangeEnd" or "gateway"`))
+		})
+	})
+
+	Context("with conflict checking", func() {
+		var (
+			conf     IPAMConfig
+			store    *fakestore.FakeStore
+			squatted map[string]bool
+		)
+
+		BeforeEach(func() {
+			subnet, err := types.ParseCIDR("10.0.0.0/24")
+			Expect(err).NotTo(HaveOccurred())
+			conf = IPAMConfig{
+				Name:                   "test",
+				Type:                   "host-local",
+				Subnet:                 types.IPNet{IP: subnet.IP, Mask: subnet.Mask},
+				CheckConflict:          "arping",
+				CheckConflictInterface: "cni0",
+			}
+			store = fakestore.NewFakeStore(map[string]string{}, nil)
+			squatted = map[string]bool{"10.0.0.2": true, "10.0.0.3": true}
+		})
+
+		newAllocator := func() *IPAllocator {
+			alloc, err := NewIPAllocator(&conf, store)
+			Expect(err).NotTo(HaveOccurred())
+			alloc.inUse = func(addr net.IP) (bool, error) {
+				return squatted[addr.String()], nil
+			}
+			return alloc
+		}
+
+		It("skips addresses another host answers for", func() {
+			res, err := newAllocator().Get("ID", "eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.IP.IP.String()).To(Equal("10.0.0.4"))
+			Expect(store.IPMap()).To(Equal(map[string]string{"10.0.0.4": "ID"}))
+		})
+
+		It("refuses a requested address another host answers for", func() {
+			conf.Args = &IPAMArgs{IP: net.ParseIP("10.0.0.3")}
+
+			_, err := newAllocator().Get("ID", "eth0")
+			Expect(err).To(MatchError(`requested IP address "10.0.0.3" is not available in network: test`))
+			Expect(store.IPMap()).To(BeEmpty())
+		})
+
+		It("releases the address and fails if the check fails", func() {
+			alloc := newAllocator()
+			alloc.inUse = func(net.IP) (bool, error) { return false, errors.New("no such device") }
+
+			_, err := alloc.Get("ID", "eth0")
+			Expect(err).To(MatchError(`failed to check 10.0.0.2 for conflicts on "cni0": no such device`))
+			Expect(store.IPMap()).To(BeEmpty())
+		})
+
+		It("requires checkConflictInterface", func() {
+			conf.CheckConflictInterface = ""
+
+			_, err := NewIPAllocator(&conf, store)
+			Expect(err).To(MatchError(`"checkConflict" requires "checkConflictInterface"`))
+		})
+
+		It("rejects an unknown mode", func() {
+			conf.CheckConflict = "ping"
+
+			_, err := NewIPAllocator(&conf, store)
+			Expect(err).To(MatchError(`unknown checkConflict mode "ping"`))
+		})
+	})
+
+	Context("with stable-hash allocation", func() {
+		var (
+			conf  IPAMConfig
+			store *fakestore.FakeStore
+		)
+
+		BeforeEach(func() {
+			subnet, err := types.ParseCIDR("2001:db8::/64")
+			Expect(err).NotTo(HaveOccurred())
+			conf = IPAMConfig{
+				Name:       "test",
+				Type:       "host-local",
+				Subnet:     types.IPNet{IP: subnet.IP, Mask: subnet.Mask},
+				Allocation: "stable-hash",
+			}
+			store = fakestore.NewFakeStore(map[string]string{}, nil)
+		})
+
+		get := func(id string) net.IP {
+			alloc, err := NewIPAllocator(&conf, store)
+			Expect(err).NotTo(HaveOccurred())
+			res, err := alloc.Get(id, "eth0")
+			Expect(err).NotTo(HaveOccurred())
+			return res.IP.IP
+		}
+
+		It("derives the same address for the same container and network", func() {
+			first := get("ID")
+			Expect(first.Equal(net.ParseIP("2001:db8::2"))).To(BeFalse())
+			Expect((*net.IPNet)(&conf.Subnet).Contains(first)).To(BeTrue())
+
+			Expect(store.ReleaseByID("ID")).To(Succeed())
+			Expect(get("ID")).To(Equal(first))
+
+			other := get("ID2")
+			Expect(other).NotTo(Equal(first))
+
+			Expect(store.ReleaseByID("ID")).To(Succeed())
+			conf.Name = "other"
+			Expect(get("ID")).NotTo(Equal(first))
+		})
+
+		It("takes the next free address when the derived one is taken", func() {
+			derived := get("ID")
+			Expect(store.ReleaseByID("ID")).To(Succeed())
+			store = fakestore.NewFakeStore(map[string]string{derived.String(): "other"}, nil)
+
+			next := make(net.IP, len(derived))
+			copy(next, derived)
+			next[len(next)-1]++
+			Expect(get("ID").String()).To(Equal(next.String()))
+		})
+
+		It("stays within rangeStart and rangeEnd", func() {
+			conf.RangeStart = net.ParseIP("2001:db8::10")
+			conf.RangeEnd = net.ParseIP("2001:db8::13")
+
+			var ips []string
+			for i := 0; i < 4; i++ {
+				ips = append(ips, get(fmt.Sprintf("ID%d", i)).String())
+			}
+			Expect(ips).To(ConsistOf("2001:db8::10", "2001:db8::11", "2001:db8::12", "2001:db8::13"))
+
+			alloc, err := NewIPAllocator(&conf, store)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = alloc.Get("ID4", "eth0")
+			Expect(err).To(MatchError("no IP addresses available in network: test"))
+		})
+
+		It("allocates IPv4 ranges sequentially", func() {
+			subnet, err := types.ParseCIDR("10.0.0.0/24")
+			Expect(err).NotTo(HaveOccurred())
+			conf.Subnet = types.IPNet{IP: subnet.IP, Mask: subnet.Mask}
+
+			Expect(get("ID").String()).To(Equal("10.0.0.2"))
+			Expect(get("ID2").String()).To(Equal("10.0.0.3"))
+		})
+
+		It("rejects an unknown mode", func() {
+			conf.Allocation = "random"
+
+			_, err := NewIPAllocator(&conf, store)
+			Expect(err).To(MatchError(`unknown allocation mode "random"`))
+		})
+	})
+
 })
@@ -0,0 +1,156 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/logging"
+	"github.com/containernetworking/cni/plugins/ipam/host-local/backend"
+)
+
+// auditRecord is a line of the audit log, written for every IP reserved
+// or released
+type auditRecord struct {
+	Time        time.Time `json:"time"`
+	Op          string    `json:"op"`
+	Network     string    `json:"network"`
+	ContainerID string    `json:"containerID"`
+	IP          string    `json:"ip"`
+	// Result is "success" or "failure", in which case Error says why
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// auditStore records the reservations and releases of a network in an
+// append-only log, one JSON object per line
+type auditStore struct {
+	backend.Store
+	log     *os.File
+	network string
+	now     func() time.Time
+}
+
+// newAuditStore wraps store to append to the audit log at path, creating
+// it if needed
+func newAuditStore(store backend.Store, path, network string) (*auditStore, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditStore{
+		Store:   store,
+		log:     f,
+		network: network,
+		now:     time.Now,
+	}, nil
+}
+
+func (s *auditStore) write(op, id string, ip net.IP, opErr error) error {
+	r := auditRecord{
+		Time:        s.now().UTC(),
+		Op:          op,
+		Network:     s.network,
+		ContainerID: id,
+		IP:          ip.String(),
+		Result:      "success",
+	}
+	if opErr != nil {
+		r.Result = "failure"
+		r.Error = opErr.Error()
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	// a single write keeps concurrent appends from interleaving
+	_, err = s.log.Write(append(data, '\n'))
+	return err
+}
+
+func (s *auditStore) Close() error {
+	err := s.Store.Close()
+	if closeErr := s.log.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Reserve records successful and failed reservations, but not finding ip
+// taken. A reservation that cannot be recorded is undone, so that the log
+// accounts for every IP held.
+func (s *auditStore) Reserve(r backend.Reservation, ip net.IP) (bool, error) {
+	reserved, err := s.Store.Reserve(r, ip)
+	if err == nil && !reserved {
+		return false, nil
+	}
+
+	if auditErr := s.write("reserve", r.ID, ip, err); auditErr != nil {
+		if reserved {
+			if releaseErr := s.Store.Release(ip); releaseErr != nil {
+				logging.Errorf("failed to release %v after failing to audit it: %v", ip, releaseErr)
+			}
+		}
+		return false, auditErr
+	}
+	return reserved, err
+}
+
+// Release and ReleaseByID only warn if the release cannot be recorded:
+// the IP is free already, and failing DEL would not bring the record back
+func (s *auditStore) Release(ip net.IP) error {
+	reservations, err := s.Store.Reservations()
+	if err != nil {
+		return err
+	}
+	id, held := reservations[ip.String()]
+
+	err = s.Store.Release(ip)
+	if held {
+		s.warnOnWriteError("release", id, ip, err)
+	}
+	return err
+}
+
+func (s *auditStore) ReleaseByID(id string) error {
+	reservations, err := s.Store.Reservations()
+	if err != nil {
+		return err
+	}
+	var ips []string
+	for ip, holder := range reservations {
+		if holder == id {
+			ips = append(ips, ip)
+		}
+	}
+	sort.Strings(ips)
+
+	err = s.Store.ReleaseByID(id)
+	for _, ip := range ips {
+		s.warnOnWriteError("release", id, net.ParseIP(ip), err)
+	}
+	return err
+}
+
+func (s *auditStore) warnOnWriteError(op, id string, ip net.IP, opErr error) {
+	if err := s.write(op, id, ip, opErr); err != nil {
+		logging.Warnf("failed to write audit record of %s of %v: %v", op, ip, err)
+	}
+}
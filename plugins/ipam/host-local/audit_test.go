@@ -0,0 +1,126 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/plugins/ipam/host-local/backend"
+	fakestore "github.com/containernetworking/cni/plugins/ipam/host-local/backend/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("host-local audit log", func() {
+	var (
+		dir     string
+		path    string
+		store   *fakestore.FakeStore
+		audited *auditStore
+		now     time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "host-local-audit")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "audit.log")
+
+		store = fakestore.NewFakeStore(map[string]string{"10.0.0.5": "b"}, nil)
+		audited, err = newAuditStore(store, path, "test")
+		Expect(err).NotTo(HaveOccurred())
+		now = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+		audited.now = func() time.Time { return now }
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	records := func() []auditRecord {
+		f, err := os.Open(path)
+		Expect(err).NotTo(HaveOccurred())
+		defer f.Close()
+
+		var rs []auditRecord
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var r auditRecord
+			Expect(json.Unmarshal(scanner.Bytes(), &r)).To(Succeed())
+			rs = append(rs, r)
+		}
+		Expect(scanner.Err()).NotTo(HaveOccurred())
+		return rs
+	}
+
+	It("records the IPs allocated and released for a container", func() {
+		subnet, err := types.ParseCIDR("10.0.0.0/29")
+		Expect(err).NotTo(HaveOccurred())
+		conf := &IPAMConfig{
+			Name:   "test",
+			Type:   "host-local",
+			Subnet: types.IPNet{IP: subnet.IP, Mask: subnet.Mask},
+		}
+		alloc, err := NewIPAllocator(conf, audited)
+		Expect(err).NotTo(HaveOccurred())
+
+		ipConf, err := alloc.Get("a", "eth0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ipConf.IP.IP.String()).To(Equal("10.0.0.2"))
+		now = now.Add(time.Hour)
+		Expect(alloc.Release("a")).To(Succeed())
+		Expect(alloc.Release("a")).To(Succeed())
+
+		Expect(records()).To(Equal([]auditRecord{
+			{Time: now.Add(-time.Hour), Op: "reserve", Network: "test", ContainerID: "a", IP: "10.0.0.2", Result: "success"},
+			{Time: now, Op: "release", Network: "test", ContainerID: "a", IP: "10.0.0.2", Result: "success"},
+		}))
+	})
+
+	It("records failures but not IPs found taken", func() {
+		reserved, err := audited.Reserve(backend.Reservation{ID: "a"}, net.ParseIP("10.0.0.5"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reserved).To(BeFalse())
+
+		store.InjectError("Reserve", errors.New("disk full"), 1)
+		_, err = audited.Reserve(backend.Reservation{ID: "a"}, net.ParseIP("10.0.0.6"))
+		Expect(err).To(MatchError("disk full"))
+
+		store.InjectError("Release", errors.New("read-only"), 1)
+		Expect(audited.Release(net.ParseIP("10.0.0.5"))).To(MatchError("read-only"))
+
+		Expect(records()).To(Equal([]auditRecord{
+			{Time: now, Op: "reserve", Network: "test", ContainerID: "a", IP: "10.0.0.6", Result: "failure", Error: "disk full"},
+			{Time: now, Op: "release", Network: "test", ContainerID: "b", IP: "10.0.0.5", Result: "failure", Error: "read-only"},
+		}))
+	})
+
+	It("undoes a reservation that cannot be recorded", func() {
+		Expect(audited.log.Close()).To(Succeed())
+
+		reserved, err := audited.Reserve(backend.Reservation{ID: "a"}, net.ParseIP("10.0.0.6"))
+		Expect(err).To(HaveOccurred())
+		Expect(reserved).To(BeFalse())
+		Expect(store.IPMap()).To(Equal(map[string]string{"10.0.0.5": "b"}))
+	})
+})
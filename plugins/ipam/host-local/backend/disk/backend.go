@@ -15,24 +15,40 @@
 package disk
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/containernetworking/cni/plugins/ipam/host-local/backend"
 )
 
 const lastIPFile = "last_reserved_ip"
 
 var defaultDataDir = "/var/lib/cni/networks"
 
+// errReadOnly is returned by the changes of a store opened with NewReadOnly
+var errReadOnly = errors.New("store is read-only")
+
 type Store struct {
 	FileLock
 	dataDir string
+	// readOnly is set for stores opened with NewReadOnly
+	readOnly bool
 }
 
-func New(network string) (*Store, error) {
-	dir := filepath.Join(defaultDataDir, network)
+// New opens the store of network in dataDir, /var/lib/cni/networks if
+// dataDir is empty, creating it if need be
+func New(dataDir, network string) (*Store, error) {
+	if dataDir == "" {
+		dataDir = defaultDataDir
+	}
+	dir := filepath.Join(dataDir, network)
 	if err := os.MkdirAll(dir, 0644); err != nil {
 		return nil, err
 	}
@@ -41,10 +57,32 @@ func New(network string) (*Store, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Store{*lk, dir}, nil
+	return &Store{FileLock: *lk, dataDir: dir}, nil
 }
 
-func (s *Store) Reserve(id string, ip net.IP) (bool, error) {
+// NewReadOnly opens the existing store of network in dataDir, e.g. the
+// replica of the store of another node, for reading only: reserving and
+// releasing fail, and the index is read but never written.
+func NewReadOnly(dataDir, network string) (*Store, error) {
+	dir := filepath.Join(dataDir, network)
+	lk, err := NewFileLock(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{FileLock: *lk, dataDir: dir, readOnly: true}, nil
+}
+
+// Reserve writes the reservation file of ip, the JSON encoding of r
+func (s *Store) Reserve(r backend.Reservation, ip net.IP) (bool, error) {
+	if s.readOnly {
+		return false, errReadOnly
+	}
+	data, err := json.Marshal(&r)
+	if err != nil {
+		return false, err
+	}
+	indexed := s.indexCurrent()
+
 	fname := filepath.Join(s.dataDir, ip.String())
 	f, err := os.OpenFile(fname, os.O_RDWR|os.O_EXCL|os.O_CREATE, 0644)
 	if os.IsExist(err) {
@@ -53,7 +91,7 @@ func (s *Store) Reserve(id string, ip net.IP) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	if _, err := f.WriteString(id); err != nil {
+	if _, err := f.Write(data); err != nil {
 		f.Close()
 		os.Remove(f.Name())
 		return false, err
@@ -68,6 +106,10 @@ func (s *Store) Reserve(id string, ip net.IP) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+
+	if indexed {
+		s.record("reserve", ip.String(), r.ID)
+	}
 	return true, nil
 }
 
@@ -82,26 +124,79 @@ func (s *Store) LastReservedIP() (net.IP, error) {
 }
 
 func (s *Store) Release(ip net.IP) error {
-	return os.Remove(filepath.Join(s.dataDir, ip.String()))
+	if s.readOnly {
+		return errReadOnly
+	}
+	indexed := s.indexCurrent()
+
+	if err := os.Remove(filepath.Join(s.dataDir, ip.String())); err != nil {
+		return err
+	}
+	if indexed {
+		s.record("release", ip.String(), "")
+	}
+	return nil
 }
 
 // N.B. This function eats errors to be tolerant and
 // release as much as possible
 func (s *Store) ReleaseByID(id string) error {
-	err := filepath.Walk(s.dataDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
-			return nil
+	if s.readOnly {
+		return errReadOnly
+	}
+	reservations, err := s.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	for ip, reservedID := range reservations {
+		if reservedID != id {
+			continue
 		}
-		data, err := ioutil.ReadFile(path)
-		if err != nil {
-			return nil
+		if err := os.Remove(filepath.Join(s.dataDir, ip)); err != nil {
+			continue
 		}
-		if string(data) == id {
-			if err := os.Remove(path); err != nil {
-				return nil
-			}
+		s.record("release", ip, "")
+	}
+	return nil
+}
+
+func (s *Store) CountByIDPrefix(prefix string) (int, error) {
+	reservations, err := s.loadIndex()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, id := range reservations {
+		if strings.HasPrefix(id, prefix) {
+			n++
 		}
-		return nil
-	})
-	return err
+	}
+	return n, nil
+}
+
+func (s *Store) Reservations() (map[string]string, error) {
+	return s.loadIndex()
+}
+
+func (s *Store) Reservation(ip net.IP) (*backend.Reservation, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.dataDir, ip.String()))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseReservation(data), nil
+}
+
+// parseReservation decodes a reservation file. Files written by older
+// versions of the plugin hold the bare ID, which cannot start with '{'.
+func parseReservation(data []byte) *backend.Reservation {
+	r := &backend.Reservation{}
+	if bytes.HasPrefix(data, []byte("{")) && json.Unmarshal(data, r) == nil {
+		return r
+	}
+	return &backend.Reservation{ID: string(data)}
 }
@@ -0,0 +1,77 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package disk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/containernetworking/cni/plugins/ipam/host-local/backend"
+)
+
+// benchmarkStartup measures what every ADD and DEL does first, opening
+// the store and counting its reservations, on a network with live
+// reservations and history allocations made and released before
+func benchmarkStartup(b *testing.B, live, history int) {
+	dir, err := ioutil.TempDir("", "host-local-disk")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := New(dir, "test")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := s.Reservations(); err != nil {
+		b.Fatal(err)
+	}
+	reserve := func(id, ip string) {
+		if ok, err := s.Reserve(backend.Reservation{ID: id}, net.ParseIP(ip)); err != nil || !ok {
+			b.Fatalf("failed to reserve %s for %s: %v %v", ip, id, ok, err)
+		}
+	}
+	ip := net.ParseIP("10.0.0.0").To4()
+	for i := 0; i < live; i++ {
+		ip[2], ip[3] = byte(i>>8), byte(i)
+		reserve(fmt.Sprintf("live-%d", i), ip.String())
+	}
+	for i := 0; i < history; i++ {
+		reserve(fmt.Sprintf("old-%d", i), "10.1.0.1")
+		if err := s.Release(net.ParseIP("10.1.0.1")); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s, err := New(dir, "test")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := s.CountByIDPrefix("live-"); err != nil {
+			b.Fatal(err)
+		}
+		s.Close()
+	}
+}
+
+func BenchmarkStartup100Live(b *testing.B)             { benchmarkStartup(b, 100, 0) }
+func BenchmarkStartup100Live10000History(b *testing.B) { benchmarkStartup(b, 100, 10000) }
+func BenchmarkStartup100Live20000History(b *testing.B) { benchmarkStartup(b, 100, 20000) }
@@ -0,0 +1,176 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package disk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/plugins/ipam/host-local/backend"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// touchLater makes sure that the next change of the data directory
+// gets a modification time of its own
+func touchLater() {
+	time.Sleep(20 * time.Millisecond)
+}
+
+var _ = Describe("disk store", func() {
+	var (
+		dataDir string
+		s       *Store
+	)
+
+	BeforeEach(func() {
+		var err error
+		dataDir, err = ioutil.TempDir("", "host-local-disk")
+		Expect(err).NotTo(HaveOccurred())
+		s, err = New(dataDir, "test")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		s.Close()
+		Expect(os.RemoveAll(dataDir)).To(Succeed())
+	})
+
+	reserve := func(s *Store, id, ip string) {
+		ok, err := s.Reserve(backend.Reservation{ID: id}, net.ParseIP(ip))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue(), "%s is taken", ip)
+	}
+
+	reservations := func(s *Store) map[string]string {
+		r, err := s.Reservations()
+		Expect(err).NotTo(HaveOccurred())
+		return r
+	}
+
+	It("keeps the index in step with reservations and releases", func() {
+		Expect(reservations(s)).To(BeEmpty())
+		reserve(s, "a-1", "10.0.0.2")
+		reserve(s, "a-2", "10.0.0.3")
+		reserve(s, "b-1", "10.0.0.4")
+		reserve(s, "b-1", "10.0.0.5")
+		Expect(s.indexCurrent()).To(BeTrue())
+
+		Expect(s.Release(net.ParseIP("10.0.0.3"))).To(Succeed())
+		Expect(s.ReleaseByID("b-1")).To(Succeed())
+		Expect(s.indexCurrent()).To(BeTrue())
+
+		Expect(reservations(s)).To(Equal(map[string]string{"10.0.0.2": "a-1"}))
+		Expect(s.CountByIDPrefix("a-")).To(Equal(1))
+	})
+
+	It("rebuilds the index after a change from outside", func() {
+		reserve(s, "a", "10.0.0.2")
+		reserve(s, "b", "10.0.0.3")
+		Expect(reservations(s)).To(Equal(map[string]string{"10.0.0.2": "a", "10.0.0.3": "b"}))
+
+		// e.g. an older plugin version or an operator edits the reservations
+		touchLater()
+		Expect(os.Remove(filepath.Join(s.dataDir, "10.0.0.2"))).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(s.dataDir, "10.0.0.4"), []byte("c"), 0644)).To(Succeed())
+		Expect(s.indexCurrent()).To(BeFalse())
+
+		Expect(reservations(s)).To(Equal(map[string]string{"10.0.0.3": "b", "10.0.0.4": "c"}))
+		Expect(s.indexCurrent()).To(BeTrue())
+	})
+
+	It("rebuilds the index after a torn journal entry", func() {
+		reserve(s, "a", "10.0.0.2")
+		Expect(reservations(s)).To(Equal(map[string]string{"10.0.0.2": "a"}))
+
+		journal := filepath.Join(s.dataDir, indexDir, journalFile)
+		f, err := os.OpenFile(journal, os.O_WRONLY|os.O_APPEND, 0644)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = f.WriteString(`{"op":"reserve","ip":"10.0.`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		Expect(reservations(s)).To(Equal(map[string]string{"10.0.0.2": "a"}))
+	})
+
+	It("compacts the journal", func() {
+		Expect(reservations(s)).To(BeEmpty())
+		for i := 0; i <= compactAfter; i++ {
+			reserve(s, fmt.Sprintf("c-%d", i), "10.0.0.2")
+			Expect(s.Release(net.ParseIP("10.0.0.2"))).To(Succeed())
+		}
+		reserve(s, "last", "10.0.0.3")
+
+		Expect(reservations(s)).To(Equal(map[string]string{"10.0.0.3": "last"}))
+		data, err := ioutil.ReadFile(filepath.Join(s.dataDir, indexDir, journalFile))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(strings.Count(string(data), "\n")).To(Equal(1))
+		Expect(reservations(s)).To(Equal(map[string]string{"10.0.0.3": "last"}))
+	})
+
+	It("records the attachment of a reservation", func() {
+		r := backend.Reservation{
+			ID:         "a",
+			IfName:     "eth1",
+			Allocated:  time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+			ConfigHash: "sha256:abc",
+		}
+		ok, err := s.Reserve(r, net.ParseIP("10.0.0.2"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		Expect(s.Reservation(net.ParseIP("10.0.0.2"))).To(Equal(&r))
+
+		// reservations written by older versions only hold the ID
+		Expect(ioutil.WriteFile(filepath.Join(s.dataDir, "10.0.0.3"), []byte("b"), 0644)).To(Succeed())
+		Expect(s.Reservation(net.ParseIP("10.0.0.3"))).To(Equal(&backend.Reservation{ID: "b"}))
+		Expect(reservations(s)).To(Equal(map[string]string{"10.0.0.2": "a", "10.0.0.3": "b"}))
+
+		Expect(s.Reservation(net.ParseIP("10.0.0.4"))).To(BeNil())
+	})
+
+	It("opens a store for reading only", func() {
+		reserve(s, "a-1", "10.0.0.2")
+
+		ro, err := NewReadOnly(dataDir, "test")
+		Expect(err).NotTo(HaveOccurred())
+		defer ro.Close()
+
+		// a scan of a read-only store does not rebuild the index
+		Expect(os.RemoveAll(filepath.Join(dataDir, "test", indexDir))).To(Succeed())
+		Expect(reservations(ro)).To(Equal(map[string]string{"10.0.0.2": "a-1"}))
+		_, err = os.Stat(filepath.Join(dataDir, "test", indexDir))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+
+		_, err = ro.Reserve(backend.Reservation{ID: "b-1"}, net.ParseIP("10.0.0.3"))
+		Expect(err).To(Equal(errReadOnly))
+		Expect(ro.Release(net.ParseIP("10.0.0.2"))).To(Equal(errReadOnly))
+		Expect(ro.ReleaseByID("a-1")).To(Equal(errReadOnly))
+		Expect(reservations(s)).To(Equal(map[string]string{"10.0.0.2": "a-1"}))
+
+		_, err = NewReadOnly(dataDir, "missing")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("keeps the store of a network in its data directory", func() {
+		reserve(s, "a", "10.0.0.2")
+		Expect(filepath.Join(dataDir, "test", "10.0.0.2")).To(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,269 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package disk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// indexDir holds the index of the reservations of a network, so that
+// the store does not have to read every reservation file to count or
+// release them. It is a directory of its own so that writing the index
+// leaves the modification time of the data directory alone.
+//
+// The index is a snapshot of the reservations plus a journal of the
+// reservations and releases since. Every journal entry records the
+// modification time of the data directory after the change, so the
+// index is known to be current if the last entry matches it; reservation
+// files added or removed by anything but the store invalidate it, and it
+// is rebuilt from the files the next time it is needed. The journal is
+// folded into a new snapshot once it grows longer than compactAfter
+// entries, which keeps loading the index independent of how many
+// allocations the network has seen.
+const (
+	indexDir     = ".index"
+	snapshotFile = "reservations.json"
+	journalFile  = "journal"
+	compactAfter = 1000
+)
+
+type journalEntry struct {
+	// Op is "reserve", "release" or, as the first entry after a
+	// compaction, "snapshot"
+	Op         string `json:"op"`
+	IP         string `json:"ip,omitempty"`
+	ID         string `json:"id,omitempty"`
+	DirModTime int64  `json:"dirModTime"`
+}
+
+var errTornJournal = errors.New("journal does not end with a complete entry")
+
+// indexCurrent reports whether the index reflects the reservation files.
+// It only reads the end of the journal.
+func (s *Store) indexCurrent() bool {
+	modTime, err := s.dirModTime()
+	if err != nil {
+		return false
+	}
+	last, err := lastJournalEntry(filepath.Join(s.dataDir, indexDir, journalFile))
+	return err == nil && last.DirModTime == modTime
+}
+
+// record appends a change of the reservation files to the journal. It
+// must only be called if the index was current before the change. A
+// failure is not fatal: the journal then does not match the data
+// directory and the index is rebuilt when it is next needed.
+func (s *Store) record(op, ip, id string) {
+	modTime, err := s.dirModTime()
+	if err != nil {
+		return
+	}
+	line, err := json.Marshal(&journalEntry{Op: op, IP: ip, ID: id, DirModTime: modTime})
+	if err != nil {
+		return
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.dataDir, indexDir, journalFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+// loadIndex returns every reserved IP with the ID it is reserved for,
+// from the index if it is current and from the reservation files
+// otherwise, compacting or rebuilding the index as needed
+func (s *Store) loadIndex() (map[string]string, error) {
+	if s.indexCurrent() {
+		reservations, entries, err := s.readIndex()
+		if err == nil {
+			if entries > compactAfter {
+				s.compact(reservations)
+			}
+			return reservations, nil
+		}
+	}
+
+	reservations, err := s.scan()
+	if err != nil {
+		return nil, err
+	}
+	s.compact(reservations)
+	return reservations, nil
+}
+
+// readIndex replays the journal onto the snapshot and returns the
+// result along with the length of the journal
+func (s *Store) readIndex() (map[string]string, int, error) {
+	dir := filepath.Join(s.dataDir, indexDir)
+	data, err := ioutil.ReadFile(filepath.Join(dir, snapshotFile))
+	if err != nil {
+		return nil, 0, err
+	}
+	reservations := map[string]string{}
+	if err := json.Unmarshal(data, &reservations); err != nil {
+		return nil, 0, err
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(dir, journalFile))
+	if err != nil {
+		return nil, 0, err
+	}
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+	for _, line := range lines {
+		var e journalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, 0, err
+		}
+		switch e.Op {
+		case "reserve":
+			reservations[e.IP] = e.ID
+		case "release":
+			delete(reservations, e.IP)
+		}
+	}
+	return reservations, len(lines), nil
+}
+
+// compact writes reservations, which must reflect the reservation files,
+// as the new snapshot and starts a new journal. The index of a read-only
+// store is left as it is.
+func (s *Store) compact(reservations map[string]string) {
+	if s.readOnly {
+		return
+	}
+	dir := filepath.Join(s.dataDir, indexDir)
+	// creating the directory modifies the data directory, so it must
+	// happen before its modification time is recorded
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	modTime, err := s.dirModTime()
+	if err != nil {
+		return
+	}
+
+	snapshot, err := json.Marshal(reservations)
+	if err != nil {
+		return
+	}
+	header, err := json.Marshal(&journalEntry{Op: "snapshot", DirModTime: modTime})
+	if err != nil {
+		return
+	}
+
+	// a new snapshot with the old journal is still consistent, as
+	// replaying the journal again yields the same reservations
+	if writeFileAtomic(filepath.Join(dir, snapshotFile), snapshot) == nil {
+		writeFileAtomic(filepath.Join(dir, journalFile), append(header, '\n'))
+	}
+}
+
+// writeFileAtomic replaces path with data, so that readers see either
+// the old or the new content
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// lastJournalEntry reads the last entry of the journal at path without
+// reading the rest of it
+func lastJournalEntry(path string) (*journalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+
+	for window := int64(4096); ; window *= 2 {
+		if window > size {
+			window = size
+		}
+		buf := make([]byte, window)
+		if _, err := f.ReadAt(buf, size-window); err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(buf, []byte("\n")) {
+			return nil, errTornJournal
+		}
+		buf = buf[:len(buf)-1]
+
+		start := bytes.LastIndexByte(buf, '\n')
+		if start < 0 && window < size {
+			continue
+		}
+		e := &journalEntry{}
+		if err := json.Unmarshal(buf[start+1:], e); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+}
+
+func (s *Store) dirModTime() (int64, error) {
+	info, err := os.Stat(s.dataDir)
+	if err != nil {
+		return 0, err
+	}
+	return info.ModTime().UnixNano(), nil
+}
+
+// scan reads every reservation file of the network
+func (s *Store) scan() (map[string]string, error) {
+	files, err := ioutil.ReadDir(s.dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	reservations := map[string]string{}
+	for _, f := range files {
+		// every reservation is a file named after its IP
+		if f.IsDir() || net.ParseIP(f.Name()) == nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(s.dataDir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		reservations[f.Name()] = parseReservation(data).ID
+	}
+	return reservations, nil
+}
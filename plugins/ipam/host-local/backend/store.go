@@ -14,14 +14,37 @@
 
 package backend
 
-import "net"
+import (
+	"net"
+	"time"
+)
+
+// Reservation is what the store records about a reserved IP. Only ID is
+// known for reservations made by older versions of the plugin.
+type Reservation struct {
+	ID string `json:"id"`
+	// IfName is the container interface the IP was reserved for
+	IfName string `json:"ifName,omitempty"`
+	// Allocated is when the IP was reserved
+	Allocated time.Time `json:"allocated"`
+	// ConfigHash identifies the IPAM configuration the IP was reserved under
+	ConfigHash string `json:"configHash,omitempty"`
+}
 
 type Store interface {
 	Lock() error
 	Unlock() error
 	Close() error
-	Reserve(id string, ip net.IP) (bool, error)
+	Reserve(r Reservation, ip net.IP) (bool, error)
 	LastReservedIP() (net.IP, error)
 	Release(ip net.IP) error
 	ReleaseByID(id string) error
+	// CountByIDPrefix returns how many IPs are reserved for IDs starting
+	// with prefix; an empty prefix counts all of them
+	CountByIDPrefix(prefix string) (int, error)
+	// Reservations maps every reserved IP to the ID it is reserved for
+	Reservations() (map[string]string, error)
+	// Reservation returns what is recorded about ip, or nil if it is
+	// not reserved
+	Reservation(ip net.IP) (*Reservation, error)
 }
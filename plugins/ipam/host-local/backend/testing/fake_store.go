@@ -16,33 +16,138 @@ package testing
 
 import (
 	"net"
+	"strings"
+	"sync"
+
+	"github.com/containernetworking/cni/plugins/ipam/host-local/backend"
 )
 
+// Call is a call of a FakeStore method. ID and IP are only set for the
+// methods taking them.
+type Call struct {
+	Method string
+	ID     string
+	IP     net.IP
+}
+
+type injectedError struct {
+	err error
+	// times is how many more calls fail; negative means all of them
+	times int
+}
+
 type FakeStore struct {
 	ipMap          map[string]string
 	lastReservedIP net.IP
+	// details holds the reservations made through Reserve, by IP
+	details map[string]backend.Reservation
+
+	mu     sync.Mutex
+	calls  []Call
+	errors map[string]*injectedError
+	// racers maps IPs to the ID that reserves them concurrently, just
+	// before the next Reserve of the IP
+	racers map[string]string
 }
 
 func NewFakeStore(ipmap map[string]string, lastIP net.IP) *FakeStore {
-	return &FakeStore{ipmap, lastIP}
+	return &FakeStore{
+		ipMap:          ipmap,
+		lastReservedIP: lastIP,
+		details:        map[string]backend.Reservation{},
+		errors:         map[string]*injectedError{},
+		racers:         map[string]string{},
+	}
+}
+
+// InjectError makes the next times calls of method, e.g. "Reserve", fail
+// with err without touching the store. A negative times makes every
+// call fail.
+func (s *FakeStore) InjectError(method string, err error, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors[method] = &injectedError{err: err, times: times}
+}
+
+// ReserveConcurrently simulates another allocator reserving ip for id
+// between the time the allocator under test picks ip and reserves it:
+// the next Reserve of ip finds it taken.
+func (s *FakeStore) ReserveConcurrently(ip net.IP, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.racers[ip.String()] = id
+}
+
+// Calls returns the calls made to the store so far, oldest first
+func (s *FakeStore) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// IPMap returns a copy of the reservations, mapping IPs to IDs
+func (s *FakeStore) IPMap() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := map[string]string{}
+	for k, v := range s.ipMap {
+		m[k] = v
+	}
+	return m
+}
+
+// record logs the call and returns the error injected for it, if any.
+// It must be called with s.mu held.
+func (s *FakeStore) record(method, id string, ip net.IP) error {
+	s.calls = append(s.calls, Call{Method: method, ID: id, IP: ip})
+
+	injected := s.errors[method]
+	if injected == nil || injected.times == 0 {
+		return nil
+	}
+	if injected.times > 0 {
+		injected.times--
+	}
+	return injected.err
 }
 
 func (s *FakeStore) Lock() error {
-	return nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record("Lock", "", nil)
 }
 
 func (s *FakeStore) Unlock() error {
-	return nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record("Unlock", "", nil)
 }
 
 func (s *FakeStore) Close() error {
-	return nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record("Close", "", nil)
 }
 
-func (s *FakeStore) Reserve(id string, ip net.IP) (bool, error) {
+func (s *FakeStore) Reserve(r backend.Reservation, ip net.IP) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("Reserve", r.ID, ip); err != nil {
+		return false, err
+	}
+
 	key := ip.String()
+	if racer, ok := s.racers[key]; ok {
+		delete(s.racers, key)
+		if _, taken := s.ipMap[key]; !taken {
+			s.ipMap[key] = racer
+			s.lastReservedIP = ip
+		}
+	}
+
 	if _, ok := s.ipMap[key]; !ok {
-		s.ipMap[key] = id
+		s.ipMap[key] = r.ID
+		s.details[key] = r
 		s.lastReservedIP = ip
 		return true, nil
 	}
@@ -50,15 +155,32 @@ func (s *FakeStore) Reserve(id string, ip net.IP) (bool, error) {
 }
 
 func (s *FakeStore) LastReservedIP() (net.IP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("LastReservedIP", "", nil); err != nil {
+		return nil, err
+	}
 	return s.lastReservedIP, nil
 }
 
 func (s *FakeStore) Release(ip net.IP) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("Release", "", ip); err != nil {
+		return err
+	}
 	delete(s.ipMap, ip.String())
+	delete(s.details, ip.String())
 	return nil
 }
 
 func (s *FakeStore) ReleaseByID(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("ReleaseByID", id, nil); err != nil {
+		return err
+	}
+
 	toDelete := []string{}
 	for k, v := range s.ipMap {
 		if v == id {
@@ -67,6 +189,57 @@ func (s *FakeStore) ReleaseByID(id string) error {
 	}
 	for _, ip := range toDelete {
 		delete(s.ipMap, ip)
+		delete(s.details, ip)
 	}
 	return nil
 }
+
+func (s *FakeStore) CountByIDPrefix(prefix string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("CountByIDPrefix", prefix, nil); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, id := range s.ipMap {
+		if strings.HasPrefix(id, prefix) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *FakeStore) Reservations() (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("Reservations", "", nil); err != nil {
+		return nil, err
+	}
+
+	m := map[string]string{}
+	for k, v := range s.ipMap {
+		m[k] = v
+	}
+	return m, nil
+}
+
+// Reservation returns the reservation as made through Reserve, or only
+// its ID for those the store was created with
+func (s *FakeStore) Reservation(ip net.IP) (*backend.Reservation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("Reservation", "", ip); err != nil {
+		return nil, err
+	}
+
+	key := ip.String()
+	id, ok := s.ipMap[key]
+	if !ok {
+		return nil, nil
+	}
+	if r, ok := s.details[key]; ok {
+		return &r, nil
+	}
+	return &backend.Reservation{ID: id}, nil
+}
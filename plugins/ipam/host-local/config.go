@@ -15,10 +15,14 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"net"
+	"path/filepath"
+	"strings"
 
+	"github.com/containernetworking/cni/pkg/logging"
 	"github.com/containernetworking/cni/pkg/types"
 )
 
@@ -31,7 +35,62 @@ type IPAMConfig struct {
 	Subnet     types.IPNet   `json:"subnet"`
 	Gateway    net.IP        `json:"gateway"`
 	Routes     []types.Route `json:"routes"`
-	Args       *IPAMArgs     `json:"-"`
+	// PoolID names the store the IPs are allocated from, the network
+	// name if unset, so that several networks can share one pool or one
+	// network can be split over several
+	PoolID string `json:"poolID,omitempty"`
+	// DataDir is where the stores of the pools are, in place of
+	// /var/lib/cni/networks
+	DataDir string `json:"dataDir,omitempty"`
+	// Ranges replaces subnet, rangeStart, rangeEnd and gateway with
+	// several ranges, RangePolicy choosing the one each new allocation
+	// comes from
+	Ranges      []Range `json:"ranges,omitempty"`
+	RangePolicy string  `json:"rangePolicy,omitempty"`
+	// Allocation set to "stable-hash" derives the addresses of IPv6
+	// ranges from the network name and container ID instead of taking
+	// the next free one
+	Allocation string `json:"allocation,omitempty"`
+	// MaxAllocations caps the IPs reserved in the network, and
+	// MaxAllocationsPerPrefix those reserved for container IDs sharing
+	// their first IDPrefixLength characters; zero means no limit
+	MaxAllocations          int `json:"maxAllocations,omitempty"`
+	MaxAllocationsPerPrefix int `json:"maxAllocationsPerPrefix,omitempty"`
+	IDPrefixLength          int `json:"idPrefixLength,omitempty"`
+	// AuditLog is a file every reservation and release is appended to
+	AuditLog string `json:"auditLog,omitempty"`
+	// Hooks are run as IPs are reserved and released
+	Hooks *Hooks `json:"hooks,omitempty"`
+	// CheckConflict set to "arping" makes the allocator skip addresses
+	// another host answers for on CheckConflictInterface
+	CheckConflict          string `json:"checkConflict,omitempty"`
+	CheckConflictInterface string `json:"checkConflictInterface,omitempty"`
+	// Shared confines the allocations of the node to a sub-range of its
+	// own, and keeps them clear of a replicated store it only reads
+	Shared *Shared `json:"shared,omitempty"`
+	// Node makes the node allocate from its own sub-range of each
+	// subnet, derived from a key of the node
+	Node *NodeRange     `json:"node,omitempty"`
+	Args *IPAMArgs      `json:"-"`
+	Log  logging.Config `json:"-"`
+	// CNIVersion is that of the network configuration, and the version
+	// the result is printed in
+	CNIVersion string `json:"-"`
+
+	// configHash identifies the ipam section of the network
+	// configuration, and is recorded with every reservation
+	configHash string
+}
+
+// Range is one of the ranges of a network with several of them
+type Range struct {
+	Subnet     types.IPNet `json:"subnet"`
+	RangeStart net.IP      `json:"rangeStart,omitempty"`
+	RangeEnd   net.IP      `json:"rangeEnd,omitempty"`
+	Gateway    net.IP      `json:"gateway,omitempty"`
+	// Weight is the share of allocations the range gets with the
+	// weighted policy; a range of weight 0 gets none
+	Weight int `json:"weight,omitempty"`
 }
 
 type IPAMArgs struct {
@@ -40,8 +99,15 @@ type IPAMArgs struct {
 }
 
 type Net struct {
-	Name string      `json:"name"`
-	IPAM *IPAMConfig `json:"ipam"`
+	CNIVersion string         `json:"cniVersion"`
+	Name       string         `json:"name"`
+	IPAM       *IPAMConfig    `json:"ipam"`
+	Log        logging.Config `json:"log,omitempty"`
+}
+
+// rawNet is Net before its ipam section is decoded
+type rawNet struct {
+	IPAM json.RawMessage `json:"ipam"`
 }
 
 // NewIPAMConfig creates a NetworkConfig from the given network name.
@@ -65,6 +131,35 @@ func LoadIPAMConfig(bytes []byte, args string) (*IPAMConfig, error) {
 
 	// Copy net name into IPAM so not to drag Net struct around
 	n.IPAM.Name = n.Name
+	n.IPAM.Log = n.Log
+	n.IPAM.CNIVersion = n.CNIVersion
+
+	raw := rawNet{}
+	if err := json.Unmarshal(bytes, &raw); err != nil {
+		return nil, err
+	}
+	n.IPAM.configHash = fmt.Sprintf("sha256:%x", sha256.Sum256(raw.IPAM))
+
+	if !validPoolID(n.IPAM.PoolID) {
+		return nil, fmt.Errorf("invalid poolID %q", n.IPAM.PoolID)
+	}
+	if n.IPAM.Shared != nil && !validPoolID(n.IPAM.Shared.PoolID) {
+		return nil, fmt.Errorf("invalid poolID %q of the shared store", n.IPAM.Shared.PoolID)
+	}
 
 	return n.IPAM, nil
 }
+
+// validPoolID reports whether p can name a pool, which is a directory of
+// the data dir
+func validPoolID(p string) bool {
+	return p != "." && p != ".." && !strings.ContainsRune(p, filepath.Separator)
+}
+
+// pool returns the key of the store the IPs of c are allocated from
+func (c *IPAMConfig) pool() string {
+	if c.PoolID != "" {
+		return c.PoolID
+	}
+	return c.Name
+}
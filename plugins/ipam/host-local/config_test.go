@@ -0,0 +1,45 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("host-local config", func() {
+	load := func(ipam string) (*IPAMConfig, error) {
+		return LoadIPAMConfig([]byte(`{"name": "mynet", "ipam": `+ipam+`}`), "")
+	}
+
+	It("allocates from the pool of the network name by default", func() {
+		conf, err := load(`{"type": "host-local", "subnet": "10.1.2.0/24"}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.pool()).To(Equal("mynet"))
+	})
+
+	It("allocates from poolID when set", func() {
+		conf, err := load(`{"type": "host-local", "subnet": "10.1.2.0/24", "poolID": "shared"}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.pool()).To(Equal("shared"))
+	})
+
+	It("rejects a poolID outside the data dir", func() {
+		for _, id := range []string{"..", "../other", "a/b"} {
+			_, err := load(`{"type": "host-local", "subnet": "10.1.2.0/24", "poolID": "` + id + `"}`)
+			Expect(err).To(MatchError(`invalid poolID "` + id + `"`))
+		}
+	})
+})
@@ -0,0 +1,181 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/logging"
+	"github.com/containernetworking/cni/plugins/ipam/host-local/backend"
+)
+
+// defaultHookTimeout bounds the run of a hook without a timeout
+const defaultHookTimeout = 5 * time.Second
+
+// Hooks are commands run as IPs are reserved and released, e.g. to keep
+// an external IPAM database or DNS in sync with the network
+type Hooks struct {
+	// PreReserve runs once an IP is reserved, before it is handed out;
+	// the reservation is undone if it fails
+	PreReserve []string `json:"preReserve,omitempty"`
+	// PostRelease runs once an IP is released
+	PostRelease []string `json:"postRelease,omitempty"`
+	// Timeout is how many seconds a hook may run before it is killed
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// hookStore runs the hooks of a network around the reservations and
+// releases of its store
+type hookStore struct {
+	backend.Store
+	hooks   *Hooks
+	network string
+	timeout time.Duration
+}
+
+// newHookStore wraps store to run hooks
+func newHookStore(store backend.Store, hooks *Hooks, network string) (*hookStore, error) {
+	if hooks.Timeout < 0 {
+		return nil, fmt.Errorf("invalid hook timeout %d", hooks.Timeout)
+	}
+	timeout := time.Duration(hooks.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = defaultHookTimeout
+	}
+	return &hookStore{
+		Store:   store,
+		hooks:   hooks,
+		network: network,
+		timeout: timeout,
+	}, nil
+}
+
+// run runs the hook command for op on the lease of ip by id. The lease is
+// described by HOST_LOCAL_* variables added to the environment of the
+// plugin. A hook running past the timeout is killed, along with any
+// process it started.
+func (s *hookStore) run(command []string, op, id string, ip net.IP) error {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"HOST_LOCAL_HOOK="+op,
+		"HOST_LOCAL_NETWORK="+s.network,
+		"HOST_LOCAL_CONTAINER_ID="+id,
+		"HOST_LOCAL_IP="+ip.String(),
+	)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%s hook failed: %v", op, err)
+	}
+	killed := make(chan struct{})
+	timer := time.AfterFunc(s.timeout, func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		close(killed)
+	})
+	err := cmd.Wait()
+	timer.Stop()
+
+	select {
+	case <-killed:
+		return fmt.Errorf("%s hook timed out after %v", op, s.timeout)
+	default:
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %v: %s", op, err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// Reserve runs the pre-reserve hook on IPs it reserves, and releases them
+// again if the hook fails, failing the reservation
+func (s *hookStore) Reserve(r backend.Reservation, ip net.IP) (bool, error) {
+	reserved, err := s.Store.Reserve(r, ip)
+	if err != nil || !reserved || len(s.hooks.PreReserve) == 0 {
+		return reserved, err
+	}
+
+	if hookErr := s.run(s.hooks.PreReserve, "pre-reserve", r.ID, ip); hookErr != nil {
+		if releaseErr := s.Store.Release(ip); releaseErr != nil {
+			logging.Errorf("failed to release %v after its pre-reserve hook failed: %v", ip, releaseErr)
+		}
+		return false, hookErr
+	}
+	return true, nil
+}
+
+// Release and ReleaseByID run the post-release hook on every IP they
+// release, only warning if it fails: the IP is free already
+func (s *hookStore) Release(ip net.IP) error {
+	if len(s.hooks.PostRelease) == 0 {
+		return s.Store.Release(ip)
+	}
+
+	reservations, err := s.Store.Reservations()
+	if err != nil {
+		return err
+	}
+	id, held := reservations[ip.String()]
+
+	if err := s.Store.Release(ip); err != nil {
+		return err
+	}
+	if held {
+		s.warnOnHookError(id, ip)
+	}
+	return nil
+}
+
+func (s *hookStore) ReleaseByID(id string) error {
+	if len(s.hooks.PostRelease) == 0 {
+		return s.Store.ReleaseByID(id)
+	}
+
+	reservations, err := s.Store.Reservations()
+	if err != nil {
+		return err
+	}
+	var ips []string
+	for ip, holder := range reservations {
+		if holder == id {
+			ips = append(ips, ip)
+		}
+	}
+	sort.Strings(ips)
+
+	if err := s.Store.ReleaseByID(id); err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		s.warnOnHookError(id, net.ParseIP(ip))
+	}
+	return nil
+}
+
+func (s *hookStore) warnOnHookError(id string, ip net.IP) {
+	if err := s.run(s.hooks.PostRelease, "post-release", id, ip); err != nil {
+		logging.Warnf("%v", err)
+	}
+}
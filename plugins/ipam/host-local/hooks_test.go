@@ -0,0 +1,123 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containernetworking/cni/plugins/ipam/host-local/backend"
+	fakestore "github.com/containernetworking/cni/plugins/ipam/host-local/backend/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("host-local hooks", func() {
+	var (
+		dir   string
+		store *fakestore.FakeStore
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "host-local-hooks")
+		Expect(err).NotTo(HaveOccurred())
+		store = fakestore.NewFakeStore(map[string]string{"10.0.0.5": "b", "10.0.0.6": "b"}, nil)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	// recorder is a hook appending the lease it is run on to a file
+	recorder := func() ([]string, string) {
+		out := filepath.Join(dir, "leases")
+		return []string{"sh", "-c", `echo "$HOST_LOCAL_HOOK $HOST_LOCAL_NETWORK $HOST_LOCAL_CONTAINER_ID $HOST_LOCAL_IP" >> ` + out}, out
+	}
+
+	leases := func(path string) string {
+		data, err := ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		return string(data)
+	}
+
+	It("runs the pre-reserve hook on the IPs reserved", func() {
+		command, out := recorder()
+		hooked, err := newHookStore(store, &Hooks{PreReserve: command}, "test")
+		Expect(err).NotTo(HaveOccurred())
+
+		reserved, err := hooked.Reserve(backend.Reservation{ID: "a"}, net.ParseIP("10.0.0.2"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reserved).To(BeTrue())
+
+		// taken IPs are not handed out, so the hook does not hear of them
+		reserved, err = hooked.Reserve(backend.Reservation{ID: "a"}, net.ParseIP("10.0.0.5"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reserved).To(BeFalse())
+
+		Expect(leases(out)).To(Equal("pre-reserve test a 10.0.0.2\n"))
+		Expect(store.IPMap()).To(HaveKeyWithValue("10.0.0.2", "a"))
+	})
+
+	It("undoes the reservation if the pre-reserve hook fails", func() {
+		hooked, err := newHookStore(store, &Hooks{PreReserve: []string{"sh", "-c", "echo database down; exit 1"}}, "test")
+		Expect(err).NotTo(HaveOccurred())
+
+		reserved, err := hooked.Reserve(backend.Reservation{ID: "a"}, net.ParseIP("10.0.0.2"))
+		Expect(err).To(MatchError("pre-reserve hook failed: exit status 1: database down"))
+		Expect(reserved).To(BeFalse())
+		Expect(store.IPMap()).NotTo(HaveKey("10.0.0.2"))
+	})
+
+	It("kills hooks running past the timeout", func() {
+		hooked, err := newHookStore(store, &Hooks{PreReserve: []string{"sh", "-c", "sleep 30 & sleep 30"}, Timeout: 1}, "test")
+		Expect(err).NotTo(HaveOccurred())
+
+		start := time.Now()
+		_, err = hooked.Reserve(backend.Reservation{ID: "a"}, net.ParseIP("10.0.0.2"))
+		Expect(err).To(MatchError("pre-reserve hook timed out after 1s"))
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		Expect(store.IPMap()).NotTo(HaveKey("10.0.0.2"))
+	})
+
+	It("runs the post-release hook on every IP released", func() {
+		command, out := recorder()
+		hooked, err := newHookStore(store, &Hooks{PostRelease: command}, "test")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(hooked.ReleaseByID("b")).To(Succeed())
+		Expect(leases(out)).To(Equal("post-release test b 10.0.0.5\npost-release test b 10.0.0.6\n"))
+
+		// unreserved IPs were not handed out
+		Expect(hooked.Release(net.ParseIP("10.0.0.7"))).To(Succeed())
+		Expect(leases(out)).To(Equal("post-release test b 10.0.0.5\npost-release test b 10.0.0.6\n"))
+	})
+
+	It("releases IPs even if the post-release hook fails", func() {
+		hooked, err := newHookStore(store, &Hooks{PostRelease: []string{"false"}}, "test")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(hooked.Release(net.ParseIP("10.0.0.5"))).To(Succeed())
+		Expect(store.IPMap()).NotTo(HaveKey("10.0.0.5"))
+	})
+
+	It("rejects a negative timeout", func() {
+		_, err := newHookStore(store, &Hooks{Timeout: -1}, "test")
+		Expect(err).To(MatchError("invalid hook timeout -1"))
+	})
+})
@@ -15,14 +15,67 @@
 package main
 
 import (
+	"fmt"
+
+	"github.com/containernetworking/cni/plugins/ipam/host-local/backend"
 	"github.com/containernetworking/cni/plugins/ipam/host-local/backend/disk"
 
+	"github.com/containernetworking/cni/pkg/logging"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
 )
 
 func main() {
-	skel.PluginMain(cmdAdd, cmdDel)
+	skel.PluginMainWithSubcommands(skel.PluginFuncs{Add: cmdAdd, Del: cmdDel}, map[string]skel.Subcommand{
+		"migrate": &migrateCmd{},
+		"export":  &exportCmd{},
+		"import":  &importCmd{},
+	})
+}
+
+func setupLogging(conf *IPAMConfig, command string, args *skel.CmdArgs) (func(), error) {
+	return logging.Setup(conf.Log, "plugin", "host-local", "command", command,
+		"containerID", args.ContainerID, "network", conf.Name)
+}
+
+// openStore opens the store of the pool of conf, checking its
+// reservations against the replicated store, running its hooks and
+// recording changes in its audit log if it has them
+func openStore(conf *IPAMConfig) (backend.Store, error) {
+	d, err := disk.New(conf.DataDir, conf.pool())
+	if err != nil {
+		return nil, err
+	}
+	var store backend.Store = d
+
+	if conf.Shared != nil {
+		shared, err := newSharedStore(store, conf.Shared, conf.pool())
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		store = shared
+	}
+
+	if conf.Hooks != nil {
+		hooked, err := newHookStore(store, conf.Hooks, conf.Name)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		store = hooked
+	}
+	if conf.AuditLog == "" {
+		return store, nil
+	}
+
+	audited, err := newAuditStore(store, conf.AuditLog, conf.Name)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	return audited, nil
 }
 
 func cmdAdd(args *skel.CmdArgs) error {
@@ -31,7 +84,13 @@ func cmdAdd(args *skel.CmdArgs) error {
 		return err
 	}
 
-	store, err := disk.New(ipamConf.Name)
+	closeLog, err := setupLogging(ipamConf, "ADD", args)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	store, err := openStore(ipamConf)
 	if err != nil {
 		return err
 	}
@@ -42,15 +101,19 @@ func cmdAdd(args *skel.CmdArgs) error {
 		return err
 	}
 
-	ipConf, err := allocator.Get(args.ContainerID)
+	ipConf, err := allocator.Get(args.ContainerID, args.IfName)
 	if err != nil {
 		return err
 	}
+	logging.Infof("allocated %v", ipConf.IP.String())
 
-	r := &types.Result{
-		IP4: ipConf,
+	r := &types.Result{}
+	if ipConf.IP.IP.To4() != nil {
+		r.IP4 = ipConf
+	} else {
+		r.IP6 = ipConf
 	}
-	return r.Print()
+	return current.PrintResult(current.NewResultFromLegacy(r), ipamConf.CNIVersion)
 }
 
 func cmdDel(args *skel.CmdArgs) error {
@@ -59,7 +122,13 @@ func cmdDel(args *skel.CmdArgs) error {
 		return err
 	}
 
-	store, err := disk.New(ipamConf.Name)
+	closeLog, err := setupLogging(ipamConf, "DEL", args)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	store, err := openStore(ipamConf)
 	if err != nil {
 		return err
 	}
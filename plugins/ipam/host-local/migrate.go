@@ -0,0 +1,178 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+
+	"github.com/containernetworking/cni/pkg/ip"
+	"github.com/containernetworking/cni/pkg/ip/cidr"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/plugins/ipam/host-local/backend"
+)
+
+// migrationReport describes how the reservations of a network fit a
+// new configuration of its range
+type migrationReport struct {
+	Network   string     `json:"network"`
+	Kept      int        `json:"kept"`
+	Conflicts []conflict `json:"conflicts,omitempty"`
+	// Released is true once the conflicting reservations are gone
+	Released bool `json:"released,omitempty"`
+}
+
+// conflict is a reservation that the new configuration could not have
+// made, and that would clash with its allocations
+type conflict struct {
+	IP     string `json:"ip"`
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// migrate checks every reservation in store against conf and, if
+// release is set, releases those that conflict with it
+func migrate(conf *IPAMConfig, store backend.Store, release bool) (*migrationReport, error) {
+	// the allocator validates the new range
+	if _, err := NewIPAllocator(conf, store); err != nil {
+		return nil, err
+	}
+
+	if err := store.Lock(); err != nil {
+		return nil, err
+	}
+	defer store.Unlock()
+
+	reservations, err := store.Reservations()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &migrationReport{Network: conf.Name}
+	for s, id := range reservations {
+		if reason := rangeConflict(conf, net.ParseIP(s)); reason != "" {
+			report.Conflicts = append(report.Conflicts, conflict{IP: s, ID: id, Reason: reason})
+		} else {
+			report.Kept++
+		}
+	}
+	sort.Slice(report.Conflicts, func(i, j int) bool {
+		return cidr.Compare(net.ParseIP(report.Conflicts[i].IP), net.ParseIP(report.Conflicts[j].IP)) < 0
+	})
+
+	if !release || len(report.Conflicts) == 0 {
+		return report, nil
+	}
+	for _, c := range report.Conflicts {
+		if err := store.Release(net.ParseIP(c.IP)); err != nil {
+			return nil, fmt.Errorf("failed to release %s: %v", c.IP, err)
+		}
+	}
+	report.Released = true
+	return report, nil
+}
+
+// rangeConflict returns why conf could not have reserved addr, or ""
+func rangeConflict(conf *IPAMConfig, addr net.IP) string {
+	if len(conf.Ranges) == 0 {
+		return rangeConflictIn(conf.rangeOf(), addr)
+	}
+
+	// report why the first range of the subnet of addr could not have it
+	reason := "outside every range"
+	found := false
+	for _, r := range conf.Ranges {
+		why := rangeConflictIn(r, addr)
+		if why == "" {
+			return ""
+		}
+		if !found && (*net.IPNet)(&r.Subnet).Contains(addr) {
+			reason, found = why, true
+		}
+	}
+	return reason
+}
+
+// rangeConflictIn returns why r could not have had addr reserved, or ""
+func rangeConflictIn(r Range, addr net.IP) string {
+	subnet := (*net.IPNet)(&r.Subnet)
+	gw := r.Gateway
+	if gw == nil {
+		gw = ip.NextIP(subnet.IP)
+	}
+
+	switch {
+	case !subnet.Contains(addr):
+		return fmt.Sprintf("outside subnet %s", subnet)
+	case addr.Equal(subnet.IP.Mask(subnet.Mask)):
+		return "network address"
+	case addr.Equal(gw):
+		return "gateway address"
+	case r.RangeStart != nil && cidr.Compare(addr, r.RangeStart) < 0:
+		return fmt.Sprintf("before rangeStart %s", r.RangeStart)
+	case r.RangeEnd != nil && cidr.Compare(addr, r.RangeEnd) > 0:
+		return fmt.Sprintf("after rangeEnd %s", r.RangeEnd)
+	}
+	return ""
+}
+
+// migrateCmd implements "host-local migrate": it reads the new network
+// configuration from stdin, prints the report as JSON and fails while
+// conflicts remain
+type migrateCmd struct {
+	release bool
+}
+
+func (*migrateCmd) Usage() string {
+	return "check the reservations against the new configuration read from stdin"
+}
+
+func (c *migrateCmd) SetFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&c.release, "release-conflicts", false, "release the reservations that conflict with the new configuration")
+}
+
+func (c *migrateCmd) Run(ctx *skel.SubcommandContext) error {
+	return runMigrateErr(ctx.Stdin, ctx.Stdout, c.release)
+}
+
+func runMigrateErr(stdin io.Reader, stdout io.Writer, release bool) error {
+	conf, store, err := openStoreOf(stdin)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	report, err := migrate(conf, store, release)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(report, "", "    ")
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(stdout, "%s\n", out); err != nil {
+		return err
+	}
+
+	if len(report.Conflicts) > 0 && !report.Released {
+		return fmt.Errorf("%d reservations conflict with the new configuration", len(report.Conflicts))
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+
+	"github.com/containernetworking/cni/pkg/types"
+	fakestore "github.com/containernetworking/cni/plugins/ipam/host-local/backend/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("host-local store migration", func() {
+	var (
+		conf  *IPAMConfig
+		store *fakestore.FakeStore
+	)
+
+	BeforeEach(func() {
+		subnet, err := types.ParseCIDR("10.0.0.0/24")
+		Expect(err).NotTo(HaveOccurred())
+		conf = &IPAMConfig{
+			Name:   "test",
+			Type:   "host-local",
+			Subnet: types.IPNet{IP: subnet.IP, Mask: subnet.Mask},
+		}
+		store = fakestore.NewFakeStore(map[string]string{
+			"10.0.0.2":   "a",
+			"10.0.0.100": "b",
+			"10.0.0.200": "c",
+		}, nil)
+	})
+
+	It("keeps reservations that fit a larger subnet", func() {
+		subnet, err := types.ParseCIDR("10.0.0.0/23")
+		Expect(err).NotTo(HaveOccurred())
+		conf.Subnet = types.IPNet{IP: subnet.IP, Mask: subnet.Mask}
+
+		report, err := migrate(conf, store, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report).To(Equal(&migrationReport{Network: "test", Kept: 3}))
+	})
+
+	It("reports reservations outside the new range without touching them", func() {
+		subnet, err := types.ParseCIDR("10.0.0.0/25")
+		Expect(err).NotTo(HaveOccurred())
+		conf.Subnet = types.IPNet{IP: subnet.IP, Mask: subnet.Mask}
+		conf.RangeStart = net.ParseIP("10.0.0.10")
+
+		report, err := migrate(conf, store, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Kept).To(Equal(1))
+		Expect(report.Conflicts).To(Equal([]conflict{
+			{IP: "10.0.0.2", ID: "a", Reason: "before rangeStart 10.0.0.10"},
+			{IP: "10.0.0.200", ID: "c", Reason: "outside subnet 10.0.0.0/25"},
+		}))
+		Expect(report.Released).To(BeFalse())
+		Expect(store.IPMap()).To(HaveLen(3))
+	})
+
+	It("reports a reservation of the new gateway", func() {
+		conf.Gateway = net.ParseIP("10.0.0.100")
+
+		report, err := migrate(conf, store, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Conflicts).To(Equal([]conflict{
+			{IP: "10.0.0.100", ID: "b", Reason: "gateway address"},
+		}))
+	})
+
+	It("checks reservations against every range", func() {
+		subnet := conf.Subnet
+		conf.Subnet = types.IPNet{}
+		conf.Ranges = []Range{
+			{Subnet: subnet, RangeEnd: net.ParseIP("10.0.0.50")},
+			{Subnet: subnet, RangeStart: net.ParseIP("10.0.0.150")},
+		}
+
+		report, err := migrate(conf, store, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Kept).To(Equal(2))
+		Expect(report.Conflicts).To(Equal([]conflict{
+			{IP: "10.0.0.100", ID: "b", Reason: "after rangeEnd 10.0.0.50"},
+		}))
+	})
+
+	It("releases the conflicting reservations when asked to", func() {
+		conf.RangeEnd = net.ParseIP("10.0.0.150")
+
+		report, err := migrate(conf, store, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Released).To(BeTrue())
+		Expect(store.IPMap()).To(Equal(map[string]string{
+			"10.0.0.2":   "a",
+			"10.0.0.100": "b",
+		}))
+	})
+
+	It("rejects an invalid new range", func() {
+		conf.RangeEnd = net.ParseIP("10.1.0.1")
+
+		_, err := migrate(conf, store, false)
+		Expect(err).To(MatchError("10.1.0.1 not in network: 10.0.0.0/24"))
+	})
+})
@@ -0,0 +1,87 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+
+	"github.com/containernetworking/cni/pkg/ip/cidr"
+)
+
+// NodeRange derives the subnet of the node from the subnet of the
+// network, which is then that of the whole cluster, so that every node
+// allocates from a sub-range of its own without a controller handing
+// them out
+type NodeRange struct {
+	// Key identifies the node, the host name if unset. A number n
+	// selects the n-th sub-range, any other key a sub-range derived
+	// from its SHA-256 hash.
+	Key string `json:"key,omitempty"`
+	// PrefixLength is the prefix length of the sub-ranges, e.g. 24 to
+	// split a /16 into 256 of them
+	PrefixLength int `json:"prefixLength"`
+}
+
+// rangeOf returns rc with the subnet of the node in place of the subnet
+// of the cluster
+func (n *NodeRange) rangeOf(rc Range) (Range, error) {
+	if rc.RangeStart != nil || rc.RangeEnd != nil || rc.Gateway != nil {
+		return rc, fmt.Errorf("%q cannot be combined with %q, %q or %q", "node", "rangeStart", "rangeEnd", "gateway")
+	}
+	if rc.Subnet.IP == nil {
+		return rc, fmt.Errorf("missing field %q in IPAM configuration", "subnet")
+	}
+
+	subnet := net.IPNet(rc.Subnet)
+	ones, bits := subnet.Mask.Size()
+	// a sub-range needs an address besides its .0 and its gateway
+	if n.PrefixLength <= ones || n.PrefixLength > bits-2 {
+		return rc, fmt.Errorf("node prefixLength %d must be between %d and %d for subnet %s", n.PrefixLength, ones+1, bits-2, subnet.String())
+	}
+
+	count := new(big.Int).Lsh(big.NewInt(1), uint(n.PrefixLength-ones))
+	index, err := n.index(count)
+	if err != nil {
+		return rc, err
+	}
+	offset := new(big.Int).Lsh(index, uint(bits-n.PrefixLength))
+	rc.Subnet.IP = cidr.Add(subnet.IP.Mask(subnet.Mask), offset)
+	rc.Subnet.Mask = net.CIDRMask(n.PrefixLength, bits)
+	return rc, nil
+}
+
+// index returns which of count sub-ranges is that of the node
+func (n *NodeRange) index(count *big.Int) (*big.Int, error) {
+	key := n.Key
+	if key == "" {
+		var err error
+		if key, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("failed to get the host name for the node key: %v", err)
+		}
+	}
+
+	if i, ok := new(big.Int).SetString(key, 10); ok {
+		if i.Sign() < 0 || i.Cmp(count) >= 0 {
+			return nil, fmt.Errorf("node key %s is not one of the %v sub-ranges", key, count)
+		}
+		return i, nil
+	}
+	sum := sha256.Sum256([]byte(key))
+	return new(big.Int).Mod(new(big.Int).SetBytes(sum[:]), count), nil
+}
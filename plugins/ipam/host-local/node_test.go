@@ -0,0 +1,101 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+
+	"github.com/containernetworking/cni/pkg/types"
+	fakestore "github.com/containernetworking/cni/plugins/ipam/host-local/backend/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("host-local node sub-ranges", func() {
+	var conf IPAMConfig
+
+	subnet := func(s string) types.IPNet {
+		n, err := types.ParseCIDR(s)
+		Expect(err).NotTo(HaveOccurred())
+		return types.IPNet(*n)
+	}
+
+	BeforeEach(func() {
+		conf = IPAMConfig{
+			Name:   "test",
+			Type:   "host-local",
+			Subnet: subnet("10.1.0.0/16"),
+			Node:   &NodeRange{Key: "3", PrefixLength: 24},
+		}
+	})
+
+	allocate := func() (*types.IPConfig, error) {
+		alloc, err := NewIPAllocator(&conf, fakestore.NewFakeStore(map[string]string{}, nil))
+		if err != nil {
+			return nil, err
+		}
+		return alloc.Get("ID", "eth0")
+	}
+
+	It("allocates from the sub-range a numbered key selects", func() {
+		res, err := allocate()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.IP.String()).To(Equal("10.1.3.2/24"))
+		Expect(res.Gateway.String()).To(Equal("10.1.3.1"))
+	})
+
+	It("derives the sub-range of other keys from their hash", func() {
+		conf.Node.Key = "node-a"
+		res, err := allocate()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.IP.String()).To(Equal("10.1.121.2/24"))
+
+		conf.Node.Key = "node-b"
+		res, err = allocate()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.IP.String()).To(Equal("10.1.164.2/24"))
+	})
+
+	It("splits every range of the network", func() {
+		conf.Subnet = types.IPNet{}
+		conf.Ranges = []Range{{Subnet: subnet("10.1.0.0/16")}, {Subnet: subnet("2001:db8::/48")}}
+		conf.Node = &NodeRange{Key: "node-a", PrefixLength: 64}
+
+		_, err := NewIPAllocator(&conf, fakestore.NewFakeStore(map[string]string{}, nil))
+		Expect(err).To(MatchError("range 0: node prefixLength 64 must be between 17 and 30 for subnet 10.1.0.0/16"))
+
+		conf.Ranges = conf.Ranges[1:]
+		res, err := allocate()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.IP.String()).To(Equal("2001:db8:0:b779::2/64"))
+	})
+
+	It("rejects invalid configurations", func() {
+		check := func(msg string) {
+			_, err := allocate()
+			Expect(err).To(MatchError(msg))
+		}
+
+		conf.Node.Key = "256"
+		check("node key 256 is not one of the 256 sub-ranges")
+
+		conf.Node = &NodeRange{Key: "3", PrefixLength: 16}
+		check("node prefixLength 16 must be between 17 and 30 for subnet 10.1.0.0/16")
+
+		conf.Node = &NodeRange{Key: "3", PrefixLength: 24}
+		conf.Gateway = net.ParseIP("10.1.0.1")
+		check(`"node" cannot be combined with "rangeStart", "rangeEnd" or "gateway"`)
+	})
+})
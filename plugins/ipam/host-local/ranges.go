@@ -0,0 +1,275 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+
+	"github.com/containernetworking/cni/pkg/ip"
+	"github.com/containernetworking/cni/pkg/ip/cidr"
+)
+
+// The policies choosing the range of a new allocation when the network
+// has several
+const (
+	// rangePolicyFillFirst takes the first range with a free address, in
+	// the order of the configuration
+	rangePolicyFillFirst = "fill-first"
+	// rangePolicyRoundRobin starts with the range after the one of the
+	// last reserved address
+	rangePolicyRoundRobin = "round-robin"
+	// rangePolicyWeighted starts with the range holding the fewest
+	// addresses for its weight, and skips ranges of weight 0
+	rangePolicyWeighted = "weighted"
+)
+
+// The modes choosing the address of a new allocation within a range
+const (
+	// allocationSequential takes the next free address after the last
+	// reserved one
+	allocationSequential = "sequential"
+	// allocationStableHash starts the search of IPv6 ranges at an
+	// address derived from the network name and container ID
+	allocationStableHash = "stable-hash"
+)
+
+// ipRange is a range addresses are allocated from. end is where the
+// search wraps around to start.
+type ipRange struct {
+	start   net.IP
+	end     net.IP
+	subnet  net.IPNet
+	gateway net.IP
+	weight  int
+	// hashed is set when the search starts at hashedIP rather than
+	// after the last reserved address
+	hashed bool
+	// clipped is set for the part of a range within the range of a node
+	// with a shared configuration, whose end is past the range of the node
+	clipped bool
+}
+
+// rangeOf returns the single range of a network configured without
+// "ranges"
+func (c *IPAMConfig) rangeOf() Range {
+	return Range{Subnet: c.Subnet, RangeStart: c.RangeStart, RangeEnd: c.RangeEnd, Gateway: c.Gateway}
+}
+
+// newIPRanges returns the ranges of conf, a single one unless it has
+// "ranges"
+func newIPRanges(conf *IPAMConfig) ([]*ipRange, error) {
+	if len(conf.Ranges) == 0 {
+		if conf.RangePolicy != "" {
+			return nil, fmt.Errorf("%q requires %q", "rangePolicy", "ranges")
+		}
+		r, err := newNodeIPRange(conf, conf.rangeOf())
+		if err != nil {
+			return nil, err
+		}
+		return []*ipRange{r}, nil
+	}
+
+	if conf.Subnet.IP != nil || conf.RangeStart != nil || conf.RangeEnd != nil || conf.Gateway != nil {
+		return nil, fmt.Errorf("%q cannot be combined with %q, %q, %q or %q", "ranges", "subnet", "rangeStart", "rangeEnd", "gateway")
+	}
+
+	var ranges []*ipRange
+	weighted := false
+	for i, rc := range conf.Ranges {
+		if rc.Weight < 0 {
+			return nil, fmt.Errorf("weight of range %d must not be negative", i)
+		}
+		weighted = weighted || rc.Weight > 0
+		r, err := newNodeIPRange(conf, rc)
+		if err != nil {
+			return nil, fmt.Errorf("range %d: %v", i, err)
+		}
+		ranges = append(ranges, r)
+	}
+
+	switch conf.RangePolicy {
+	case "", rangePolicyFillFirst, rangePolicyRoundRobin:
+	case rangePolicyWeighted:
+		if !weighted {
+			return nil, fmt.Errorf("rangePolicy %q requires a range with a positive weight", conf.RangePolicy)
+		}
+	default:
+		return nil, fmt.Errorf("unknown rangePolicy %q", conf.RangePolicy)
+	}
+	return ranges, nil
+}
+
+// newNodeIPRange returns the range of rc, or its sub-range of the node
+// if conf has "node"
+func newNodeIPRange(conf *IPAMConfig, rc Range) (*ipRange, error) {
+	if conf.Node != nil {
+		var err error
+		if rc, err = conf.Node.rangeOf(rc); err != nil {
+			return nil, err
+		}
+	}
+	return newIPRange(rc)
+}
+
+func newIPRange(rc Range) (*ipRange, error) {
+	subnet := (*net.IPNet)(&rc.Subnet)
+	start, end, err := networkRange(subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	// skip the .0 address
+	start = ip.NextIP(start)
+
+	if rc.RangeStart != nil {
+		if err := validateRangeIP(rc.RangeStart, subnet); err != nil {
+			return nil, err
+		}
+		start = rc.RangeStart
+	}
+	if rc.RangeEnd != nil {
+		if err := validateRangeIP(rc.RangeEnd, subnet); err != nil {
+			return nil, err
+		}
+		// RangeEnd is inclusive
+		end = ip.NextIP(rc.RangeEnd)
+	}
+
+	gw := rc.Gateway
+	if gw == nil {
+		gw = ip.NextIP(subnet.IP)
+	}
+	return &ipRange{start: start, end: end, subnet: *subnet, gateway: gw, weight: rc.Weight}, nil
+}
+
+func networkRange(ipnet *net.IPNet) (net.IP, net.IP, error) {
+	if ipnet.IP == nil {
+		return nil, nil, fmt.Errorf("missing field %q in IPAM configuration", "subnet")
+	}
+	ip := ipnet.IP.To4()
+	if ip == nil {
+		ip = ipnet.IP.To16()
+		if ip == nil {
+			return nil, nil, fmt.Errorf("IP not v4 nor v6")
+		}
+	}
+
+	if len(ip) != len(ipnet.Mask) {
+		return nil, nil, fmt.Errorf("IPNet IP and Mask version mismatch")
+	}
+
+	_, end := cidr.Bounds(ipnet)
+	return ipnet.IP, end, nil
+}
+
+// contains reports whether addr is between the start and the end of r
+func (r *ipRange) contains(addr net.IP) bool {
+	if addr == nil || !r.subnet.Contains(addr) {
+		return false
+	}
+	return cidr.Compare(addr, r.start) >= 0 && cidr.Compare(addr, r.end) <= 0
+}
+
+// nextIP returns the next ip of curIP within the range
+func (r *ipRange) nextIP(curIP net.IP) net.IP {
+	if curIP.Equal(r.end) {
+		return r.start
+	}
+	return ip.NextIP(curIP)
+}
+
+// searchRange returns the start and end ip based on the last reserved ip
+func (r *ipRange) searchRange(lastReservedIP net.IP) (net.IP, net.IP) {
+	if r.contains(lastReservedIP) {
+		return r.nextIP(lastReservedIP), lastReservedIP
+	}
+	return r.start, r.end
+}
+
+// hashedIP returns the address between the start and the end of r derived
+// from the network name and id, which stays the same for as long as the
+// range does
+func (r *ipRange) hashedIP(network, id string) net.IP {
+	size := cidr.Distance(r.start, r.end)
+	if size == nil || size.Sign() <= 0 {
+		return r.start
+	}
+
+	sum := sha256.Sum256([]byte(network + "\x00" + id))
+	offset := new(big.Int).Mod(new(big.Int).SetBytes(sum[:]), size)
+	return cidr.Add(r.start, offset)
+}
+
+// rangeFor returns the range a requested address is allocated from
+func (a *IPAllocator) rangeFor(addr net.IP) (*ipRange, error) {
+	for _, r := range a.ranges {
+		if r.contains(addr) {
+			return r, nil
+		}
+	}
+	for _, r := range a.ranges {
+		if r.subnet.Contains(addr) {
+			return r, nil
+		}
+	}
+	if len(a.ranges) == 1 {
+		return nil, validateRangeIP(addr, &a.ranges[0].subnet)
+	}
+	return nil, fmt.Errorf("%s not in any range of network: %s", addr, a.conf.Name)
+}
+
+// orderRanges returns the ranges to search for a free address, in the
+// order of the range policy. It must be called with the store locked.
+func (a *IPAllocator) orderRanges(lastReservedIP net.IP) ([]*ipRange, error) {
+	switch a.conf.RangePolicy {
+	case rangePolicyRoundRobin:
+		for i, r := range a.ranges {
+			if r.contains(lastReservedIP) {
+				return append(append([]*ipRange{}, a.ranges[i+1:]...), a.ranges[:i+1]...), nil
+			}
+		}
+	case rangePolicyWeighted:
+		reservations, err := a.store.Reservations()
+		if err != nil {
+			return nil, err
+		}
+		used := map[*ipRange]int{}
+		for s := range reservations {
+			for _, r := range a.ranges {
+				if r.contains(net.ParseIP(s)) {
+					used[r]++
+					break
+				}
+			}
+		}
+
+		var ranges []*ipRange
+		for _, r := range a.ranges {
+			if r.weight > 0 {
+				ranges = append(ranges, r)
+			}
+		}
+		// fewest used addresses per unit of weight first
+		sort.SliceStable(ranges, func(i, j int) bool {
+			return used[ranges[i]]*ranges[j].weight < used[ranges[j]]*ranges[i].weight
+		})
+		return ranges, nil
+	}
+	return a.ranges, nil
+}
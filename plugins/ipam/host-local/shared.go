@@ -0,0 +1,127 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/ip"
+	"github.com/containernetworking/cni/pkg/ip/cidr"
+	"github.com/containernetworking/cni/pkg/logging"
+	"github.com/containernetworking/cni/plugins/ipam/host-local/backend"
+	"github.com/containernetworking/cni/plugins/ipam/host-local/backend/disk"
+)
+
+// Shared configures a node allocating from a network whose other
+// addresses are handed out elsewhere, e.g. a disaster recovery node next
+// to the primary ones. The node allocates only from the sub-range
+// reserved for it, in its own store, and skips addresses reserved in the
+// replica of the store of the primaries, which it never writes.
+type Shared struct {
+	// DataDir is where the replicated stores are, in place of
+	// /var/lib/cni/networks
+	DataDir string `json:"dataDir"`
+	// PoolID is the pool of the replicated store, the pool of the network
+	// if unset
+	PoolID string `json:"poolID,omitempty"`
+	// RangeStart and RangeEnd bound the addresses of the node, inclusive
+	RangeStart net.IP `json:"rangeStart"`
+	RangeEnd   net.IP `json:"rangeEnd"`
+}
+
+// clip returns the parts of ranges within the range of the node, leaving
+// out the ranges outside of it
+func (s *Shared) clip(ranges []*ipRange) ([]*ipRange, error) {
+	if s.DataDir == "" {
+		return nil, fmt.Errorf("%q requires %q", "shared", "dataDir")
+	}
+	if s.RangeStart == nil || s.RangeEnd == nil {
+		return nil, fmt.Errorf("%q requires %q and %q", "shared", "rangeStart", "rangeEnd")
+	}
+	if _, err := cidr.NewRange(s.RangeStart, s.RangeEnd); err != nil {
+		return nil, fmt.Errorf("invalid shared range: %v", err)
+	}
+
+	// the end of an ipRange is exclusive
+	nodeEnd := ip.NextIP(s.RangeEnd)
+	var clipped []*ipRange
+	for _, r := range ranges {
+		start, end := r.start, r.end
+		if cidr.Compare(s.RangeStart, start) > 0 {
+			start = s.RangeStart
+		}
+		if nodeEnd != nil && cidr.Compare(nodeEnd, end) < 0 {
+			end = nodeEnd
+		}
+		if cidr.Compare(start, end) >= 0 || !r.subnet.Contains(start) {
+			continue
+		}
+
+		c := *r
+		c.start, c.end = start, end
+		c.clipped = !end.Equal(r.end)
+		clipped = append(clipped, &c)
+	}
+	if len(clipped) == 0 {
+		return nil, fmt.Errorf("shared range %s-%s is outside every range of the network", s.RangeStart, s.RangeEnd)
+	}
+	return clipped, nil
+}
+
+// contains reports whether addr is in the range of the node
+func (s *Shared) contains(addr net.IP) bool {
+	return cidr.Compare(addr, s.RangeStart) >= 0 && cidr.Compare(addr, s.RangeEnd) <= 0
+}
+
+// sharedStore is the store of a node with a shared configuration: it
+// refuses to reserve the IPs reserved in the replicated store
+type sharedStore struct {
+	backend.Store
+	replica backend.Store
+}
+
+// newSharedStore wraps store to check its reservations against the
+// replica of pool in shared.DataDir
+func newSharedStore(store backend.Store, shared *Shared, pool string) (*sharedStore, error) {
+	if shared.PoolID != "" {
+		pool = shared.PoolID
+	}
+	replica, err := disk.NewReadOnly(shared.DataDir, pool)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the replicated store: %v", err)
+	}
+	return &sharedStore{Store: store, replica: replica}, nil
+}
+
+func (s *sharedStore) Close() error {
+	err := s.Store.Close()
+	if replicaErr := s.replica.Close(); err == nil {
+		err = replicaErr
+	}
+	return err
+}
+
+func (s *sharedStore) Reserve(r backend.Reservation, ip net.IP) (bool, error) {
+	held, err := s.replica.Reservation(ip)
+	if err != nil {
+		return false, fmt.Errorf("failed to read the replicated store: %v", err)
+	}
+	if held != nil {
+		logging.Warnf("skipping %v: reserved for %q in the replicated store", ip, held.ID)
+		return false, nil
+	}
+	return s.Store.Reserve(r, ip)
+}
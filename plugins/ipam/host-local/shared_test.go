@@ -0,0 +1,121 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/types"
+	fakestore "github.com/containernetworking/cni/plugins/ipam/host-local/backend/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("host-local shared mode", func() {
+	var (
+		conf           IPAMConfig
+		store, replica *fakestore.FakeStore
+	)
+
+	BeforeEach(func() {
+		subnet, err := types.ParseCIDR("10.0.0.0/24")
+		Expect(err).NotTo(HaveOccurred())
+		conf = IPAMConfig{
+			Name:   "test",
+			Type:   "host-local",
+			Subnet: types.IPNet{IP: subnet.IP, Mask: subnet.Mask},
+			Shared: &Shared{
+				DataDir:    "/replica",
+				RangeStart: net.ParseIP("10.0.0.200"),
+				RangeEnd:   net.ParseIP("10.0.0.202"),
+			},
+		}
+		store = fakestore.NewFakeStore(map[string]string{}, nil)
+		replica = fakestore.NewFakeStore(map[string]string{"10.0.0.2": "primary", "10.0.0.201": "primary"}, nil)
+	})
+
+	allocate := func(id string) (*types.IPConfig, error) {
+		alloc, err := NewIPAllocator(&conf, &sharedStore{Store: store, replica: replica})
+		Expect(err).NotTo(HaveOccurred())
+		return alloc.Get(id, "eth0")
+	}
+
+	It("allocates from the range of the node, skipping the IPs of the replica", func() {
+		var ips []string
+		for i := 0; i < 2; i++ {
+			res, err := allocate(fmt.Sprintf("ID%d", i))
+			Expect(err).NotTo(HaveOccurred())
+			ips = append(ips, res.IP.String())
+		}
+		Expect(ips).To(Equal([]string{"10.0.0.200/24", "10.0.0.202/24"}))
+		Expect(store.IPMap()).To(Equal(map[string]string{"10.0.0.200": "ID0", "10.0.0.202": "ID1"}))
+
+		_, err := allocate("ID2")
+		Expect(err).To(MatchError("no IP addresses available in network: test"))
+		Expect(replica.IPMap()).To(HaveLen(2))
+	})
+
+	It("keeps the gateway of the network", func() {
+		res, err := allocate("ID")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.Gateway.String()).To(Equal("10.0.0.1"))
+	})
+
+	It("refuses requested IPs outside the range of the node or reserved in the replica", func() {
+		conf.Args = &IPAMArgs{IP: net.ParseIP("10.0.0.50")}
+		_, err := allocate("ID")
+		Expect(err).To(MatchError("requested IP 10.0.0.50 is outside the range 10.0.0.200-10.0.0.202 of this node"))
+
+		conf.Args.IP = net.ParseIP("10.0.0.203")
+		_, err = allocate("ID")
+		Expect(err).To(MatchError("requested IP 10.0.0.203 is outside the range 10.0.0.200-10.0.0.202 of this node"))
+
+		conf.Args.IP = net.ParseIP("10.0.0.201")
+		_, err = allocate("ID")
+		Expect(err).To(MatchError(`requested IP address "10.0.0.201" is not available in network: test`))
+
+		conf.Args.IP = net.ParseIP("10.0.0.202")
+		res, err := allocate("ID")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.IP.String()).To(Equal("10.0.0.202/24"))
+	})
+
+	It("fails if the replica cannot be read", func() {
+		replica.InjectError("Reservation", errors.New("stale file handle"), 1)
+		_, err := allocate("ID")
+		Expect(err).To(MatchError("failed to read the replicated store: stale file handle"))
+		Expect(store.IPMap()).To(BeEmpty())
+	})
+
+	It("rejects invalid configurations", func() {
+		check := func(shared Shared, msg string) {
+			conf.Shared = &shared
+			_, err := NewIPAllocator(&conf, store)
+			Expect(err).To(MatchError(msg))
+		}
+		start, end := net.ParseIP("10.0.0.200"), net.ParseIP("10.0.0.202")
+
+		check(Shared{RangeStart: start, RangeEnd: end}, `"shared" requires "dataDir"`)
+		check(Shared{DataDir: "/replica", RangeStart: start}, `"shared" requires "rangeStart" and "rangeEnd"`)
+		check(Shared{DataDir: "/replica", RangeStart: end, RangeEnd: start}, "invalid shared range: 10.0.0.202 is after 10.0.0.200")
+		check(Shared{DataDir: "/replica", RangeStart: net.ParseIP("10.0.1.1"), RangeEnd: net.ParseIP("10.0.1.9")},
+			"shared range 10.0.1.1-10.0.1.9 is outside every range of the network")
+
+		_, err := LoadIPAMConfig([]byte(`{"name": "test", "ipam": {"type": "host-local", "shared": {"poolID": "../other"}}}`), "")
+		Expect(err).To(MatchError(`invalid poolID "../other" of the shared store`))
+	})
+})
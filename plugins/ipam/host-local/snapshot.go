@@ -0,0 +1,240 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/ip/cidr"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/plugins/ipam/host-local/backend"
+)
+
+// snapshotVersion is the version of the snapshot format written by export
+const snapshotVersion = 1
+
+// snapshot holds every reservation of a pool, independently of the
+// backend storing them
+type snapshot struct {
+	Version int    `json:"version"`
+	Network string `json:"network"`
+	Pool    string `json:"pool"`
+	// LastReservedIP is where the next allocation starts searching
+	LastReservedIP net.IP  `json:"lastReservedIP,omitempty"`
+	Leases         []lease `json:"leases"`
+}
+
+type lease struct {
+	IP net.IP `json:"ip"`
+	backend.Reservation
+}
+
+// exportSnapshot returns the reservations of store, ordered by IP
+func exportSnapshot(conf *IPAMConfig, store backend.Store) (*snapshot, error) {
+	if err := store.Lock(); err != nil {
+		return nil, err
+	}
+	defer store.Unlock()
+
+	reservations, err := store.Reservations()
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &snapshot{
+		Version: snapshotVersion,
+		Network: conf.Name,
+		Pool:    conf.pool(),
+		Leases:  []lease{},
+	}
+	// a store without one starts from the beginning of the range
+	if last, err := store.LastReservedIP(); err == nil {
+		snap.LastReservedIP = last
+	}
+	for s := range reservations {
+		addr := net.ParseIP(s)
+		r, err := store.Reservation(addr)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			// released since the listing
+			continue
+		}
+		snap.Leases = append(snap.Leases, lease{IP: addr, Reservation: *r})
+	}
+	sort.Slice(snap.Leases, func(i, j int) bool {
+		return cidr.Compare(snap.Leases[i].IP, snap.Leases[j].IP) < 0
+	})
+	return snap, nil
+}
+
+// importSnapshot reserves the leases of snap in store. It fails without
+// reserving anything if a lease could not have been made by conf, or if
+// its IP is reserved for another ID; leases already in the store are
+// skipped. It returns how many leases were imported.
+func importSnapshot(conf *IPAMConfig, store backend.Store, snap *snapshot) (int, error) {
+	if snap.Version != snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	// the allocator validates the range
+	if _, err := NewIPAllocator(conf, store); err != nil {
+		return 0, err
+	}
+
+	if err := store.Lock(); err != nil {
+		return 0, err
+	}
+	defer store.Unlock()
+
+	var (
+		todo     []lease
+		problems []string
+	)
+	for _, l := range snap.Leases {
+		if l.IP == nil || l.ID == "" {
+			problems = append(problems, "lease without IP or ID")
+			continue
+		}
+		if reason := rangeConflict(conf, l.IP); reason != "" {
+			problems = append(problems, fmt.Sprintf("%s is %s", l.IP, reason))
+			continue
+		}
+		existing, err := store.Reservation(l.IP)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case existing == nil:
+			todo = append(todo, l)
+		case existing.ID != l.ID:
+			problems = append(problems, fmt.Sprintf("%s is reserved for %q, not %q", l.IP, existing.ID, l.ID))
+		}
+	}
+	if len(problems) > 0 {
+		return 0, fmt.Errorf("cannot import the snapshot: %s", strings.Join(problems, "; "))
+	}
+
+	// the store remembers the last IP reserved, so the one the snapshot
+	// remembers goes last
+	sort.SliceStable(todo, func(i, j int) bool {
+		return !todo[i].IP.Equal(snap.LastReservedIP) && todo[j].IP.Equal(snap.LastReservedIP)
+	})
+	for i, l := range todo {
+		reserved, err := store.Reserve(l.Reservation, l.IP)
+		if err == nil && !reserved {
+			err = fmt.Errorf("%s was reserved concurrently", l.IP)
+		}
+		if err != nil {
+			for _, undo := range todo[:i] {
+				store.Release(undo.IP)
+			}
+			return 0, fmt.Errorf("failed to import %s: %v", l.IP, err)
+		}
+	}
+	return len(todo), nil
+}
+
+// exportCmd implements "host-local export": it prints a snapshot of the
+// reservations of the network whose configuration is read from stdin
+type exportCmd struct{}
+
+func (*exportCmd) Usage() string {
+	return "print a snapshot of the reservations of the configuration read from stdin"
+}
+
+func (*exportCmd) SetFlags(_ *flag.FlagSet) {}
+
+func (*exportCmd) Run(ctx *skel.SubcommandContext) error {
+	conf, store, err := openStoreOf(ctx.Stdin)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	snap, err := exportSnapshot(conf, store)
+	if err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(snap, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(ctx.Stdout, "%s\n", out)
+	return err
+}
+
+// importCmd implements "host-local import SNAPSHOT": it reserves the
+// leases of a snapshot in the network whose configuration is read from
+// stdin
+type importCmd struct{}
+
+func (*importCmd) Usage() string {
+	return "reserve the leases of a snapshot file in the configuration read from stdin"
+}
+
+func (*importCmd) SetFlags(_ *flag.FlagSet) {}
+
+func (*importCmd) Run(ctx *skel.SubcommandContext) error {
+	if len(ctx.Args) != 1 {
+		return errors.New("expected the path of the snapshot")
+	}
+	data, err := ioutil.ReadFile(ctx.Args[0])
+	if err != nil {
+		return err
+	}
+	snap := &snapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %v", err)
+	}
+
+	conf, store, err := openStoreOf(ctx.Stdin)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	n, err := importSnapshot(conf, store, snap)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(ctx.Stdout, "imported %d of %d leases into pool %q\n", n, len(snap.Leases), conf.pool())
+	return err
+}
+
+// openStoreOf opens the store of the network configuration read from r
+func openStoreOf(r io.Reader) (*IPAMConfig, backend.Store, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	conf, err := LoadIPAMConfig(data, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := openStore(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conf, store, nil
+}
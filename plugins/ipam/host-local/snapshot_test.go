@@ -0,0 +1,123 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/plugins/ipam/host-local/backend"
+	fakestore "github.com/containernetworking/cni/plugins/ipam/host-local/backend/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("host-local snapshots", func() {
+	var (
+		conf  *IPAMConfig
+		store *fakestore.FakeStore
+	)
+
+	BeforeEach(func() {
+		subnet, err := types.ParseCIDR("10.0.0.0/24")
+		Expect(err).NotTo(HaveOccurred())
+		conf = &IPAMConfig{
+			Name:   "test",
+			Type:   "host-local",
+			Subnet: types.IPNet{IP: subnet.IP, Mask: subnet.Mask},
+		}
+		store = fakestore.NewFakeStore(map[string]string{}, nil)
+		allocated := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+		for _, l := range []struct{ ip, id string }{{"10.0.0.100", "b"}, {"10.0.0.20", "a"}, {"10.0.0.5", "c"}} {
+			r := backend.Reservation{ID: l.id, IfName: "eth0", Allocated: allocated, ConfigHash: "sha256:00"}
+			_, err := store.Reserve(r, net.ParseIP(l.ip))
+			Expect(err).NotTo(HaveOccurred())
+		}
+	})
+
+	It("exports every reservation in IP order", func() {
+		snap, err := exportSnapshot(conf, store)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snap.Pool).To(Equal("test"))
+		Expect(snap.LastReservedIP.String()).To(Equal("10.0.0.5"))
+
+		var ips, ids []string
+		for _, l := range snap.Leases {
+			ips = append(ips, l.IP.String())
+			ids = append(ids, l.ID)
+			Expect(l.IfName).To(Equal("eth0"))
+			Expect(l.ConfigHash).To(Equal("sha256:00"))
+		}
+		Expect(ips).To(Equal([]string{"10.0.0.5", "10.0.0.20", "10.0.0.100"}))
+		Expect(ids).To(Equal([]string{"c", "a", "b"}))
+	})
+
+	It("imports a snapshot into another store, keeping the last reserved IP", func() {
+		snap, err := exportSnapshot(conf, store)
+		Expect(err).NotTo(HaveOccurred())
+
+		target := fakestore.NewFakeStore(map[string]string{}, nil)
+		n, err := importSnapshot(conf, target, snap)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(3))
+		Expect(target.IPMap()).To(Equal(store.IPMap()))
+		last, err := target.LastReservedIP()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(last.String()).To(Equal("10.0.0.5"))
+
+		r, err := target.Reservation(net.ParseIP("10.0.0.20"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.IfName).To(Equal("eth0"))
+
+		// importing again finds the leases in place
+		n, err = importSnapshot(conf, target, snap)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(0))
+	})
+
+	It("imports nothing if a lease conflicts", func() {
+		snap, err := exportSnapshot(conf, store)
+		Expect(err).NotTo(HaveOccurred())
+		snap.Leases = append(snap.Leases, lease{IP: net.ParseIP("10.0.1.5"), Reservation: backend.Reservation{ID: "d"}})
+
+		target := fakestore.NewFakeStore(map[string]string{"10.0.0.20": "z"}, nil)
+		_, err = importSnapshot(conf, target, snap)
+		Expect(err).To(MatchError(`cannot import the snapshot: 10.0.0.20 is reserved for "z", not "a"; 10.0.1.5 is outside subnet 10.0.0.0/24`))
+		Expect(target.IPMap()).To(Equal(map[string]string{"10.0.0.20": "z"}))
+	})
+
+	It("undoes a partial import", func() {
+		snap, err := exportSnapshot(conf, store)
+		Expect(err).NotTo(HaveOccurred())
+
+		target := fakestore.NewFakeStore(map[string]string{}, nil)
+		target.ReserveConcurrently(net.ParseIP("10.0.0.100"), "x")
+		_, err = importSnapshot(conf, target, snap)
+		Expect(err).To(MatchError("failed to import 10.0.0.100: 10.0.0.100 was reserved concurrently"))
+		Expect(target.IPMap()).To(Equal(map[string]string{"10.0.0.100": "x"}))
+
+		target = fakestore.NewFakeStore(map[string]string{}, nil)
+		target.InjectError("Reserve", errors.New("disk full"), -1)
+		_, err = importSnapshot(conf, target, snap)
+		Expect(err).To(MatchError("failed to import 10.0.0.20: disk full"))
+	})
+
+	It("rejects snapshots of an unknown version", func() {
+		_, err := importSnapshot(conf, store, &snapshot{Version: 2})
+		Expect(err).To(MatchError("unsupported snapshot version 2"))
+	})
+})
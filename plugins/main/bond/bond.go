@@ -0,0 +1,379 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+
+	"github.com/containernetworking/cni/pkg/ip"
+	"github.com/containernetworking/cni/pkg/ipam"
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+const defaultMiimon = 100
+
+// attributes of IFLA_INFO_DATA of a bond, from linux/if_link.h
+const (
+	iflaBondMode   = 1
+	iflaBondMiimon = 3
+)
+
+// nlaTypeMask strips the nested and byte order flags of an attribute type
+const nlaTypeMask = 0x3fff
+
+// bondModes maps the names of the bonding modes to their numbers
+var bondModes = map[string]uint8{
+	"balance-rr":    0,
+	"active-backup": 1,
+	"balance-xor":   2,
+	"broadcast":     3,
+	"802.3ad":       4,
+	"balance-tlb":   5,
+	"balance-alb":   6,
+}
+
+type NetConf struct {
+	types.NetConf
+	// PrevResult is the result of the earlier plugins in the chain, in
+	// any version
+	PrevResult *current.Result `json:"-"`
+	// Links are the container interfaces to enslave, created by earlier
+	// plugins in the chain
+	Links  []string `json:"links"`
+	Mode   string   `json:"mode"`
+	Miimon *int     `json:"miimon,omitempty"`
+	MTU    int      `json:"mtu"`
+
+	mode   uint8
+	miimon uint32
+}
+
+func init() {
+	// this ensures that main runs only on main thread (thread group leader).
+	// since namespace ops (unshare, setns) are done for a single thread, we
+	// must ensure that the goroutine does not jump from OS thread to thread
+	runtime.LockOSThread()
+}
+
+func loadConf(bytes []byte) (*NetConf, error) {
+	n := &NetConf{}
+	if err := json.Unmarshal(bytes, n); err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %v", err)
+	}
+	prev, err := current.ParsePrevResult(bytes)
+	if err != nil {
+		return nil, err
+	}
+	n.PrevResult = prev
+	if len(n.Links) == 0 {
+		return nil, fmt.Errorf(`"links" field is required. It lists the container interfaces to enslave`)
+	}
+
+	if n.Mode == "" {
+		n.Mode = "active-backup"
+	}
+	mode, ok := bondModes[n.Mode]
+	if !ok {
+		return nil, fmt.Errorf("unknown bond mode: %q", n.Mode)
+	}
+	n.mode = mode
+
+	n.miimon = defaultMiimon
+	if n.Miimon != nil {
+		if *n.Miimon < 0 {
+			return nil, fmt.Errorf("invalid miimon %d", *n.Miimon)
+		}
+		n.miimon = uint32(*n.Miimon)
+	}
+	return n, nil
+}
+
+// addBond creates the bond ifName in the current network namespace. The
+// netlink package cannot pass the bonding options, so the request is put
+// together here.
+func addBond(ifName string, mode uint8, miimon uint32, mtu int) error {
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+	req.AddData(nl.NewIfInfomsg(syscall.AF_UNSPEC))
+	req.AddData(nl.NewRtAttr(syscall.IFLA_IFNAME, nl.ZeroTerminated(ifName)))
+	if mtu > 0 {
+		req.AddData(nl.NewRtAttr(syscall.IFLA_MTU, nl.Uint32Attr(uint32(mtu))))
+	}
+
+	linkInfo := nl.NewRtAttr(syscall.IFLA_LINKINFO, nil)
+	nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_KIND, nl.NonZeroTerminated("bond"))
+	data := nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_DATA, nil)
+	nl.NewRtAttrChild(data, iflaBondMode, nl.Uint8Attr(mode))
+	nl.NewRtAttrChild(data, iflaBondMiimon, nl.Uint32Attr(miimon))
+	req.AddData(linkInfo)
+
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+// bondOptions returns the mode and miimon of the bond with the given
+// index, which the netlink package does not parse
+func bondOptions(index int) (uint8, uint32, error) {
+	req := nl.NewNetlinkRequest(syscall.RTM_GETLINK, syscall.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(index)
+	req.AddData(msg)
+
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWLINK)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var (
+		mode   uint8
+		miimon uint32
+	)
+	for _, m := range msgs {
+		attrs, err := nl.ParseRouteAttr(m[msg.Len():])
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, attr := range attrs {
+			if attr.Attr.Type&nlaTypeMask != syscall.IFLA_LINKINFO {
+				continue
+			}
+			infos, err := nl.ParseRouteAttr(attr.Value)
+			if err != nil {
+				return 0, 0, err
+			}
+			for _, info := range infos {
+				if info.Attr.Type&nlaTypeMask != nl.IFLA_INFO_DATA {
+					continue
+				}
+				data, err := nl.ParseRouteAttr(info.Value)
+				if err != nil {
+					return 0, 0, err
+				}
+				for _, d := range data {
+					switch d.Attr.Type & nlaTypeMask {
+					case iflaBondMode:
+						mode = d.Value[0]
+					case iflaBondMiimon:
+						miimon = nl.NativeEndian().Uint32(d.Value[:4])
+					}
+				}
+			}
+		}
+	}
+	return mode, miimon, nil
+}
+
+// createBond creates the bond ifName and enslaves the links of conf to
+// it, in the current network namespace. The bond is removed again, which
+// frees the links, if any step fails.
+func createBond(conf *NetConf, ifName string) (netlink.Link, error) {
+	if _, err := netlink.LinkByName(ifName); err == nil {
+		return nil, types.NewInterfaceExistsError(ifName)
+	}
+
+	var links []netlink.Link
+	for _, name := range conf.Links {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lookup link %q: %v", name, err)
+		}
+		if link.Attrs().MasterIndex != 0 {
+			return nil, fmt.Errorf("%q is already enslaved to another device", name)
+		}
+		links = append(links, link)
+	}
+
+	// enslaving sets the MTU of the links to that of the bond, which
+	// keeps theirs unless told otherwise
+	mtu := conf.MTU
+	if mtu == 0 {
+		mtu = links[0].Attrs().MTU
+	}
+	if err := addBond(ifName, conf.mode, conf.miimon, mtu); err != nil {
+		return nil, fmt.Errorf("failed to create bond %q: %v", ifName, err)
+	}
+	bond, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+
+	err = func() error {
+		for _, link := range links {
+			// links must be down to be enslaved; the bond brings them up
+			if err := netlink.LinkSetDown(link); err != nil {
+				return fmt.Errorf("failed to set %q down: %v", link.Attrs().Name, err)
+			}
+			if err := netlink.LinkSetMasterByIndex(link, bond.Attrs().Index); err != nil {
+				return fmt.Errorf("failed to enslave %q to %q: %v", link.Attrs().Name, ifName, err)
+			}
+		}
+		up := true
+		return ip.SetLinkProperties(ifName, ip.LinkProperties{Up: &up})
+	}()
+	if err != nil {
+		netlink.LinkDel(bond)
+		return nil, err
+	}
+
+	// the bond takes its MAC from the first link
+	return netlink.LinkByName(ifName)
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+	}
+	defer netns.Close()
+
+	var bond netlink.Link
+	err = netns.Do(func(_ ns.NetNS) error {
+		var err error
+		bond, err = createBond(n, args.IfName)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	result := n.PrevResult
+	if n.IPAM.Type != "" {
+		ipamResult, err := configureIPAM(n, args, netns)
+		if err != nil {
+			netns.Do(func(_ ns.NetNS) error {
+				return netlink.LinkDel(bond)
+			})
+			return err
+		}
+		result = current.NewResultFromLegacy(ipamResult)
+	}
+	if result == nil {
+		result = &current.Result{}
+	}
+	result.AddSandboxInterface(args.IfName, bond.Attrs().HardwareAddr.String(), args.Netns)
+	result.DNS = types.MergeDNS(n.DNS, result.DNS)
+	return current.PrintResult(result, n.CNIVersion)
+}
+
+// configureIPAM runs the IPAM plugin of n and gives the bond the
+// addresses it returns, releasing them again if that fails
+func configureIPAM(n *NetConf, args *skel.CmdArgs, netns ns.NetNS) (*types.Result, error) {
+	result, err := ipam.ExecAdd(n.IPAM.Type, args.StdinData)
+	if err != nil {
+		return nil, err
+	}
+	if result.IP4 == nil && result.IP6 == nil {
+		ipam.ExecDel(n.IPAM.Type, args.StdinData)
+		return nil, errors.New("IPAM plugin returned missing IP config")
+	}
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		return ipam.ConfigureIface(args.IfName, current.NewResultFromLegacy(result))
+	})
+	if err != nil {
+		ipam.ExecDel(n.IPAM.Type, args.StdinData)
+		return nil, err
+	}
+	return result, nil
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if n.PrevResult == nil {
+		return errors.New("required prevResult missing")
+	}
+
+	return ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		bond, err := netlink.LinkByName(args.IfName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", args.IfName, err)
+		}
+		if bond.Type() != "bond" {
+			return fmt.Errorf("%q is a %s link, not bond", args.IfName, bond.Type())
+		}
+
+		mode, miimon, err := bondOptions(bond.Attrs().Index)
+		if err != nil {
+			return fmt.Errorf("failed to get the options of bond %q: %v", args.IfName, err)
+		}
+		if mode != n.mode {
+			return fmt.Errorf("bond %q is not in %q mode", args.IfName, n.Mode)
+		}
+		if miimon != n.miimon {
+			return fmt.Errorf("bond %q has miimon %d, not %d", args.IfName, miimon, n.miimon)
+		}
+
+		for _, name := range n.Links {
+			link, err := netlink.LinkByName(name)
+			if err != nil {
+				return fmt.Errorf("failed to lookup link %q: %v", name, err)
+			}
+			if link.Attrs().MasterIndex != bond.Attrs().Index {
+				return fmt.Errorf("%q is not enslaved to bond %q", name, args.IfName)
+			}
+		}
+
+		if n.IPAM.Type == "" {
+			return nil
+		}
+		return ipam.CheckIface(args.IfName, n.PrevResult)
+	})
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	if n.IPAM.Type != "" {
+		if err := ipam.ExecDel(n.IPAM.Type, args.StdinData); err != nil {
+			return err
+		}
+	}
+
+	if args.Netns == "" {
+		return nil
+	}
+
+	// deleting the bond frees the links, which are left to the plugins
+	// that created them
+	return ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		if _, err := netlink.LinkByName(args.IfName); err != nil {
+			return nil
+		}
+		return ip.DelLinkByName(args.IfName)
+	})
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.PluginFuncs{Add: cmdAdd, Check: cmdCheck, Del: cmdDel})
+}
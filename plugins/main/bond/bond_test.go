@@ -0,0 +1,188 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/testutils"
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const IFNAME = "bond0"
+
+var _ = Describe("bond Operations", func() {
+	var targetNs ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+		targetNs, err = ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		err = targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			// as left by earlier plugins in the chain
+			for _, name := range []string{"link0", "link1"} {
+				err := netlink.LinkAdd(&netlink.Veth{
+					LinkAttrs: netlink.LinkAttrs{Name: name, MTU: 1400},
+					PeerName:  name + "-peer",
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(targetNs.Close()).To(Succeed())
+	})
+
+	It("rejects a configuration without links", func() {
+		_, err := loadConf([]byte(`{"name": "mynet", "type": "bond"}`))
+		Expect(err).To(MatchError(`"links" field is required. It lists the container interfaces to enslave`))
+
+		_, err = loadConf([]byte(`{"name": "mynet", "type": "bond", "links": ["link0"], "mode": "round-robin"}`))
+		Expect(err).To(MatchError(`unknown bond mode: "round-robin"`))
+	})
+
+	It("enslaves the links to a bond and checks and removes it", func() {
+		conf := `{
+    "cniVersion": "0.3.0",
+    "name": "mynet",
+    "type": "bond",
+    "links": ["link0", "link1"],
+    "ipam": {
+        "type": "host-local",
+        "subnet": "10.1.2.0/24"
+    }
+}`
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      IFNAME,
+			StdinData:   []byte(conf),
+		}
+
+		result, err := testutils.CmdAddWithCurrentResult(targetNs.Path(), IFNAME, func() error {
+			return cmdAdd(args)
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Interfaces).To(HaveLen(1))
+		Expect(result.Interfaces[0].Name).To(Equal(IFNAME))
+		Expect(result.Interfaces[0].Sandbox).To(Equal(targetNs.Path()))
+
+		err = targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			bond, err := netlink.LinkByName(IFNAME)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bond.Type()).To(Equal("bond"))
+			Expect(bond.Attrs().MTU).To(Equal(1400))
+			Expect(bond.Attrs().HardwareAddr.String()).To(Equal(result.Interfaces[0].Mac))
+
+			mode, miimon, err := bondOptions(bond.Attrs().Index)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mode).To(Equal(bondModes["active-backup"]))
+			Expect(miimon).To(BeEquivalentTo(defaultMiimon))
+
+			for _, name := range []string{"link0", "link1"} {
+				link, err := netlink.LinkByName(name)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(link.Attrs().MasterIndex).To(Equal(bond.Attrs().Index))
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		check := func(mode string) error {
+			checkConf := map[string]interface{}{
+				"name":       "mynet",
+				"type":       "bond",
+				"links":      []string{"link0", "link1"},
+				"mode":       mode,
+				"prevResult": result,
+			}
+			stdin, err := json.Marshal(checkConf)
+			Expect(err).NotTo(HaveOccurred())
+
+			checkArgs := *args
+			checkArgs.StdinData = stdin
+			return testutils.CmdCheckWithResult(targetNs.Path(), IFNAME, func() error {
+				return cmdCheck(&checkArgs)
+			})
+		}
+
+		Expect(check("")).To(Succeed())
+		Expect(check("802.3ad")).To(MatchError(`bond "bond0" is not in "802.3ad" mode`))
+
+		// CHECK fails once a link has been freed
+		err = targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			link, err := netlink.LinkByName("link1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetMasterByIndex(link, 0)).To(Succeed())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(check("")).To(MatchError(`"link1" is not enslaved to bond "bond0"`))
+
+		err = testutils.CmdDelWithResult(targetNs.Path(), IFNAME, func() error {
+			return cmdDel(args)
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			_, err := netlink.LinkByName(IFNAME)
+			Expect(err).To(HaveOccurred())
+
+			link, err := netlink.LinkByName("link0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link.Attrs().MasterIndex).To(Equal(0))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("leaves the links alone when they cannot all be enslaved", func() {
+		conf := &NetConf{
+			NetConf: types.NetConf{Name: "mynet", Type: "bond"},
+			Links:   []string{"link0", "missing0"},
+		}
+
+		err := targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			_, err := createBond(conf, IFNAME)
+			Expect(err).To(MatchError(`failed to lookup link "missing0": Link not found`))
+
+			_, err = netlink.LinkByName(IFNAME)
+			Expect(err).To(HaveOccurred())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
@@ -19,28 +19,106 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
+	"os/exec"
 	"runtime"
+	"strings"
 	"syscall"
 
 	"github.com/containernetworking/cni/pkg/ip"
 	"github.com/containernetworking/cni/pkg/ipam"
+	"github.com/containernetworking/cni/pkg/logging"
 	"github.com/containernetworking/cni/pkg/ns"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
 	"github.com/containernetworking/cni/pkg/utils"
+	"github.com/containernetworking/cni/pkg/utils/sysctl"
 	"github.com/vishvananda/netlink"
 )
 
 const defaultBrName = "cni0"
 
+// stateDir holds the configuration each attachment was added with, so
+// that it can be torn down after the network configuration is gone
+const stateDir = "/var/lib/cni/bridge"
+
 type NetConf struct {
 	types.NetConf
-	BrName      string `json:"bridge"`
-	IsGW        bool   `json:"isGateway"`
-	IsDefaultGW bool   `json:"isDefaultGateway"`
-	IPMasq      bool   `json:"ipMasq"`
-	MTU         int    `json:"mtu"`
-	HairpinMode bool   `json:"hairpinMode"`
+	// PrevResult is the result of the ADD being checked, in any version
+	PrevResult     *current.Result    `json:"-"`
+	BrName         string             `json:"bridge"`
+	IsGW           GatewayFamilies    `json:"isGateway"`
+	IsDefaultGW    GatewayFamilies    `json:"isDefaultGateway"`
+	IPMasq         bool               `json:"ipMasq"`
+	IPMasqBackend  ip.FirewallBackend `json:"ipMasqBackend,omitempty"`
+	IPMasqExclude  []types.IPNet      `json:"ipMasqExclude,omitempty"`
+	MTU            int                `json:"mtu"`
+	HairpinMode    bool               `json:"hairpinMode"`
+	IfNameConflict ip.IfNamePolicy    `json:"ifNameConflict,omitempty"`
+	MacSpoofChk    bool               `json:"macspoofchk,omitempty"`
+	ProxyARP       bool               `json:"proxyArp"`
+	ProxyARPIface  string             `json:"proxyArpInterface,omitempty"`
+	Uplink         string             `json:"uplink,omitempty"`
+	UplinkVLAN     int                `json:"uplinkVlan,omitempty"`
+	Clsact         bool               `json:"clsact,omitempty"`
+	BPFIngress     string             `json:"bpfIngress,omitempty"`
+	BPFEgress      string             `json:"bpfEgress,omitempty"`
+	// IngressRate and EgressRate limit the traffic sent to and by the
+	// container to that many bits per second, with buckets of
+	// IngressBurst and EgressBurst bits
+	IngressRate  uint64 `json:"ingressRate,omitempty"`
+	IngressBurst uint64 `json:"ingressBurst,omitempty"`
+	EgressRate   uint64 `json:"egressRate,omitempty"`
+	EgressBurst  uint64 `json:"egressBurst,omitempty"`
+	// RouteMTU and RouteAdvMSS are set on the routes of the container
+	// that the IPAM result does not give its own, so that TCP picks an
+	// MSS that fits the encapsulation of an overlay underneath
+	RouteMTU      int            `json:"routeMtu,omitempty"`
+	RouteAdvMSS   int            `json:"routeAdvmss,omitempty"`
+	RouteMTULock  bool           `json:"routeMtuLock,omitempty"`
+	Log           logging.Config `json:"log,omitempty"`
+	RuntimeConfig struct {
+		// Mac is the hardware address the runtime asks for on the
+		// container interface, with the "mac" capability
+		Mac string `json:"mac,omitempty"`
+	} `json:"runtimeConfig,omitempty"`
+	// ValidAttachments are the attachments of the network still in
+	// use, given on GC
+	ValidAttachments []utils.Attachment `json:"cni.dev/valid-attachments,omitempty"`
+
+	// mac is RuntimeConfig.Mac parsed, nil if there is none
+	mac net.HardwareAddr
+}
+
+// GatewayFamilies enables a gateway setting per address family. In the
+// configuration it is either a boolean applying to both families or an
+// object such as {"ipv4": true, "ipv6": false}.
+type GatewayFamilies struct {
+	IPv4 bool `json:"ipv4"`
+	IPv6 bool `json:"ipv6"`
+}
+
+func (g *GatewayFamilies) UnmarshalJSON(data []byte) error {
+	var all bool
+	if err := json.Unmarshal(data, &all); err == nil {
+		g.IPv4, g.IPv6 = all, all
+		return nil
+	}
+
+	type families GatewayFamilies
+	if err := json.Unmarshal(data, (*families)(g)); err != nil {
+		return fmt.Errorf("must be a boolean or an object with ipv4 and ipv6 booleans: %v", err)
+	}
+	return nil
+}
+
+// For reports whether the setting is enabled for the family of ip
+func (g GatewayFamilies) For(ip net.IP) bool {
+	if ip.To4() != nil {
+		return g.IPv4
+	}
+	return g.IPv6
 }
 
 func init() {
@@ -57,23 +135,81 @@ func loadNetConf(bytes []byte) (*NetConf, error) {
 	if err := json.Unmarshal(bytes, n); err != nil {
 		return nil, fmt.Errorf("failed to load netconf: %v", err)
 	}
+	prev, err := current.ParsePrevResult(bytes)
+	if err != nil {
+		return nil, err
+	}
+	n.PrevResult = prev
+	if n.RuntimeConfig.Mac != "" {
+		mac, err := net.ParseMAC(n.RuntimeConfig.Mac)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mac %q in runtimeConfig: %v", n.RuntimeConfig.Mac, err)
+		}
+		n.mac = mac
+	}
+	if n.RouteMTU < 0 || n.RouteAdvMSS < 0 {
+		return nil, fmt.Errorf("routeMtu and routeAdvmss must not be negative")
+	}
+	if n.RouteMTULock && n.RouteMTU == 0 {
+		return nil, fmt.Errorf("routeMtuLock requires routeMtu")
+	}
 	return n, nil
 }
 
+// loadDelConf returns the configuration the attachment was added with,
+// or the one given if none was saved, along with its raw form
+func loadDelConf(cache *ipam.ConfCache, args *skel.CmdArgs) (*NetConf, []byte, bool, error) {
+	_, stdin, found, err := cache.Load(args.ContainerID, args.IfName)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if !found {
+		stdin = args.StdinData
+	}
+
+	n, err := loadNetConf(stdin)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return n, stdin, found, nil
+}
+
+// savedAddrs returns the container addresses of the result saved when
+// the attachment was added, if any
+func savedAddrs(result *current.Result) []*net.IPNet {
+	if result == nil {
+		return nil
+	}
+
+	var ipns []*net.IPNet
+	for _, ipc := range result.IPs {
+		ipn := ipc.Address
+		ipns = append(ipns, &ipn)
+	}
+	return ipns
+}
+
 func ensureBridgeAddr(br *netlink.Bridge, ipn *net.IPNet) error {
-	addrs, err := netlink.AddrList(br, syscall.AF_INET)
+	family := netlink.FAMILY_V4
+	if ipn.IP.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+
+	addrs, err := netlink.AddrList(br, family)
 	if err != nil && err != syscall.ENOENT {
 		return fmt.Errorf("could not get list of IP addresses: %v", err)
 	}
 
-	// if there're no addresses on the bridge, it's ok -- we'll add one
-	if len(addrs) > 0 {
-		ipnStr := ipn.String()
-		for _, a := range addrs {
-			// string comp is actually easiest for doing IPNet comps
-			if a.IPNet.String() == ipnStr {
-				return nil
-			}
+	// if there're no addresses on the bridge, it's ok -- we'll add one.
+	// The kernel assigns IPv6 link-local addresses by itself.
+	ipnStr := ipn.String()
+	for _, a := range addrs {
+		if a.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		// string comp is actually easiest for doing IPNet comps
+		if a.IPNet.String() == ipnStr {
+			return nil
 		}
 		return fmt.Errorf("%q already has an IP address different from %v", br.Name, ipn.String())
 	}
@@ -114,6 +250,7 @@ func ensureBridge(brName string, mtu int) (*netlink.Bridge, error) {
 		if err != syscall.EEXIST {
 			return nil, fmt.Errorf("could not add %q: %v", brName, err)
 		}
+		logging.Debugf("bridge %q already exists", brName)
 
 		// it's ok if the device already exists as long as config is similar
 		br, err = bridgeByName(brName)
@@ -122,47 +259,63 @@ func ensureBridge(brName string, mtu int) (*netlink.Bridge, error) {
 		}
 	}
 
-	if err := netlink.LinkSetUp(br); err != nil {
+	up := true
+	if err := ip.SetLinkProperties(brName, ip.LinkProperties{Up: &up}); err != nil {
 		return nil, err
 	}
 
 	return br, nil
 }
 
-func setupVeth(netns ns.NetNS, br *netlink.Bridge, ifName string, mtu int, hairpinMode bool) error {
+// setupVeth connects the container to br and returns the names of the
+// container interface, which differs from ifName if that was taken and
+// policy allowed picking another one, and of the host interface. The
+// container interface is given mac, unless it is nil.
+func setupVeth(netns ns.NetNS, br *netlink.Bridge, ifName string, policy ip.IfNamePolicy, mtu int, mac net.HardwareAddr, hairpinMode bool) (string, string, error) {
 	var hostVethName string
 
 	err := netns.Do(func(hostNS ns.NetNS) error {
+		var err error
+		if ifName, err = ip.ResolveIfName(ifName, policy); err != nil {
+			return err
+		}
+
 		// create the veth pair in the container and move host end into host netns
 		hostVeth, _, err := ip.SetupVeth(ifName, mtu, hostNS)
 		if err != nil {
 			return err
 		}
+		if mac != nil {
+			if err := ip.SetHWAddr(ifName, mac); err != nil {
+				return err
+			}
+		}
 
 		hostVethName = hostVeth.Attrs().Name
+		logging.Debugf("created veth pair %q in container and %q on host", ifName, hostVethName)
 		return nil
 	})
 	if err != nil {
-		return err
+		return "", "", err
 	}
 
 	// need to lookup hostVeth again as its index has changed during ns move
 	hostVeth, err := netlink.LinkByName(hostVethName)
 	if err != nil {
-		return fmt.Errorf("failed to lookup %q: %v", hostVethName, err)
+		return "", "", fmt.Errorf("failed to lookup %q: %v", hostVethName, err)
 	}
 
 	// connect host veth end to the bridge
 	if err = netlink.LinkSetMaster(hostVeth, br); err != nil {
-		return fmt.Errorf("failed to connect %q to bridge %v: %v", hostVethName, br.Attrs().Name, err)
+		return "", "", fmt.Errorf("failed to connect %q to bridge %v: %v", hostVethName, br.Attrs().Name, err)
 	}
 
 	// set hairpin mode
 	if err = netlink.LinkSetHairpin(hostVeth, hairpinMode); err != nil {
-		return fmt.Errorf("failed to setup hairpin mode for %v: %v", hostVethName, err)
+		return "", "", fmt.Errorf("failed to setup hairpin mode for %v: %v", hostVethName, err)
 	}
 
-	return nil
+	return ifName, hostVethName, nil
 }
 
 func calcGatewayIP(ipn *net.IPNet) net.IP {
@@ -170,6 +323,145 @@ func calcGatewayIP(ipn *net.IPNet) net.IP {
 	return ip.NextIP(nid)
 }
 
+// resultIPConfigs returns the IP configuration of each family in r
+func resultIPConfigs(r *types.Result) []*types.IPConfig {
+	var ipcs []*types.IPConfig
+	for _, ipc := range []*types.IPConfig{r.IP4, r.IP6} {
+		if ipc != nil {
+			ipcs = append(ipcs, ipc)
+		}
+	}
+	return ipcs
+}
+
+// addDefaultRoute adds a default route via the gateway to ipc, failing if
+// IPAM already routes the default through a different gateway
+func addDefaultRoute(ipc *types.IPConfig) error {
+	defaultNet := &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}
+	if ipc.IP.IP.To4() == nil {
+		defaultNet = &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+	}
+
+	for _, route := range ipc.Routes {
+		if defaultNet.String() == route.Dst.String() {
+			if route.GW != nil && !route.GW.Equal(ipc.Gateway) {
+				return fmt.Errorf(
+					"isDefaultGateway ineffective because IPAM sets default route via %q",
+					route.GW,
+				)
+			}
+		}
+	}
+
+	ipc.Routes = append(ipc.Routes, types.Route{Dst: *defaultNet, GW: ipc.Gateway})
+	return nil
+}
+
+// setRouteMetrics applies routeMtu and routeAdvmss to the routes of ipc
+// that have no MTU or advertised MSS of their own
+func setRouteMetrics(n *NetConf, ipc *types.IPConfig) {
+	for i := range ipc.Routes {
+		r := &ipc.Routes[i]
+		if r.MTU == 0 && n.RouteMTU > 0 {
+			r.MTU = n.RouteMTU
+			r.LockMTU = n.RouteMTULock
+		}
+		if r.AdvMSS == 0 {
+			r.AdvMSS = n.RouteAdvMSS
+		}
+	}
+}
+
+// The host lookups of checkPrerequisites, replaced in tests
+var (
+	lookPath = exec.LookPath
+	// vlanProcDir only exists once the 8021q module is loaded
+	vlanProcDir = "/proc/net/vlan"
+	// brNetfilterDir only exists once the br_netfilter module is loaded
+	brNetfilterDir = "/proc/sys/net/bridge"
+	settable       = sysctl.Settable
+)
+
+// checkPrerequisites fails before anything is created if the host lacks
+// a module, tool, interface or sysctl that an option of n depends on,
+// naming the option and how to resolve it; ADD would otherwise fail
+// halfway through, leaving a bridge or veth behind.
+func checkPrerequisites(n *NetConf) error {
+	var missing []string
+	if n.Uplink != "" {
+		if _, err := netlink.LinkByName(n.Uplink); err != nil {
+			missing = append(missing, fmt.Sprintf("uplink %q does not exist: create it or change uplink", n.Uplink))
+		}
+	}
+	if n.UplinkVLAN != 0 {
+		if _, err := os.Stat(vlanProcDir); err != nil {
+			missing = append(missing, "uplinkVlan needs the 8021q module, which is not loaded: load 8021q or remove uplinkVlan")
+		}
+	}
+	if n.IsGW.IPv4 {
+		if err := settable("net.ipv4.ip_forward", "1"); err != nil {
+			missing = append(missing, fmt.Sprintf("isGateway needs IPv4 forwarding (%v): set net.ipv4.ip_forward to 1 or disable isGateway", err))
+		}
+	}
+	// a kernel without IPv6 has no forwarding to enable, nor addresses to
+	// forward for
+	if n.IsGW.IPv6 {
+		if err := settable("net.ipv6.conf.all.forwarding", "1"); err != nil && !os.IsNotExist(err) {
+			missing = append(missing, fmt.Sprintf("isGateway needs IPv6 forwarding (%v): set net.ipv6.conf.all.forwarding to 1 or disable isGateway for ipv6", err))
+		}
+	}
+	if n.HairpinMode {
+		if _, err := os.Stat(brNetfilterDir); err != nil {
+			missing = append(missing, "hairpinMode needs the br_netfilter module, which is not loaded: load br_netfilter or disable hairpinMode")
+		}
+	}
+	if n.ProxyARP && n.ProxyARPIface != "" {
+		if _, err := netlink.LinkByName(n.ProxyARPIface); err != nil {
+			missing = append(missing, fmt.Sprintf("proxyArp needs the interface %q, which does not exist: create it or change proxyArpInterface", n.ProxyARPIface))
+		}
+	}
+	for _, hook := range []struct{ option, path string }{
+		{"bpfIngress", n.BPFIngress},
+		{"bpfEgress", n.BPFEgress},
+	} {
+		if hook.path == "" {
+			continue
+		}
+		if _, err := os.Stat(hook.path); err != nil {
+			missing = append(missing, fmt.Sprintf("%s needs a program pinned at %q, which does not exist: pin it or remove %s", hook.option, hook.path, hook.option))
+		}
+		if _, err := lookPath("tc"); err != nil {
+			missing = append(missing, fmt.Sprintf("%s needs tc, which is not installed: install iproute2 or remove %s", hook.option, hook.option))
+		}
+	}
+	if n.MacSpoofChk {
+		if _, err := lookPath("nft"); err != nil {
+			missing = append(missing, "macspoofchk needs nft, which is not installed: install nftables or disable macspoofchk")
+		}
+	}
+	if n.IPMasq {
+		tools := map[ip.FirewallBackend][]string{
+			ip.FirewallBackendAuto:     {"iptables", "nft"},
+			ip.FirewallBackendIPTables: {"iptables"},
+			ip.FirewallBackendNFTables: {"nft"},
+		}[n.IPMasqBackend]
+		found := len(tools) == 0
+		for _, tool := range tools {
+			if _, err := lookPath(tool); err == nil {
+				found = true
+			}
+		}
+		if !found {
+			missing = append(missing, fmt.Sprintf("ipMasq needs %s, which is not installed: install it or disable ipMasq", strings.Join(tools, " or ")))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("the host is missing prerequisites of the configuration: %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
+
 func setupBridge(n *NetConf) (*netlink.Bridge, error) {
 	// create bridge if necessary
 	br, err := ensureBridge(n.BrName, n.MTU)
@@ -177,17 +469,147 @@ func setupBridge(n *NetConf) (*netlink.Bridge, error) {
 		return nil, fmt.Errorf("failed to create bridge %q: %v", n.BrName, err)
 	}
 
+	if n.Uplink != "" {
+		if err := ensureUplink(br, n.Uplink, n.UplinkVLAN); err != nil {
+			return nil, fmt.Errorf("failed to attach uplink %q to bridge %q: %v", n.Uplink, n.BrName, err)
+		}
+	}
+
 	return br, nil
 }
 
+// ensureUplink enslaves the host interface uplink to br, or its VLAN
+// subinterface uplink.<vlan> if vlan is not zero, creating that if needed
+func ensureUplink(br *netlink.Bridge, uplink string, vlan int) error {
+	if vlan < 0 || vlan > 4094 {
+		return fmt.Errorf("invalid VLAN ID %d", vlan)
+	}
+
+	link, err := netlink.LinkByName(uplink)
+	if err != nil {
+		return fmt.Errorf("could not lookup %q: %v", uplink, err)
+	}
+	if vlan != 0 {
+		if link, err = ensureVLAN(link, vlan); err != nil {
+			return err
+		}
+	}
+
+	switch master := link.Attrs().MasterIndex; master {
+	case br.Attrs().Index:
+	case 0:
+		if err := netlink.LinkSetMaster(link, br); err != nil {
+			return fmt.Errorf("failed to connect %q to bridge %v: %v", link.Attrs().Name, br.Attrs().Name, err)
+		}
+	default:
+		return fmt.Errorf("%q is already enslaved to another device", link.Attrs().Name)
+	}
+
+	up := true
+	return ip.SetLinkProperties(link.Attrs().Name, ip.LinkProperties{Up: &up})
+}
+
+// ensureVLAN returns the VLAN subinterface with the given ID on parent,
+// creating it if it does not exist yet
+func ensureVLAN(parent netlink.Link, vlan int) (netlink.Link, error) {
+	name := fmt.Sprintf("%s.%d", parent.Attrs().Name, vlan)
+	v := &netlink.Vlan{
+		LinkAttrs: netlink.LinkAttrs{
+			Name:        name,
+			ParentIndex: parent.Attrs().Index,
+		},
+		VlanId: vlan,
+	}
+	if err := netlink.LinkAdd(v); err != nil && err != syscall.EEXIST {
+		return nil, fmt.Errorf("could not add %q: %v", name, err)
+	}
+
+	// it's ok if the device already exists as long as it is the same VLAN
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not lookup %q: %v", name, err)
+	}
+	existing, ok := link.(*netlink.Vlan)
+	if !ok || existing.VlanId != vlan || existing.Attrs().ParentIndex != parent.Attrs().Index {
+		return nil, fmt.Errorf("%q already exists but is not VLAN %d of %q", name, vlan, parent.Attrs().Name)
+	}
+	return existing, nil
+}
+
+func setupLogging(n *NetConf, command string, args *skel.CmdArgs) (func(), error) {
+	return logging.Setup(n.Log, "plugin", "bridge", "command", command,
+		"containerID", args.ContainerID, "ifName", args.IfName, "network", n.Name)
+}
+
+// setupTCHooks adds a clsact qdisc to the host end of the veth and
+// attaches the configured pinned programs, before IPAM hands the container
+// an address, so that an agent instrumenting its traffic never misses the
+// first packets
+func setupTCHooks(n *NetConf, hostVethName string) error {
+	if !n.Clsact && n.BPFIngress == "" && n.BPFEgress == "" {
+		return nil
+	}
+	if err := ip.EnsureClsact(hostVethName); err != nil {
+		return err
+	}
+	logging.Debugf("added clsact qdisc to %q", hostVethName)
+
+	for _, hook := range []struct{ name, path string }{
+		{"ingress", n.BPFIngress},
+		{"egress", n.BPFEgress},
+	} {
+		if hook.path == "" {
+			continue
+		}
+		if err := ip.AttachPinnedBPF(hostVethName, hook.name, hook.path); err != nil {
+			return err
+		}
+		logging.Debugf("attached %q to %s of %q", hook.path, hook.name, hostVethName)
+	}
+	return nil
+}
+
+// setupRateLimits shapes the traffic of the container on the veth. What
+// the host end sends goes to the container, while what the container
+// sends only reaches the host end as ingress, which tbf cannot queue, so
+// that is shaped on the container end.
+func setupRateLimits(n *NetConf, netns ns.NetNS, ifName, hostVethName string) error {
+	if n.IngressRate != 0 {
+		if err := ip.SetRateLimit(hostVethName, n.IngressRate, n.IngressBurst); err != nil {
+			return err
+		}
+		logging.Debugf("limited traffic to the container on %q to %d bit/s", hostVethName, n.IngressRate)
+	}
+	if n.EgressRate == 0 {
+		return nil
+	}
+	err := netns.Do(func(_ ns.NetNS) error {
+		return ip.SetRateLimit(ifName, n.EgressRate, n.EgressBurst)
+	})
+	if err != nil {
+		return err
+	}
+	logging.Debugf("limited traffic from the container on %q to %d bit/s", ifName, n.EgressRate)
+	return nil
+}
+
 func cmdAdd(args *skel.CmdArgs) error {
 	n, err := loadNetConf(args.StdinData)
 	if err != nil {
 		return err
 	}
 
-	if n.IsDefaultGW {
-		n.IsGW = true
+	closeLog, err := setupLogging(n, "ADD", args)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	n.IsGW.IPv4 = n.IsGW.IPv4 || n.IsDefaultGW.IPv4
+	n.IsGW.IPv6 = n.IsGW.IPv6 || n.IsDefaultGW.IPv6
+
+	if err := checkPrerequisites(n); err != nil {
+		return err
 	}
 
 	br, err := setupBridge(n)
@@ -201,119 +623,454 @@ func cmdAdd(args *skel.CmdArgs) error {
 	}
 	defer netns.Close()
 
-	if err = setupVeth(netns, br, args.IfName, n.MTU, n.HairpinMode); err != nil {
+	ifName, hostVethName, err := setupVeth(netns, br, args.IfName, n.IfNameConflict, n.MTU, n.mac, n.HairpinMode)
+	if err != nil {
+		return err
+	}
+
+	if err := setupTCHooks(n, hostVethName); err != nil {
+		return err
+	}
+	if err := setupRateLimits(n, netns, ifName, hostVethName); err != nil {
 		return err
 	}
 
 	// run the IPAM plugin and get back the config to apply
-	result, err := ipam.ExecAdd(n.IPAM.Type, args.StdinData)
+	cache := ipam.NewConfCache(stateDir)
+	result, err := ipam.ExecAddWithCache(cache, args.ContainerID, args.IfName, n.IPAM.Type, args.StdinData)
 	if err != nil {
+		logging.Errorf("IPAM plugin %q failed: %v", n.IPAM.Type, err)
 		return err
 	}
+	logging.Debugf("IPAM plugin %q returned %v", n.IPAM.Type, result)
 
-	// TODO: make this optional when IPv6 is supported
-	if result.IP4 == nil {
-		return errors.New("IPAM plugin returned missing IPv4 config")
+	ipConfigs := resultIPConfigs(result)
+	if len(ipConfigs) == 0 {
+		return errors.New("IPAM plugin returned no IP config")
 	}
 
-	if result.IP4.Gateway == nil && n.IsGW {
-		result.IP4.Gateway = calcGatewayIP(&result.IP4.IP)
+	for _, ipc := range ipConfigs {
+		if ipc.Gateway == nil && n.IsGW.For(ipc.IP.IP) {
+			ipc.Gateway = calcGatewayIP(&ipc.IP)
+		}
 	}
 
+	var mac net.HardwareAddr
 	if err := netns.Do(func(_ ns.NetNS) error {
-		// set the default gateway if requested
-		if n.IsDefaultGW {
-			_, defaultNet, err := net.ParseCIDR("0.0.0.0/0")
-			if err != nil {
+		// set the default gateway of each family if requested
+		for _, ipc := range ipConfigs {
+			if !n.IsDefaultGW.For(ipc.IP.IP) {
+				continue
+			}
+			if err := addDefaultRoute(ipc); err != nil {
 				return err
 			}
+		}
+		for _, ipc := range ipConfigs {
+			setRouteMetrics(n, ipc)
+		}
 
-			for _, route := range result.IP4.Routes {
-				if defaultNet.String() == route.Dst.String() {
-					if route.GW != nil && !route.GW.Equal(result.IP4.Gateway) {
-						return fmt.Errorf(
-							"isDefaultGateway ineffective because IPAM sets default route via %q",
-							route.GW,
-						)
-					}
-				}
+		// unless the runtime asked for one, the MAC address is derived
+		// from the IPv4 address only
+		if n.mac == nil && result.IP4 != nil {
+			if err := ip.SetHWAddrByIP(ifName, result.IP4.IP.IP); err != nil {
+				return err
 			}
+		}
 
-			result.IP4.Routes = append(
-				result.IP4.Routes,
-				types.Route{Dst: *defaultNet, GW: result.IP4.Gateway},
-			)
-
-			// TODO: IPV6
+		if err := ipam.ConfigureIface(ifName, current.NewResultFromLegacy(result)); err != nil {
+			return err
 		}
 
-		return ipam.ConfigureIface(args.IfName, result)
+		link, err := netlink.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+		}
+		mac = link.Attrs().HardwareAddr
+		return nil
 	}); err != nil {
 		return err
 	}
 
-	if n.IsGW {
+	var gwIPs []*current.IPConfig
+	for _, ipc := range current.NewResultFromLegacy(result).IPs {
+		if !n.IsGW.For(ipc.Address.IP) {
+			continue
+		}
 		gwn := &net.IPNet{
-			IP:   result.IP4.Gateway,
-			Mask: result.IP4.IP.Mask,
+			IP:   ipc.Gateway,
+			Mask: ipc.Address.Mask,
 		}
 
 		if err = ensureBridgeAddr(br, gwn); err != nil {
 			return err
 		}
+		logging.Debugf("bridge %q has gateway address %v", n.BrName, gwn)
+		gwIPs = append(gwIPs, ipc)
+	}
 
-		if err := ip.EnableIP4Forward(); err != nil {
+	if len(gwIPs) > 0 {
+		if err := ip.EnableForward(gwIPs); err != nil {
 			return fmt.Errorf("failed to enable forwarding: %v", err)
 		}
 	}
 
 	if n.IPMasq {
-		chain := utils.FormatChainName(n.Name, args.ContainerID)
-		comment := utils.FormatComment(n.Name, args.ContainerID)
-		if err = ip.SetupIPMasq(ip.Network(&result.IP4.IP), chain, comment); err != nil {
+		chain, err := utils.FormatChainNameWithPrefix("CNI-", n.Name, args.ContainerID, args.IfName)
+		if err != nil {
+			return err
+		}
+		comment := utils.FormatCommentWithIfName(n.Name, args.ContainerID, args.IfName)
+		exclude := make([]*net.IPNet, len(n.IPMasqExclude))
+		for i := range n.IPMasqExclude {
+			exclude[i] = (*net.IPNet)(&n.IPMasqExclude[i])
+		}
+		for _, ipc := range ipConfigs {
+			if err = ip.SetupIPMasqWithBackend(n.IPMasqBackend, ip.Network(&ipc.IP), chain, comment, exclude...); err != nil {
+				return err
+			}
+			logging.Debugf("set up masquerading for %v in chain %q", ipc.IP.String(), chain)
+		}
+	}
+
+	if n.ProxyARP {
+		var ips []net.IP
+		for _, ipc := range ipConfigs {
+			ips = append(ips, ipc.IP.IP)
+		}
+		if err = ip.SetupProxyNeigh(n.ProxyARPIface, ips); err != nil {
 			return err
 		}
+		logging.Debugf("published %v with proxy ARP/NDP", ips)
 	}
 
-	result.DNS = n.DNS
-	return result.Print()
+	if n.MacSpoofChk {
+		chain, err := utils.FormatChainNameWithPrefix("CNI-", n.Name, args.ContainerID, args.IfName)
+		if err != nil {
+			return err
+		}
+		comment := utils.FormatCommentWithIfName(n.Name, args.ContainerID, args.IfName)
+		var ips []net.IP
+		for _, ipc := range ipConfigs {
+			ips = append(ips, ipc.IP.IP)
+		}
+		if err = ip.SetupSpoofCheck(hostVethName, mac, ips, chain, comment); err != nil {
+			return fmt.Errorf("failed to set up spoof checking on %q: %v", hostVethName, err)
+		}
+		logging.Debugf("restricted %q to %v and %v in chain %q", hostVethName, mac, ips, chain)
+	}
+
+	logging.Infof("attached %q to bridge %q with %v", ifName, n.BrName, result)
+	result.DNS = types.MergeDNS(n.DNS, result.DNS)
+	res := current.NewResultFromLegacy(result)
+	res.AddSandboxInterface(ifName, mac.String(), args.Netns)
+	if err := cache.SaveResult(args.ContainerID, args.IfName, res); err != nil {
+		return fmt.Errorf("failed to save attachment state: %v", err)
+	}
+	return current.PrintResult(res, n.CNIVersion)
 }
 
-func cmdDel(args *skel.CmdArgs) error {
+func cmdCheck(args *skel.CmdArgs) error {
 	n, err := loadNetConf(args.StdinData)
 	if err != nil {
 		return err
 	}
 
-	if err := ipam.ExecDel(n.IPAM.Type, args.StdinData); err != nil {
+	closeLog, err := setupLogging(n, "CHECK", args)
+	if err != nil {
 		return err
 	}
+	defer closeLog()
 
-	if args.Netns == "" {
-		return nil
+	if n.PrevResult == nil {
+		return errors.New("required prevResult missing")
+	}
+	n.IsGW.IPv4 = n.IsGW.IPv4 || n.IsDefaultGW.IPv4
+	n.IsGW.IPv6 = n.IsGW.IPv6 || n.IsDefaultGW.IPv6
+	result := n.PrevResult
+	ifName := args.IfName
+	if name := result.SandboxInterface(); name != "" {
+		ifName = name
 	}
 
-	var ipn *net.IPNet
-	err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
-		var err error
-		ipn, err = ip.DelLinkByNameAddr(args.IfName, netlink.FAMILY_V4)
+	br, err := bridgeByName(n.BrName)
+	if err != nil {
 		return err
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+	}
+	defer netns.Close()
+
+	var hostVethIndex int
+	err = netns.Do(func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+		}
+		hostVethIndex, err = ip.VethPeerIndex(link)
+		if err != nil {
+			return err
+		}
+		if n.mac != nil && link.Attrs().HardwareAddr.String() != n.mac.String() {
+			return fmt.Errorf("%q has MAC %v, not %v", ifName, link.Attrs().HardwareAddr, n.mac)
+		}
+		if err := checkRateLimit(ifName, n.EgressRate); err != nil {
+			return err
+		}
+		return ipam.CheckIface(ifName, result)
 	})
 	if err != nil {
 		return err
 	}
 
+	drift, err := bridgeDrift(n, br, result)
+	if err != nil {
+		return err
+	}
+	portDrift, err := portDrift(n, br, hostVethIndex)
+	if err != nil {
+		return err
+	}
+	if drift = append(drift, portDrift...); len(drift) > 0 {
+		return fmt.Errorf("bridge %q differs from its configuration: %s", n.BrName, strings.Join(drift, "; "))
+	}
+	return nil
+}
+
+// bridgeDrift describes how br differs from what ADD set up for n and
+// result, if it does
+func bridgeDrift(n *NetConf, br *netlink.Bridge, result *current.Result) ([]string, error) {
+	var drift []string
+	if br.Attrs().Flags&net.FlagUp == 0 {
+		drift = append(drift, "it is down")
+	}
+	if n.MTU != 0 && br.Attrs().MTU != n.MTU {
+		drift = append(drift, fmt.Sprintf("its MTU is %d, not %d", br.Attrs().MTU, n.MTU))
+	}
+	// ADD gives ports no VLAN membership, they depend on filtering being off
+	vlanFiltering, err := ip.BridgeVlanFiltering(br)
+	if err != nil {
+		return nil, fmt.Errorf("could not read vlan_filtering of %q: %v", n.BrName, err)
+	}
+	if vlanFiltering {
+		drift = append(drift, "vlan_filtering is enabled")
+	}
+
+	addrs, err := netlink.AddrList(br, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("could not get list of IP addresses: %v", err)
+	}
+	for _, ipc := range result.IPs {
+		if ipc.Gateway == nil || !n.IsGW.For(ipc.Address.IP) {
+			continue
+		}
+		gwn := &net.IPNet{IP: ipc.Gateway, Mask: ipc.Address.Mask}
+		found := false
+		for _, a := range addrs {
+			if a.IPNet.String() == gwn.String() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			drift = append(drift, fmt.Sprintf("gateway address %v is missing", gwn))
+		}
+	}
+
+	if n.Uplink != "" {
+		name := n.Uplink
+		if n.UplinkVLAN != 0 {
+			name = fmt.Sprintf("%s.%d", n.Uplink, n.UplinkVLAN)
+		}
+		link, err := netlink.LinkByName(name)
+		switch {
+		case err != nil:
+			drift = append(drift, fmt.Sprintf("uplink %q is missing", name))
+		case link.Attrs().MasterIndex != br.Attrs().Index:
+			drift = append(drift, fmt.Sprintf("uplink %q is not a port", name))
+		}
+	}
+	return drift, nil
+}
+
+// portDrift describes how the host end of the veth of the container
+// differs from what ADD set up for n, if it does
+func portDrift(n *NetConf, br *netlink.Bridge, hostVethIndex int) ([]string, error) {
+	hostVeth, err := netlink.LinkByIndex(hostVethIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup the host end of the veth: %v", err)
+	}
+	name := hostVeth.Attrs().Name
+	if hostVeth.Attrs().MasterIndex != br.Attrs().Index {
+		return []string{fmt.Sprintf("%q is not a port", name)}, nil
+	}
+
+	protinfo, err := netlink.LinkGetProtinfo(hostVeth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the port settings of %q: %v", name, err)
+	}
+	if protinfo.Hairpin != n.HairpinMode {
+		return []string{fmt.Sprintf("hairpin mode of port %q is %s, not %s", name, onOff(protinfo.Hairpin), onOff(n.HairpinMode))}, nil
+	}
+	if err := checkRateLimit(name, n.IngressRate); err != nil {
+		return []string{err.Error()}, nil
+	}
+	return nil, nil
+}
+
+// checkRateLimit returns an error if the traffic sent by ifName is not
+// limited to rate bits per second, or is limited while rate is 0
+func checkRateLimit(ifName string, rate uint64) error {
+	got, err := ip.RateLimit(ifName)
+	if err != nil {
+		return err
+	}
+	// the kernel keeps whole bytes per second
+	if got != rate/8*8 {
+		return fmt.Errorf("%q is rate limited to %d bit/s, not %d", ifName, got, rate)
+	}
+	return nil
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	cache := ipam.NewConfCache(stateDir)
+	n, stdin, cached, err := loadDelConf(cache, args)
+	if err != nil {
+		return err
+	}
+
+	closeLog, err := setupLogging(n, "DEL", args)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+	if cached {
+		logging.Debugf("tearing down with the configuration saved at ADD")
+	}
+
+	if err := ipam.ExecDel(n.IPAM.Type, stdin); err != nil {
+		logging.Errorf("IPAM plugin %q failed: %v", n.IPAM.Type, err)
+		return err
+	}
+
+	saved, err := cache.LoadResult(args.ContainerID, args.IfName)
+	if err != nil {
+		return err
+	}
+	// the container interface has another name if args.IfName was taken
+	ifName := args.IfName
+	if saved != nil && saved.SandboxInterface() != "" {
+		ifName = saved.SandboxInterface()
+	}
+
+	var ipns []*net.IPNet
+	if args.Netns == "" {
+		logging.Debugf("no netns given, skipping interface teardown")
+	} else {
+		err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+			var err error
+			ipns, err = ip.DelLinkByNameAddr(ifName)
+			return err
+		})
+		switch err.(type) {
+		case nil:
+			logging.Debugf("deleted %q with addresses %v", ifName, ipns)
+		case ns.NSPathNotExistErr, ns.NSPathDeadErr:
+			logging.Debugf("netns %q is gone, skipping interface teardown", args.Netns)
+		default:
+			return err
+		}
+	}
+
+	// without the container its addresses are only known from the ADD
+	if ipns == nil {
+		ipns = savedAddrs(saved)
+	}
+
 	if n.IPMasq {
-		chain := utils.FormatChainName(n.Name, args.ContainerID)
-		comment := utils.FormatComment(n.Name, args.ContainerID)
-		if err = ip.TeardownIPMasq(ipn, chain, comment); err != nil {
+		chain, err := utils.FormatChainNameWithPrefix("CNI-", n.Name, args.ContainerID, args.IfName)
+		if err != nil {
 			return err
 		}
+		comment := utils.FormatCommentWithIfName(n.Name, args.ContainerID, args.IfName)
+		// attachments added before chains were per interface share one
+		// chain per container
+		legacyChain := utils.FormatChainName(n.Name, args.ContainerID)
+		legacyComment := utils.FormatComment(n.Name, args.ContainerID)
+		for _, ipn := range ipns {
+			if err = ip.TeardownIPMasqWithBackend(n.IPMasqBackend, ipn, chain, comment); err != nil {
+				return err
+			}
+			if err = ip.TeardownIPMasqWithBackend(n.IPMasqBackend, ipn, legacyChain, legacyComment); err != nil {
+				return err
+			}
+		}
 	}
 
-	return nil
+	if n.ProxyARP {
+		var ips []net.IP
+		for _, ipn := range ipns {
+			ips = append(ips, ipn.IP)
+		}
+		if err = ip.TeardownProxyNeigh(n.ProxyARPIface, ips); err != nil {
+			return err
+		}
+	}
+
+	if n.MacSpoofChk {
+		chain, err := utils.FormatChainNameWithPrefix("CNI-", n.Name, args.ContainerID, args.IfName)
+		if err != nil {
+			return err
+		}
+		if err = ip.TeardownSpoofCheck(chain); err != nil {
+			return err
+		}
+		if err = ip.TeardownSpoofCheck(utils.FormatChainName(n.Name, args.ContainerID)); err != nil {
+			return err
+		}
+	}
+
+	return cache.Remove(args.ContainerID, args.IfName)
+}
+
+// cmdGC removes the masquerade chains of the attachments of the network
+// that are not among the valid ones, such as those of a container whose
+// DEL never came
+func cmdGC(args *skel.CmdArgs) error {
+	n, err := loadNetConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	closeLog, err := setupLogging(n, "GC", args)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	orphaned, err := utils.OrphanedChains("CNI-", n.Name, n.ValidAttachments)
+	if err != nil {
+		return err
+	}
+	return ip.SweepIPMasq(n.IPMasqBackend, func(chain, comment string) bool {
+		if !orphaned(chain, comment) {
+			return false
+		}
+		logging.Debugf("removing orphaned masquerade chain %q", chain)
+		return true
+	})
 }
 
 func main() {
-	skel.PluginMain(cmdAdd, cmdDel)
+	skel.PluginMainFuncs(skel.PluginFuncs{Add: cmdAdd, Check: cmdCheck, Del: cmdDel, GC: cmdGC})
 }
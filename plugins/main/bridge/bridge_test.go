@@ -15,14 +15,24 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"syscall"
 
+	"github.com/containernetworking/cni/pkg/ip"
+	"github.com/containernetworking/cni/pkg/ipam"
 	"github.com/containernetworking/cni/pkg/ns"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/testutils"
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/cni/pkg/utils"
 
 	"github.com/vishvananda/netlink"
 
@@ -53,7 +63,7 @@ var _ = Describe("bridge Operations", func() {
 				Type: "bridge",
 			},
 			BrName: IFNAME,
-			IsGW:   false,
+			IsGW:   GatewayFamilies{},
 			IPMasq: false,
 			MTU:    5000,
 		}
@@ -97,7 +107,7 @@ var _ = Describe("bridge Operations", func() {
 					Type: "bridge",
 				},
 				BrName: IFNAME,
-				IsGW:   false,
+				IsGW:   GatewayFamilies{},
 				IPMasq: false,
 			}
 
@@ -116,6 +126,187 @@ var _ = Describe("bridge Operations", func() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 
+	It("attaches the bridge to the uplink", func() {
+		conf := &NetConf{
+			NetConf: types.NetConf{
+				Name: "testConfig",
+				Type: "bridge",
+			},
+			BrName: "bridge0",
+			Uplink: "uplink0",
+		}
+
+		err := originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := netlink.LinkAdd(&netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: "uplink0"},
+				PeerName:  "uplink1",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			for i := 0; i < 2; i++ {
+				bridge, err := setupBridge(conf)
+				Expect(err).NotTo(HaveOccurred())
+
+				link, err := netlink.LinkByName("uplink0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(link.Attrs().MasterIndex).To(Equal(bridge.Attrs().Index))
+				Expect(link.Attrs().Flags & net.FlagUp).To(Equal(net.FlagUp))
+			}
+
+			conf.BrName = "bridge1"
+			_, err = setupBridge(conf)
+			Expect(err).To(MatchError(`failed to attach uplink "uplink0" to bridge "bridge1": "uplink0" is already enslaved to another device`))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("adds a clsact qdisc to the host veth with clsact", func() {
+		conf := &NetConf{
+			NetConf: types.NetConf{
+				Name: "testConfig",
+				Type: "bridge",
+			},
+			Clsact: true,
+		}
+
+		err := originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := netlink.LinkAdd(&netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: "veth0"},
+				PeerName:  "veth1",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			// a second ADD finds the qdisc in place
+			for i := 0; i < 2; i++ {
+				Expect(setupTCHooks(conf, "veth0")).To(Succeed())
+			}
+
+			link, err := netlink.LinkByName("veth0")
+			Expect(err).NotTo(HaveOccurred())
+			qdiscs, err := netlink.QdiscList(link)
+			Expect(err).NotTo(HaveOccurred())
+
+			var clsacts int
+			for _, q := range qdiscs {
+				if q.Type() == "clsact" {
+					clsacts++
+				}
+			}
+			Expect(clsacts).To(Equal(1))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("rate limits both ends of the veth", func() {
+		conf := &NetConf{
+			NetConf: types.NetConf{
+				Name: "testConfig",
+				Type: "bridge",
+			},
+			IngressRate: 8000000,
+			EgressRate:  4000000,
+		}
+
+		targetNs, err := ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		defer targetNs.Close()
+
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := netlink.LinkAdd(&netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: "veth0"},
+				PeerName:  "veth1",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			peer, err := netlink.LinkByName("veth1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetNsFd(peer, int(targetNs.Fd()))).To(Succeed())
+
+			// a second ADD replaces the limits
+			for i := 0; i < 2; i++ {
+				Expect(setupRateLimits(conf, targetNs, "veth1", "veth0")).To(Succeed())
+			}
+			Expect(checkRateLimit("veth0", conf.IngressRate)).To(Succeed())
+			Expect(checkRateLimit("veth0", 0)).To(MatchError(`"veth0" is rate limited to 8000000 bit/s, not 0`))
+
+			Expect(ip.ClearRateLimit("veth0")).To(Succeed())
+			rate, err := ip.RateLimit("veth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rate).To(BeZero())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			Expect(checkRateLimit("veth1", conf.EgressRate)).To(Succeed())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("attaches the bridge to a VLAN of the uplink", func() {
+		const IFNAME = "bridge0"
+
+		conf := &NetConf{
+			NetConf: types.NetConf{
+				Name: "testConfig",
+				Type: "bridge",
+			},
+			BrName:     IFNAME,
+			Uplink:     "uplink0",
+			UplinkVLAN: 100,
+		}
+
+		err := originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := netlink.LinkAdd(&netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: "uplink0"},
+				PeerName:  "uplink1",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			// a second ADD finds everything in place
+			for i := 0; i < 2; i++ {
+				bridge, err := setupBridge(conf)
+				Expect(err).NotTo(HaveOccurred())
+
+				link, err := netlink.LinkByName("uplink0.100")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(link).To(BeAssignableToTypeOf(&netlink.Vlan{}))
+				Expect(link.(*netlink.Vlan).VlanId).To(Equal(100))
+				Expect(link.Attrs().MasterIndex).To(Equal(bridge.Attrs().Index))
+				Expect(link.Attrs().Flags & net.FlagUp).To(Equal(net.FlagUp))
+			}
+
+			// the uplink itself stays out of the bridge
+			link, err := netlink.LinkByName("uplink0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link.Attrs().MasterIndex).To(BeZero())
+
+			conf.UplinkVLAN = 200
+			conf.BrName = "bridge1"
+			_, err = setupBridge(conf)
+			Expect(err).NotTo(HaveOccurred())
+
+			conf.UplinkVLAN = 0
+			conf.Uplink = "uplink0.100"
+			_, err = setupBridge(conf)
+			Expect(err).To(MatchError(`failed to attach uplink "uplink0.100" to bridge "bridge1": "uplink0.100" is already enslaved to another device`))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
 	It("configures and deconfigures a bridge and veth with default route with ADD/DEL", func() {
 		const BRNAME = "cni0"
 		const IFNAME = "eth0"
@@ -236,4 +427,405 @@ var _ = Describe("bridge Operations", func() {
 		})
 		Expect(err).NotTo(HaveOccurred())
 	})
+
+	It("reports how the bridge differs from its configuration on CHECK", func() {
+		const BRNAME = "cni0"
+		const IFNAME = "eth0"
+
+		conf := map[string]interface{}{
+			"name":      "mynet",
+			"type":      "bridge",
+			"bridge":    BRNAME,
+			"isGateway": true,
+			"mtu":       1400,
+			"ipam": map[string]interface{}{
+				"type":   "host-local",
+				"subnet": "10.1.2.0/24",
+			},
+		}
+		stdin, err := json.Marshal(conf)
+		Expect(err).NotTo(HaveOccurred())
+
+		targetNs, err := ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		defer targetNs.Close()
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      IFNAME,
+			StdinData:   stdin,
+		}
+
+		var result *types.Result
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			result, err = testutils.CmdAddWithResult(targetNs.Path(), IFNAME, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		check := func() error {
+			conf["prevResult"] = result
+			stdin, err := json.Marshal(conf)
+			Expect(err).NotTo(HaveOccurred())
+
+			checkArgs := *args
+			checkArgs.StdinData = stdin
+			return originalNS.Do(func(ns.NetNS) error {
+				return testutils.CmdCheckWithResult(targetNs.Path(), IFNAME, func() error {
+					return cmdCheck(&checkArgs)
+				})
+			})
+		}
+
+		Expect(check()).To(Succeed())
+
+		// change the bridge and its port behind the back of the plugin
+		var hostVethName string
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			br, err := netlink.LinkByName(BRNAME)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetMTU(br, 1300)).To(Succeed())
+			gwn := &net.IPNet{IP: result.IP4.Gateway, Mask: result.IP4.IP.Mask}
+			Expect(netlink.AddrDel(br, &netlink.Addr{IPNet: gwn})).To(Succeed())
+
+			links, err := netlink.LinkList()
+			Expect(err).NotTo(HaveOccurred())
+			for _, l := range links {
+				if l.Attrs().MasterIndex == br.Attrs().Index {
+					hostVethName = l.Attrs().Name
+					Expect(netlink.LinkSetHairpin(l, true)).To(Succeed())
+				}
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hostVethName).NotTo(BeEmpty())
+
+		Expect(check()).To(MatchError(fmt.Sprintf(`bridge "cni0" differs from its configuration: its MTU is 1300, not 1400; gateway address 10.1.2.1/24 is missing; hairpin mode of port %q is on, not off`, hostVethName)))
+	})
+
+	It("gives the container interface the MAC of the runtimeConfig", func() {
+		const BRNAME = "cni0"
+		const IFNAME = "eth0"
+		const MAC = "c2:11:22:33:44:55"
+
+		conf := map[string]interface{}{
+			"cniVersion":   "0.3.0",
+			"name":         "mynet",
+			"type":         "bridge",
+			"bridge":       BRNAME,
+			"capabilities": map[string]bool{"mac": true},
+			"runtimeConfig": map[string]interface{}{
+				"mac": MAC,
+			},
+			"ipam": map[string]interface{}{
+				"type":   "host-local",
+				"subnet": "10.1.2.0/24",
+			},
+		}
+		stdin, err := json.Marshal(conf)
+		Expect(err).NotTo(HaveOccurred())
+
+		targetNs, err := ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		defer targetNs.Close()
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy-mac",
+			Netns:       targetNs.Path(),
+			IfName:      IFNAME,
+			StdinData:   stdin,
+		}
+
+		var result *current.Result
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			result, err = testutils.CmdAddWithCurrentResult(targetNs.Path(), IFNAME, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Interfaces).To(Equal([]*current.Interface{{Name: IFNAME, Mac: MAC, Sandbox: targetNs.Path()}}))
+		Expect(*result.IPs[0].Interface).To(Equal(0))
+
+		err = targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			link, err := netlink.LinkByName(IFNAME)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link.Attrs().HardwareAddr.String()).To(Equal(MAC))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		check := func() error {
+			conf["prevResult"] = result
+			stdin, err := json.Marshal(conf)
+			Expect(err).NotTo(HaveOccurred())
+
+			checkArgs := *args
+			checkArgs.StdinData = stdin
+			return originalNS.Do(func(ns.NetNS) error {
+				return testutils.CmdCheckWithResult(targetNs.Path(), IFNAME, func() error {
+					return cmdCheck(&checkArgs)
+				})
+			})
+		}
+		Expect(check()).To(Succeed())
+
+		err = targetNs.Do(func(ns.NetNS) error {
+			link, err := netlink.LinkByName(IFNAME)
+			if err != nil {
+				return err
+			}
+			other, _ := net.ParseMAC("c2:11:22:33:44:66")
+			return netlink.LinkSetHardwareAddr(link, other)
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(check()).To(MatchError(`"eth0" has MAC c2:11:22:33:44:66, not c2:11:22:33:44:55`))
+	})
+
+	It("sets the route MTU and advmss on the routes of the container", func() {
+		n, err := loadNetConf([]byte(`{"name": "mynet", "type": "bridge", "routeMtu": 1400, "routeAdvmss": 1360, "routeMtuLock": true}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		ipc := &types.IPConfig{
+			IP:      net.IPNet{IP: net.ParseIP("10.1.2.3"), Mask: net.CIDRMask(24, 32)},
+			Gateway: net.ParseIP("10.1.2.1"),
+		}
+		Expect(addDefaultRoute(ipc)).To(Succeed())
+		ipc.Routes = append(ipc.Routes, types.Route{
+			Dst: net.IPNet{IP: net.ParseIP("192.168.0.0"), Mask: net.CIDRMask(16, 32)},
+			GW:  net.ParseIP("10.1.2.254"),
+			MTU: 9000,
+		})
+		setRouteMetrics(n, ipc)
+		Expect(ipc.Routes[0].MTU).To(Equal(1400))
+		Expect(ipc.Routes[0].LockMTU).To(BeTrue())
+		Expect(ipc.Routes[0].AdvMSS).To(Equal(1360))
+		Expect(ipc.Routes[1].MTU).To(Equal(9000))
+		Expect(ipc.Routes[1].LockMTU).To(BeFalse())
+		Expect(ipc.Routes[1].AdvMSS).To(Equal(1360))
+
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			Expect(netlink.LinkAdd(&netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: "eth0"},
+				PeerName:  "veth0",
+			})).To(Succeed())
+			for _, name := range []string{"eth0", "veth0"} {
+				link, err := netlink.LinkByName(name)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(netlink.LinkSetUp(link)).To(Succeed())
+			}
+
+			result := &types.Result{IP4: ipc}
+			Expect(ipam.ConfigureIface("eth0", current.NewResultFromLegacy(result))).To(Succeed())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		out, err := ns.CommandOutput(originalNS, exec.Command("ip", "-4", "route", "show", "default"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(out)).To(ContainSubstring("mtu lock 1400 advmss 1360"))
+	})
+
+	It("rejects a route MTU lock without a route MTU", func() {
+		_, err := loadNetConf([]byte(`{"name": "mynet", "type": "bridge", "routeMtuLock": true}`))
+		Expect(err).To(MatchError("routeMtuLock requires routeMtu"))
+	})
+
+	It("rejects an invalid MAC in the runtimeConfig", func() {
+		_, err := loadNetConf([]byte(`{"name": "mynet", "type": "bridge", "runtimeConfig": {"mac": "nope"}}`))
+		Expect(err).To(MatchError(`invalid mac "nope" in runtimeConfig: address nope: invalid MAC address`))
+	})
+
+	Context("with prerequisites missing", func() {
+		var oldLookPath func(string) (string, error)
+		var oldVlanProcDir, oldBrNetfilterDir string
+		var oldSettable func(string, string) error
+
+		BeforeEach(func() {
+			oldLookPath, oldVlanProcDir, oldBrNetfilterDir, oldSettable = lookPath, vlanProcDir, brNetfilterDir, settable
+			lookPath = func(file string) (string, error) {
+				return "", &exec.Error{Name: file, Err: exec.ErrNotFound}
+			}
+			vlanProcDir = "/nonexistent/vlan"
+			brNetfilterDir = "/nonexistent/bridge"
+			settable = func(name, value string) error {
+				return fmt.Errorf("/proc/sys/%s is 0 and cannot be written: read-only file system", strings.Replace(name, ".", "/", -1))
+			}
+		})
+
+		AfterEach(func() {
+			lookPath, vlanProcDir, brNetfilterDir, settable = oldLookPath, oldVlanProcDir, oldBrNetfilterDir, oldSettable
+		})
+
+		It("names every option that cannot work and how to resolve it", func() {
+			n, err := loadNetConf([]byte(`{
+				"name": "mynet",
+				"type": "bridge",
+				"uplink": "nonexistent0",
+				"uplinkVlan": 100,
+				"isGateway": true,
+				"hairpinMode": true,
+				"ipMasq": true,
+				"macspoofchk": true,
+				"bpfIngress": "/nonexistent/prog"
+			}`))
+			Expect(err).NotTo(HaveOccurred())
+
+			err = originalNS.Do(func(ns.NetNS) error {
+				return checkPrerequisites(n)
+			})
+			Expect(err).To(MatchError("the host is missing prerequisites of the configuration: " +
+				`uplink "nonexistent0" does not exist: create it or change uplink; ` +
+				"uplinkVlan needs the 8021q module, which is not loaded: load 8021q or remove uplinkVlan; " +
+				"isGateway needs IPv4 forwarding (/proc/sys/net/ipv4/ip_forward is 0 and cannot be written: read-only file system): set net.ipv4.ip_forward to 1 or disable isGateway; " +
+				"isGateway needs IPv6 forwarding (/proc/sys/net/ipv6/conf/all/forwarding is 0 and cannot be written: read-only file system): set net.ipv6.conf.all.forwarding to 1 or disable isGateway for ipv6; " +
+				"hairpinMode needs the br_netfilter module, which is not loaded: load br_netfilter or disable hairpinMode; " +
+				`bpfIngress needs a program pinned at "/nonexistent/prog", which does not exist: pin it or remove bpfIngress; ` +
+				"bpfIngress needs tc, which is not installed: install iproute2 or remove bpfIngress; " +
+				"macspoofchk needs nft, which is not installed: install nftables or disable macspoofchk; " +
+				"ipMasq needs iptables or nft, which is not installed: install it or disable ipMasq"))
+		})
+
+		It("fails ADD before creating the bridge", func() {
+			stdin := `{"name": "mynet", "type": "bridge", "bridge": "cni0", "proxyArp": true, "proxyArpInterface": "nonexistent0", "ipam": {"type": "host-local", "subnet": "10.1.2.0/24"}}`
+			err := originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				err := cmdAdd(&skel.CmdArgs{ContainerID: "dummy", Netns: originalNS.Path(), IfName: "eth0", StdinData: []byte(stdin)})
+				Expect(err).To(MatchError(`the host is missing prerequisites of the configuration: proxyArp needs the interface "nonexistent0", which does not exist: create it or change proxyArpInterface`))
+
+				_, err = netlink.LinkByName("cni0")
+				Expect(err).To(HaveOccurred())
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("checks IPv6 forwarding only for an IPv6 gateway", func() {
+			n, err := loadNetConf([]byte(`{"name": "mynet", "type": "bridge", "isGateway": {"ipv6": true}}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(checkPrerequisites(n)).To(MatchError("the host is missing prerequisites of the configuration: " +
+				"isGateway needs IPv6 forwarding (/proc/sys/net/ipv6/conf/all/forwarding is 0 and cannot be written: read-only file system): " +
+				"set net.ipv6.conf.all.forwarding to 1 or disable isGateway for ipv6"))
+		})
+
+		It("skips IPv6 forwarding on a kernel without IPv6", func() {
+			settable = func(name, value string) error {
+				_, err := os.Stat("/nonexistent/" + name)
+				return err
+			}
+			n, err := loadNetConf([]byte(`{"name": "mynet", "type": "bridge", "isGateway": {"ipv6": true}}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(checkPrerequisites(n)).To(Succeed())
+		})
+
+		It("needs br_netfilter for hairpinMode", func() {
+			n, err := loadNetConf([]byte(`{"name": "mynet", "type": "bridge", "hairpinMode": true}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(checkPrerequisites(n)).To(MatchError("the host is missing prerequisites of the configuration: " +
+				"hairpinMode needs the br_netfilter module, which is not loaded: load br_netfilter or disable hairpinMode"))
+		})
+
+		It("accepts configurations that do not need them", func() {
+			n, err := loadNetConf([]byte(`{"name": "mynet", "type": "bridge", "mtu": 1400}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(checkPrerequisites(n)).To(Succeed())
+		})
+	})
+
+	Context("on GC", func() {
+		// fakeNFT lists the table of a family from its <family>.json,
+		// or no table without one, and records the transactions it is given
+		const fakeNFT = `#!/bin/sh
+if [ "$2" = "list" ]; then
+	if [ -f "%[1]s/$4.json" ]; then
+		cat "%[1]s/$4.json"
+		exit 0
+	fi
+	echo "Error: No such file or directory" >&2
+	exit 1
+fi
+cat >> "%[1]s/transactions"
+echo >> "%[1]s/transactions"
+`
+		var dir, oldPath string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "bridge-gc")
+			Expect(err).NotTo(HaveOccurred())
+			err = ioutil.WriteFile(filepath.Join(dir, "nft"), []byte(fmt.Sprintf(fakeNFT, dir)), 0755)
+			Expect(err).NotTo(HaveOccurred())
+
+			oldPath = os.Getenv("PATH")
+			os.Setenv("PATH", dir+string(os.PathListSeparator)+oldPath)
+		})
+
+		AfterEach(func() {
+			os.Setenv("PATH", oldPath)
+			os.RemoveAll(dir)
+		})
+
+		It("removes the masquerade chains of the attachments that are no longer valid", func() {
+			chain := func(network, containerID string) string {
+				chain, err := utils.FormatChainNameWithPrefix("CNI-", network, containerID, "eth0")
+				Expect(err).NotTo(HaveOccurred())
+				return chain
+			}
+			comment := func(network, containerID string) string {
+				data, err := json.Marshal(utils.FormatCommentWithIfName(network, containerID, "eth0"))
+				Expect(err).NotTo(HaveOccurred())
+				return string(data)
+			}
+			valid, stale, other := chain("mynet", "valid"), chain("mynet", "gone"), chain("othernet", "gone")
+
+			table := fmt.Sprintf(`{"nftables": [
+				{"table": {"family": "ip", "name": "cni", "handle": 1}},
+				{"chain": {"family": "ip", "table": "cni", "name": "POSTROUTING", "handle": 1, "type": "nat", "hook": "postrouting", "prio": 100, "policy": "accept"}},
+				{"chain": {"family": "ip", "table": "cni", "name": %[1]q, "handle": 2}},
+				{"chain": {"family": "ip", "table": "cni", "name": %[3]q, "handle": 3}},
+				{"chain": {"family": "ip", "table": "cni", "name": %[5]q, "handle": 4}},
+				{"rule": {"family": "ip", "table": "cni", "chain": "POSTROUTING", "handle": 5, "comment": %[2]s, "expr": [{"jump": {"target": %[1]q}}]}},
+				{"rule": {"family": "ip", "table": "cni", "chain": %[1]q, "handle": 6, "comment": %[2]s, "expr": [{"masquerade": null}]}},
+				{"rule": {"family": "ip", "table": "cni", "chain": "POSTROUTING", "handle": 7, "comment": %[4]s, "expr": [{"jump": {"target": %[3]q}}]}},
+				{"rule": {"family": "ip", "table": "cni", "chain": %[3]q, "handle": 8, "comment": %[4]s, "expr": [{"masquerade": null}]}},
+				{"rule": {"family": "ip", "table": "cni", "chain": %[5]q, "handle": 9, "comment": %[6]s, "expr": [{"masquerade": null}]}}
+			]}`, valid, comment("mynet", "valid"), stale, comment("mynet", "gone"), other, comment("othernet", "gone"))
+			err := ioutil.WriteFile(filepath.Join(dir, "ip.json"), []byte(table), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			stdin := `{
+				"name": "mynet",
+				"type": "bridge",
+				"ipMasq": true,
+				"ipMasqBackend": "nftables",
+				"cni.dev/valid-attachments": [{"containerID": "valid", "ifname": "eth0"}]
+			}`
+			Expect(cmdGC(&skel.CmdArgs{StdinData: []byte(stdin)})).To(Succeed())
+
+			data, err := ioutil.ReadFile(filepath.Join(dir, "transactions"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.TrimSuffix(string(data), "\n")).To(MatchJSON(fmt.Sprintf(`{"nftables": [
+				{"delete": {"rule": {"family": "ip", "table": "cni", "chain": "POSTROUTING", "handle": 7}}},
+				{"flush": {"chain": {"family": "ip", "table": "cni", "name": %[1]q}}},
+				{"delete": {"chain": {"family": "ip", "table": "cni", "name": %[1]q}}}
+			]}`, stale)))
+		})
+	})
 })
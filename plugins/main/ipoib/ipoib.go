@@ -0,0 +1,262 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+
+	"github.com/containernetworking/cni/pkg/ip"
+	"github.com/containernetworking/cni/pkg/ipam"
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/vishvananda/netlink"
+)
+
+// fullMembership is the bit of a pkey the kernel sets on every child
+// interface
+const fullMembership = 0x8000
+
+type NetConf struct {
+	types.NetConf
+	// PrevResult is the result of the ADD being checked, in any version
+	PrevResult *current.Result `json:"-"`
+	Master     string          `json:"master"`
+	PKey       PKey            `json:"pkey"`
+	Mode       string          `json:"mode"`
+	MTU        int             `json:"mtu"`
+}
+
+// PKey is an InfiniBand partition key. In the configuration it is either
+// a number or a string such as "0x8001".
+type PKey uint16
+
+func (p *PKey) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		s = string(data)
+	}
+	v, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return fmt.Errorf("invalid pkey %s: must be a 16-bit number such as \"0x8001\"", data)
+	}
+	*p = PKey(v)
+	return nil
+}
+
+func (p PKey) String() string {
+	return fmt.Sprintf("0x%04x", uint16(p))
+}
+
+func init() {
+	// this ensures that main runs only on main thread (thread group leader).
+	// since namespace ops (unshare, setns) are done for a single thread, we
+	// must ensure that the goroutine does not jump from OS thread to thread
+	runtime.LockOSThread()
+}
+
+func loadConf(bytes []byte) (*NetConf, error) {
+	n := &NetConf{}
+	if err := json.Unmarshal(bytes, n); err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %v", err)
+	}
+	prev, err := current.ParsePrevResult(bytes)
+	if err != nil {
+		return nil, err
+	}
+	n.PrevResult = prev
+	if n.Master == "" {
+		return nil, fmt.Errorf(`"master" field is required. It specifies the InfiniBand interface to create the child interface on`)
+	}
+	// the kernel treats the limited and full membership versions of a
+	// partition alike, and 0 is no partition at all
+	if n.PKey&^fullMembership == 0 {
+		return nil, fmt.Errorf(`"pkey" field is required and must not be %v`, n.PKey)
+	}
+	return n, nil
+}
+
+func modeFromString(s string) (ip.IPoIBMode, error) {
+	switch s {
+	case "", "datagram":
+		return ip.IPoIBModeDatagram, nil
+	case "connected":
+		return ip.IPoIBModeConnected, nil
+	default:
+		return 0, fmt.Errorf("unknown ipoib mode: %q", s)
+	}
+}
+
+func createIPoIB(conf *NetConf, ifName string, netns ns.NetNS) error {
+	mode, err := modeFromString(conf.Mode)
+	if err != nil {
+		return err
+	}
+
+	m, err := netlink.LinkByName(conf.Master)
+	if err != nil {
+		return fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+	}
+
+	// create with a temporary name, as ifName may be taken on the host
+	tmpName, err := ip.RandomVethName()
+	if err != nil {
+		return err
+	}
+
+	child := &ip.IPoIB{
+		LinkAttrs: netlink.LinkAttrs{
+			MTU:         conf.MTU,
+			Name:        tmpName,
+			ParentIndex: m.Attrs().Index,
+			Namespace:   netlink.NsFd(int(netns.Fd())),
+		},
+		Pkey: uint16(conf.PKey),
+		Mode: mode,
+	}
+	if err := ip.LinkAdd(child); err != nil {
+		return fmt.Errorf("failed to create ipoib child of %q with pkey %v: %v", conf.Master, conf.PKey, err)
+	}
+
+	return netns.Do(func(_ ns.NetNS) error {
+		err := renameLink(tmpName, ifName)
+		if err != nil {
+			return fmt.Errorf("failed to rename ipoib child to %q: %v", ifName, err)
+		}
+		return nil
+	})
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+	}
+	defer netns.Close()
+
+	if err = createIPoIB(n, args.IfName, netns); err != nil {
+		return err
+	}
+
+	// run the IPAM plugin and get back the config to apply
+	result, err := ipam.ExecAdd(n.IPAM.Type, args.StdinData)
+	if err != nil {
+		return err
+	}
+	if result.IP4 == nil {
+		return errors.New("IPAM plugin returned missing IPv4 config")
+	}
+
+	res := current.NewResultFromLegacy(result)
+	err = netns.Do(func(_ ns.NetNS) error {
+		return ipam.ConfigureIface(args.IfName, res)
+	})
+	if err != nil {
+		return err
+	}
+
+	res.DNS = types.MergeDNS(n.DNS, res.DNS)
+	return current.PrintResult(res, n.CNIVersion)
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if n.PrevResult == nil {
+		return errors.New("required prevResult missing")
+	}
+
+	mode, err := modeFromString(n.Mode)
+	if err != nil {
+		return err
+	}
+	modeName := n.Mode
+	if modeName == "" {
+		modeName = "datagram"
+	}
+
+	m, err := netlink.LinkByName(n.Master)
+	if err != nil {
+		return fmt.Errorf("failed to lookup master %q: %v", n.Master, err)
+	}
+
+	return ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		link, err := ip.LinkByName(args.IfName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", args.IfName, err)
+		}
+
+		l, ok := link.(*ip.IPoIB)
+		if !ok {
+			return fmt.Errorf("%q is a %s link, not ipoib", args.IfName, link.Type())
+		}
+		if l.Pkey|fullMembership != uint16(n.PKey)|fullMembership {
+			return fmt.Errorf("ipoib %q has pkey %v, not %v", args.IfName, PKey(l.Pkey), n.PKey)
+		}
+		if l.Mode != mode {
+			return fmt.Errorf("ipoib %q is not in %q mode", args.IfName, modeName)
+		}
+		if l.ParentIndex != m.Attrs().Index {
+			return fmt.Errorf("ipoib %q is not a child of %q", args.IfName, n.Master)
+		}
+
+		return ipam.CheckIface(args.IfName, n.PrevResult)
+	})
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	err = ipam.ExecDel(n.IPAM.Type, args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	if args.Netns == "" {
+		return nil
+	}
+
+	return ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		return ip.DelLinkByName(args.IfName)
+	})
+}
+
+func renameLink(curName, newName string) error {
+	link, err := netlink.LinkByName(curName)
+	if err != nil {
+		return err
+	}
+
+	return netlink.LinkSetName(link, newName)
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.PluginFuncs{Add: cmdAdd, Check: cmdCheck, Del: cmdDel})
+}
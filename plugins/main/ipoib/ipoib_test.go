@@ -0,0 +1,95 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/containernetworking/cni/pkg/ip"
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ipoib Operations", func() {
+	It("accepts a pkey as a number or a hexadecimal string", func() {
+		n, err := loadConf([]byte(`{"name": "ib", "type": "ipoib", "master": "ib0", "pkey": "0x8001"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n.PKey).To(Equal(PKey(0x8001)))
+
+		n, err = loadConf([]byte(`{"name": "ib", "type": "ipoib", "master": "ib0", "pkey": 32770}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n.PKey).To(Equal(PKey(0x8002)))
+	})
+
+	It("rejects a missing or invalid pkey", func() {
+		_, err := loadConf([]byte(`{"name": "ib", "type": "ipoib", "master": "ib0"}`))
+		Expect(err).To(MatchError(`"pkey" field is required and must not be 0x0000`))
+
+		_, err = loadConf([]byte(`{"name": "ib", "type": "ipoib", "master": "ib0", "pkey": "0x8000"}`))
+		Expect(err).To(MatchError(`"pkey" field is required and must not be 0x8000`))
+
+		_, err = loadConf([]byte(`{"name": "ib", "type": "ipoib", "master": "ib0", "pkey": "0x18001"}`))
+		Expect(err).To(MatchError(ContainSubstring(`invalid pkey "0x18001"`)))
+	})
+
+	It("parses the modes", func() {
+		mode, err := modeFromString("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mode).To(Equal(ip.IPoIBModeDatagram))
+
+		mode, err = modeFromString("connected")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mode).To(Equal(ip.IPoIBModeConnected))
+
+		_, err = modeFromString("reliable")
+		Expect(err).To(MatchError(`unknown ipoib mode: "reliable"`))
+	})
+
+	It("fails to create a child of an interface that is not InfiniBand", func() {
+		conf := &NetConf{
+			NetConf: types.NetConf{
+				Name: "testConfig",
+				Type: "ipoib",
+			},
+			Master: "eth0",
+			PKey:   0x8001,
+		}
+
+		originalNS, err := ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		defer originalNS.Close()
+		targetNs, err := ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		defer targetNs.Close()
+
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := netlink.LinkAdd(&netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: "eth0"},
+				PeerName:  "eth1",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = createIPoIB(conf, "ib0", targetNs)
+			Expect(err).To(MatchError(HavePrefix(`failed to create ipoib child of "eth0" with pkey 0x8001: `)))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
@@ -25,14 +25,20 @@ import (
 	"github.com/containernetworking/cni/pkg/ns"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
 	"github.com/vishvananda/netlink"
 )
 
 type NetConf struct {
 	types.NetConf
-	Master string `json:"master"`
-	Mode   string `json:"mode"`
-	MTU    int    `json:"mtu"`
+	// PrevResult is the result of the ADD being checked, in any version
+	PrevResult *current.Result `json:"-"`
+	Master     string          `json:"master"`
+	Mode       string          `json:"mode"`
+	MTU        int             `json:"mtu"`
+	// LinkContainer looks up Master in the container namespace, where an
+	// earlier plugin in the chain created it
+	LinkContainer bool `json:"linkInContainer,omitempty"`
 }
 
 func init() {
@@ -47,6 +53,11 @@ func loadConf(bytes []byte) (*NetConf, error) {
 	if err := json.Unmarshal(bytes, n); err != nil {
 		return nil, fmt.Errorf("failed to load netconf: %v", err)
 	}
+	prev, err := current.ParsePrevResult(bytes)
+	if err != nil {
+		return nil, err
+	}
+	n.PrevResult = prev
 	if n.Master == "" {
 		return nil, fmt.Errorf(`"master" field is required. It specifies the host interface name to virtualize`)
 	}
@@ -70,9 +81,9 @@ func createIpvlan(conf *NetConf, ifName string, netns ns.NetNS) error {
 		return err
 	}
 
-	m, err := netlink.LinkByName(conf.Master)
+	m, err := lookupMaster(conf, netns)
 	if err != nil {
-		return fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+		return err
 	}
 
 	// due to kernel bug we have to create with tmpname or it might
@@ -87,12 +98,14 @@ func createIpvlan(conf *NetConf, ifName string, netns ns.NetNS) error {
 			MTU:         conf.MTU,
 			Name:        tmpName,
 			ParentIndex: m.Attrs().Index,
-			Namespace:   netlink.NsFd(int(netns.Fd())),
 		},
 		Mode: mode,
 	}
+	if !conf.LinkContainer {
+		mv.Namespace = netlink.NsFd(int(netns.Fd()))
+	}
 
-	if err := netlink.LinkAdd(mv); err != nil {
+	if err := addLink(conf, mv, netns); err != nil {
 		return fmt.Errorf("failed to create ipvlan: %v", err)
 	}
 
@@ -105,6 +118,35 @@ func createIpvlan(conf *NetConf, ifName string, netns ns.NetNS) error {
 	})
 }
 
+// lookupMaster returns the master of conf, from netns if it is in the
+// container
+func lookupMaster(conf *NetConf, netns ns.NetNS) (netlink.Link, error) {
+	var m netlink.Link
+	lookup := func(_ ns.NetNS) error {
+		var err error
+		if m, err = netlink.LinkByName(conf.Master); err != nil {
+			return fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+		}
+		return nil
+	}
+
+	if conf.LinkContainer {
+		return m, netns.Do(lookup)
+	}
+	return m, lookup(nil)
+}
+
+// addLink adds link in the namespace the master of conf is in; when that
+// is the host, link is moved to the container through its Namespace
+func addLink(conf *NetConf, link netlink.Link, netns ns.NetNS) error {
+	if conf.LinkContainer {
+		return netns.Do(func(_ ns.NetNS) error {
+			return netlink.LinkAdd(link)
+		})
+	}
+	return netlink.LinkAdd(link)
+}
+
 func cmdAdd(args *skel.CmdArgs) error {
 	n, err := loadConf(args.StdinData)
 	if err != nil {
@@ -130,15 +172,66 @@ func cmdAdd(args *skel.CmdArgs) error {
 		return errors.New("IPAM plugin returned missing IPv4 config")
 	}
 
+	res := current.NewResultFromLegacy(result)
 	err = netns.Do(func(_ ns.NetNS) error {
-		return ipam.ConfigureIface(args.IfName, result)
+		return ipam.ConfigureIface(args.IfName, res)
 	})
 	if err != nil {
 		return err
 	}
 
-	result.DNS = n.DNS
-	return result.Print()
+	res.DNS = types.MergeDNS(n.DNS, res.DNS)
+	return current.PrintResult(res, n.CNIVersion)
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if n.PrevResult == nil {
+		return errors.New("required prevResult missing")
+	}
+
+	mode, err := modeFromString(n.Mode)
+	if err != nil {
+		return err
+	}
+	modeName := n.Mode
+	if modeName == "" {
+		modeName = "l2"
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+	}
+	defer netns.Close()
+
+	m, err := lookupMaster(n, netns)
+	if err != nil {
+		return err
+	}
+
+	return netns.Do(func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(args.IfName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", args.IfName, err)
+		}
+
+		l, ok := link.(*netlink.IPVlan)
+		if !ok {
+			return fmt.Errorf("%q is a %s link, not ipvlan", args.IfName, link.Type())
+		}
+		if l.Mode != mode {
+			return fmt.Errorf("ipvlan %q is not in %q mode", args.IfName, modeName)
+		}
+		if l.ParentIndex != m.Attrs().Index {
+			return fmt.Errorf("ipvlan %q is not on master %q", args.IfName, n.Master)
+		}
+
+		return ipam.CheckIface(args.IfName, n.PrevResult)
+	})
 }
 
 func cmdDel(args *skel.CmdArgs) error {
@@ -171,5 +264,5 @@ func renameLink(curName, newName string) error {
 }
 
 func main() {
-	skel.PluginMain(cmdAdd, cmdDel)
+	skel.PluginMainFuncs(skel.PluginFuncs{Add: cmdAdd, Check: cmdCheck, Del: cmdDel})
 }
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/containernetworking/cni/pkg/ns"
@@ -97,6 +98,56 @@ var _ = Describe("ipvlan Operations", func() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 
+	It("creates an ipvlan link on a master in the container with linkInContainer", func() {
+		conf := &NetConf{
+			NetConf: types.NetConf{
+				Name: "testConfig",
+				Type: "ipvlan",
+			},
+			Master:        "master0",
+			LinkContainer: true,
+		}
+
+		targetNs, err := ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		defer targetNs.Close()
+
+		err = targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			// as left by an earlier plugin in the chain
+			err := netlink.LinkAdd(&netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: "master0"},
+				PeerName:  "master1",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := createIpvlan(conf, "foobar0", targetNs)
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			master, err := netlink.LinkByName("master0")
+			Expect(err).NotTo(HaveOccurred())
+			link, err := netlink.LinkByName("foobar0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link).To(BeAssignableToTypeOf(&netlink.IPVlan{}))
+			Expect(link.Attrs().ParentIndex).To(Equal(master.Attrs().Index))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
 	It("configures and deconfigures an iplvan link with ADD/DEL", func() {
 		const IFNAME = "ipvl0"
 
@@ -165,4 +216,81 @@ var _ = Describe("ipvlan Operations", func() {
 		})
 		Expect(err).NotTo(HaveOccurred())
 	})
+
+	It("verifies a ipvlan link with CHECK", func() {
+		const IFNAME = "ipvl0"
+
+		conf := fmt.Sprintf(`{
+    "name": "mynet",
+    "type": "ipvlan",
+    "master": "%s",
+    "ipam": {
+        "type": "host-local",
+        "subnet": "10.1.2.0/24"
+    }
+}`, MASTER_NAME)
+
+		targetNs, err := ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		defer targetNs.Close()
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      IFNAME,
+			StdinData:   []byte(conf),
+		}
+
+		var result *types.Result
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			result, err = testutils.CmdAddWithResult(targetNs.Path(), IFNAME, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		check := func(mode string) error {
+			checkConf := map[string]interface{}{
+				"name":       "mynet",
+				"type":       "ipvlan",
+				"master":     MASTER_NAME,
+				"mode":       mode,
+				"prevResult": result,
+			}
+			stdin, err := json.Marshal(checkConf)
+			Expect(err).NotTo(HaveOccurred())
+
+			checkArgs := *args
+			checkArgs.StdinData = stdin
+			err = originalNS.Do(func(ns.NetNS) error {
+				return testutils.CmdCheckWithResult(targetNs.Path(), IFNAME, func() error {
+					return cmdCheck(&checkArgs)
+				})
+			})
+			return err
+		}
+
+		Expect(check("")).To(Succeed())
+
+		err = check("l3")
+		Expect(err).To(MatchError(`ipvlan "ipvl0" is not in "l3" mode`))
+
+		// CHECK fails once the address has gone
+		err = targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			link, err := netlink.LinkByName(IFNAME)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.AddrDel(link, &netlink.Addr{IPNet: &result.IP4.IP})).To(Succeed())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = check("")
+		Expect(err).To(MatchError(fmt.Sprintf(`"ipvl0" is missing IP addr %s`, result.IP4.IP.String())))
+	})
 })
@@ -15,9 +15,12 @@
 package main
 
 import (
+	"encoding/json"
+
 	"github.com/containernetworking/cni/pkg/ns"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
 	"github.com/vishvananda/netlink"
 )
 
@@ -40,8 +43,11 @@ func cmdAdd(args *skel.CmdArgs) error {
 		return err // not tested
 	}
 
-	result := types.Result{}
-	return result.Print()
+	// the configuration is otherwise ignored, and need not even be
+	// JSON: only its cniVersion picks the format of the result
+	conf := types.NetConf{}
+	json.Unmarshal(args.StdinData, &conf)
+	return current.PrintResult(&current.Result{}, conf.CNIVersion)
 }
 
 func cmdDel(args *skel.CmdArgs) error {
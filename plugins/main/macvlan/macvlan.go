@@ -18,6 +18,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"os"
 	"runtime"
 
 	"github.com/containernetworking/cni/pkg/ip"
@@ -25,19 +27,75 @@ import (
 	"github.com/containernetworking/cni/pkg/ns"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
 	"github.com/containernetworking/cni/pkg/utils/sysctl"
 	"github.com/vishvananda/netlink"
 )
 
 const (
-	IPv4InterfaceArpProxySysctlTemplate = "net.ipv4.conf.%s.proxy_arp"
+	IPv4InterfaceArpProxySysctlTemplate = "net/ipv4/conf/%s/proxy_arp"
 )
 
+// errMasterUnhealthy is the code of the error CHECK returns when the
+// master of the container interface is down, renamed or has a smaller
+// MTU, e.g. after maintenance of the host NIC; the attachment has to be
+// set up again
+const errMasterUnhealthy uint = 110
+
 type NetConf struct {
 	types.NetConf
-	Master string `json:"master"`
-	Mode   string `json:"mode"`
-	MTU    int    `json:"mtu"`
+	// PrevResult is the result of the ADD being checked, in any version
+	PrevResult *current.Result `json:"-"`
+	Master     string          `json:"master"`
+	Mode       string          `json:"mode"`
+	MTU        int             `json:"mtu"`
+	// LinkContainer looks up Master in the container namespace, where an
+	// earlier plugin in the chain created it
+	LinkContainer bool `json:"linkInContainer,omitempty"`
+	// Tap creates a macvtap device instead, for VM-based sandboxes
+	Tap bool `json:"tap,omitempty"`
+}
+
+// TapDevice tells the runtime where to find the macvtap device backing
+// the container interface
+type TapDevice struct {
+	Name  string `json:"name"`
+	Index int    `json:"index"`
+	Mac   string `json:"mac"`
+	// Path is the character device of the tap queue, named after the
+	// interface index
+	Path string `json:"path"`
+}
+
+// tapResult is the result of ADD in tap mode: Result in the format of
+// Version, with the tap device added
+type tapResult struct {
+	Result  *current.Result
+	Version string
+	Tap     *TapDevice
+}
+
+func (r *tapResult) Print() error {
+	versioned, err := r.Result.GetAsVersion(r.Version)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(versioned)
+	if err != nil {
+		return err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	fields["tap"] = r.Tap
+
+	data, err = json.MarshalIndent(fields, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
 }
 
 func init() {
@@ -52,6 +110,11 @@ func loadConf(bytes []byte) (*NetConf, error) {
 	if err := json.Unmarshal(bytes, n); err != nil {
 		return nil, fmt.Errorf("failed to load netconf: %v", err)
 	}
+	prev, err := current.ParsePrevResult(bytes)
+	if err != nil {
+		return nil, err
+	}
+	n.PrevResult = prev
 	if n.Master == "" {
 		return nil, fmt.Errorf(`"master" field is required. It specifies the host interface name to virtualize`)
 	}
@@ -79,9 +142,9 @@ func createMacvlan(conf *NetConf, ifName string, netns ns.NetNS) error {
 		return err
 	}
 
-	m, err := netlink.LinkByName(conf.Master)
+	m, err := lookupMaster(conf, netns)
 	if err != nil {
-		return fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+		return err
 	}
 
 	// due to kernel bug we have to create with tmpName or it might
@@ -91,18 +154,23 @@ func createMacvlan(conf *NetConf, ifName string, netns ns.NetNS) error {
 		return err
 	}
 
-	mv := &netlink.Macvlan{
+	var mv netlink.Link = &netlink.Macvlan{
 		LinkAttrs: netlink.LinkAttrs{
 			MTU:         conf.MTU,
 			Name:        tmpName,
 			ParentIndex: m.Attrs().Index,
-			Namespace:   netlink.NsFd(int(netns.Fd())),
 		},
 		Mode: mode,
 	}
+	if !conf.LinkContainer {
+		mv.Attrs().Namespace = netlink.NsFd(int(netns.Fd()))
+	}
+	if conf.Tap {
+		mv = &netlink.Macvtap{Macvlan: *mv.(*netlink.Macvlan)}
+	}
 
-	if err := netlink.LinkAdd(mv); err != nil {
-		return fmt.Errorf("failed to create macvlan: %v", err)
+	if err := addLink(conf, mv, netns); err != nil {
+		return fmt.Errorf("failed to create %s: %v", mv.Type(), err)
 	}
 
 	return netns.Do(func(_ ns.NetNS) error {
@@ -117,12 +185,41 @@ func createMacvlan(conf *NetConf, ifName string, netns ns.NetNS) error {
 		err := renameLink(tmpName, ifName)
 		if err != nil {
 			_ = netlink.LinkDel(mv)
-			return fmt.Errorf("failed to rename macvlan to %q: %v", ifName, err)
+			return fmt.Errorf("failed to rename %s to %q: %v", mv.Type(), ifName, err)
 		}
 		return nil
 	})
 }
 
+// lookupMaster returns the master of conf, from netns if it is in the
+// container
+func lookupMaster(conf *NetConf, netns ns.NetNS) (netlink.Link, error) {
+	var m netlink.Link
+	lookup := func(_ ns.NetNS) error {
+		var err error
+		if m, err = netlink.LinkByName(conf.Master); err != nil {
+			return fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+		}
+		return nil
+	}
+
+	if conf.LinkContainer {
+		return m, netns.Do(lookup)
+	}
+	return m, lookup(nil)
+}
+
+// addLink adds link in the namespace the master of conf is in; when that
+// is the host, link is moved to the container through its Namespace
+func addLink(conf *NetConf, link netlink.Link, netns ns.NetNS) error {
+	if conf.LinkContainer {
+		return netns.Do(func(_ ns.NetNS) error {
+			return ip.LinkAdd(link)
+		})
+	}
+	return ip.LinkAdd(link)
+}
+
 func cmdAdd(args *skel.CmdArgs) error {
 	n, err := loadConf(args.StdinData)
 	if err != nil {
@@ -148,15 +245,151 @@ func cmdAdd(args *skel.CmdArgs) error {
 		return errors.New("IPAM plugin returned missing IPv4 config")
 	}
 
+	res := current.NewResultFromLegacy(result)
+	var tap *TapDevice
 	err = netns.Do(func(_ ns.NetNS) error {
-		return ipam.ConfigureIface(args.IfName, result)
+		// in passthru mode the macvlan shares the address of its parent
+		if n.Mode != "passthru" {
+			if err := ip.SetHWAddrByIP(args.IfName, result.IP4.IP.IP); err != nil {
+				return err
+			}
+		}
+
+		// the addresses belong to the guest behind the tap
+		if n.Tap {
+			tap, err = tapDevice(args.IfName)
+			return err
+		}
+		return ipam.ConfigureIface(args.IfName, res)
 	})
 	if err != nil {
 		return err
 	}
 
-	result.DNS = n.DNS
-	return result.Print()
+	res.DNS = types.MergeDNS(n.DNS, res.DNS)
+	if tap != nil {
+		return (&tapResult{Result: res, Version: n.CNIVersion, Tap: tap}).Print()
+	}
+	return current.PrintResult(res, n.CNIVersion)
+}
+
+// tapDevice describes the macvtap ifName, which must be in the current
+// network namespace
+func tapDevice(ifName string) (*TapDevice, error) {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+	return &TapDevice{
+		Name:  ifName,
+		Index: link.Attrs().Index,
+		Mac:   link.Attrs().HardwareAddr.String(),
+		Path:  fmt.Sprintf("/dev/tap%d", link.Attrs().Index),
+	}, nil
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if n.PrevResult == nil {
+		return errors.New("required prevResult missing")
+	}
+
+	mode, err := modeFromString(n.Mode)
+	if err != nil {
+		return err
+	}
+	modeName := n.Mode
+	if modeName == "" {
+		modeName = "bridge"
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+	}
+	defer netns.Close()
+
+	kind := "macvlan"
+	if n.Tap {
+		kind = "macvtap"
+	}
+	var l *netlink.Macvlan
+	err = netns.Do(func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(args.IfName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", args.IfName, err)
+		}
+
+		switch link := link.(type) {
+		case *netlink.Macvlan:
+			l = link
+		case *netlink.Macvtap:
+			l = &link.Macvlan
+		}
+		if l == nil || link.Type() != kind {
+			return fmt.Errorf("%q is a %s link, not %s", args.IfName, link.Type(), kind)
+		}
+		if l.Mode != mode {
+			return fmt.Errorf("%s %q is not in %q mode", kind, args.IfName, modeName)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := checkMaster(n, netns, kind, l); err != nil {
+		return err
+	}
+
+	if n.Tap {
+		return nil
+	}
+	return netns.Do(func(_ ns.NetNS) error {
+		return ipam.CheckIface(args.IfName, n.PrevResult)
+	})
+}
+
+// checkMaster verifies that link, the container interface of the given
+// kind, is still on the master of conf, and that the master is up and
+// its MTU is not below that of link
+func checkMaster(conf *NetConf, netns ns.NetNS, kind string, link *netlink.Macvlan) error {
+	check := func(_ ns.NetNS) error {
+		parent, err := netlink.LinkByIndex(link.ParentIndex)
+		if err != nil {
+			return masterError(conf, "master %q of %s %q is gone: %v", conf.Master, kind, link.Name, err)
+		}
+
+		attrs := parent.Attrs()
+		if attrs.Name != conf.Master {
+			// the link is on another interface, not on a renamed master
+			if _, err := netlink.LinkByName(conf.Master); err == nil {
+				return fmt.Errorf("%s %q is not on master %q", kind, link.Name, conf.Master)
+			}
+			return masterError(conf, "master %q of %s %q was renamed to %q", conf.Master, kind, link.Name, attrs.Name)
+		}
+		if attrs.Flags&net.FlagUp == 0 {
+			return masterError(conf, "master %q of %s %q is down", conf.Master, kind, link.Name)
+		}
+		if attrs.MTU < link.MTU {
+			return masterError(conf, "MTU %d of master %q is below the MTU %d of %s %q", attrs.MTU, conf.Master, link.MTU, kind, link.Name)
+		}
+		return nil
+	}
+
+	if conf.LinkContainer {
+		return netns.Do(check)
+	}
+	return check(nil)
+}
+
+// masterError returns an errMasterUnhealthy error carrying the master of
+// conf as its "master" field
+func masterError(conf *NetConf, format string, args ...interface{}) error {
+	return types.NewError(errMasterUnhealthy, fmt.Sprintf(format, args...), "").WithField("master", conf.Master)
 }
 
 func cmdDel(args *skel.CmdArgs) error {
@@ -188,6 +421,20 @@ func renameLink(curName, newName string) error {
 	return netlink.LinkSetName(link, newName)
 }
 
+// about is what the plugin reports on ABOUT
+var about = &types.About{
+	Name:        "macvlan",
+	Description: "attaches the container to a host interface through a macvlan or macvtap link",
+	Config: map[string]types.ConfigField{
+		"master":          {Type: "string", Required: true, Description: "host interface to enslave"},
+		"mode":            {Type: "string", Default: "bridge", Description: `"bridge", "private", "vepa" or "passthru"`},
+		"mtu":             {Type: "number", Description: "MTU of the link, the kernel default if unset"},
+		"linkInContainer": {Type: "boolean", Default: false, Description: "look up master in the container namespace"},
+		"tap":             {Type: "boolean", Default: false, Description: "create a macvtap link for a VM sandbox"},
+		"ipam":            {Type: "object", Required: true, Description: "IPAM configuration"},
+	},
+}
+
 func main() {
-	skel.PluginMain(cmdAdd, cmdDel)
+	skel.PluginMainFuncs(skel.PluginFuncs{Add: cmdAdd, Check: cmdCheck, Del: cmdDel, About: about})
 }
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/containernetworking/cni/pkg/ns"
@@ -49,8 +50,9 @@ var _ = Describe("macvlan Operations", func() {
 				},
 			})
 			Expect(err).NotTo(HaveOccurred())
-			_, err = netlink.LinkByName(MASTER_NAME)
+			m, err := netlink.LinkByName(MASTER_NAME)
 			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetUp(m)).To(Succeed())
 			return nil
 		})
 		Expect(err).NotTo(HaveOccurred())
@@ -96,6 +98,101 @@ var _ = Describe("macvlan Operations", func() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 
+	It("creates a macvtap link with tap", func() {
+		conf := &NetConf{
+			NetConf: types.NetConf{
+				Name: "testConfig",
+				Type: "macvlan",
+			},
+			Master: MASTER_NAME,
+			Mode:   "private",
+			Tap:    true,
+		}
+
+		targetNs, err := ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		defer targetNs.Close()
+
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err = createMacvlan(conf, "foobar0", targetNs)
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			link, err := netlink.LinkByName("foobar0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link).To(BeAssignableToTypeOf(&netlink.Macvtap{}))
+			Expect(link.(*netlink.Macvtap).Mode).To(Equal(netlink.MACVLAN_MODE_PRIVATE))
+
+			tap, err := tapDevice("foobar0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tap).To(Equal(&TapDevice{
+				Name:  "foobar0",
+				Index: link.Attrs().Index,
+				Mac:   link.Attrs().HardwareAddr.String(),
+				Path:  fmt.Sprintf("/dev/tap%d", link.Attrs().Index),
+			}))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("creates a macvlan link on a master in the container with linkInContainer", func() {
+		conf := &NetConf{
+			NetConf: types.NetConf{
+				Name: "testConfig",
+				Type: "macvlan",
+			},
+			Master:        "master0",
+			LinkContainer: true,
+		}
+
+		targetNs, err := ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		defer targetNs.Close()
+
+		err = targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			// as left by an earlier plugin in the chain
+			err := netlink.LinkAdd(&netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: "master0"},
+				PeerName:  "master1",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := createMacvlan(conf, "foobar0", targetNs)
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			master, err := netlink.LinkByName("master0")
+			Expect(err).NotTo(HaveOccurred())
+			link, err := netlink.LinkByName("foobar0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link).To(BeAssignableToTypeOf(&netlink.Macvlan{}))
+			Expect(link.Attrs().ParentIndex).To(Equal(master.Attrs().Index))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
 	It("configures and deconfigures a macvlan link with ADD/DEL", func() {
 		const IFNAME = "macvl0"
 
@@ -165,4 +262,164 @@ var _ = Describe("macvlan Operations", func() {
 		})
 		Expect(err).NotTo(HaveOccurred())
 	})
+
+	It("verifies a macvlan link with CHECK", func() {
+		const IFNAME = "macvl0"
+
+		conf := fmt.Sprintf(`{
+    "name": "mynet",
+    "type": "macvlan",
+    "master": "%s",
+    "ipam": {
+        "type": "host-local",
+        "subnet": "10.1.2.0/24"
+    }
+}`, MASTER_NAME)
+
+		targetNs, err := ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		defer targetNs.Close()
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      IFNAME,
+			StdinData:   []byte(conf),
+		}
+
+		var result *types.Result
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			result, err = testutils.CmdAddWithResult(targetNs.Path(), IFNAME, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		check := func(mode string) error {
+			checkConf := map[string]interface{}{
+				"name":       "mynet",
+				"type":       "macvlan",
+				"master":     MASTER_NAME,
+				"mode":       mode,
+				"prevResult": result,
+			}
+			stdin, err := json.Marshal(checkConf)
+			Expect(err).NotTo(HaveOccurred())
+
+			checkArgs := *args
+			checkArgs.StdinData = stdin
+			err = originalNS.Do(func(ns.NetNS) error {
+				return testutils.CmdCheckWithResult(targetNs.Path(), IFNAME, func() error {
+					return cmdCheck(&checkArgs)
+				})
+			})
+			return err
+		}
+
+		Expect(check("")).To(Succeed())
+
+		err = check("private")
+		Expect(err).To(MatchError(`macvlan "macvl0" is not in "private" mode`))
+
+		// CHECK fails once the address has gone
+		err = targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			link, err := netlink.LinkByName(IFNAME)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.AddrDel(link, &netlink.Addr{IPNet: &result.IP4.IP})).To(Succeed())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = check("")
+		Expect(err).To(MatchError(fmt.Sprintf(`"macvl0" is missing IP addr %s`, result.IP4.IP.String())))
+	})
+
+	It("reports an unhealthy master with CHECK", func() {
+		const IFNAME = "macvl0"
+
+		conf := fmt.Sprintf(`{
+    "name": "mynet",
+    "type": "macvlan",
+    "master": "%s",
+    "ipam": {
+        "type": "host-local",
+        "subnet": "10.1.2.0/24"
+    }
+}`, MASTER_NAME)
+
+		targetNs, err := ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		defer targetNs.Close()
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      IFNAME,
+			StdinData:   []byte(conf),
+		}
+
+		var result *types.Result
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			result, err = testutils.CmdAddWithResult(targetNs.Path(), IFNAME, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		check := func() error {
+			stdin, err := json.Marshal(map[string]interface{}{
+				"name":       "mynet",
+				"type":       "macvlan",
+				"master":     MASTER_NAME,
+				"prevResult": result,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			checkArgs := *args
+			checkArgs.StdinData = stdin
+			return originalNS.Do(func(ns.NetNS) error {
+				return testutils.CmdCheckWithResult(targetNs.Path(), IFNAME, func() error {
+					return cmdCheck(&checkArgs)
+				})
+			})
+		}
+		// withMaster runs f on the master, by the name it has now
+		withMaster := func(name string, f func(netlink.Link) error) {
+			err := originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				m, err := netlink.LinkByName(name)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(f(m)).To(Succeed())
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		expectUnhealthy := func(err error, msg string) {
+			Expect(err).To(MatchError(msg))
+			code, _ := types.ErrorCode(err)
+			Expect(code).To(Equal(errMasterUnhealthy))
+		}
+
+		Expect(check()).To(Succeed())
+
+		withMaster(MASTER_NAME, netlink.LinkSetDown)
+		expectUnhealthy(check(), `master "eth0" of macvlan "macvl0" is down`)
+
+		withMaster(MASTER_NAME, func(m netlink.Link) error {
+			return netlink.LinkSetName(m, "eth1")
+		})
+		withMaster("eth1", netlink.LinkSetUp)
+		expectUnhealthy(check(), `master "eth0" of macvlan "macvl0" was renamed to "eth1"`)
+	})
 })
@@ -19,17 +19,20 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"os"
 	"runtime"
+	"time"
 
 	"github.com/vishvananda/netlink"
 
 	"github.com/containernetworking/cni/pkg/ip"
 	"github.com/containernetworking/cni/pkg/ipam"
+	"github.com/containernetworking/cni/pkg/logging"
 	"github.com/containernetworking/cni/pkg/ns"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
 	"github.com/containernetworking/cni/pkg/utils"
+	"github.com/containernetworking/cni/pkg/utils/sysctl"
 )
 
 func init() {
@@ -41,11 +44,100 @@ func init() {
 
 type NetConf struct {
 	types.NetConf
-	IPMasq bool `json:"ipMasq"`
-	MTU    int  `json:"mtu"`
+	IPMasq         bool               `json:"ipMasq"`
+	IPMasqBackend  ip.FirewallBackend `json:"ipMasqBackend,omitempty"`
+	IPMasqExclude  []types.IPNet      `json:"ipMasqExclude,omitempty"`
+	MTU            int                `json:"mtu"`
+	IfNameConflict ip.IfNamePolicy    `json:"ifNameConflict,omitempty"`
+	ProxyARP       bool               `json:"proxyArp"`
+	ProxyARPIface  string             `json:"proxyArpInterface,omitempty"`
+	Log            logging.Config     `json:"log,omitempty"`
+	RuntimeConfig  struct {
+		// Mac is the hardware address the runtime asks for on the
+		// container interface, with the "mac" capability
+		Mac string `json:"mac,omitempty"`
+	} `json:"runtimeConfig,omitempty"`
+	// ValidAttachments are the attachments of the network still in
+	// use, given on GC
+	ValidAttachments []utils.Attachment `json:"cni.dev/valid-attachments,omitempty"`
+
+	// mac is RuntimeConfig.Mac parsed, nil if there is none
+	mac net.HardwareAddr
 }
 
-func setupContainerVeth(netns, ifName string, mtu int, pr *types.Result) (string, error) {
+// stateDir holds the configuration each attachment was added with, so
+// that it can be torn down after the network configuration is gone
+const stateDir = "/var/lib/cni/ptp"
+
+// settleTimeout bounds the wait for the container's IPv6 addresses to
+// become usable
+const settleTimeout = 10 * time.Second
+
+func loadNetConf(bytes []byte) (*NetConf, error) {
+	conf := &NetConf{}
+	if err := json.Unmarshal(bytes, conf); err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %v", err)
+	}
+	if conf.RuntimeConfig.Mac != "" {
+		mac, err := net.ParseMAC(conf.RuntimeConfig.Mac)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mac %q in runtimeConfig: %v", conf.RuntimeConfig.Mac, err)
+		}
+		conf.mac = mac
+	}
+	return conf, nil
+}
+
+// loadDelConf returns the configuration the attachment was added with,
+// or the one given if none was saved, along with its raw form
+func loadDelConf(cache *ipam.ConfCache, args *skel.CmdArgs) (*NetConf, []byte, bool, error) {
+	_, stdin, found, err := cache.Load(args.ContainerID, args.IfName)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if !found {
+		stdin = args.StdinData
+	}
+
+	conf, err := loadNetConf(stdin)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return conf, stdin, found, nil
+}
+
+// savedAddrs returns the container addresses of the result saved when
+// the attachment was added, if any
+func savedAddrs(result *current.Result) []*net.IPNet {
+	if result == nil {
+		return nil
+	}
+
+	var ipns []*net.IPNet
+	for _, ipc := range result.IPs {
+		ipn := ipc.Address
+		ipns = append(ipns, &ipn)
+	}
+	return ipns
+}
+
+func setupLogging(conf *NetConf, command string, args *skel.CmdArgs) (func(), error) {
+	return logging.Setup(conf.Log, "plugin", "ptp", "command", command,
+		"containerID", args.ContainerID, "ifName", args.IfName, "network", conf.Name)
+}
+
+// resolveIfName returns the name to give the container interface, which
+// differs from ifName if that was taken and policy allowed picking another
+func resolveIfName(netns, ifName string, policy ip.IfNamePolicy) (string, error) {
+	err := ns.WithNetNSPath(netns, func(_ ns.NetNS) error {
+		var err error
+		ifName, err = ip.ResolveIfName(ifName, policy)
+		return err
+	})
+	return ifName, err
+}
+
+func setupContainerVeth(netns, ifName string, mtu int, mac net.HardwareAddr, pr *types.Result) (string, net.HardwareAddr, error) {
 	// The IPAM result will be something like IP=192.168.3.5/24, GW=192.168.3.1.
 	// What we want is really a point-to-point link but veth does not support IFF_POINTOPONT.
 	// Next best thing would be to let it ARP but set interface to 192.168.3.5/32 and
@@ -58,13 +150,19 @@ func setupContainerVeth(netns, ifName string, mtu int, pr *types.Result) (string
 	// In other words we force all traffic to ARP via the gateway except for GW itself.
 
 	var hostVethName string
+	var contMac net.HardwareAddr
 	err := ns.WithNetNSPath(netns, func(hostNS ns.NetNS) error {
 		hostVeth, _, err := ip.SetupVeth(ifName, mtu, hostNS)
 		if err != nil {
 			return err
 		}
+		if mac != nil {
+			if err := ip.SetHWAddr(ifName, mac); err != nil {
+				return err
+			}
+		}
 
-		if err = ipam.ConfigureIface(ifName, pr); err != nil {
+		if err = ipam.ConfigureIface(ifName, current.NewResultFromLegacy(pr)); err != nil {
 			return err
 		}
 
@@ -73,76 +171,132 @@ func setupContainerVeth(netns, ifName string, mtu int, pr *types.Result) (string
 			return fmt.Errorf("failed to look up %q: %v", ifName, err)
 		}
 
-		// Delete the route that was automatically added
-		route := netlink.Route{
-			LinkIndex: contVeth.Attrs().Index,
-			Dst: &net.IPNet{
-				IP:   pr.IP4.IP.IP.Mask(pr.IP4.IP.Mask),
-				Mask: pr.IP4.IP.Mask,
-			},
-			Scope: netlink.SCOPE_NOWHERE,
-		}
-
-		if err := netlink.RouteDel(&route); err != nil {
-			return fmt.Errorf("failed to delete route %v: %v", route, err)
-		}
-
-		for _, r := range []netlink.Route{
-			netlink.Route{
-				LinkIndex: contVeth.Attrs().Index,
-				Dst: &net.IPNet{
-					IP:   pr.IP4.Gateway,
-					Mask: net.CIDRMask(32, 32),
-				},
-				Scope: netlink.SCOPE_LINK,
-				Src:   pr.IP4.IP.IP,
-			},
-			netlink.Route{
-				LinkIndex: contVeth.Attrs().Index,
-				Dst: &net.IPNet{
-					IP:   pr.IP4.IP.IP.Mask(pr.IP4.IP.Mask),
-					Mask: pr.IP4.IP.Mask,
-				},
-				Scope: netlink.SCOPE_UNIVERSE,
-				Gw:    pr.IP4.Gateway,
-				Src:   pr.IP4.IP.IP,
-			},
-		} {
-			if err := netlink.RouteAdd(&r); err != nil {
-				return fmt.Errorf("failed to add route %v: %v", r, err)
+		// the routes below use the container addresses as their source
+		if pr.IP6 != nil {
+			if err := ip.SettleAddresses(ifName, settleTimeout); err != nil {
+				return err
+			}
+		}
+
+		for _, ipc := range resultIPConfigs(pr) {
+			if err := setupContainerRoutes(contVeth, ipc); err != nil {
+				return err
 			}
 		}
 
 		hostVethName = hostVeth.Attrs().Name
+		contMac = contVeth.Attrs().HardwareAddr
 
 		return nil
 	})
-	return hostVethName, err
+	return hostVethName, contMac, err
 }
 
-func setupHostVeth(vethName string, ipConf *types.IPConfig) error {
+// resultIPConfigs returns the IP configuration of each family in r
+func resultIPConfigs(r *types.Result) []*types.IPConfig {
+	var ipcs []*types.IPConfig
+	for _, ipc := range []*types.IPConfig{r.IP4, r.IP6} {
+		if ipc != nil {
+			ipcs = append(ipcs, ipc)
+		}
+	}
+	return ipcs
+}
+
+// hostMask returns the mask of a single address of ip's family
+func hostMask(ip net.IP) net.IPMask {
+	if ip.To4() != nil {
+		return net.CIDRMask(32, 32)
+	}
+	return net.CIDRMask(128, 128)
+}
+
+// setupContainerRoutes replaces the subnet route the kernel added for
+// the address of ipc with a route to the gateway and a route to the
+// subnet via the gateway; see setupContainerVeth.
+func setupContainerRoutes(contVeth netlink.Link, ipc *types.IPConfig) error {
+	if ipc.Gateway == nil {
+		return fmt.Errorf("IPAM plugin returned no gateway for %v", ipc.IP.String())
+	}
+
+	// Delete the route that was automatically added
+	route := netlink.Route{
+		LinkIndex: contVeth.Attrs().Index,
+		Dst: &net.IPNet{
+			IP:   ipc.IP.IP.Mask(ipc.IP.Mask),
+			Mask: ipc.IP.Mask,
+		},
+		Scope: netlink.SCOPE_NOWHERE,
+	}
+	if ipc.IP.IP.To4() == nil {
+		// IPv6 routes have no scope
+		route.Scope = netlink.SCOPE_UNIVERSE
+	}
+
+	if err := netlink.RouteDel(&route); err != nil {
+		return fmt.Errorf("failed to delete route %v: %v", route, err)
+	}
+
+	gwNet := &net.IPNet{
+		IP:   ipc.Gateway,
+		Mask: hostMask(ipc.Gateway),
+	}
+	opts := ip.RouteOptions{Scope: netlink.SCOPE_LINK, Src: ipc.IP.IP}
+	if err := ip.AddRouteWithOptions(gwNet, nil, contVeth, opts); err != nil {
+		return fmt.Errorf("failed to add route to %v: %v", gwNet, err)
+	}
+
+	subnet := ip.Network(&ipc.IP)
+	opts = ip.RouteOptions{Scope: netlink.SCOPE_UNIVERSE, Src: ipc.IP.IP}
+	if err := ip.AddRouteWithOptions(subnet, ipc.Gateway, contVeth, opts); err != nil {
+		return fmt.Errorf("failed to add route to %v via %v: %v", subnet, ipc.Gateway, err)
+	}
+
+	return nil
+}
+
+func setupHostVeth(vethName string, ipConfigs []*types.IPConfig) error {
 	// hostVeth moved namespaces and may have a new ifindex
 	veth, err := netlink.LinkByName(vethName)
 	if err != nil {
 		return fmt.Errorf("failed to lookup %q: %v", vethName, err)
 	}
 
-	// TODO(eyakubovich): IPv6
+	for _, ipConf := range ipConfigs {
+		if ipConf.IP.IP.To4() == nil {
+			// the container resolves the gateway address right away,
+			// before duplicate address detection would have finished
+			name := fmt.Sprintf("net/ipv6/conf/%s/accept_dad", vethName)
+			if _, err := sysctl.Sysctl(name, "0"); err != nil {
+				return fmt.Errorf("failed to set %s: %v", name, err)
+			}
+		}
+
+		if err := setupHostVethAddr(veth, ipConf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func setupHostVethAddr(veth netlink.Link, ipConf *types.IPConfig) error {
 	ipn := &net.IPNet{
 		IP:   ipConf.Gateway,
-		Mask: net.CIDRMask(32, 32),
+		Mask: hostMask(ipConf.Gateway),
 	}
 	addr := &netlink.Addr{IPNet: ipn, Label: ""}
-	if err = netlink.AddrAdd(veth, addr); err != nil {
+	if err := netlink.AddrAdd(veth, addr); err != nil {
 		return fmt.Errorf("failed to add IP addr (%#v) to veth: %v", ipn, err)
 	}
 
 	ipn = &net.IPNet{
 		IP:   ipConf.IP.IP,
-		Mask: net.CIDRMask(32, 32),
+		Mask: hostMask(ipConf.IP.IP),
 	}
-	// dst happens to be the same as IP/net of host veth
-	if err = ip.AddHostRoute(ipn, nil, veth); err != nil && !os.IsExist(err) {
+	// dst happens to be the same as IP/net of host veth. A route left
+	// behind by an earlier, partially completed ADD is replaced.
+	if err := ip.ReplaceRouteWithOptions(ipn, nil, veth, ip.RouteOptions{Scope: netlink.SCOPE_HOST}); err != nil {
 		return fmt.Errorf("failed to add route on host: %v", err)
 	}
 
@@ -150,80 +304,205 @@ func setupHostVeth(vethName string, ipConf *types.IPConfig) error {
 }
 
 func cmdAdd(args *skel.CmdArgs) error {
-	conf := NetConf{}
-	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
-		return fmt.Errorf("failed to load netconf: %v", err)
+	conf, err := loadNetConf(args.StdinData)
+	if err != nil {
+		return err
 	}
 
-	if err := ip.EnableIP4Forward(); err != nil {
-		return fmt.Errorf("failed to enable forwarding: %v", err)
+	closeLog, err := setupLogging(conf, "ADD", args)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	ifName, err := resolveIfName(args.Netns, args.IfName, conf.IfNameConflict)
+	if err != nil {
+		return err
 	}
 
 	// run the IPAM plugin and get back the config to apply
-	result, err := ipam.ExecAdd(conf.IPAM.Type, args.StdinData)
+	cache := ipam.NewConfCache(stateDir)
+	result, err := ipam.ExecAddWithCache(cache, args.ContainerID, args.IfName, conf.IPAM.Type, args.StdinData)
 	if err != nil {
+		logging.Errorf("IPAM plugin %q failed: %v", conf.IPAM.Type, err)
 		return err
 	}
-	if result.IP4 == nil {
-		return errors.New("IPAM plugin returned missing IPv4 config")
+	logging.Debugf("IPAM plugin %q returned %v", conf.IPAM.Type, result)
+	ipConfigs := resultIPConfigs(result)
+	if len(ipConfigs) == 0 {
+		return errors.New("IPAM plugin returned no IP config")
 	}
 
-	hostVethName, err := setupContainerVeth(args.Netns, args.IfName, conf.MTU, result)
+	if err := ip.EnableForward(current.NewResultFromLegacy(result).IPs); err != nil {
+		return fmt.Errorf("failed to enable forwarding: %v", err)
+	}
+
+	hostVethName, mac, err := setupContainerVeth(args.Netns, ifName, conf.MTU, conf.mac, result)
 	if err != nil {
 		return err
 	}
+	logging.Debugf("created veth pair %q in container and %q on host", ifName, hostVethName)
 
-	if err = setupHostVeth(hostVethName, result.IP4); err != nil {
+	if err = setupHostVeth(hostVethName, ipConfigs); err != nil {
 		return err
 	}
 
+	if conf.ProxyARP {
+		var ips []net.IP
+		for _, ipc := range ipConfigs {
+			ips = append(ips, ipc.IP.IP)
+		}
+		if err = ip.SetupProxyNeigh(conf.ProxyARPIface, ips); err != nil {
+			return err
+		}
+		logging.Debugf("published %v with proxy ARP/NDP", ips)
+	}
+
 	if conf.IPMasq {
-		chain := utils.FormatChainName(conf.Name, args.ContainerID)
-		comment := utils.FormatComment(conf.Name, args.ContainerID)
-		if err = ip.SetupIPMasq(&result.IP4.IP, chain, comment); err != nil {
+		chain, err := utils.FormatChainNameWithPrefix("CNI-", conf.Name, args.ContainerID, args.IfName)
+		if err != nil {
 			return err
 		}
+		comment := utils.FormatCommentWithIfName(conf.Name, args.ContainerID, args.IfName)
+		exclude := make([]*net.IPNet, len(conf.IPMasqExclude))
+		for i := range conf.IPMasqExclude {
+			exclude[i] = (*net.IPNet)(&conf.IPMasqExclude[i])
+		}
+		for _, ipc := range ipConfigs {
+			if err = ip.SetupIPMasqWithBackend(conf.IPMasqBackend, &ipc.IP, chain, comment, exclude...); err != nil {
+				return err
+			}
+			logging.Debugf("set up masquerading for %v in chain %q", ipc.IP.String(), chain)
+		}
 	}
 
-	result.DNS = conf.DNS
-	return result.Print()
+	logging.Infof("connected %q to host veth %q with %v", ifName, hostVethName, result)
+
+	result.DNS = types.MergeDNS(conf.DNS, result.DNS)
+	res := current.NewResultFromLegacy(result)
+	res.AddSandboxInterface(ifName, mac.String(), args.Netns)
+	if err := cache.SaveResult(args.ContainerID, args.IfName, res); err != nil {
+		return fmt.Errorf("failed to save attachment state: %v", err)
+	}
+	return current.PrintResult(res, conf.CNIVersion)
 }
 
 func cmdDel(args *skel.CmdArgs) error {
-	conf := NetConf{}
-	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
-		return fmt.Errorf("failed to load netconf: %v", err)
+	cache := ipam.NewConfCache(stateDir)
+	conf, stdin, cached, err := loadDelConf(cache, args)
+	if err != nil {
+		return err
 	}
 
-	if err := ipam.ExecDel(conf.IPAM.Type, args.StdinData); err != nil {
+	closeLog, err := setupLogging(conf, "DEL", args)
+	if err != nil {
 		return err
 	}
-
-	if args.Netns == "" {
-		return nil
+	defer closeLog()
+	if cached {
+		logging.Debugf("tearing down with the configuration saved at ADD")
 	}
 
-	var ipn *net.IPNet
-	err := ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
-		var err error
-		ipn, err = ip.DelLinkByNameAddr(args.IfName, netlink.FAMILY_V4)
+	if err := ipam.ExecDel(conf.IPAM.Type, stdin); err != nil {
+		logging.Errorf("IPAM plugin %q failed: %v", conf.IPAM.Type, err)
 		return err
-	})
+	}
+
+	saved, err := cache.LoadResult(args.ContainerID, args.IfName)
 	if err != nil {
 		return err
 	}
+	// the container interface has another name if args.IfName was taken
+	ifName := args.IfName
+	if saved != nil && saved.SandboxInterface() != "" {
+		ifName = saved.SandboxInterface()
+	}
+
+	var ipns []*net.IPNet
+	if args.Netns == "" {
+		logging.Debugf("no netns given, skipping interface teardown")
+	} else {
+		err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+			var err error
+			ipns, err = ip.DelLinkByNameAddr(ifName)
+			return err
+		})
+		switch err.(type) {
+		case nil:
+			logging.Debugf("deleted %q with addresses %v", ifName, ipns)
+		case ns.NSPathNotExistErr, ns.NSPathDeadErr:
+			logging.Debugf("netns %q is gone, skipping interface teardown", args.Netns)
+		default:
+			return err
+		}
+	}
+
+	// without the container its addresses are only known from the ADD
+	if ipns == nil {
+		ipns = savedAddrs(saved)
+	}
 
 	if conf.IPMasq {
-		chain := utils.FormatChainName(conf.Name, args.ContainerID)
-		comment := utils.FormatComment(conf.Name, args.ContainerID)
-		if err = ip.TeardownIPMasq(ipn, chain, comment); err != nil {
+		chain, err := utils.FormatChainNameWithPrefix("CNI-", conf.Name, args.ContainerID, args.IfName)
+		if err != nil {
 			return err
 		}
+		comment := utils.FormatCommentWithIfName(conf.Name, args.ContainerID, args.IfName)
+		// attachments added before chains were per interface share one
+		// chain per container
+		legacyChain := utils.FormatChainName(conf.Name, args.ContainerID)
+		legacyComment := utils.FormatComment(conf.Name, args.ContainerID)
+		for _, ipn := range ipns {
+			if err = ip.TeardownIPMasqWithBackend(conf.IPMasqBackend, ipn, chain, comment); err != nil {
+				return err
+			}
+			if err = ip.TeardownIPMasqWithBackend(conf.IPMasqBackend, ipn, legacyChain, legacyComment); err != nil {
+				return err
+			}
+		}
 	}
 
-	return nil
+	if conf.ProxyARP {
+		var ips []net.IP
+		for _, ipn := range ipns {
+			ips = append(ips, ipn.IP)
+		}
+		if err = ip.TeardownProxyNeigh(conf.ProxyARPIface, ips); err != nil {
+			return err
+		}
+	}
+
+	return cache.Remove(args.ContainerID, args.IfName)
+}
+
+// cmdGC removes the masquerade chains of the attachments of the network
+// that are not among the valid ones, such as those of a container whose
+// DEL never came
+func cmdGC(args *skel.CmdArgs) error {
+	conf, err := loadNetConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	closeLog, err := setupLogging(conf, "GC", args)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	orphaned, err := utils.OrphanedChains("CNI-", conf.Name, conf.ValidAttachments)
+	if err != nil {
+		return err
+	}
+	return ip.SweepIPMasq(conf.IPMasqBackend, func(chain, comment string) bool {
+		if !orphaned(chain, comment) {
+			return false
+		}
+		logging.Debugf("removing orphaned masquerade chain %q", chain)
+		return true
+	})
 }
 
 func main() {
-	skel.PluginMain(cmdAdd, cmdDel)
+	skel.PluginMainFuncs(skel.PluginFuncs{Add: cmdAdd, Del: cmdDel, GC: cmdGC})
 }
@@ -0,0 +1,254 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// win-bridge attaches Windows containers to an L2Bridge network of the
+// Host Networking Service, each through an HNS endpoint of its own.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/hns"
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+)
+
+type NetConf struct {
+	types.NetConf
+	// the result of the ADD being checked, in any version
+	PrevResult *current.Result `json:"-"`
+	// Policies are added to the HNS endpoint of every container as they
+	// are, e.g. an OutBoundNAT policy to masquerade its traffic
+	Policies []json.RawMessage `json:"policies,omitempty"`
+}
+
+func loadConf(bytes []byte) (*NetConf, error) {
+	n := &NetConf{}
+	if err := json.Unmarshal(bytes, n); err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %v", err)
+	}
+	if n.IPAM.Type == "" {
+		return nil, errors.New("IPAM plugin is required")
+	}
+	prev, err := current.ParsePrevResult(bytes)
+	if err != nil {
+		return nil, err
+	}
+	n.PrevResult = prev
+	return n, nil
+}
+
+// endpointName is the name of the HNS endpoint of the container on the
+// network, which identifies it across ADD, CHECK and DEL
+func endpointName(containerID, network string) string {
+	return containerID + "_" + network
+}
+
+// hnsNetwork returns the HNS network with the name of the network of n,
+// which must be an L2Bridge one
+func hnsNetwork(n *NetConf) (*hns.Network, error) {
+	network, err := hns.NetworkByName(n.Name)
+	if err != nil {
+		return nil, err
+	}
+	if network.Type != "L2Bridge" {
+		return nil, fmt.Errorf("HNS network %q is of type %s, not L2Bridge", n.Name, network.Type)
+	}
+	return network, nil
+}
+
+// subnetGateway returns the gateway of the subnet of network that
+// contains ip, or nil if there is none
+func subnetGateway(network *hns.Network, ip net.IP) net.IP {
+	for _, s := range network.Subnets {
+		_, subnet, err := net.ParseCIDR(s.AddressPrefix)
+		if err != nil || !subnet.Contains(ip) {
+			continue
+		}
+		return net.ParseIP(s.GatewayAddress)
+	}
+	return nil
+}
+
+// newEndpoint returns the endpoint called name giving the container the
+// IPv4 address of result on network, through the gateway of result or
+// else that of the subnet of the address
+func newEndpoint(name string, n *NetConf, network *hns.Network, result *types.Result) (*hns.Endpoint, error) {
+	// HNS endpoints have a single IPv4 address
+	if result.IP4 == nil {
+		return nil, errors.New("IPAM plugin returned no IPv4 config")
+	}
+	ones, _ := result.IP4.IP.Mask.Size()
+	dns := types.MergeDNS(n.DNS, result.DNS)
+
+	ep := &hns.Endpoint{
+		Name:           name,
+		VirtualNetwork: network.ID,
+		Policies:       n.Policies,
+		IPAddress:      result.IP4.IP.IP,
+		PrefixLength:   uint8(ones),
+		DNSSuffix:      dns.Domain,
+		DNSServerList:  strings.Join(dns.Nameservers, ","),
+	}
+	gw := result.IP4.Gateway
+	if gw == nil {
+		gw = subnetGateway(network, result.IP4.IP.IP)
+	}
+	if gw != nil {
+		ep.GatewayAddress = gw.String()
+	}
+	return ep, nil
+}
+
+// endpointResult returns the result of attaching the container to ep,
+// the container interface there is named after the endpoint
+func endpointResult(ep *hns.Endpoint, netns string) *current.Result {
+	res := current.NewResultFromLegacy(&types.Result{
+		IP4: &types.IPConfig{
+			IP:      net.IPNet{IP: ep.IPAddress, Mask: net.CIDRMask(int(ep.PrefixLength), 32)},
+			Gateway: net.ParseIP(ep.GatewayAddress),
+		},
+	})
+	res.DNS.Domain = ep.DNSSuffix
+	if ep.DNSServerList != "" {
+		res.DNS.Nameservers = strings.Split(ep.DNSServerList, ",")
+	}
+
+	// HNS spells MAC addresses with dashes
+	mac := ep.MacAddress
+	if hw, err := net.ParseMAC(mac); err == nil {
+		mac = hw.String()
+	}
+	res.AddSandboxInterface(ep.Name, mac, netns)
+	return res
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	network, err := hnsNetwork(n)
+	if err != nil {
+		return err
+	}
+
+	// An endpoint only outlives the ADD creating it once it is attached,
+	// so a repeated ADD of the container finds it ready.
+	name := endpointName(args.ContainerID, n.Name)
+	ep, err := hns.EndpointByName(name)
+	if err != nil {
+		return err
+	}
+	if ep != nil {
+		return current.PrintResult(endpointResult(ep, args.Netns), n.CNIVersion)
+	}
+
+	result, err := invoke.DelegateAdd(n.IPAM.Type, args.StdinData)
+	if err != nil {
+		return err
+	}
+	ep, err = newEndpoint(name, n, network, result)
+	if err != nil {
+		return err
+	}
+	if ep, err = hns.CreateEndpoint(ep); err != nil {
+		return fmt.Errorf("failed to create HNS endpoint %q: %v", name, err)
+	}
+	if err := hns.AttachEndpoint(ep.ID, args.ContainerID); err != nil {
+		hns.DeleteEndpoint(ep.ID)
+		return fmt.Errorf("failed to attach HNS endpoint %q: %v", name, err)
+	}
+
+	return current.PrintResult(endpointResult(ep, args.Netns), n.CNIVersion)
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if n.PrevResult == nil {
+		return errors.New("required prevResult missing")
+	}
+	network, err := hnsNetwork(n)
+	if err != nil {
+		return err
+	}
+
+	name := endpointName(args.ContainerID, n.Name)
+	ep, err := hns.EndpointByName(name)
+	if err != nil {
+		return err
+	}
+	if ep == nil {
+		return fmt.Errorf("HNS endpoint %q not found", name)
+	}
+	if ep.VirtualNetwork != network.ID {
+		return fmt.Errorf("HNS endpoint %q is not on network %q", name, n.Name)
+	}
+	if err := checkAddress(ep, n.PrevResult); err != nil {
+		return err
+	}
+
+	return invoke.DelegateCheck(n.IPAM.Type, args.StdinData)
+}
+
+// checkAddress fails unless ep has the IPv4 address of result
+func checkAddress(ep *hns.Endpoint, result *current.Result) error {
+	got := endpointResult(ep, "").IPs[0].Address.String()
+	for _, ipc := range result.IPs {
+		if ipc.Address.IP.To4() == nil {
+			continue
+		}
+		if want := ipc.Address.String(); want != got {
+			return fmt.Errorf("HNS endpoint %q has address %s, not %s", ep.Name, got, want)
+		}
+		return nil
+	}
+	return fmt.Errorf("HNS endpoint %q has address %s, but the result has no IPv4 address", ep.Name, got)
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	name := endpointName(args.ContainerID, n.Name)
+	ep, err := hns.EndpointByName(name)
+	if err != nil {
+		return err
+	}
+	if ep != nil {
+		// Detaching fails once the container is gone, which takes the
+		// attachment with it; the endpoint is deleted either way.
+		hns.DetachEndpoint(ep.ID, args.ContainerID)
+		if err := hns.DeleteEndpoint(ep.ID); err != nil {
+			return fmt.Errorf("failed to delete HNS endpoint %q: %v", name, err)
+		}
+	}
+
+	return invoke.DelegateDel(n.IPAM.Type, args.StdinData)
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.PluginFuncs{Add: cmdAdd, Check: cmdCheck, Del: cmdDel})
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/hns"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("win-bridge", func() {
+	const conf = `{
+		"cniVersion": "0.3.0",
+		"name": "cbr0",
+		"type": "win-bridge",
+		"dns": {"nameservers": ["10.1.0.10"], "domain": "cluster.local"},
+		"policies": [{"Type": "OutBoundNAT", "ExceptionList": ["10.1.0.0/16"]}],
+		"ipam": {"type": "host-local", "subnet": "10.1.2.0/24"}
+	}`
+
+	network := &hns.Network{
+		ID:      "net1",
+		Name:    "cbr0",
+		Type:    "L2Bridge",
+		Subnets: []hns.Subnet{{AddressPrefix: "10.1.2.0/24", GatewayAddress: "10.1.2.1"}},
+	}
+
+	ipamResult := func(cidr, gw string) *types.Result {
+		ip, ipn, err := net.ParseCIDR(cidr)
+		Expect(err).NotTo(HaveOccurred())
+		ipn.IP = ip
+		return &types.Result{IP4: &types.IPConfig{IP: *ipn, Gateway: net.ParseIP(gw)}}
+	}
+
+	It("names the endpoint after the container and the network", func() {
+		Expect(endpointName("dummy", "cbr0")).To(Equal("dummy_cbr0"))
+	})
+
+	It("requires an IPAM plugin", func() {
+		_, err := loadConf([]byte(`{"name": "cbr0", "type": "win-bridge"}`))
+		Expect(err).To(MatchError("IPAM plugin is required"))
+	})
+
+	It("gives the endpoint the address, DNS and policies of the container", func() {
+		n, err := loadConf([]byte(conf))
+		Expect(err).NotTo(HaveOccurred())
+
+		ep, err := newEndpoint("dummy_cbr0", n, network, ipamResult("10.1.2.3/24", ""))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ep.Name).To(Equal("dummy_cbr0"))
+		Expect(ep.VirtualNetwork).To(Equal("net1"))
+		Expect(ep.IPAddress.String()).To(Equal("10.1.2.3"))
+		Expect(ep.PrefixLength).To(Equal(uint8(24)))
+		Expect(ep.GatewayAddress).To(Equal("10.1.2.1"))
+		Expect(ep.DNSSuffix).To(Equal("cluster.local"))
+		Expect(ep.DNSServerList).To(Equal("10.1.0.10"))
+		Expect(ep.Policies).To(Equal([]json.RawMessage{json.RawMessage(`{"Type": "OutBoundNAT", "ExceptionList": ["10.1.0.0/16"]}`)}))
+
+		ep, err = newEndpoint("dummy_cbr0", n, network, ipamResult("10.1.2.3/24", "10.1.2.254"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ep.GatewayAddress).To(Equal("10.1.2.254"))
+
+		_, err = newEndpoint("dummy_cbr0", n, network, &types.Result{})
+		Expect(err).To(MatchError("IPAM plugin returned no IPv4 config"))
+	})
+
+	It("reports the endpoint as the container interface", func() {
+		res := endpointResult(&hns.Endpoint{
+			Name:           "dummy_cbr0",
+			MacAddress:     "00-15-5D-01-02-03",
+			IPAddress:      net.ParseIP("10.1.2.3"),
+			PrefixLength:   24,
+			GatewayAddress: "10.1.2.1",
+			DNSSuffix:      "cluster.local",
+			DNSServerList:  "10.1.0.10,10.1.0.11",
+		}, "netns1")
+
+		Expect(res.Interfaces).To(Equal([]*current.Interface{{Name: "dummy_cbr0", Mac: "00:15:5d:01:02:03", Sandbox: "netns1"}}))
+		Expect(res.IPs).To(HaveLen(1))
+		Expect(res.IPs[0].Address.String()).To(Equal("10.1.2.3/24"))
+		Expect(res.IPs[0].Gateway.String()).To(Equal("10.1.2.1"))
+		Expect(*res.IPs[0].Interface).To(Equal(0))
+		Expect(res.DNS).To(Equal(types.DNS{Domain: "cluster.local", Nameservers: []string{"10.1.0.10", "10.1.0.11"}}))
+	})
+
+	It("compares the address of the endpoint with that of the result on CHECK", func() {
+		ep := &hns.Endpoint{Name: "dummy_cbr0", IPAddress: net.ParseIP("10.1.2.3"), PrefixLength: 24}
+		Expect(checkAddress(ep, endpointResult(ep, ""))).To(Succeed())
+
+		ep.IPAddress = net.ParseIP("10.1.2.4")
+		res := current.NewResultFromLegacy(ipamResult("10.1.2.3/24", ""))
+		Expect(checkAddress(ep, res)).To(MatchError(`HNS endpoint "dummy_cbr0" has address 10.1.2.4/24, not 10.1.2.3/24`))
+	})
+})
@@ -0,0 +1,320 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This is a "meta-plugin" for IPv6-only networks. Chained after the plugin
+// that configures the container interface, it sets up the customer-side
+// translator (CLAT) of 464XLAT in the container: IPv4 traffic is routed to
+// a TUN interface, where TAYGA translates it to IPv6 towards the NAT64
+// (PLAT) of the network, so that IPv4-only applications keep working.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/containernetworking/cni/pkg/ip"
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/cni/pkg/utils/sysctl"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// clatIfName is the TUN interface IPv4 traffic is routed to
+	clatIfName = "clat"
+	// defaultNAT64Prefix is the well-known prefix of RFC 6052
+	defaultNAT64Prefix = "64:ff9b::/96"
+	// stateDir holds the TAYGA configuration and pid file of each
+	// attachment
+	stateDir = "/var/lib/cni/clat"
+)
+
+// The IPv4 addresses the container and TAYGA use on the TUN interface,
+// from the range RFC 7335 reserves for the purpose
+var (
+	containerIPv4  = net.IPv4(192, 0, 0, 1)
+	translatorIPv4 = net.IPv4(192, 0, 0, 2)
+)
+
+type NetConf struct {
+	types.NetConf
+	// PrevResult is the result of the plugin that configured the
+	// container interface, in any version
+	PrevResult *current.Result `json:"-"`
+	// NAT64Prefix is the prefix the NAT64 of the network maps IPv4
+	// addresses into
+	NAT64Prefix string `json:"nat64Prefix,omitempty"`
+	// ClatAddress is the IPv6 address the IPv4 traffic of the container
+	// is translated from; by default it is derived from the address of
+	// the container interface
+	ClatAddress net.IP `json:"clatAddress,omitempty"`
+	// Translator is the TAYGA binary, looked up in PATH by default
+	Translator string `json:"translator,omitempty"`
+	MTU        int    `json:"mtu,omitempty"`
+}
+
+func init() {
+	// this ensures that main runs only on main thread (thread group leader).
+	// since namespace ops (unshare, setns) are done for a single thread, we
+	// must ensure that the goroutine does not jump from OS thread to thread
+	runtime.LockOSThread()
+}
+
+func loadConf(bytes []byte) (*NetConf, *net.IPNet, error) {
+	n := &NetConf{}
+	if err := json.Unmarshal(bytes, n); err != nil {
+		return nil, nil, fmt.Errorf("failed to load netconf: %v", err)
+	}
+	prev, err := current.ParsePrevResult(bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	n.PrevResult = prev
+	if n.NAT64Prefix == "" {
+		n.NAT64Prefix = defaultNAT64Prefix
+	}
+	_, prefix, err := net.ParseCIDR(n.NAT64Prefix)
+	if err != nil || prefix.IP.To4() != nil {
+		return nil, nil, fmt.Errorf("invalid nat64Prefix %q: must be an IPv6 prefix", n.NAT64Prefix)
+	}
+	// the prefix lengths RFC 6052 defines
+	switch ones, _ := prefix.Mask.Size(); ones {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return nil, nil, fmt.Errorf("invalid nat64Prefix %q: must be a /32, /40, /48, /56, /64 or /96", n.NAT64Prefix)
+	}
+	if n.ClatAddress != nil && n.ClatAddress.To4() != nil {
+		return nil, nil, fmt.Errorf("invalid clatAddress %v: must be an IPv6 address", n.ClatAddress)
+	}
+	return n, prefix, nil
+}
+
+// clatAddress returns the CLAT address of the container interface
+// address addr: the address with the first bit of its interface
+// identifier flipped, which keeps it in the same /64 and away from the
+// addresses IPAM plugins hand out from the start of a range
+func clatAddress(addr net.IP) net.IP {
+	clat := make(net.IP, net.IPv6len)
+	copy(clat, addr.To16())
+	clat[8] ^= 0x80
+	return clat
+}
+
+// taygaConfig renders the configuration of TAYGA translating the IPv4
+// traffic of the container from clat to prefix
+func taygaConfig(prefix *net.IPNet, clat net.IP) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "tun-device %s\n", clatIfName)
+	fmt.Fprintf(&b, "ipv4-addr %s\n", translatorIPv4)
+	fmt.Fprintf(&b, "prefix %s\n", prefix)
+	fmt.Fprintf(&b, "map %s %s\n", containerIPv4, clat)
+	return b.Bytes()
+}
+
+// statePaths returns the TAYGA configuration and pid file of the
+// attachment
+func statePaths(args *skel.CmdArgs) (string, string) {
+	base := filepath.Join(stateDir, args.ContainerID+"-"+args.IfName)
+	return base + ".conf", base + ".pid"
+}
+
+// runTranslator runs TAYGA with args in netns, failing with what it wrote
+// to stderr
+func runTranslator(netns ns.NetNS, path string, args ...string) error {
+	_, err := ns.CommandOutput(netns, exec.Command(path, args...))
+	return err
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	n, prefix, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	result := n.PrevResult
+	if result == nil {
+		return errors.New("required prevResult missing")
+	}
+	var ip6 *current.IPConfig
+	for _, ipc := range result.IPs {
+		if ipc.Version == "4" {
+			return fmt.Errorf("container already has the IPv4 address %v: clat is for IPv6-only networks", ipc.Address.IP)
+		}
+		if ip6 == nil {
+			ip6 = ipc
+		}
+	}
+	if ip6 == nil {
+		return errors.New("prevResult has no IPv6 address to translate IPv4 traffic from")
+	}
+	ifName := args.IfName
+	if name := result.SandboxInterface(); name != "" {
+		ifName = name
+	}
+
+	clat := n.ClatAddress
+	if clat == nil {
+		clat = clatAddress(ip6.Address.IP)
+	}
+
+	translator := n.Translator
+	if translator == "" {
+		translator = "tayga"
+	}
+	if translator, err = exec.LookPath(translator); err != nil {
+		return fmt.Errorf("failed to locate the translator: %v", err)
+	}
+
+	confPath, pidPath := statePaths(args)
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(confPath, taygaConfig(prefix, clat), 0600); err != nil {
+		return fmt.Errorf("failed to write translator config: %v", err)
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(ns.NetNS) error {
+		uplink, err := netlink.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+		}
+
+		if err := runTranslator(netns, translator, "--config", confPath, "--mktun"); err != nil {
+			return err
+		}
+		tun, err := netlink.LinkByName(clatIfName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", clatIfName, err)
+		}
+
+		// translated packets grow by the difference of the headers
+		mtu := n.MTU
+		if mtu == 0 {
+			mtu = uplink.Attrs().MTU - 20
+		}
+		if err := netlink.LinkSetMTU(tun, mtu); err != nil {
+			return fmt.Errorf("failed to set the MTU of %q: %v", clatIfName, err)
+		}
+		if err := netlink.LinkSetUp(tun); err != nil {
+			return fmt.Errorf("failed to set %q up: %v", clatIfName, err)
+		}
+		addr := &netlink.Addr{IPNet: &net.IPNet{IP: containerIPv4, Mask: net.CIDRMask(32, 32)}}
+		if err := netlink.AddrAdd(tun, addr); err != nil {
+			return fmt.Errorf("failed to add %v to %q: %v", containerIPv4, clatIfName, err)
+		}
+
+		routes := []*netlink.Route{
+			{LinkIndex: tun.Attrs().Index, Dst: &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}, Scope: netlink.SCOPE_LINK},
+			{LinkIndex: tun.Attrs().Index, Dst: &net.IPNet{IP: clat, Mask: net.CIDRMask(128, 128)}},
+		}
+		for _, route := range routes {
+			if err := netlink.RouteAdd(route); err != nil {
+				return fmt.Errorf("failed to add route to %v via %q: %v", route.Dst, clatIfName, err)
+			}
+		}
+
+		// the container forwards the traffic of the CLAT address between
+		// the uplink and the TUN interface, and answers for it on the
+		// uplink; forwarding would otherwise turn off SLAAC
+		if err := ip.EnableIP6Forward(); err != nil {
+			return fmt.Errorf("failed to enable IPv6 forwarding: %v", err)
+		}
+		key := fmt.Sprintf("net/ipv6/conf/%s/accept_ra", ifName)
+		if _, err := sysctl.Sysctl(key, "2"); err != nil {
+			return fmt.Errorf("failed to set %s: %v", key, err)
+		}
+		if err := ip.SetupProxyNeigh(ifName, []net.IP{clat}); err != nil {
+			return err
+		}
+
+		// TAYGA detaches once it is running
+		return runTranslator(netns, translator, "--config", confPath, "--pidfile", pidPath)
+	})
+	if err != nil {
+		return err
+	}
+
+	return current.PrintResult(result, n.CNIVersion)
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	confPath, pidPath := statePaths(args)
+	if err := stopTranslator(confPath, pidPath); err != nil {
+		return err
+	}
+	for _, path := range []string{confPath, pidPath} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if args.Netns == "" {
+		return nil
+	}
+	return ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		if _, err := netlink.LinkByName(clatIfName); err != nil {
+			// already gone
+			return nil
+		}
+		return ip.DelLinkByName(clatIfName)
+	})
+}
+
+// stopTranslator terminates the TAYGA of the attachment, if it still
+// runs; the pid is only trusted if the process uses confPath, as it may
+// have been reused
+func stopTranslator(confPath, pidPath string) error {
+	data, err := ioutil.ReadFile(pidPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid pid file %s: %v", pidPath, err)
+	}
+
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil || !bytes.Contains(cmdline, []byte(confPath)) {
+		return nil
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("failed to stop the translator: %v", err)
+	}
+	return nil
+}
+
+func main() {
+	skel.PluginMain(cmdAdd, cmdDel)
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("clat", func() {
+	It("derives the CLAT address from the container address", func() {
+		Expect(clatAddress(net.ParseIP("2001:db8::2")).String()).To(Equal("2001:db8::8000:0:0:2"))
+		Expect(clatAddress(net.ParseIP("2001:db8::8000:0:0:2")).String()).To(Equal("2001:db8::2"))
+	})
+
+	It("renders the translator configuration", func() {
+		_, prefix, err := net.ParseCIDR("64:ff9b::/96")
+		Expect(err).NotTo(HaveOccurred())
+		conf := taygaConfig(prefix, net.ParseIP("2001:db8::8000:0:0:2"))
+		Expect(string(conf)).To(Equal(`tun-device clat
+ipv4-addr 192.0.0.2
+prefix 64:ff9b::/96
+map 192.0.0.1 2001:db8::8000:0:0:2
+`))
+	})
+
+	It("keeps the state of each attachment apart", func() {
+		confPath, pidPath := statePaths(&skel.CmdArgs{ContainerID: "dummy", IfName: "eth0"})
+		Expect(confPath).To(Equal("/var/lib/cni/clat/dummy-eth0.conf"))
+		Expect(pidPath).To(Equal("/var/lib/cni/clat/dummy-eth0.pid"))
+	})
+
+	Context("loading the configuration", func() {
+		It("defaults to the well-known prefix", func() {
+			n, prefix, err := loadConf([]byte(`{"name": "mynet", "type": "clat"}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.NAT64Prefix).To(Equal("64:ff9b::/96"))
+			Expect(prefix.String()).To(Equal("64:ff9b::/96"))
+		})
+
+		It("accepts a network-specific prefix", func() {
+			_, prefix, err := loadConf([]byte(`{"name": "mynet", "type": "clat", "nat64Prefix": "2001:db8:64::/64"}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(prefix.String()).To(Equal("2001:db8:64::/64"))
+		})
+
+		It("rejects prefixes of other lengths", func() {
+			_, _, err := loadConf([]byte(`{"name": "mynet", "type": "clat", "nat64Prefix": "64:ff9b::/80"}`))
+			Expect(err).To(MatchError(`invalid nat64Prefix "64:ff9b::/80": must be a /32, /40, /48, /56, /64 or /96`))
+		})
+
+		It("rejects IPv4 prefixes and CLAT addresses", func() {
+			_, _, err := loadConf([]byte(`{"name": "mynet", "type": "clat", "nat64Prefix": "10.0.0.0/8"}`))
+			Expect(err).To(MatchError(`invalid nat64Prefix "10.0.0.0/8": must be an IPv6 prefix`))
+
+			_, _, err = loadConf([]byte(`{"name": "mynet", "type": "clat", "clatAddress": "10.0.0.1"}`))
+			Expect(err).To(MatchError("invalid clatAddress 10.0.0.1: must be an IPv6 address"))
+		})
+	})
+
+	Context("adding", func() {
+		add := func(conf string) error {
+			return cmdAdd(&skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       "/var/run/netns/dummy",
+				IfName:      "eth0",
+				StdinData:   []byte(conf),
+			})
+		}
+
+		It("requires a previous result", func() {
+			Expect(add(`{"name": "mynet", "type": "clat"}`)).To(MatchError("required prevResult missing"))
+		})
+
+		It("refuses containers that already have IPv4", func() {
+			err := add(`{"name": "mynet", "type": "clat", "prevResult": {"ip4": {"ip": "10.1.2.3/24"}}}`)
+			Expect(err).To(MatchError("container already has the IPv4 address 10.1.2.3: clat is for IPv6-only networks"))
+		})
+
+		It("requires an IPv6 address", func() {
+			err := add(`{"name": "mynet", "type": "clat", "prevResult": {}}`)
+			Expect(err).To(MatchError("prevResult has no IPv6 address to translate IPv4 traffic from"))
+		})
+	})
+})
@@ -21,6 +21,7 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -28,10 +29,12 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/containernetworking/cni/pkg/invoke"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
 )
 
 const (
@@ -129,29 +132,61 @@ func loadFlannelSubnetEnv(fn string) (*subnetEnv, error) {
 	return se, nil
 }
 
-func saveScratchNetConf(containerID string, netconf []byte) error {
+// scratchState is what is kept for a container between ADD and DEL.
+// Older versions kept the delegate netconf alone, without the envelope.
+type scratchState struct {
+	IfName  string          `json:"ifName"`
+	Netconf json.RawMessage `json:"netconf"`
+}
+
+func saveScratchNetConf(containerID, ifName string, netconf []byte) error {
 	if err := os.MkdirAll(stateDir, 0700); err != nil {
 		return err
 	}
+	data, err := json.Marshal(&scratchState{IfName: ifName, Netconf: netconf})
+	if err != nil {
+		return err
+	}
 	path := filepath.Join(stateDir, containerID)
-	return ioutil.WriteFile(path, netconf, 0600)
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// loadScratchNetConf returns the interface name, if it was recorded, and
+// the delegate netconf kept at path
+func loadScratchNetConf(path string) (string, []byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	state := scratchState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return "", nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if state.Netconf == nil {
+		return "", data, nil
+	}
+	return state.IfName, state.Netconf, nil
 }
 
 func consumeScratchNetConf(containerID string) ([]byte, error) {
 	path := filepath.Join(stateDir, containerID)
 	defer os.Remove(path)
 
-	return ioutil.ReadFile(path)
+	_, netconf, err := loadScratchNetConf(path)
+	return netconf, err
 }
 
-func delegateAdd(cid string, netconf map[string]interface{}) error {
+// delegateAdd runs the ADD of the delegate of netconf, and prints its
+// result in the format of cniVersion
+func delegateAdd(cid, ifName, cniVersion string, netconf map[string]interface{}) error {
 	netconfBytes, err := json.Marshal(netconf)
 	if err != nil {
 		return fmt.Errorf("error serializing delegate netconf: %v", err)
 	}
 
 	// save the rendered netconf for cmdDel
-	if err = saveScratchNetConf(cid, netconfBytes); err != nil {
+	if err = saveScratchNetConf(cid, ifName, netconfBytes); err != nil {
 		return err
 	}
 
@@ -160,7 +195,7 @@ func delegateAdd(cid string, netconf map[string]interface{}) error {
 		return err
 	}
 
-	return result.Print()
+	return current.PrintResult(current.NewResultFromLegacy(result), cniVersion)
 }
 
 func hasKey(m map[string]interface{}, k string) bool {
@@ -199,6 +234,9 @@ func cmdAdd(args *skel.CmdArgs) error {
 	}
 
 	n.Delegate["name"] = n.Name
+	if !hasKey(n.Delegate, "cniVersion") && n.CNIVersion != "" {
+		n.Delegate["cniVersion"] = n.CNIVersion
+	}
 
 	if !hasKey(n.Delegate, "type") {
 		n.Delegate["type"] = "bridge"
@@ -231,7 +269,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 		},
 	}
 
-	return delegateAdd(args.ContainerID, n.Delegate)
+	return delegateAdd(args.ContainerID, args.IfName, n.CNIVersion, n.Delegate)
 }
 
 func cmdDel(args *skel.CmdArgs) error {
@@ -248,6 +286,125 @@ func cmdDel(args *skel.CmdArgs) error {
 	return invoke.DelegateDel(n.Type, netconfBytes)
 }
 
+func cmdCheck(args *skel.CmdArgs) error {
+	return checkContainer(stateDir, args, nil)
+}
+
+// checkContainer runs the CHECK of the delegate of the container with
+// state in dir, with the netconf it was added with and the prevResult
+// being checked. It fails without running the delegate if flanneld has
+// moved the node to another subnet since, which the container cannot be
+// in anymore.
+func checkContainer(dir string, args *skel.CmdArgs, opts *invoke.DelegateOptions) error {
+	n, err := loadFlannelNetConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if n.PrevResult == nil {
+		return errors.New("required prevResult missing")
+	}
+
+	path := filepath.Join(dir, args.ContainerID)
+	_, netconf, err := loadScratchNetConf(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("container %q was not added by flannel: %s does not exist", args.ContainerID, path)
+	}
+	if err != nil {
+		return err
+	}
+	delegate := map[string]interface{}{}
+	if err := json.Unmarshal(netconf, &delegate); err != nil {
+		return fmt.Errorf("failed to parse netconf: %v", err)
+	}
+
+	if fenv, err := loadFlannelSubnetEnv(n.SubnetFile); err == nil {
+		added := ""
+		if ipam, ok := delegate["ipam"].(map[string]interface{}); ok {
+			added, _ = ipam["subnet"].(string)
+		}
+		if added != fenv.sn.String() {
+			return fmt.Errorf("the flannel subnet is now %v, the container was added in %s", fenv.sn, added)
+		}
+	}
+
+	stdin := map[string]json.RawMessage{}
+	if err := json.Unmarshal(args.StdinData, &stdin); err != nil {
+		return fmt.Errorf("failed to load netconf: %v", err)
+	}
+	delegate["prevResult"] = stdin["prevResult"]
+	if v, ok := stdin["cniVersion"]; ok {
+		delegate["cniVersion"] = v
+	}
+	netconf, err = json.Marshal(delegate)
+	if err != nil {
+		return fmt.Errorf("error serializing delegate netconf: %v", err)
+	}
+
+	delegateType, _ := delegate["type"].(string)
+	return invoke.DelegateCheckWithOptions(delegateType, netconf, opts)
+}
+
+func cmdStatus(args *skel.CmdArgs) error {
+	n, err := loadFlannelNetConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	return subnetFileStatus(n.SubnetFile)
+}
+
+// subnetFileStatus fails with an ErrNotReady error unless flanneld has
+// written a complete subnet file at path since the host booted; a file
+// left over from before, e.g. outside of /run, describes a lease flanneld
+// may not hold anymore
+func subnetFileStatus(path string) error {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return types.NewError(types.ErrNotReady, fmt.Sprintf("%s does not exist, flanneld has not started", path), "")
+	}
+	if err != nil {
+		return types.NewError(types.ErrNotReady, err.Error(), "")
+	}
+	if _, err := loadFlannelSubnetEnv(path); err != nil {
+		return types.NewError(types.ErrNotReady, err.Error(), "")
+	}
+
+	booted, err := bootTime()
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Before(booted) {
+		return types.NewError(types.ErrNotReady, fmt.Sprintf("%s was written before the host booted, flanneld has not started since", path), "")
+	}
+	return nil
+}
+
+// bootTime returns when the host booted, from the btime line of /proc/stat
+func bootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 2 && fields[0] == "btime" {
+			secs, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid btime in /proc/stat: %v", err)
+			}
+			return time.Unix(secs, 0), nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, errors.New("no btime in /proc/stat")
+}
+
 func main() {
-	skel.PluginMain(cmdAdd, cmdDel)
+	skel.PluginMainWithSubcommands(skel.PluginFuncs{Add: cmdAdd, Check: cmdCheck, Del: cmdDel, Status: cmdStatus}, map[string]skel.Subcommand{
+		"gc": &gcCmd{},
+	})
 }
@@ -0,0 +1,145 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const subnetEnvFile = `FLANNEL_NETWORK=10.1.0.0/16
+FLANNEL_SUBNET=10.1.17.1/24
+FLANNEL_MTU=1472
+FLANNEL_IPMASQ=true
+`
+
+var _ = Describe("flannel", func() {
+	var (
+		dir        string
+		subnetFile string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "flannel")
+		Expect(err).NotTo(HaveOccurred())
+		subnetFile = filepath.Join(dir, "subnet.env")
+		Expect(ioutil.WriteFile(subnetFile, []byte(subnetEnvFile), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	Context("on CHECK", func() {
+		const prevResult = `{"ip4":{"ip":"10.1.17.2/24"}}`
+		var (
+			exec *fakeExec
+			opts *invoke.DelegateOptions
+			args *skel.CmdArgs
+		)
+
+		writeState := func(subnet string) {
+			netconf := fmt.Sprintf(`{"name":"flannel-net","type":"bridge","ipam":{"type":"host-local","subnet":%q}}`, subnet)
+			state := `{"ifName":"eth0","netconf":` + netconf + `}`
+			Expect(ioutil.WriteFile(filepath.Join(dir, "ctr"), []byte(state), 0600)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			exec = &fakeExec{}
+			opts = &invoke.DelegateOptions{Exec: exec, Env: map[string]string{"CNI_COMMAND": "CHECK"}}
+			args = &skel.CmdArgs{
+				ContainerID: "ctr",
+				IfName:      "eth0",
+				StdinData:   []byte(fmt.Sprintf(`{"cniVersion":"0.3.0","name":"flannel-net","type":"flannel","subnetFile":%q,"prevResult":%s}`, subnetFile, prevResult)),
+			}
+		})
+
+		It("checks the delegate with the netconf it was added with", func() {
+			writeState("10.1.17.0/24")
+
+			Expect(checkContainer(dir, args, opts)).To(Succeed())
+			Expect(exec.runs).To(HaveLen(1))
+			Expect(exec.runs[0]).To(HaveKeyWithValue("CNI_COMMAND", "CHECK"))
+
+			delegate := map[string]interface{}{}
+			Expect(json.Unmarshal([]byte(exec.runs[0]["stdin"]), &delegate)).To(Succeed())
+			Expect(delegate).To(HaveKeyWithValue("type", "bridge"))
+			Expect(delegate).To(HaveKeyWithValue("cniVersion", "0.3.0"))
+			Expect(delegate).To(HaveKeyWithValue("prevResult", map[string]interface{}{
+				"ip4": map[string]interface{}{"ip": "10.1.17.2/24"},
+			}))
+		})
+
+		It("reports a container added in a subnet flannel does not hold anymore", func() {
+			writeState("10.1.42.0/24")
+
+			err := checkContainer(dir, args, opts)
+			Expect(err).To(MatchError("the flannel subnet is now 10.1.17.0/24, the container was added in 10.1.42.0/24"))
+			Expect(exec.runs).To(BeEmpty())
+		})
+
+		It("fails for a container it did not add", func() {
+			err := checkContainer(dir, args, opts)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix(`container "ctr" was not added by flannel`))
+		})
+
+		It("requires a prevResult", func() {
+			writeState("10.1.17.0/24")
+			args.StdinData = []byte(fmt.Sprintf(`{"name":"flannel-net","type":"flannel","subnetFile":%q}`, subnetFile))
+
+			Expect(checkContainer(dir, args, opts)).To(MatchError("required prevResult missing"))
+		})
+	})
+
+	Context("on STATUS", func() {
+		expectNotReady := func(err error) {
+			Expect(err).To(BeAssignableToTypeOf(&types.Error{}))
+			Expect(err.(*types.Error).Code).To(Equal(types.ErrNotReady))
+		}
+
+		It("is ready once flanneld has written the subnet file", func() {
+			Expect(subnetFileStatus(subnetFile)).To(Succeed())
+		})
+
+		It("is not ready without a subnet file", func() {
+			Expect(os.Remove(subnetFile)).To(Succeed())
+			expectNotReady(subnetFileStatus(subnetFile))
+		})
+
+		It("is not ready with an incomplete subnet file", func() {
+			Expect(ioutil.WriteFile(subnetFile, []byte("FLANNEL_NETWORK=10.1.0.0/16\n"), 0644)).To(Succeed())
+			expectNotReady(subnetFileStatus(subnetFile))
+		})
+
+		It("is not ready with a subnet file from before the host booted", func() {
+			before := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+			Expect(os.Chtimes(subnetFile, before, before)).To(Succeed())
+			expectNotReady(subnetFileStatus(subnetFile))
+		})
+	})
+})
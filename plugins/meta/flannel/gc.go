@@ -0,0 +1,177 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// gcGracePeriod protects containers being added while gc runs, which the
+// caller may not list as live yet
+const gcGracePeriod = time.Minute
+
+// defaultIfName is assumed for containers whose state was saved before
+// the interface name was recorded
+const defaultIfName = "eth0"
+
+// gcReport lists the containers whose state gc removed, and those it
+// could not tear down with the error of their delegate
+type gcReport struct {
+	Removed []string          `json:"removed"`
+	Failed  map[string]string `json:"failed,omitempty"`
+}
+
+// gc tears down every container with state in dir that is not in live,
+// unless the state was saved within gcGracePeriod: it runs the DEL of the
+// delegate, without a network namespace since the container is gone, and
+// removes the state once that succeeded. With dryRun it only reports what
+// it would remove.
+func gc(dir string, live map[string]bool, opts *invoke.DelegateOptions, dryRun bool) (*gcReport, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return &gcReport{Removed: []string{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report := &gcReport{Removed: []string{}}
+	for _, f := range files {
+		id := f.Name()
+		if f.IsDir() || live[id] || time.Since(f.ModTime()) < gcGracePeriod {
+			continue
+		}
+		if dryRun {
+			report.Removed = append(report.Removed, id)
+			continue
+		}
+
+		if err := gcContainer(dir, id, opts); err != nil {
+			if report.Failed == nil {
+				report.Failed = map[string]string{}
+			}
+			report.Failed[id] = err.Error()
+			continue
+		}
+		report.Removed = append(report.Removed, id)
+	}
+	sort.Strings(report.Removed)
+	return report, nil
+}
+
+func gcContainer(dir, id string, opts *invoke.DelegateOptions) error {
+	path := filepath.Join(dir, id)
+	ifName, netconf, err := loadScratchNetConf(path)
+	if err != nil {
+		return err
+	}
+	if ifName == "" {
+		ifName = defaultIfName
+	}
+
+	n := &types.NetConf{}
+	if err := json.Unmarshal(netconf, n); err != nil {
+		return fmt.Errorf("failed to parse netconf: %v", err)
+	}
+
+	delOpts := &invoke.DelegateOptions{
+		Path: opts.Path,
+		Exec: opts.Exec,
+		Env: map[string]string{
+			"CNI_COMMAND":     "DEL",
+			"CNI_CONTAINERID": id,
+			"CNI_IFNAME":      ifName,
+			"CNI_NETNS":       "",
+		},
+	}
+	if err := invoke.DelegateDelWithOptions(n.Type, netconf, delOpts); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// gcCmd implements "flannel gc [-dry-run] ID...": every container with
+// state that is not among the live IDs is torn down. An ID of "-" reads
+// more IDs from stdin, one per line. It prints the report as JSON and
+// fails if any teardown failed.
+type gcCmd struct {
+	dryRun bool
+}
+
+func (*gcCmd) Usage() string {
+	return "tear down the containers that are not among the live IDs"
+}
+
+func (c *gcCmd) SetFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&c.dryRun, "dry-run", false, "only report the containers that would be torn down")
+}
+
+func (c *gcCmd) Run(ctx *skel.SubcommandContext) error {
+	live, err := liveIDs(ctx.Args, ctx.Stdin)
+	if err != nil {
+		return err
+	}
+
+	report, err := gc(stateDir, live, &invoke.DelegateOptions{}, c.dryRun)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(report, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(ctx.Stdout, "%s\n", out)
+
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("%d containers could not be torn down", len(report.Failed))
+	}
+	return nil
+}
+
+func liveIDs(args []string, stdin io.Reader) (map[string]bool, error) {
+	live := map[string]bool{}
+	for _, id := range args {
+		if id != "-" {
+			live[id] = true
+			continue
+		}
+
+		s := bufio.NewScanner(stdin)
+		for s.Scan() {
+			if id := strings.TrimSpace(s.Text()); id != "" {
+				live[id] = true
+			}
+		}
+		if err := s.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read live container IDs: %v", err)
+		}
+	}
+	return live, nil
+}
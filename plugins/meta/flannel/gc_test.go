@@ -0,0 +1,148 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/types"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeExec records the environment and stdin of each delegate it runs
+type fakeExec struct {
+	runs []map[string]string
+	err  error
+}
+
+func (e *fakeExec) ExecPlugin(pluginPath string, stdinData []byte, environ []string) ([]byte, error) {
+	env := map[string]string{}
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if strings.HasPrefix(parts[0], "CNI_") {
+			env[parts[0]] = parts[1]
+		}
+	}
+	env["stdin"] = string(stdinData)
+	e.runs = append(e.runs, env)
+	return nil, e.err
+}
+
+func (e *fakeExec) FindInPath(plugin string, paths []string) (string, error) {
+	return plugin, nil
+}
+
+func (e *fakeExec) Decode(jsonBytes []byte) (*types.Result, error) {
+	return nil, errors.New("not implemented")
+}
+
+var _ = Describe("flannel state garbage collection", func() {
+	const netconf = `{"name":"flannel-net","type":"bridge"}`
+	var (
+		dir  string
+		exec *fakeExec
+		opts *invoke.DelegateOptions
+	)
+
+	// writeState saves state for id as it was saved age ago
+	writeState := func(id, state string, age time.Duration) {
+		path := filepath.Join(dir, id)
+		Expect(ioutil.WriteFile(path, []byte(state), 0600)).To(Succeed())
+		then := time.Now().Add(-age)
+		Expect(os.Chtimes(path, then, then)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "flannel-gc")
+		Expect(err).NotTo(HaveOccurred())
+		exec = &fakeExec{}
+		opts = &invoke.DelegateOptions{Exec: exec}
+
+		writeState("live", `{"ifName":"eth0","netconf":`+netconf+`}`, time.Hour)
+		writeState("stale", `{"ifName":"eth1","netconf":`+netconf+`}`, time.Hour)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("tears down and forgets the containers that are not live", func() {
+		report, err := gc(dir, map[string]bool{"live": true}, opts, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report).To(Equal(&gcReport{Removed: []string{"stale"}}))
+
+		Expect(exec.runs).To(Equal([]map[string]string{{
+			"CNI_COMMAND":     "DEL",
+			"CNI_CONTAINERID": "stale",
+			"CNI_IFNAME":      "eth1",
+			"CNI_NETNS":       "",
+			"stdin":           netconf,
+		}}))
+		Expect(filepath.Join(dir, "stale")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(dir, "live")).To(BeAnExistingFile())
+	})
+
+	It("tears down state saved before the interface name was recorded", func() {
+		writeState("old", netconf, time.Hour)
+
+		_, err := gc(dir, map[string]bool{"live": true, "stale": true}, opts, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(exec.runs).To(HaveLen(1))
+		Expect(exec.runs[0]).To(HaveKeyWithValue("CNI_IFNAME", defaultIfName))
+		Expect(exec.runs[0]).To(HaveKeyWithValue("stdin", netconf))
+	})
+
+	It("leaves containers that are being added alone", func() {
+		writeState("new", `{"ifName":"eth0","netconf":`+netconf+`}`, 0)
+
+		report, err := gc(dir, map[string]bool{"live": true}, opts, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Removed).To(Equal([]string{"stale"}))
+		Expect(filepath.Join(dir, "new")).To(BeAnExistingFile())
+	})
+
+	It("keeps the state of a container whose teardown failed", func() {
+		exec.err = errors.New("bridge is gone")
+
+		report, err := gc(dir, map[string]bool{"live": true}, opts, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Removed).To(BeEmpty())
+		Expect(report.Failed).To(Equal(map[string]string{"stale": "bridge is gone"}))
+		Expect(filepath.Join(dir, "stale")).To(BeAnExistingFile())
+	})
+
+	It("only reports in a dry run", func() {
+		report, err := gc(dir, map[string]bool{}, opts, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Removed).To(Equal([]string{"live", "stale"}))
+		Expect(exec.runs).To(BeEmpty())
+		Expect(filepath.Join(dir, "stale")).To(BeAnExistingFile())
+	})
+
+	It("reads live container IDs from stdin", func() {
+		live, err := liveIDs([]string{"a", "-"}, strings.NewReader("b\n\n c \n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(live).To(Equal(map[string]bool{"a": true, "b": true, "c": true}))
+	})
+})
@@ -0,0 +1,253 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This is a "meta-plugin" for containers publishing host ports through
+// the portmap plugin. Chained after the plugin attaching the container to
+// a bridge, it sets up the host so that the published ports can also be
+// reached from the host itself on 127.0.0.1 and from containers on the
+// same bridge ("hairpin" NAT).
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/ip"
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/cni/pkg/utils/sysctl"
+	"github.com/vishvananda/netlink"
+)
+
+const defaultBrName = "cni0"
+
+type NetConf struct {
+	types.NetConf
+	// PrevResult is the result of the plugin attaching the container to
+	// the bridge, in any version
+	PrevResult *current.Result `json:"-"`
+	// BrName is the bridge the container is attached to
+	BrName string `json:"bridge"`
+	// RouteLocalnet lets host ports be reached on 127.0.0.1; it is on
+	// unless set to false
+	RouteLocalnet *bool `json:"routeLocalnet,omitempty"`
+}
+
+func init() {
+	// this ensures that main runs only on main thread (thread group leader).
+	// since namespace ops (unshare, setns) are done for a single thread, we
+	// must ensure that the goroutine does not jump from OS thread to thread
+	runtime.LockOSThread()
+}
+
+func loadConf(bytes []byte) (*NetConf, error) {
+	n := &NetConf{BrName: defaultBrName}
+	if err := json.Unmarshal(bytes, n); err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %v", err)
+	}
+	prev, err := current.ParsePrevResult(bytes)
+	if err != nil {
+		return nil, err
+	}
+	n.PrevResult = prev
+	if n.PrevResult == nil {
+		return nil, errors.New("required prevResult missing")
+	}
+	return n, nil
+}
+
+// setting is a sysctl of the host hairpin NAT depends on
+type setting struct {
+	key   string
+	value string
+	// why tells what breaks without the setting
+	why string
+}
+
+// settings returns the sysctls hairpin NAT needs for the container with
+// the result of n
+func settings(n *NetConf) []setting {
+	families := map[string]bool{}
+	for _, ipc := range n.PrevResult.IPs {
+		families[ipc.Version] = true
+	}
+
+	var s []setting
+	if n.RouteLocalnet == nil || *n.RouteLocalnet {
+		s = append(s, setting{
+			key:   fmt.Sprintf("net/ipv4/conf/%s/route_localnet", n.BrName),
+			value: "1",
+			why:   "host ports cannot be reached on 127.0.0.1",
+		})
+	}
+	if families["4"] {
+		s = append(s, setting{
+			key:   "net/bridge/bridge-nf-call-iptables",
+			value: "1",
+			why:   "IPv4 traffic between containers of the bridge bypasses the NAT of host ports",
+		})
+	}
+	if families["6"] {
+		s = append(s, setting{
+			key:   "net/bridge/bridge-nf-call-ip6tables",
+			value: "1",
+			why:   "IPv6 traffic between containers of the bridge bypasses the NAT of host ports",
+		})
+	}
+	return s
+}
+
+// requireBrNetfilter fails unless the net/bridge sysctls exist, which
+// they only do once the br_netfilter module is loaded
+func requireBrNetfilter() error {
+	if _, err := os.Stat("/proc/sys/net/bridge"); err != nil {
+		return fmt.Errorf("bridge netfilter is not available, load the br_netfilter module: %v", err)
+	}
+	return nil
+}
+
+// hostVeth returns the host end of the veth of the container, which must
+// be a port of br
+func hostVeth(netns ns.NetNS, ifName string, br netlink.Link) (netlink.Link, error) {
+	var hostVethIndex int
+	err := netns.Do(func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+		}
+		hostVethIndex, err = ip.VethPeerIndex(link)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := netlink.LinkByIndex(hostVethIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup the host end of %q: %v", ifName, err)
+	}
+	if link.Attrs().MasterIndex != br.Attrs().Index {
+		return nil, fmt.Errorf("host end %q of %q is not a port of bridge %q", link.Attrs().Name, ifName, br.Attrs().Name)
+	}
+	return link, nil
+}
+
+// bridgePort returns the port of the bridge of n the container is
+// attached to, the host end of its veth
+func bridgePort(n *NetConf, args *skel.CmdArgs) (netlink.Link, error) {
+	br, err := netlink.LinkByName(n.BrName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup bridge %q: %v", n.BrName, err)
+	}
+	if _, ok := br.(*netlink.Bridge); !ok {
+		return nil, fmt.Errorf("%q is not a bridge", n.BrName)
+	}
+
+	ifName := args.IfName
+	if name := n.PrevResult.SandboxInterface(); name != "" {
+		ifName = name
+	}
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+	}
+	defer netns.Close()
+
+	return hostVeth(netns, ifName, br)
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	veth, err := bridgePort(n, args)
+	if err != nil {
+		return err
+	}
+	if err := requireBrNetfilter(); err != nil {
+		return err
+	}
+
+	for _, s := range settings(n) {
+		if _, err := sysctl.Sysctl(s.key, s.value); err != nil {
+			return err
+		}
+	}
+
+	// traffic a container sends to its own published port comes back
+	// through the port it left from
+	if err := netlink.LinkSetHairpin(veth, true); err != nil {
+		return fmt.Errorf("failed to set hairpin mode on %q: %v", veth.Attrs().Name, err)
+	}
+
+	return current.PrintResult(n.PrevResult, n.CNIVersion)
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	veth, err := bridgePort(n, args)
+	if err != nil {
+		return err
+	}
+	if err := requireBrNetfilter(); err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, s := range settings(n) {
+		value, err := sysctl.Sysctl(s.key)
+		if err != nil {
+			return err
+		}
+		if value != s.value {
+			missing = append(missing, fmt.Sprintf("%s is %s, so %s", strings.Replace(s.key, "/", ".", -1), value, s.why))
+		}
+	}
+
+	protinfo, err := netlink.LinkGetProtinfo(veth)
+	if err != nil {
+		return fmt.Errorf("failed to get the port settings of %q: %v", veth.Attrs().Name, err)
+	}
+	if !protinfo.Hairpin {
+		missing = append(missing, fmt.Sprintf("hairpin mode of port %q is off, so containers cannot reach their own host ports", veth.Attrs().Name))
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("hairpin NAT is not set up: %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	// The sysctls are shared by every container of the bridge and are
+	// left in place, and the hairpin mode goes away with the veth.
+	return nil
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.PluginFuncs{Add: cmdAdd, Check: cmdCheck, Del: cmdDel})
+}
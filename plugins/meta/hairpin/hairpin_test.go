@@ -0,0 +1,175 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/testutils"
+	"github.com/containernetworking/cni/pkg/utils/sysctl"
+
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("hairpin", func() {
+	const conf = `{
+		"name": "mynet",
+		"type": "hairpin",
+		"bridge": "cni0",
+		"prevResult": {"ip4": {"ip": "10.1.2.3/24"}}
+	}`
+
+	var (
+		originalNS, targetNS ns.NetNS
+		hostVethName         string
+	)
+
+	BeforeEach(func() {
+		var err error
+		originalNS, err = ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		targetNS, err = ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		// attach the container to a bridge, as the bridge plugin would
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "cni0"}}
+			Expect(netlink.LinkAdd(br)).To(Succeed())
+			veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth0"}, PeerName: "eth0"}
+			Expect(netlink.LinkAdd(veth)).To(Succeed())
+			Expect(netlink.LinkSetMaster(veth, br)).To(Succeed())
+			hostVethName = veth.Name
+
+			peer, err := netlink.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetNsFd(peer, int(targetNS.Fd()))).To(Succeed())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(targetNS.Close()).To(Succeed())
+		Expect(originalNS.Close()).To(Succeed())
+	})
+
+	args := func(stdin string) *skel.CmdArgs {
+		return &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNS.Path(),
+			IfName:      "eth0",
+			StdinData:   []byte(stdin),
+		}
+	}
+
+	add := func(stdin string) error {
+		return originalNS.Do(func(ns.NetNS) error {
+			_, err := testutils.CmdAddWithResult(targetNS.Path(), "eth0", func() error {
+				return cmdAdd(args(stdin))
+			})
+			return err
+		})
+	}
+
+	check := func(stdin string) error {
+		return originalNS.Do(func(ns.NetNS) error {
+			return testutils.CmdCheckWithResult(targetNS.Path(), "eth0", func() error {
+				return cmdCheck(args(stdin))
+			})
+		})
+	}
+
+	It("sets up the host and the port of the container for hairpin NAT", func() {
+		Expect(add(conf)).To(Succeed())
+
+		err := originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			Expect(sysctl.Sysctl("net/ipv4/conf/cni0/route_localnet")).To(Equal("1"))
+			Expect(sysctl.Sysctl("net/bridge/bridge-nf-call-iptables")).To(Equal("1"))
+
+			veth, err := netlink.LinkByName(hostVethName)
+			Expect(err).NotTo(HaveOccurred())
+			protinfo, err := netlink.LinkGetProtinfo(veth)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(protinfo.Hairpin).To(BeTrue())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(check(conf)).To(Succeed())
+	})
+
+	It("leaves route_localnet alone when disabled", func() {
+		Expect(add(`{
+			"name": "mynet",
+			"type": "hairpin",
+			"routeLocalnet": false,
+			"prevResult": {"ip4": {"ip": "10.1.2.3/24"}}
+		}`)).To(Succeed())
+
+		err := originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			Expect(sysctl.Sysctl("net/ipv4/conf/cni0/route_localnet")).To(Equal("0"))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("reports what is no longer set up on CHECK", func() {
+		Expect(add(conf)).To(Succeed())
+
+		err := originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			_, err := sysctl.Sysctl("net/ipv4/conf/cni0/route_localnet", "0")
+			Expect(err).NotTo(HaveOccurred())
+			veth, err := netlink.LinkByName(hostVethName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetHairpin(veth, false)).To(Succeed())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(check(conf)).To(MatchError(fmt.Sprintf("hairpin NAT is not set up: net.ipv4.conf.cni0.route_localnet is 0, so host ports cannot be reached on 127.0.0.1; hairpin mode of port %q is off, so containers cannot reach their own host ports", hostVethName)))
+	})
+
+	It("requires the container to be a port of the bridge", func() {
+		err := originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			veth, err := netlink.LinkByName(hostVethName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetMasterByIndex(veth, 0)).To(Succeed())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(add(conf)).To(MatchError(fmt.Sprintf(`host end %q of "eth0" is not a port of bridge "cni0"`, hostVethName)))
+	})
+
+	It("requires a previous result", func() {
+		_, err := loadConf([]byte(`{"name": "mynet", "type": "hairpin"}`))
+		Expect(err).To(MatchError("required prevResult missing"))
+	})
+})
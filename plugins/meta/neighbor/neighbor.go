@@ -0,0 +1,293 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This is a "meta-plugin" pinning neighbor entries. Chained after the
+// plugin creating the container interface, it adds static ARP and NDP
+// entries to it, and optionally ones for the container addresses to the
+// host end of its veth, for networks where address resolution does not
+// work or must not be trusted, e.g. EVPN fabrics suppressing ARP.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/containernetworking/cni/pkg/ip"
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/vishvananda/netlink"
+)
+
+type NetConf struct {
+	types.NetConf
+	// PrevResult is the result of the plugin creating the container
+	// interface, in any version
+	PrevResult *current.Result `json:"-"`
+	// Neighbors are added to the container interface
+	Neighbors []Neighbor `json:"neighbors,omitempty"`
+	// GatewayMac, if set, is the MAC the gateways of the result are
+	// pinned to in the container
+	GatewayMac string `json:"gatewayMac,omitempty"`
+	// HostVeth pins the addresses of the container, on the host end of
+	// its veth, to the MAC of the container interface
+	HostVeth bool `json:"hostVeth,omitempty"`
+}
+
+// Neighbor is a static neighbor entry
+type Neighbor struct {
+	IP  string `json:"ip"`
+	Mac string `json:"mac"`
+}
+
+// entry is a neighbor entry to add to a link
+type entry struct {
+	ip  net.IP
+	mac net.HardwareAddr
+}
+
+func init() {
+	// this ensures that main runs only on main thread (thread group leader).
+	// since namespace ops (unshare, setns) are done for a single thread, we
+	// must ensure that the goroutine does not jump from OS thread to thread
+	runtime.LockOSThread()
+}
+
+func loadConf(bytes []byte) (*NetConf, error) {
+	n := &NetConf{}
+	if err := json.Unmarshal(bytes, n); err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %v", err)
+	}
+	prev, err := current.ParsePrevResult(bytes)
+	if err != nil {
+		return nil, err
+	}
+	n.PrevResult = prev
+	if n.PrevResult == nil {
+		return nil, errors.New("required prevResult missing")
+	}
+	return n, nil
+}
+
+// containerEntries returns the entries of the container interface: the
+// configured neighbors, then the gateways of the result if gatewayMac
+// is set
+func containerEntries(n *NetConf) ([]entry, error) {
+	var entries []entry
+	for i, neigh := range n.Neighbors {
+		ip := net.ParseIP(neigh.IP)
+		if ip == nil {
+			return nil, fmt.Errorf("neighbor %d: invalid ip %q", i, neigh.IP)
+		}
+		mac, err := net.ParseMAC(neigh.Mac)
+		if err != nil {
+			return nil, fmt.Errorf("neighbor %d: invalid mac %q", i, neigh.Mac)
+		}
+		entries = append(entries, entry{ip: ip, mac: mac})
+	}
+
+	if n.GatewayMac == "" {
+		return entries, nil
+	}
+	mac, err := net.ParseMAC(n.GatewayMac)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gatewayMac %q", n.GatewayMac)
+	}
+	for _, ipc := range n.PrevResult.IPs {
+		if ipc.Gateway != nil {
+			entries = append(entries, entry{ip: ipc.Gateway, mac: mac})
+		}
+	}
+	if len(entries) == len(n.Neighbors) {
+		return nil, errors.New("gatewayMac is set but the result has no gateway")
+	}
+	return entries, nil
+}
+
+// hostEntries returns the entries pinning the addresses of the result to
+// the MAC of the container interface
+func hostEntries(n *NetConf, mac net.HardwareAddr) []entry {
+	var entries []entry
+	for _, ipc := range n.PrevResult.IPs {
+		entries = append(entries, entry{ip: ipc.Address.IP, mac: mac})
+	}
+	return entries
+}
+
+func family(ip net.IP) int {
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
+
+// setEntries adds or replaces the entries on link as permanent ones. An
+// existing entry is deleted first, since NeighSet does not replace the
+// lladdr of one.
+func setEntries(link netlink.Link, entries []entry) error {
+	for _, e := range entries {
+		neigh := &netlink.Neigh{
+			LinkIndex:    link.Attrs().Index,
+			Family:       family(e.ip),
+			State:        netlink.NUD_PERMANENT,
+			IP:           e.ip,
+			HardwareAddr: e.mac,
+		}
+		if err := netlink.NeighDel(neigh); err != nil && err != syscall.ENOENT {
+			return fmt.Errorf("failed to delete neighbor %v on %q: %v", e.ip, link.Attrs().Name, err)
+		}
+		if err := netlink.NeighSet(neigh); err != nil {
+			return fmt.Errorf("failed to add neighbor %v lladdr %v on %q: %v", e.ip, e.mac, link.Attrs().Name, err)
+		}
+	}
+	return nil
+}
+
+// checkEntries describes each entry that is not a permanent one of link
+// with its MAC
+func checkEntries(link netlink.Link, entries []entry) ([]string, error) {
+	var problems []string
+	for _, e := range entries {
+		neighs, err := netlink.NeighList(link.Attrs().Index, family(e.ip))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the neighbors of %q: %v", link.Attrs().Name, err)
+		}
+
+		var found *netlink.Neigh
+		for i := range neighs {
+			if neighs[i].IP.Equal(e.ip) {
+				found = &neighs[i]
+				break
+			}
+		}
+		switch {
+		case found == nil:
+			problems = append(problems, fmt.Sprintf("%q has no neighbor %v", link.Attrs().Name, e.ip))
+		case found.State&netlink.NUD_PERMANENT == 0:
+			problems = append(problems, fmt.Sprintf("neighbor %v of %q is not permanent", e.ip, link.Attrs().Name))
+		case found.HardwareAddr.String() != e.mac.String():
+			problems = append(problems, fmt.Sprintf("neighbor %v of %q is %v, not %v", e.ip, link.Attrs().Name, found.HardwareAddr, e.mac))
+		}
+	}
+	return problems, nil
+}
+
+// containerLink returns the container interface: the one named in the
+// result, or CNI_IFNAME. It must be called in the container namespace.
+func containerLink(n *NetConf, args *skel.CmdArgs) (netlink.Link, error) {
+	ifName := args.IfName
+	if name := n.PrevResult.SandboxInterface(); name != "" {
+		ifName = name
+	}
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+	return link, nil
+}
+
+// hostVeth returns the host end of the veth of the container interface
+func hostVeth(link netlink.Link) (netlink.Link, error) {
+	index, err := ip.VethPeerIndex(link)
+	if err != nil {
+		return nil, err
+	}
+	host, err := netlink.LinkByIndex(index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup the host end of %q: %v", link.Attrs().Name, err)
+	}
+	return host, nil
+}
+
+// apply runs f on the container interface in its namespace, and on the
+// entries of the host end of its veth if n asks for them
+func apply(n *NetConf, args *skel.CmdArgs, f func(link netlink.Link, entries []entry) error) error {
+	entries, err := containerEntries(n)
+	if err != nil {
+		return err
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+	}
+	defer netns.Close()
+
+	var link netlink.Link
+	err = netns.Do(func(_ ns.NetNS) error {
+		var err error
+		if link, err = containerLink(n, args); err != nil {
+			return err
+		}
+		return f(link, entries)
+	})
+	if err != nil || !n.HostVeth {
+		return err
+	}
+
+	host, err := hostVeth(link)
+	if err != nil {
+		return err
+	}
+	return f(host, hostEntries(n, link.Attrs().HardwareAddr))
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	if err := apply(n, args, setEntries); err != nil {
+		return err
+	}
+	return current.PrintResult(n.PrevResult, n.CNIVersion)
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	err = apply(n, args, func(link netlink.Link, entries []entry) error {
+		p, err := checkEntries(link, entries)
+		problems = append(problems, p...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("neighbors are not set up: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	// The entries go away with the interfaces they are on.
+	return nil
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.PluginFuncs{Add: cmdAdd, Check: cmdCheck, Del: cmdDel})
+}
@@ -0,0 +1,189 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/testutils"
+
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("neighbor", func() {
+	const conf = `{
+		"name": "mynet",
+		"type": "neighbor",
+		"neighbors": [{"ip": "10.1.2.254", "mac": "02:00:00:00:00:fe"}],
+		"gatewayMac": "02:00:00:00:00:01",
+		"hostVeth": true,
+		"prevResult": {"ip4": {"ip": "10.1.2.3/24", "gateway": "10.1.2.1"}}
+	}`
+
+	var (
+		originalNS, targetNS ns.NetNS
+		containerMac         net.HardwareAddr
+	)
+
+	BeforeEach(func() {
+		var err error
+		originalNS, err = ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		targetNS, err = ns.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth0"}, PeerName: "eth0"}
+			Expect(netlink.LinkAdd(veth)).To(Succeed())
+			peer, err := netlink.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+			containerMac = peer.Attrs().HardwareAddr
+			Expect(netlink.LinkSetNsFd(peer, int(targetNS.Fd()))).To(Succeed())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(targetNS.Close()).To(Succeed())
+		Expect(originalNS.Close()).To(Succeed())
+	})
+
+	args := func(stdin string) *skel.CmdArgs {
+		return &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNS.Path(),
+			IfName:      "eth0",
+			StdinData:   []byte(stdin),
+		}
+	}
+
+	add := func(stdin string) error {
+		return originalNS.Do(func(ns.NetNS) error {
+			_, err := testutils.CmdAddWithResult(targetNS.Path(), "eth0", func() error {
+				return cmdAdd(args(stdin))
+			})
+			return err
+		})
+	}
+
+	check := func(stdin string) error {
+		return originalNS.Do(func(ns.NetNS) error {
+			return testutils.CmdCheckWithResult(targetNS.Path(), "eth0", func() error {
+				return cmdCheck(args(stdin))
+			})
+		})
+	}
+
+	// neighbor returns the entry of ip on the link, if there is one
+	neighbor := func(netns ns.NetNS, linkName string, ip string) *netlink.Neigh {
+		var found *netlink.Neigh
+		err := netns.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			link, err := netlink.LinkByName(linkName)
+			Expect(err).NotTo(HaveOccurred())
+			neighs, err := netlink.NeighList(link.Attrs().Index, netlink.FAMILY_ALL)
+			Expect(err).NotTo(HaveOccurred())
+			for i := range neighs {
+				if neighs[i].IP.Equal(net.ParseIP(ip)) {
+					found = &neighs[i]
+				}
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return found
+	}
+
+	It("pins the neighbors of the container and its addresses on the host veth", func() {
+		Expect(add(conf)).To(Succeed())
+
+		for ip, mac := range map[string]string{
+			"10.1.2.254": "02:00:00:00:00:fe",
+			"10.1.2.1":   "02:00:00:00:00:01",
+		} {
+			n := neighbor(targetNS, "eth0", ip)
+			Expect(n).NotTo(BeNil(), ip)
+			Expect(n.State).To(Equal(netlink.NUD_PERMANENT))
+			Expect(n.HardwareAddr.String()).To(Equal(mac))
+		}
+
+		n := neighbor(originalNS, "veth0", "10.1.2.3")
+		Expect(n).NotTo(BeNil())
+		Expect(n.State).To(Equal(netlink.NUD_PERMANENT))
+		Expect(n.HardwareAddr.String()).To(Equal(containerMac.String()))
+
+		Expect(check(conf)).To(Succeed())
+	})
+
+	It("reports the entries that are no longer in place on CHECK", func() {
+		Expect(add(conf)).To(Succeed())
+
+		err := targetNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			link, err := netlink.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.NeighDel(&netlink.Neigh{
+				LinkIndex: link.Attrs().Index,
+				Family:    netlink.FAMILY_V4,
+				IP:        net.ParseIP("10.1.2.254"),
+			})).To(Succeed())
+			hwaddr, _ := net.ParseMAC("02:00:00:00:00:02")
+			Expect(setEntries(link, []entry{{ip: net.ParseIP("10.1.2.1"), mac: hwaddr}})).To(Succeed())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(check(conf)).To(MatchError(`neighbors are not set up: "eth0" has no neighbor 10.1.2.254; neighbor 10.1.2.1 of "eth0" is 02:00:00:00:00:02, not 02:00:00:00:00:01`))
+	})
+
+	It("leaves the host veth alone unless asked", func() {
+		Expect(add(`{
+			"name": "mynet",
+			"type": "neighbor",
+			"gatewayMac": "02:00:00:00:00:01",
+			"prevResult": {"ip4": {"ip": "10.1.2.3/24", "gateway": "10.1.2.1"}}
+		}`)).To(Succeed())
+
+		Expect(neighbor(targetNS, "eth0", "10.1.2.1")).NotTo(BeNil())
+		Expect(neighbor(originalNS, "veth0", "10.1.2.3")).To(BeNil())
+	})
+
+	It("validates the entries", func() {
+		n, err := loadConf([]byte(`{"name": "mynet", "type": "neighbor", "neighbors": [{"ip": "10.1.2.254", "mac": "nope"}], "prevResult": {}}`))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = containerEntries(n)
+		Expect(err).To(MatchError(`neighbor 0: invalid mac "nope"`))
+
+		n, err = loadConf([]byte(`{"name": "mynet", "type": "neighbor", "gatewayMac": "02:00:00:00:00:01", "prevResult": {"ip4": {"ip": "10.1.2.3/24"}}}`))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = containerEntries(n)
+		Expect(err).To(MatchError("gatewayMac is set but the result has no gateway"))
+	})
+
+	It("requires a previous result", func() {
+		_, err := loadConf([]byte(`{"name": "mynet", "type": "neighbor"}`))
+		Expect(err).To(MatchError("required prevResult missing"))
+	})
+})
@@ -0,0 +1,289 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This is a "meta-plugin" for failure injection. Chained after the plugin
+// attaching the container with a veth, it adds netem impairments --
+// latency, jitter, loss and reordering -- to the host end of the veth, so
+// that chaos-engineering tools can degrade the network of a container
+// through the CNI chain of its runtime.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/ip"
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/vishvananda/netlink"
+)
+
+// defaultLimit is the queue length of netem, in packets, as tc sets it
+const defaultLimit = 1000
+
+type NetConf struct {
+	types.NetConf
+	// PrevResult is the result of the plugin creating the container
+	// interface, in any version
+	PrevResult *current.Result `json:"-"`
+	Impairment
+	RuntimeConfig struct {
+		// Netem, if given, replaces the impairment of the configuration
+		Netem *Impairment `json:"netem,omitempty"`
+	} `json:"runtimeConfig,omitempty"`
+}
+
+// Impairment is what netem does to the packets to the container
+type Impairment struct {
+	// Latency is added to every packet, in milliseconds
+	Latency uint32 `json:"latency,omitempty"`
+	// Jitter varies the latency of each packet by up to as many
+	// milliseconds
+	Jitter uint32 `json:"jitter,omitempty"`
+	// Loss is the percentage of packets dropped
+	Loss float64 `json:"loss,omitempty"`
+	// Reorder is the percentage of packets sent without the latency,
+	// ahead of the ones before them
+	Reorder float64 `json:"reorder,omitempty"`
+	// Correlation is the percentage by which the latency, loss and
+	// reordering of a packet depend on those of the one before it
+	Correlation float64 `json:"correlation,omitempty"`
+	// Limit is the number of packets netem holds back at most
+	Limit uint32 `json:"limit,omitempty"`
+}
+
+func init() {
+	// this ensures that main runs only on main thread (thread group leader).
+	// since namespace ops (unshare, setns) are done for a single thread, we
+	// must ensure that the goroutine does not jump from OS thread to thread
+	runtime.LockOSThread()
+}
+
+func loadConf(bytes []byte) (*NetConf, error) {
+	n := &NetConf{}
+	if err := json.Unmarshal(bytes, n); err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %v", err)
+	}
+	prev, err := current.ParsePrevResult(bytes)
+	if err != nil {
+		return nil, err
+	}
+	n.PrevResult = prev
+	if n.PrevResult == nil {
+		return nil, errors.New("required prevResult missing")
+	}
+	return n, nil
+}
+
+// impairment returns the impairment of n: that of the runtime if it
+// passed one, else that of the configuration
+func (n *NetConf) impairment() Impairment {
+	if n.RuntimeConfig.Netem != nil {
+		return *n.RuntimeConfig.Netem
+	}
+	return n.Impairment
+}
+
+// none tells whether i leaves the packets alone
+func (i Impairment) none() bool {
+	return i.Latency == 0 && i.Jitter == 0 && i.Loss == 0 && i.Reorder == 0
+}
+
+// probability converts a percentage to the fraction of 2^32 netem takes
+func probability(name string, percent float64) (uint32, error) {
+	if percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("%s %v must be between 0 and 100", name, percent)
+	}
+	return uint32(percent / 100 * math.MaxUint32), nil
+}
+
+// ticks converts milliseconds to the scheduler ticks netem takes
+func ticks(name string, ms uint32) (uint32, error) {
+	t := float64(ms) * 1000 * netlink.TickInUsec()
+	if t > math.MaxUint32 {
+		return 0, fmt.Errorf("%s %dms is too large", name, ms)
+	}
+	return uint32(t), nil
+}
+
+// opts returns the parameters of the netem qdisc doing i
+func (i Impairment) opts() (*netemOpts, error) {
+	o := &netemOpts{limit: i.Limit}
+	if o.limit == 0 {
+		o.limit = defaultLimit
+	}
+
+	var err error
+	if o.latency, err = ticks("latency", i.Latency); err != nil {
+		return nil, err
+	}
+	if o.jitter, err = ticks("jitter", i.Jitter); err != nil {
+		return nil, err
+	}
+	if o.loss, err = probability("loss", i.Loss); err != nil {
+		return nil, err
+	}
+	if o.reorder, err = probability("reorder", i.Reorder); err != nil {
+		return nil, err
+	}
+	corr, err := probability("correlation", i.Correlation)
+	if err != nil {
+		return nil, err
+	}
+	o.delayCorr, o.lossCorr = corr, corr
+
+	if o.reorder != 0 {
+		// packets can only overtake ones that are held back
+		if i.Latency == 0 {
+			return nil, errors.New("reorder requires a latency")
+		}
+		// as tc does, reorder any packet rather than every n-th
+		o.gap = 1
+		o.reorderCorr = corr
+	}
+	return o, nil
+}
+
+// hostVeth returns the host end of the veth of the container interface:
+// the one named in the result, or CNI_IFNAME
+func hostVeth(n *NetConf, args *skel.CmdArgs) (netlink.Link, error) {
+	ifName := args.IfName
+	if n.PrevResult != nil {
+		if name := n.PrevResult.SandboxInterface(); name != "" {
+			ifName = name
+		}
+	}
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+	}
+	defer netns.Close()
+
+	var hostVethIndex int
+	err = netns.Do(func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+		}
+		hostVethIndex, err = ip.VethPeerIndex(link)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := netlink.LinkByIndex(hostVethIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup the host end of %q: %v", ifName, err)
+	}
+	return link, nil
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	i := n.impairment()
+	opts, err := i.opts()
+	if err != nil {
+		return err
+	}
+
+	veth, err := hostVeth(n, args)
+	if err != nil {
+		return err
+	}
+	if i.none() {
+		// e.g. the runtime lifted the impairment of the configuration
+		// on a repeated ADD
+		if err := removeNetem(veth); err != nil {
+			return err
+		}
+	} else if err := replaceNetem(veth, opts); err != nil {
+		return err
+	}
+
+	return current.PrintResult(n.PrevResult, n.CNIVersion)
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	i := n.impairment()
+	want, err := i.opts()
+	if err != nil {
+		return err
+	}
+
+	veth, err := hostVeth(n, args)
+	if err != nil {
+		return err
+	}
+	name := veth.Attrs().Name
+	q, err := findNetem(veth)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case q == nil && i.none():
+		return nil
+	case q == nil:
+		return fmt.Errorf("%q has no netem qdisc", name)
+	case i.none():
+		return fmt.Errorf("%q has a netem qdisc, but the configuration impairs nothing", name)
+	}
+	if differ := q.opts.differ(want); len(differ) > 0 {
+		return fmt.Errorf("netem qdisc of %q does not match the configuration: %s differ", name, strings.Join(differ, ", "))
+	}
+	return nil
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	// a runtime without a cache passes no prevResult to DEL
+	n := &NetConf{}
+	if err := json.Unmarshal(args.StdinData, n); err != nil {
+		return fmt.Errorf("failed to load netconf: %v", err)
+	}
+	prev, err := current.ParsePrevResult(args.StdinData)
+	if err != nil {
+		return err
+	}
+	n.PrevResult = prev
+
+	// The qdisc goes away with the veth, so there is nothing to do if
+	// the container or its veth is gone already.
+	if args.Netns == "" {
+		return nil
+	}
+	veth, err := hostVeth(n, args)
+	if err != nil {
+		return nil
+	}
+	return removeNetem(veth)
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.PluginFuncs{Add: cmdAdd, Check: cmdCheck, Del: cmdDel})
+}
@@ -0,0 +1,209 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/testutils"
+
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("netem", func() {
+	const conf = `{
+		"name": "mynet",
+		"type": "netem",
+		"latency": 100,
+		"jitter": 10,
+		"loss": 1.5,
+		"prevResult": {"ip4": {"ip": "10.1.2.3/24", "gateway": "10.1.2.1"}}
+	}`
+
+	var env *testutils.Env
+
+	BeforeEach(func() {
+		var err error
+		env, err = testutils.NewEnv()
+		Expect(err).NotTo(HaveOccurred())
+
+		err = env.HostNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth0"}, PeerName: "eth0"}
+			Expect(netlink.LinkAdd(veth)).To(Succeed())
+			peer, err := netlink.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetNsFd(peer, int(env.ContainerNS.Fd()))).To(Succeed())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(env.Close()).To(Succeed())
+	})
+
+	args := func(stdin string) *skel.CmdArgs {
+		return &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       env.ContainerNS.Path(),
+			IfName:      "eth0",
+			StdinData:   []byte(stdin),
+		}
+	}
+
+	add := func(stdin string) error {
+		return env.HostNS.Do(func(ns.NetNS) error {
+			_, err := testutils.CmdAddWithResult(env.ContainerNS.Path(), "eth0", func() error {
+				return cmdAdd(args(stdin))
+			})
+			return err
+		})
+	}
+
+	check := func(stdin string) error {
+		return env.HostNS.Do(func(ns.NetNS) error {
+			return testutils.CmdCheckWithResult(env.ContainerNS.Path(), "eth0", func() error {
+				return cmdCheck(args(stdin))
+			})
+		})
+	}
+
+	del := func(stdin string) error {
+		return env.HostNS.Do(func(ns.NetNS) error {
+			return testutils.CmdDelWithResult(env.ContainerNS.Path(), "eth0", func() error {
+				return cmdDel(args(stdin))
+			})
+		})
+	}
+
+	// rootNetem returns the netem qdisc of the host veth, if it has one
+	rootNetem := func() *netemQdisc {
+		var q *netemQdisc
+		err := env.HostNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			link, err := netlink.LinkByName("veth0")
+			Expect(err).NotTo(HaveOccurred())
+			q, err = findNetem(link)
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return q
+	}
+
+	It("impairs the host veth on ADD and removes the impairment on DEL", func() {
+		Expect(add(conf)).To(Succeed())
+
+		q := rootNetem()
+		Expect(q).NotTo(BeNil())
+		n, err := loadConf([]byte(conf))
+		Expect(err).NotTo(HaveOccurred())
+		want, err := n.impairment().opts()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(q.opts.differ(want)).To(BeEmpty())
+		Expect(check(conf)).To(Succeed())
+
+		Expect(del(conf)).To(Succeed())
+		Expect(rootNetem()).To(BeNil())
+		Expect(check(conf)).To(MatchError(`"veth0" has no netem qdisc`))
+	})
+
+	It("reports an impairment other than the configured one on CHECK", func() {
+		Expect(add(conf)).To(Succeed())
+
+		Expect(check(`{
+			"name": "mynet",
+			"type": "netem",
+			"latency": 100,
+			"jitter": 10,
+			"loss": 5,
+			"prevResult": {"ip4": {"ip": "10.1.2.3/24", "gateway": "10.1.2.1"}}
+		}`)).To(MatchError(`netem qdisc of "veth0" does not match the configuration: loss differ`))
+	})
+
+	It("takes the impairment of the runtime in place of the configured one", func() {
+		n, err := loadConf([]byte(`{
+			"name": "mynet",
+			"type": "netem",
+			"latency": 100,
+			"runtimeConfig": {"netem": {"loss": 20}},
+			"prevResult": {}
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n.impairment()).To(Equal(Impairment{Loss: 20}))
+
+		n, err = loadConf([]byte(`{"name": "mynet", "type": "netem", "runtimeConfig": {"netem": {}}, "latency": 100, "prevResult": {}}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n.impairment().none()).To(BeTrue())
+	})
+
+	It("leaves the host veth alone when there is nothing to impair", func() {
+		Expect(add(`{
+			"name": "mynet",
+			"type": "netem",
+			"prevResult": {"ip4": {"ip": "10.1.2.3/24", "gateway": "10.1.2.1"}}
+		}`)).To(Succeed())
+		Expect(rootNetem()).To(BeNil())
+	})
+
+	It("succeeds on DEL once the container is gone", func() {
+		Expect(cmdDel(&skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       "/var/run/netns/gone",
+			IfName:      "eth0",
+			StdinData:   []byte(`{"name": "mynet", "type": "netem", "latency": 100}`),
+		})).To(Succeed())
+	})
+
+	It("converts the impairment to the parameters of netem", func() {
+		o, err := Impairment{Latency: 100, Jitter: 10, Loss: 50, Reorder: 25, Correlation: 100}.opts()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(o.latency).To(Equal(uint32(100 * 1000 * netlink.TickInUsec())))
+		Expect(o.jitter).To(Equal(uint32(10 * 1000 * netlink.TickInUsec())))
+		Expect(o.loss).To(Equal(uint32(math.MaxUint32 / 2)))
+		Expect(o.reorder).To(Equal(uint32(math.MaxUint32 / 4)))
+		Expect(o.gap).To(Equal(uint32(1)))
+		Expect(o.delayCorr).To(Equal(uint32(math.MaxUint32)))
+		Expect(o.limit).To(Equal(uint32(defaultLimit)))
+
+		parsed, err := parseNetemOpts(o.serialize())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parsed).To(Equal(o))
+	})
+
+	It("validates the impairment", func() {
+		_, err := Impairment{Loss: 101}.opts()
+		Expect(err).To(MatchError("loss 101 must be between 0 and 100"))
+
+		_, err = Impairment{Reorder: 10}.opts()
+		Expect(err).To(MatchError("reorder requires a latency"))
+
+		_, err = Impairment{Latency: math.MaxUint32}.opts()
+		Expect(err).To(MatchError("latency 4294967295ms is too large"))
+	})
+
+	It("requires a previous result", func() {
+		_, err := loadConf([]byte(`{"name": "mynet", "type": "netem"}`))
+		Expect(err).To(MatchError("required prevResult missing"))
+	})
+})
@@ -0,0 +1,223 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// The netlink library knows no netem, so its qdisc is set up here. See
+// include/uapi/linux/pkt_sched.h of the kernel.
+const (
+	tcaNetemCorr    = 1
+	tcaNetemReorder = 3
+
+	sizeofNetemQopt    = 24
+	sizeofNetemCorr    = 12
+	sizeofNetemReorder = 8
+)
+
+// netemOpts are the parameters of a netem qdisc, as the kernel takes
+// them: times in scheduler ticks, probabilities in fractions of 2^32
+type netemOpts struct {
+	latency   uint32
+	limit     uint32
+	loss      uint32
+	gap       uint32
+	duplicate uint32
+	jitter    uint32
+
+	delayCorr uint32
+	lossCorr  uint32
+	dupCorr   uint32
+
+	reorder     uint32
+	reorderCorr uint32
+}
+
+// serialize returns o as the TCA_OPTIONS of netem: a struct tc_netem_qopt
+// followed by the attributes with the correlations and reordering
+func (o *netemOpts) serialize() []byte {
+	native := nl.NativeEndian()
+	qopt := make([]byte, sizeofNetemQopt)
+	for i, v := range []uint32{o.latency, o.limit, o.loss, o.gap, o.duplicate, o.jitter} {
+		native.PutUint32(qopt[4*i:], v)
+	}
+	corr := make([]byte, sizeofNetemCorr)
+	for i, v := range []uint32{o.delayCorr, o.lossCorr, o.dupCorr} {
+		native.PutUint32(corr[4*i:], v)
+	}
+	reorder := make([]byte, sizeofNetemReorder)
+	native.PutUint32(reorder[0:], o.reorder)
+	native.PutUint32(reorder[4:], o.reorderCorr)
+
+	b := append(qopt, nl.NewRtAttr(tcaNetemCorr, corr).Serialize()...)
+	return append(b, nl.NewRtAttr(tcaNetemReorder, reorder).Serialize()...)
+}
+
+// parseNetemOpts is the reverse of serialize
+func parseNetemOpts(b []byte) (*netemOpts, error) {
+	if len(b) < sizeofNetemQopt {
+		return nil, fmt.Errorf("netem options of %d bytes are too short", len(b))
+	}
+	native := nl.NativeEndian()
+	u32 := func(b []byte, i int) uint32 { return native.Uint32(b[4*i:]) }
+
+	o := &netemOpts{
+		latency:   u32(b, 0),
+		limit:     u32(b, 1),
+		loss:      u32(b, 2),
+		gap:       u32(b, 3),
+		duplicate: u32(b, 4),
+		jitter:    u32(b, 5),
+	}
+	attrs, err := nl.ParseRouteAttr(b[sizeofNetemQopt:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse netem options: %v", err)
+	}
+	for _, attr := range attrs {
+		switch {
+		case attr.Attr.Type == tcaNetemCorr && len(attr.Value) >= sizeofNetemCorr:
+			o.delayCorr, o.lossCorr, o.dupCorr = u32(attr.Value, 0), u32(attr.Value, 1), u32(attr.Value, 2)
+		case attr.Attr.Type == tcaNetemReorder && len(attr.Value) >= sizeofNetemReorder:
+			o.reorder, o.reorderCorr = u32(attr.Value, 0), u32(attr.Value, 1)
+		}
+	}
+	return o, nil
+}
+
+// differ returns the names of the parameters of o that are not those of
+// want
+func (o *netemOpts) differ(want *netemOpts) []string {
+	var names []string
+	for _, p := range []struct {
+		name       string
+		want, have uint32
+	}{
+		{"latency", want.latency, o.latency},
+		{"jitter", want.jitter, o.jitter},
+		{"loss", want.loss, o.loss},
+		{"reorder", want.reorder, o.reorder},
+		{"gap", want.gap, o.gap},
+		{"duplicate", want.duplicate, o.duplicate},
+		{"limit", want.limit, o.limit},
+		{"latency correlation", want.delayCorr, o.delayCorr},
+		{"loss correlation", want.lossCorr, o.lossCorr},
+		{"reorder correlation", want.reorderCorr, o.reorderCorr},
+	} {
+		if p.have != p.want {
+			names = append(names, p.name)
+		}
+	}
+	return names
+}
+
+// netemQdisc is the root netem qdisc of a link
+type netemQdisc struct {
+	handle uint32
+	opts   *netemOpts
+}
+
+// replaceNetem makes a netem qdisc doing o the root qdisc of link, in
+// place of whatever was there.
+// Equivalent to: `tc qdisc replace dev $link root netem ...`
+func replaceNetem(link netlink.Link, o *netemOpts) error {
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWQDISC, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE|syscall.NLM_F_ACK)
+	req.AddData(&nl.TcMsg{
+		Family:  nl.FAMILY_ALL,
+		Ifindex: int32(link.Attrs().Index),
+		Parent:  netlink.HANDLE_ROOT,
+	})
+	req.AddData(nl.NewRtAttr(nl.TCA_KIND, nl.ZeroTerminated("netem")))
+	req.AddData(nl.NewRtAttr(nl.TCA_OPTIONS, o.serialize()))
+
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	if err == syscall.ENOENT {
+		// the kernel knows no qdisc of that kind
+		return fmt.Errorf("netem is not available, load the sch_netem module: %v", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add netem qdisc to %q: %v", link.Attrs().Name, err)
+	}
+	return nil
+}
+
+// findNetem returns the root qdisc of link if it is netem, else nil
+func findNetem(link netlink.Link) (*netemQdisc, error) {
+	index := int32(link.Attrs().Index)
+	req := nl.NewNetlinkRequest(syscall.RTM_GETQDISC, syscall.NLM_F_DUMP)
+	req.AddData(&nl.TcMsg{Family: nl.FAMILY_ALL, Ifindex: index})
+
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWQDISC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list qdiscs of %q: %v", link.Attrs().Name, err)
+	}
+	for _, m := range msgs {
+		msg := nl.DeserializeTcMsg(m)
+		// the dump is of every link
+		if msg.Ifindex != index || msg.Parent != netlink.HANDLE_ROOT {
+			continue
+		}
+		attrs, err := nl.ParseRouteAttr(m[msg.Len():])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse qdisc of %q: %v", link.Attrs().Name, err)
+		}
+
+		var kind string
+		var options []byte
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case nl.TCA_KIND:
+				kind = string(attr.Value[:len(attr.Value)-1])
+			case nl.TCA_OPTIONS:
+				options = attr.Value
+			}
+		}
+		if kind != "netem" {
+			return nil, nil
+		}
+		o, err := parseNetemOpts(options)
+		if err != nil {
+			return nil, err
+		}
+		return &netemQdisc{handle: msg.Handle, opts: o}, nil
+	}
+	return nil, nil
+}
+
+// removeNetem removes the root qdisc of link if it is netem, which gives
+// the link its default qdisc back
+func removeNetem(link netlink.Link) error {
+	q, err := findNetem(link)
+	if err != nil || q == nil {
+		return err
+	}
+	err = netlink.QdiscDel(&netlink.GenericQdisc{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    q.handle,
+			Parent:    netlink.HANDLE_ROOT,
+		},
+		QdiscType: "netem",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove netem qdisc of %q: %v", link.Attrs().Name, err)
+	}
+	return nil
+}
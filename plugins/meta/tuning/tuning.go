@@ -13,26 +13,36 @@
 // limitations under the License.
 
 // This is a "meta-plugin". It reads in its own netconf, it does not create
-// any network interface but just changes the network sysctl.
+// any network interface but just changes the network sysctl and the
+// attributes of the container interface.
 
 package main
 
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"path/filepath"
+	"net"
 	"strings"
 
+	"github.com/containernetworking/cni/pkg/ip"
 	"github.com/containernetworking/cni/pkg/ns"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/cni/pkg/utils/sysctl"
 )
 
 // TuningConf represents the network tuning configuration.
 type TuningConf struct {
 	types.NetConf
 	SysCtl map[string]string `json:"sysctl"`
+
+	// MTU, Mac, TxQLen and Alias are set on the container interface
+	// when present
+	MTU    int     `json:"mtu,omitempty"`
+	Mac    string  `json:"mac,omitempty"`
+	TxQLen *int    `json:"txQLen,omitempty"`
+	Alias  *string `json:"alias,omitempty"`
 }
 
 func cmdAdd(args *skel.CmdArgs) error {
@@ -41,33 +51,48 @@ func cmdAdd(args *skel.CmdArgs) error {
 		return fmt.Errorf("failed to load netconf: %v", err)
 	}
 
+	props := ip.LinkProperties{
+		MTU:    tuningConf.MTU,
+		TxQLen: tuningConf.TxQLen,
+		Alias:  tuningConf.Alias,
+	}
+	if tuningConf.Mac != "" {
+		mac, err := net.ParseMAC(tuningConf.Mac)
+		if err != nil {
+			return fmt.Errorf("invalid mac %q: %v", tuningConf.Mac, err)
+		}
+		props.HardwareAddr = mac
+	}
+
 	// The directory /proc/sys/net is per network namespace. Enter in the
 	// network namespace before writing on it.
 
 	err := ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
 		for key, value := range tuningConf.SysCtl {
-			fileName := filepath.Join("/proc/sys", strings.Replace(key, ".", "/", -1))
-			fileName = filepath.Clean(fileName)
+			fileName, err := sysctl.Path(key)
+			if err != nil {
+				return err
+			}
 
 			// Refuse to modify sysctl parameters that don't belong
 			// to the network subsystem.
 			if !strings.HasPrefix(fileName, "/proc/sys/net/") {
 				return fmt.Errorf("invalid net sysctl key: %q", key)
 			}
-			content := []byte(value)
-			err := ioutil.WriteFile(fileName, content, 0644)
-			if err != nil {
+			if _, err := sysctl.Sysctl(key, value); err != nil {
 				return err
 			}
 		}
-		return nil
+		if tuningConf.MTU == 0 && props.HardwareAddr == nil && props.TxQLen == nil && props.Alias == nil {
+			return nil
+		}
+		return ip.SetLinkProperties(args.IfName, props)
 	})
 	if err != nil {
 		return err
 	}
 
-	result := types.Result{}
-	return result.Print()
+	return current.PrintResult(&current.Result{}, tuningConf.CNIVersion)
 }
 
 func cmdDel(args *skel.CmdArgs) error {
@@ -0,0 +1,130 @@
+// Copyright 2017 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This is a test plugin that touches no network device. It records each
+// invocation to a debug file and replies with the result or error it is
+// configured with, so that runtimes and libcni can be tested against it.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/cni/plugins/test/noop/debug"
+)
+
+type NetConf struct {
+	types.NetConf
+	// DebugFile, if set, gets a debug.Record appended for every ADD and DEL
+	DebugFile string `json:"debugFile"`
+	// Result is printed on ADD. When it is unset the prevResult is
+	// passed through, or an empty result printed if there is none.
+	Result     json.RawMessage `json:"result,omitempty"`
+	PrevResult json.RawMessage `json:"prevResult,omitempty"`
+	// Error is reported instead of succeeding by the commands listed
+	// in FailOn, or by all of them if FailOn is empty
+	Error  *types.Error `json:"error,omitempty"`
+	FailOn []string     `json:"failOn,omitempty"`
+}
+
+func loadConf(command string, args *skel.CmdArgs) (*NetConf, error) {
+	n := &NetConf{}
+	if err := json.Unmarshal(args.StdinData, n); err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %v", err)
+	}
+
+	if n.DebugFile != "" {
+		err := debug.Append(n.DebugFile, &debug.Record{
+			Command:     command,
+			ContainerID: args.ContainerID,
+			Netns:       args.Netns,
+			IfName:      args.IfName,
+			Args:        args.Args,
+			Path:        args.Path,
+			Stdin:       string(args.StdinData),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to write debug file: %v", err)
+		}
+	}
+
+	return n, nil
+}
+
+func (n *NetConf) failsOn(command string) bool {
+	if n.Error == nil {
+		return false
+	}
+	if len(n.FailOn) == 0 {
+		return true
+	}
+	for _, c := range n.FailOn {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	n, err := loadConf("ADD", args)
+	if err != nil {
+		return err
+	}
+	if n.failsOn("ADD") {
+		return n.Error
+	}
+
+	result := n.Result
+	if result == nil {
+		result = n.PrevResult
+	}
+	if result == nil {
+		return current.PrintResult(&current.Result{}, n.CNIVersion)
+	}
+	_, err = os.Stdout.Write(result)
+	return err
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	n, err := loadConf("DEL", args)
+	if err != nil {
+		return err
+	}
+	if n.failsOn("DEL") {
+		return n.Error
+	}
+	return nil
+}
+
+// about is what the plugin reports on ABOUT
+var about = &types.About{
+	Name:        "noop",
+	Description: "records its invocations and replies as configured, for tests",
+	Config: map[string]types.ConfigField{
+		"debugFile": {Type: "string", Description: "file every ADD and DEL is recorded in"},
+		"result":    {Type: "object", Description: "result printed on ADD, the prevResult if unset"},
+		"error":     {Type: "object", Description: "error reported instead of succeeding"},
+		"failOn":    {Type: "array", Description: "commands reporting the error, all of them if empty"},
+	},
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.PluginFuncs{Add: cmdAdd, Del: cmdDel, About: about})
+}